@@ -10,7 +10,9 @@ from sqlalchemy.exc import IntegrityError
 from sqlalchemy.orm import Session, sessionmaker
 
 from auth_models import AuthSession, Base, User
+import conversation_models  # noqa: F401  (registers conversation tables on Base.metadata)
 from config import logger
+from migrate import AUTO_MIGRATE, apply_migrations, pending
 
 DATABASE_URL = os.getenv("DWANI_DATABASE_URL", "sqlite:///./talk_auth.db").strip()
 AUTH_SESSION_TTL_SECONDS = int(os.getenv("DWANI_AUTH_SESSION_TTL_SECONDS", os.getenv("DWANI_SESSION_TTL_SECONDS", "86400")))
@@ -30,7 +32,18 @@ SessionLocal = sessionmaker(bind=ENGINE, autocommit=False, autoflush=False, expi
 
 
 def init_auth_db() -> None:
-    Base.metadata.create_all(bind=ENGINE)
+    """Apply schema migrations (see migrate.py); unknown dialects fall back to create_all()."""
+    if not AUTO_MIGRATE:
+        return
+    try:
+        applied = apply_migrations(ENGINE)
+    except Exception:
+        # SQLite has no advisory lock: another worker may have applied the same migration first.
+        if pending(ENGINE):
+            raise
+        return
+    if not applied:
+        Base.metadata.create_all(bind=ENGINE)
 
 
 @contextmanager
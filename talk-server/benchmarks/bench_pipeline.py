@@ -0,0 +1,126 @@
+"""Benchmark server-side overhead of /v1/speech_to_speech against in-process fake upstreams.
+
+Measures wall time and peak Python allocations per request for several upload sizes,
+so regressions in the proxy layer (multipart handling, base64 streaming, TTS relay)
+show up without real ASR/LLM/TTS backends.
+
+    cd talk-server && python -m benchmarks.bench_pipeline --iterations 20
+"""
+import argparse
+import io
+import os
+import statistics
+import sys
+import time
+import tracemalloc
+
+sys.path.insert(0, os.path.dirname(os.path.dirname(os.path.abspath(__file__))))
+
+os.environ.setdefault("DWANI_ENABLE_METRICS", "0")
+os.environ.setdefault("DWANI_LOG_FORMAT", "plain")
+os.environ.setdefault("DWANI_API_BASE_URL_TTS", "http://tts.invalid")
+
+from fastapi.testclient import TestClient  # noqa: E402
+
+import main  # noqa: E402
+from services import pipeline  # noqa: E402
+
+_TTS_AUDIO_BYTES = 256 * 1024
+_TTS_CHUNK_BYTES = 16 * 1024
+
+
+class _FakeAsrResponse:
+    status_code = 200
+    text = ""
+
+    def json(self):
+        return {"choices": [{"message": {"content": "ನಮಸ್ಕಾರ"}}]}
+
+
+class _FakeTtsResponse:
+    status_code = 200
+    headers = {"Content-Type": "audio/mpeg"}
+
+    def raise_for_status(self):
+        pass
+
+    async def aiter_bytes(self):
+        chunk = b"\xff" * _TTS_CHUNK_BYTES
+        for _ in range(_TTS_AUDIO_BYTES // _TTS_CHUNK_BYTES):
+            yield chunk
+
+    async def aclose(self):
+        pass
+
+
+class _FakeUpstreamClient:
+    """Stands in for the shared upstream client; the test client itself stays real."""
+
+    async def post(self, url, headers=None, content=None, json=None, timeout=None):
+        if content is not None:
+            async for _ in content:
+                pass
+        return _FakeAsrResponse()
+
+    def build_request(self, method, url, **kwargs):
+        return None
+
+    async def send(self, request, stream=False):
+        return _FakeTtsResponse()
+
+
+async def _fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None):
+    return "ಹೇಗಿದ್ದೀರಿ?"
+
+
+def _install_fakes() -> None:
+    from services import transcribe, tts
+
+    upstream = _FakeUpstreamClient()
+    transcribe.http_client = lambda: upstream
+    tts.http_client = lambda: upstream
+    pipeline.call_llm = _fake_call_llm
+
+
+def _run(client: TestClient, payload: bytes, iterations: int):
+    durations = []
+    peaks = []
+    for _ in range(iterations):
+        tracemalloc.start()
+        start = time.perf_counter()
+        res = client.post(
+            "/v1/speech_to_speech",
+            params={"mode": "llm"},
+            files={"file": ("bench.wav", io.BytesIO(payload), "audio/wav")},
+        )
+        durations.append(time.perf_counter() - start)
+        _, peak = tracemalloc.get_traced_memory()
+        tracemalloc.stop()
+        peaks.append(peak)
+        if res.status_code != 200:
+            raise SystemExit(f"unexpected status {res.status_code}: {res.text[:200]}")
+    return durations, peaks
+
+
+def main_cli() -> None:
+    parser = argparse.ArgumentParser(description="Benchmark talk-server pipeline overhead.")
+    parser.add_argument("--iterations", type=int, default=10)
+    parser.add_argument("--sizes-kb", type=str, default="64,1024,10240", help="Comma-separated upload sizes in KiB.")
+    args = parser.parse_args()
+
+    _install_fakes()
+    client = TestClient(main.app)
+    print(f"{'upload':>10} {'p50 ms':>9} {'p95 ms':>9} {'peak alloc KiB':>15}")
+    for size_kb in (int(s) for s in args.sizes_kb.split(",") if s.strip()):
+        payload = os.urandom(size_kb * 1024)
+        durations, peaks = _run(client, payload, args.iterations)
+        durations.sort()
+        p95 = durations[min(len(durations) - 1, int(len(durations) * 0.95))]
+        print(
+            f"{size_kb:>7}KiB {statistics.median(durations) * 1000:>9.2f} {p95 * 1000:>9.2f} "
+            f"{statistics.median(peaks) / 1024:>15.1f}"
+        )
+
+
+if __name__ == "__main__":
+    main_cli()
@@ -1,5 +1,6 @@
 """Environment-derived configuration. Do not depend on other app modules."""
 import os
+import tempfile
 import logging.config
 
 
@@ -8,18 +9,555 @@ def _env_int(name: str, default: int) -> int:
     return int(v) if v else default
 
 
+# Public demo profile (see services/demo.py): sessions in memory and no stored turns or recordings,
+# smaller default upload and per-IP limits, a daily quota, watermarked TTS audio, and a rotating API key.
+DEMO_MODE = os.getenv("DWANI_DEMO_MODE", "0") == "1"
 ASR_TIMEOUT = _env_int("DWANI_ASR_TIMEOUT", 30)
 TTS_TIMEOUT = _env_int("DWANI_TTS_TIMEOUT", 30)
 LLM_TIMEOUT = _env_int("DWANI_LLM_TIMEOUT", 60)
-MAX_UPLOAD_BYTES = _env_int("DWANI_MAX_UPLOAD_BYTES", 25 * 1024 * 1024)  # 25MB
+# Overall per-request deadline in seconds (0 = none); clients may lower it with X-Request-Timeout.
+REQUEST_TIMEOUT = _env_int("DWANI_REQUEST_TIMEOUT", 0)
+MAX_UPLOAD_BYTES = _env_int("DWANI_MAX_UPLOAD_BYTES", (2 if DEMO_MODE else 25) * 1024 * 1024)  # 25MB, 2MB for demos
 MAX_RETRIES = _env_int("DWANI_MAX_RETRIES", 2)
+# Multipart uploads (see services/uploads.py): bytes of a file held in memory before it is spooled to a temporary file
+# (in DWANI_UPLOAD_SPOOL_DIR, else the system's), and the form parser's limits on files, fields and a field's bytes.
+UPLOAD_SPOOL_BYTES = _env_int("DWANI_UPLOAD_SPOOL_BYTES", 1024 * 1024)
+UPLOAD_SPOOL_DIR = os.getenv("DWANI_UPLOAD_SPOOL_DIR", "").strip() or None
+FORM_MAX_FILES = _env_int("DWANI_FORM_MAX_FILES", 1000)
+FORM_MAX_FIELDS = _env_int("DWANI_FORM_MAX_FIELDS", 1000)
+FORM_MAX_PART_BYTES = _env_int("DWANI_FORM_MAX_PART_BYTES", 1024 * 1024)
+# Audio sent to ASR must be in one of these formats by its magic bytes when they are recognized (executables,
+# archives, documents and a non-audio Content-Type are always refused); DWANI_UPLOAD_STRICT=1 refuses unrecognized
+# bytes too. DWANI_FORM_STRICT_FIELDS=1 refuses form fields an endpoint does not take, but for DWANI_FORM_EXTRA_FIELDS.
+UPLOAD_AUDIO_FORMATS = [
+    f.strip().lower() for f in os.getenv("DWANI_UPLOAD_AUDIO_FORMATS", "wav,mp3,ogg,flac,webm,mp4,aac,amr,aiff").split(",")
+    if f.strip()
+]
+UPLOAD_STRICT = os.getenv("DWANI_UPLOAD_STRICT", "0") == "1"
+FORM_STRICT_FIELDS = os.getenv("DWANI_FORM_STRICT_FIELDS", "0") == "1"
+FORM_EXTRA_FIELDS = {f.strip() for f in os.getenv("DWANI_FORM_EXTRA_FIELDS", "").split(",") if f.strip()}
+
+# Shared upstream connection pool (see services/http_client.py).
+HTTP_MAX_CONNECTIONS = _env_int("DWANI_HTTP_MAX_CONNECTIONS", 100)
+HTTP_MAX_KEEPALIVE = _env_int("DWANI_HTTP_MAX_KEEPALIVE", 20)
+HTTP_KEEPALIVE_EXPIRY = _env_int("DWANI_HTTP_KEEPALIVE_EXPIRY", 30)
+HTTP_PROXY = os.getenv("DWANI_HTTP_PROXY", "").strip() or None
+HTTP2 = os.getenv("DWANI_HTTP2", "0") == "1"
+# Optional mTLS to the ASR/LLM/TTS/agent backends: custom CA bundle and client certificate.
+BACKEND_CA_FILE = os.getenv("DWANI_BACKEND_CA_FILE", "").strip() or None
+BACKEND_CLIENT_CERT_FILE = os.getenv("DWANI_BACKEND_CLIENT_CERT_FILE", "").strip() or None
+BACKEND_CLIENT_KEY_FILE = os.getenv("DWANI_BACKEND_CLIENT_KEY_FILE", "").strip() or None
+
+# Concurrent pipeline requests per worker (0 = unlimited), how many may wait, and for how long (see services/concurrency.py).
+PIPELINE_MAX_CONCURRENT = _env_int("DWANI_PIPELINE_MAX_CONCURRENT", 0)
+PIPELINE_MAX_QUEUE = _env_int("DWANI_PIPELINE_MAX_QUEUE", 32)
+PIPELINE_QUEUE_TIMEOUT = _env_int("DWANI_PIPELINE_QUEUE_TIMEOUT", 10)
+PIPELINE_RETRY_AFTER = _env_int("DWANI_PIPELINE_RETRY_AFTER", 5)
+# Concurrent upstream calls per stage and worker, such as "asr=8,llm=2,tts=4" (asr, llm, tts, vision; unlisted ones are unlimited),
+# how many calls may wait for one per stage, and for how long (see services/concurrency.py).
+STAGE_MAX_CONCURRENT = dict(
+    (k.strip().lower(), int(v))
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_STAGE_MAX_CONCURRENT", "").split(","))
+    if k.strip() and v.strip()
+)
+STAGE_MAX_QUEUE = _env_int("DWANI_STAGE_MAX_QUEUE", 64)
+STAGE_QUEUE_TIMEOUT = _env_int("DWANI_STAGE_QUEUE_TIMEOUT", 10)
+# Priority classes (interactive, batch; see services/concurrency.py): slots of each limiter batch work may not take,
+# API keys whose requests are batch, such as "key1=batch,key2=interactive", and the header a request may ask for batch in.
+PRIORITY_INTERACTIVE_RESERVED = _env_int("DWANI_PRIORITY_INTERACTIVE_RESERVED", 1)
+PRIORITY_API_KEYS = dict(
+    (k.strip(), v.strip().lower())
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_PRIORITY_API_KEYS", "").split(","))
+    if k.strip() and v.strip().lower() in ("interactive", "batch")
+)
+PRIORITY_HEADER = os.getenv("DWANI_PRIORITY_HEADER", "X-Priority")
+# Pacing toward each backend instance (see services/pacing.py): calls per second and calls in flight per instance,
+# such as "llm=5,tts=20", how many calls may go at once after a quiet spell, and how long a call may be held back.
+UPSTREAM_MAX_RPS = dict(
+    (k.strip().lower(), float(v))
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_UPSTREAM_MAX_RPS", "").split(","))
+    if k.strip() and v.strip()
+)
+UPSTREAM_MAX_CONCURRENT = dict(
+    (k.strip().lower(), int(v))
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_UPSTREAM_MAX_CONCURRENT", "").split(","))
+    if k.strip() and v.strip()
+)
+UPSTREAM_BURST = _env_int("DWANI_UPSTREAM_BURST", 1)
+UPSTREAM_MAX_WAIT = float(os.getenv("DWANI_UPSTREAM_MAX_WAIT", "5") or 5)
+# Idempotency-Key on /v1/speech_to_speech (see services/idempotency.py): how long a response is replayed, and how many are kept.
+IDEMPOTENCY_TTL_SECONDS = _env_int("DWANI_IDEMPOTENCY_TTL_SECONDS", 3600)
+IDEMPOTENCY_MAX_ENTRIES = _env_int("DWANI_IDEMPOTENCY_MAX_ENTRIES", 256)
+# Replay protection (see services/replay.py): identical audio uploads from one caller within this many seconds
+# (0 = off) are answered from the first response (cache) or refused with 409 (reject).
+REPLAY_WINDOW_SECONDS = _env_int("DWANI_REPLAY_WINDOW_SECONDS", 0)
+REPLAY_ACTION = os.getenv("DWANI_REPLAY_ACTION", "cache").strip().lower() or "cache"
+# Duplicate-audio detection (see services/fingerprint.py): how long the transcript of an uploaded clip is
+# reused for identical uploads (0 = off), how many are kept, and whether whole speech_to_speech responses are too.
+FINGERPRINT_TTL_SECONDS = _env_int("DWANI_FINGERPRINT_TTL_SECONDS", 0)
+FINGERPRINT_MAX_ENTRIES = _env_int("DWANI_FINGERPRINT_MAX_ENTRIES", 512)
+FINGERPRINT_RESULTS = os.getenv("DWANI_FINGERPRINT_RESULTS", "0") == "1"
+# Chaos mode for client resilience testing (see services/chaos.py): per-stage (asr, llm, tts) probabilities such
+# as "asr=0.1,tts=0.2" of added latency, of a failed stage, and of cut-off TTS audio. Never turn this on in production.
+CHAOS_MODE = os.getenv("DWANI_CHAOS_MODE", "0") == "1"
+CHAOS_LATENCY_RATE = dict(
+    (k.strip().lower(), float(v))
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_CHAOS_LATENCY_RATE", "").split(","))
+    if k.strip() and v.strip()
+)
+CHAOS_ERROR_RATE = dict(
+    (k.strip().lower(), float(v))
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_CHAOS_ERROR_RATE", "").split(","))
+    if k.strip() and v.strip()
+)
+CHAOS_LATENCY_MS = _env_int("DWANI_CHAOS_LATENCY_MS", 2000)
+CHAOS_ERROR_STATUS = _env_int("DWANI_CHAOS_ERROR_STATUS", 503)
+CHAOS_TRUNCATE_RATE = float(os.getenv("DWANI_CHAOS_TRUNCATE_RATE", "0") or 0)
+CHAOS_SEED = os.getenv("DWANI_CHAOS_SEED", "").strip()
+
+# Offline development (see services/mock.py): canned ASR transcripts ("|"-separated, used in turn), the LLM's
+# reply template ({text} is the user's text), and an audio file returned by TTS instead of the silent sample MP3.
+MOCK_BACKENDS = os.getenv("DWANI_MOCK_BACKENDS", "0") == "1"
+MOCK_TRANSCRIPTS = [t.strip() for t in os.getenv("DWANI_MOCK_TRANSCRIPTS", "").split("|") if t.strip()]
+MOCK_REPLY = os.getenv("DWANI_MOCK_REPLY", "You said: {text}")
+MOCK_TTS_FILE = os.getenv("DWANI_MOCK_TTS_FILE", "").strip() or None
+
+# Offline fallback for kiosks (see services/offline.py): a whisper.cpp ggml model and its threads for ASR, and
+# piper voices per language ("kannada=/models/kn.onnx,default=/models/en.onnx") for TTS, used when the backend is unreachable.
+OFFLINE_ASR_MODEL = os.getenv("DWANI_OFFLINE_ASR_MODEL", "").strip()
+OFFLINE_ASR_THREADS = _env_int("DWANI_OFFLINE_ASR_THREADS", 4)
+OFFLINE_TTS_VOICES = dict(
+    (k.strip().lower(), v.strip())
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_OFFLINE_TTS_VOICES", "").split(","))
+    if k.strip() and v.strip()
+)
+
+# Several comma-separated backend URLs per stage: round_robin or least_latency, and passive health checks (see services/balancer.py).
+BACKEND_BALANCING = os.getenv("DWANI_BACKEND_BALANCING", "round_robin").strip().lower()
+BACKEND_FAILURE_THRESHOLD = _env_int("DWANI_BACKEND_FAILURE_THRESHOLD", 3)
+BACKEND_EJECT_SECONDS = _env_int("DWANI_BACKEND_EJECT_SECONDS", 30)
+# Backend URLs that name a service (srv+http://..., consul+http://...; see services/discovery.py):
+# how often their instances are looked up again (0: only at startup), and the Consul agent.
+DISCOVERY_REFRESH_SECONDS = _env_int("DWANI_DISCOVERY_REFRESH_SECONDS", 30)
+CONSUL_URL = (os.getenv("DWANI_CONSUL_URL") or os.getenv("CONSUL_HTTP_ADDR") or "http://127.0.0.1:8500").strip()
+
+JOB_WORKERS = _env_int("DWANI_JOB_WORKERS", 2)
+JOB_QUEUE_SIZE = _env_int("DWANI_JOB_QUEUE_SIZE", 100)
+JOB_TTL_SECONDS = _env_int("DWANI_JOB_TTL_SECONDS", 3600)
+# Job webhook_url must resolve to a public host, on submit and again before delivery, unless allowed.
+WEBHOOK_ALLOW_PRIVATE = os.getenv("DWANI_WEBHOOK_ALLOW_PRIVATE", "0") == "1"
+# POST /v1/batch/speech_to_speech (services/batch.py): files per request, counting those in zip
+# archives, and how many of them run through the pipeline at once.
+BATCH_MAX_FILES = _env_int("DWANI_BATCH_MAX_FILES", 100)
+BATCH_CONCURRENCY = _env_int("DWANI_BATCH_CONCURRENCY", 4)
 
 SESSION_CONTEXT_LIMIT = _env_int("DWANI_SESSION_CONTEXT_LIMIT", 10)
 SESSION_MAX_HISTORY = _env_int("DWANI_SESSION_MAX_HISTORY", 20)
+# Session store (services/session.py): redis (memory fallback), sqlite, memory, or module:Class; memory in demo mode.
+SESSION_STORE = "memory" if DEMO_MODE else os.getenv("DWANI_SESSION_STORE", "redis").strip()
+SESSION_SQLITE_PATH = os.getenv("DWANI_SESSION_SQLITE_PATH", "./talk_sessions.db").strip()
+# Session slots (services/slots.py): how many a session holds and how long a string value may be.
+SESSION_SLOTS_MAX = _env_int("DWANI_SESSION_SLOTS_MAX", 32)
+SESSION_SLOT_VALUE_MAX_CHARS = _env_int("DWANI_SESSION_SLOT_VALUE_MAX_CHARS", 200)
+# Session assessment (GET /v1/sessions/{id}/assessment): default language for the feedback, and the LLM's output budget.
+ASSESSMENT_FEEDBACK_LANGUAGE = os.getenv("DWANI_ASSESSMENT_FEEDBACK_LANGUAGE", "english").strip().lower() or "english"
+ASSESSMENT_MAX_TOKENS = _env_int("DWANI_ASSESSMENT_MAX_TOKENS", 2048)
+# Meeting summaries (/v1/meetings/summarize): the LLM's output budget for the minutes.
+MEETING_SUMMARY_MAX_TOKENS = _env_int("DWANI_MEETING_SUMMARY_MAX_TOKENS", 1024)
+# Loop breaker (see services/loops.py): how many earlier assistant replies a reply is compared with (0 = none),
+# the similarity that counts as a repeat, and the reply used when a fresh prompt loops too
+# (unset: the built-in one in the turn's language, services/messages.py).
+LOOP_HISTORY = _env_int("DWANI_LOOP_HISTORY", 3)
+LOOP_SIMILARITY = float(os.getenv("DWANI_LOOP_SIMILARITY", "0.9") or 0.9)
+LOOP_FALLBACK_REPLY = os.getenv("DWANI_LOOP_FALLBACK_REPLY", "").strip()
+# Unclear transcripts: below this ASR confidence (0 = off; only when the backend reports one) the LLM is
+# skipped and the user is asked to repeat, with this reply (unset: the built-in one, services/messages.py).
+ASR_CONFIDENCE_MIN = float(os.getenv("DWANI_ASR_CONFIDENCE_MIN", "0") or 0)
+ASR_CLARIFY_REPLY = os.getenv("DWANI_ASR_CLARIFY_REPLY", "").strip()
+# Translation stage (input_language/output_language): the LLM's language when a turn only names
+# the user's, and the translation's output budget. DWANI_TRANSLATION_BASE_URL is read in services/backends.py.
+TRANSLATION_LANGUAGE = os.getenv("DWANI_TRANSLATION_LANGUAGE", "english").strip().lower() or "english"
+TRANSLATION_MAX_TOKENS = _env_int("DWANI_TRANSLATION_MAX_TOKENS", 1024)
+# Image reading (/v1/image_to_speech): vision model name, timeout, reply length, and upload limit.
+VISION_MODEL = os.getenv("DWANI_VISION_MODEL", "gemma4")
+VISION_TIMEOUT = _env_int("DWANI_VISION_TIMEOUT", 60)
+VISION_MAX_TOKENS = _env_int("DWANI_VISION_MAX_TOKENS", 1024)
+IMAGE_MAX_BYTES = _env_int("DWANI_IMAGE_MAX_BYTES", 10 * 1024 * 1024)
+# Content-Type replacements for TTS responses, "[backend URL prefix ]reported=type" comma-separated (see services/audio_format.py).
+TTS_CONTENT_TYPES = os.getenv("DWANI_TTS_CONTENT_TYPES", "")
+# Request/response templates for ASR and TTS backends with a differently shaped API (see services/provider_templates.py).
+PROVIDER_TEMPLATES_FILE = os.getenv("DWANI_PROVIDER_TEMPLATES_FILE", "").strip()
+# Sanity checks on backend output (see services/sanity.py): retries for an echoing LLM reply or too-short TTS audio, and that minimum.
+GARBAGE_RETRIES = _env_int("DWANI_GARBAGE_RETRIES", 1)
+# Empty LLM replies: extra tries, told to answer in plain text, and whether a conversational turn then gets a
+# fixed apology instead of a 502 (this reply, unset: the built-in one in the turn's language, services/messages.py).
+LLM_EMPTY_RETRIES = _env_int("DWANI_LLM_EMPTY_RETRIES", 1)
+LLM_EMPTY_FALLBACK = os.getenv("DWANI_LLM_EMPTY_FALLBACK", "0") == "1"
+LLM_EMPTY_FALLBACK_REPLY = os.getenv("DWANI_LLM_EMPTY_FALLBACK_REPLY", "").strip()
+MIN_TTS_AUDIO_MS = _env_int("DWANI_MIN_TTS_AUDIO_MS", 100)
+# Shadow traffic (see services/shadow.py): candidate ASR and TTS backends that a percentage of requests is also sent to,
+# in the background with results discarded, and how many shadow requests may run at once per worker.
+SHADOW_ASR_URL = os.getenv("DWANI_SHADOW_ASR_URL", "").strip()
+SHADOW_TTS_URL = os.getenv("DWANI_SHADOW_TTS_URL", "").strip().rstrip("/")
+SHADOW_PERCENT = float(os.getenv("DWANI_SHADOW_PERCENT", "10") or 0)
+SHADOW_MAX_CONCURRENT = _env_int("DWANI_SHADOW_MAX_CONCURRENT", 4)
+# ffmpeg binary used when the TTS backend cannot return the requested reply audio format, and to post-process it.
+FFMPEG_PATH = os.getenv("DWANI_FFMPEG_PATH", "ffmpeg").strip() or "ffmpeg"
+# Reply audio post-processing with ffmpeg (see services/loudness.py): integrated loudness target in LUFS
+# (0 = leave the backend's volume), true-peak ceiling in dBTP, and silence put before every clip in ms.
+TTS_LOUDNESS_LUFS = float(os.getenv("DWANI_TTS_LOUDNESS_LUFS", "0") or 0)
+TTS_TRUE_PEAK = float(os.getenv("DWANI_TTS_TRUE_PEAK", "-1.5") or -1.5)
+TTS_LEAD_SILENCE_MS = _env_int("DWANI_TTS_LEAD_SILENCE_MS", 0)
+# ID3 tags on MP3 reply audio (see services/audio_metadata.py): language, request ID, time, and the first
+# DWANI_AUDIO_METADATA_EXCERPT_CHARS characters of what it says (0 = leave the text out).
+AUDIO_METADATA = os.getenv("DWANI_AUDIO_METADATA", "0") == "1"
+AUDIO_METADATA_EXCERPT_CHARS = _env_int("DWANI_AUDIO_METADATA_EXCERPT_CHARS", 100)
+# Dictation: ASR segment length for long WAV uploads, and LLM punctuation/ITN restoration (auto, always, never).
+DICTATION_SEGMENT_SECONDS = _env_int("DWANI_DICTATION_SEGMENT_SECONDS", 30)
+DICTATION_RESTORE = os.getenv("DWANI_DICTATION_RESTORE", "auto").strip().lower()
+# Dictation silence compression: pauses of at least this long are cut to DWANI_DICTATION_SILENCE_KEEP_MS before ASR (0 = off).
+DICTATION_SILENCE_MS = _env_int("DWANI_DICTATION_SILENCE_MS", 2000)
+DICTATION_SILENCE_KEEP_MS = _env_int("DWANI_DICTATION_SILENCE_KEEP_MS", 500)
+# Pronunciation assessment: the reading pace that scores full fluency, and the pause length counted as a hesitation.
+PRONUNCIATION_TARGET_WPM = _env_int("DWANI_PRONUNCIATION_TARGET_WPM", 90)
+PRONUNCIATION_PAUSE_MS = _env_int("DWANI_PRONUNCIATION_PAUSE_MS", 700)
+# Read-aloud (/v1/read): document size limit, sentence chunk size, parallel TTS calls, and where artifacts live.
+READ_MAX_CHARS = _env_int("DWANI_READ_MAX_CHARS", 100000)
+READ_CHUNK_CHARS = _env_int("DWANI_READ_CHUNK_CHARS", 400)
+READ_PARALLELISM = _env_int("DWANI_READ_PARALLELISM", 4)
+READ_DIR = os.getenv("DWANI_READ_DIR", "").strip() or os.path.join(tempfile.gettempdir(), "dwani-read")
+READ_TTL_SECONDS = _env_int("DWANI_READ_TTL_SECONDS", 3600)
+# Read-aloud of URLs: fetch timeout and size cap; private/loopback hosts are refused unless allowed.
+READ_URL_TIMEOUT = _env_int("DWANI_READ_URL_TIMEOUT", 15)
+READ_URL_MAX_BYTES = _env_int("DWANI_READ_URL_MAX_BYTES", 5 * 1024 * 1024)
+READ_URL_ALLOW_PRIVATE = os.getenv("DWANI_READ_URL_ALLOW_PRIVATE", "0") == "1"
+# Segmented replies (services/reply_segments.py), format=segments: longest segment in characters, and how
+# long segment URLs work. They are sealed with DWANI_REPLY_SEGMENT_SECRET (or _FILE; random per process when unset).
+REPLY_SEGMENT_CHARS = _env_int("DWANI_REPLY_SEGMENT_CHARS", 200)
+REPLY_SEGMENT_TTL_SECONDS = _env_int("DWANI_REPLY_SEGMENT_TTL_SECONDS", 600)
+# Reply audio in object storage (see services/blob_store.py) for format=url: s3://bucket/prefix, gs://bucket/prefix or
+# module:Class, the endpoint of an S3-compatible store such as MinIO, its region, and how long signed URLs stay valid.
+BLOB_STORE_URI = os.getenv("DWANI_BLOB_STORE_URI", "").strip()
+BLOB_ENDPOINT_URL = os.getenv("DWANI_BLOB_ENDPOINT_URL", "").strip()
+BLOB_REGION = os.getenv("DWANI_BLOB_REGION", "").strip()
+BLOB_URL_TTL_SECONDS = _env_int("DWANI_BLOB_URL_TTL_SECONDS", 3600)
+# Input audio by URL (audio_url on /v1/speech_to_speech): fetch timeout; the size cap is DWANI_MAX_UPLOAD_BYTES,
+# and private/loopback hosts are refused unless allowed.
+AUDIO_URL_TIMEOUT = _env_int("DWANI_AUDIO_URL_TIMEOUT", 20)
+AUDIO_URL_ALLOW_PRIVATE = os.getenv("DWANI_AUDIO_URL_ALLOW_PRIVATE", "0") == "1"
+# Pipeline stage hooks (see services/hooks.py): comma-separated "module:function" plugins called at startup.
+PIPELINE_PLUGINS = os.getenv("DWANI_PIPELINE_PLUGINS", "").strip()
+# Start/shutdown hooks (see services/lifecycle.py): seconds each shutdown hook may take.
+SHUTDOWN_HOOK_TIMEOUT = _env_int("DWANI_SHUTDOWN_HOOK_TIMEOUT", 10)
+# Draining (see services/drain.py): seconds POST /admin/drain waits for in-flight pipeline requests, and the
+# flag file that makes every worker's /ready report draining.
+DRAIN_TIMEOUT = float(os.getenv("DWANI_DRAIN_TIMEOUT") or 30)
+DRAIN_FILE = os.getenv("DWANI_DRAIN_FILE", "").strip() or os.path.join(tempfile.gettempdir(), "dwani-draining")
+# Backend warm-up (see services/warmup.py): probe every stage at startup and hold readiness until each has
+# answered, retrying every DWANI_WARMUP_RETRY_SECONDS; keepalive probes every DWANI_BACKEND_KEEPALIVE_SECONDS after (0 = none).
+WARMUP = os.getenv("DWANI_WARMUP", "0") == "1"
+WARMUP_RETRY_SECONDS = float(os.getenv("DWANI_WARMUP_RETRY_SECONDS", "10") or 10)
+BACKEND_KEEPALIVE_SECONDS = float(os.getenv("DWANI_BACKEND_KEEPALIVE_SECONDS", "0") or 0)
+# Constrained prompts (see services/grammar.py): JSON file of extra grammars, name -> spec.
+GRAMMARS_FILE = os.getenv("DWANI_GRAMMARS_FILE", "").strip()
+# Assistant personas (see services/personas.py): JSON file of name -> profile, and the one used when a request names none.
+PERSONAS_FILE = os.getenv("DWANI_PERSONAS_FILE", "").strip()
+PERSONA = os.getenv("DWANI_PERSONA", "default").strip() or "default"
+# White-label phrases (see services/branding.py): JSON file of default and per-tenant text or recordings for built-in messages.
+BRANDING_FILE = os.getenv("DWANI_BRANDING_FILE", "").strip()
+# Tenants (see services/tenants.py): JSON file of tenant name -> API keys, hosts, backends, languages, personas and quotas.
+TENANTS_FILE = os.getenv("DWANI_TENANTS_FILE", "").strip()
+# Feature flags (see services/flags.py): deployment-wide name=on|off|N% rollouts, and a JSON file turning flags on or
+# off for particular API keys or tenants.
+FEATURE_FLAGS = os.getenv("DWANI_FEATURE_FLAGS", "").strip()
+FEATURE_FLAGS_FILE = os.getenv("DWANI_FEATURE_FLAGS_FILE", "").strip()
+# Intents that need a spoken "yes" before they are emitted (see services/confirmation.py), how many unclear
+# answers re-ask the question before the intent is dropped, and an optional CloudEvents webhook for emitted intents.
+CONFIRM_INTENTS = {name.strip().lower() for name in os.getenv("DWANI_CONFIRM_INTENTS", "").split(",") if name.strip()}
+CONFIRM_MAX_ATTEMPTS = _env_int("DWANI_CONFIRM_MAX_ATTEMPTS", 2)
+INTENT_WEBHOOK_URL = os.getenv("DWANI_INTENT_WEBHOOK_URL", "").strip()
+# language=auto: language used when detection finds none (empty = let the LLM follow the user), and
+# per-language TTS voices such as "kannada=kn_female,hindi=hi_male" sent as the speech request's voice.
+AUTO_LANGUAGE_FALLBACK = os.getenv("DWANI_AUTO_LANGUAGE_FALLBACK", "").strip().lower()
+TTS_VOICES = dict(
+    (k.strip().lower(), v.strip())
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_TTS_VOICES", "").split(","))
+    if k.strip() and v.strip()
+)
+# Code-mixed input (see services/transliteration.py): per language, whether Latin-script words in transcripts and
+# replies are written in the language's script ("kannada=mixed,hindi=native"; keep, native or mixed), and words kept in Latin.
+TRANSLITERATE = os.getenv("DWANI_TRANSLITERATE", "").strip()
+TRANSLITERATE_KEEP_WORDS = {w.strip().lower() for w in os.getenv("DWANI_TRANSLITERATE_KEEP_WORDS", "").split(",") if w.strip()}
+# Spoken forms for TTS (see services/text_normalization.py): numbers, dates, currency and abbreviations in Kannada,
+# Hindi and Tamil replies written out in words (0: as the LLM wrote them), a JSON file of extra abbreviations
+# (language -> {abbreviation: expansion}), and SSML with optional prosody rate and pitch for backends that accept it.
+TTS_NORMALIZE = os.getenv("DWANI_TTS_NORMALIZE", "1") == "1"
+TTS_ABBREVIATIONS_FILE = os.getenv("DWANI_TTS_ABBREVIATIONS_FILE", "").strip()
+TTS_SSML = os.getenv("DWANI_TTS_SSML", "0") == "1"
+TTS_SSML_RATE = os.getenv("DWANI_TTS_SSML_RATE", "").strip()
+TTS_SSML_PITCH = os.getenv("DWANI_TTS_SSML_PITCH", "").strip()
+# Neighbor-language fallback (see services/transcribe.py): when the ASR backend reports a language confidence
+# below DWANI_LANGUAGE_CONFIDENCE_MIN for the requested language, the audio is transcribed again in its
+# neighbors, such as "kannada=telugu,telugu=kannada,hindi=marathi|punjabi", and the most confident result is kept.
+LANGUAGE_CONFIDENCE_MIN = float(os.getenv("DWANI_LANGUAGE_CONFIDENCE_MIN", "0.5") or 0)
+NEIGHBOR_LANGUAGES = dict(
+    (k.strip().lower(), [n.strip().lower() for n in v.split("|") if n.strip()])
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_NEIGHBOR_LANGUAGES", "").split(","))
+    if k.strip() and v.strip()
+)
+# Speaker diarization (see services/transcribe.py): a request field set to true for ASR backends that diarize
+# natively and return timed "segments"; unset, speakers are only labelled through the prompt.
+ASR_DIARIZE_FIELD = os.getenv("DWANI_ASR_DIARIZE_FIELD", "").strip()
+# N-best transcripts: how many hypotheses to ask the ASR backend for (its "n"; 0 or 1 = only the best).
+# Alternatives the backend returns are passed on either way, for clients to offer as corrections.
+ASR_NBEST = _env_int("DWANI_ASR_NBEST", 0)
+# Audio preprocessing before ASR (see services/preprocess.py): "trim", "normalize", both comma-separated, or "off";
+# X-Audio-Preprocess overrides it per request. Silence is quieter than the loudest moment by DWANI_AUDIO_TRIM_DB,
+# and DWANI_AUDIO_TRIM_PAD_MS of it stays at each end; speech is brought to DWANI_AUDIO_TARGET_DBFS, by at most
+# DWANI_AUDIO_MAX_GAIN_DB and never past -1 dBFS peaks.
+AUDIO_PREPROCESS = os.getenv("DWANI_AUDIO_PREPROCESS", "off").strip().lower()
+AUDIO_TRIM_DB = float(os.getenv("DWANI_AUDIO_TRIM_DB", "40") or 40)
+AUDIO_TRIM_PAD_MS = _env_int("DWANI_AUDIO_TRIM_PAD_MS", 200)
+AUDIO_TARGET_DBFS = float(os.getenv("DWANI_AUDIO_TARGET_DBFS", "-20") or -20)
+AUDIO_MAX_GAIN_DB = float(os.getenv("DWANI_AUDIO_MAX_GAIN_DB", "30") or 30)
+# Hints on uploads with no speech in them (see services/speech_hints.py): shorter than this is too_short, a loudest
+# moment below this level too_quiet, and at least this percentage of samples at full scale clipped.
+NO_SPEECH_MIN_SECONDS = float(os.getenv("DWANI_NO_SPEECH_MIN_SECONDS", "0.5") or 0.5)
+NO_SPEECH_QUIET_DBFS = float(os.getenv("DWANI_NO_SPEECH_QUIET_DBFS", "-40") or -40)
+NO_SPEECH_CLIPPED_PERCENT = float(os.getenv("DWANI_NO_SPEECH_CLIPPED_PERCENT", "1") or 1)
+# Content moderation (see services/moderation.py): blocklist terms and/or file, mask or block, the mask text,
+# an optional OpenAI-compatible /v1/moderations URL, which directions to check, and whether API failures let text through.
+MODERATION_BLOCKLIST = os.getenv("DWANI_MODERATION_BLOCKLIST", "")
+MODERATION_BLOCKLIST_FILE = os.getenv("DWANI_MODERATION_BLOCKLIST_FILE", "").strip()
+MODERATION_ACTION = os.getenv("DWANI_MODERATION_ACTION", "mask").strip().lower()
+MODERATION_MASK = os.getenv("DWANI_MODERATION_MASK", "***")
+MODERATION_URL = os.getenv("DWANI_MODERATION_URL", "").strip()
+MODERATION_TIMEOUT = _env_int("DWANI_MODERATION_TIMEOUT", 5)
+MODERATION_STAGES = {s.strip().lower() for s in os.getenv("DWANI_MODERATION_STAGES", "input,output").split(",") if s.strip()}
+MODERATION_FAIL_OPEN = os.getenv("DWANI_MODERATION_FAIL_OPEN", "0") == "1"
+# Prompt-injection guard (see services/injection.py): off, log, neutralize or block instruction-like user
+# input before the LLM, the text that replaces it under neutralize, and a file of extra patterns.
+INJECTION_ACTION = os.getenv("DWANI_INJECTION_ACTION", "log").strip().lower()
+INJECTION_MASK = os.getenv("DWANI_INJECTION_MASK", "[removed]")
+INJECTION_PATTERNS_FILE = os.getenv("DWANI_INJECTION_PATTERNS_FILE", "").strip()
+# PII redaction (see services/pii.py): on/off, the built-in types to look for, a file of extra
+# "name regex" types, the text that replaces each item ({type} is its type), and an audit file.
+PII_REDACT = os.getenv("DWANI_PII_REDACT", "0") == "1"
+PII_TYPES = tuple(t.strip().lower() for t in os.getenv("DWANI_PII_TYPES", "phone,aadhaar,email,pan").split(",") if t.strip())
+PII_PATTERNS_FILE = os.getenv("DWANI_PII_PATTERNS_FILE", "").strip()
+PII_MASK = os.getenv("DWANI_PII_MASK", "[{type}]")
+PII_AUDIT_FILE = os.getenv("DWANI_PII_AUDIT_FILE", "").strip()
+# Transcript corrections (see services/corrections.py): JSON list of phrase or regex replacements applied to
+# ASR output before the LLM, managed at runtime over /admin/corrections and written back to the file.
+CORRECTIONS_FILE = os.getenv("DWANI_CORRECTIONS_FILE", "").strip()
+# Per-turn budgets (0 = none): LLM reply tokens and TTS characters; an over-long reply is first
+# shortened by the LLM (DWANI_BUDGET_SHORTEN=1), else cut at a sentence boundary.
+TURN_MAX_LLM_TOKENS = _env_int("DWANI_TURN_MAX_LLM_TOKENS", 0)
+TURN_MAX_TTS_CHARS = _env_int("DWANI_TURN_MAX_TTS_CHARS", 0)
+BUDGET_SHORTEN = os.getenv("DWANI_BUDGET_SHORTEN", "1") == "1"
+# Latency budget (see services/budget.py), for requests with a deadline: time kept back for TTS, the LLM's output
+# rate used to fit max_tokens into what is left (floored at DWANI_LATENCY_MIN_TOKENS), and the time left for the LLM
+# below which optional stages (retrieval, loop retries, LLM shortening) are skipped. DWANI_LATENCY_DEGRADE=0 turns it off.
+LATENCY_DEGRADE = os.getenv("DWANI_LATENCY_DEGRADE", "1") == "1"
+LATENCY_TTS_RESERVE_MS = _env_int("DWANI_LATENCY_TTS_RESERVE_MS", 1500)
+LATENCY_LLM_TOKENS_PER_SECOND = _env_int("DWANI_LATENCY_LLM_TOKENS_PER_SECOND", 20)
+LATENCY_MIN_TOKENS = _env_int("DWANI_LATENCY_MIN_TOKENS", 32)
+LATENCY_SKIP_BELOW_MS = _env_int("DWANI_LATENCY_SKIP_BELOW_MS", 4000)
+# Caption cues and word timings for reply audio (services/captions.py): longest cue, speaking
+# rate used to time words when the audio's duration is not known yet, and the TTS response
+# header that carries the backend's own word timing marks, if it sends them.
+CAPTION_MAX_CHARS = _env_int("DWANI_CAPTION_MAX_CHARS", 84)
+CAPTION_CHARS_PER_SECOND = float(os.getenv("DWANI_CAPTION_CHARS_PER_SECOND", "14") or 14)
+TTS_TIMESTAMPS_HEADER = os.getenv("DWANI_TTS_TIMESTAMPS_HEADER", "X-Word-Timestamps").strip()
+# End-of-turn preset for sessions that have not chosen one: fast, balanced, or patient.
+TURN_DETECTION_PRESET = os.getenv("DWANI_TURN_DETECTION_PRESET", "balanced").strip().lower()
+# Streaming transcription (/v1/transcribe/stream): PCM sample rate when the client does not give one.
+STREAM_SAMPLE_RATE = _env_int("DWANI_STREAM_SAMPLE_RATE", 16000)
+# Encrypted audio frames on that WebSocket (see services/stream_crypto.py): optional, required or off. The key
+# exchange is signed with DWANI_STREAM_SIGNING_KEY (or _FILE), without which encrypted sessions are refused.
+STREAM_ENCRYPTION = os.getenv("DWANI_STREAM_ENCRYPTION", "optional").strip().lower()
+# Wake-word gating on that WebSocket (see services/wake_word.py): comma-separated wake words (empty: off) that
+# ?wake_word= may replace, how alike a transcript must be (1 = exact), and seconds to wait for the command.
+STREAM_WAKE_WORDS = os.getenv("DWANI_STREAM_WAKE_WORDS", "").strip()
+STREAM_WAKE_SIMILARITY = float(os.getenv("DWANI_STREAM_WAKE_SIMILARITY") or 0.8)
+STREAM_WAKE_WINDOW = float(os.getenv("DWANI_STREAM_WAKE_WINDOW") or 8)
+# Conversations over a WebSocket (/v1/conversation/stream): how often, in ms of speech, the turn so
+# far is transcribed for a partial_transcript event (0: no partial transcripts).
+CONVERSATION_PARTIAL_MS = _env_int("DWANI_CONVERSATION_PARTIAL_MS", 1000)
+# Diagnostics under /debug (pprof-style profiles, live config, GC stats); they also need
+# DWANI_ADMIN_TOKEN. tracemalloc frames per allocation for /debug/pprof/heap (0: off; slows allocation).
+DEBUG_ENDPOINTS = os.getenv("DWANI_DEBUG_ENDPOINTS", "0") == "1"
+DEBUG_TRACEMALLOC_FRAMES = _env_int("DWANI_DEBUG_TRACEMALLOC_FRAMES", 0)
+# Per-request artifact recording (services/recorder.py): uploaded audio, transcript, LLM and TTS text, and TTS audio
+# under RECORD_DIR/<request id>/, for reproducing bad answers; admin API at /admin/artifacts.
+RECORD_ARTIFACTS = os.getenv("DWANI_RECORD_ARTIFACTS", "0") == "1" and not DEMO_MODE
+RECORD_DIR = os.getenv("DWANI_RECORD_DIR", "./talk_recordings").strip() or "./talk_recordings"
+RECORD_TTL_SECONDS = _env_int("DWANI_RECORD_TTL_SECONDS", 3 * 86400)
+RECORD_MAX_REQUESTS = _env_int("DWANI_RECORD_MAX_REQUESTS", 1000)
+# Serve the microphone demo page at / (routers/demo.py); turn off where only the API should be exposed.
+DEMO_PAGE = os.getenv("DWANI_DEMO_PAGE", "1") == "1"
+# Demo mode: pipeline requests per IP and day, how often the demo API key changes (the previous one stays
+# valid for as long again), and the audio watermark text. The key's signing secret is DWANI_DEMO_KEY_SECRET (or _FILE).
+DEMO_DAILY_QUOTA = _env_int("DWANI_DEMO_DAILY_QUOTA", 100)
+DEMO_KEY_ROTATE_SECONDS = max(60, _env_int("DWANI_DEMO_KEY_ROTATE_SECONDS", 3600))
+DEMO_WATERMARK = os.getenv("DWANI_DEMO_WATERMARK", "Generated by the dwani.ai public demo").strip()
+# Persist each turn (text only, no audio) to DWANI_DATABASE_URL for /v1/graphql analytics.
+STORE_CONVERSATIONS = os.getenv("DWANI_STORE_CONVERSATIONS", "0") == "1" and not DEMO_MODE
+# Stored conversation retention (see services/retention.py): turns older than this many days are erased (0 = kept),
+# soft-deleted conversations are erased this many days after deletion, and the purge runs this often (0 = only from cron).
+CONVERSATION_RETENTION_DAYS = _env_int("DWANI_CONVERSATION_RETENTION_DAYS", 0)
+CONVERSATION_DELETE_GRACE_DAYS = _env_int("DWANI_CONVERSATION_DELETE_GRACE_DAYS", 30)
+CONVERSATION_PURGE_INTERVAL_SECONDS = _env_int("DWANI_CONVERSATION_PURGE_INTERVAL_SECONDS", 3600)
+# Usage accounting per API key (see services/usage.py): memory, sqlite, off, or module:Class, and the SQLite file.
+USAGE_STORE = os.getenv("DWANI_USAGE_STORE", "memory").strip()
+USAGE_SQLITE_PATH = os.getenv("DWANI_USAGE_SQLITE_PATH", "./talk_usage.db").strip()
+# Estimated cost per 1000 characters of transcript (ASR + LLM prompt) and reply (LLM output + TTS).
+COST_PER_1K_INPUT_CHARS = float(os.getenv("DWANI_COST_PER_1K_INPUT_CHARS", "0") or 0)
+COST_PER_1K_OUTPUT_CHARS = float(os.getenv("DWANI_COST_PER_1K_OUTPUT_CHARS", "0") or 0)
+# Experiment and variant of every turn this deployment serves, when requests do not name one (see services/experiments.py).
+EXPERIMENT = os.getenv("DWANI_EXPERIMENT", "").strip()
+EXPERIMENT_VARIANT = os.getenv("DWANI_EXPERIMENT_VARIANT", "").strip()
+# IDs the server makes up (see services/ids.py): uuid4, uuid7 or ulid, optional per-kind prefixes such as
+# "request=req_,job=job_", and the headers a client's own correlation ID is taken from (empty = never).
+ID_FORMAT = os.getenv("DWANI_ID_FORMAT", "uuid4").strip().lower() or "uuid4"
+ID_PREFIXES = dict(
+    (k.strip().lower(), v.strip())
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_ID_PREFIXES", "").split(","))
+    if k.strip() and v.strip()
+)
+REQUEST_ID_HEADERS = [
+    name.strip() for name in os.getenv("DWANI_REQUEST_ID_HEADERS", "X-Request-ID,X-Correlation-ID").split(",") if name.strip()
+]
+# Share links to stored conversations (services/share.py): default and longest lifetime, and how long
+# an entered passcode keeps the page open. Links are signed with DWANI_SHARE_LINK_SECRET (or _FILE).
+SHARE_LINK_TTL_SECONDS = _env_int("DWANI_SHARE_LINK_TTL_SECONDS", 7 * 86400)
+SHARE_LINK_MAX_TTL_SECONDS = _env_int("DWANI_SHARE_LINK_MAX_TTL_SECONDS", 30 * 86400)
+SHARE_LINK_UNLOCK_SECONDS = _env_int("DWANI_SHARE_LINK_UNLOCK_SECONDS", 3600)
+# Twilio Media Streams phone calls (/v1/telephony/twilio): the account's auth token (requests
+# must carry a valid X-Twilio-Signature when set), the public wss:// URL Twilio connects to,
+# call defaults (a <Stream> <Parameter> overrides them), and what is said on answer and on failure.
+TWILIO_AUTH_TOKEN = os.getenv("DWANI_TWILIO_AUTH_TOKEN", "").strip()
+TWILIO_STREAM_URL = os.getenv("DWANI_TWILIO_STREAM_URL", "").strip()
+TWILIO_LANGUAGE = os.getenv("DWANI_TWILIO_LANGUAGE", "").strip()
+TWILIO_MODE = os.getenv("DWANI_TWILIO_MODE", "llm").strip() or "llm"
+TWILIO_AGENT = os.getenv("DWANI_TWILIO_AGENT", "").strip()
+TWILIO_GREETING = os.getenv("DWANI_TWILIO_GREETING", "").strip()
+TWILIO_ERROR_PROMPT = os.getenv("DWANI_TWILIO_ERROR_PROMPT", "").strip()
+# Telegram bot (services/telegram.py; the token is DWANI_TELEGRAM_BOT_TOKEN, read with the other
+# credentials): the public webhook URL registered at startup, or long polling instead, chat
+# defaults, the chat IDs allowed to use it (empty: anyone), and the Bot API server.
+TELEGRAM_WEBHOOK_URL = os.getenv("DWANI_TELEGRAM_WEBHOOK_URL", "").strip()
+TELEGRAM_POLLING = os.getenv("DWANI_TELEGRAM_POLLING", "0") == "1"
+TELEGRAM_LANGUAGE = os.getenv("DWANI_TELEGRAM_LANGUAGE", "").strip()
+TELEGRAM_MODE = os.getenv("DWANI_TELEGRAM_MODE", "llm").strip() or "llm"
+TELEGRAM_AGENT = os.getenv("DWANI_TELEGRAM_AGENT", "").strip()
+TELEGRAM_ALLOWED_CHATS = {
+    chat.strip() for chat in os.getenv("DWANI_TELEGRAM_ALLOWED_CHATS", "").split(",") if chat.strip()
+}
+TELEGRAM_API_URL = os.getenv("DWANI_TELEGRAM_API_URL", "https://api.telegram.org").strip()
+# Discord voice bot (services/discord_bot.py; the token is DWANI_DISCORD_BOT_TOKEN, read with the
+# other credentials): the voice channel joined at startup, defaults for every member, and the
+# user IDs it answers (empty: anyone in the channel).
+DISCORD_CHANNEL_ID = _env_int("DWANI_DISCORD_CHANNEL_ID", 0)
+DISCORD_LANGUAGE = os.getenv("DWANI_DISCORD_LANGUAGE", "").strip()
+DISCORD_MODE = os.getenv("DWANI_DISCORD_MODE", "llm").strip() or "llm"
+DISCORD_AGENT = os.getenv("DWANI_DISCORD_AGENT", "").strip()
+DISCORD_ALLOWED_USERS = {
+    user.strip() for user in os.getenv("DWANI_DISCORD_ALLOWED_USERS", "").split(",") if user.strip()
+}
+# WebRTC calls (services/webrtc.py): STUN/TURN server URLs offered to the peer connection, and calls per worker.
+WEBRTC_ICE_SERVERS = [url.strip() for url in os.getenv("DWANI_WEBRTC_ICE_SERVERS", "").split(",") if url.strip()]
+WEBRTC_MAX_CALLS = _env_int("DWANI_WEBRTC_MAX_CALLS", 20)
+# Barge-in on phone calls: caller speech of at least DWANI_BARGE_IN_MS while a reply is being
+# prepared or played cancels it and starts a new turn.
+BARGE_IN = os.getenv("DWANI_BARGE_IN", "1") == "1"
+BARGE_IN_MS = _env_int("DWANI_BARGE_IN_MS", 300)
 _MAX_SESSIONS = 5000
+# One JSON access log line per request (method, path, status, duration, per-stage times) except for the
+# listed paths, and a Server-Timing response header with the ASR, LLM and TTS durations.
+ACCESS_LOG = os.getenv("DWANI_ACCESS_LOG", "1") == "1"
+ACCESS_LOG_EXCLUDE = {
+    path.strip() for path in os.getenv("DWANI_ACCESS_LOG_EXCLUDE", "/health,/ready,/startup,/metrics").split(",") if path.strip()
+}
+SERVER_TIMING = os.getenv("DWANI_SERVER_TIMING", "1") == "1"
+# gzip for whole JSON responses of at least this many bytes when the client accepts it (see services/compression.py).
+COMPRESSION = os.getenv("DWANI_COMPRESSION", "1") == "1"
+COMPRESS_MIN_BYTES = _env_int("DWANI_COMPRESS_MIN_BYTES", 1024)
+COMPRESS_LEVEL = _env_int("DWANI_COMPRESS_LEVEL", 6)
+
+# CORS (see cors.py): comma-separated origins ("*" wildcards allowed), request headers browsers may send,
+# response headers scripts may read, preflight cache lifetime, and whether cookies/credentials are allowed.
+CORS_ALLOWED_ORIGINS = os.getenv(
+    "DWANI_CORS_ALLOWED_ORIGINS",
+    "https://dwani.ai,https://talk.dwani.ai,https://*.dwani.ai,https://*dwani-*.hf.space,"
+    "http://localhost:11080,http://localhost:5173,http://localhost,http://127.0.0.1:5173,http://127.0.0.1:80",
+)
+CORS_ALLOWED_HEADERS = os.getenv(
+    "DWANI_CORS_ALLOWED_HEADERS",
+    "Content-Type,X-Session-ID,X-Request-ID,X-Correlation-ID,X-Request-Timeout,Idempotency-Key,X-API-Key,Authorization",
+)
+CORS_EXPOSE_HEADERS = os.getenv(
+    "DWANI_CORS_EXPOSE_HEADERS", "X-Request-ID,Server-Timing,Retry-After,X-Timeout-Stage,X-Deadline-Consumed-Ms,X-Deadline-Remaining-Ms,X-Read-Id,X-Audio-Duration,X-Grammar-Match,X-Grammar-Value,X-Confirmation,X-Intent,X-Detected-Language,X-Language-Fallback,X-ASR-Low-Confidence,X-Budget-Hit,X-LLM-Model,Idempotent-Replayed"
+)
+CORS_MAX_AGE = _env_int("DWANI_CORS_MAX_AGE", 86400)
+CORS_ALLOW_CREDENTIALS = os.getenv("DWANI_CORS_ALLOW_CREDENTIALS", "1") == "1"
+
+# Token-bucket limits such as "120/minute"; empty string disables that dimension.
+RATE_LIMIT_PER_IP = os.getenv("DWANI_RATE_LIMIT_PER_IP", "10/minute" if DEMO_MODE else "120/minute")
+RATE_LIMIT_PER_API_KEY = os.getenv("DWANI_RATE_LIMIT_PER_API_KEY", "600/minute")
 
 LLM_MODEL = os.getenv("DWANI_LLM_MODEL", "gemma3")
+# Generation defaults; requests may override them. Unset temperature/top_p use the backend's default.
+LLM_MAX_TOKENS = _env_int("DWANI_LLM_MAX_TOKENS", 256)
+LLM_TEMPERATURE = float(os.environ["DWANI_LLM_TEMPERATURE"]) if os.getenv("DWANI_LLM_TEMPERATURE") else None
+LLM_TOP_P = float(os.environ["DWANI_LLM_TOP_P"]) if os.getenv("DWANI_LLM_TOP_P") else None
+# Reply length (services/prompts.py): the default verbosity mode (short, normal or detailed) and the
+# max_tokens of the longer two; short replies get DWANI_LLM_MAX_TOKENS.
+VERBOSITY = os.getenv("DWANI_VERBOSITY", "short").strip().lower() or "short"
+VERBOSITY_NORMAL_MAX_TOKENS = _env_int("DWANI_VERBOSITY_NORMAL_MAX_TOKENS", 512)
+VERBOSITY_DETAILED_MAX_TOKENS = _env_int("DWANI_VERBOSITY_DETAILED_MAX_TOKENS", 1024)
+# LLM fallback (see services/llm_health.py): an OpenAI-compatible backend and model (unset: DWANI_LLM_MODEL) used while
+# the median time to first token of the last DWANI_LLM_HEALTH_WINDOW calls is over DWANI_LLM_SLOW_MS, for this many seconds.
+LLM_FALLBACK_BASE_URL = os.getenv("DWANI_LLM_FALLBACK_BASE_URL", "").strip().rstrip("/")
+LLM_FALLBACK_MODEL = os.getenv("DWANI_LLM_FALLBACK_MODEL", "").strip()
+LLM_SLOW_MS = _env_int("DWANI_LLM_SLOW_MS", 5000)
+LLM_HEALTH_WINDOW = _env_int("DWANI_LLM_HEALTH_WINDOW", 10)
+LLM_FALLBACK_SECONDS = _env_int("DWANI_LLM_FALLBACK_SECONDS", 60)
+# Hedged LLM requests (see services/balancer.py): when a completion has not answered within this many milliseconds,
+# the same prompt goes to the next DWANI_API_BASE_URL_LLM instance (or the same one again) and the first reply wins.
+LLM_HEDGE = os.getenv("DWANI_LLM_HEDGE", "0") == "1"
+LLM_HEDGE_DELAY_MS = _env_int("DWANI_LLM_HEDGE_DELAY_MS", 1000)
+# A/B testing of LLM models (see services/llm_variants.py): "model[@base_url]=weight,..." (empty: DWANI_LLM_MODEL only).
+LLM_VARIANTS = os.getenv("DWANI_LLM_VARIANTS", "").strip()
+# Models a request's model parameter may name (see services/llm_variants.py), besides DWANI_LLM_MODEL and the
+# DWANI_LLM_VARIANTS models; empty: any model.
+LLM_ALLOWED_MODELS = [name.strip() for name in os.getenv("DWANI_LLM_ALLOWED_MODELS", "").split(",") if name.strip()]
+# LLM tool calling (see services/tools.py): tools offered with conversational LLM calls (empty: none), how many
+# rounds of tool calls one reply may take, and each tool's timeout and result length.
+LLM_TOOLS = [name.strip() for name in os.getenv("DWANI_LLM_TOOLS", "").split(",") if name.strip()]
+LLM_TOOL_ROUNDS = _env_int("DWANI_LLM_TOOL_ROUNDS", 3)
+LLM_TOOL_TIMEOUT = _env_int("DWANI_LLM_TOOL_TIMEOUT", 5)
+LLM_TOOL_RESULT_CHARS = _env_int("DWANI_LLM_TOOL_RESULT_CHARS", 2000)
+TOOL_TIMEZONE = os.getenv("DWANI_TOOL_TIMEZONE", "Asia/Kolkata").strip()
+TOOL_WEATHER_URL = os.getenv("DWANI_TOOL_WEATHER_URL", "").strip()
+TOOL_KNOWLEDGE_FILE = os.getenv("DWANI_TOOL_KNOWLEDGE_FILE", "").strip()
+TOOLS_FILE = os.getenv("DWANI_TOOLS_FILE", "").strip()
+# Retrieval stage (see services/retrieval.py): a search endpoint queried with the transcript before the LLM
+# (empty: off), how many passages to keep, the lowest score kept (unset: all), their total length, and its timeout.
+RETRIEVAL_URL = os.getenv("DWANI_RETRIEVAL_URL", "").strip()
+RETRIEVAL_TOP_K = _env_int("DWANI_RETRIEVAL_TOP_K", 3)
+RETRIEVAL_MIN_SCORE = float(os.environ["DWANI_RETRIEVAL_MIN_SCORE"]) if os.getenv("DWANI_RETRIEVAL_MIN_SCORE") else None
+RETRIEVAL_MAX_CHARS = _env_int("DWANI_RETRIEVAL_MAX_CHARS", 3000)
+RETRIEVAL_TIMEOUT = float(os.getenv("DWANI_RETRIEVAL_TIMEOUT") or 3)
 AGENT_BASE_URL = os.getenv("DWANI_AGENT_BASE_URL", "").rstrip("/")
+# Backend credentials (see services/credentials.py): an optional "module:Class" secrets provider, and the header
+# each stage's key is sent in, such as "tts=X-API-Key" (default: Authorization: Bearer; X-API-Key for agents).
+SECRETS_PROVIDER = os.getenv("DWANI_SECRETS_PROVIDER", "").strip()
+BACKEND_AUTH_HEADERS = dict(
+    (k.strip().lower(), v.strip())
+    for k, _, v in (item.partition("=") for item in os.getenv("DWANI_BACKEND_AUTH_HEADERS", "").split(","))
+    if k.strip() and v.strip()
+)
 LOG_FORMAT = os.getenv("DWANI_LOG_FORMAT", "json").strip().lower()
 
 
@@ -0,0 +1,78 @@
+from datetime import datetime
+from typing import Optional
+
+from sqlalchemy import Boolean, DateTime, Float, Integer, String, Text, func
+from sqlalchemy.orm import Mapped, mapped_column
+
+from auth_models import Base
+
+
+class ConversationTurn(Base):
+    __tablename__ = "conversation_turns"
+
+    id: Mapped[int] = mapped_column(Integer, primary_key=True, autoincrement=True)
+    # sha256 digest of X-Session-ID (as used for Redis keys); raw session IDs are never stored.
+    session_key: Mapped[Optional[str]] = mapped_column(String(64), index=True, nullable=True)
+    mode: Mapped[str] = mapped_column(String(16), nullable=False)
+    agent_name: Mapped[Optional[str]] = mapped_column(String(64), nullable=True)
+    language: Mapped[Optional[str]] = mapped_column(String(32), nullable=True)
+    input_type: Mapped[str] = mapped_column(String(8), nullable=False)
+    transcript: Mapped[str] = mapped_column(Text, nullable=False)
+    reply: Mapped[str] = mapped_column(Text, nullable=False)
+    latency_ms: Mapped[Optional[int]] = mapped_column(Integer, nullable=True)
+    # Derived fields (migration 0002); `python migrate.py backfill` fills older rows.
+    transcript_chars: Mapped[Optional[int]] = mapped_column(Integer, nullable=True)
+    reply_chars: Mapped[Optional[int]] = mapped_column(Integer, nullable=True)
+    cost_usd: Mapped[Optional[float]] = mapped_column(Float, nullable=True)
+    # X-Request-ID (or the client's correlation ID) of the request that made the turn (migration 0005).
+    request_id: Mapped[Optional[str]] = mapped_column(String(128), index=True, nullable=True)
+    # Reply audio the client received before the stream ended or it went away (migration 0006); null when not streamed.
+    playback_bytes: Mapped[Optional[int]] = mapped_column(Integer, nullable=True)
+    playback_ms: Mapped[Optional[int]] = mapped_column(Integer, nullable=True)
+    playback_interrupted: Mapped[Optional[bool]] = mapped_column(Boolean, nullable=True)
+    # What the turn can be segmented by (migration 0007; services/experiments.py): null when not known.
+    experiment: Mapped[Optional[str]] = mapped_column(String(128), index=True, nullable=True)
+    variant: Mapped[Optional[str]] = mapped_column(String(128), nullable=True)
+    provider: Mapped[Optional[str]] = mapped_column(String(128), nullable=True)
+    model: Mapped[Optional[str]] = mapped_column(String(128), nullable=True)
+    voice: Mapped[Optional[str]] = mapped_column(String(128), nullable=True)
+    # Tenant (services/tenants.py) the turn was served for (migration 0009); null for the deployment's own.
+    tenant: Mapped[Optional[str]] = mapped_column(String(64), index=True, nullable=True)
+    created_at: Mapped[datetime] = mapped_column(
+        DateTime(timezone=True),
+        server_default=func.now(),
+        index=True,
+        nullable=False,
+    )
+
+
+class ExportWatermark(Base):
+    __tablename__ = "export_watermarks"
+
+    name: Mapped[str] = mapped_column(String(64), primary_key=True)
+    last_id: Mapped[int] = mapped_column(Integer, default=0, nullable=False)
+    updated_at: Mapped[datetime] = mapped_column(
+        DateTime(timezone=True),
+        server_default=func.now(),
+        onupdate=func.now(),
+        nullable=False,
+    )
+
+
+class ConversationFlags(Base):
+    """Compliance state of one conversation (migration 0008; services/retention.py); no row means neither flag."""
+
+    __tablename__ = "conversation_flags"
+
+    session_key: Mapped[str] = mapped_column(String(64), primary_key=True)
+    # Exempt from the retention purge and from deletion while set.
+    legal_hold: Mapped[bool] = mapped_column(Boolean, default=False, nullable=False)
+    hold_reason: Mapped[Optional[str]] = mapped_column(String(255), nullable=True)
+    # Soft delete: hidden from reads now, erased DWANI_CONVERSATION_DELETE_GRACE_DAYS later unless restored.
+    deleted_at: Mapped[Optional[datetime]] = mapped_column(DateTime(timezone=True), index=True, nullable=True)
+    updated_at: Mapped[datetime] = mapped_column(
+        DateTime(timezone=True),
+        server_default=func.now(),
+        onupdate=func.now(),
+        nullable=False,
+    )
@@ -0,0 +1,355 @@
+from dataclasses import dataclass
+from datetime import datetime, timedelta, timezone
+from typing import Dict, List, Optional, Union
+
+from sqlalchemy import delete, func, or_, select, update
+from sqlalchemy.exc import IntegrityError
+
+from auth_store import db_session
+from config import COST_PER_1K_INPUT_CHARS, COST_PER_1K_OUTPUT_CHARS, logger
+from conversation_models import ConversationFlags, ConversationTurn, ExportWatermark
+
+
+class _AllTenants:
+    """Reads across every tenant's turns, for admin tools; API callers pass their own tenant instead."""
+
+
+ALL_TENANTS = _AllTenants()
+TenantScope = Union[Optional[str], _AllTenants]
+
+
+@dataclass
+class ConversationSummary:
+    session_key: str
+    turn_count: int
+    started_at: datetime
+    last_turn_at: datetime
+
+
+class LegalHold(Exception):
+    """The conversation is under legal hold and cannot be deleted."""
+
+
+@dataclass
+class UsageSummary:
+    turns: int
+    conversations: int
+    avg_latency_ms: Optional[float]
+    by_mode: Dict[str, int]
+    by_language: Dict[str, int]
+    by_input_type: Dict[str, int]
+
+
+def derived_fields(transcript: str, reply: str) -> Dict[str, object]:
+    """Columns computed from a turn's text; shared by record_turn and the migrate backfill."""
+    transcript_chars, reply_chars = len(transcript), len(reply)
+    cost = (transcript_chars * COST_PER_1K_INPUT_CHARS + reply_chars * COST_PER_1K_OUTPUT_CHARS) / 1000
+    return {"transcript_chars": transcript_chars, "reply_chars": reply_chars, "cost_usd": round(cost, 6)}
+
+
+def record_turn(
+    session_key: Optional[str],
+    mode: str,
+    agent_name: Optional[str],
+    language: Optional[str],
+    input_type: str,
+    transcript: str,
+    reply: str,
+    latency_ms: Optional[int],
+    request_id: Optional[str] = None,
+    experiment: Optional[str] = None,
+    variant: Optional[str] = None,
+    provider: Optional[str] = None,
+    model: Optional[str] = None,
+    voice: Optional[str] = None,
+    tenant: Optional[str] = None,
+) -> None:
+    """Best effort: a failed write is logged and never fails the user's request."""
+    try:
+        with db_session() as db:
+            db.add(
+                ConversationTurn(
+                    session_key=session_key,
+                    mode=mode,
+                    agent_name=agent_name if mode == "agent" else None,
+                    language=language,
+                    input_type=input_type,
+                    transcript=transcript,
+                    reply=reply,
+                    latency_ms=latency_ms,
+                    request_id=request_id,
+                    experiment=experiment,
+                    variant=variant,
+                    provider=provider,
+                    model=model,
+                    voice=voice,
+                    tenant=tenant,
+                    **derived_fields(transcript, reply),
+                )
+            )
+    except Exception as exc:
+        logger.warning("Failed to store conversation turn: %s", exc)
+
+
+def record_playback(request_id: str, delivered_bytes: int, delivered_ms: Optional[int], interrupted: bool) -> None:
+    """Best effort: note on request_id's turn how much of the reply audio the client received."""
+    try:
+        with db_session() as db:
+            db.execute(
+                update(ConversationTurn)
+                .where(ConversationTurn.request_id == request_id)
+                .values(playback_bytes=delivered_bytes, playback_ms=delivered_ms, playback_interrupted=interrupted)
+            )
+    except Exception as exc:
+        logger.warning("Failed to store playback of turn %s: %s", request_id, exc)
+
+
+def _window(stmt, since: Optional[datetime], until: Optional[datetime]):
+    if since is not None:
+        stmt = stmt.where(ConversationTurn.created_at >= since)
+    if until is not None:
+        stmt = stmt.where(ConversationTurn.created_at < until)
+    return stmt
+
+
+def _of_tenant(stmt, tenant: TenantScope):
+    """stmt limited to tenant's turns (None: the deployment's own); ALL_TENANTS leaves it as is."""
+    if isinstance(tenant, _AllTenants):
+        return stmt
+    return stmt.where(ConversationTurn.tenant.is_(None) if tenant is None else ConversationTurn.tenant == tenant)
+
+
+def _live(stmt):
+    """stmt without the turns of soft-deleted conversations."""
+    deleted = select(ConversationFlags.session_key).where(ConversationFlags.deleted_at.is_not(None))
+    return stmt.where(or_(ConversationTurn.session_key.is_(None), ConversationTurn.session_key.not_in(deleted)))
+
+
+def list_conversations(
+    limit: int = 20,
+    offset: int = 0,
+    since: Optional[datetime] = None,
+    until: Optional[datetime] = None,
+    tenant: TenantScope = ALL_TENANTS,
+) -> List[ConversationSummary]:
+    """Conversations (turns sharing a session) ordered by most recent activity."""
+    last_turn = func.max(ConversationTurn.created_at)
+    stmt = (
+        select(
+            ConversationTurn.session_key,
+            func.count(ConversationTurn.id),
+            func.min(ConversationTurn.created_at),
+            last_turn,
+        )
+        .where(ConversationTurn.session_key.is_not(None))
+        .group_by(ConversationTurn.session_key)
+        .order_by(last_turn.desc())
+        .limit(limit)
+        .offset(offset)
+    )
+    with db_session() as db:
+        rows = db.execute(_of_tenant(_live(_window(stmt, since, until)), tenant)).all()
+    return [ConversationSummary(key, count, first, last) for key, count, first, last in rows]
+
+
+def get_conversation(
+    session_key: str, include_deleted: bool = False, tenant: TenantScope = ALL_TENANTS
+) -> Optional[ConversationSummary]:
+    stmt = _of_tenant(select(
+        func.count(ConversationTurn.id),
+        func.min(ConversationTurn.created_at),
+        func.max(ConversationTurn.created_at),
+    ).where(ConversationTurn.session_key == session_key), tenant)
+    if not include_deleted:
+        stmt = _live(stmt)
+    with db_session() as db:
+        count, first, last = db.execute(stmt).one()
+    if not count:
+        return None
+    return ConversationSummary(session_key, count, first, last)
+
+
+def list_turns(
+    session_key: Optional[str] = None,
+    limit: int = 50,
+    offset: int = 0,
+    since: Optional[datetime] = None,
+    until: Optional[datetime] = None,
+    tenant: TenantScope = ALL_TENANTS,
+) -> List[ConversationTurn]:
+    stmt = _of_tenant(select(ConversationTurn), tenant)
+    if session_key is not None:
+        stmt = stmt.where(ConversationTurn.session_key == session_key).order_by(ConversationTurn.id)
+    else:
+        stmt = stmt.order_by(ConversationTurn.id.desc())
+    with db_session() as db:
+        return list(db.execute(_live(_window(stmt, since, until)).limit(limit).offset(offset)).scalars().all())
+
+
+def get_turn(turn_id: int) -> Optional[ConversationTurn]:
+    with db_session() as db:
+        return db.get(ConversationTurn, turn_id)
+
+
+def turns_after(last_id: int, limit: int) -> List[ConversationTurn]:
+    stmt = select(ConversationTurn).where(ConversationTurn.id > last_id).order_by(ConversationTurn.id).limit(limit)
+    with db_session() as db:
+        return list(db.execute(stmt).scalars().all())
+
+
+def conversation_flags(session_key: str) -> Optional[ConversationFlags]:
+    with db_session() as db:
+        return db.get(ConversationFlags, session_key)
+
+
+def _flags(db, session_key: str) -> ConversationFlags:
+    row = db.get(ConversationFlags, session_key)
+    if row is None:
+        row = ConversationFlags(session_key=session_key, legal_hold=False)
+        db.add(row)
+    return row
+
+
+def set_legal_hold(session_key: str, held: bool, reason: Optional[str] = None) -> ConversationFlags:
+    """Place or release a legal hold; a held conversation is never purged or deleted."""
+    with db_session() as db:
+        row = _flags(db, session_key)
+        row.legal_hold = held
+        row.hold_reason = reason if held else None
+    return row
+
+
+def soft_delete(session_key: str, now: Optional[datetime] = None) -> ConversationFlags:
+    """Hide the conversation and start its grace period; LegalHold while it is held. Deleting again keeps the first time."""
+    with db_session() as db:
+        row = _flags(db, session_key)
+        if row.legal_hold:
+            raise LegalHold(session_key)
+        if row.deleted_at is None:
+            row.deleted_at = now or datetime.now(timezone.utc)
+    return row
+
+
+def restore(session_key: str) -> Optional[ConversationFlags]:
+    """Undo a soft delete still in its grace period; None when the conversation was not deleted."""
+    with db_session() as db:
+        row = db.get(ConversationFlags, session_key)
+        if row is None or row.deleted_at is None:
+            return None
+        row.deleted_at = None
+    return row
+
+
+def erase_conversation(session_key: str) -> int:
+    """Erase the conversation's turns now, without a grace period; LegalHold while it is held. Returns the turns erased."""
+    with db_session() as db:
+        row = db.get(ConversationFlags, session_key)
+        if row is not None and row.legal_hold:
+            raise LegalHold(session_key)
+        erased = db.execute(delete(ConversationTurn).where(ConversationTurn.session_key == session_key)).rowcount
+        db.execute(delete(ConversationFlags).where(ConversationFlags.session_key == session_key))
+    return erased
+
+
+def purge_conversations(retention_days: int, grace_days: int, now: Optional[datetime] = None) -> Dict[str, int]:
+    """Erase turns past retention_days (0 = none) and soft-deleted conversations past grace_days, except held ones.
+
+    Returns the turns erased as {"expired": n, "deleted": n}.
+    """
+    now = now or datetime.now(timezone.utc)
+    held = select(ConversationFlags.session_key).where(ConversationFlags.legal_hold.is_(True))
+    purged = {"expired": 0, "deleted": 0}
+    with db_session() as db:
+        due = list(db.execute(
+            select(ConversationFlags.session_key).where(
+                ConversationFlags.deleted_at.is_not(None),
+                ConversationFlags.deleted_at < now - timedelta(days=max(0, grace_days)),
+                ConversationFlags.legal_hold.is_(False),
+            )
+        ).scalars())
+        if due:
+            purged["deleted"] = db.execute(delete(ConversationTurn).where(ConversationTurn.session_key.in_(due))).rowcount
+            db.execute(delete(ConversationFlags).where(ConversationFlags.session_key.in_(due)))
+        if retention_days > 0:
+            purged["expired"] = db.execute(
+                delete(ConversationTurn).where(
+                    ConversationTurn.created_at < now - timedelta(days=retention_days),
+                    or_(ConversationTurn.session_key.is_(None), ConversationTurn.session_key.not_in(held)),
+                )
+            ).rowcount
+        # Rows left with neither flag (a released hold) mean the same as no row.
+        db.execute(delete(ConversationFlags).where(
+            ConversationFlags.legal_hold.is_(False), ConversationFlags.deleted_at.is_(None)
+        ))
+    return purged
+
+
+def export_watermark(name: str) -> int:
+    with db_session() as db:
+        row = db.get(ExportWatermark, name)
+        if row is not None:
+            return row.last_id
+    try:
+        with db_session() as db:
+            db.add(ExportWatermark(name=name, last_id=0))
+    except IntegrityError:
+        pass  # created concurrently by another exporter
+    return 0
+
+
+def advance_export_watermark(name: str, expected: int, last_id: int) -> bool:
+    """Compare-and-set so concurrent exporters never both claim the same batch."""
+    stmt = (
+        update(ExportWatermark)
+        .where(ExportWatermark.name == name, ExportWatermark.last_id == expected)
+        .values(last_id=last_id)
+    )
+    with db_session() as db:
+        return db.execute(stmt).rowcount == 1
+
+
+def _counts(column, since: Optional[datetime], until: Optional[datetime], tenant: TenantScope) -> Dict[str, int]:
+    stmt = _of_tenant(select(column, func.count(ConversationTurn.id)).group_by(column), tenant)
+    with db_session() as db:
+        return {str(key) if key is not None else "unknown": count for key, count in db.execute(_window(stmt, since, until)).all()}
+
+
+def usage_summary(
+    since: Optional[datetime] = None, until: Optional[datetime] = None, tenant: TenantScope = ALL_TENANTS
+) -> UsageSummary:
+    stmt = _of_tenant(select(
+        func.count(ConversationTurn.id),
+        func.count(func.distinct(ConversationTurn.session_key)),
+        func.avg(ConversationTurn.latency_ms),
+    ), tenant)
+    with db_session() as db:
+        turns, conversations, avg_latency = db.execute(_window(stmt, since, until)).one()
+    return UsageSummary(
+        turns=turns,
+        conversations=conversations,
+        avg_latency_ms=float(avg_latency) if avg_latency is not None else None,
+        by_mode=_counts(ConversationTurn.mode, since, until, tenant),
+        by_language=_counts(ConversationTurn.language, since, until, tenant),
+        by_input_type=_counts(ConversationTurn.input_type, since, until, tenant),
+    )
+
+
+def daily_activity(days: int = 30, tenant: TenantScope = ALL_TENANTS) -> List[Dict[str, object]]:
+    """Turns per UTC day for the last N days, oldest first; days without turns are omitted."""
+    since = datetime.now(timezone.utc) - timedelta(days=days)
+    stmt = select(ConversationTurn.created_at, ConversationTurn.latency_ms).where(ConversationTurn.created_at >= since)
+    stmt = _of_tenant(stmt, tenant)
+    buckets: Dict[str, List[Optional[int]]] = {}
+    with db_session() as db:
+        # Bucketed in Python: date truncation differs between SQLite and Postgres.
+        for created_at, latency_ms in db.execute(stmt).all():
+            buckets.setdefault(created_at.date().isoformat(), []).append(latency_ms)
+    result = []
+    for day in sorted(buckets):
+        latencies = [v for v in buckets[day] if v is not None]
+        result.append({
+            "day": day,
+            "turns": len(buckets[day]),
+            "avg_latency_ms": sum(latencies) / len(latencies) if latencies else None,
+        })
+    return result
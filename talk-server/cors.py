@@ -0,0 +1,96 @@
+"""CORS policy: which browser origins may call the gateway, with which headers and credentials.
+
+Origins are exact ("https://talk.dwani.ai") or contain "*" wildcards ("https://*.dwani.ai");
+a lone "*" allows any origin and is answered with a literal "*", which browsers only accept
+without credentials, so credentials are never advertised for it.
+"""
+import fnmatch
+from dataclasses import dataclass
+from typing import Dict, Optional, Tuple
+
+from config import (
+    CORS_ALLOW_CREDENTIALS,
+    CORS_ALLOWED_HEADERS,
+    CORS_ALLOWED_ORIGINS,
+    CORS_EXPOSE_HEADERS,
+    CORS_MAX_AGE,
+    logger,
+)
+
+_METHODS = "GET, POST, PUT, DELETE, OPTIONS, HEAD"
+
+
+def parse_list(raw: str) -> Tuple[str, ...]:
+    return tuple(item.strip() for item in (raw or "").split(",") if item.strip())
+
+
+@dataclass(frozen=True)
+class CORSPolicy:
+    origins: Tuple[str, ...]
+    headers: Tuple[str, ...]
+    expose_headers: Tuple[str, ...] = ()
+    max_age: int = 86400
+    allow_credentials: bool = True
+
+    @property
+    def any_origin(self) -> bool:
+        return "*" in self.origins
+
+    def allows(self, origin: Optional[str]) -> bool:
+        if not origin:
+            return False
+        origin = origin.rstrip("/").lower()
+        for pattern in self.origins:
+            pattern = pattern.rstrip("/").lower()
+            if pattern == "*" or pattern == origin:
+                return True
+            # fnmatch's "*" also crosses "/" and ":", so wildcards stay within the host part of the URL.
+            if "*" in pattern and fnmatch.fnmatchcase(origin, pattern) and origin.count("/") == pattern.count("/"):
+                return True
+        return False
+
+    def _origin_headers(self, origin: str) -> Dict[str, str]:
+        if self.any_origin:
+            return {"Access-Control-Allow-Origin": "*"}
+        headers = {"Access-Control-Allow-Origin": origin, "Vary": "Origin"}
+        if self.allow_credentials:
+            headers["Access-Control-Allow-Credentials"] = "true"
+        return headers
+
+    def preflight_headers(self, origin: str, requested_headers: Optional[str] = None) -> Dict[str, str]:
+        headers = self._origin_headers(origin)
+        allow_headers = ", ".join(self.headers)
+        if "*" in self.headers:
+            allow_headers = requested_headers or ""
+            headers["Vary"] = "Origin, Access-Control-Request-Headers"
+        headers.update({
+            "Access-Control-Allow-Methods": _METHODS,
+            "Access-Control-Allow-Headers": allow_headers,
+            "Access-Control-Max-Age": str(self.max_age),
+        })
+        return headers
+
+    def response_headers(self, origin: str) -> Dict[str, str]:
+        headers = self._origin_headers(origin)
+        if self.expose_headers:
+            headers["Access-Control-Expose-Headers"] = ", ".join(self.expose_headers)
+        return headers
+
+
+def load_policy(
+    origins: str = CORS_ALLOWED_ORIGINS,
+    headers: str = CORS_ALLOWED_HEADERS,
+    expose_headers: str = CORS_EXPOSE_HEADERS,
+    max_age: int = CORS_MAX_AGE,
+    allow_credentials: bool = CORS_ALLOW_CREDENTIALS,
+) -> CORSPolicy:
+    policy = CORSPolicy(
+        origins=parse_list(origins),
+        headers=parse_list(headers),
+        expose_headers=parse_list(expose_headers),
+        max_age=max(0, max_age),
+        allow_credentials=allow_credentials,
+    )
+    if policy.any_origin and allow_credentials:
+        logger.warning("DWANI_CORS_ALLOWED_ORIGINS=* cannot be combined with credentials; cookies will not be sent cross-origin")
+    return policy
@@ -1,12 +1,16 @@
 """Shared dependencies (e.g. rate limiter, auth)."""
+import hmac
 import os
 from typing import Optional
 
-from fastapi import Header, HTTPException, Request
+from fastapi import Header, HTTPException, Request, WebSocket
 from slowapi import Limiter
 from slowapi.util import get_remote_address
 
 from auth_store import AUTH_COOKIE_NAME, resolve_user_from_session
+from config import DEMO_MODE
+from services.demo import valid_demo_key
+from services.tenants import current_tenant_settings
 
 limiter = Limiter(key_func=get_remote_address)
 
@@ -15,20 +19,74 @@ def require_api_key(
     authorization: Optional[str] = Header(default=None),
     x_api_key: Optional[str] = Header(default=None, alias="X-API-Key"),
 ) -> None:
-    """Optional auth gate: enforced only when DWANI_API_KEY is configured."""
-    configured_key = os.getenv("DWANI_API_KEY", "").strip()
-    if not configured_key:
-        return
-
+    """Optional auth gate: enforced when DWANI_API_KEY is configured, in demo mode, or for a tenant with API keys."""
     bearer_key = None
     if authorization and authorization.lower().startswith("bearer "):
         bearer_key = authorization[7:].strip()
     provided = x_api_key or bearer_key
 
-    if not provided or provided != configured_key:
+    tenant = current_tenant_settings()
+    if tenant is not None and tenant.api_keys:
+        if not tenant.has_key(provided):
+            raise HTTPException(status_code=401, detail="Invalid or missing API key")
+        return
+    configured_key = os.getenv("DWANI_API_KEY", "").strip()
+    if not configured_key and not DEMO_MODE:
+        return
+    if valid_demo_key(provided):
+        return
+    if not provided or not configured_key or provided != configured_key:
         raise HTTPException(status_code=401, detail="Invalid or missing API key")
 
 
+def request_api_key(request: Request) -> Optional[str]:
+    """The API key a request carries (X-API-Key or Bearer), whether or not it is valid."""
+    key = (request.headers.get("X-API-Key") or "").strip()
+    if key:
+        return key
+    authorization = request.headers.get("authorization") or ""
+    if authorization.lower().startswith("bearer "):
+        return authorization[7:].strip() or None
+    return None
+
+
+def websocket_api_key(websocket: WebSocket) -> Optional[str]:
+    """The API key a WebSocket carries; browsers cannot set headers there, so ?api_key= is accepted too."""
+    authorization = websocket.headers.get("Authorization") or ""
+    bearer_key = authorization[7:].strip() if authorization.lower().startswith("bearer ") else None
+    return websocket.headers.get("X-API-Key") or bearer_key or websocket.query_params.get("api_key")
+
+
+def websocket_api_key_ok(websocket: WebSocket) -> bool:
+    """require_api_key for WebSockets, once the connection's tenant is applied."""
+    provided = websocket_api_key(websocket)
+    tenant = current_tenant_settings()
+    if tenant is not None and tenant.api_keys:
+        return tenant.has_key(provided)
+    configured_key = os.getenv("DWANI_API_KEY", "").strip()
+    if not configured_key and not DEMO_MODE:
+        return True
+    return valid_demo_key(provided) or bool(configured_key and provided == configured_key)
+
+
+def require_admin(
+    authorization: Optional[str] = Header(default=None),
+    x_admin_token: Optional[str] = Header(default=None, alias="X-Admin-Token"),
+) -> None:
+    """Admin endpoints need DWANI_ADMIN_TOKEN (never the API key); they 404 while it is unset."""
+    configured_token = os.getenv("DWANI_ADMIN_TOKEN", "").strip()
+    if not configured_token:
+        raise HTTPException(status_code=404, detail="Not Found")
+
+    bearer_token = None
+    if authorization and authorization.lower().startswith("bearer "):
+        bearer_token = authorization[7:].strip()
+    provided = x_admin_token or bearer_token
+
+    if not provided or not hmac.compare_digest(provided.encode("utf-8"), configured_token.encode("utf-8")):
+        raise HTTPException(status_code=401, detail="Invalid or missing admin token")
+
+
 async def get_optional_user(request: Request):
     session_id = request.cookies.get(AUTH_COOKIE_NAME, "")
     if not session_id:
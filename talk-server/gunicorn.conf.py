@@ -0,0 +1,34 @@
+"""Gunicorn settings sized from the container's CPU quota. Override with DWANI_* env vars (HTTP/2: hypercorn.conf.py)."""
+import os
+import ssl
+
+from runtime_tuning import apply_gc_settings, worker_count
+
+# Comma-separated listeners: host:port, unix:/path/to.sock or fd://N. Sockets passed by systemd
+# socket activation (LISTEN_FDS) are used instead of these.
+bind = [address.strip() for address in os.getenv("DWANI_BIND", "0.0.0.0:8000").split(",") if address.strip()]
+# Permissions of Unix sockets Gunicorn creates, as a umask (octal; 0o007 = owner and group only).
+umask = int(os.getenv("DWANI_SOCKET_UMASK", "0"), 8)
+worker_class = "uvicorn.workers.UvicornWorker"
+workers = worker_count()
+# talk-server writes its own JSON access log (DWANI_ACCESS_LOG); Gunicorn's is only used when that is off.
+accesslog = None if os.getenv("DWANI_ACCESS_LOG", "1") == "1" else "-"
+errorlog = "-"
+graceful_timeout = int(os.getenv("DWANI_GRACEFUL_TIMEOUT", "30"))
+# Seconds an idle keep-alive connection stays open.
+keepalive = int(os.getenv("DWANI_KEEPALIVE_SECONDS", "5"))
+# Recycle workers after N requests to bound memory growth from audio buffering (0 disables).
+max_requests = int(os.getenv("DWANI_MAX_REQUESTS", "0"))
+max_requests_jitter = int(os.getenv("DWANI_MAX_REQUESTS_JITTER", "0"))
+
+# Native TLS: serve HTTPS directly when a certificate is configured. Setting
+# DWANI_TLS_CLIENT_CA_FILE also requires clients to present a certificate signed by it.
+certfile = os.getenv("DWANI_TLS_CERT_FILE") or None
+keyfile = os.getenv("DWANI_TLS_KEY_FILE") or None
+if os.getenv("DWANI_TLS_CLIENT_CA_FILE"):
+    ca_certs = os.getenv("DWANI_TLS_CLIENT_CA_FILE")
+    cert_reqs = ssl.CERT_REQUIRED
+
+
+def post_worker_init(worker):
+    apply_gc_settings()
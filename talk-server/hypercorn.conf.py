@@ -0,0 +1,27 @@
+"""Hypercorn settings, used instead of gunicorn.conf.py when DWANI_HTTP2=1 (see talk.py serve).
+
+Hypercorn speaks HTTP/2: over TLS it is negotiated with ALPN, and without TLS (DWANI_H2C=1,
+for internal networks) clients may use h2c, by prior knowledge or an Upgrade from HTTP/1.1.
+"""
+import os
+import ssl
+
+from runtime_tuning import worker_count
+
+# The same listeners as Gunicorn: host:port, unix:/path/to.sock or fd://N.
+bind = [address.strip() for address in os.getenv("DWANI_BIND", "0.0.0.0:8000").split(",") if address.strip()]
+umask = int(os.getenv("DWANI_SOCKET_UMASK", "0"), 8)
+workers = worker_count()
+accesslog = None if os.getenv("DWANI_ACCESS_LOG", "1") == "1" else "-"
+errorlog = "-"
+graceful_timeout = int(os.getenv("DWANI_GRACEFUL_TIMEOUT", "30"))
+keep_alive_timeout = int(os.getenv("DWANI_KEEPALIVE_SECONDS", "5"))
+# Concurrent requests per HTTP/2 connection, e.g. one client's parallel TTS streams.
+h2_max_concurrent_streams = int(os.getenv("DWANI_H2_MAX_STREAMS", "100"))
+alpn_protocols = ["h2", "http/1.1"]
+
+certfile = os.getenv("DWANI_TLS_CERT_FILE") or None
+keyfile = os.getenv("DWANI_TLS_KEY_FILE") or None
+if os.getenv("DWANI_TLS_CLIENT_CA_FILE"):
+    ca_certs = os.getenv("DWANI_TLS_CLIENT_CA_FILE")
+    verify_mode = ssl.CERT_REQUIRED
@@ -1,33 +1,94 @@
 import argparse
 import os
-import uuid
+import ssl
+import time
 from typing import Dict, Optional
 
 import uvicorn
-from fastapi import FastAPI, HTTPException, Request
-from fastapi.middleware.cors import CORSMiddleware
+from fastapi import Depends, FastAPI, HTTPException, Request
+from fastapi.exceptions import RequestValidationError
 from fastapi.responses import JSONResponse, Response
 from prometheus_fastapi_instrumentator import Instrumentator
 from slowapi.errors import RateLimitExceeded
 from slowapi.util import get_remote_address
+from starlette.concurrency import run_in_threadpool
+from starlette.exceptions import HTTPException as StarletteHTTPException
 
 from auth_store import init_auth_db, log_auth_db_config
-from config import logger
-from deps import limiter
-from routers import auth, chat, chess, health, warehouse
+from config import (
+    ACCESS_LOG,
+    ACCESS_LOG_EXCLUDE,
+    COMPRESSION,
+    DEBUG_TRACEMALLOC_FRAMES,
+    DEMO_DAILY_QUOTA,
+    DEMO_MODE,
+    PRIORITY_HEADER,
+    RATE_LIMIT_PER_API_KEY,
+    RATE_LIMIT_PER_IP,
+    REQUEST_TIMEOUT,
+    SERVER_TIMING,
+    logger,
+)
+from cors import load_policy
+from deps import limiter, request_api_key
+from ratelimit import TokenBucketLimiter, api_key_bucket, parse_rate, retry_after_header
+from runtime_tuning import apply_gc_settings
+from routers import admin, analytics, artifacts, audio, auth, batch, chat, chess, conversations, debug, demo, health, jobs, mcp, read, session, share, stream, telegram, telephony, usage, warehouse, webrtc
+from services.build_info import VERSION
+from services.chaos import install as install_chaos
+from services.compression import JSONCompression
+from services.concurrency import use_priority
+from services.corrections import install as install_corrections
+from services.deadline import HEADER as DEADLINE_HEADER, budget_headers, set_deadline
+from services.demo import install as install_demo
+from services.diagnostics import start_tracing
+from services.discord_bot import start as start_discord, stop as stop_discord
+from services.error_codes import code_for, code_for_status, error_fields, start_error_language
+from services.errors import StageError
+from services.experiments import EXPERIMENT_HEADER, MODEL_HEADER, VARIANT_HEADER, start_tags
+from services.exporter import parquet_exporter
+from services.flags import use_flags
+from services.hooks import load_plugins
+from services.injection import install as install_injection_guard
+from services.loudness import install as install_loudness
+from services.moderation import install as install_moderation
+from services.preprocess import HEADER as PREPROCESS_HEADER, install as install_preprocess, use_preprocessing
+from services.pii import install as install_pii_redaction
+from services.recorder import install as install_recorder
+from services.retention import retention_sweeper
+from services.telegram import start as start_telegram, stop as stop_telegram
+from services.text_normalization import install as install_text_normalization
+from services.transliteration import install as install_transliteration
+from services.uploads import apply_upload_limits, check_form_fields
+from services.warmup import warmer
+from services.webrtc import close_all as close_webrtc_calls
+from services.http_client import close_http_client
+from services.credentials import install_log_filter as install_redaction_filter
+from services.ids import bind_request_id, client_request_id, install_log_filter, new_id, reset_request_id
+from services.jobs import job_manager
+from services.latency import server_timing, track_request
+from services.lifecycle import LifecycleContext, lifecycle
+from services.messages import brand_for_host
+from services import discovery, drain, mock
+from services.usage import save_usage, track_usage, usage_account
+from services.sharding import ForwardError, forward_request, forwarded_by_peer, session_owner
+from services.tenants import current_tenant_settings, tenant_for_request, use_tenant_settings
+from services.tools import check_tools
 
 # App
 app = FastAPI(
     title="dwani.ai API",
     description="Conversational AI Agents for Indian languages — speech-to-speech, agents, and multimodal inference.",
-    version="1.0.0",
+    version=VERSION,
     redirect_slashes=False,
+    dependencies=[Depends(check_form_fields)],
     openapi_tags=[
         {"name": "Chat", "description": "Chat-related endpoints"},
         {"name": "Audio", "description": "Audio processing and TTS endpoints"},
         {"name": "Translation", "description": "Text translation endpoints"},
         {"name": "Warehouse", "description": "Warehouse simulation endpoints"},
         {"name": "Chess", "description": "Chess gameplay endpoints"},
+        {"name": "Usage", "description": "Usage per API key, for chargeback"},
     ],
 )
 app.state.limiter = limiter
@@ -62,12 +123,60 @@ def _setup_metrics() -> None:
 
 _setup_tracing()
 _setup_metrics()
+install_log_filter()
+install_redaction_filter()
+
+
+# Shutdown hooks run newest first: the shared HTTP client closes after the workers that use it.
+lifecycle.on_shutdown(close_http_client)
+lifecycle.on_start(discovery.start)
+lifecycle.on_shutdown(discovery.stop)
+lifecycle.on_start(job_manager.start)
+lifecycle.on_shutdown(job_manager.stop)
+lifecycle.on_start(parquet_exporter.start)
+lifecycle.on_shutdown(parquet_exporter.stop)
+lifecycle.on_start(retention_sweeper.start)
+lifecycle.on_shutdown(retention_sweeper.stop)
+lifecycle.on_start(start_telegram)
+lifecycle.on_shutdown(stop_telegram)
+lifecycle.on_start(warmer.start)
+lifecycle.on_shutdown(warmer.stop)
+lifecycle.on_start(start_discord)
+lifecycle.on_shutdown(stop_discord)
+lifecycle.on_shutdown(close_webrtc_calls)
 
 
 @app.on_event("startup")
 async def validate_required_env() -> None:
     init_auth_db()
     log_auth_db_config()
+    apply_upload_limits()
+    # First, so no recording, hook or log line sees a transcript before its PII is redacted.
+    install_pii_redaction()
+    # Ahead of plugin hooks, so recordings show inputs as received and outputs as the backends return them.
+    install_recorder()
+    # After the recorder, which keeps what ASR heard, and ahead of plugins, which see the corrected transcript.
+    install_corrections()
+    # Plugins' before_asr hooks see the trimmed, normalized audio that ASR gets.
+    install_preprocess()
+    load_plugins()
+    # After plugins, so their before_tts hooks see the reply as the LLM wrote it, and ahead of
+    # text normalization, which reads the reply in its own script.
+    install_transliteration()
+    install_text_normalization()
+    # After plugins, which may register tools of their own.
+    check_tools()
+    install_moderation()
+    install_injection_guard()
+    install_chaos()
+    install_loudness()
+    # Last, so the watermark goes on the audio as it is sent.
+    install_demo()
+    start_tracing(DEBUG_TRACEMALLOC_FRAMES)
+    await lifecycle.start(LifecycleContext("server"))
+    if mock.enabled():
+        logger.warning("Mock backends: ASR, LLM and TTS answers are canned and nothing is sent upstream")
+        return
     if os.getenv("DWANI_ENFORCE_ENV", "0") != "1":
         return
     required = [
@@ -82,14 +191,28 @@ async def validate_required_env() -> None:
         raise RuntimeError(f"Missing required environment variables: {', '.join(missing)}")
 
 
-def _error_response(status_code: int, message: str, request_id: str = "", details: Optional[Dict] = None) -> JSONResponse:
-    rid = request_id or str(uuid.uuid4())
+@app.on_event("shutdown")
+async def stop_background_workers() -> None:
+    await lifecycle.shutdown()
+
+
+def _error_response(
+    status_code: int,
+    message: str,
+    request_id: str = "",
+    details: Optional[Dict] = None,
+    stage: Optional[str] = None,
+    error_code: Optional[str] = None,
+) -> JSONResponse:
+    rid = request_id or new_id("request")
     body = {
         "error": {
             "code": str(status_code),
             "message": message,
+            "stage": stage,
             "request_id": rid,
             "details": details or {},
+            **error_fields(error_code or code_for_status(status_code)),
         },
         "detail": message,
     }
@@ -97,7 +220,7 @@ def _error_response(status_code: int, message: str, request_id: str = "", detail
 
 
 async def rate_limit_handler(request: Request, exc: RateLimitExceeded) -> JSONResponse:
-    rid = getattr(request.state, "request_id", str(uuid.uuid4()))
+    rid = getattr(request.state, "request_id", new_id("request"))
     resp = _error_response(429, "Rate limit exceeded. Try again later.", rid, {"detail": str(getattr(exc, "detail", ""))})
     resp.headers["Retry-After"] = "60"
     return resp
@@ -108,72 +231,169 @@ app.add_exception_handler(RateLimitExceeded, rate_limit_handler)
 
 @app.exception_handler(HTTPException)
 async def http_exception_handler(request: Request, exc: HTTPException) -> JSONResponse:
-    request_id = getattr(request.state, "request_id", str(uuid.uuid4()))
+    request_id = getattr(request.state, "request_id", new_id("request"))
     detail = exc.detail if isinstance(exc.detail, str) else str(exc.detail)
-    return _error_response(exc.status_code, detail, request_id)
+    stage = exc.stage if isinstance(exc, StageError) else None
+    # details.stage predates error.stage; kept for existing clients.
+    details = {"stage": stage, **exc.details} if stage else getattr(exc, "details", None)
+    resp = _error_response(exc.status_code, detail, request_id, details, stage, code_for(exc))
+    if exc.headers:
+        resp.headers.update(exc.headers)
+    return resp
 
 
-# CORS
-_CORS_EXPLICIT_ORIGINS = [
-    "https://dwani.ai",
-    "https://talk.dwani.ai",
-    "http://localhost:11080",
-    "http://localhost:5173",
-    "http://localhost",
-    "http://127.0.0.1:5173",
-    "http://127.0.0.1:80",
-]
+@app.exception_handler(StarletteHTTPException)
+async def starlette_http_exception_handler(request: Request, exc: StarletteHTTPException) -> JSONResponse:
+    """Routing errors (404 unknown path, 405 wrong method) in the same envelope."""
+    request_id = getattr(request.state, "request_id", new_id("request"))
+    resp = _error_response(exc.status_code, str(exc.detail), request_id)
+    if exc.headers:
+        resp.headers.update(exc.headers)
+    return resp
 
 
-def _cors_allow_origin(origin: Optional[str]) -> bool:
-    if not origin:
-        return False
-    if origin in _CORS_EXPLICIT_ORIGINS:
-        return True
-    if origin.startswith("https://") and origin.endswith(".dwani.ai"):
-        return True
-    if "dwani-" in origin and ".hf.space" in origin and origin.startswith("https://"):
-        return True
-    return False
+@app.exception_handler(RequestValidationError)
+async def validation_exception_handler(request: Request, exc: RequestValidationError) -> JSONResponse:
+    request_id = getattr(request.state, "request_id", new_id("request"))
+    errors = [
+        {"loc": list(e.get("loc", ())), "msg": e.get("msg", ""), "type": e.get("type", "")}
+        for e in exc.errors()
+    ]
+    return _error_response(422, "Request validation failed", request_id, {"errors": errors})
 
 
 @app.middleware("http")
-async def cors_middleware(request: Request, call_next):
-    origin = request.headers.get("origin")
-    if request.method == "OPTIONS" and _cors_allow_origin(origin):
-        return Response(
-            status_code=200,
-            headers={
-                "Access-Control-Allow-Origin": origin,
-                "Access-Control-Allow-Methods": "GET, POST, OPTIONS, HEAD",
-                "Access-Control-Allow-Headers": "Content-Type, X-Session-ID, X-Request-ID, X-API-Key, Authorization",
-                "Access-Control-Allow-Credentials": "true",
-                "Access-Control-Max-Age": "86400",
-            },
-        )
+async def forward_session_to_owner(request: Request, call_next):
+    session_id = (request.headers.get("X-Session-ID") or "").strip()
+    if session_id and request.url.path.startswith("/v1/") and not forwarded_by_peer(request):
+        owner = session_owner(session_id)
+        if owner:
+            try:
+                return await forward_request(request, owner)
+            except ForwardError as exc:
+                if not exc.replayable:
+                    # Part of the body went to the owner and cannot be read again here.
+                    logger.error("Forwarding to session owner %s failed mid-request: %s", owner, exc)
+                    return _error_response(502, "Session owner could not be reached", getattr(request.state, "request_id", ""))
+                logger.warning("Forwarding to session owner %s failed; serving locally: %s", owner, exc)
+    return await call_next(request)
+
+
+_RATE_LIMIT_EXEMPT_PATHS = {"/health", "/ready", "/startup", "/metrics"}
+_ip_rate = parse_rate(RATE_LIMIT_PER_IP)
+_key_rate = parse_rate(RATE_LIMIT_PER_API_KEY)
+ip_limiter = TokenBucketLimiter(*_ip_rate) if _ip_rate else None
+key_limiter = TokenBucketLimiter(*_key_rate) if _key_rate else None
+# Demo mode: DWANI_DEMO_DAILY_QUOTA pipeline (POST /v1/...) requests per IP, refilled over a day.
+demo_quota = TokenBucketLimiter(DEMO_DAILY_QUOTA, DEMO_DAILY_QUOTA / 86400) if DEMO_MODE and DEMO_DAILY_QUOTA > 0 else None
+
+
+@app.middleware("http")
+async def token_bucket_rate_limit(request: Request, call_next):
+    if request.method == "OPTIONS" or request.url.path in _RATE_LIMIT_EXEMPT_PATHS:
+        return await call_next(request)
+    wait = 0.0
+    # Requests a peer relayed and signed (services/sharding.py) were already limited on the replica that received them.
+    relayed = forwarded_by_peer(request)
+    if ip_limiter is not None and not relayed:
+        wait = ip_limiter.acquire(get_remote_address(request))
+    if not wait and demo_quota is not None and not relayed and request.method == "POST" and request.url.path.startswith("/v1/"):
+        wait = demo_quota.acquire(get_remote_address(request))
+    api_key = request_api_key(request)
+    if not wait and api_key and key_limiter is not None:
+        wait = key_limiter.acquire(api_key_bucket(api_key))
+    tenant = current_tenant_settings()
+    if not wait and tenant is not None and not relayed:
+        wait = tenant.acquire(pipeline=request.method == "POST" and request.url.path.startswith("/v1/"))
+    if wait > 0:
+        rid = getattr(request.state, "request_id", "")
+        resp = _error_response(429, "Rate limit exceeded. Try again later.", rid)
+        resp.headers["Retry-After"] = retry_after_header(wait)
+        return resp
+    return await call_next(request)
+
+
+def _request_deadline_seconds(request: Request) -> Optional[float]:
+    """Smallest of DWANI_REQUEST_TIMEOUT, the client's X-Request-Timeout (seconds) and X-Deadline-Ms."""
+    candidates = [float(REQUEST_TIMEOUT)] if REQUEST_TIMEOUT > 0 else []
+    for header, scale in (("X-Request-Timeout", 1.0), (DEADLINE_HEADER, 0.001)):
+        raw = (request.headers.get(header) or "").strip()
+        if raw:
+            try:
+                value = float(raw) * scale
+            except ValueError:
+                value = 0.0
+            if value > 0:
+                candidates.append(value)
+    return min(candidates) if candidates else None
+
+
+@app.middleware("http")
+async def request_deadline(request: Request, call_next):
+    set_deadline(_request_deadline_seconds(request))
     response = await call_next(request)
-    if _cors_allow_origin(origin):
-        response.headers["Access-Control-Allow-Origin"] = origin
-    response.headers["Access-Control-Allow-Methods"] = "GET, POST, OPTIONS, HEAD"
-    response.headers["Access-Control-Allow-Headers"] = "Content-Type, X-Session-ID, X-Request-ID, X-API-Key, Authorization"
-    response.headers["Access-Control-Allow-Credentials"] = "true"
-    response.headers["Access-Control-Max-Age"] = "86400"
+    response.headers.update(budget_headers())
     return response
 
 
-app.add_middleware(
-    CORSMiddleware,
-    allow_origins=_CORS_EXPLICIT_ORIGINS,
-    allow_credentials=True,
-    allow_methods=["*"],
-    allow_headers=["*"],
-)
+@app.middleware("http")
+async def track_in_flight(request: Request, call_next):
+    """Count pipeline requests until their reply is sent, for POST /admin/drain (services/drain.py)."""
+    if not request.url.path.startswith("/v1/"):
+        return await call_next(request)
+    with drain.tracked():
+        response = await call_next(request)
+    response.body_iterator = drain.tracked_body(response.body_iterator)
+    return response
+
+
+@app.middleware("http")
+async def apply_tenant(request: Request, call_next):
+    """Serve the request with the settings of the tenant its API key or Host names (services/tenants.py)."""
+    use_tenant_settings(tenant_for_request(request_api_key(request), request.headers.get("host")))
+    return await call_next(request)
+
+
+@app.middleware("http")
+async def apply_priority(request: Request, call_next):
+    """Queue the request as interactive or batch work, by its API key and header (services/concurrency.py)."""
+    use_priority(request_api_key(request), request.headers.get(PRIORITY_HEADER))
+    return await call_next(request)
+
+
+@app.middleware("http")
+async def apply_feature_flags(request: Request, call_next):
+    """Judge feature flags by the request's API key and tenant (services/flags.py)."""
+    use_flags(request_api_key(request))
+    return await call_next(request)
+
+
+@app.middleware("http")
+async def brand_by_host(request: Request, call_next):
+    """Built-in phrases said for this request use the branding of the tenant its Host names."""
+    brand_for_host(request.headers.get("host"))
+    return await call_next(request)
+
+
+@app.middleware("http")
+async def recover_unhandled_errors(request: Request, call_next):
+    """Turn uncaught exceptions into a 500 envelope; the traceback is logged, never returned."""
+    try:
+        return await call_next(request)
+    except Exception:
+        rid = getattr(request.state, "request_id", "")
+        logger.exception("Unhandled error", extra={"path": request.url.path, "request_id": rid})
+        return _error_response(500, "Internal server error", rid)
 
 
 @app.middleware("http")
 async def add_request_id(request: Request, call_next):
-    request.state.request_id = request.headers.get("X-Request-ID") or str(uuid.uuid4())
-    response = await call_next(request)
+    request.state.request_id = client_request_id(request.headers) or new_id("request")
+    token = bind_request_id(request.state.request_id)
+    try:
+        response = await call_next(request)
+    finally:
+        reset_request_id(token)
     response.headers["X-Request-ID"] = request.state.request_id
     response.headers["X-Content-Type-Options"] = "nosniff"
     response.headers["X-Frame-Options"] = "SAMEORIGIN"
@@ -181,22 +401,151 @@ async def add_request_id(request: Request, call_next):
     return response
 
 
+@app.middleware("http")
+async def account_usage(request: Request, call_next):
+    """Add what the request used (audio seconds, LLM tokens, TTS characters) to its API key's usage."""
+    counts = track_usage()
+    try:
+        return await call_next(request)
+    finally:
+        if counts:
+            await run_in_threadpool(save_usage, usage_account(request_api_key(request)), counts)
+
+
+@app.middleware("http")
+async def tag_experiment(request: Request, call_next):
+    """Tag the request's turns with its experiment and variant (services/experiments.py), and name the LLM model that answered."""
+    tags = start_tags(request.headers.get(EXPERIMENT_HEADER), request.headers.get(VARIANT_HEADER))
+    response = await call_next(request)
+    if tags.get("model"):
+        response.headers[MODEL_HEADER] = tags["model"]
+    return response
+
+
+@app.middleware("http")
+async def audio_preprocessing(request: Request, call_next):
+    """Apply the request's X-Audio-Preprocess choice to its uploads (services/preprocess.py)."""
+    use_preprocessing(request.headers.get(PREPROCESS_HEADER))
+    return await call_next(request)
+
+
+@app.middleware("http")
+async def error_language(request: Request, call_next):
+    """Error messages for the user are in the request's language (services/error_codes.py)."""
+    start_error_language(request.headers.get("accept-language"))
+    return await call_next(request)
+
+
+# Outside the routes' middleware, so it sees their final JSON bodies.
+if COMPRESSION:
+    app.add_middleware(JSONCompression)
+
+
+cors_policy = load_policy()
+
+
+# Outside every middleware that can answer on its own (rate limits, deadlines, the 500 envelope),
+# so browsers can read those responses too; only the access log wraps it.
+@app.middleware("http")
+async def cors_middleware(request: Request, call_next):
+    origin = request.headers.get("origin")
+    allowed = cors_policy.allows(origin)
+    if request.method == "OPTIONS" and origin and request.headers.get("access-control-request-method"):
+        if not allowed:
+            request_id = getattr(request.state, "request_id", new_id("request"))
+            return _error_response(403, "Origin not allowed", request_id)
+        return Response(
+            status_code=204,
+            headers=cors_policy.preflight_headers(origin, request.headers.get("access-control-request-headers")),
+        )
+    response = await call_next(request)
+    if allowed:
+        headers = cors_policy.response_headers(origin)
+        vary = headers.pop("Vary", None)
+        response.headers.update(headers)
+        if vary:
+            response.headers["Vary"] = ", ".join(filter(None, [response.headers.get("Vary"), vary]))
+    return response
+
+
+_access_logger = logger.getChild("access")
+
+
+@app.middleware("http")
+async def access_log(request: Request, call_next):
+    """Outermost: times the whole request, adds Server-Timing, and writes the access log line."""
+    started = time.perf_counter()
+    stages = track_request()
+    status_code = 500
+    try:
+        response = await call_next(request)
+        status_code = response.status_code
+        if SERVER_TIMING:
+            response.headers["Server-Timing"] = server_timing(stages, (time.perf_counter() - started) * 1000)
+        return response
+    finally:
+        if ACCESS_LOG and request.url.path not in ACCESS_LOG_EXCLUDE:
+            _access_logger.info(
+                "%s %s %s",
+                request.method,
+                request.url.path,
+                status_code,
+                extra={
+                    "request_id": getattr(request.state, "request_id", None),
+                    "http_method": request.method,
+                    "path": request.url.path,
+                    "status_code": status_code,
+                    "duration_ms": round((time.perf_counter() - started) * 1000, 1),
+                    "stage_ms": {stage: round(ms, 1) for stage, ms in stages.items()},
+                    "client_ip": getattr(request.client, "host", None),
+                    "user_agent": request.headers.get("user-agent"),
+                },
+            )
+
+
 # Routers
 app.include_router(health.router)
+app.include_router(demo.router)
 app.include_router(warehouse.router)
 app.include_router(chess.router)
 app.include_router(chat.router)
+app.include_router(audio.router)
+app.include_router(jobs.router)
+app.include_router(batch.router)
+app.include_router(session.router)
+app.include_router(read.router)
+app.include_router(mcp.router)
+app.include_router(analytics.router)
+app.include_router(share.router)
+app.include_router(telephony.router)
+app.include_router(telegram.router)
+app.include_router(stream.router)
+app.include_router(webrtc.router)
 app.include_router(auth.router)
+app.include_router(debug.router)
+app.include_router(artifacts.router)
+app.include_router(admin.router)
+app.include_router(conversations.router)
+app.include_router(usage.router)
 
 
 if __name__ == "__main__":
-    if not os.getenv("DWANI_API_BASE_URL_LLM"):
-        raise ValueError("Environment variable DWANI_API_BASE_URL_LLM must be set")
-    if not os.getenv("DWANI_API_BASE_URL_TTS"):
-        raise ValueError("Environment variable DWANI_API_BASE_URL_TTS must be set")
-
     parser = argparse.ArgumentParser(description="Run the FastAPI server.")
     parser.add_argument("--port", type=int, default=8000, help="Port to run the server on.")
     parser.add_argument("--host", type=str, default="0.0.0.0", help="Host to run the server on.")
+    parser.add_argument("--mock", action="store_true", help="Answer ASR, LLM and TTS calls with canned responses.")
     args = parser.parse_args()
-    uvicorn.run(app, host=args.host, port=args.port)
+    if args.mock:
+        mock.enable()
+    if not mock.enabled():
+        if not os.getenv("DWANI_API_BASE_URL_LLM"):
+            raise ValueError("Environment variable DWANI_API_BASE_URL_LLM must be set")
+        if not os.getenv("DWANI_API_BASE_URL_TTS"):
+            raise ValueError("Environment variable DWANI_API_BASE_URL_TTS must be set")
+    apply_gc_settings()
+    tls = {}
+    if os.getenv("DWANI_TLS_CERT_FILE"):
+        tls = {"ssl_certfile": os.getenv("DWANI_TLS_CERT_FILE"), "ssl_keyfile": os.getenv("DWANI_TLS_KEY_FILE") or None}
+        if os.getenv("DWANI_TLS_CLIENT_CA_FILE"):
+            tls.update(ssl_ca_certs=os.getenv("DWANI_TLS_CLIENT_CA_FILE"), ssl_cert_reqs=ssl.CERT_REQUIRED)
+    uvicorn.run(app, host=args.host, port=args.port, **tls)
@@ -0,0 +1,54 @@
+"""Serve the voice tools over MCP stdio: newline-delimited JSON-RPC on stdin/stdout.
+
+    DWANI_API_BASE_URL_LLM=... DWANI_API_BASE_URL_TTS=... python mcp_server.py
+
+The upstream backends are called directly; this does not need the HTTP server running.
+"""
+import asyncio
+import json
+import logging
+import sys
+
+from services.http_client import close_http_client
+from services.mcp import handle_message, parse_error
+
+# stdout carries the protocol; keep logs off it.
+for _handler in logging.getLogger().handlers:
+    if isinstance(_handler, logging.StreamHandler):
+        _handler.setStream(sys.stderr)
+
+
+async def _serve() -> None:
+    loop = asyncio.get_running_loop()
+    write_lock = asyncio.Lock()
+    pending = set()
+
+    async def _reply(message) -> None:
+        reply = await handle_message(message) if message is not None else parse_error()
+        if reply is None:
+            return
+        async with write_lock:
+            sys.stdout.write(json.dumps(reply, ensure_ascii=False) + "\n")
+            sys.stdout.flush()
+
+    while True:
+        line = await loop.run_in_executor(None, sys.stdin.readline)
+        if not line:
+            break
+        if not line.strip():
+            continue
+        try:
+            message = json.loads(line)
+        except json.JSONDecodeError:
+            message = None
+        # Handle requests concurrently so a long voice_chat does not block pings.
+        task = asyncio.create_task(_reply(message))
+        pending.add(task)
+        task.add_done_callback(pending.discard)
+    if pending:
+        await asyncio.gather(*pending)
+    await close_http_client()
+
+
+if __name__ == "__main__":
+    asyncio.run(_serve())
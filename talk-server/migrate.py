@@ -0,0 +1,143 @@
+"""Schema migrations and backfills for DWANI_DATABASE_URL.
+
+    python migrate.py status      # applied and pending migrations
+    python migrate.py up          # apply pending migrations (also done at startup unless DWANI_AUTO_MIGRATE=0)
+    python migrate.py backfill    # fill derived turn fields (chars, cost) on older rows
+
+Migrations are plain SQL files in migrations/<dialect>/NNNN_name.sql, applied in order
+and recorded in schema_migrations.
+"""
+import argparse
+import os
+import sys
+from pathlib import Path
+from typing import List, Optional, Set, Tuple
+
+from sqlalchemy import text
+from sqlalchemy.engine import Connection, Engine
+
+from config import logger
+
+MIGRATIONS_DIR = Path(__file__).resolve().parent / "migrations"
+AUTO_MIGRATE = os.getenv("DWANI_AUTO_MIGRATE", "1") == "1"
+_PG_LOCK_ID = 0x64776E69  # "dwni"; serializes migrations across workers on Postgres
+
+
+def migrations_for(dialect: str) -> List[Tuple[str, Path]]:
+    directory = MIGRATIONS_DIR / dialect
+    if not directory.is_dir():
+        return []
+    return [(path.stem.split("_", 1)[0], path) for path in sorted(directory.glob("*.sql"))]
+
+
+def _statements(sql: str) -> List[str]:
+    lines = [line for line in sql.splitlines() if not line.strip().startswith("--")]
+    return [stmt.strip() for stmt in "\n".join(lines).split(";") if stmt.strip()]
+
+
+def _ensure_version_table(conn: Connection) -> None:
+    conn.execute(text(
+        "CREATE TABLE IF NOT EXISTS schema_migrations ("
+        "version VARCHAR(32) PRIMARY KEY, name VARCHAR(255) NOT NULL, "
+        "applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL)"
+    ))
+
+
+def applied_versions(conn: Connection) -> Set[str]:
+    _ensure_version_table(conn)
+    return {row[0] for row in conn.execute(text("SELECT version FROM schema_migrations"))}
+
+
+def pending(engine: Engine) -> List[Tuple[str, Path]]:
+    with engine.begin() as conn:
+        done = applied_versions(conn)
+    return [(v, p) for v, p in migrations_for(engine.dialect.name) if v not in done]
+
+
+def apply_migrations(engine: Engine) -> bool:
+    """Apply pending migrations; False when this database dialect has none."""
+    available = migrations_for(engine.dialect.name)
+    if not available:
+        logger.warning("No migrations for database dialect %s", engine.dialect.name)
+        return False
+    with engine.begin() as conn:
+        is_pg = engine.dialect.name == "postgresql"
+        if is_pg:
+            conn.execute(text("SELECT pg_advisory_xact_lock(:id)"), {"id": _PG_LOCK_ID})
+        done = applied_versions(conn)
+        for version, path in available:
+            if version in done:
+                continue
+            logger.info("Applying migration %s", path.name)
+            for stmt in _statements(path.read_text(encoding="utf-8")):
+                conn.execute(text(stmt))
+            conn.execute(
+                text("INSERT INTO schema_migrations (version, name) VALUES (:v, :n)"),
+                {"v": version, "n": path.stem},
+            )
+    return True
+
+
+def backfill(engine: Engine, batch_size: int = 500, recompute: bool = False) -> int:
+    """Fill transcript_chars, reply_chars and cost_usd; recompute=True redoes every row (e.g. new prices)."""
+    from conversation_store import derived_fields
+
+    updated = 0
+    last_id = 0
+    where = "" if recompute else " AND cost_usd IS NULL"
+    while True:
+        with engine.begin() as conn:
+            rows = conn.execute(
+                text(
+                    "SELECT id, transcript, reply FROM conversation_turns "
+                    f"WHERE id > :last{where} ORDER BY id LIMIT :n"
+                ),
+                {"last": last_id, "n": batch_size},
+            ).all()
+            for row_id, transcript, reply in rows:
+                conn.execute(
+                    text(
+                        "UPDATE conversation_turns SET transcript_chars = :transcript_chars, "
+                        "reply_chars = :reply_chars, cost_usd = :cost_usd WHERE id = :id"
+                    ),
+                    {"id": row_id, **derived_fields(transcript, reply)},
+                )
+        if not rows:
+            return updated
+        updated += len(rows)
+        last_id = rows[-1][0]
+
+
+def main(argv: Optional[List[str]] = None) -> int:
+    parser = argparse.ArgumentParser(prog="migrate", description="Migrate the talk-server database.")
+    sub = parser.add_subparsers(dest="command", required=True)
+    sub.add_parser("status", help="Show applied and pending migrations")
+    sub.add_parser("up", help="Apply pending migrations")
+    fill = sub.add_parser("backfill", help="Fill derived fields on stored conversation turns")
+    fill.add_argument("--batch-size", type=int, default=500)
+    fill.add_argument("--recompute", action="store_true", help="Recompute rows that already have values")
+    args = parser.parse_args(argv)
+
+    from auth_store import ENGINE
+
+    if args.command == "status":
+        with ENGINE.begin() as conn:
+            done = applied_versions(conn)
+        for version, path in migrations_for(ENGINE.dialect.name):
+            print(f"{'applied' if version in done else 'pending'}  {path.name}")
+        return 0
+    if args.command == "up":
+        if not apply_migrations(ENGINE):
+            return 1
+        print("Database is up to date")
+        return 0
+    remaining = pending(ENGINE)
+    if remaining:
+        print(f"Apply pending migrations first: {', '.join(p.name for _, p in remaining)}", file=sys.stderr)
+        return 1
+    print(f"Backfilled {backfill(ENGINE, args.batch_size, args.recompute)} turns")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
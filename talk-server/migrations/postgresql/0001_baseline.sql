@@ -0,0 +1,32 @@
+-- Tables previously created by SQLAlchemy create_all(); IF NOT EXISTS keeps existing databases intact.
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id VARCHAR(128) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS ix_auth_sessions_user_id ON auth_sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS conversation_turns (
+    id SERIAL PRIMARY KEY,
+    session_key VARCHAR(64),
+    mode VARCHAR(16) NOT NULL,
+    agent_name VARCHAR(64),
+    language VARCHAR(32),
+    input_type VARCHAR(8) NOT NULL,
+    transcript TEXT NOT NULL,
+    reply TEXT NOT NULL,
+    latency_ms INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ix_conversation_turns_session_key ON conversation_turns (session_key);
+CREATE INDEX IF NOT EXISTS ix_conversation_turns_created_at ON conversation_turns (created_at);
@@ -0,0 +1,4 @@
+-- Derived per-turn fields; existing rows are filled by `python migrate.py backfill`.
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS transcript_chars INTEGER;
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS reply_chars INTEGER;
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS cost_usd DOUBLE PRECISION;
@@ -0,0 +1,4 @@
+-- How much reply audio the client received, and whether it went away before the end.
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS playback_bytes INTEGER;
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS playback_ms INTEGER;
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS playback_interrupted BOOLEAN;
@@ -0,0 +1,7 @@
+-- Experiment, variant, LLM provider and model, and TTS voice of each turn, for segmenting analytics; older rows have none.
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS experiment VARCHAR(128);
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS variant VARCHAR(128);
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS provider VARCHAR(128);
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS model VARCHAR(128);
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS voice VARCHAR(128);
+CREATE INDEX IF NOT EXISTS ix_conversation_turns_experiment ON conversation_turns (experiment);
@@ -0,0 +1,3 @@
+-- Tenant each turn was served for, so reads stay within the caller's tenant; older rows are the deployment's own.
+ALTER TABLE conversation_turns ADD COLUMN IF NOT EXISTS tenant VARCHAR(64);
+CREATE INDEX IF NOT EXISTS ix_conversation_turns_tenant ON conversation_turns (tenant);
@@ -0,0 +1,4 @@
+-- Derived per-turn fields; existing rows are filled by `python migrate.py backfill`.
+ALTER TABLE conversation_turns ADD COLUMN transcript_chars INTEGER;
+ALTER TABLE conversation_turns ADD COLUMN reply_chars INTEGER;
+ALTER TABLE conversation_turns ADD COLUMN cost_usd FLOAT;
@@ -0,0 +1,6 @@
+-- Highest conversation_turns.id already exported, per exporter.
+CREATE TABLE IF NOT EXISTS export_watermarks (
+    name VARCHAR(64) NOT NULL PRIMARY KEY,
+    last_id INTEGER DEFAULT 0 NOT NULL,
+    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP) NOT NULL
+);
@@ -0,0 +1,12 @@
+-- Stored turn languages become BCP-47 tags (kn-IN), as in API responses.
+UPDATE conversation_turns SET language = CASE language
+    WHEN 'kannada' THEN 'kn-IN'
+    WHEN 'hindi' THEN 'hi-IN'
+    WHEN 'tamil' THEN 'ta-IN'
+    WHEN 'malayalam' THEN 'ml-IN'
+    WHEN 'telugu' THEN 'te-IN'
+    WHEN 'marathi' THEN 'mr-IN'
+    WHEN 'english' THEN 'en-IN'
+    WHEN 'german' THEN 'de-DE'
+END
+WHERE language IN ('kannada', 'hindi', 'tamil', 'malayalam', 'telugu', 'marathi', 'english', 'german');
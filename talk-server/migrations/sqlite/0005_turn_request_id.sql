@@ -0,0 +1,3 @@
+-- Request (correlation) ID of each turn; older rows have none.
+ALTER TABLE conversation_turns ADD COLUMN request_id VARCHAR(128);
+CREATE INDEX IF NOT EXISTS ix_conversation_turns_request_id ON conversation_turns (request_id);
@@ -0,0 +1,9 @@
+-- Legal hold and soft delete per conversation (session_key); conversations without a row have neither.
+CREATE TABLE IF NOT EXISTS conversation_flags (
+    session_key VARCHAR(64) NOT NULL PRIMARY KEY,
+    legal_hold BOOLEAN DEFAULT 0 NOT NULL,
+    hold_reason VARCHAR(255),
+    deleted_at DATETIME,
+    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ix_conversation_flags_deleted_at ON conversation_flags (deleted_at);
@@ -1,6 +1,6 @@
 """Pydantic models and shared enums. Single source of truth for allowed languages."""
 from enum import Enum
-from typing import Optional, Literal
+from typing import Any, Dict, List, Optional, Literal
 
 from pydantic import BaseModel, Field, ConfigDict, field_validator
 
@@ -18,6 +18,25 @@ class SupportedLanguage(str, Enum):
 
 
 ALLOWED_LANGUAGES = [lang.value for lang in SupportedLanguage]
+# BCP-47 tags used in response metadata and stored records; accepted as input aliases.
+LANGUAGE_TAGS = {
+    "kannada": "kn-IN",
+    "hindi": "hi-IN",
+    "tamil": "ta-IN",
+    "malayalam": "ml-IN",
+    "telugu": "te-IN",
+    "marathi": "mr-IN",
+    "english": "en-IN",
+    "german": "de-DE",
+}
+_TAG_LANGUAGES = {tag.split("-")[0].lower(): name for name, tag in LANGUAGE_TAGS.items()}
+
+
+def language_alias(value: Any) -> Any:
+    """The language name for a BCP-47 tag (kn-IN, kn); other values are left for validation."""
+    if isinstance(value, str) and value.strip():
+        return _TAG_LANGUAGES.get(value.strip().replace("_", "-").lower().split("-")[0], value)
+    return value
 ALLOWED_AGENTS = [
     "travel_planner",
     "viva_examiner",
@@ -29,13 +48,320 @@ ALLOWED_AGENTS = [
 DEFAULT_AGENT_NAME = "travel_planner"
 
 
+class ErrorBody(BaseModel):
+    code: str = Field(..., description="HTTP status code as a string")
+    message: str = Field(..., description="Human-readable error message")
+    stage: Optional[str] = Field(None, description="Pipeline stage that failed (asr, llm, tts, agent), if any")
+    request_id: str = Field(..., description="Request ID to quote in bug reports")
+    details: Dict[str, Any] = Field(default_factory=dict, description="Extra context, e.g. the timed-out stage")
+    error_code: str = Field(..., description="Machine-readable error code, e.g. asr_unavailable, no_speech_detected, llm_timeout")
+    localized_message: str = Field(..., description="Message for the user in the request's language")
+    language: str = Field(..., description="Language of localized_message: english, kannada, hindi or tamil")
+
+
+class ErrorResponse(BaseModel):
+    """Envelope returned for every error status."""
+    error: ErrorBody
+    detail: str = Field(..., description="Same as error.message; kept for older clients")
+
+
+def error_responses(*status_codes: int) -> Dict[int, Dict[str, Any]]:
+    """OpenAPI `responses` entries documenting the error envelope for the given statuses."""
+    descriptions = {
+        400: "Invalid input",
+        401: "Invalid or missing API key",
+        404: "Not found",
+        409: "Conflicts with the resource's state",
+        413: "File too large",
+        415: "Not audio in an accepted format",
+        422: "Request validation failed",
+        429: "Rate limit exceeded",
+        501: "Not supported by this deployment",
+        502: "Upstream service error",
+        503: "Service overloaded or not ready",
+        504: "Upstream stage timed out (see X-Timeout-Stage)",
+    }
+    return {code: {"model": ErrorResponse, "description": descriptions.get(code, "Error")} for code in status_codes}
+
+
+class GrammarMatchResponse(BaseModel):
+    name: str = Field(..., description="Grammar the transcript was matched against")
+    matched: bool = Field(..., description="Whether the transcript fit the grammar (false: the reply re-prompts)")
+    value: Optional[str] = Field(None, description="Normalized value, e.g. 'yes' or '4821'")
+
+
+class WordTimingResponse(BaseModel):
+    word: str = Field(..., description="Word as spoken")
+    start_seconds: float = Field(..., description="When the word starts, from the beginning of the reply audio")
+    end_seconds: float = Field(..., description="When the word ends")
+
+
+class CaptionCueResponse(BaseModel):
+    index: int = Field(..., description="Position of the cue in the reply")
+    start_seconds: float = Field(..., description="When the cue starts, from the beginning of the reply audio")
+    end_seconds: float = Field(..., description="When the cue ends")
+    text: str = Field(..., description="Caption text")
+    words: List[WordTimingResponse] = Field(default_factory=list, description="Timing of each word in the cue")
+
+
+class IntentResponse(BaseModel):
+    name: str = Field(..., description="Intent name, e.g. payment")
+    slots: Dict[str, Any] = Field(default_factory=dict, description="Intent parameters")
+    description: Optional[str] = Field(None, description="Human-readable summary used in the confirmation question")
+
+
+class AudioURLRequest(BaseModel):
+    audio_url: str = Field(
+        ..., min_length=1, max_length=4096, description="http(s) URL of the input audio, e.g. an S3 presigned URL"
+    )
+
+
+class TranslationResponse(BaseModel):
+    input_language: str = Field(..., description="Language the user spoke or typed")
+    output_language: str = Field(..., description="Language of the spoken reply")
+    llm_language: str = Field(..., description="Language the LLM or agent worked in")
+    llm_input: str = Field(..., description="The user's words as translated for the LLM")
+    llm_reply: str = Field(..., description="The LLM's reply before it was translated back")
+
+
+class LanguageFallbackResponse(BaseModel):
+    requested: str = Field(..., description="Language the request asked for (BCP-47 tag)")
+    used: str = Field(..., description="Neighbor language the audio was transcribed and answered in")
+
+
+class ReplySegmentResponse(BaseModel):
+    index: int = Field(..., description="Position of the segment in the reply, from 0")
+    text: str = Field(..., description="What the segment says")
+    url: str = Field(..., description="Where to fetch the segment's audio, synthesized on request")
+
+
+class TurnTimingsResponse(BaseModel):
+    model_config = ConfigDict(extra="allow")  # <stage>_ms of the other stages that ran (translation, retrieval, ...)
+
+    asr_ms: Optional[float] = Field(None, description="Speech recognition (absent for typed input)")
+    llm_ms: Optional[float] = Field(None, description="The LLM's reply (agent_ms in agent mode)")
+    tts_ms: Optional[float] = Field(None, description="Speech synthesis, to the first audio bytes")
+    total_ms: float = Field(..., description="The whole request, up to the response")
+
+
+class TurnUsageResponse(BaseModel):
+    audio_seconds: float = Field(0, description="Seconds of input audio")
+    llm_prompt_tokens: int = Field(0, description="Prompt tokens the LLM reported")
+    llm_completion_tokens: int = Field(0, description="Completion tokens the LLM reported")
+    tts_chars: int = Field(0, description="Characters sent to TTS")
+
+
+class SpeechToSpeechJSONResponse(BaseModel):
+    transcription: str = Field(..., description="User transcript (or the submitted text)")
+    llm_response: str = Field(..., description="Assistant reply that was synthesized (the transcript itself with skip_llm)")
+    audio_base64: Optional[str] = Field(None, description="Base64-encoded reply audio (format=json)")
+    audio_url: Optional[str] = Field(None, description="Signed URL of the reply audio in object storage (format=url)")
+    audio_url_expires_at: Optional[int] = Field(None, description="Unix time after which audio_url stops working")
+    audio_content_type: str = Field("audio/mpeg", description="Media type of the decoded audio")
+    grammar: Optional[GrammarMatchResponse] = Field(None, description="Grammar result when the request set grammar")
+    confirmation: Optional[str] = Field(
+        None, description="Sensitive-intent confirmation state: pending, confirmed, cancelled, or failed"
+    )
+    intent: Optional[IntentResponse] = Field(None, description="Intent emitted by this turn (after confirmation if required)")
+    detected_language: Optional[str] = Field(None, description="Language detected for language=auto (null if none was)")
+    language_fallback: Optional[LanguageFallbackResponse] = Field(
+        None, description="Set when low ASR language confidence switched the turn to a neighbor language"
+    )
+    asr_confidence: Optional[float] = Field(None, description="The ASR backend's confidence in the transcript, when it reports one")
+    low_confidence: Optional[bool] = Field(
+        None, description="True when the transcript was too uncertain to answer, and the reply asks the user to repeat"
+    )
+    budget_hits: Optional[List[str]] = Field(None, description="Per-turn budgets the reply was cut to fit: llm_tokens, tts_chars")
+    translation: Optional[TranslationResponse] = Field(
+        None, description="The LLM's side of a cross-lingual turn (input_language/output_language)"
+    )
+    captions: List[CaptionCueResponse] = Field(
+        default_factory=list, description="Caption cues timed to the reply audio, for synchronized subtitles"
+    )
+    word_timing: Optional[str] = Field(
+        None, description="Where the word timings come from: tts (the backend's marks) or estimated"
+    )
+    subtitles: Optional[str] = Field(None, description="The captions as an SRT or WebVTT file, when subtitles was set")
+    timings: Optional[TurnTimingsResponse] = Field(
+        None, description="Milliseconds per stage that ran (<stage>_ms, as in Server-Timing) and in total"
+    )
+    usage: Optional[TurnUsageResponse] = Field(None, description="What the request used, as counted for GET /v1/usage")
+    segments: Optional[List[ReplySegmentResponse]] = Field(None, description="The reply's audio segments (format=segments)")
+    segments_expire_at: Optional[int] = Field(None, description="Unix time after which the segment URLs stop working")
+
+
+class ImageToSpeechJSONResponse(BaseModel):
+    text: str = Field(..., description="Text read from the image, or its summary when mode=summary")
+    audio_base64: str = Field(..., description="Base64-encoded speech")
+    audio_content_type: str = Field("audio/mpeg", description="Media type of the decoded audio")
+    budget_hits: Optional[List[str]] = Field(None, description="tts_chars when the audio stops short of text to fit the TTS budget")
+
+
+class SpeakerSegmentResponse(BaseModel):
+    speaker: Optional[str] = Field(None, description="Speaker label, 'Speaker 1' and so on in order of first speech; null when unlabelled")
+    text: str
+    start_seconds: Optional[float] = Field(None, description="Start within the audio, when the ASR backend times its segments")
+    end_seconds: Optional[float] = Field(None, description="End within the audio, when the ASR backend times its segments")
+
+
 class TranscriptionResponse(BaseModel):
     text: str = Field(..., description="Transcribed text from the audio")
+    language: Optional[str] = Field(None, description="Language the ASR backend detected, when it reports one")
+    language_confidence: Optional[float] = Field(None, description="The backend's confidence in that language, when it reports one")
+    requested_language: Optional[str] = Field(
+        None, description="Language asked for, when its low confidence switched the transcript to a neighbor (language)"
+    )
+    confidence: Optional[float] = Field(None, description="The backend's confidence in the transcript (0-1), when it reports one")
+    segments: Optional[List[SpeakerSegmentResponse]] = Field(None, description="Speaker-labelled segments, for diarized transcripts")
+    alternatives: Optional[List[str]] = Field(None, description="Other hypotheses the ASR backend returned, most likely first")
     model_config = ConfigDict(
         json_schema_extra={"example": {"text": "Hello, how are you?"}}
     )
 
 
+class DictationSegmentResponse(BaseModel):
+    index: int
+    start_seconds: float = Field(..., description="Segment start within the upload")
+    end_seconds: Optional[float] = Field(None, description="Segment end; null when the audio was not WAV")
+    text: str = Field(..., description="Punctuated paragraph")
+    raw_text: str = Field(..., description="Text as returned by ASR")
+    restored: bool = Field(..., description="Whether punctuation was restored by the LLM")
+
+
+class SilenceCompressionResponse(BaseModel):
+    original_seconds: float = Field(..., description="Length of the uploaded recording")
+    compressed_seconds: float = Field(..., description="Audio actually sent to ASR")
+    removed_seconds: float
+    silences: int = Field(..., description="Pauses that were shortened")
+    ratio: float = Field(..., description="compressed_seconds / original_seconds")
+
+
+class DictationResponse(BaseModel):
+    text: str = Field(..., description="All paragraphs, separated by blank lines")
+    segments: List[DictationSegmentResponse]
+    silence: Optional[SilenceCompressionResponse] = Field(
+        None, description="Silence removed before transcription; null when the audio was not 16-bit WAV"
+    )
+
+
+class PronunciationWordResponse(BaseModel):
+    index: int = Field(..., description="Position in the reference text")
+    word: str = Field(..., description="Reference word as written")
+    heard: Optional[str] = Field(None, description="What ASR heard in its place; null when omitted")
+    status: str = Field(..., description="correct, mispronounced, or omitted")
+    accuracy: int = Field(..., description="0-100: how close the heard word is to the reference word")
+    extra_before: List[str] = Field(default_factory=list, description="Words added just before this one (fillers, repeats)")
+
+
+class PronunciationResponse(BaseModel):
+    reference_text: str
+    transcription: str
+    accuracy: int = Field(..., description="0-100, mean word accuracy")
+    completeness: int = Field(..., description="0-100, share of reference words read")
+    fluency: int = Field(..., description="0-100, from added words, reading pace, and long pauses")
+    overall: int = Field(..., description="0-100, mean of accuracy, completeness, and fluency")
+    words: List[PronunciationWordResponse]
+    extra_words: List[str] = Field(..., description="Every word heard that is not in the reference")
+    words_per_minute: Optional[float] = Field(None, description="Reading pace over the voiced audio; null unless WAV")
+    long_pauses: Optional[int] = Field(None, description="Pauses longer than DWANI_PRONUNCIATION_PAUSE_MS; null unless WAV")
+
+
+class VocabularySuggestionResponse(BaseModel):
+    instead_of: str = Field(..., description="The learner's word or phrase")
+    suggestion: str = Field(..., description="A more natural or precise choice")
+    explanation: str = ""
+
+
+class TurnFeedbackResponse(BaseModel):
+    index: int = Field(..., description="Learner turn number in the session, from 0")
+    text: str = Field(..., description="What the learner said")
+    corrected: Optional[str] = Field(None, description="Corrected sentence; null when it was already correct")
+    grammar: List[str] = Field(default_factory=list, description="Grammar notes in the feedback language")
+    vocabulary: List[VocabularySuggestionResponse] = Field(default_factory=list)
+
+
+class SessionAssessmentResponse(BaseModel):
+    session_id: str
+    feedback_language: str
+    grammar_score: Optional[int] = Field(None, description="0-100 over the whole conversation")
+    vocabulary_score: Optional[int] = Field(None, description="0-100 over the whole conversation")
+    summary: str
+    turns: List[TurnFeedbackResponse]
+    cached: bool = Field(False, description="True when returned from the session without a new LLM call")
+
+
+class SessionTranscriptTurnResponse(BaseModel):
+    user: Optional[str] = Field(None, description="What the user said (the transcript) or typed")
+    assistant: Optional[str] = Field(None, description="The assistant's reply")
+    at: Optional[str] = Field(None, description="When the exchange happened (ISO 8601, UTC); null for turns from before timestamps were kept")
+
+
+class SessionTranscriptResponse(BaseModel):
+    session_id: str
+    turns: List[SessionTranscriptTurnResponse]
+
+
+class MeetingTurnResponse(BaseModel):
+    part: int = Field(..., description="Part of the recording the turn was transcribed in; speaker numbers restart in each")
+    speaker: Optional[str] = Field(None, description="Speaker label from ASR, e.g. 'Speaker 1'; null when unlabelled")
+    text: str
+    start_seconds: float = Field(..., description="Start of the turn within the recording when ASR times it (DWANI_ASR_DIARIZE_FIELD), else of its part")
+    end_seconds: Optional[float] = Field(None, description="End of the turn or part; null when neither is known (audio that is not WAV)")
+
+
+class ActionItemResponse(BaseModel):
+    task: str
+    owner: Optional[str] = Field(None, description="Who it is assigned to; null when the meeting did not say")
+    due: Optional[str] = Field(None, description="When it is due, as said in the meeting")
+
+
+class MeetingSummaryResponse(BaseModel):
+    summary: str
+    key_points: List[str]
+    decisions: List[str]
+    action_items: List[ActionItemResponse]
+    transcript: List[MeetingTurnResponse]
+    silence: Optional[SilenceCompressionResponse] = Field(None, description="Silence removed before transcription")
+    audio_base64: Optional[str] = Field(None, description="Spoken summary when speak=true")
+    audio_content_type: Optional[str] = Field(None, description="Media type of the decoded audio")
+    budget_hits: Optional[List[str]] = Field(None, description="tts_chars when the spoken summary was cut to fit the TTS budget")
+
+
+class ReadChapterResponse(BaseModel):
+    index: int
+    title: Optional[str] = Field(None, description="Chapter heading; null for text before the first heading")
+    start_seconds: float = Field(..., description="Chapter start within the stitched audio")
+    duration_seconds: float
+    first_segment: int = Field(..., description="Index of the chapter's first HLS segment")
+
+
+class ShareLinkRequest(BaseModel):
+    ttl_seconds: Optional[int] = Field(
+        None, ge=60, description="Link lifetime in seconds (default DWANI_SHARE_LINK_TTL_SECONDS, capped by the max)"
+    )
+    passcode: Optional[str] = Field(None, min_length=4, max_length=64, description="Passcode viewers must enter")
+
+
+class ShareLinkResponse(BaseModel):
+    url: str = Field(..., description="Playback page for the conversation")
+    token: str
+    expires_at: int = Field(..., description="Unix time the link stops working")
+    passcode_protected: bool
+
+
+class ReadResponse(BaseModel):
+    id: str
+    title: Optional[str] = Field(None, description="Article headline when reading a URL")
+    source_url: Optional[str] = Field(None, description="Page that was read, after redirects")
+    audio_url: str = Field(..., description="Stitched MP3 of the whole document")
+    playlist_url: str = Field(..., description="HLS (VOD) playlist, one MP3 segment per chunk")
+    duration_seconds: float
+    segments: int
+    characters: int
+    expires_at: float = Field(..., description="Unix time after which the artifact is deleted")
+    chapters: List[ReadChapterResponse]
+
+
 class WarehouseCommandRequest(BaseModel):
     robot: str = Field(..., description="Robot to control: 'uav', 'ugv', or 'arm'.")
     action: Optional[str] = Field(default=None, description="Action: move, pick, drop, pick_from_stack, place_on_stack.")
@@ -47,6 +373,73 @@ class WarehouseCommandRequest(BaseModel):
     z: Optional[float] = Field(default=None)
 
 
+class GenerationParams(BaseModel):
+    """Per-request LLM sampling overrides; unset fields use the DWANI_LLM_* defaults."""
+    model: Optional[str] = Field(None, description="LLM model name", min_length=1, max_length=128)
+    temperature: Optional[float] = Field(None, ge=0, le=2, description="Sampling temperature")
+    top_p: Optional[float] = Field(None, gt=0, le=1, description="Nucleus sampling probability mass")
+    max_tokens: Optional[int] = Field(None, ge=1, le=4096, description="Maximum reply tokens")
+
+
+class TurnDetectionPresetResponse(BaseModel):
+    name: str = Field(..., description="Preset name")
+    description: str
+    speech_threshold_dbfs: float = Field(..., description="Frames louder than this (dBFS) count as speech")
+    min_speech_ms: int = Field(..., description="Shorter bursts of speech are ignored")
+    silence_ms: int = Field(..., description="Silence that ends the turn")
+    prefix_padding_ms: int = Field(..., description="Audio kept from before speech start")
+    max_utterance_seconds: int = Field(..., description="A turn is ended after this long regardless")
+
+
+class TurnDetectionUpdate(BaseModel):
+    preset: Literal["fast", "balanced", "patient"] = Field(..., description="Turn-detection preset for this session")
+
+
+class WebRTCOffer(BaseModel):
+    sdp: str = Field(..., description="The browser's SDP offer, with an audio track (RTCPeerConnection.localDescription.sdp)")
+    type: Literal["offer"] = Field("offer", description="Always offer")
+
+
+class WebRTCAnswer(BaseModel):
+    sdp: str = Field(..., description="SDP answer for RTCPeerConnection.setRemoteDescription, ICE candidates included")
+    type: Literal["answer"] = "answer"
+    call_id: str = Field(..., description="ID of the call, in the server's logs")
+    session_id: str = Field(..., description="Session the call's turns are kept in (X-Session-ID, or a new one)")
+
+
+class SessionSlots(BaseModel):
+    slots: Dict[str, Any] = Field(
+        default_factory=dict,
+        description="Slot name -> string, number, or boolean; in updates, null removes the slot",
+        examples=[{"party_size": 2, "time": "7pm"}],
+    )
+
+
+class SessionErasureResponse(BaseModel):
+    session_id: str
+    erased: Dict[str, Any] = Field(
+        description="What was removed: session (history, slots and settings), conversation_turns, recordings",
+        examples=[{"session": True, "conversation_turns": 12, "recordings": 3}],
+    )
+
+
+class OpenAISpeechRequest(BaseModel):
+    """Body of POST /v1/audio/speech, as the OpenAI audio API defines it."""
+    model: Optional[str] = Field(None, max_length=128, description="Accepted for compatibility; the configured TTS backend is used")
+    input: str = Field(..., min_length=1, max_length=4096, description="Text to speak")
+    voice: Optional[str] = Field(
+        None, max_length=64, description="A language name or code (e.g. 'hindi', 'kn') picks the voice; OpenAI voice names are ignored"
+    )
+    response_format: Optional[str] = Field("mp3", description="mp3, opus, or wav")
+    speed: Optional[float] = Field(None, ge=0.25, le=4.0, description="Accepted for compatibility; not applied")
+    language: Optional[SupportedLanguage] = Field(None, description="Language of the input (name or BCP-47 tag); overrides voice")
+
+    @field_validator("language", mode="before")
+    @classmethod
+    def validate_language(cls, value: Any) -> Any:
+        return language_alias(value)
+
+
 class ChatRequest(BaseModel):
     text: str = Field(..., description="User message text", min_length=1, max_length=4000)
     mode: Literal["llm", "agent"] = Field("llm", description="Processing mode: 'llm' or 'agent'")
@@ -56,6 +449,21 @@ class ChatRequest(BaseModel):
         min_length=1,
         max_length=64,
     )
+    language: Optional[SupportedLanguage] = Field(
+        None, description="Language the reply must be written in (name or BCP-47 tag such as kn-IN)"
+    )
+    model: Optional[str] = Field(None, description="LLM model name (mode='llm')", min_length=1, max_length=128)
+    temperature: Optional[float] = Field(None, ge=0, le=2, description="Sampling temperature (mode='llm')")
+    top_p: Optional[float] = Field(None, gt=0, le=1, description="Nucleus sampling probability mass (mode='llm')")
+    max_tokens: Optional[int] = Field(None, ge=1, le=4096, description="Maximum reply tokens (mode='llm')")
+    persona: Optional[str] = Field(None, max_length=64, description="Assistant persona configured on the server (mode='llm')")
+    verbosity: Optional[Literal["short", "normal", "detailed"]] = Field(
+        None, description="Reply length (mode='llm'); DWANI_VERBOSITY when unset"
+    )
+
+    def generation(self, model: Optional[str] = None) -> "GenerationParams":
+        """The sampling overrides, with model as resolve_model() returned it (stripped and allowed)."""
+        return GenerationParams(model=model, temperature=self.temperature, top_p=self.top_p, max_tokens=self.max_tokens)
 
     @field_validator("agent_name")
     @classmethod
@@ -66,6 +474,11 @@ class ChatRequest(BaseModel):
             raise ValueError(f"agent_name must be one of {ALLOWED_AGENTS}")
         return value
 
+    @field_validator("language", mode="before")
+    @classmethod
+    def validate_language(cls, value: Any) -> Any:
+        return language_alias(value)
+
 
 class SignupRequest(BaseModel):
     email: str = Field(..., min_length=5, max_length=255)
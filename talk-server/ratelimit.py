@@ -0,0 +1,67 @@
+"""Token-bucket rate limiting per client IP and per API key.
+
+Buckets live in process memory, so with several gunicorn workers each worker enforces
+the configured rate on its own share of traffic.
+"""
+import hashlib
+import math
+import threading
+import time
+from collections import OrderedDict
+from typing import Optional, Tuple
+
+_PERIODS = {"second": 1, "minute": 60, "hour": 3600, "day": 86400}
+_MAX_BUCKETS = 10000
+
+
+def parse_rate(spec: str) -> Optional[Tuple[float, float]]:
+    """Parse "120/minute" into (capacity, tokens per second). Empty or "0" disables."""
+    spec = (spec or "").strip().lower()
+    if not spec or spec == "0":
+        return None
+    count, _, period = spec.partition("/")
+    seconds = _PERIODS.get(period.strip().rstrip("s") or "minute")
+    if seconds is None:
+        raise ValueError(f"Invalid rate limit period in {spec!r}")
+    capacity = float(count)
+    if capacity <= 0:
+        return None
+    return capacity, capacity / seconds
+
+
+class TokenBucketLimiter:
+    def __init__(self, capacity: float, refill_per_second: float, max_buckets: int = _MAX_BUCKETS):
+        self.capacity = capacity
+        self.refill_per_second = refill_per_second
+        self._max_buckets = max_buckets
+        self._buckets: "OrderedDict[str, Tuple[float, float]]" = OrderedDict()
+        self._lock = threading.Lock()
+
+    def acquire(self, key: str) -> float:
+        """Take one token for key. Returns 0 when allowed, else seconds until a token is available."""
+        now = time.monotonic()
+        with self._lock:
+            tokens, updated = self._buckets.pop(key, (self.capacity, now))
+            tokens = min(self.capacity, tokens + (now - updated) * self.refill_per_second)
+            if tokens >= 1:
+                self._buckets[key] = (tokens - 1, now)
+                wait = 0.0
+            else:
+                self._buckets[key] = (tokens, now)
+                wait = (1 - tokens) / self.refill_per_second
+            while len(self._buckets) > self._max_buckets:
+                self._buckets.popitem(last=False)
+        return wait
+
+    def reset(self) -> None:
+        with self._lock:
+            self._buckets.clear()
+
+
+def retry_after_header(wait_seconds: float) -> str:
+    return str(max(1, math.ceil(wait_seconds)))
+
+
+def api_key_bucket(api_key: str) -> str:
+    # Keep raw keys out of memory dumps and logs.
+    return hashlib.sha256(api_key.encode("utf-8")).hexdigest()[:24]
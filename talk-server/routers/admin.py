@@ -0,0 +1,196 @@
+"""Admin endpoints: inspect and steer backends, flush caches and put ejected backends back in rotation without a restart.
+
+Only for DWANI_ADMIN_TOKEN (X-Admin-Token or Bearer). Caches and backend health live in each
+worker process, so these act on the worker that serves the request; run them once per worker
+(or restart) to cover all. The TTS cache's disk tier (DWANI_TTS_CACHE_DIR) is shared and is
+emptied for every worker at once.
+
+GET /admin/backends lists every backend instance with its health, latency, weight and whether it
+is disabled; POST /admin/backends/disable takes one out of rotation for a while (DELETE undoes
+it) and PUT /admin/backends/weight changes its share of round-robin traffic (services/balancer.py).
+
+POST /admin/drain is the preStop hook's (services/drain.py): it fails /ready for all workers and
+waits for the serving worker's in-flight requests; DELETE /admin/drain undoes it.
+
+GET, POST and DELETE /admin/corrections manage the transcript correction dictionary
+(services/corrections.py); with DWANI_CORRECTIONS_FILE, changes are saved there and reach every worker.
+"""
+import math
+import time
+from typing import Any, Dict, List, Optional
+
+from fastapi import APIRouter, Body, Depends, HTTPException, Query
+from starlette.concurrency import run_in_threadpool
+
+from config import BACKEND_BALANCING, DRAIN_TIMEOUT, logger
+from deps import require_admin
+from services import drain
+from services.balancer import Instance, instances_at, pools
+from services.corrections import correction_from_spec, corrections
+from services.fingerprint import result_cache, transcript_cache
+from services.idempotency import idempotency_cache
+from services.llm_health import reset_llm_health
+from services.replay import replay_guard
+from services.tts_cache import tts_cache
+
+router = APIRouter(prefix="/admin", include_in_schema=False, dependencies=[Depends(require_admin)])
+
+CACHES = ("tts", "idempotency", "replay", "fingerprint")
+STAGES = ("asr", "vision", "llm", "tts")
+
+
+def _selected(values: Optional[List[str]], known: tuple, name: str) -> List[str]:
+    selected = [v.strip().lower() for v in values or [] if v.strip()] or list(known)
+    unknown = sorted(set(selected) - set(known))
+    if unknown:
+        raise HTTPException(status_code=400, detail=f"Unknown {name} {unknown}; expected some of {list(known)}")
+    return list(dict.fromkeys(selected))
+
+
+@router.post("/caches/flush")
+async def flush_caches(cache: Optional[List[str]] = Query(None, description=f"Caches to flush, of {CACHES}; all by default")) -> Dict[str, Any]:
+    """Drop cached entries; the number dropped per cache (tts_disk: files of the shared disk tier)."""
+    flushed: Dict[str, int] = {}
+    for name in _selected(cache, CACHES, "cache"):
+        if name == "tts":
+            flushed[name] = len(tts_cache)
+            tts_cache.clear()
+            if tts_cache.directory is not None:
+                flushed["tts_disk"] = await run_in_threadpool(tts_cache.purge_disk)
+        elif name == "idempotency":
+            flushed[name] = len(idempotency_cache)
+            idempotency_cache.clear()
+        elif name == "replay":
+            flushed[name] = len(replay_guard)
+            replay_guard.clear()
+        else:
+            flushed[name] = len(transcript_cache) + len(result_cache)
+            transcript_cache.clear()
+            result_cache.clear()
+    logger.warning("Caches flushed by an admin", extra={"flushed": flushed})
+    return {"flushed": flushed}
+
+
+@router.post("/breakers/reset")
+async def reset_breakers(stage: Optional[List[str]] = Query(None, description=f"Stages to reset, of {STAGES}; all by default")) -> Dict[str, Any]:
+    """Return ejected backend instances to rotation (and end the LLM's fallback period); those re-admitted per stage."""
+    stages = _selected(stage, STAGES, "stage")
+    reset = dict.fromkeys(stages, 0)
+    for pool in pools():
+        if pool.stage in reset:
+            reset[pool.stage] += pool.reset()
+    if "llm" in reset:
+        reset_llm_health()
+    logger.warning("Backend breakers reset by an admin", extra={"stages": stages})
+    return {"reset": reset}
+
+
+def _instances(stage: str, url: str) -> List[Instance]:
+    _selected([stage], STAGES, "stage")
+    found = instances_at(stage.strip().lower(), url)
+    if not found:
+        raise HTTPException(status_code=404, detail=f"No {stage} backend at {url} in this worker")
+    return found
+
+
+@router.get("/backends")
+async def list_backends() -> Dict[str, Any]:
+    """Every backend instance this worker knows, with health, latency, weight and whether it is disabled."""
+    now = time.monotonic()
+    return {
+        "strategy": BACKEND_BALANCING,
+        "pools": [
+            {"stage": p.stage, "sources": list(p.sources), "instances": [i.snapshot(now) for i in p.instances]}
+            for p in pools()
+            if any(i.url for i in p.instances)
+        ],
+    }
+
+
+@router.post("/backends/disable")
+async def disable_backend(
+    stage: str = Query(..., description=f"Stage of the backend, of {STAGES}"),
+    url: str = Query(..., description="The instance's URL, as GET /admin/backends lists it"),
+    seconds: float = Query(300, ge=0, description="How long to keep it out of rotation; 0 until enabled again"),
+) -> Dict[str, Any]:
+    """Send the instance no requests for a while; its snapshot afterwards."""
+    now = time.monotonic()
+    found = _instances(stage, url)
+    for instance in found:
+        instance.disabled_until = now + seconds if seconds else math.inf
+    logger.warning("Backend disabled by an admin", extra={"stage": stage, "url": url, "seconds": seconds or None})
+    return found[0].snapshot(now)
+
+
+@router.delete("/backends/disable")
+async def enable_backend(
+    stage: str = Query(..., description=f"Stage of the backend, of {STAGES}"),
+    url: str = Query(..., description="The instance's URL, as GET /admin/backends lists it"),
+) -> Dict[str, Any]:
+    """Put a disabled instance back in rotation; its snapshot afterwards."""
+    found = _instances(stage, url)
+    for instance in found:
+        instance.disabled_until = 0.0
+    logger.warning("Backend enabled by an admin", extra={"stage": stage, "url": url})
+    return found[0].snapshot(time.monotonic())
+
+
+@router.put("/backends/weight")
+async def weigh_backend(
+    stage: str = Query(..., description=f"Stage of the backend, of {STAGES}"),
+    url: str = Query(..., description="The instance's URL, as GET /admin/backends lists it"),
+    weight: int = Query(..., ge=0, le=100, description="Round-robin turns per rotation; 0 only when the others fail"),
+) -> Dict[str, Any]:
+    """Change the instance's share of round-robin traffic; its snapshot afterwards."""
+    found = _instances(stage, url)
+    for instance in found:
+        instance.weight = weight
+    logger.warning("Backend weight changed by an admin", extra={"stage": stage, "url": url, "weight": weight})
+    return found[0].snapshot(time.monotonic())
+
+
+@router.post("/drain")
+async def start_drain(timeout: float = Query(DRAIN_TIMEOUT, ge=0, description="Seconds to wait for in-flight requests")) -> Dict[str, Any]:
+    """Report not ready, then wait for this worker's in-flight pipeline requests; whether they finished."""
+    drain.start_drain()
+    logger.warning("Draining requested by an admin", extra={"in_flight": drain.in_flight()})
+    idle = await drain.wait_idle(timeout)
+    return {"draining": True, "idle": idle, "in_flight": drain.in_flight()}
+
+
+@router.delete("/drain")
+async def stop_drain() -> Dict[str, Any]:
+    """Report ready again."""
+    drain.stop_drain()
+    logger.warning("Draining cancelled by an admin")
+    return {"draining": False}
+
+
+@router.get("/corrections")
+async def list_corrections() -> Dict[str, Any]:
+    """The transcript corrections, in the order they are applied."""
+    entries = await run_in_threadpool(corrections.entries)
+    return {"corrections": [entry.as_dict() for entry in entries]}
+
+
+@router.post("/corrections")
+async def add_correction(
+    spec: Dict[str, Any] = Body(..., description='{"match", "replace", "regex"?, "language"?}'),
+) -> Dict[str, Any]:
+    """Add a correction, or update the one with the same match and language; the entry."""
+    try:
+        correction = correction_from_spec(spec)
+    except ValueError as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    await run_in_threadpool(corrections.add, correction)
+    logger.warning("Transcript correction added by an admin", extra={"correction": correction.as_dict()})
+    return correction.as_dict()
+
+
+@router.delete("/corrections/{entry_id}")
+async def remove_correction(entry_id: str) -> Dict[str, Any]:
+    """Remove the correction with this id."""
+    if not await run_in_threadpool(corrections.remove, entry_id):
+        raise HTTPException(status_code=404, detail=f"No transcript correction {entry_id!r}")
+    logger.warning("Transcript correction removed by an admin", extra={"id": entry_id})
+    return {"removed": entry_id}
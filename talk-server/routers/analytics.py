@@ -0,0 +1,158 @@
+"""Read-only GraphQL API over stored conversations and usage (DWANI_STORE_CONVERSATIONS=1).
+
+The audio pipeline is not exposed here; this is for dashboards and reporting. Soft-deleted
+conversations (services/retention.py) are left out of conversations and turns, and a tenant's
+API key (services/tenants.py) only sees that tenant's turns; other keys see the deployment's own.
+"""
+from datetime import datetime
+from typing import List, Optional
+
+import strawberry
+from fastapi import Depends
+from starlette.concurrency import run_in_threadpool
+from strawberry.fastapi import GraphQLRouter
+
+import conversation_store
+from deps import require_api_key
+from services.tenants import current_tenant_name
+
+_MAX_PAGE = 100
+
+
+def _page(limit: int) -> int:
+    return max(1, min(limit, _MAX_PAGE))
+
+
+@strawberry.type
+class Turn:
+    id: int
+    mode: str
+    agent_name: Optional[str]
+    language: Optional[str]
+    input_type: str
+    transcript: str
+    reply: str
+    latency_ms: Optional[int]
+    experiment: Optional[str]
+    variant: Optional[str]
+    provider: Optional[str]
+    model: Optional[str]
+    voice: Optional[str]
+    created_at: datetime
+
+    @staticmethod
+    def from_row(row) -> "Turn":
+        return Turn(
+            id=row.id,
+            mode=row.mode,
+            agent_name=row.agent_name,
+            language=row.language,
+            input_type=row.input_type,
+            transcript=row.transcript,
+            reply=row.reply,
+            latency_ms=row.latency_ms,
+            experiment=row.experiment,
+            variant=row.variant,
+            provider=row.provider,
+            model=row.model,
+            voice=row.voice,
+            created_at=row.created_at,
+        )
+
+
+@strawberry.type
+class Conversation:
+    session_key: str
+    turn_count: int
+    started_at: datetime
+    last_turn_at: datetime
+
+    @strawberry.field
+    async def turns(self, limit: int = 50, offset: int = 0) -> List[Turn]:
+        rows = await run_in_threadpool(
+            conversation_store.list_turns, self.session_key, _page(limit), offset, tenant=current_tenant_name()
+        )
+        return [Turn.from_row(r) for r in rows]
+
+
+@strawberry.type
+class Count:
+    key: str
+    turns: int
+
+
+@strawberry.type
+class Usage:
+    turns: int
+    conversations: int
+    avg_latency_ms: Optional[float]
+    by_mode: List[Count]
+    by_language: List[Count]
+    by_input_type: List[Count]
+
+
+@strawberry.type
+class DailyActivity:
+    day: str
+    turns: int
+    avg_latency_ms: Optional[float]
+
+
+def _counts(values) -> List[Count]:
+    return [Count(key=k, turns=v) for k, v in sorted(values.items(), key=lambda kv: -kv[1])]
+
+
+@strawberry.type
+class Query:
+    @strawberry.field(description="Conversations ordered by most recent turn")
+    async def conversations(
+        self,
+        limit: int = 20,
+        offset: int = 0,
+        since: Optional[datetime] = None,
+        until: Optional[datetime] = None,
+    ) -> List[Conversation]:
+        rows = await run_in_threadpool(
+            conversation_store.list_conversations, _page(limit), offset, since, until, tenant=current_tenant_name()
+        )
+        return [Conversation(**vars(r)) for r in rows]
+
+    @strawberry.field(description="One conversation by its session key")
+    async def conversation(self, session_key: str) -> Optional[Conversation]:
+        summary = await run_in_threadpool(conversation_store.get_conversation, session_key, tenant=current_tenant_name())
+        return Conversation(**vars(summary)) if summary is not None else None
+
+    @strawberry.field(description="Most recent turns across the caller's conversations")
+    async def turns(
+        self,
+        limit: int = 20,
+        offset: int = 0,
+        since: Optional[datetime] = None,
+        until: Optional[datetime] = None,
+    ) -> List[Turn]:
+        rows = await run_in_threadpool(
+            conversation_store.list_turns, None, _page(limit), offset, since, until, tenant=current_tenant_name()
+        )
+        return [Turn.from_row(r) for r in rows]
+
+    @strawberry.field(description="Turn and conversation totals with breakdowns")
+    async def usage(self, since: Optional[datetime] = None, until: Optional[datetime] = None) -> Usage:
+        summary = await run_in_threadpool(conversation_store.usage_summary, since, until, tenant=current_tenant_name())
+        return Usage(
+            turns=summary.turns,
+            conversations=summary.conversations,
+            avg_latency_ms=summary.avg_latency_ms,
+            by_mode=_counts(summary.by_mode),
+            by_language=_counts(summary.by_language),
+            by_input_type=_counts(summary.by_input_type),
+        )
+
+    @strawberry.field(description="Turns per UTC day")
+    async def daily_activity(self, days: int = 30) -> List[DailyActivity]:
+        rows = await run_in_threadpool(conversation_store.daily_activity, max(1, min(days, 366)), tenant=current_tenant_name())
+        return [DailyActivity(**r) for r in rows]
+
+
+schema = strawberry.Schema(query=Query)
+
+router = GraphQLRouter(schema, path="/v1/graphql", dependencies=[Depends(require_api_key)], tags=["Analytics"])
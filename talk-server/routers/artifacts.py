@@ -0,0 +1,56 @@
+"""Admin API over recorded request artifacts (services/recorder.py), for reproducing bad answers.
+
+Only for DWANI_ADMIN_TOKEN (X-Admin-Token or Bearer), since recordings hold what users said.
+Listing works while recording is off, so earlier recordings can still be fetched or deleted.
+Artifacts encrypted at rest (DWANI_RECORD_ENCRYPTION_KEY) are decrypted when served.
+"""
+import asyncio
+from typing import Any, Dict
+
+from cryptography.exceptions import InvalidTag
+from fastapi import APIRouter, Depends, HTTPException, Query
+from fastapi.responses import Response
+
+from config import RECORD_ARTIFACTS
+from deps import require_admin
+from services.recorder import recorder
+
+router = APIRouter(prefix="/admin/artifacts", include_in_schema=False, dependencies=[Depends(require_admin)])
+
+
+@router.get("")
+async def list_recordings(limit: int = Query(100, ge=1, le=1000)) -> Dict[str, Any]:
+    """Recorded requests, newest first."""
+    return {"recording": RECORD_ARTIFACTS, "requests": await asyncio.to_thread(recorder.recent, limit)}
+
+
+@router.get("/{request_id}")
+async def get_recording(request_id: str) -> Dict[str, Any]:
+    """The request's manifest: session, language, mode, and its artifacts in pipeline order."""
+    manifest = recorder.manifest(request_id)
+    if manifest is None:
+        raise HTTPException(status_code=404, detail="No recording for this request ID")
+    return manifest
+
+
+@router.get("/{request_id}/{name}")
+async def get_artifact(request_id: str, name: str) -> Response:
+    """One artifact, decrypted when recordings are encrypted at rest."""
+    try:
+        content = await asyncio.to_thread(recorder.read_artifact, request_id, name)
+    except (ValueError, InvalidTag):
+        raise HTTPException(status_code=409, detail="Artifact cannot be decrypted with DWANI_RECORD_ENCRYPTION_KEY")
+    if content is None:
+        raise HTTPException(status_code=404, detail="No such artifact")
+    return Response(
+        content,
+        media_type=recorder.media_type(name),
+        headers={"Content-Disposition": f'attachment; filename="{name}"'},
+    )
+
+
+@router.delete("/{request_id}", status_code=204)
+async def delete_recording(request_id: str) -> Response:
+    if not recorder.delete(request_id):
+        raise HTTPException(status_code=404, detail="No recording for this request ID")
+    return Response(status_code=204)
@@ -0,0 +1,128 @@
+"""OpenAI-compatible audio endpoints, so OpenAI SDK users can point base_url at this gateway.
+
+POST /v1/audio/transcriptions and POST /v1/audio/speech take the OpenAI request shapes and
+go to the configured ASR and TTS backends, with the same hooks, limits, and caching as the
+rest of the API. model is accepted and ignored. Languages are given as codes ("hi") or names.
+"""
+from typing import Optional
+
+from fastapi import APIRouter, Depends, File, Form, HTTPException, Request, UploadFile
+from fastapi.responses import JSONResponse, PlainTextResponse, Response
+
+from deps import get_optional_user, limiter, require_api_key
+from models import ALLOWED_LANGUAGES, OpenAISpeechRequest, error_responses
+from services import stream_tts
+from services.audio_format import content_type_for, transcode
+from services.concurrency import pipeline_limiter
+from services.language_id import normalize_language
+from services.latency import observe_stage, set_input_audio, wav_duration
+from services.playback import PlaybackResponse
+from services.replay import replay_guard, replay_key
+from services.transcribe import transcribe_audio
+from services.uploads import upload_digest
+
+router = APIRouter(prefix="/v1/audio", tags=["OpenAI-compatible"])
+
+_TRANSCRIPTION_FORMATS = ("json", "text", "verbose_json")
+# OpenAI's names for the formats the TTS path can return.
+_SPEECH_FORMATS = {"mp3": "mp3", "opus": "ogg", "wav": "wav"}
+
+
+@router.post(
+    "/transcriptions",
+    summary="Transcription (OpenAI-compatible)",
+    description="Same request and response shapes as OpenAI's audio transcriptions, served by the configured ASR backend.",
+    responses=error_responses(400, 401, 413, 415, 422, 429, 502, 503, 504),
+)
+@limiter.limit("20/minute")
+async def transcriptions(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    file: UploadFile = File(..., description="Audio to transcribe"),
+    model: Optional[str] = Form(None, description="Accepted for compatibility; the configured ASR backend is used"),
+    language: Optional[str] = Form(None, description=f"ISO-639-1 code or name of one of {ALLOWED_LANGUAGES}"),
+    prompt: Optional[str] = Form(None, description="Accepted for compatibility; not used"),
+    response_format: str = Form("json", description="json, text, or verbose_json"),
+    temperature: Optional[float] = Form(None, description="Accepted for compatibility; not used"),
+    diarize: bool = Form(False, description="Label speakers; json and verbose_json then include speaker segments"),
+) -> Response:
+    if response_format not in _TRANSCRIPTION_FORMATS:
+        raise HTTPException(status_code=400, detail=f"response_format must be one of {list(_TRANSCRIPTION_FORMATS)}")
+    spoken = normalize_language(language) if language else None
+    if language and spoken is None:
+        raise HTTPException(status_code=400, detail=f"language must be a code or name of one of {ALLOWED_LANGUAGES}")
+    request_id = getattr(request.state, "request_id", None)
+
+    async def respond() -> Response:
+        head = await file.read(4096)
+        await file.seek(0)
+        duration = wav_duration(head)
+        set_input_audio(duration)
+        async with pipeline_limiter.slot():
+            with observe_stage("asr"):
+                result = await transcribe_audio(file, language=spoken, request_id=request_id, diarize=diarize)
+        if diarize:
+            # One line per speaker turn.
+            text = "\n".join(" ".join(line.split()) for line in result.text.splitlines() if line.strip())
+        else:
+            text = " ".join(result.text.split())
+        segments = [
+            {"id": index, "start": segment.start_seconds, "end": segment.end_seconds, "speaker": segment.speaker, "text": segment.text}
+            for index, segment in enumerate(result.segments or [])
+        ]
+        if response_format == "text":
+            return PlainTextResponse(text)
+        if response_format == "verbose_json":
+            return JSONResponse(content={
+                "task": "transcribe",
+                "language": result.language or spoken,
+                "duration": round(duration, 3) if duration else None,
+                "text": text,
+                "segments": segments,
+                **({"alternatives": result.alternatives} if result.alternatives else {}),
+            })
+        return JSONResponse(content={
+            "text": text,
+            **({"segments": segments} if diarize else {}),
+            **({"alternatives": result.alternatives} if result.alternatives else {}),
+        })
+
+    if not replay_guard.enabled:
+        return await respond()
+    upload = await upload_digest(file)
+    return await replay_guard.run(replay_key(request, upload, file.content_type, spoken, response_format, str(diarize)), respond)
+
+
+@router.post(
+    "/speech",
+    summary="Speech (OpenAI-compatible)",
+    description="Same request shape as OpenAI's audio speech; the audio comes from the configured TTS backend.",
+    responses={
+        200: {"description": "Speech audio", "content": {"audio/mpeg": {}, "audio/ogg": {}, "audio/wav": {}}},
+        **error_responses(400, 401, 422, 429, 502, 503, 504),
+    },
+)
+@limiter.limit("20/minute")
+async def speech(
+    request: Request,
+    payload: OpenAISpeechRequest,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+) -> Response:
+    requested = (payload.response_format or "mp3").strip().lower()
+    if requested not in _SPEECH_FORMATS:
+        raise HTTPException(status_code=400, detail=f"response_format must be one of {list(_SPEECH_FORMATS)}")
+    audio_format = _SPEECH_FORMATS[requested]
+    text = payload.input.strip()
+    if not text:
+        raise HTTPException(status_code=400, detail="input must not be empty")
+    language = payload.language.value if payload.language else normalize_language(payload.voice)
+    request_id = getattr(request.state, "request_id", None)
+    async with pipeline_limiter.slot():
+        tts_stream = await stream_tts(text, request_id=request_id, language=language, audio_format=audio_format)
+    if tts_stream.audio_format != audio_format:
+        # The backend ignored response_format, which OpenAI clients rely on.
+        audio = await transcode(await tts_stream.read(), audio_format)
+        return Response(audio, media_type=content_type_for(audio_format))
+    return PlaybackResponse(tts_stream)
@@ -12,6 +12,7 @@ from auth_store import (
     create_user,
     revoke_session,
 )
+from config import DEMO_MODE
 from deps import get_optional_user
 from models import LoginRequest, SignupRequest, UserResponse
 
@@ -41,6 +42,8 @@ def _clear_auth_cookie(response: Response) -> None:
 
 @router.post("/signup", response_model=UserResponse, status_code=status.HTTP_201_CREATED)
 async def signup(payload: SignupRequest, response: Response) -> UserResponse:
+    if DEMO_MODE:
+        raise HTTPException(status_code=403, detail="Sign-up is disabled in demo mode")
     user = create_user(email=payload.email, password=payload.password)
     if user is None:
         raise HTTPException(status_code=409, detail="Email is already registered")
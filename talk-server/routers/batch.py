@@ -0,0 +1,56 @@
+from typing import Any, Dict, List, Optional
+
+from fastapi import APIRouter, Depends, File, HTTPException, Query, Request, UploadFile
+
+from deps import get_optional_user, limiter, require_api_key
+from models import ALLOWED_LANGUAGES, error_responses
+from services.batch import expand, run_batch
+from services.language_id import parse_language
+from services.personas import resolve_persona
+
+router = APIRouter(prefix="/v1/batch", tags=["Audio"])
+
+
+@router.post(
+    "/speech_to_speech",
+    summary="Run speech-to-speech over many files",
+    description=(
+        "Run each uploaded file (zip archives are unpacked) through the pipeline, DWANI_BATCH_CONCURRENCY at a time, "
+        "and return a manifest with a result or error per file. For evaluating the pipeline on a test corpus."
+    ),
+    responses=error_responses(400, 401, 413, 429),
+)
+@limiter.limit("5/minute")
+async def batch_speech_to_speech(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    files: List[UploadFile] = File(..., description="Audio files, or zip archives of them"),
+    mode: str = Query("llm", description="Processing mode: 'llm' or 'agent'"),
+    agent_name: Optional[str] = Query(None, description="Agent name when mode='agent'"),
+    language: Optional[str] = Query(
+        None, description=f"Spoken and reply language, one of {ALLOWED_LANGUAGES} or a BCP-47 tag, or 'auto' to detect it"
+    ),
+    persona: Optional[str] = Query(None, max_length=64, description="Assistant persona configured on the server (mode='llm')"),
+    include_audio: bool = Query(False, description="Also return each reply's audio as audio_base64"),
+) -> Dict[str, Any]:
+    if mode not in {"llm", "agent"}:
+        raise HTTPException(status_code=400, detail="mode must be 'llm' or 'agent'")
+    language = parse_language(language, allow_auto=True)
+    profile = resolve_persona(persona)
+    expanded = expand(files)
+    try:
+        return await run_batch(
+            expanded,
+            mode=mode,
+            agent_name=agent_name,
+            language=language,
+            persona=profile,
+            include_audio=include_audio,
+            request_id=getattr(request.state, "request_id", None),
+        )
+    finally:
+        # Files unpacked from archives are ours to close; Starlette closes the uploads themselves.
+        for file in expanded:
+            if file not in files:
+                await file.close()
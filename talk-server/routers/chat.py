@@ -1,21 +1,70 @@
 import base64
-import os
-from typing import Any, Dict, Optional
+import json
+from dataclasses import asdict
+from typing import Any, AsyncIterator, Dict, List, Optional
+from urllib.parse import quote
 
 import httpx
-from fastapi import APIRouter, Depends, File, HTTPException, Request, UploadFile, Query
-from fastapi.responses import JSONResponse, Response
+from fastapi import APIRouter, Depends, File, Form, HTTPException, Request, UploadFile, Query
+from fastapi.responses import JSONResponse, Response, StreamingResponse
 
-from config import TTS_TIMEOUT, logger
+from config import logger
 from deps import get_optional_user, limiter, require_api_key
-from models import ALLOWED_AGENTS, ChatRequest, DEFAULT_AGENT_NAME
-from services import call_agent, call_llm, get_session_context, append_to_session, transcribe_audio
+from models import (
+    ALLOWED_AGENTS,
+    ALLOWED_LANGUAGES,
+    AudioURLRequest,
+    ChatRequest,
+    DEFAULT_AGENT_NAME,
+    DictationResponse,
+    GenerationParams,
+    ImageToSpeechJSONResponse,
+    MeetingSummaryResponse,
+    PronunciationResponse,
+    SpeechToSpeechJSONResponse,
+    error_responses,
+)
+from services import call_agent, call_llm, converse, get_session_context, append_to_session, stream_tts
+from services.audio_format import content_type_for, normalize_format, transcode
+from services.audio_url import fetch_audio
+from services.blob_store import blob_store, deliver
+from services.budget import cut_to_tts_budget, llm_generation, trim_llm_reply
+from services.captions import SUBTITLE_FORMATS, audio_duration, caption_cues, subtitles, tts_word_timings
+from services.chat_svc import last_finish_reason
+from services.concurrency import pipeline_limiter
+from services.confirmation import ConfirmationOutcome
+from services.dictation import DictationSegment, SilenceCompression, dictate
+from services.error_codes import code_for, error_fields
+from services.errors import RequestError
+from services.grammar import resolve_grammar
+from services.idempotency import fingerprint, idempotency_cache, request_key
+from services.language_id import AUTO_LANGUAGE, language_tag, parse_language
+from services.latency import request_timings
+from services.llm_variants import resolve_model
+from services.meetings import summarize_meeting
+from services.moderation import moderate
+from services.personas import resolve_persona
+from services.prompts import build_system_prompt, resolve_verbosity, verbosity_generation
+from services.retrieval import grounded, retrieve
+from services.pronunciation import assess_pronunciation
+from services.fingerprint import results_enabled, reuse_result
+from services.flags import require as require_flag
+from services.replay import replay_guard, replay_key
+from services.reply_segments import open_token, segment_manifest
+from services.slots import apply_turn_updates, get_slots, save_slots
+from services.playback import PlaybackResponse, track_playback
+from services.tts import TTSStream
+from services.tools import conversation
+from services.uploads import upload_digest
+from services.usage import request_usage
+from services.vision import IMAGE_MODES, read_image
 
 router = APIRouter(prefix="/v1", tags=["Chat"])
 _MAX_SESSION_ID_LEN = 128
+_MAX_TEXT_LEN = 4000
 
 
-@router.post("/chat", summary="Text chat")
+@router.post("/chat", summary="Text chat", responses=error_responses(400, 401, 422, 429, 502, 503, 504))
 @limiter.limit("60/minute")
 async def chat(
     request: Request,
@@ -26,7 +75,8 @@ async def chat(
     text = (payload.text or "").strip()
     request_id = getattr(request.state, "request_id", None)
     if not text:
-        raise HTTPException(status_code=400, detail="Text must not be empty")
+        raise RequestError(400, "empty_input", "Text must not be empty")
+    text = await moderate(text, "input", request_id)
 
     session_id = (request.headers.get("X-Session-ID") or "").strip() or None
     if session_id and len(session_id) > _MAX_SESSION_ID_LEN:
@@ -37,8 +87,9 @@ async def chat(
         selected_agent = payload.agent_name or DEFAULT_AGENT_NAME
         if selected_agent not in ALLOWED_AGENTS:
             raise HTTPException(status_code=400, detail=f"agent_name must be one of {ALLOWED_AGENTS}")
-        agent_result = await call_agent(selected_agent, text, session_id=session_id, request_id=request_id)
-        reply = agent_result["reply"]
+        async with pipeline_limiter.slot():
+            agent_result = await call_agent(selected_agent, text, session_id=session_id, request_id=request_id)
+        reply = await moderate(agent_result["reply"], "output", request_id)
         out: Dict[str, Any] = {"user": text, "reply": reply}
         if agent_result.get("warehouse_state") is not None:
             out["warehouse_state"] = agent_result["warehouse_state"]
@@ -46,26 +97,131 @@ async def chat(
             out["chess_state"] = agent_result["chess_state"]
         if session_id:
             append_to_session(session_id, text, reply)
+            slots = get_slots(session_id)
+            updated = apply_turn_updates(slots, agent_result.get("slots"), f"agent {selected_agent}")
+            if updated != slots:
+                save_slots(session_id, updated)
         return out
     else:
-        reply = await call_llm(text, context=context, request_id=request_id)
+        language = payload.language.value if payload.language else None
+        slots = get_slots(session_id)
+        persona = resolve_persona(payload.persona)
+        model = resolve_model(payload.model)
+        passages = await retrieve(text, language, request_id)
+        system_prompt = grounded(build_system_prompt(language, slots, persona, payload.verbosity), passages)
+        prompt = {"system_prompt": system_prompt} if slots or persona or payload.verbosity or passages else {}
+        generation, capped = llm_generation(verbosity_generation(payload.generation(model), payload.verbosity))
+        async with pipeline_limiter.slot():
+            with conversation():
+                reply = await call_llm(
+                    text, context=context, request_id=request_id, language=language, generation=generation, **prompt
+                )
+        reply, _ = trim_llm_reply(reply, capped, last_finish_reason())
+        reply = await moderate(reply, "output", request_id)
         if session_id:
             append_to_session(session_id, text, reply)
         return {"user": text, "reply": reply}
 
 
+def _confirmation_fields(outcome: Optional[ConfirmationOutcome]) -> Dict[str, Any]:
+    if outcome is None:
+        return {}
+    fields: Dict[str, Any] = {} if outcome.status == "emitted" else {"confirmation": outcome.status}
+    if outcome.intent is not None:
+        fields["intent"] = asdict(outcome.intent)
+    return fields
+
+
+def _turn_report() -> Dict[str, Any]:
+    """The request's stage timings and usage so far, for JSON turn responses."""
+    return {"timings": request_timings(), "usage": request_usage()}
+
+
+async def _audio_response(tts_stream: TTSStream, audio_format: Optional[str]) -> Response:
+    headers = {"Cache-Control": "no-cache"}
+    if audio_format and tts_stream.audio_format != audio_format:
+        # The backend ignored response_format: convert the whole reply.
+        audio_bytes = await transcode(await tts_stream.read(), audio_format)
+        headers["Content-Disposition"] = f"inline; filename=\"speech.{audio_format}\""
+        return Response(audio_bytes, media_type=content_type_for(audio_format), headers=headers)
+    headers["Content-Disposition"] = f"inline; filename=\"speech.{tts_stream.audio_format or 'mp3'}\""
+    return PlaybackResponse(tts_stream, headers=headers)
+
+
+def _ndjson_line(event: Dict[str, Any]) -> str:
+    return json.dumps(event, ensure_ascii=False) + "\n"
+
+
+async def _reply_events(
+    turn_fields: Dict[str, Any], tts_stream: TTSStream, subtitle_format: Optional[str] = None
+) -> AsyncIterator[str]:
+    """format=ndjson: the turn, caption cues with word timings, the audio as it arrives, then done.
+
+    Without the TTS backend's word marks cues are timed from an estimate, and the done line has
+    the measured duration and the cues re-timed to it, when it can be measured. With
+    subtitle_format the done line also has the final cues as an SRT or WebVTT file.
+    """
+    reply = turn_fields["llm_response"]
+    marks = tts_word_timings(tts_stream, reply)
+    yield _ndjson_line({
+        "type": "turn",
+        **turn_fields,
+        "audio_content_type": tts_stream.media_type,
+        "word_timing": "tts" if marks else "estimated",
+    })
+    cues = caption_cues(reply, word_timings=marks)
+    for cue in cues:
+        yield _ndjson_line({"type": "caption", **cue.as_dict()})
+    parts = []
+    try:
+        async for chunk in tts_stream.iter_bytes():
+            parts.append(chunk)
+            yield _ndjson_line({"type": "audio", "audio_base64": base64.b64encode(chunk).decode("utf-8")})
+    except (HTTPException, httpx.HTTPError) as exc:
+        logger.error("Reply audio stream failed: %s", exc)
+        code, message = (exc.status_code, exc.detail) if isinstance(exc, HTTPException) else (502, "External API error")
+        yield _ndjson_line({"type": "error", "code": str(code), "message": str(message), **error_fields(code_for(exc) if isinstance(exc, HTTPException) else "tts_failed")})
+        return
+    duration = audio_duration(b"".join(parts))
+    done: Dict[str, Any] = {"type": "done", "duration_seconds": round(duration, 3) if duration else None}
+    if duration and not marks:
+        cues = caption_cues(reply, duration)
+        done["captions"] = [cue.as_dict() for cue in cues]
+    if subtitle_format:
+        done["subtitles"] = subtitles(cues, subtitle_format)
+    yield _ndjson_line(done)
+
+
 @router.post(
     "/speech_to_speech",
     summary="Speech-to-Speech Conversion",
-    description="Convert input speech to processed speech in the specified language.",
+    description=(
+        "Convert input speech (or typed text, skipping ASR) to processed speech in the specified language. "
+        'Instead of a multipart upload, a JSON body {"audio_url": "..."} has talk-server download the audio.'
+    ),
     tags=["Audio"],
+    openapi_extra={
+        "requestBody": {
+            "content": {
+                "application/json": {"schema": AudioURLRequest.model_json_schema()},
+            },
+        },
+    },
     responses={
-        200: {"description": "Audio stream", "content": {"audio/mp3": {"example": "Binary audio data"}}},
-        400: {"description": "Invalid input or language"},
-        413: {"description": "File too large"},
-        429: {"description": "Rate limit exceeded"},
-        504: {"description": "External API timeout"},
-        500: {"description": "External API error"},
+        200: {
+            "description": (
+                "Reply audio stream in the requested format, JSON when format=json (without audio when skip_tts) or segments, "
+                "or NDJSON lines (turn, caption, audio, done) when format=ndjson"
+            ),
+            "model": SpeechToSpeechJSONResponse,
+            "content": {
+                "audio/mpeg": {"example": "Binary audio data"},
+                "audio/wav": {},
+                "audio/ogg": {},
+                "application/x-ndjson": {},
+            },
+        },
+        **error_responses(400, 401, 413, 415, 429, 502, 503, 504),
     },
 )
 @limiter.limit("20/minute")
@@ -73,84 +229,463 @@ async def speech_to_speech(
     request: Request,
     _: None = Depends(require_api_key),
     __ = Depends(get_optional_user),
-    file: UploadFile = File(..., description="Audio file to process"),
-    language: Optional[str] = Query(None, description="Legacy hint (optional); transcription is model-based"),
+    file: Optional[UploadFile] = File(None, description="Audio file to process"),
+    text: Optional[str] = Form(None, max_length=_MAX_TEXT_LEN, description="Typed input used instead of an audio file (skips ASR)"),
+    transcript_override: Optional[str] = Form(
+        None,
+        max_length=_MAX_TEXT_LEN,
+        description="Corrected transcript of the previous turn (e.g. one of its alternatives): reruns only the LLM and TTS, "
+        "replacing that turn in the session (after a low_confidence reply, which was not recorded, send text instead)",
+    ),
+    model_field: Optional[str] = Form(
+        None, alias="model", min_length=1, max_length=128, description="LLM model name, as the model query parameter"
+    ),
+    language: Optional[str] = Query(
+        None, description=f"Spoken and reply language, one of {ALLOWED_LANGUAGES} or a BCP-47 tag, or 'auto' to detect it"
+    ),
+    input_language: Optional[str] = Query(
+        None, description="Language the user speaks, when it differs from language (the LLM's); the input is translated"
+    ),
+    output_language: Optional[str] = Query(
+        None, description="Language of the spoken reply (default input_language); the LLM's reply is translated"
+    ),
     mode: str = Query("llm", description="Processing mode: 'llm' or 'agent'"),
     agent_name: Optional[str] = Query(None, description="Agent name when mode='agent'"),
+    model: Optional[str] = Query(
+        None, min_length=1, max_length=128, description="LLM model name (mode='llm'), one of DWANI_LLM_ALLOWED_MODELS when set"
+    ),
+    temperature: Optional[float] = Query(None, ge=0, le=2, description="Sampling temperature (mode='llm')"),
+    top_p: Optional[float] = Query(None, gt=0, le=1, description="Nucleus sampling probability mass (mode='llm')"),
+    max_tokens: Optional[int] = Query(None, ge=1, le=4096, description="Maximum reply tokens (mode='llm')"),
+    format: Optional[str] = Query(
+        None,
+        description="Reply audio format: mp3, wav, ogg/opus; json for a JSON body; url for a JSON body with a signed URL "
+        "to the audio in object storage (DWANI_BLOB_STORE_URI); ndjson to stream audio and captions; or segments for a "
+        "JSON body with a URL per reply segment, each synthesized when it is fetched",
+    ),
+    grammar: Optional[str] = Query(
+        None,
+        max_length=_MAX_TEXT_LEN,
+        description="Constrain the answer to a grammar (yes_no, digits, a configured name, or inline JSON); skips the LLM",
+    ),
+    persona: Optional[str] = Query(None, max_length=64, description="Assistant persona configured on the server (mode='llm')"),
+    verbosity: Optional[str] = Query(
+        None, description="Reply length (mode='llm'): short, normal or detailed; DWANI_VERBOSITY when unset"
+    ),
+    subtitles_format: Optional[str] = Query(
+        None, alias="subtitles", description="Also return the reply's captions as an srt or vtt file (format=json or ndjson)"
+    ),
+    skip_llm: bool = Query(False, description="Echo mode: speak the transcript back instead of answering it (pronunciation practice)"),
+    skip_tts: bool = Query(False, description="Return the transcript and reply as JSON, without synthesizing the reply audio"),
 ) -> Response:
     if mode not in {"llm", "agent"}:
         raise HTTPException(status_code=400, detail="mode must be 'llm' or 'agent'")
+    if skip_tts and format not in (None, "json"):
+        raise HTTPException(status_code=400, detail="skip_tts returns JSON without audio; leave format unset or use json")
+    if skip_tts and subtitles_format:
+        raise HTTPException(status_code=400, detail="subtitles are timed to the reply audio, which skip_tts leaves out")
+    if skip_llm and (grammar or input_language or output_language or transcript_override is not None):
+        raise HTTPException(
+            status_code=400,
+            detail="skip_llm speaks the transcript back; it cannot be combined with grammar, input_language, "
+            "output_language or transcript_override",
+        )
+    return_url = format == "url"
+    return_json = format == "json" or return_url or skip_tts
+    return_events = format == "ndjson"
+    return_segments = format == "segments"
+    if return_events:
+        require_flag("streaming_replies")
+    if return_segments:
+        require_flag("reply_segments")
+    if return_url and blob_store() is None:
+        raise HTTPException(status_code=400, detail="format=url needs DWANI_BLOB_STORE_URI")
+    subtitles_format = (subtitles_format or "").strip().lower() or None
+    if subtitles_format and subtitles_format not in SUBTITLE_FORMATS:
+        raise HTTPException(status_code=400, detail=f"subtitles must be one of {list(SUBTITLE_FORMATS)}")
+    if subtitles_format and not (return_json or return_events):
+        raise HTTPException(status_code=400, detail="subtitles needs format=json, url or ndjson")
+    audio_format = None if return_json or return_events or return_segments else normalize_format(format)
+    language = parse_language(language, allow_auto=True)
+    input_language = parse_language(input_language, "input_language")
+    output_language = parse_language(output_language, "output_language")
+    if input_language or output_language:
+        require_flag("translation")
+    if (input_language or output_language) and language == AUTO_LANGUAGE:
+        raise HTTPException(status_code=400, detail="input_language and output_language cannot be used with language=auto")
+    if transcript_override is not None and (file is not None or text is not None):
+        raise HTTPException(status_code=400, detail="transcript_override replaces 'file' and 'text'; send it alone")
+    typed = text if transcript_override is None else transcript_override
+    audio_url = await _audio_url(request) if file is None and typed is None else None
+    if (file is None) == (typed is None) and audio_url is None:
+        raise HTTPException(status_code=400, detail="Provide exactly one of 'file', 'text', 'transcript_override', or a JSON 'audio_url'")
+    constraint = resolve_grammar(grammar)
+    profile = resolve_persona(persona)
+    verbosity = resolve_verbosity(verbosity)
+    model = resolve_model(model or model_field)
 
     logger.debug("Processing speech-to-speech request", extra={
         "endpoint": "/v1/speech_to_speech",
-        "audio_filename": file.filename,
+        "audio_filename": file.filename if file else None,
+        "input": "audio" if file else "audio_url" if audio_url else "correction" if transcript_override is not None else "text",
         "language": language,
         "client_ip": getattr(request.client, "host", None),
     })
 
+    session_id = (request.headers.get("X-Session-ID") or "").strip() or None
+    request_id = getattr(request.state, "request_id", None)
+    if session_id and len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"X-Session-ID must be <= {_MAX_SESSION_ID_LEN} characters")
+
+    async def respond() -> Response:
+        try:
+            upload = await fetch_audio(audio_url) if audio_url else file
+            # The slot covers the work up to the first audio bytes; the rest streams at the client's pace.
+            async with pipeline_limiter.slot():
+                turn = await converse(
+                    upload,
+                    mode=mode,
+                    agent_name=agent_name,
+                    session_id=session_id,
+                    request_id=request_id,
+                    language=language,
+                    text=typed,
+                    generation=GenerationParams(model=model, temperature=temperature, top_p=top_p, max_tokens=max_tokens),
+                    grammar=constraint,
+                    input_language=input_language,
+                    output_language=output_language,
+                    persona=profile,
+                    verbosity=verbosity,
+                    correction=transcript_override is not None,
+                    echo=skip_llm,
+                )
+                transcription, llm_text = turn.transcription, turn.reply
+
+                if not (skip_tts or return_segments):
+                    tts_stream = await stream_tts(llm_text, request_id=request_id, language=turn.language, audio_format=audio_format)
+
+            turn_fields = {
+                "transcription": transcription,
+                "llm_response": llm_text,
+                **({"grammar": asdict(turn.grammar)} if turn.grammar else {}),
+                **_confirmation_fields(turn.confirmation),
+                **({"detected_language": language_tag(turn.language)} if language == AUTO_LANGUAGE else {}),
+                **({"language_fallback": {
+                    "requested": language_tag(turn.language_fallback[0]),
+                    "used": language_tag(turn.language_fallback[1]),
+                }} if turn.language_fallback else {}),
+                **({"asr_confidence": turn.asr_confidence} if turn.asr_confidence is not None else {}),
+                **({"alternatives": list(turn.alternatives)} if turn.alternatives else {}),
+                **({"low_confidence": True} if turn.low_confidence else {}),
+                **({"corrected": True} if transcript_override is not None else {}),
+                **({"budget_hits": list(turn.budget_hits)} if turn.budget_hits else {}),
+                **({"translation": turn.translation.as_dict()} if turn.translation else {}),
+            }
+            if skip_tts:
+                return JSONResponse(content={**turn_fields, **_turn_report()})
+            if return_segments:
+                manifest = segment_manifest(llm_text, turn.language, request_id)
+                return JSONResponse(content={**turn_fields, **manifest, **_turn_report()})
+            logger.info("TTS audio stream opened", extra={"content_type": tts_stream.content_type})
+            if return_json:
+                marks = tts_word_timings(tts_stream, llm_text)
+                audio_bytes = await tts_stream.read()
+                cues = caption_cues(llm_text, audio_duration(audio_bytes), marks)
+                if return_url:
+                    audio_fields = await deliver(audio_bytes, tts_stream.media_type, request_id)
+                else:
+                    audio_fields = {
+                        "audio_base64": base64.b64encode(audio_bytes).decode("utf-8"),
+                        "audio_content_type": tts_stream.media_type,
+                    }
+                return JSONResponse(content={
+                    **turn_fields,
+                    **audio_fields,
+                    "captions": [cue.as_dict() for cue in cues],
+                    "word_timing": "tts" if marks else "estimated",
+                    **({"subtitles": subtitles(cues, subtitles_format)} if subtitles_format else {}),
+                    **_turn_report(),
+                })
+            track_playback(tts_stream, request_id, store=True)
+            if return_events:
+                return PlaybackResponse(
+                    tts_stream,
+                    _reply_events(turn_fields, tts_stream, subtitles_format),
+                    media_type="application/x-ndjson",
+                    headers={"Cache-Control": "no-cache"},
+                )
+            response = await _audio_response(tts_stream, audio_format)
+            if turn.grammar:
+                response.headers["X-Grammar-Match"] = "true" if turn.grammar.matched else "false"
+                if turn.grammar.value is not None:
+                    response.headers["X-Grammar-Value"] = quote(turn.grammar.value)
+            if language == AUTO_LANGUAGE:
+                response.headers["X-Detected-Language"] = language_tag(turn.language) or "unknown"
+            if turn.language_fallback:
+                response.headers["X-Language-Fallback"] = language_tag(turn.language_fallback[1]) or "unknown"
+            if turn.low_confidence:
+                response.headers["X-ASR-Low-Confidence"] = "true"
+            if turn.budget_hits:
+                response.headers["X-Budget-Hit"] = ",".join(turn.budget_hits)
+            if turn.confirmation:
+                if turn.confirmation.status != "emitted":
+                    response.headers["X-Confirmation"] = turn.confirmation.status
+                if turn.confirmation.intent:
+                    response.headers["X-Intent"] = quote(turn.confirmation.intent.name)
+            return response
+        except httpx.TimeoutException:
+            logger.error("External speech-to-speech API timed out")
+            raise HTTPException(status_code=504, detail="External API timeout")
+        except httpx.HTTPError as e:
+            logger.error(f"External speech-to-speech API error: {e}")
+            raise HTTPException(status_code=502, detail="External API error")
+
+    idempotency_key = request_key(request)
+    if idempotency_key is None:
+        if file is None or not (replay_guard.enabled or results_enabled()):
+            return await respond()
+        upload = await upload_digest(file)
+        key = replay_key(request, upload, file.content_type, session_id)
+        return await (replay_guard.run(key, respond) if replay_guard.enabled else reuse_result(key, respond))
+    upload = await upload_digest(file) if file is not None else ""
+    request_fingerprint = fingerprint(
+        str(request.url.query), session_id, typed or audio_url, file.content_type if file else None, upload
+    )
+    return await idempotency_cache.run(idempotency_key, request_fingerprint, respond)
+
+
+@router.get(
+    "/replies/segments/{token}",
+    summary="One segment of a segmented reply",
+    description=(
+        "Synthesize one segment of a format=segments reply. The URL is its own credential, so no API key is needed; "
+        "it stops working after DWANI_REPLY_SEGMENT_TTL_SECONDS."
+    ),
+    tags=["Audio"],
+    responses={
+        200: {"content": {"audio/mpeg": {}, "audio/wav": {}, "audio/ogg": {}}},
+        **error_responses(400, 404, 429, 502, 503, 504),
+    },
+)
+@limiter.limit("120/minute")
+async def reply_segment(
+    request: Request,
+    token: str,
+    format: Optional[str] = Query(None, description="Audio format: mp3, wav, ogg/opus"),
+) -> Response:
+    segment = open_token(token)
+    audio_format = normalize_format(format)
+    async with pipeline_limiter.slot():
+        tts_stream = await stream_tts(
+            segment.text, request_id=segment.request_id, language=segment.language, audio_format=audio_format
+        )
+    response = await _audio_response(tts_stream, audio_format)
+    response.headers["X-Reply-Segment"] = f"{segment.index + 1}/{segment.count}"
+    return response
+
+
+async def _audio_url(request: Request) -> Optional[str]:
+    """audio_url from a JSON body; None when the request is not JSON."""
+    if request.headers.get("content-type", "").split(";")[0].strip().lower() != "application/json":
+        return None
     try:
-        session_id = (request.headers.get("X-Session-ID") or "").strip() or None
-        request_id = getattr(request.state, "request_id", None)
-        if session_id and len(session_id) > _MAX_SESSION_ID_LEN:
-            raise HTTPException(status_code=400, detail=f"X-Session-ID must be <= {_MAX_SESSION_ID_LEN} characters")
-        context = get_session_context(session_id) if session_id else []
-
-        asr_text = await transcribe_audio(file=file, request_id=request_id)
-        text = asr_text.text
-        if not text or not text.strip():
-            raise HTTPException(status_code=400, detail="No speech detected in the audio")
-
-        if mode == "agent":
-            selected_agent = agent_name or DEFAULT_AGENT_NAME
-            if selected_agent not in ALLOWED_AGENTS:
-                raise HTTPException(status_code=400, detail=f"agent_name must be one of {ALLOWED_AGENTS}")
-            agent_result = await call_agent(selected_agent, text, session_id=session_id, request_id=request_id)
-            llm_text = agent_result["reply"]
-        else:
-            llm_text = await call_llm(text, context=context, request_id=request_id)
+        payload = AudioURLRequest.model_validate(await request.json())
+    except ValueError:
+        raise HTTPException(status_code=422, detail='Expected a JSON body {"audio_url": "..."}')
+    return payload.audio_url.strip()
 
-        if not llm_text or not llm_text.strip():
-            raise HTTPException(status_code=502, detail="Text for TTS is empty")
 
-        if session_id:
-            append_to_session(session_id, text, llm_text)
-
-        base_url = f"{os.getenv('DWANI_API_BASE_URL_TTS')}/v1/audio/speech"
-        async with httpx.AsyncClient(timeout=TTS_TIMEOUT) as client:
-            tts_response = await client.post(
-                base_url,
-                json={"text": llm_text},
-                headers={
-                    "accept": "*/*",
-                    "Content-Type": "application/json",
-                    **({"X-Request-ID": request_id} if request_id else {}),
-                },
-            )
-            tts_response.raise_for_status()
-            audio_bytes = tts_response.content
+@router.post(
+    "/image_to_speech",
+    summary="Image to speech (OCR)",
+    description=(
+        "Read the text in a photographed document aloud, or (mode=summary) explain what it says "
+        "in simple words in the chosen language."
+    ),
+    tags=["Audio"],
+    responses={
+        200: {
+            "description": "Speech in the requested format, or JSON when format=json",
+            "model": ImageToSpeechJSONResponse,
+            "content": {"audio/mpeg": {}, "audio/wav": {}, "audio/ogg": {}},
+        },
+        **error_responses(400, 401, 413, 422, 429, 502, 503, 504),
+    },
+)
+@limiter.limit("20/minute")
+async def image_to_speech(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    file: UploadFile = File(..., description="Photo or scan (JPEG, PNG, WebP, GIF)"),
+    language: Optional[str] = Query(None, description=f"Language to speak, one of {ALLOWED_LANGUAGES} or a BCP-47 tag"),
+    mode: str = Query("ocr", description="'ocr' reads the text as written; 'summary' explains it in language"),
+    format: Optional[str] = Query(None, description="Audio format: mp3, wav, ogg/opus; or json for a JSON body"),
+) -> Response:
+    if mode not in IMAGE_MODES:
+        raise HTTPException(status_code=400, detail=f"mode must be one of {list(IMAGE_MODES)}")
+    return_json = format == "json"
+    audio_format = None if return_json else normalize_format(format)
+    language = parse_language(language)
+    request_id = getattr(request.state, "request_id", None)
+    async with pipeline_limiter.slot():
+        text = await read_image(file, mode=mode, language=language, request_id=request_id)
+        spoken, over_tts_budget = cut_to_tts_budget(text)
+        tts_stream = await stream_tts(spoken, request_id=request_id, language=language, audio_format=audio_format)
+    if return_json:
+        return JSONResponse(content={
+            "text": text,
+            "audio_base64": base64.b64encode(await tts_stream.read()).decode("utf-8"),
+            "audio_content_type": tts_stream.media_type,
+            **({"budget_hits": ["tts_chars"]} if over_tts_budget else {}),
+        })
+    response = await _audio_response(tts_stream, audio_format)
+    if over_tts_budget:
+        response.headers["X-Budget-Hit"] = "tts_chars"
+    return response
 
-        if not audio_bytes or len(audio_bytes) == 0:
-            logger.error("TTS returned empty audio", extra={"base_url": base_url, "status_code": tts_response.status_code})
-            raise HTTPException(status_code=502, detail="TTS service returned empty audio; no MP3 data received")
 
-        logger.info("TTS audio received", extra={"content_length": len(audio_bytes), "content_type": tts_response.headers.get("Content-Type")})
+def _segment_dict(segment: DictationSegment) -> Dict[str, Any]:
+    return {
+        "index": segment.index,
+        "start_seconds": segment.start_seconds,
+        "end_seconds": segment.end_seconds,
+        "text": segment.text,
+        "raw_text": segment.raw_text,
+        "restored": segment.restored,
+    }
 
-        return_json = request.query_params.get("format") == "json"
-        if return_json:
-            return JSONResponse(content={
-                "transcription": text,
-                "llm_response": llm_text,
-                "audio_base64": base64.b64encode(audio_bytes).decode("utf-8"),
-            })
-        headers = {
-            "Content-Disposition": "inline; filename=\"speech.mp3\"",
-            "Cache-Control": "no-cache",
-            "Content-Type": "audio/mp3",
-        }
-        return Response(content=audio_bytes, media_type="audio/mp3", headers=headers)
-    except httpx.TimeoutException:
-        logger.error("External speech-to-speech API timed out")
-        raise HTTPException(status_code=504, detail="External API timeout")
-    except httpx.HTTPError as e:
-        logger.error(f"External speech-to-speech API error: {e}")
-        raise HTTPException(status_code=502, detail=f"External API error: {str(e)}")
+
+@router.post(
+    "/dictation",
+    summary="Dictation (long-form transcription)",
+    description=(
+        "Transcribe a long recording into punctuated paragraphs without an LLM reply. "
+        "Streams one NDJSON line per paragraph as it is ready; format=json returns the whole document."
+    ),
+    tags=["Audio"],
+    responses={
+        200: {
+            "description": "NDJSON segment lines ending with a done line, or JSON when format=json",
+            "model": DictationResponse,
+            "content": {"application/x-ndjson": {}},
+        },
+        **error_responses(400, 401, 413, 415, 429, 502, 503, 504),
+    },
+)
+@limiter.limit("10/minute")
+async def dictation(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    file: UploadFile = File(..., description="Audio to transcribe; long WAV recordings are split at pauses"),
+    language: Optional[str] = Query(None, description=f"Spoken language, one of {ALLOWED_LANGUAGES} or a BCP-47 tag"),
+    format: Optional[str] = Query(None, description="'json' for a single JSON document instead of NDJSON"),
+) -> Response:
+    language = parse_language(language)
+    request_id = getattr(request.state, "request_id", None)
+    compression: List[SilenceCompression] = []
+    segments = dictate(file, language=language, request_id=request_id, on_compressed=compression.append)
+    # Wait for the first paragraph so early failures get a proper error status.
+    try:
+        first = await segments.__anext__()
+    except StopAsyncIteration:
+        raise RequestError(400, "empty_input", "Empty audio file")
+
+    if format == "json":
+        collected = [first] + [segment async for segment in segments]
+        return JSONResponse(content={
+            "text": "\n\n".join(s.text for s in collected),
+            "segments": [_segment_dict(s) for s in collected],
+            "silence": compression[0].as_dict() if compression else None,
+        })
+
+    async def ndjson():
+        yield json.dumps({"type": "segment", **_segment_dict(first)}, ensure_ascii=False) + "\n"
+        count = 1
+        try:
+            async for segment in segments:
+                yield json.dumps({"type": "segment", **_segment_dict(segment)}, ensure_ascii=False) + "\n"
+                count += 1
+        except HTTPException as exc:
+            logger.error("Dictation failed after %s segments: %s", count, exc.detail)
+            yield json.dumps({"type": "error", "code": str(exc.status_code), "message": str(exc.detail), **error_fields(code_for(exc))}, ensure_ascii=False) + "\n"
+            return
+        done = {"type": "done", "segments": count}
+        if compression:
+            done["silence"] = compression[0].as_dict()
+        yield json.dumps(done) + "\n"
+
+    return StreamingResponse(ndjson(), media_type="application/x-ndjson", headers={"Cache-Control": "no-cache"})
+
+
+@router.post(
+    "/pronunciation",
+    summary="Pronunciation assessment",
+    description=(
+        "Score a recording of the learner reading reference_text aloud: per-word accuracy "
+        "(correct, mispronounced, omitted), words added, and overall accuracy, completeness, and fluency."
+    ),
+    tags=["Audio"],
+    response_model=PronunciationResponse,
+    responses=error_responses(400, 401, 413, 415, 422, 429, 502, 503, 504),
+)
+@limiter.limit("20/minute")
+async def pronunciation(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    file: UploadFile = File(..., description="The learner reading the reference text; WAV also gets pace and pause scoring"),
+    reference_text: str = Form(..., description="The sentence or passage the learner was asked to read"),
+    language: Optional[str] = Query(None, description=f"Language of the text, one of {ALLOWED_LANGUAGES} or a BCP-47 tag"),
+) -> Dict[str, Any]:
+    language = parse_language(language)
+    request_id = getattr(request.state, "request_id", None)
+    assessment = await assess_pronunciation(file, reference_text.strip(), language=language, request_id=request_id)
+    return assessment.as_dict()
+
+
+@router.post(
+    "/meetings/summarize",
+    summary="Meeting summary",
+    description=(
+        "Transcribe a meeting recording with speaker labels and summarize it: a short summary, "
+        "key points, decisions, and action items with owner and due date; speak=true also returns "
+        "the summary as speech."
+    ),
+    tags=["Audio"],
+    response_model=MeetingSummaryResponse,
+    responses=error_responses(400, 401, 413, 415, 422, 429, 502, 503, 504),
+)
+@limiter.limit("5/minute")
+async def meetings_summarize(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    file: UploadFile = File(..., description="Meeting recording; long WAV recordings are split at pauses"),
+    language: Optional[str] = Query(None, description=f"Spoken language, one of {ALLOWED_LANGUAGES} or a BCP-47 tag"),
+    summary_language: Optional[str] = Query(None, description="Language of the minutes (default: language, else English)"),
+    speak: bool = Query(False, description="Also synthesize the summary and action items"),
+    format: Optional[str] = Query(None, description="Audio format of the spoken summary: mp3, wav, ogg/opus"),
+) -> Dict[str, Any]:
+    audio_format = normalize_format(format)
+    language = parse_language(language)
+    summary_language = parse_language(summary_language, "summary_language")
+    request_id = getattr(request.state, "request_id", None)
+    minutes = await summarize_meeting(file, language=language, summary_language=summary_language, request_id=request_id)
+    result = minutes.as_dict()
+    if speak:
+        spoken, over_tts_budget = cut_to_tts_budget(minutes.spoken_text())
+        async with pipeline_limiter.slot():
+            tts_stream = await stream_tts(
+                spoken, request_id=request_id, language=summary_language or language, audio_format=audio_format
+            )
+        audio = await tts_stream.read()
+        media_type = tts_stream.media_type
+        if audio_format and tts_stream.audio_format != audio_format:
+            audio, media_type = await transcode(audio, audio_format), content_type_for(audio_format)
+        result["audio_base64"] = base64.b64encode(audio).decode("utf-8")
+        result["audio_content_type"] = media_type
+        if over_tts_budget:
+            result["budget_hits"] = ["tts_chars"]
+    return result
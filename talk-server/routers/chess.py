@@ -1,11 +1,11 @@
 import os
 from typing import Any, Dict
 
-import httpx
 from fastapi import APIRouter, Depends, Request
 
 from config import LLM_TIMEOUT, logger
 from deps import get_optional_user, limiter
+from services.http_client import http_client
 
 router = APIRouter(prefix="/v1/chess", tags=["Chess"])
 
@@ -19,8 +19,7 @@ async def get_chess_state(request: Request, __=Depends(get_optional_user)) -> Di
         raise HTTPException(status_code=502, detail="Agent service base URL is not configured")
     url = f"{agent_base}/v1/chess/state"
     try:
-        async with httpx.AsyncClient(timeout=LLM_TIMEOUT) as client:
-            resp = await client.get(url)
+        resp = await http_client().get(url, timeout=LLM_TIMEOUT)
     except Exception as exc:
         logger.error(f"Chess state request failed: {exc}")
         from fastapi import HTTPException
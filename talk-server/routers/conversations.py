@@ -0,0 +1,94 @@
+"""Admin API for the compliance state of stored conversations (services/retention.py).
+
+Only for DWANI_ADMIN_TOKEN (X-Admin-Token or Bearer). Conversations are addressed by their
+session_key, as GraphQL reports it (the SHA-256 of X-Session-ID, never the ID itself).
+"""
+from datetime import timedelta
+from typing import Any, Dict, Optional
+
+from fastapi import APIRouter, Depends, HTTPException
+from pydantic import BaseModel, Field
+from starlette.concurrency import run_in_threadpool
+
+import conversation_store
+from config import CONVERSATION_DELETE_GRACE_DAYS, STORE_CONVERSATIONS, logger
+from deps import require_admin
+from services.retention import purge_once
+
+router = APIRouter(prefix="/admin/conversations", include_in_schema=False, dependencies=[Depends(require_admin)])
+
+
+class LegalHoldRequest(BaseModel):
+    reason: Optional[str] = Field(None, max_length=255, description="Matter or ticket the hold is for")
+
+
+def _require_storage() -> None:
+    if not STORE_CONVERSATIONS:
+        raise HTTPException(status_code=404, detail="Conversations are not stored (set DWANI_STORE_CONVERSATIONS=1)")
+
+
+async def _state(session_key: str) -> Dict[str, Any]:
+    """The conversation's turns and flags; 404 when it has no stored turns."""
+    _require_storage()
+    summary = await run_in_threadpool(conversation_store.get_conversation, session_key, True)
+    if summary is None:
+        raise HTTPException(status_code=404, detail="Conversation not found")
+    flags = await run_in_threadpool(conversation_store.conversation_flags, session_key)
+    deleted_at = flags.deleted_at if flags is not None else None
+    return {
+        "session_key": session_key,
+        "turn_count": summary.turn_count,
+        "legal_hold": bool(flags and flags.legal_hold),
+        "hold_reason": flags.hold_reason if flags is not None else None,
+        "deleted_at": deleted_at.isoformat() if deleted_at else None,
+        "erase_after": (deleted_at + timedelta(days=CONVERSATION_DELETE_GRACE_DAYS)).isoformat() if deleted_at else None,
+    }
+
+
+@router.post("/purge")
+async def purge() -> Dict[str, Any]:
+    """Run the retention purge now; the turns erased per reason."""
+    _require_storage()
+    return {"purged": await run_in_threadpool(purge_once)}
+
+
+@router.get("/{session_key}")
+async def get_state(session_key: str) -> Dict[str, Any]:
+    return await _state(session_key)
+
+
+@router.put("/{session_key}/hold")
+async def place_hold(session_key: str, payload: LegalHoldRequest) -> Dict[str, Any]:
+    await _state(session_key)
+    await run_in_threadpool(conversation_store.set_legal_hold, session_key, True, payload.reason)
+    logger.warning("Legal hold placed on a conversation", extra={"session_key": session_key, "hold_reason": payload.reason})
+    return await _state(session_key)
+
+
+@router.delete("/{session_key}/hold")
+async def release_hold(session_key: str) -> Dict[str, Any]:
+    await _state(session_key)
+    await run_in_threadpool(conversation_store.set_legal_hold, session_key, False)
+    logger.warning("Legal hold released on a conversation", extra={"session_key": session_key})
+    return await _state(session_key)
+
+
+@router.delete("/{session_key}")
+async def delete_conversation(session_key: str) -> Dict[str, Any]:
+    """Soft-delete: hidden now, erased after DWANI_CONVERSATION_DELETE_GRACE_DAYS; 409 under legal hold."""
+    await _state(session_key)
+    try:
+        await run_in_threadpool(conversation_store.soft_delete, session_key)
+    except conversation_store.LegalHold:
+        raise HTTPException(status_code=409, detail="Conversation is under legal hold")
+    logger.warning("Conversation deleted", extra={"session_key": session_key})
+    return await _state(session_key)
+
+
+@router.post("/{session_key}/restore")
+async def restore_conversation(session_key: str) -> Dict[str, Any]:
+    await _state(session_key)
+    if await run_in_threadpool(conversation_store.restore, session_key) is None:
+        raise HTTPException(status_code=409, detail="Conversation is not deleted")
+    logger.warning("Deleted conversation restored", extra={"session_key": session_key})
+    return await _state(session_key)
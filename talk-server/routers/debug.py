@@ -0,0 +1,64 @@
+"""Diagnostics for production memory and CPU problems, like Go's net/http/pprof and /debug/vars.
+
+Off unless DWANI_DEBUG_ENDPOINTS=1, and then only for DWANI_ADMIN_TOKEN (X-Admin-Token or
+Bearer): they reveal internals and the profiler costs CPU. Each response describes the one
+worker that served it; under gunicorn, repeat the request to reach the others.
+"""
+import asyncio
+from typing import Any, Dict
+
+from fastapi import APIRouter, Depends, HTTPException, Query
+from fastapi.responses import PlainTextResponse
+
+from config import DEBUG_ENDPOINTS
+from deps import require_admin
+from services import diagnostics
+
+_MAX_PROFILE_SECONDS = 60
+
+
+def _enabled() -> None:
+    if not DEBUG_ENDPOINTS:
+        raise HTTPException(status_code=404, detail="Not Found")
+
+
+router = APIRouter(prefix="/debug", include_in_schema=False, dependencies=[Depends(_enabled), Depends(require_admin)])
+
+
+@router.get("/vars")
+async def debug_vars() -> Dict[str, Any]:
+    """Live config (secrets masked), backend health, and process, GC and task statistics."""
+    return {
+        "runtime": diagnostics.runtime_stats(),
+        "backends": diagnostics.backend_health(),
+        "config": diagnostics.live_config(),
+    }
+
+
+@router.post("/gc")
+async def debug_gc() -> Dict[str, Any]:
+    """Run a full collection, to tell garbage that was merely uncollected from a real leak."""
+    return diagnostics.collect_garbage()
+
+
+@router.get("/pprof/heap")
+async def debug_heap(
+    limit: int = Query(25, ge=1, le=500),
+    group_by: str = Query("lineno", pattern="^(lineno|filename|traceback)$"),
+) -> Dict[str, Any]:
+    top = await asyncio.to_thread(diagnostics.heap_top, limit, group_by)
+    if top is None:
+        raise HTTPException(status_code=409, detail="tracemalloc is off; set DWANI_DEBUG_TRACEMALLOC_FRAMES and restart")
+    return {"pid": diagnostics.runtime_stats()["pid"], "top": top}
+
+
+@router.get("/pprof/goroutine", response_class=PlainTextResponse)
+async def debug_stacks() -> str:
+    """Stacks of all threads and asyncio tasks."""
+    return diagnostics.stack_dump()
+
+
+@router.get("/pprof/profile", response_class=PlainTextResponse)
+async def debug_profile(seconds: float = Query(10, gt=0, le=_MAX_PROFILE_SECONDS)) -> str:
+    """Sampling CPU profile as folded stacks (flamegraph.pl, speedscope)."""
+    return await asyncio.to_thread(diagnostics.sample_profile, seconds)
@@ -0,0 +1,46 @@
+"""Browser demo page at /: record from the microphone, send it to /v1/speech_to_speech, play the reply.
+
+For manual testing and demos without the talk-ui frontend; DWANI_DEMO_PAGE=0 turns it off.
+The page is static/demo.html, read once at import and shipped in the image with the code.
+In demo mode (services/demo.py) the page gets its API key from /demo/key.
+"""
+import html
+from pathlib import Path
+
+from fastapi import APIRouter, HTTPException
+from fastapi.responses import HTMLResponse, JSONResponse
+
+from config import DEMO_MODE, DEMO_PAGE
+from models import ALLOWED_LANGUAGES
+from services.demo import issue_key
+
+router = APIRouter()
+_PAGE_PATH = Path(__file__).resolve().parent.parent / "static" / "demo.html"
+_PAGE = _PAGE_PATH.read_text(encoding="utf-8").replace(
+    "{{LANGUAGE_OPTIONS}}",
+    "".join(f'<option value="{html.escape(lang)}">{html.escape(lang.title())}</option>' for lang in ALLOWED_LANGUAGES),
+).replace("{{DEMO_MODE}}", "true" if DEMO_MODE else "false")
+_PAGE_HEADERS = {
+    "Cache-Control": "no-cache",
+    "Content-Security-Policy": (
+        "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; "
+        "connect-src 'self'; media-src data:; form-action 'none'"
+    ),
+    # The page asks for the microphone for itself only.
+    "Permissions-Policy": "microphone=(self)",
+}
+
+
+@router.get("/", include_in_schema=False)
+async def demo_page() -> HTMLResponse:
+    if not DEMO_PAGE:
+        raise HTTPException(status_code=404, detail="Not Found")
+    return HTMLResponse(_PAGE, headers=_PAGE_HEADERS)
+
+
+@router.get("/demo/key", include_in_schema=False)
+async def demo_key() -> JSONResponse:
+    """The rotating demo API key; only in demo mode."""
+    if not DEMO_MODE or not DEMO_PAGE:
+        raise HTTPException(status_code=404, detail="Not Found")
+    return JSONResponse(issue_key(), headers={"Cache-Control": "no-store"})
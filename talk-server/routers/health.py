@@ -1,8 +1,17 @@
 import os
-from typing import Any, Dict
+import time
+from typing import Any, Dict, Tuple
 
-import httpx
-from fastapi import APIRouter
+from fastapi import APIRouter, Depends
+from fastapi.responses import JSONResponse
+
+from config import BACKEND_BALANCING
+from deps import require_api_key
+from services.balancer import pool, pools, split_urls
+from services.build_info import build_info
+from services.drain import draining
+from services.http_client import http_client
+from services.warmup import warmer
 
 router = APIRouter(tags=["Health"])
 
@@ -13,24 +22,93 @@ async def health() -> Dict[str, str]:
     return {"status": "ok"}
 
 
-@router.get("/ready")
-async def ready() -> Dict[str, Any]:
-    """Readiness: dependencies (chat-completions, TTS, LLM) are reachable."""
+# Set once /startup has seen every backend; a startup probe is not asked again afterwards.
+_started = False
+
+
+async def _probe() -> Tuple[bool, Dict[str, str]]:
+    """Whether every configured stage has a reachable instance, and the check per instance.
+
+    With several URLs per stage each instance is probed (as "tts[0]", "tts[1]", ...), the
+    result feeds the balancer's health tracking, and the stage is ready while any instance is.
+    """
     checks = {}
-    async with httpx.AsyncClient(timeout=5.0) as client:
-        for name, url in [
-            ("chat_completions", os.getenv("DWANI_CHAT_COMPLETIONS_URL", "").strip() or None),
-            ("tts", os.getenv("DWANI_API_BASE_URL_TTS", "").rstrip("/") + "/" if os.getenv("DWANI_API_BASE_URL_TTS") else None),
-            ("llm", os.getenv("DWANI_API_BASE_URL_LLM", "").rstrip("/") + "/v1/models" if os.getenv("DWANI_API_BASE_URL_LLM") else None),
-        ]:
-            if not url:
-                checks[name] = "skipped (no url)"
-                continue
+    client = http_client()
+    stages = [
+        ("chat_completions", "asr", os.getenv("DWANI_CHAT_COMPLETIONS_URL", "").strip(), ""),
+        ("tts", "tts", os.getenv("DWANI_API_BASE_URL_TTS", ""), "/"),
+        ("llm", "llm", os.getenv("DWANI_API_BASE_URL_LLM", ""), "/v1/models"),
+    ]
+    stage_ok = []
+    for name, stage, raw, probe_path in stages:
+        if not split_urls(raw):
+            checks[name] = "skipped (no url)"
+            continue
+        backend_pool = pool(stage, raw)
+        results = []
+        for index, instance in enumerate(backend_pool.instances):
+            key = name if len(backend_pool.instances) == 1 else f"{name}[{index}]"
             try:
-                r = await client.get(url)
+                r = await client.get(instance.url + probe_path, timeout=5.0)
                 # Some APIs may not allow GET on chat-completions endpoints (405).
                 ok = (r.status_code < 500) or (r.status_code in (401, 405))
-                checks[name] = "ok" if ok else f"error {r.status_code}"
+                checks[key] = "ok" if ok else f"error {r.status_code}"
             except Exception as e:
-                checks[name] = f"unreachable: {type(e).__name__}"
-    return {"status": "ok" if all("ok" in str(v) or "skipped" in str(v) for v in checks.values()) else "degraded", "checks": checks}
+                ok = False
+                checks[key] = f"unreachable: {type(e).__name__}"
+            if ok:
+                backend_pool.record_success(instance)
+            else:
+                backend_pool.record_failure(instance)
+            results.append(ok)
+        stage_ok.append(any(results))
+    return all(stage_ok), checks
+
+
+def _warming_up() -> JSONResponse:
+    return JSONResponse(status_code=503, content={"status": "warming_up", "pending": sorted(warmer.pending)})
+
+
+@router.get("/ready")
+async def ready():
+    """Readiness: dependencies (chat-completions, TTS, LLM) are reachable; 503 while draining or warming up."""
+    if draining():
+        return JSONResponse(status_code=503, content={"status": "draining"})
+    if not warmer.warm:
+        return _warming_up()
+    ok, checks = await _probe()
+    return {"status": "ok" if ok else "degraded", "checks": checks}
+
+
+@router.get("/startup")
+async def startup():
+    """Startup probe: 503 until every configured backend stage has been reached once (and warmed up), then always ok."""
+    global _started
+    if not _started:
+        if not warmer.warm:
+            return _warming_up()
+        ok, checks = await _probe()
+        if not ok:
+            return JSONResponse(status_code=503, content={"status": "starting", "checks": checks})
+        _started = True
+    return {"status": "ok"}
+
+
+@router.get("/version")
+async def version(_: None = Depends(require_api_key)) -> Dict[str, Any]:
+    """The running build: version, commit, build date, Python and enabled features (services/build_info.py)."""
+    return build_info()
+
+
+@router.get("/ready/backends")
+async def ready_backends(_: None = Depends(require_api_key)) -> Dict[str, Any]:
+    """Per-instance health and latency as tracked by the balancer in this worker (URLs are internal, so keyed)."""
+    now = time.monotonic()
+    return {
+        "strategy": BACKEND_BALANCING,
+        "pools": [
+            {"stage": p.stage, "instances": [i.snapshot(now) for i in p.instances]}
+            for p in pools()
+            if any(i.url for i in p.instances)
+        ],
+    }
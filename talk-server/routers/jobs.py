@@ -0,0 +1,95 @@
+from typing import Any, Dict, Optional
+
+from fastapi import APIRouter, Depends, File, HTTPException, Query, Request, UploadFile
+
+from config import WEBHOOK_ALLOW_PRIVATE
+from deps import get_optional_user, limiter, require_api_key
+from models import ALLOWED_LANGUAGES, error_responses
+from services.blob_store import blob_store
+from services.branding import current_tenant
+from services.egress import check_host
+from services.experiments import current_tags
+from services.jobs import Job, job_manager
+from services.language_id import parse_language
+from services.personas import resolve_persona
+from services.preprocess import current_steps, steps_name
+from services.tenants import current_tenant_name, tenants
+
+router = APIRouter(prefix="/v1/jobs", tags=["Audio"])
+_MAX_SESSION_ID_LEN = 128
+
+
+def _caller_owns(job: Job) -> bool:
+    """Whether the caller's tenant submitted job; like sessions, other tenants' jobs do not exist for it.
+
+    job.tenant is the branding tenant, which can be a host with branding but no tenant settings;
+    such jobs belong to the deployment, as they ran with its settings.
+    """
+    owner = tenants.get(job.tenant or "")
+    return (owner.name if owner is not None else None) == current_tenant_name()
+
+
+@router.post(
+    "/speech_to_speech",
+    status_code=202,
+    summary="Queue a speech-to-speech job",
+    description="Accept an upload and run the pipeline in the background. Poll the job or pass webhook_url to receive the result.",
+    responses=error_responses(400, 401, 413, 429, 503),
+)
+@limiter.limit("20/minute")
+async def create_speech_to_speech_job(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    file: UploadFile = File(..., description="Audio file to process"),
+    mode: str = Query("llm", description="Processing mode: 'llm' or 'agent'"),
+    agent_name: Optional[str] = Query(None, description="Agent name when mode='agent'"),
+    language: Optional[str] = Query(
+        None, description=f"Spoken and reply language, one of {ALLOWED_LANGUAGES} or a BCP-47 tag, or 'auto' to detect it"
+    ),
+    webhook_url: Optional[str] = Query(None, description="URL that receives the finished job as a JSON POST"),
+    persona: Optional[str] = Query(None, max_length=64, description="Assistant persona configured on the server (mode='llm')"),
+    format: Optional[str] = Query(
+        None, description="url to store the reply audio in object storage (DWANI_BLOB_STORE_URI) and return a signed URL"
+    ),
+) -> Dict[str, Any]:
+    if mode not in {"llm", "agent"}:
+        raise HTTPException(status_code=400, detail="mode must be 'llm' or 'agent'")
+    language = parse_language(language, allow_auto=True)
+    profile = resolve_persona(persona)
+    if format not in (None, "url"):
+        raise HTTPException(status_code=400, detail="format must be 'url' (or unset for audio_base64)")
+    if format == "url" and blob_store() is None:
+        raise HTTPException(status_code=400, detail="format=url needs DWANI_BLOB_STORE_URI")
+    if webhook_url:
+        await check_host(webhook_url, WEBHOOK_ALLOW_PRIVATE, "webhook_url")
+    session_id = (request.headers.get("X-Session-ID") or "").strip() or None
+    if session_id and len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"X-Session-ID must be <= {_MAX_SESSION_ID_LEN} characters")
+
+    tags = current_tags()
+    job = await job_manager.submit(
+        file,
+        mode=mode,
+        agent_name=agent_name,
+        session_id=session_id,
+        request_id=getattr(request.state, "request_id", None),
+        webhook_url=webhook_url,
+        language=language,
+        persona=profile.name if profile else None,
+        experiment=tags["experiment"],
+        variant=tags["variant"],
+        preprocess=steps_name(current_steps()),
+        tenant=current_tenant(),
+        delivery=format,
+    )
+    return {"job_id": job.id, "status": job.status, "status_url": f"/v1/jobs/{job.id}"}
+
+
+@router.get("/{job_id}", summary="Get speech-to-speech job status and result", responses=error_responses(401, 404, 429))
+@limiter.limit("120/minute")
+async def get_job(request: Request, job_id: str, _: None = Depends(require_api_key)) -> Dict[str, Any]:
+    job = job_manager.get(job_id)
+    if job is None or not _caller_owns(job):
+        raise HTTPException(status_code=404, detail="Job not found")
+    return job.public()
@@ -0,0 +1,82 @@
+import asyncio
+import json
+from typing import Any, AsyncIterator, Dict
+
+from fastapi import APIRouter, Depends, HTTPException, Query, Request
+from fastapi.responses import JSONResponse, Response, StreamingResponse
+
+from deps import require_api_key
+from models import error_responses
+from services.ids import new_id
+from services.mcp import handle_message, parse_error
+
+router = APIRouter(prefix="/mcp", tags=["MCP"])
+
+# SSE sessions live in this worker's memory; with several workers, route a client's
+# /mcp/sse and /mcp/messages to the same one (or use the stateless POST /mcp).
+_sse_queues: Dict[str, "asyncio.Queue[Dict[str, Any]]"] = {}
+_SSE_KEEPALIVE_SECONDS = 15.0
+
+
+async def _read_message(request: Request) -> Any:
+    try:
+        return json.loads(await request.body())
+    except (json.JSONDecodeError, UnicodeDecodeError):
+        return None
+
+
+@router.post("", summary="MCP JSON-RPC over plain HTTP (one request, one response)", responses=error_responses(400, 401, 429))
+async def mcp_post(request: Request, _: None = Depends(require_api_key)) -> Response:
+    message = await _read_message(request)
+    if message is None:
+        return JSONResponse(parse_error(), status_code=400)
+    reply = await handle_message(message, request_id=getattr(request.state, "request_id", None))
+    if reply is None:
+        return Response(status_code=202)
+    return JSONResponse(reply)
+
+
+@router.get("/sse", summary="Open an MCP SSE session", responses=error_responses(401, 429))
+async def mcp_sse(request: Request, _: None = Depends(require_api_key)) -> StreamingResponse:
+    session_id = new_id("session")
+    queue: "asyncio.Queue[Dict[str, Any]]" = asyncio.Queue()
+    _sse_queues[session_id] = queue
+
+    async def _events() -> AsyncIterator[str]:
+        try:
+            yield f"event: endpoint\ndata: /mcp/messages?session_id={session_id}\n\n"
+            while not await request.is_disconnected():
+                try:
+                    reply = await asyncio.wait_for(queue.get(), timeout=_SSE_KEEPALIVE_SECONDS)
+                except asyncio.TimeoutError:
+                    yield ": keepalive\n\n"
+                    continue
+                yield f"event: message\ndata: {json.dumps(reply, ensure_ascii=False)}\n\n"
+        finally:
+            _sse_queues.pop(session_id, None)
+
+    return StreamingResponse(_events(), media_type="text/event-stream", headers={"Cache-Control": "no-cache"})
+
+
+@router.post(
+    "/messages",
+    status_code=202,
+    summary="Send a JSON-RPC message to an MCP SSE session",
+    responses=error_responses(401, 404, 429),
+)
+async def mcp_messages(
+    request: Request,
+    session_id: str = Query(..., description="Session from the SSE endpoint event"),
+    _: None = Depends(require_api_key),
+) -> Response:
+    queue = _sse_queues.get(session_id)
+    if queue is None:
+        raise HTTPException(status_code=404, detail="MCP session not found")
+    message = await _read_message(request)
+    if message is None:
+        await queue.put(parse_error())
+        return Response(status_code=202)
+    reply = await handle_message(message, request_id=getattr(request.state, "request_id", None))
+    if reply is not None:
+        await queue.put(reply)
+    return Response(status_code=202)
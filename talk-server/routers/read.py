@@ -0,0 +1,155 @@
+import asyncio
+from typing import Any, Dict, Optional
+
+from fastapi import APIRouter, Depends, File, Form, HTTPException, Query, Request, UploadFile
+from fastapi.responses import FileResponse, Response, StreamingResponse
+
+from config import READ_MAX_CHARS
+from deps import get_optional_user, limiter, require_api_key
+from models import ALLOWED_LANGUAGES, ReadResponse, error_responses
+from services.article import fetch_article, readable_chapters
+from services.language_id import parse_language
+from services.read_aloud import (
+    document_text,
+    hls_playlist,
+    parse_document,
+    plan_chunks,
+    read_artifacts,
+    stitch_mp3,
+    synthesize_chunks,
+    translate_chapters,
+)
+from services.ranges import ACCEPT_RANGES, ranged_response
+
+router = APIRouter(prefix="/v1/read", tags=["Audio"])
+
+
+def _public(manifest: Dict[str, Any]) -> Dict[str, Any]:
+    base = f"/v1/read/{manifest['id']}"
+    return {
+        "id": manifest["id"],
+        "title": manifest.get("title"),
+        "source_url": manifest.get("source_url"),
+        "audio_url": f"{base}/audio.mp3",
+        "playlist_url": f"{base}/playlist.m3u8",
+        "duration_seconds": manifest["duration_seconds"],
+        "segments": len(manifest["segments"]),
+        "characters": manifest["characters"],
+        "expires_at": manifest["expires_at"],
+        "chapters": manifest["chapters"],
+    }
+
+
+def _manifest_or_404(read_id: str) -> Dict[str, Any]:
+    manifest = read_artifacts.manifest(read_id)
+    if manifest is None:
+        raise HTTPException(status_code=404, detail="Read-aloud artifact not found or expired")
+    return manifest
+
+
+@router.post(
+    "",
+    summary="Read a document aloud",
+    description=(
+        "Synthesize long text, a document (plain text, text extracted from a PDF, or HTML), or the main article "
+        "of a web page chapter by chapter, optionally translated first. Returns links to the stitched MP3 and an HLS playlist, or the MP3 itself when output=audio."
+    ),
+    responses={
+        200: {
+            "description": "Artifact links and chapter timeline, or the stitched MP3 when output=audio",
+            "model": ReadResponse,
+            "content": {"audio/mpeg": {}},
+        },
+        **error_responses(400, 401, 413, 429, 502, 503, 504),
+    },
+)
+@limiter.limit("5/minute")
+async def read_aloud(
+    request: Request,
+    _: None = Depends(require_api_key),
+    __ = Depends(get_optional_user),
+    text: Optional[str] = Form(None, description="Text to read; use file for documents"),
+    file: Optional[UploadFile] = File(None, description="UTF-8 text or HTML document"),
+    url: Optional[str] = Form(None, description="Web page whose main article is read; navigation and comments are dropped"),
+    language: Optional[str] = Query(None, description=f"Reading language, one of {ALLOWED_LANGUAGES} or a BCP-47 tag"),
+    translate: bool = Query(False, description="Translate the text into language before reading it"),
+    output: str = Query("json", description="'json' for artifact links, 'audio' for the stitched MP3"),
+) -> Response:
+    if output not in {"json", "audio"}:
+        raise HTTPException(status_code=400, detail="output must be 'json' or 'audio'")
+    language = parse_language(language)
+    if sum(x is not None for x in (text, file, url)) != 1:
+        raise HTTPException(status_code=400, detail="Provide exactly one of text, file, or url")
+    if translate and not language:
+        raise HTTPException(status_code=400, detail="translate requires language")
+    request_id = getattr(request.state, "request_id", None)
+    title = None
+    if url is not None:
+        article = await fetch_article(url.strip())
+        title, url = article.title, article.url
+        chapters = readable_chapters(article)
+    else:
+        content_type = None
+        if file is not None:
+            content_type = file.content_type
+            text = document_text(await file.read())
+        if len(text) > READ_MAX_CHARS:
+            raise HTTPException(status_code=413, detail=f"Document too large (max {READ_MAX_CHARS} characters)")
+        chapters = parse_document(text, content_type)
+    if sum(len(c.title or "") + sum(map(len, c.paragraphs)) for c in chapters) > READ_MAX_CHARS:
+        raise HTTPException(status_code=413, detail=f"Document too large (max {READ_MAX_CHARS} characters)")
+    if translate and chapters:
+        chapters = await translate_chapters(chapters, language, request_id=request_id)
+
+    chunks = plan_chunks(chapters)
+    if not chunks:
+        raise HTTPException(status_code=400, detail="Document contains no readable text")
+    parts = await synthesize_chunks(chunks, language=language, request_id=request_id)
+    manifest = await asyncio.to_thread(read_artifacts.create, chunks, parts, chapters, title, url)
+    if output == "audio":
+        return Response(
+            content=stitch_mp3(parts),
+            media_type="audio/mpeg",
+            headers={"X-Read-Id": manifest["id"], "X-Audio-Duration": str(manifest["duration_seconds"])},
+        )
+    return _public(manifest)
+
+
+# Artifact reads take no API key: HLS players cannot send one, and the random ID is the capability.
+@router.get("/{read_id}", summary="Get a read-aloud artifact", response_model=ReadResponse, responses=error_responses(404))
+async def get_read(read_id: str) -> Dict[str, Any]:
+    return _public(_manifest_or_404(read_id))
+
+
+@router.get(
+    "/{read_id}/playlist.m3u8",
+    summary="HLS playlist of a read-aloud artifact",
+    responses={200: {"content": {"application/vnd.apple.mpegurl": {}}}, **error_responses(404)},
+)
+async def get_read_playlist(read_id: str) -> Response:
+    return Response(content=hls_playlist(_manifest_or_404(read_id)), media_type="application/vnd.apple.mpegurl")
+
+
+@router.get(
+    "/{read_id}/segments/{index}.mp3",
+    summary="One segment of a read-aloud artifact",
+    responses={200: {"content": {"audio/mpeg": {}}}, **error_responses(404)},
+)
+async def get_read_segment(read_id: str, index: int) -> Response:
+    path = read_artifacts.segment_path(read_id, index)
+    if path is None:
+        raise HTTPException(status_code=404, detail="Read-aloud segment not found or expired")
+    return FileResponse(path, media_type="audio/mpeg")
+
+
+@router.get(
+    "/{read_id}/audio.mp3",
+    summary="Stitched MP3 of a read-aloud artifact",
+    responses={200: {"content": {"audio/mpeg": {}}}, **error_responses(404)},
+)
+async def get_read_audio(read_id: str, request: Request) -> Response:
+    manifest = _manifest_or_404(read_id)
+    if request.headers.get("range"):
+        audio = b"".join([part async for part in read_artifacts.iter_audio(read_id, manifest)])
+        return ranged_response(request, audio, "audio/mpeg")
+    return StreamingResponse(read_artifacts.iter_audio(read_id, manifest), media_type="audio/mpeg", headers=ACCEPT_RANGES)
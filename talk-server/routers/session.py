@@ -0,0 +1,207 @@
+from typing import Any, Dict, List, Optional
+
+from fastapi import APIRouter, Depends, HTTPException, Query, Request
+from fastapi.responses import JSONResponse, PlainTextResponse, Response
+from starlette.concurrency import run_in_threadpool
+
+import conversation_store
+from deps import limiter, require_api_key
+from models import (
+    SessionAssessmentResponse,
+    SessionErasureResponse,
+    SessionSlots,
+    SessionTranscriptResponse,
+    TurnDetectionPresetResponse,
+    TurnDetectionUpdate,
+    error_responses,
+)
+from services.assessment import assess_session
+from services.erasure import erase_session
+from services.language_id import parse_language
+from services.session import session_transcript
+from services.slots import get_slots, save_slots, update_slots
+from services.turn_detection import list_presets, session_preset, set_session_preset
+
+router = APIRouter(prefix="/v1", tags=["Chat"])
+_MAX_SESSION_ID_LEN = 128
+
+
+def _session_id(request: Request) -> str:
+    session_id = (request.headers.get("X-Session-ID") or "").strip()
+    if not session_id:
+        raise HTTPException(status_code=400, detail="X-Session-ID header is required")
+    if len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"X-Session-ID must be <= {_MAX_SESSION_ID_LEN} characters")
+    return session_id
+
+
+@router.get(
+    "/turn_detection/presets",
+    summary="List turn-detection presets",
+    response_model=List[TurnDetectionPresetResponse],
+    responses=error_responses(401, 429),
+)
+@limiter.limit("60/minute")
+async def turn_detection_presets(request: Request, _: None = Depends(require_api_key)) -> List[Dict[str, Any]]:
+    return [p.as_dict() for p in list_presets()]
+
+
+@router.get(
+    "/session/turn_detection",
+    summary="Get the session's turn-detection preset",
+    response_model=TurnDetectionPresetResponse,
+    responses=error_responses(400, 401, 429),
+)
+@limiter.limit("60/minute")
+async def get_turn_detection(request: Request, _: None = Depends(require_api_key)) -> Dict[str, Any]:
+    return session_preset(_session_id(request)).as_dict()
+
+
+@router.put(
+    "/session/turn_detection",
+    summary="Choose the session's turn-detection preset",
+    description="fast for quick commands, balanced for conversation, patient for dictation.",
+    response_model=TurnDetectionPresetResponse,
+    responses=error_responses(400, 401, 422, 429),
+)
+@limiter.limit("30/minute")
+async def put_turn_detection(
+    request: Request,
+    payload: TurnDetectionUpdate,
+    _: None = Depends(require_api_key),
+) -> Dict[str, Any]:
+    return set_session_preset(_session_id(request), payload.preset).as_dict()
+
+
+@router.get(
+    "/session/slots",
+    summary="Get the session's slots",
+    response_model=SessionSlots,
+    responses=error_responses(400, 401, 429),
+)
+@limiter.limit("60/minute")
+async def get_session_slots(request: Request, _: None = Depends(require_api_key)) -> Dict[str, Any]:
+    return {"slots": get_slots(_session_id(request))}
+
+
+@router.patch(
+    "/session/slots",
+    summary="Set or remove session slots",
+    description="Merges the given slots into the session's; a null value removes that slot.",
+    response_model=SessionSlots,
+    responses=error_responses(400, 401, 422, 429),
+)
+@limiter.limit("30/minute")
+async def patch_session_slots(
+    request: Request,
+    payload: SessionSlots,
+    _: None = Depends(require_api_key),
+) -> Dict[str, Any]:
+    return {"slots": update_slots(_session_id(request), payload.slots)}
+
+
+@router.delete(
+    "/session/slots",
+    summary="Clear the session's slots",
+    response_model=SessionSlots,
+    responses=error_responses(400, 401, 429),
+)
+@limiter.limit("30/minute")
+async def delete_session_slots(request: Request, _: None = Depends(require_api_key)) -> Dict[str, Any]:
+    save_slots(_session_id(request), {})
+    return {"slots": {}}
+
+
+@router.get(
+    "/sessions/{session_id}/assessment",
+    summary="Language-learning feedback on a session",
+    description=(
+        "Has the LLM review the learner's turns: corrections, grammar notes, and vocabulary suggestions "
+        "explained in feedback_language, with grammar and vocabulary scores. Cached until the session has new turns."
+    ),
+    response_model=SessionAssessmentResponse,
+    responses=error_responses(400, 401, 404, 429, 502, 503, 504),
+)
+@limiter.limit("10/minute")
+async def get_session_assessment(
+    request: Request,
+    session_id: str,
+    feedback_language: Optional[str] = Query(None, description="Language of the explanations (the learner's own)"),
+    language: Optional[str] = Query(None, description="Language the learner is practising; detected when omitted"),
+    refresh: bool = Query(False, description="Assess again even when a cached assessment covers every turn"),
+    _: None = Depends(require_api_key),
+) -> Dict[str, Any]:
+    session_id = session_id.strip()
+    if not session_id or len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"session id must be 1 to {_MAX_SESSION_ID_LEN} characters")
+    assessment = await assess_session(
+        session_id,
+        feedback_language=parse_language(feedback_language, "feedback_language"),
+        language=parse_language(language),
+        refresh=refresh,
+        request_id=getattr(request.state, "request_id", None),
+    )
+    if assessment is None:
+        raise HTTPException(status_code=404, detail="Session has no learner turns to assess")
+    return assessment.as_dict()
+
+
+@router.get(
+    "/sessions/{session_id}/transcript",
+    summary="The session's conversation, turn by turn",
+    description=(
+        "The user's transcripts and the assistant's replies with when each exchange happened, as JSON or, with "
+        "format=text, as plain text to show or share. Covers the turns the session keeps (DWANI_SESSION_MAX_HISTORY)."
+    ),
+    response_model=SessionTranscriptResponse,
+    responses={200: {"content": {"text/plain": {}}}, **error_responses(400, 401, 404, 429)},
+)
+@limiter.limit("30/minute")
+async def get_session_transcript(
+    request: Request,
+    session_id: str,
+    format: str = Query("json", description="json, or text for one line per message"),
+    _: None = Depends(require_api_key),
+) -> Response:
+    session_id = session_id.strip()
+    if not session_id or len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"session id must be 1 to {_MAX_SESSION_ID_LEN} characters")
+    if format not in ("json", "text"):
+        raise HTTPException(status_code=400, detail="format must be 'json' or 'text'")
+    turns = await run_in_threadpool(session_transcript, session_id)
+    if not turns:
+        raise HTTPException(status_code=404, detail="Session has no turns")
+    if format == "json":
+        return JSONResponse({"session_id": session_id, "turns": turns})
+    lines = []
+    for turn in turns:
+        stamp = f"[{turn['at']}] " if turn["at"] else ""
+        for speaker, role in (("User", "user"), ("Assistant", "assistant")):
+            if turn[role] is not None:
+                lines.append(f"{stamp}{speaker}: {turn[role]}")
+        lines.append("")
+    return PlainTextResponse("\n".join(lines))
+
+
+@router.delete(
+    "/sessions/{session_id}",
+    summary="Erase everything stored about a session",
+    description=(
+        "Deletes the session's history, slots and settings, its stored conversation turns and its request "
+        "recordings at once, with no grace period. 409 while the conversation is under legal hold."
+    ),
+    response_model=SessionErasureResponse,
+    responses=error_responses(400, 401, 409, 429, 501),
+)
+@limiter.limit("10/minute")
+async def delete_session(request: Request, session_id: str, _: None = Depends(require_api_key)) -> Dict[str, Any]:
+    session_id = session_id.strip()
+    if not session_id or len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"session id must be 1 to {_MAX_SESSION_ID_LEN} characters")
+    try:
+        erased = await run_in_threadpool(erase_session, session_id)
+    except conversation_store.LegalHold:
+        raise HTTPException(status_code=409, detail="Conversation is under legal hold")
+    except NotImplementedError:
+        raise HTTPException(status_code=501, detail="The session store cannot delete sessions")
+    return {"session_id": session_id, "erased": erased}
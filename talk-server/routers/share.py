@@ -0,0 +1,161 @@
+"""Share links: a minimal transcript-and-audio playback page for a stored conversation.
+
+Creating a link needs the API key; opening it does not, so QA reviewers can listen with just
+the URL (and the passcode, if one was set). Spoken audio is the assistant's replies,
+re-synthesized on demand (served from the TTS cache when warm) since turns are stored as text.
+"""
+import html
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Optional
+
+from fastapi import APIRouter, Depends, Form, HTTPException, Request
+from fastapi.responses import HTMLResponse, Response
+from starlette.concurrency import run_in_threadpool
+
+import conversation_store
+from config import SHARE_LINK_TTL_SECONDS, STORE_CONVERSATIONS
+from deps import limiter, require_api_key
+from models import ShareLinkRequest, ShareLinkResponse, error_responses
+from services.concurrency import pipeline_limiter
+from services.playback import PlaybackResponse
+from services.ranges import ACCEPT_RANGES, ranged_response
+from services.share import create_link, read_claims, unlock, verify
+from services.tenants import current_tenant_name
+from services.tts import stream_tts
+
+router = APIRouter(tags=["Analytics"])
+_MAX_TURNS = 200
+_PAGE_HEADERS = {
+    "Cache-Control": "no-store",
+    # The token is the credential: keep it out of Referer headers and search indexes.
+    "Referrer-Policy": "no-referrer",
+    "X-Robots-Tag": "noindex, nofollow",
+    "Content-Security-Policy": "default-src 'none'; style-src 'unsafe-inline'; media-src 'self'; form-action 'self'",
+}
+_STYLE = (
+    "body{font-family:system-ui,sans-serif;max-width:42rem;margin:2rem auto;padding:0 1rem;color:#222}"
+    ".turn{border-top:1px solid #ddd;padding:.75rem 0}.who{font-size:.8rem;color:#666;text-transform:uppercase}"
+    "audio{width:100%;margin-top:.4rem}.error{color:#b00}"
+)
+
+
+def _html(title: str, body: str, status_code: int = 200) -> HTMLResponse:
+    page = (
+        f'<!doctype html><html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">'
+        f"<title>{html.escape(title)}</title><style>{_STYLE}</style></head><body>{body}</body></html>"
+    )
+    return HTMLResponse(page, status_code=status_code, headers=_PAGE_HEADERS)
+
+
+def _passcode_form(error: Optional[str] = None, status_code: int = 200) -> HTMLResponse:
+    message = f'<p class="error">{html.escape(error)}</p>' if error else ""
+    return _html("Shared conversation", (
+        "<h1>Shared conversation</h1><p>Enter the passcode you were given to open this conversation.</p>"
+        f'{message}<form method="post"><input type="password" name="passcode" required autofocus> '
+        "<button>Open</button></form>"
+    ), status_code)
+
+
+def _error_page(exc: HTTPException) -> HTMLResponse:
+    return _html("Shared conversation", f"<h1>Shared conversation</h1><p>{html.escape(str(exc.detail))}</p>", exc.status_code)
+
+
+def _conversation_page(token: str, turns: List[Any], expires_at: int) -> HTMLResponse:
+    items = []
+    for turn in turns:
+        items.append(
+            '<div class="turn">'
+            f'<div class="who">User · {html.escape(turn.input_type)}</div><p>{html.escape(turn.transcript)}</p>'
+            f'<div class="who">Assistant</div><p>{html.escape(turn.reply)}</p>'
+            f'<audio controls preload="none" src="/share/{html.escape(token)}/audio/{turn.id}"></audio>'
+            "</div>"
+        )
+    started = turns[0].created_at.strftime("%Y-%m-%d %H:%M") if turns else ""
+    expires = datetime.fromtimestamp(expires_at, tz=timezone.utc).strftime("%Y-%m-%d %H:%M")
+    return _html("Shared conversation", (
+        f"<h1>Shared conversation</h1><p>{len(turns)} turns, started {started} UTC. "
+        f"This page can be opened until {expires} UTC.</p>" + "".join(items)
+    ))
+
+
+@router.post(
+    "/v1/conversations/{session_key}/share",
+    summary="Create a share link for a stored conversation",
+    description="Signed, expiring link to a playback page (transcript and audio) that needs no API key.",
+    response_model=ShareLinkResponse,
+    responses=error_responses(401, 404, 422, 429),
+)
+@limiter.limit("30/minute")
+async def create_share_link(
+    request: Request,
+    session_key: str,
+    payload: ShareLinkRequest,
+    _: None = Depends(require_api_key),
+) -> Dict[str, Any]:
+    if not STORE_CONVERSATIONS:
+        raise HTTPException(status_code=404, detail="Conversations are not stored (set DWANI_STORE_CONVERSATIONS=1)")
+    # Only the caller's tenant's conversations can be shared.
+    if await run_in_threadpool(conversation_store.get_conversation, session_key, tenant=current_tenant_name()) is None:
+        raise HTTPException(status_code=404, detail="Conversation not found")
+    token, claims = create_link(session_key, payload.ttl_seconds or SHARE_LINK_TTL_SECONDS, payload.passcode)
+    return {
+        "url": str(request.url_for("share_page", token=token)),
+        "token": token,
+        "expires_at": claims.expires_at,
+        "passcode_protected": claims.passcode,
+    }
+
+
+async def _render(token: str, passcode: Optional[str] = None) -> HTMLResponse:
+    if passcode is not None:
+        token, claims = unlock(token, passcode)
+    else:
+        claims = verify(token)
+    turns = await run_in_threadpool(conversation_store.list_turns, claims.session_key, _MAX_TURNS)
+    if not turns:
+        raise HTTPException(status_code=404, detail="Conversation not found")
+    return _conversation_page(token, turns, claims.expires_at)
+
+
+@router.get("/share/{token}", name="share_page", include_in_schema=False)
+@limiter.limit("60/minute")
+async def share_page(request: Request, token: str) -> Response:
+    try:
+        claims = read_claims(token)
+        if claims.passcode:
+            # The signature can only be checked together with the passcode.
+            if claims.expired:
+                raise HTTPException(status_code=410, detail="Share link has expired")
+            return _passcode_form()
+        return await _render(token)
+    except HTTPException as exc:
+        return _error_page(exc)
+
+
+@router.post("/share/{token}", include_in_schema=False)
+@limiter.limit("10/minute")
+async def share_page_unlock(request: Request, token: str, passcode: str = Form(...)) -> Response:
+    try:
+        return await _render(token, passcode)
+    except HTTPException as exc:
+        if exc.status_code == 401:
+            return _passcode_form(str(exc.detail), status_code=401)
+        return _error_page(exc)
+
+
+@router.get("/share/{token}/audio/{turn_id}", include_in_schema=False)
+@limiter.limit("120/minute")
+async def share_audio(request: Request, token: str, turn_id: int) -> Response:
+    claims = verify(token)
+    turn = await run_in_threadpool(conversation_store.get_turn, turn_id)
+    if turn is None or turn.session_key != claims.session_key:
+        raise HTTPException(status_code=404, detail="Turn not found")
+    async with pipeline_limiter.slot():
+        tts_stream = await stream_tts(
+            turn.reply, request_id=getattr(request.state, "request_id", None), language=turn.language
+        )
+    headers = {"Cache-Control": "private, max-age=3600"}
+    if request.headers.get("range"):
+        # A player seeking or resuming: the reply is synthesized (or cached) whole, then sliced.
+        return ranged_response(request, await tts_stream.read(), tts_stream.media_type, headers)
+    return PlaybackResponse(tts_stream, headers={**headers, **ACCEPT_RANGES})
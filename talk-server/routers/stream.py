@@ -0,0 +1,215 @@
+"""Streaming transcription over a WebSocket, with server-side end-of-utterance detection
+(services/streaming.py).
+
+Send binary messages of 16-bit little-endian mono PCM at ?sample_rate= (default
+DWANI_STREAM_SAMPLE_RATE), in chunks of any size. Text messages are control messages:
+{"type": "flush"} ends the current utterance now (push-to-talk release) and {"type": "end"}
+transcribes what is left, sends the remaining results, and closes. The server sends JSON:
+ready, speech_started, speech_stopped, then a transcription (or error) per utterance.
+With ?encryption= the audio, the controls and the server's messages are encrypted end to end
+(services/stream_crypto.py); with ?wake_word= (or DWANI_STREAM_WAKE_WORDS) only the command
+after a wake word is transcribed, and wake and sleep events are sent (services/wake_word.py).
+
+/v1/conversation/stream answers each turn as well, with typed events for the transcript, the
+reply and its audio (services/conversation_stream.py).
+"""
+import asyncio
+import json
+from typing import Optional
+
+from fastapi import APIRouter, HTTPException, WebSocket, WebSocketDisconnect
+
+from config import STREAM_SAMPLE_RATE, STREAM_WAKE_WORDS, logger
+from deps import websocket_api_key, websocket_api_key_ok
+from models import ALLOWED_LANGUAGES
+from services.audio_format import normalize_format
+from services.conversation_stream import ConversationStream
+from services.error_codes import error_fields
+from services.ids import client_request_id, new_id
+from services.language_id import normalize_language, tenant_allows_language
+from services.stream_crypto import FrameError, server_session
+from services.streaming import StreamingTranscriber
+from services.tenants import current_tenant_settings, tenant_for_request, use_tenant_settings
+from services.turn_detection import get_preset, session_preset
+from services.wake_word import WakeWordGate, parse_wake_words
+
+router = APIRouter(tags=["Audio"])
+_MIN_SAMPLE_RATE, _MAX_SAMPLE_RATE = 8000, 48000
+_MAX_SESSION_ID_LEN = 128
+
+
+async def _reject(websocket: WebSocket, message: str) -> None:
+    await websocket.send_json({"type": "error", "code": "400", "message": message, **error_fields("invalid_request")})
+    await websocket.close(code=1008)
+
+
+def _settings_error(sample_rate: Optional[int], language: Optional[str], turn_detection: Optional[str], session_id: str) -> Optional[str]:
+    if sample_rate is None or not _MIN_SAMPLE_RATE <= sample_rate <= _MAX_SAMPLE_RATE:
+        return f"sample_rate must be an integer from {_MIN_SAMPLE_RATE} to {_MAX_SAMPLE_RATE}"
+    if language and normalize_language(language) is None:
+        return f"language must be one of {ALLOWED_LANGUAGES} or a BCP-47 tag such as kn-IN"
+    if language and not tenant_allows_language(normalize_language(language)):
+        return f"language must be one of {sorted(current_tenant_settings().languages)}"
+    if turn_detection and get_preset(turn_detection) is None:
+        return f"unknown turn_detection preset {turn_detection!r}"
+    if len(session_id) > _MAX_SESSION_ID_LEN:
+        return f"session_id must be <= {_MAX_SESSION_ID_LEN} characters"
+    return None
+
+
+@router.websocket("/v1/transcribe/stream", name="transcribe_stream")
+async def transcribe_stream(websocket: WebSocket) -> None:
+    use_tenant_settings(tenant_for_request(websocket_api_key(websocket), websocket.headers.get("host")))
+    if not websocket_api_key_ok(websocket):
+        await websocket.close(code=1008)
+        return
+    await websocket.accept()
+    query = websocket.query_params
+    raw_rate = query.get("sample_rate") or str(STREAM_SAMPLE_RATE)
+    sample_rate = int(raw_rate) if raw_rate.isdigit() else None
+    language = (query.get("language") or "").strip() or None
+    turn_detection = (query.get("turn_detection") or "").strip().lower() or None
+    session_id = (websocket.headers.get("X-Session-ID") or query.get("session_id") or "").strip()
+    wake_words = parse_wake_words(query.get("wake_word") or STREAM_WAKE_WORDS)
+    error = _settings_error(sample_rate, language, turn_detection, session_id)
+    cipher = None
+    if not error:
+        try:
+            cipher, key_exchange = server_session((query.get("encryption") or "").strip().lower(), query.get("public_key"))
+        except ValueError as exc:
+            error = str(exc)
+    if error:
+        await _reject(websocket, error)
+        return
+    send = websocket.send_json
+    if cipher is not None:
+        await websocket.send_json(key_exchange)
+        # Results are sent from the transcription worker too; frames must go out in sequence order.
+        sending = asyncio.Lock()
+
+        async def send(message: dict) -> None:
+            async with sending:
+                await websocket.send_bytes(cipher.seal_json(message))
+
+    stream = StreamingTranscriber(
+        send,
+        get_preset(turn_detection) or session_preset(session_id or None),
+        sample_rate=sample_rate,
+        language=normalize_language(language),
+        request_id=client_request_id(websocket.headers) or new_id("request"),
+        wake=WakeWordGate(wake_words) if wake_words else None,
+    )
+    await stream.start()
+    try:
+        while True:
+            message = await websocket.receive()
+            if message["type"] == "websocket.disconnect":
+                return
+            control = None
+            if message.get("bytes") is not None:
+                audio = message["bytes"]
+                if cipher is not None:
+                    try:
+                        audio, control = cipher.open_client_frame(audio)
+                    except FrameError as exc:
+                        await send({"type": "error", "code": "400", "message": str(exc), **error_fields("invalid_request")})
+                        await websocket.close(code=1008)
+                        return
+                if audio is not None:
+                    await stream.feed(audio)
+                    continue
+            elif cipher is not None:
+                # Plain controls could come from the edge the session is encrypted against.
+                await send({"type": "error", "code": "400", "message": "controls must be encrypted frames", **error_fields("invalid_request")})
+                continue
+            else:
+                try:
+                    control = json.loads(message.get("text") or "")
+                except json.JSONDecodeError:
+                    control = None
+            kind = control.get("type") if isinstance(control, dict) else None
+            if kind == "flush":
+                await stream.flush()
+            elif kind == "end":
+                await stream.finish()
+                await websocket.close()
+                return
+            else:
+                await send({"type": "error", "code": "400", "message": 'expected PCM bytes or {"type": "flush" | "end"}', **error_fields("invalid_request")})
+    except WebSocketDisconnect:
+        logger.info("Streaming transcription client disconnected")
+    finally:
+        await stream.close()
+
+
+_CONVERSATION_CONTROLS = '{"type": "text" | "flush" | "cancel" | "end"}'
+
+
+@router.websocket("/v1/conversation/stream", name="conversation_stream")
+async def conversation_stream(websocket: WebSocket) -> None:
+    use_tenant_settings(tenant_for_request(websocket_api_key(websocket), websocket.headers.get("host")))
+    if not websocket_api_key_ok(websocket):
+        await websocket.close(code=1008)
+        return
+    await websocket.accept()
+    query = websocket.query_params
+    raw_rate = query.get("sample_rate") or str(STREAM_SAMPLE_RATE)
+    sample_rate = int(raw_rate) if raw_rate.isdigit() else None
+    language = (query.get("language") or "").strip() or None
+    turn_detection = (query.get("turn_detection") or "").strip().lower() or None
+    session_id = (websocket.headers.get("X-Session-ID") or query.get("session_id") or "").strip()
+    mode = (query.get("mode") or "llm").strip().lower()
+    error = _settings_error(sample_rate, language, turn_detection, session_id)
+    if not error and mode not in {"llm", "agent"}:
+        error = "mode must be 'llm' or 'agent'"
+    audio_format = None
+    if not error:
+        try:
+            audio_format = normalize_format(query.get("format"))
+        except HTTPException as exc:
+            error = str(exc.detail)
+    if error:
+        await _reject(websocket, error)
+        return
+    conversation = ConversationStream(
+        websocket.send_json,
+        get_preset(turn_detection) or session_preset(session_id or None),
+        session_id or new_id("session"),
+        sample_rate=sample_rate,
+        language=normalize_language(language),
+        mode=mode,
+        agent_name=(query.get("agent_name") or "").strip() or None,
+        audio_format=audio_format,
+        request_id=client_request_id(websocket.headers) or new_id("request"),
+    )
+    await conversation.start()
+    try:
+        while True:
+            message = await websocket.receive()
+            if message["type"] == "websocket.disconnect":
+                return
+            if message.get("bytes") is not None:
+                await conversation.feed(message["bytes"])
+                continue
+            try:
+                control = json.loads(message.get("text") or "")
+            except json.JSONDecodeError:
+                control = None
+            kind = control.get("type") if isinstance(control, dict) else None
+            if kind == "text" and isinstance(control.get("text"), str) and control["text"].strip():
+                await conversation.text(control["text"])
+            elif kind == "flush":
+                await conversation.flush()
+            elif kind == "cancel":
+                conversation.cancel()
+            elif kind == "end":
+                await conversation.finish()
+                await websocket.close()
+                return
+            else:
+                detail = f"expected PCM bytes or {_CONVERSATION_CONTROLS}"
+                await websocket.send_json({"type": "error", "code": "400", "message": detail, **error_fields("invalid_request")})
+    except WebSocketDisconnect:
+        logger.info("Conversation stream client disconnected")
+    finally:
+        await conversation.close()
@@ -0,0 +1,44 @@
+"""The Telegram bot's webhook (services/telegram.py).
+
+Telegram cannot send the API key, so updates must carry DWANI_TELEGRAM_WEBHOOK_SECRET (passed to
+setWebhook by the startup registration) in X-Telegram-Bot-Api-Secret-Token. Without a secret
+every update is refused: anyone who knows the URL could otherwise speak as any chat.
+"""
+import hmac
+
+from fastapi import APIRouter, HTTPException, Request
+from fastapi.responses import JSONResponse
+
+from deps import limiter
+from services.credentials import secret
+from services.telegram import telegram_bot
+
+router = APIRouter(tags=["Telegram"])
+
+
+@router.post(
+    "/v1/telegram/webhook",
+    summary="Updates from the Telegram Bot API",
+    description=(
+        "Telegram POSTs each update here. Voice notes and text messages are answered in the "
+        "background with a voice message and its text; the update is acknowledged at once."
+    ),
+)
+@limiter.limit("600/minute")
+async def telegram_webhook(request: Request) -> JSONResponse:
+    bot = telegram_bot()
+    if bot is None:
+        raise HTTPException(status_code=404, detail="The Telegram bot is not configured")
+    expected = secret("DWANI_TELEGRAM_WEBHOOK_SECRET")
+    supplied = request.headers.get("X-Telegram-Bot-Api-Secret-Token") or ""
+    if not expected:
+        raise HTTPException(status_code=403, detail="Telegram updates need DWANI_TELEGRAM_WEBHOOK_SECRET")
+    if not hmac.compare_digest(supplied.encode(), expected.encode()):
+        raise HTTPException(status_code=403, detail="Invalid Telegram secret token")
+    try:
+        update = await request.json()
+    except ValueError:
+        raise HTTPException(status_code=400, detail="The update is not JSON")
+    if isinstance(update, dict):
+        bot.dispatch(update)
+    return JSONResponse({"ok": True})
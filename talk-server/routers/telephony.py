@@ -0,0 +1,81 @@
+"""Twilio phone calls: the TwiML webhook and the Media Streams WebSocket (services/telephony.py).
+
+Twilio cannot send the API key, so both endpoints require a valid X-Twilio-Signature instead.
+Without DWANI_TWILIO_AUTH_TOKEN there is nothing to check it against: the webhook answers 404
+and the WebSocket is closed with 1008, so phone calls are off.
+"""
+import html
+from typing import Dict, Optional
+
+from fastapi import APIRouter, HTTPException, Request, WebSocket, WebSocketDisconnect
+from fastapi.responses import Response
+
+from config import TWILIO_AUTH_TOKEN, TWILIO_STREAM_URL, logger
+from deps import limiter
+from services.messages import brand_for_host
+from services.telephony import TwilioCall, valid_twilio_signature
+from services.tenants import tenant_for_request, use_tenant_settings
+
+router = APIRouter(tags=["Telephony"])
+
+
+def _stream_url(url: str) -> str:
+    """The public wss:// URL of the WebSocket, derived from an incoming request's URL unless configured."""
+    if TWILIO_STREAM_URL:
+        return TWILIO_STREAM_URL
+    return url.replace("https://", "wss://", 1).replace("http://", "ws://", 1)
+
+
+def _signed(url: str, params: Optional[Dict[str, str]], signature: Optional[str]) -> bool:
+    return bool(TWILIO_AUTH_TOKEN) and valid_twilio_signature(TWILIO_AUTH_TOKEN, url, params, signature)
+
+
+@router.post(
+    "/v1/telephony/twilio/twiml",
+    summary="TwiML that connects a Twilio call to the voice bot",
+    description=(
+        "Point a Twilio number's voice webhook here. Query parameters language, mode, agent_name and "
+        "turn_detection are passed to the call as <Stream> parameters."
+    ),
+)
+@limiter.limit("60/minute")
+async def twilio_twiml(request: Request) -> Response:
+    if not TWILIO_AUTH_TOKEN:
+        raise HTTPException(status_code=404, detail="Phone calls are not configured")
+    form = await request.form()
+    params = {key: str(value) for key, value in form.items()}
+    if not _signed(str(request.url), params, request.headers.get("X-Twilio-Signature")):
+        raise HTTPException(status_code=403, detail="Invalid Twilio signature")
+    stream_url = _stream_url(str(request.url_for("twilio_media_stream")))
+    parameters = "".join(
+        f'<Parameter name="{name}" value="{html.escape(request.query_params[name])}"/>'
+        for name in ("language", "mode", "agent_name", "turn_detection")
+        if request.query_params.get(name)
+    )
+    twiml = (
+        '<?xml version="1.0" encoding="UTF-8"?><Response><Connect>'
+        f'<Stream url="{html.escape(stream_url)}">{parameters}</Stream>'
+        "</Connect></Response>"
+    )
+    return Response(twiml, media_type="application/xml")
+
+
+@router.websocket("/v1/telephony/twilio", name="twilio_media_stream")
+async def twilio_media_stream(websocket: WebSocket) -> None:
+    if not _signed(TWILIO_STREAM_URL or str(websocket.url), None, websocket.headers.get("X-Twilio-Signature")):
+        if TWILIO_AUTH_TOKEN:
+            logger.warning("Rejected Twilio media stream with an invalid signature")
+        await websocket.close(code=1008)
+        return
+    await websocket.accept()
+    brand_for_host(websocket.headers.get("host"))
+    use_tenant_settings(tenant_for_request(None, websocket.headers.get("host")))
+    call = TwilioCall(websocket.send_json)
+    try:
+        while await call.handle(await websocket.receive_json()):
+            pass
+        await websocket.close()
+    except WebSocketDisconnect:
+        logger.info("Twilio media stream for call %s disconnected", call.call_sid)
+    finally:
+        await call.close()
@@ -0,0 +1,70 @@
+"""Usage per API key (services/usage.py): the caller's own at /v1/usage, every key's as CSV for billing."""
+import asyncio
+import csv
+import io
+from datetime import date
+from typing import Any, Dict, List, Optional
+
+from fastapi import APIRouter, Depends, HTTPException, Query, Request
+from fastapi.responses import Response
+
+from deps import request_api_key, require_admin, require_api_key
+from models import error_responses
+from services.usage import METRICS, usage_account, usage_store
+
+router = APIRouter(tags=["Usage"])
+
+
+def _day(value: Optional[str], name: str) -> Optional[str]:
+    if value is None:
+        return None
+    try:
+        return date.fromisoformat(value).isoformat()
+    except ValueError:
+        raise HTTPException(status_code=400, detail=f"{name} must be a date such as 2026-01-31")
+
+
+def _csv(rows: List[Dict[str, Any]], filename: str) -> Response:
+    out = io.StringIO()
+    writer = csv.DictWriter(out, fieldnames=["day", "account", *METRICS], lineterminator="\n")
+    writer.writeheader()
+    writer.writerows(rows)
+    return Response(
+        out.getvalue(), media_type="text/csv", headers={"Content-Disposition": f'attachment; filename="{filename}"'}
+    )
+
+
+@router.get(
+    "/v1/usage",
+    summary="Usage of the calling API key",
+    description="Daily audio seconds transcribed, LLM tokens and TTS characters for the API key on the request "
+    "(UTC days, since and until inclusive). format=csv returns the same rows as a CSV file.",
+    responses={
+        200: {"description": "Daily usage and totals", "content": {"application/json": {}, "text/csv": {}}},
+        **error_responses(400, 401),
+    },
+    dependencies=[Depends(require_api_key)],
+)
+async def get_usage(
+    request: Request,
+    since: Optional[str] = Query(None, description="First day, YYYY-MM-DD"),
+    until: Optional[str] = Query(None, description="Last day, YYYY-MM-DD"),
+    format: str = Query("json", pattern="^(json|csv)$"),
+) -> Any:
+    account = usage_account(request_api_key(request))
+    rows = await asyncio.to_thread(usage_store().rows, account, _day(since, "since"), _day(until, "until"))
+    if format == "csv":
+        return _csv(rows, "usage.csv")
+    totals = {metric: round(sum(row[metric] for row in rows), 3) for metric in METRICS}
+    return {"account": account, "days": [{k: v for k, v in row.items() if k != "account"} for row in rows], "totals": totals}
+
+
+@router.get("/admin/usage.csv", include_in_schema=False, dependencies=[Depends(require_admin)])
+async def export_usage(
+    since: Optional[str] = Query(None),
+    until: Optional[str] = Query(None),
+    account: Optional[str] = Query(None, description="One account (API key digest) instead of all"),
+) -> Response:
+    """Every key's daily usage, for chargeback."""
+    rows = await asyncio.to_thread(usage_store().rows, account, _day(since, "since"), _day(until, "until"))
+    return _csv(rows, "usage.csv")
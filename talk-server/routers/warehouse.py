@@ -1,12 +1,12 @@
 import os
 from typing import Any, Dict
 
-import httpx
 from fastapi import APIRouter, Depends, Request
 
 from config import LLM_TIMEOUT, logger
 from deps import get_optional_user, limiter
 from models import WarehouseCommandRequest
+from services.http_client import http_client
 
 router = APIRouter(prefix="/v1/warehouse", tags=["Warehouse"])
 
@@ -20,8 +20,7 @@ async def get_warehouse_state(request: Request, __=Depends(get_optional_user)) -
         raise HTTPException(status_code=502, detail="Agent service base URL is not configured")
     url = f"{agent_base}/v1/warehouse/state"
     try:
-        async with httpx.AsyncClient(timeout=LLM_TIMEOUT) as client:
-            resp = await client.get(url)
+        resp = await http_client().get(url, timeout=LLM_TIMEOUT)
     except Exception as exc:
         logger.error(f"Warehouse state request failed: {exc}")
         from fastapi import HTTPException
@@ -46,8 +45,7 @@ async def proxy_warehouse_command(request: Request, body: WarehouseCommandReques
         raise HTTPException(status_code=502, detail="Agent service base URL is not configured")
     url = f"{agent_base}/v1/warehouse/command"
     try:
-        async with httpx.AsyncClient(timeout=LLM_TIMEOUT) as client:
-            resp = await client.post(url, json=body.model_dump())
+        resp = await http_client().post(url, json=body.model_dump(), timeout=LLM_TIMEOUT)
     except Exception as exc:
         logger.error(f"Warehouse command request failed: {exc}")
         from fastapi import HTTPException
@@ -0,0 +1,58 @@
+"""WebRTC signaling: the browser posts its SDP offer and gets the answer (services/webrtc.py).
+
+The offer's audio track carries the microphone to the server, and the answer adds a return track
+with the replies. Query parameters are those of /v1/conversation/stream; the session is X-Session-ID.
+"""
+from typing import Any, Dict, Optional
+
+from fastapi import APIRouter, Body, Depends, HTTPException, Query, Request
+
+from deps import limiter, require_api_key
+from models import WebRTCAnswer, WebRTCOffer, error_responses
+from services.ids import client_request_id, new_id
+from services.language_id import parse_language
+from services.turn_detection import get_preset, session_preset
+from services.webrtc import WebRTCCall, answer
+
+router = APIRouter(prefix="/v1", tags=["Audio"])
+_MAX_SESSION_ID_LEN = 128
+
+
+@router.post(
+    "/webrtc/offer",
+    summary="Start a WebRTC voice call",
+    description=(
+        "Answer the browser's SDP offer. Speech on its audio track is cut into turns on the server and answered "
+        "on the return track; a data channel, if it opens one, gets the conversation's JSON events."
+    ),
+    response_model=WebRTCAnswer,
+    responses=error_responses(400, 401, 429, 501, 503),
+)
+@limiter.limit("30/minute")
+async def webrtc_offer(
+    request: Request,
+    offer: WebRTCOffer = Body(...),
+    language: Optional[str] = Query(None, description="Language of the call (name, ISO code or BCP-47 tag)"),
+    mode: str = Query("llm", description="llm or agent"),
+    agent_name: Optional[str] = Query(None, description="Agent to answer with in agent mode"),
+    turn_detection: Optional[str] = Query(None, description="Turn-detection preset; the session's by default"),
+    _: None = Depends(require_api_key),
+) -> Dict[str, Any]:
+    if mode not in {"llm", "agent"}:
+        raise HTTPException(status_code=400, detail="mode must be 'llm' or 'agent'")
+    preset = get_preset(turn_detection) if turn_detection else None
+    if turn_detection and preset is None:
+        raise HTTPException(status_code=400, detail=f"unknown turn_detection preset {turn_detection!r}")
+    session_id = (request.headers.get("X-Session-ID") or "").strip()
+    if len(session_id) > _MAX_SESSION_ID_LEN:
+        raise HTTPException(status_code=400, detail=f"X-Session-ID must be <= {_MAX_SESSION_ID_LEN} characters")
+    call = WebRTCCall(
+        preset or session_preset(session_id or None),
+        session_id or new_id("session"),
+        language=parse_language(language),
+        mode=mode,
+        agent_name=(agent_name or "").strip() or None,
+        request_id=client_request_id(request.headers) or getattr(request.state, "request_id", None) or new_id("request"),
+    )
+    sdp = await answer(call, offer.sdp, offer.type)
+    return {"sdp": sdp, "type": "answer", "call_id": call.id, "session_id": call.session_id}
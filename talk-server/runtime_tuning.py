@@ -0,0 +1,72 @@
+"""Container-aware process sizing and garbage-collector knobs.
+
+Do not depend on other app modules: gunicorn.conf.py imports this before the app.
+"""
+import gc
+import logging
+import math
+import os
+from typing import Optional
+
+logger = logging.getLogger("indic_all_server")
+
+_CGROUP_V2_CPU_MAX = "/sys/fs/cgroup/cpu.max"
+_CGROUP_V1_QUOTA = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+_CGROUP_V1_PERIOD = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+
+
+def _read(path: str) -> Optional[str]:
+    try:
+        with open(path, encoding="ascii") as fh:
+            return fh.read().strip()
+    except OSError:
+        return None
+
+
+def cgroup_cpu_limit() -> Optional[float]:
+    """CPUs granted by the container's CFS quota, or None when unlimited/unknown."""
+    v2 = _read(_CGROUP_V2_CPU_MAX)
+    if v2:
+        quota, _, period = v2.partition(" ")
+        if quota != "max" and period:
+            return int(quota) / int(period)
+        return None
+    quota, period = _read(_CGROUP_V1_QUOTA), _read(_CGROUP_V1_PERIOD)
+    if quota and period and int(quota) > 0:
+        return int(quota) / int(period)
+    return None
+
+
+def available_cpus() -> int:
+    """Like automaxprocs: the CFS quota rounded up, capped by the CPUs we may run on."""
+    try:
+        host = len(os.sched_getaffinity(0))
+    except AttributeError:  # pragma: no cover - non-Linux
+        host = os.cpu_count() or 1
+    limit = cgroup_cpu_limit()
+    if limit is None:
+        return host
+    return max(1, min(host, math.ceil(limit)))
+
+
+def worker_count() -> int:
+    configured = os.getenv("DWANI_WORKERS", "").strip()
+    if configured:
+        return max(1, int(configured))
+    per_cpu = float(os.getenv("DWANI_WORKERS_PER_CPU", "1") or 1)
+    return max(1, math.ceil(available_cpus() * per_cpu))
+
+
+def apply_gc_settings() -> None:
+    """Apply DWANI_GC_THRESHOLDS ("700,10,10") and DWANI_GC_FREEZE=1 (freeze startup objects)."""
+    thresholds = os.getenv("DWANI_GC_THRESHOLDS", "").strip()
+    if thresholds:
+        try:
+            values = [int(v) for v in thresholds.split(",") if v.strip()]
+            gc.set_threshold(*values[:3])
+        except (TypeError, ValueError) as exc:
+            logger.warning("Ignoring invalid DWANI_GC_THRESHOLDS=%r: %s", thresholds, exc)
+    if os.getenv("DWANI_GC_FREEZE", "0") == "1":
+        # Objects created while importing the app never die; keep them out of every collection.
+        gc.collect()
+        gc.freeze()
@@ -2,6 +2,11 @@ from .retry import retry_async
 from .session import get_session_context, append_to_session
 from .transcribe import transcribe_audio
 from .chat_svc import call_llm, call_agent
+from .tts import TTSStream, stream_tts
+from .backends import PipelineConfig
+from .hooks import HookContext, PipelineHooks, hooks
+from .lifecycle import Lifecycle, LifecycleContext, lifecycle
+from .pipeline import Pipeline, SpeechResult, TurnResult, converse
 
 __all__ = [
     "retry_async",
@@ -10,4 +15,17 @@ __all__ = [
     "transcribe_audio",
     "call_llm",
     "call_agent",
+    "TTSStream",
+    "stream_tts",
+    "TurnResult",
+    "converse",
+    "Pipeline",
+    "PipelineConfig",
+    "SpeechResult",
+    "HookContext",
+    "PipelineHooks",
+    "hooks",
+    "Lifecycle",
+    "LifecycleContext",
+    "lifecycle",
 ]
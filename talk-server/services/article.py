@@ -0,0 +1,231 @@
+"""Web article fetching and main-content extraction for read-aloud of URLs.
+
+The fetch follows at most a few redirects, refuses hosts that resolve to private, loopback,
+or link-local addresses (unless DWANI_READ_URL_ALLOW_PRIVATE=1), connects to the address it
+checked (services/egress.py), and stops reading after DWANI_READ_URL_MAX_BYTES. Extraction
+is readability-style: paragraphs are scored by length and punctuation, scores flow to their
+parent and grandparent elements, and the best-scoring container is kept, minus navigation,
+sidebars, comments, and link lists.
+"""
+import re
+from dataclasses import dataclass, field
+from html.parser import HTMLParser
+from typing import Dict, List, Optional, Tuple
+from urllib.parse import urljoin
+
+import httpx
+from fastapi import HTTPException
+
+from config import READ_URL_ALLOW_PRIVATE, READ_URL_MAX_BYTES, READ_URL_TIMEOUT, logger
+from services.egress import PinnedTransport
+from services.read_aloud import Chapter, parse_document
+
+_MAX_REDIRECTS = 5
+_USER_AGENT = "dwani-talk-read-aloud/1.0"
+_SKIP_TAGS = {"script", "style", "noscript", "template", "svg", "iframe", "form", "button", "select", "textarea"}
+_BOILERPLATE_TAGS = {"nav", "header", "footer", "aside"}
+_BOILERPLATE_HINT = re.compile(
+    r"comment|sidebar|footer|header|menu|nav|share|social|related|promo|advert|\bads?\b|cookie|banner|"
+    r"newsletter|subscribe|breadcrumb|popup|modal|sponsor",
+    re.IGNORECASE,
+)
+_CONTENT_HINT = re.compile(r"article|content|story|entry|post|main|body|text", re.IGNORECASE)
+_BLOCK_TAGS = {"p", "li", "pre", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6", "dd", "td", "figcaption"}
+_HEADINGS = {"h1", "h2", "h3"}
+_VOID_TAGS = {"br", "img", "hr", "meta", "link", "input", "source", "wbr", "area", "base", "col", "embed", "track"}
+
+
+@dataclass
+class Article:
+    title: Optional[str]
+    chapters: List[Chapter]
+    url: str
+
+
+@dataclass
+class _Block:
+    tag: str
+    text: str
+    link_chars: int
+    ancestors: Tuple[int, ...]
+
+
+@dataclass
+class _Element:
+    id: int
+    tag: str
+    boilerplate: bool
+    weight: float
+    text: List[str] = field(default_factory=list)
+    link_chars: int = 0
+
+
+class _ArticleParser(HTMLParser):
+    def __init__(self):
+        super().__init__(convert_charrefs=True)
+        self.blocks: List[_Block] = []
+        self.weights: Dict[int, float] = {}
+        self.title: Optional[str] = None
+        self.og_title: Optional[str] = None
+        self._stack: List[_Element] = []
+        self._next_id = 0
+        self._skip_depth = 0
+        self._in_title = False
+        self._in_link = 0
+        self._block: Optional[_Element] = None
+
+    def handle_starttag(self, tag, attrs):
+        attrs = dict(attrs)
+        if tag == "meta" and attrs.get("property") == "og:title" and attrs.get("content"):
+            self.og_title = " ".join(attrs["content"].split())
+        if tag in _VOID_TAGS:
+            if tag == "br" and self._block is not None:
+                self._block.text.append(" ")
+            return
+        if self._skip_depth or tag in _SKIP_TAGS:
+            self._skip_depth += 1
+            return
+        if tag == "title":
+            self._in_title = True
+        if tag in _BLOCK_TAGS and self._block is not None and self._block.tag == "p":
+            self.handle_endtag("p")  # <p> cannot contain blocks: an unclosed one ends here
+        hints = f"{attrs.get('class') or ''} {attrs.get('id') or ''} {attrs.get('role') or ''}"
+        boilerplate = tag in _BOILERPLATE_TAGS or (bool(_BOILERPLATE_HINT.search(hints)) and not _CONTENT_HINT.search(hints))
+        weight = 25.0 if tag in {"article", "main"} or _CONTENT_HINT.search(hints) else 0.0
+        element = _Element(self._next_id, tag, boilerplate, weight)
+        self._next_id += 1
+        self.weights[element.id] = weight
+        self._stack.append(element)
+        if tag == "a":
+            self._in_link += 1
+        if tag in _BLOCK_TAGS and self._block is None:
+            self._block = element
+
+    def handle_endtag(self, tag):
+        if tag in _VOID_TAGS:
+            return
+        if self._skip_depth:
+            self._skip_depth -= 1
+            return
+        if tag == "title":
+            self._in_title = False
+        # Pop up to the matching open tag; unclosed children (common in real HTML) close with it.
+        if not any(e.tag == tag for e in self._stack):
+            return
+        while self._stack:
+            element = self._stack.pop()
+            if element.tag == "a":
+                self._in_link = max(0, self._in_link - 1)
+            if element is self._block:
+                self._finish_block(element)
+            if element.tag == tag:
+                break
+
+    def _finish_block(self, element: _Element) -> None:
+        self._block = None
+        text = " ".join("".join(element.text).split())
+        if not text or any(e.boilerplate for e in self._stack) or element.boilerplate:
+            return
+        self.blocks.append(_Block(element.tag, text, element.link_chars, tuple(e.id for e in self._stack)))
+
+    def handle_data(self, data):
+        if self._skip_depth:
+            return
+        if self._in_title:
+            self.title = " ".join(f"{self.title or ''} {data}".split())
+            return
+        if self._block is not None:
+            self._block.text.append(data)
+            if self._in_link:
+                self._block.link_chars += len(data.strip())
+
+    def close(self):
+        super().close()
+        while self._stack:
+            element = self._stack.pop()
+            if element is self._block:
+                self._finish_block(element)
+
+
+def _score(block: _Block) -> float:
+    if block.tag in {"h1", "h2", "h3", "h4", "h5", "h6"} or len(block.text) < 25:
+        return 0.0
+    return 1.0 + block.text.count(",") + block.text.count("،") + min(3.0, len(block.text) / 100)
+
+
+def extract_article(html: str, url: str = "") -> Article:
+    """Title and chapters of the page's main content."""
+    parser = _ArticleParser()
+    parser.feed(html)
+    parser.close()
+    scores: Dict[int, float] = {}
+    for block in parser.blocks:
+        link_density = block.link_chars / max(1, len(block.text))
+        value = _score(block) * (1 - link_density)
+        if not value or not block.ancestors:
+            continue
+        for depth, element_id in enumerate(reversed(block.ancestors[-2:])):
+            scores[element_id] = scores.get(element_id, parser.weights.get(element_id, 0.0)) + value / (depth + 1)
+    best = max(scores, key=scores.get) if scores else None
+
+    title = parser.og_title or parser.title
+    chapters = [Chapter(None)]
+    for block in parser.blocks:
+        if best is not None and best not in block.ancestors:
+            continue
+        if block.link_chars / max(1, len(block.text)) > 0.5:
+            continue  # link lists ("Read more", tag clouds) inside the article container
+        if block.tag in _HEADINGS:
+            if block.tag == "h1" and (not title or block.text in title):
+                title = block.text  # the headline, without the site name <title> usually carries
+                continue
+            chapters.append(Chapter(block.text))
+        elif block.tag != "td" or len(block.text) >= 25:
+            chapters[-1].paragraphs.append(block.text)
+    chapters = [c for c in chapters if c.paragraphs or c.title]
+    return Article(title=title, chapters=chapters, url=url)
+
+
+def readable_chapters(article: Article) -> List[Chapter]:
+    """Chapters with the headline read first, as the title of the opening chapter."""
+    chapters = list(article.chapters)
+    if article.title:
+        if chapters and chapters[0].title is None:
+            chapters[0] = Chapter(article.title, chapters[0].paragraphs)
+        else:
+            chapters.insert(0, Chapter(article.title))
+    return chapters
+
+
+async def fetch_article(url: str) -> Article:
+    """Download url (HTML or plain text) and extract its main content; 400/502 on failure."""
+    async with httpx.AsyncClient(
+        timeout=READ_URL_TIMEOUT,
+        follow_redirects=False,
+        headers={"User-Agent": _USER_AGENT, "Accept": "text/html, text/plain;q=0.9"},
+        transport=PinnedTransport(READ_URL_ALLOW_PRIVATE),
+    ) as client:
+        for _ in range(_MAX_REDIRECTS + 1):
+            try:
+                async with client.stream("GET", url) as resp:
+                    if resp.is_redirect and resp.headers.get("location"):
+                        url = urljoin(url, resp.headers["location"])
+                        continue
+                    if resp.status_code != 200:
+                        raise HTTPException(status_code=502, detail=f"url returned HTTP {resp.status_code}")
+                    content_type = resp.headers.get("content-type", "").split(";")[0].strip().lower()
+                    if content_type not in {"text/html", "application/xhtml+xml", "text/plain", ""}:
+                        raise HTTPException(status_code=400, detail="url must be an HTML page or plain text")
+                    body = bytearray()
+                    async for chunk in resp.aiter_bytes():
+                        body.extend(chunk)
+                        if len(body) > READ_URL_MAX_BYTES:
+                            raise HTTPException(status_code=413, detail="Page too large to read aloud")
+                    text = bytes(body).decode(resp.encoding or "utf-8", "replace")
+            except httpx.HTTPError as exc:
+                logger.warning("Fetching %s for read-aloud failed: %s", url, exc)
+                raise HTTPException(status_code=502, detail="url could not be fetched")
+            if content_type == "text/plain":
+                return Article(title=None, chapters=parse_document(text, "text/plain"), url=url)
+            return extract_article(text, url)
+    raise HTTPException(status_code=502, detail="url redirected too many times")
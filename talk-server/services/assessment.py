@@ -0,0 +1,214 @@
+"""Language-learning feedback on a session: the LLM reviews the learner's turns.
+
+GET /v1/sessions/{id}/assessment sends the session's history (the learner's turns, with the
+assistant's replies for context) to the LLM with an examiner prompt and asks for JSON: for
+each learner turn a corrected version, grammar notes, and better word choices, plus overall
+grammar and vocabulary scores and a short summary. Explanations are written in the feedback
+language (the learner's own, DWANI_ASSESSMENT_FEEDBACK_LANGUAGE by default) while
+corrections stay in the language that was practised.
+
+The result is kept in the session's settings with a digest of the turns it covers, so asking
+again costs no LLM call until the conversation has moved on.
+"""
+import hashlib
+import json
+from dataclasses import asdict, dataclass, field
+from typing import Any, Dict, List, Optional
+
+from config import ASSESSMENT_FEEDBACK_LANGUAGE, ASSESSMENT_MAX_TOKENS, logger
+from models import GenerationParams
+from services.chat_svc import call_llm
+from services.errors import StageError
+from services.language_id import language_tag
+from services.prompts import language_display_name
+from services.session import History, get_session_settings, session_store, tenant_session_id, update_session_settings
+
+_SETTING = "assessment"
+_PROMPT = (
+    "You are a language teacher reviewing a learner's side of a conversation with a tutor. "
+    "Assess only the lines marked Learner; the Tutor lines are context. {practised}"
+    "For every Learner line give: the corrected sentence in the same language (or null when it "
+    "is already correct), grammar notes, and vocabulary suggestions (a more natural or precise "
+    "word or phrase, what it replaces, and why). Write notes, explanations, and the summary in "
+    "{feedback}. Then score grammar and vocabulary from 0 to 100 for the whole conversation. "
+    "Reply with only a JSON object: "
+    '{{"turns": [{{"index": 0, "corrected": null, "grammar": ["..."], "vocabulary": '
+    '[{{"instead_of": "...", "suggestion": "...", "explanation": "..."}}]}}], '
+    '"grammar_score": 0, "vocabulary_score": 0, "summary": "..."}}'
+)
+
+
+@dataclass
+class VocabularySuggestion:
+    instead_of: str
+    suggestion: str
+    explanation: str = ""
+
+
+@dataclass
+class TurnFeedback:
+    index: int
+    text: str
+    corrected: Optional[str] = None
+    grammar: List[str] = field(default_factory=list)
+    vocabulary: List[VocabularySuggestion] = field(default_factory=list)
+
+
+@dataclass
+class SessionAssessment:
+    session_id: str
+    feedback_language: str
+    grammar_score: Optional[int]
+    vocabulary_score: Optional[int]
+    summary: str
+    turns: List[TurnFeedback]
+    cached: bool = False
+
+    def as_dict(self) -> Dict[str, Any]:
+        return {**asdict(self), "feedback_language": language_tag(self.feedback_language)}
+
+
+def learner_turns(history: History) -> List[str]:
+    return [m.get("content", "") for m in history if m.get("role") == "user" and (m.get("content") or "").strip()]
+
+
+def _transcript(history: History) -> str:
+    lines, index = [], 0
+    for message in history:
+        content = " ".join((message.get("content") or "").split())
+        if not content:
+            continue
+        if message.get("role") == "user":
+            lines.append(f"Learner [{index}]: {content}")
+            index += 1
+        else:
+            lines.append(f"Tutor: {content}")
+    return "\n".join(lines)
+
+
+def _digest(turns: List[str], feedback_language: str, language: Optional[str]) -> str:
+    key = json.dumps([feedback_language, language, turns], ensure_ascii=False)
+    return hashlib.sha256(key.encode("utf-8")).hexdigest()[:16]
+
+
+def json_object(raw: str) -> Dict[str, Any]:
+    """The JSON object in an LLM reply, tolerating code fences or text around it."""
+    start, end = raw.find("{"), raw.rfind("}")
+    if start < 0 or end < start:
+        raise ValueError("no JSON object")
+    value = json.loads(raw[start:end + 1])
+    if not isinstance(value, dict):
+        raise ValueError("not a JSON object")
+    return value
+
+
+def _score(value: Any) -> Optional[int]:
+    try:
+        return max(0, min(100, round(float(value))))
+    except (TypeError, ValueError):
+        return None
+
+
+def _strings(value: Any) -> List[str]:
+    if isinstance(value, str):
+        value = [value]
+    if not isinstance(value, list):
+        return []
+    return [str(v).strip() for v in value if str(v).strip()]
+
+
+def _turn_feedback(turns: List[str], items: Any) -> List[TurnFeedback]:
+    by_index: Dict[int, Dict[str, Any]] = {}
+    for position, item in enumerate(items if isinstance(items, list) else []):
+        if isinstance(item, dict):
+            index = item.get("index", position)
+            if isinstance(index, int) and 0 <= index < len(turns):
+                by_index.setdefault(index, item)
+    feedback = []
+    for index, text in enumerate(turns):
+        item = by_index.get(index, {})
+        corrected = str(item.get("corrected") or "").strip() or None
+        suggestions = [
+            VocabularySuggestion(
+                instead_of=str(v.get("instead_of") or "").strip(),
+                suggestion=str(v.get("suggestion") or "").strip(),
+                explanation=str(v.get("explanation") or "").strip(),
+            )
+            for v in item.get("vocabulary") or []
+            if isinstance(v, dict) and str(v.get("suggestion") or "").strip()
+        ]
+        feedback.append(TurnFeedback(
+            index=index,
+            text=text,
+            corrected=corrected if corrected != text.strip() else None,
+            grammar=_strings(item.get("grammar")),
+            vocabulary=suggestions,
+        ))
+    return feedback
+
+
+def parse_assessment(raw: str, session_id: str, turns: List[str], feedback_language: str) -> SessionAssessment:
+    """SessionAssessment from the LLM's JSON; StageError when it is not usable."""
+    try:
+        data = json_object(raw)
+    except ValueError as exc:
+        logger.warning("Session assessment was not JSON (%s): %.200s", exc, raw)
+        raise StageError("llm", detail="LLM returned an invalid assessment")
+    return SessionAssessment(
+        session_id=session_id,
+        feedback_language=feedback_language,
+        grammar_score=_score(data.get("grammar_score")),
+        vocabulary_score=_score(data.get("vocabulary_score")),
+        summary=str(data.get("summary") or "").strip(),
+        turns=_turn_feedback(turns, data.get("turns")),
+    )
+
+
+def _from_cache(raw: Optional[str], digest: str) -> Optional[SessionAssessment]:
+    if not raw:
+        return None
+    try:
+        cached = json.loads(raw)
+        if cached.get("digest") != digest:
+            return None
+        result = cached["result"]
+        turns = [
+            TurnFeedback(**{**t, "vocabulary": [VocabularySuggestion(**v) for v in t.get("vocabulary", [])]})
+            for t in result.pop("turns")
+        ]
+        return SessionAssessment(**{**result, "turns": turns, "cached": True})
+    except (ValueError, TypeError, KeyError, AttributeError):
+        return None
+
+
+async def assess_session(
+    session_id: str,
+    feedback_language: Optional[str] = None,
+    language: Optional[str] = None,
+    refresh: bool = False,
+    request_id: Optional[str] = None,
+) -> Optional[SessionAssessment]:
+    """Feedback on the session's learner turns; None when it has none."""
+    history = session_store().load_history(tenant_session_id(session_id))
+    turns = learner_turns(history)
+    if not turns:
+        return None
+    feedback_language = feedback_language or ASSESSMENT_FEEDBACK_LANGUAGE
+    digest = _digest(turns, feedback_language, language)
+    if not refresh:
+        cached = _from_cache(get_session_settings(session_id).get(_SETTING), digest)
+        if cached is not None:
+            return cached
+
+    practised = f"The learner is practising {language_display_name(language)}. " if language else ""
+    raw = await call_llm(
+        _transcript(history),
+        request_id=request_id,
+        generation=GenerationParams(temperature=0, max_tokens=ASSESSMENT_MAX_TOKENS),
+        system_prompt=_PROMPT.format(practised=practised, feedback=language_display_name(feedback_language)),
+    )
+    assessment = parse_assessment(raw, session_id, turns, feedback_language)
+    update_session_settings(session_id, {
+        _SETTING: json.dumps({"digest": digest, "result": assessment.as_dict()}, ensure_ascii=False),
+    })
+    return assessment
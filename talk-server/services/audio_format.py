@@ -0,0 +1,295 @@
+"""Reply audio formats: detection of what the TTS backend returned, and ffmpeg transcoding.
+
+The requested format is forwarded to the backend as response_format; when it answers in a
+different format anyway, the audio is transcoded with ffmpeg (DWANI_FFMPEG_PATH).
+
+Some backends label their audio application/octet-stream or worse. DWANI_TTS_CONTENT_TYPES
+replaces the Content-Type a backend reports, for all backends or those under a URL prefix:
+"application/octet-stream=audio/mpeg,http://tts-b:9000 *=audio/wav". A body that is plainly
+not audio (an HTML error page or a JSON error behind a 200) is rejected rather than relayed.
+
+Input audio is sniffed too (sniff_input_format, not_audio_file), before uploads reach ASR
+(services/uploads.py).
+"""
+import asyncio
+from typing import List, Optional, Tuple
+
+from fastapi import HTTPException
+
+from config import FFMPEG_PATH, TTS_CONTENT_TYPES, logger
+from services.errors import StageError
+
+# "ogg" and "opus" are the same thing here: Opus audio in an Ogg container.
+AUDIO_FORMATS = ("mp3", "wav", "ogg", "opus")
+CONTENT_TYPES = {"mp3": "audio/mpeg", "wav": "audio/wav", "ogg": "audio/ogg"}
+_FFMPEG_ARGS = {
+    "mp3": ["-f", "mp3", "-codec:a", "libmp3lame", "-q:a", "4"],
+    "wav": ["-f", "wav", "-codec:a", "pcm_s16le"],
+    "ogg": ["-f", "ogg", "-codec:a", "libopus", "-b:a", "32k"],
+    # Not a reply format: raw 8 kHz G.711 for phone calls (services/telephony.py).
+    "mulaw": ["-f", "mulaw", "-ar", "8000", "-ac", "1"],
+    # Nor this: 16 kHz mono PCM WAV for audio preprocessing before ASR (services/preprocess.py).
+    "pcm16": ["-f", "wav", "-codec:a", "pcm_s16le", "-ar", "16000", "-ac", "1"],
+}
+_CONTENT_TYPE_FORMATS = {
+    "audio/mpeg": "mp3",
+    "audio/mp3": "mp3",
+    "audio/wav": "wav",
+    "audio/wave": "wav",
+    "audio/x-wav": "wav",
+    "audio/ogg": "ogg",
+    "audio/opus": "ogg",
+}
+
+
+def normalize_format(value: Optional[str]) -> Optional[str]:
+    """Canonical name ("mp3", "wav", "ogg") for a requested format; None when unset."""
+    value = (value or "").strip().lower()
+    if not value:
+        return None
+    if value not in AUDIO_FORMATS:
+        raise HTTPException(status_code=400, detail=f"format must be one of {list(AUDIO_FORMATS) + ['json']}")
+    return "ogg" if value == "opus" else value
+
+
+def detect_format(content_type: Optional[str], head: bytes = b"") -> Optional[str]:
+    """Format from the magic bytes, falling back to the Content-Type (often generic or wrong)."""
+    if head[:4] == b"RIFF" and head[8:12] == b"WAVE":
+        return "wav"
+    if head[:4] == b"OggS":
+        return "ogg"
+    if head[:3] == b"ID3" or (len(head) > 1 and head[0] == 0xFF and head[1] & 0xE0 == 0xE0):
+        return "mp3"
+    return _CONTENT_TYPE_FORMATS.get((content_type or "").split(";")[0].strip().lower())
+
+
+def parse_content_type_overrides(spec: str) -> List[Tuple[str, str, str]]:
+    """(backend URL prefix or "", reported type or "*", type to use) per "[prefix ]reported=type" entry."""
+    overrides = []
+    for item in spec.split(","):
+        rule, _, forced = item.partition("=")
+        prefix, _, reported = rule.strip().rpartition(" ")
+        if reported.strip() and forced.strip():
+            overrides.append((prefix.strip().rstrip("/"), reported.strip().lower(), forced.strip()))
+    # Backend-specific rules before global ones, exact types before "*".
+    return sorted(overrides, key=lambda o: (not o[0], o[1] == "*"))
+
+
+_OVERRIDES = parse_content_type_overrides(TTS_CONTENT_TYPES)
+if TTS_CONTENT_TYPES.strip() and not _OVERRIDES:
+    logger.warning("DWANI_TTS_CONTENT_TYPES has no valid reported=type entries: %r", TTS_CONTENT_TYPES)
+
+
+def override_content_type(base_url: str, reported: Optional[str]) -> Optional[str]:
+    """The Content-Type to use for a response from the backend at base_url, after DWANI_TTS_CONTENT_TYPES."""
+    media = (reported or "").split(";")[0].strip().lower()
+    for prefix, match, forced in _OVERRIDES:
+        if (not prefix or base_url.startswith(prefix)) and match in ("*", media):
+            return forced
+    return reported
+
+
+_TEXT_TYPES = ("text/", "application/json", "application/problem+json", "application/xml", "application/xhtml")
+
+
+def non_audio_reason(content_type: Optional[str], head: bytes) -> Optional[str]:
+    """Why a TTS body is not audio (an error page, JSON), or None when it may well be audio."""
+    if head[:4] in (b"RIFF", b"OggS") or detect_format(None, head[:12]):
+        return None
+    media = (content_type or "").split(";")[0].strip().lower()
+    if media.startswith(_TEXT_TYPES):
+        return f"Content-Type {media}"
+    sample = head[:64].lstrip()
+    try:
+        text = sample.decode("utf-8")
+    except UnicodeDecodeError:
+        return None  # binary: raw PCM or a format we do not sniff
+    if text[:1] in ("<", "{", "[") and all(ch.isprintable() or ch.isspace() for ch in text):
+        return "body looks like HTML or JSON"
+    return None
+
+
+def sniff_input_format(head: bytes) -> Optional[str]:
+    """The input audio format the magic bytes show (wav, mp3, ogg, flac, webm, mp4, aac, amr, aiff); None when unknown."""
+    if head[:4] == b"RIFF" and head[8:12] == b"WAVE":
+        return "wav"
+    if head[:4] == b"OggS":
+        return "ogg"
+    if head[:4] == b"fLaC":
+        return "flac"
+    if head[:4] == b"\x1aE\xdf\xa3":  # Matroska, of which WebM is a profile
+        return "webm"
+    if head[4:8] == b"ftyp":  # MP4, M4A and 3GP
+        return "mp4"
+    if head[:5] == b"#!AMR":
+        return "amr"
+    if head[:4] == b"FORM" and head[8:12] in (b"AIFF", b"AIFC"):
+        return "aiff"
+    if len(head) > 1 and head[0] == 0xFF and head[1] & 0xF6 == 0xF0:  # ADTS: MPEG sync with layer 0
+        return "aac"
+    if head[:3] == b"ID3" or (len(head) > 1 and head[0] == 0xFF and head[1] & 0xE0 == 0xE0):
+        return "mp3"
+    return None
+
+
+_NOT_AUDIO_MAGIC = (
+    (b"\x7fELF", "an ELF executable"),
+    (b"\xfe\xed\xfa\xce", "a Mach-O executable"),
+    (b"\xfe\xed\xfa\xcf", "a Mach-O executable"),
+    (b"\xce\xfa\xed\xfe", "a Mach-O executable"),
+    (b"\xcf\xfa\xed\xfe", "a Mach-O executable"),
+    (b"\xca\xfe\xba\xbe", "a Mach-O or Java executable"),
+    (b"#!/", "a script"),
+    (b"PK\x03\x04", "a zip archive"),
+    (b"\x1f\x8b\x08", "a gzip archive"),
+    (b"7z\xbc\xaf\x27\x1c", "a 7z archive"),
+    (b"Rar!", "a RAR archive"),
+    (b"%PDF", "a PDF document"),
+    (b"\xd0\xcf\x11\xe0", "an Office document"),
+    (b"\x89PNG", "an image"),
+    (b"\xff\xd8\xff", "an image"),
+    (b"GIF8", "an image"),
+)
+
+
+def not_audio_file(head: bytes) -> Optional[str]:
+    """What an uploaded file plainly is instead of audio (an executable, an archive, a document, HTML), or None."""
+    if sniff_input_format(head) is not None:
+        return None
+    if head[:2] == b"MZ" and len(head) >= 64:
+        pe = int.from_bytes(head[60:64], "little")  # offset of the PE header
+        if head[pe:pe + 4] == b"PE\0\0":
+            return "a Windows executable"
+    for magic, kind in _NOT_AUDIO_MAGIC:
+        if head.startswith(magic):
+            return kind
+    return "HTML or JSON" if non_audio_reason(None, head) else None
+
+
+def content_type_for(audio_format: Optional[str], fallback: Optional[str] = None) -> str:
+    return CONTENT_TYPES.get(audio_format or "", fallback or "application/octet-stream")
+
+
+async def transcode(audio: bytes, target: str, filters: Optional[str] = None, sample_rate: Optional[int] = None) -> bytes:
+    """Convert audio to target with ffmpeg, through an audio filter graph if given; a 502 tts stage error when ffmpeg is missing or fails."""
+    options = [*(["-af", filters] if filters else []), *(["-ar", str(sample_rate)] if sample_rate else [])]
+    try:
+        process = await asyncio.create_subprocess_exec(
+            FFMPEG_PATH, "-hide_banner", "-loglevel", "error", "-i", "pipe:0", *options, *_FFMPEG_ARGS[target], "pipe:1",
+            stdin=asyncio.subprocess.PIPE,
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.PIPE,
+        )
+    except OSError as exc:
+        logger.error("ffmpeg is not available for audio transcoding: %s", exc)
+        raise StageError("tts", detail=f"TTS audio could not be converted to {target}")
+    out, err = await process.communicate(audio)
+    if process.returncode != 0 or not out:
+        logger.error("ffmpeg transcoding to %s failed: %s", target, err.decode("utf-8", "replace").strip())
+        raise StageError("tts", detail=f"TTS audio could not be converted to {target}")
+    return out
+
+
+_MP3_BITRATES_V1 = (0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320)
+_MP3_BITRATES_V2 = (0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160)
+_MP3_SAMPLE_RATES = {3: (44100, 48000, 32000), 2: (22050, 24000, 16000), 0: (11025, 12000, 8000)}
+
+
+def _syncsafe(n: int) -> bytes:
+    return bytes(((n >> 21) & 0x7F, (n >> 14) & 0x7F, (n >> 7) & 0x7F, n & 0x7F))
+
+
+def _unsyncsafe(data: bytes) -> int:
+    return (data[0] & 0x7F) << 21 | (data[1] & 0x7F) << 14 | (data[2] & 0x7F) << 7 | (data[3] & 0x7F)
+
+
+def strip_id3(data: bytes) -> bytes:
+    """MP3 without its leading ID3v2 tags, so files can be concatenated into one stream."""
+    while len(data) >= 10 and data[:3] == b"ID3":
+        data = data[10 + _unsyncsafe(data[6:10]):]
+    return data
+
+
+def id3_frame(frame_id: str, text: str, description: Optional[str] = None) -> bytes:
+    """An ID3v2.4 text frame in UTF-8; a TXXX frame also takes a description."""
+    body = b"\x03" + (description.encode("utf-8") + b"\x00" if description is not None else b"") + text.encode("utf-8")
+    return frame_id.encode("ascii") + _syncsafe(len(body)) + b"\x00\x00" + body
+
+
+def _frame_key(frame: bytes) -> bytes:
+    """What a frame replaces: its ID, or a TXXX frame's ID and description."""
+    return frame[:4] if frame[:4] != b"TXXX" else frame[:4] + frame[11:].split(b"\x00", 1)[0]
+
+
+def tag_mp3(audio: bytes, frames: List[bytes]) -> bytes:
+    """MP3 with frames in its leading ID3v2.4 tag, replacing frames of the same kind, or in a new tag in front.
+
+    The tag must have arrived whole (it is in the first bytes of a streamed reply) and carry no
+    extended header; otherwise a tag of its own goes in front of it.
+    """
+    if not frames:
+        return audio
+    keys = {_frame_key(frame) for frame in frames}
+    kept, rest = [], audio
+    if len(audio) >= 10 and audio[:4] == b"ID3\x04" and audio[5] == 0 and 10 + _unsyncsafe(audio[6:10]) <= len(audio):
+        end = 10 + _unsyncsafe(audio[6:10])
+        body, rest = audio[10:end], audio[end:]
+        offset = 0
+        while offset + 10 <= len(body) and body[offset] != 0:  # frames end where the padding starts
+            size = 10 + _unsyncsafe(body[offset + 4:offset + 8])
+            if offset + size > len(body):
+                break
+            if _frame_key(body[offset:offset + size]) not in keys:
+                kept.append(body[offset:offset + size])
+            offset += size
+    tag = b"".join(frames + kept)
+    return b"ID3\x04\x00\x00" + _syncsafe(len(tag)) + tag + rest
+
+
+def sample_rate(data: bytes) -> Optional[int]:
+    """Sample rate of WAV (its fmt chunk), MP3 (the first frame header) or Ogg Opus (always 48 kHz) audio."""
+    audio_format = detect_format(None, data[:12])
+    if audio_format == "wav":
+        offset = 12
+        while offset + 8 <= len(data):
+            size = int.from_bytes(data[offset + 4:offset + 8], "little")
+            if data[offset:offset + 4] == b"fmt ":
+                rate = int.from_bytes(data[offset + 12:offset + 16], "little") if offset + 16 <= len(data) else 0
+                return rate or None
+            offset += 8 + size + (size & 1)
+        return None
+    if audio_format == "ogg":
+        return 48000 if b"OpusHead" in data[:64] else None
+    if audio_format != "mp3":
+        return None
+    data = strip_id3(data)
+    for offset in range(min(len(data), 4096) - 3):
+        b1, b2 = data[offset + 1], data[offset + 2]
+        version, rate_index = (b1 >> 3) & 3, (b2 >> 2) & 3
+        if data[offset] == 0xFF and b1 & 0xE0 == 0xE0 and version != 1 and (b1 >> 1) & 3 == 1 and rate_index != 3:
+            return _MP3_SAMPLE_RATES[version][rate_index]
+    return None
+
+
+def mp3_duration(data: bytes) -> Optional[float]:
+    """Duration of MPEG Layer III audio by walking its frame headers; None if none are found."""
+    data = strip_id3(data)
+    offset, samples, sample_rate = 0, 0, None
+    while offset + 4 <= len(data):
+        b1, b2 = data[offset + 1], data[offset + 2]
+        version, layer = (b1 >> 3) & 3, (b1 >> 1) & 3
+        bitrate_index, rate_index = b2 >> 4, (b2 >> 2) & 3
+        if (
+            data[offset] != 0xFF or b1 & 0xE0 != 0xE0 or version == 1 or layer != 1
+            or bitrate_index in (0, 15) or rate_index == 3
+        ):
+            offset += 1  # not a frame header: resync
+            continue
+        mpeg1 = version == 3
+        bitrate = (_MP3_BITRATES_V1 if mpeg1 else _MP3_BITRATES_V2)[bitrate_index] * 1000
+        sample_rate = _MP3_SAMPLE_RATES[version][rate_index]
+        offset += (144 if mpeg1 else 72) * bitrate // sample_rate + ((b2 >> 1) & 1)
+        samples += 1152 if mpeg1 else 576
+    if not samples or not sample_rate:
+        return None
+    return samples / sample_rate
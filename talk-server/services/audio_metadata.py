@@ -0,0 +1,64 @@
+"""Self-describing reply audio: ID3 tags on MP3 output.
+
+With DWANI_AUDIO_METADATA=1 every MP3 clip talk-server sends has an ID3v2.4 tag saying what it
+is: its language (TLAN, and the BCP-47 tag as TXXX "language"), the request ID (TXXX
+"request_id"), when it was made (TDRC, UTC) and, as its title (TIT2), the first
+DWANI_AUDIO_METADATA_EXCERPT_CHARS characters of what it says. A downloaded or shared file can
+then be traced back to its request. Frames go into the tag the backend (or the demo watermark)
+already put in front, replacing ones of the same kind, and a tag is added otherwise.
+
+The tag is put on as the audio is sent (services/tts.py), after every after_tts hook; the TTS
+cache and request recordings keep the audio as the backend returned it. WAV and Ogg are sent as
+they are: their metadata lives in chunks and codec comments that players mostly ignore.
+"""
+from datetime import datetime, timezone
+from typing import List, Optional
+
+from config import AUDIO_METADATA, AUDIO_METADATA_EXCERPT_CHARS
+from models import LANGUAGE_TAGS
+from services.audio_format import id3_frame
+
+# TLAN takes ISO 639-2 codes.
+_ISO_639_2 = {
+    "kannada": "kan",
+    "hindi": "hin",
+    "tamil": "tam",
+    "malayalam": "mal",
+    "telugu": "tel",
+    "marathi": "mar",
+    "english": "eng",
+    "german": "deu",
+}
+
+
+def excerpt(text: str, max_chars: int) -> str:
+    """The start of text, cut after a word when it is longer than max_chars."""
+    text = " ".join(text.split())
+    if len(text) <= max_chars:
+        return text
+    cut = text[:max_chars]
+    if text[max_chars] != " " and " " in cut:
+        cut = cut.rsplit(" ", 1)[0]
+    return cut.rstrip(",;:") + "…"
+
+
+def metadata_frames(
+    text: str,
+    language: Optional[str],
+    request_id: Optional[str],
+    now: Optional[datetime] = None,
+) -> List[bytes]:
+    """The ID3 frames describing a clip of text; none when DWANI_AUDIO_METADATA is off."""
+    if not AUDIO_METADATA:
+        return []
+    made = (now or datetime.now(timezone.utc)).astimezone(timezone.utc)
+    frames = [id3_frame("TDRC", made.strftime("%Y-%m-%dT%H:%M:%S"))]
+    if AUDIO_METADATA_EXCERPT_CHARS > 0 and text.strip():
+        frames.append(id3_frame("TIT2", excerpt(text, AUDIO_METADATA_EXCERPT_CHARS)))
+    if language in _ISO_639_2:
+        frames.append(id3_frame("TLAN", _ISO_639_2[language]))
+    if language:
+        frames.append(id3_frame("TXXX", LANGUAGE_TAGS.get(language, language), "language"))
+    if request_id:
+        frames.append(id3_frame("TXXX", request_id, "request_id"))
+    return frames
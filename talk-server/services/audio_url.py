@@ -0,0 +1,86 @@
+"""Input audio fetched from a URL, for callers that would otherwise proxy the bytes.
+
+/v1/speech_to_speech accepts a JSON body {"audio_url": "..."} instead of a multipart upload:
+an http(s) URL, such as an S3 or GCS presigned URL, that talk-server downloads itself. As for
+read-aloud of web pages, every hop of a redirect is checked so that neither the URL nor a
+redirect can reach private, loopback, or link-local addresses (unless
+DWANI_AUDIO_URL_ALLOW_PRIVATE=1), and is fetched from the address that was checked
+(services/egress.py). The download stops at DWANI_MAX_UPLOAD_BYTES, and the
+response must be audio: an audio/* or generic binary Content-Type whose bytes are not text.
+The query string is never logged, since presigned URLs carry their signature there.
+"""
+from typing import Optional
+from urllib.parse import unquote, urljoin, urlparse
+
+import httpx
+from fastapi import HTTPException, UploadFile
+
+from config import AUDIO_URL_ALLOW_PRIVATE, AUDIO_URL_TIMEOUT, MAX_UPLOAD_BYTES, logger
+from services.audio_format import content_type_for, detect_format, non_audio_reason
+from services.egress import PinnedTransport
+from services.errors import RequestError
+from services.pipeline import audio_upload
+
+_MAX_REDIRECTS = 5
+_USER_AGENT = "dwani-talk-audio-fetch/1.0"
+# Object stores often serve uploads without a specific type.
+_GENERIC_TYPES = {"", "application/octet-stream", "binary/octet-stream", "video/webm", "video/mp4"}
+
+
+def redacted(url: str) -> str:
+    """url without its query string or credentials, for logs."""
+    parsed = urlparse(url)
+    return f"{parsed.scheme}://{parsed.hostname or ''}{f':{parsed.port}' if parsed.port else ''}{parsed.path}"
+
+
+def _filename(url: str) -> str:
+    name = unquote(urlparse(url).path.rsplit("/", 1)[-1])
+    return name[:128] or "audio"
+
+
+def _audio_type(content_type: str, head: bytes) -> Optional[str]:
+    """The upload's media type, or None when the response is not audio."""
+    if not (content_type.startswith("audio/") or content_type in _GENERIC_TYPES) or non_audio_reason(content_type, head):
+        return None
+    if content_type.startswith("audio/"):
+        return content_type
+    return content_type_for(detect_format(None, head[:12]), "application/octet-stream")
+
+
+async def fetch_audio(url: str) -> UploadFile:
+    """Download url as an upload for the pipeline; 400/413/502 on failure."""
+    max_mb = MAX_UPLOAD_BYTES // (1024 * 1024)
+    async with httpx.AsyncClient(
+        timeout=AUDIO_URL_TIMEOUT,
+        follow_redirects=False,
+        headers={"User-Agent": _USER_AGENT, "Accept": "audio/*, application/octet-stream;q=0.9"},
+        transport=PinnedTransport(AUDIO_URL_ALLOW_PRIVATE, "audio_url"),
+    ) as client:
+        for _ in range(_MAX_REDIRECTS + 1):
+            try:
+                async with client.stream("GET", url) as resp:
+                    if resp.is_redirect and resp.headers.get("location"):
+                        url = urljoin(url, resp.headers["location"])
+                        continue
+                    if resp.status_code != 200:
+                        raise HTTPException(status_code=502, detail=f"audio_url returned HTTP {resp.status_code}")
+                    declared = resp.headers.get("content-length", "")
+                    if declared.isdigit() and int(declared) > MAX_UPLOAD_BYTES:
+                        raise HTTPException(status_code=413, detail=f"File too large (max {max_mb}MB)")
+                    content_type = resp.headers.get("content-type", "").split(";")[0].strip().lower()
+                    body = bytearray()
+                    async for chunk in resp.aiter_bytes():
+                        body.extend(chunk)
+                        if len(body) > MAX_UPLOAD_BYTES:
+                            raise HTTPException(status_code=413, detail=f"File too large (max {max_mb}MB)")
+            except httpx.HTTPError as exc:
+                logger.warning("Fetching audio from %s failed: %s", redacted(url), exc)
+                raise HTTPException(status_code=502, detail="audio_url could not be fetched")
+            if not body:
+                raise RequestError(400, "empty_input", "Empty audio file")
+            media_type = _audio_type(content_type, bytes(body[:4096]))
+            if media_type is None:
+                raise HTTPException(status_code=400, detail="audio_url must point to an audio file")
+            logger.info("Fetched input audio", extra={"audio_url": redacted(url), "bytes": len(body)})
+            return audio_upload(bytes(body), media_type, _filename(url))
+    raise HTTPException(status_code=502, detail="audio_url redirected too many times")
@@ -0,0 +1,241 @@
+"""Backend endpoints used by the pipeline stages.
+
+Each value comes from the DWANI_* environment unless an embedded Pipeline
+(services/pipeline.py) has overridden it for the current task via use_config(), or the
+request's tenant (services/tenants.py) has via use_backends().
+ASR, LLM, and TTS URLs may be comma-separated lists; the *_pool() functions balance
+over them (services/balancer.py). API keys come from services/credentials.py, so they may
+also be files or come from a secrets provider. In mock mode (services/mock.py), unset URLs
+point at the mock backends.
+"""
+import os
+from contextlib import contextmanager
+from contextvars import ContextVar
+from dataclasses import dataclass, replace
+from typing import Dict, Iterator, Optional
+
+from config import (
+    AGENT_BASE_URL,
+    BACKEND_AUTH_HEADERS,
+    LLM_FALLBACK_BASE_URL,
+    LLM_FALLBACK_MODEL,
+    LLM_MODEL,
+    SHADOW_ASR_URL,
+    SHADOW_TTS_URL,
+    TURN_MAX_LLM_TOKENS,
+    TURN_MAX_TTS_CHARS,
+)
+from services.balancer import BackendPool, pool
+from services import mock
+from services.credentials import secret
+
+_DEFAULT_ASR_URL = "http://localhost:8000/v1/chat/completions"
+
+
+@dataclass(frozen=True)
+class PipelineConfig:
+    """Backends for an embedded Pipeline; a field left as None falls back to its env var."""
+
+    asr_url: Optional[str] = None  # DWANI_CHAT_COMPLETIONS_URL
+    asr_api_key: Optional[str] = None  # DWANI_ASR_API_KEY
+    vision_url: Optional[str] = None  # DWANI_VISION_URL
+    llm_base_url: Optional[str] = None  # DWANI_API_BASE_URL_LLM
+    llm_model: Optional[str] = None  # DWANI_LLM_MODEL
+    llm_api_key: Optional[str] = None  # DWANI_LLM_API_KEY
+    tts_base_url: Optional[str] = None  # DWANI_API_BASE_URL_TTS
+    tts_api_key: Optional[str] = None  # DWANI_TTS_API_KEY
+    translation_base_url: Optional[str] = None  # DWANI_TRANSLATION_BASE_URL (unset = the LLM)
+    translation_model: Optional[str] = None  # DWANI_TRANSLATION_MODEL
+    translation_api_key: Optional[str] = None  # DWANI_TRANSLATION_API_KEY
+    agent_base_url: Optional[str] = None  # DWANI_AGENT_BASE_URL
+    agent_api_key: Optional[str] = None  # AGENTS_API_KEY
+    max_llm_tokens: Optional[int] = None  # DWANI_TURN_MAX_LLM_TOKENS (0 = no budget)
+    max_tts_chars: Optional[int] = None  # DWANI_TURN_MAX_TTS_CHARS (0 = no budget)
+
+
+_active: ContextVar[Optional[PipelineConfig]] = ContextVar("dwani_pipeline_config", default=None)
+
+
+@contextmanager
+def use_config(config: Optional[PipelineConfig]) -> Iterator[None]:
+    token = _active.set(config)
+    try:
+        yield
+    finally:
+        _active.reset(token)
+
+
+def use_backends(config: Optional[PipelineConfig]) -> None:
+    """Apply config for the rest of the current request or task, as a tenant's backends (services/tenants.py)."""
+    _active.set(config)
+
+
+def _override(field: str) -> Optional[str]:
+    config = _active.get()
+    return getattr(config, field) if config is not None else None
+
+
+def _mock_url(stage: str) -> str:
+    return mock.MOCK_URLS[stage] if mock.enabled() else ""
+
+
+def asr_url() -> str:
+    return _override("asr_url") or os.getenv("DWANI_CHAT_COMPLETIONS_URL", _mock_url("asr") or _DEFAULT_ASR_URL)
+
+
+def asr_pool() -> BackendPool:
+    return pool("asr", asr_url())
+
+
+def asr_api_key() -> str:
+    return _override("asr_api_key") or secret("DWANI_ASR_API_KEY")
+
+
+def vision_url() -> str:
+    """Vision chat-completions endpoint; the ASR endpoint's multimodal model when unset."""
+    return _override("vision_url") or os.getenv("DWANI_VISION_URL", "").strip() or asr_url()
+
+
+def vision_pool() -> BackendPool:
+    return pool("vision", vision_url())
+
+
+def vision_api_key() -> str:
+    """DWANI_VISION_API_KEY; the ASR key while vision uses the ASR endpoint."""
+    return secret("DWANI_VISION_API_KEY") or (asr_api_key() if vision_url() == asr_url() else "")
+
+
+def llm_base_url() -> str:
+    return (_override("llm_base_url") or os.getenv("DWANI_API_BASE_URL_LLM", "") or _mock_url("llm")).rstrip("/")
+
+
+def llm_pool() -> BackendPool:
+    return pool("llm", llm_base_url())
+
+
+def llm_model() -> str:
+    return _override("llm_model") or LLM_MODEL
+
+
+def llm_api_key() -> str:
+    return _override("llm_api_key") or secret("DWANI_LLM_API_KEY")
+
+
+def llm_model_pinned() -> bool:
+    """Whether an embedded pipeline, tenant or backend switch has set the LLM model for this task."""
+    return _override("llm_model") is not None
+
+
+@contextmanager
+def use_llm_model(model: str, base_url: Optional[str] = None) -> Iterator[None]:
+    """Point LLM calls at model (on base_url, when given) for the duration; an A/B variant (services/llm_variants.py)."""
+    current = _active.get() or PipelineConfig()
+    with use_config(replace(current, llm_model=model, llm_base_url=base_url or current.llm_base_url)):
+        yield
+
+
+def llm_fallback_base_url() -> str:
+    """Backend LLM calls move to while the LLM is slow (services/llm_health.py); empty when there is none."""
+    return LLM_FALLBACK_BASE_URL
+
+
+@contextmanager
+def use_llm_fallback() -> Iterator[None]:
+    """Point LLM calls at the fallback backend and model for the duration."""
+    current = _active.get() or PipelineConfig()
+    with use_config(replace(
+        current,
+        llm_base_url=LLM_FALLBACK_BASE_URL,
+        llm_model=LLM_FALLBACK_MODEL or llm_model(),
+        llm_api_key=secret("DWANI_LLM_FALLBACK_API_KEY") or llm_api_key(),
+    )):
+        yield
+
+
+def translation_base_url() -> str:
+    """OpenAI-compatible backend for the translation stage; empty to translate with the LLM."""
+    return (_override("translation_base_url") or os.getenv("DWANI_TRANSLATION_BASE_URL", "")).rstrip("/")
+
+
+@contextmanager
+def use_translation_backend() -> Iterator[None]:
+    """Point LLM calls at the translation backend, when one is configured, for the duration."""
+    base_url = translation_base_url()
+    if not base_url:
+        yield
+        return
+    current = _active.get() or PipelineConfig()
+    with use_config(replace(
+        current,
+        llm_base_url=base_url,
+        llm_model=_override("translation_model") or os.getenv("DWANI_TRANSLATION_MODEL", "") or llm_model(),
+        llm_api_key=_override("translation_api_key") or secret("DWANI_TRANSLATION_API_KEY") or llm_api_key(),
+    )):
+        yield
+
+
+@contextmanager
+def use_shadow_backend(stage: str) -> Iterator[None]:
+    """Point the stage's (asr or tts) calls at its shadow candidate (services/shadow.py) for the duration."""
+    current = _active.get() or PipelineConfig()
+    if stage == "asr":
+        candidate = replace(current, asr_url=SHADOW_ASR_URL, asr_api_key=secret("DWANI_SHADOW_ASR_API_KEY") or None)
+    else:
+        candidate = replace(current, tts_base_url=SHADOW_TTS_URL, tts_api_key=secret("DWANI_SHADOW_TTS_API_KEY") or None)
+    with use_config(candidate):
+        yield
+
+
+def tts_base_url() -> str:
+    return (_override("tts_base_url") or os.getenv("DWANI_API_BASE_URL_TTS", "") or _mock_url("tts")).rstrip("/")
+
+
+def tts_pool() -> BackendPool:
+    return pool("tts", tts_base_url())
+
+
+def tts_api_key() -> str:
+    return _override("tts_api_key") or secret("DWANI_TTS_API_KEY")
+
+
+def agent_base_url() -> str:
+    return (_override("agent_base_url") or AGENT_BASE_URL or _mock_url("agent")).rstrip("/")
+
+
+def agent_api_key() -> str:
+    return (_override("agent_api_key") or secret("AGENTS_API_KEY")).strip()
+
+
+def retrieval_api_key() -> str:
+    return secret("DWANI_RETRIEVAL_API_KEY")
+
+
+_API_KEYS = {
+    "asr": asr_api_key,
+    "vision": vision_api_key,
+    "tts": tts_api_key,
+    "agent": agent_api_key,
+    "retrieval": retrieval_api_key,
+}
+_DEFAULT_AUTH_HEADERS = {"agent": "X-API-Key"}
+
+
+def auth_headers(stage: str) -> Dict[str, str]:
+    """The stage's API key as its DWANI_BACKEND_AUTH_HEADERS header (Authorization: Bearer by default); {} without one."""
+    key = _API_KEYS[stage]()
+    if not key:
+        return {}
+    header = BACKEND_AUTH_HEADERS.get(stage) or _DEFAULT_AUTH_HEADERS.get(stage, "Authorization")
+    return {header: f"Bearer {key}" if header.lower() == "authorization" else key}
+
+
+def max_llm_tokens() -> int:
+    """Per-turn LLM token budget; 0 when there is none."""
+    value = _override("max_llm_tokens")
+    return TURN_MAX_LLM_TOKENS if value is None else value
+
+
+def max_tts_chars() -> int:
+    """Per-turn TTS character budget; 0 when there is none."""
+    value = _override("max_tts_chars")
+    return TURN_MAX_TTS_CHARS if value is None else value
@@ -0,0 +1,323 @@
+"""Load balancing and failover across several instances of one backend stage.
+
+DWANI_CHAT_COMPLETIONS_URL (ASR), DWANI_API_BASE_URL_LLM, and DWANI_API_BASE_URL_TTS may
+each list several URLs, comma-separated. Requests are spread over them round-robin or to the lowest-latency
+instance (DWANI_BACKEND_BALANCING) and fail over to the next one on a connection error or
+a 5xx. An instance that fails DWANI_BACKEND_FAILURE_THRESHOLD times in a row is ejected for
+DWANI_BACKEND_EJECT_SECONDS, then tried again; while every instance is ejected they are all
+still tried, healthiest first. Health is tracked per worker process.
+The admin API (routers/admin.py) may also disable an instance for a while, so it gets no
+requests at all, or change its round-robin weight (1 by default; 0 only when the others fail).
+A URL may also name a service to discover instances from (services/discovery.py).
+hedged() races a slow request against another instance instead of waiting for it to fail.
+"""
+import asyncio
+import itertools
+import math
+import threading
+import time
+from dataclasses import dataclass
+from typing import Awaitable, Callable, Dict, List, Optional, Tuple, TypeVar
+
+import httpx
+from prometheus_client import Counter, Gauge
+
+from config import BACKEND_BALANCING, BACKEND_EJECT_SECONDS, BACKEND_FAILURE_THRESHOLD, logger
+from services import discovery
+from services.concurrency import stage_slot
+from services.errors import STAGE_NAMES, StageError
+from services.pacing import paced
+
+T = TypeVar("T")
+
+_UP = Gauge("dwani_backend_up", "Whether a backend instance is in rotation (1) or ejected (0)", ["stage", "url"])
+_FAILOVERS = Counter("dwani_backend_failovers_total", "Requests moved to another backend instance", ["stage"])
+_HEDGES = Counter("dwani_backend_hedges_total", "Hedged requests sent to a second backend instance, and those it won", ["stage", "outcome"])
+# Weight of the newest sample in the moving latency average.
+_EWMA_ALPHA = 0.3
+
+
+def split_urls(raw: str) -> Tuple[str, ...]:
+    return tuple(url.strip().rstrip("/") for url in (raw or "").split(",") if url.strip())
+
+
+def connection_failed(exc: BaseException) -> bool:
+    """Errors that mean the request never reached the backend, so another instance may serve it."""
+    return isinstance(exc, (httpx.ConnectError, httpx.ConnectTimeout))
+
+
+def server_error(response: httpx.Response) -> bool:
+    return response.status_code >= 500
+
+
+@dataclass
+class Instance:
+    url: str
+    latency: Optional[float] = None  # moving average of successful calls, seconds
+    failures: int = 0  # consecutive
+    ejected_until: float = 0.0
+    in_flight: int = 0
+    weight: int = 1  # share of round-robin turns
+    disabled_until: float = 0.0  # set by an admin; math.inf until enabled again
+
+    def available(self, now: float) -> bool:
+        return self.ejected_until <= now
+
+    def disabled(self, now: float) -> bool:
+        return self.disabled_until > now
+
+    def snapshot(self, now: float) -> Dict[str, object]:
+        return {
+            "url": self.url,
+            "healthy": self.available(now),
+            "consecutive_failures": self.failures,
+            "latency_ms": round(self.latency * 1000, 1) if self.latency is not None else None,
+            "in_flight": self.in_flight,
+            "weight": self.weight,
+            "disabled": self.disabled(now),
+            "disabled_for_s": round(self.disabled_until - now) if self.disabled(now) and self.disabled_until != math.inf else None,
+        }
+
+
+class BackendPool:
+    """Instances of one stage with their health; see the module docstring for the policy."""
+
+    def __init__(
+        self,
+        stage: str,
+        urls: Tuple[str, ...],
+        strategy: str = BACKEND_BALANCING,
+        failure_threshold: int = BACKEND_FAILURE_THRESHOLD,
+        eject_seconds: float = BACKEND_EJECT_SECONDS,
+    ):
+        self.stage = stage
+        self.sources = urls  # as configured, discovery URLs included
+        self.instances = [Instance(url) for url in discovery.expand(urls)]
+        self.strategy = strategy
+        self.failure_threshold = max(1, failure_threshold)
+        self.eject_seconds = eject_seconds
+        self._next = itertools.count()
+        for instance in self.instances:
+            _UP.labels(stage=stage, url=instance.url).set(1)
+
+    def set_urls(self, urls: Tuple[str, ...]) -> None:
+        """Serve from urls from now on; instances that stay keep their health record."""
+        current = {instance.url: instance for instance in self.instances}
+        for url in set(current) - set(urls):
+            _UP.remove(self.stage, url)
+        self.instances = [current.get(url) or Instance(url) for url in urls]
+        for url in set(urls) - set(current):
+            _UP.labels(stage=self.stage, url=url).set(1)
+
+    def candidates(self) -> List[Instance]:
+        """Instances in the order to try them for one request; disabled ones are left out."""
+        now = time.monotonic()
+        enabled = [i for i in self.instances if not i.disabled(now)]
+        if self.strategy == "least_latency":
+            # Unmeasured instances first so each gets a sample; in-flight count breaks ties.
+            ordered = sorted(enabled, key=lambda i: (i.latency is not None, i.latency or 0.0, i.in_flight))
+        else:
+            # Each instance starts as many turns in the rotation as its weight.
+            turns = [index for index, i in enumerate(enabled) for _ in range(i.weight)] or [0]
+            start = turns[next(self._next) % len(turns)]
+            ordered = enabled[start:] + enabled[:start]
+        healthy = [i for i in ordered if i.available(now) and i.weight > 0]
+        spare = [i for i in ordered if i.available(now) and i.weight == 0]
+        ejected = sorted((i for i in ordered if not i.available(now)), key=lambda i: i.ejected_until)
+        return healthy + spare + ejected
+
+    def record_success(self, instance: Instance, seconds: Optional[float] = None) -> None:
+        if instance.failures >= self.failure_threshold:
+            logger.info("%s backend %s recovered", self.stage.upper(), instance.url)
+        instance.failures = 0
+        instance.ejected_until = 0.0
+        if seconds is not None:
+            instance.latency = seconds if instance.latency is None else (
+                _EWMA_ALPHA * seconds + (1 - _EWMA_ALPHA) * instance.latency
+            )
+        _UP.labels(stage=self.stage, url=instance.url).set(1)
+
+    def record_failure(self, instance: Instance) -> None:
+        instance.failures += 1
+        if instance.failures >= self.failure_threshold:
+            if instance.available(time.monotonic()):
+                logger.warning(
+                    "%s backend %s ejected for %ss after %s consecutive failures",
+                    self.stage.upper(), instance.url, self.eject_seconds, instance.failures,
+                )
+            instance.ejected_until = time.monotonic() + self.eject_seconds
+            _UP.labels(stage=self.stage, url=instance.url).set(0)
+
+    def reset(self) -> int:
+        """Put every instance back in rotation with a clean record; the number that were ejected."""
+        now = time.monotonic()
+        ejected = sum(not instance.available(now) for instance in self.instances)
+        for instance in self.instances:
+            instance.failures = 0
+            instance.ejected_until = 0.0
+            _UP.labels(stage=self.stage, url=instance.url).set(1)
+        if ejected:
+            logger.info("%s backends reset; %s back in rotation", self.stage.upper(), ejected)
+        return ejected
+
+    async def call(
+        self,
+        fn: Callable[[str], Awaitable[T]],
+        failed_result: Optional[Callable[[T], bool]] = None,
+        failed_error: Callable[[BaseException], bool] = connection_failed,
+        discard: Optional[Callable[[T], Awaitable[None]]] = None,
+    ) -> T:
+        """Run fn(url) against instances in turn until one succeeds.
+
+        failed_result flags a response worth failing over on (e.g. a 5xx); the last
+        instance's response is returned even then, so callers keep their own error handling.
+        discard releases a flagged response (e.g. closes a stream) before moving on.
+        The call holds a slot of the stage's limiter (services/concurrency.py) throughout, and
+        each attempt keeps to its instance's pace (services/pacing.py).
+        """
+        async with stage_slot(self.stage):
+            return await self._call(fn, failed_result, failed_error, discard)
+
+    async def _call(
+        self,
+        fn: Callable[[str], Awaitable[T]],
+        failed_result: Optional[Callable[[T], bool]],
+        failed_error: Callable[[BaseException], bool],
+        discard: Optional[Callable[[T], Awaitable[None]]],
+    ) -> T:
+        candidates = self.candidates()
+        if not candidates:
+            raise StageError(self.stage, 503, f"No {STAGE_NAMES.get(self.stage, self.stage)} backend instances are available")
+        for attempt, instance in enumerate(candidates):
+            last = attempt == len(candidates) - 1
+            instance.in_flight += 1
+            try:
+                async with paced(self.stage, instance.url):
+                    # Latency is the backend's own, not the time spent waiting for its turn.
+                    started = time.monotonic()
+                    result = await fn(instance.url)
+            except BaseException as exc:
+                if not failed_error(exc):
+                    raise
+                self.record_failure(instance)
+                if last:
+                    raise
+                logger.warning("%s backend %s failed (%s); trying next", self.stage.upper(), instance.url, type(exc).__name__)
+                _FAILOVERS.labels(stage=self.stage).inc()
+                continue
+            finally:
+                instance.in_flight -= 1
+            if failed_result is not None and failed_result(result):
+                self.record_failure(instance)
+                if not last:
+                    logger.warning("%s backend %s returned a server error; trying next", self.stage.upper(), instance.url)
+                    _FAILOVERS.labels(stage=self.stage).inc()
+                    if discard is not None:
+                        await discard(result)
+                    continue
+                return result
+            self.record_success(instance, time.monotonic() - started)
+            return result
+        raise RuntimeError(f"No {self.stage} backend configured")
+
+    async def hedged(
+        self,
+        fn: Callable[[str], Awaitable[T]],
+        delay: float,
+        failed_error: Callable[[BaseException], bool] = connection_failed,
+    ) -> T:
+        """Run fn(url) on the best instance and, when it has not answered within delay seconds
+        or failed_error flags its failure, on the next one too (the same one when it is alone).
+
+        The first to succeed wins and the other is cancelled; the call fails when both do. Both
+        attempts share one slot of the stage's limiter.
+        """
+        async with stage_slot(self.stage):
+            candidates = self.candidates()
+            if not candidates:
+                raise StageError(self.stage, 503, f"No {STAGE_NAMES.get(self.stage, self.stage)} backend instances are available")
+            spare: Optional[Instance] = candidates[1] if len(candidates) > 1 else candidates[0]
+            hedge: Optional[asyncio.Task] = None
+            running = {asyncio.create_task(self._attempt(fn, candidates[0], failed_error))}
+            error: Optional[BaseException] = None
+            try:
+                while running:
+                    done, running = await asyncio.wait(
+                        running, timeout=delay if spare is not None else None, return_when=asyncio.FIRST_COMPLETED
+                    )
+                    for task in done:
+                        if task.exception() is None:
+                            if task is hedge:
+                                _HEDGES.labels(stage=self.stage, outcome="won").inc()
+                            return task.result()
+                        error = task.exception()
+                    if spare is not None and (not done or failed_error(error)):
+                        logger.info("%s backend is slow or failed; hedging on %s", self.stage.upper(), spare.url)
+                        _HEDGES.labels(stage=self.stage, outcome="sent").inc()
+                        hedge = asyncio.create_task(self._attempt(fn, spare, failed_error))
+                        running.add(hedge)
+                        spare = None
+                raise error
+            finally:
+                for task in running:
+                    task.cancel()
+                await asyncio.gather(*running, return_exceptions=True)
+
+    async def _attempt(
+        self,
+        fn: Callable[[str], Awaitable[T]],
+        instance: Instance,
+        failed_error: Callable[[BaseException], bool],
+    ) -> T:
+        instance.in_flight += 1
+        try:
+            async with paced(self.stage, instance.url):
+                started = time.monotonic()
+                result = await fn(instance.url)
+        except Exception as exc:
+            if failed_error(exc):
+                self.record_failure(instance)
+            raise
+        finally:
+            instance.in_flight -= 1
+        self.record_success(instance, time.monotonic() - started)
+        return result
+
+
+_pools: Dict[Tuple[str, Tuple[str, ...]], BackendPool] = {}
+_pools_lock = threading.Lock()
+
+
+def pool(stage: str, raw_urls: str) -> BackendPool:
+    """Shared pool for a stage's URL list, so health carries over between requests."""
+    # An unset URL stays a single empty instance, so the request fails as it always has.
+    urls = split_urls(raw_urls) or ("",)
+    key = (stage, urls)
+    with _pools_lock:
+        existing = _pools.get(key)
+        if existing is None:
+            existing = _pools[key] = BackendPool(stage, urls)
+        return existing
+
+
+def refresh_pools() -> None:
+    """Move pools with discovery URLs to the instances last found for them."""
+    for existing in pools():
+        if any(discovery.is_discovered(url) for url in existing.sources):
+            existing.set_urls(discovery.expand(existing.sources))
+
+
+def pools() -> List[BackendPool]:
+    with _pools_lock:
+        return list(_pools.values())
+
+
+def instances_at(stage: str, url: str) -> List[Instance]:
+    """The instances of stage at url, one per pool that has it (tenants' pools included)."""
+    url = url.strip().rstrip("/")
+    return [i for p in pools() if p.stage == stage for i in p.instances if i.url == url]
+
+
+def reset_pools() -> None:
+    with _pools_lock:
+        _pools.clear()
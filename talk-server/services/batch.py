@@ -0,0 +1,141 @@
+"""Batch runs: many recordings through the pipeline in one request, for evaluating it on a test corpus.
+
+POST /v1/batch/speech_to_speech takes several files, or zip archives of them, and runs each
+through ASR, the LLM/agent and TTS, DWANI_BATCH_CONCURRENCY at a time. The response is a
+manifest with one result per file, in upload order. A file that fails gets the error object a
+job would, and the others carry on. Files are independent turns with no session, and like
+jobs they wait for a pipeline slot as batch work rather than being turned away.
+"""
+import asyncio
+import base64
+import mimetypes
+import posixpath
+import shutil
+import time
+import zipfile
+from typing import Any, Dict, List, Optional
+
+from fastapi import HTTPException, UploadFile
+from starlette.datastructures import Headers
+
+from config import BATCH_CONCURRENCY, BATCH_MAX_FILES, MAX_UPLOAD_BYTES, logger
+from services.concurrency import pipeline_limiter
+from services.jobs import failure
+from services.language_id import AUTO_LANGUAGE, language_tag
+from services.personas import Persona
+from services.pipeline import converse
+from services.tts import stream_tts
+from services.uploads import spooled_file
+
+_ZIP_TYPES = {"application/zip", "application/x-zip-compressed"}
+_CHUNK = 64 * 1024
+
+
+def _is_zip(file: UploadFile) -> bool:
+    return (file.content_type or "").lower() in _ZIP_TYPES or (file.filename or "").lower().endswith(".zip")
+
+
+def _entries(archive: UploadFile) -> List[UploadFile]:
+    """The files in a zip upload, in archive order; folders and macOS metadata are left out."""
+    try:
+        bundle = zipfile.ZipFile(archive.file)
+    except zipfile.BadZipFile:
+        raise HTTPException(status_code=400, detail=f"{archive.filename or 'upload'} is not a valid zip archive")
+    entries = []
+    for info in bundle.infolist():
+        if info.is_dir() or info.filename.startswith("__MACOSX/") or posixpath.basename(info.filename).startswith("."):
+            continue
+        if info.file_size > MAX_UPLOAD_BYTES:
+            raise HTTPException(
+                status_code=413, detail=f"{info.filename} is too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)"
+            )
+        spool = spooled_file()
+        with bundle.open(info) as member:
+            shutil.copyfileobj(member, spool, _CHUNK)
+        spool.seek(0)
+        content_type = mimetypes.guess_type(info.filename)[0] or "application/octet-stream"
+        entries.append(
+            UploadFile(file=spool, size=info.file_size, filename=info.filename, headers=Headers({"content-type": content_type}))
+        )
+    return entries
+
+
+def expand(files: List[UploadFile]) -> List[UploadFile]:
+    """files with each zip archive replaced by its entries; 400 beyond DWANI_BATCH_MAX_FILES or with none."""
+    expanded: List[UploadFile] = []
+    for file in files:
+        expanded.extend(_entries(file) if _is_zip(file) else [file])
+        if len(expanded) > BATCH_MAX_FILES:
+            raise HTTPException(status_code=400, detail=f"A batch takes at most {BATCH_MAX_FILES} files")
+    if not expanded:
+        raise HTTPException(status_code=400, detail="The batch has no files")
+    return expanded
+
+
+async def _run_one(
+    file: UploadFile,
+    mode: str,
+    agent_name: Optional[str],
+    language: Optional[str],
+    persona: Optional[Persona],
+    include_audio: bool,
+    request_id: Optional[str],
+) -> Dict[str, Any]:
+    started = time.monotonic()
+    entry: Dict[str, Any] = {"file": file.filename}
+    try:
+        async with pipeline_limiter.slot(block=True, priority="batch"):
+            turn = await converse(
+                file, mode=mode, agent_name=agent_name, request_id=request_id, language=language, persona=persona
+            )
+            tts_stream = await stream_tts(turn.reply, request_id=request_id, language=turn.language)
+            audio_bytes = await tts_stream.read()
+    except Exception as exc:
+        if not isinstance(exc, HTTPException):
+            logger.error("Batch file %s failed: %s", file.filename, exc)
+        return {**entry, "status": "failed", "error": failure(exc, language), "latency_ms": _ms(started)}
+    return {
+        **entry,
+        "status": "succeeded",
+        "transcription": turn.transcription,
+        "llm_response": turn.reply,
+        **({"detected_language": language_tag(turn.language)} if language == AUTO_LANGUAGE else {}),
+        **({"asr_confidence": turn.asr_confidence} if turn.asr_confidence is not None else {}),
+        **({"low_confidence": True} if turn.low_confidence else {}),
+        "audio_content_type": tts_stream.media_type,
+        "audio_bytes": len(audio_bytes),
+        **({"audio_base64": base64.b64encode(audio_bytes).decode("utf-8")} if include_audio else {}),
+        "latency_ms": _ms(started),
+    }
+
+
+def _ms(started: float) -> int:
+    return int((time.monotonic() - started) * 1000)
+
+
+async def run_batch(
+    files: List[UploadFile],
+    mode: str = "llm",
+    agent_name: Optional[str] = None,
+    language: Optional[str] = None,
+    persona: Optional[Persona] = None,
+    include_audio: bool = False,
+    request_id: Optional[str] = None,
+) -> Dict[str, Any]:
+    """The manifest of running each of files as one turn (see the module docstring)."""
+    gate = asyncio.Semaphore(max(1, BATCH_CONCURRENCY))
+
+    async def run(file: UploadFile) -> Dict[str, Any]:
+        async with gate:
+            return await _run_one(file, mode, agent_name, language, persona, include_audio, request_id)
+
+    started = time.monotonic()
+    results = await asyncio.gather(*(run(file) for file in files))
+    succeeded = sum(result["status"] == "succeeded" for result in results)
+    return {
+        "files": len(results),
+        "succeeded": succeeded,
+        "failed": len(results) - succeeded,
+        "latency_ms": _ms(started),
+        "results": list(results),
+    }
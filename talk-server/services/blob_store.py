@@ -0,0 +1,151 @@
+"""Reply audio delivered through object storage instead of the response body.
+
+format=url on /v1/speech_to_speech (and on jobs) writes the synthesized audio to the store named
+by DWANI_BLOB_STORE_URI and returns JSON with a signed URL to it, valid for
+DWANI_BLOB_URL_TTL_SECONDS, next to the transcripts. Clients on flaky connections fetch (and
+re-fetch) the audio on their own time, and finished jobs no longer carry it base64-encoded.
+
+- s3://bucket[/prefix]: Amazon S3, or MinIO and other S3-compatible stores with
+  DWANI_BLOB_ENDPOINT_URL; credentials come from boto3's usual chain (AWS_ACCESS_KEY_ID, ...).
+- gs://bucket[/prefix]: Google Cloud Storage with the application default credentials, which
+  must be able to sign (a service account key, or iam.serviceAccounts.signBlob).
+- "module:Class" (or module:factory): any BlobStore implementation.
+
+Objects are written as <prefix>/<YYYY-MM-DD>/<reply ID>.<format>, a fresh ID every time, so a
+client's X-Request-ID can neither overwrite nor guess another object; the request ID goes in the
+object's metadata (request-id). Expire objects with the bucket's lifecycle rules. A failed upload
+fails the request as a storage stage error.
+"""
+import asyncio
+import importlib
+import time
+from typing import Any, Dict, Optional, Tuple
+
+from config import BLOB_ENDPOINT_URL, BLOB_REGION, BLOB_STORE_URI, BLOB_URL_TTL_SECONDS, logger
+from services.audio_format import CONTENT_TYPES, detect_format
+from services.errors import StageError
+from services.ids import new_id
+
+
+class BlobStore:
+    """Where reply audio is written; subclasses implement put and signed_url, which may block (they run in a thread)."""
+
+    name = "custom"
+
+    def put(self, key: str, data: bytes, content_type: str, metadata: Optional[Dict[str, str]] = None) -> None:
+        raise NotImplementedError
+
+    def signed_url(self, key: str, expires_in: int) -> str:
+        """A URL that fetches key without credentials for expires_in seconds."""
+        raise NotImplementedError
+
+
+def _bucket_and_prefix(uri: str) -> Tuple[str, str]:
+    bucket, _, prefix = uri.split("://", 1)[1].partition("/")
+    if not bucket:
+        raise ValueError(f"DWANI_BLOB_STORE_URI {uri!r} names no bucket")
+    return bucket, prefix.strip("/")
+
+
+class S3BlobStore(BlobStore):
+    """S3, or an S3-compatible store (MinIO, R2, ...) at endpoint_url; presigned GET URLs."""
+
+    name = "s3"
+
+    def __init__(self, bucket: str, prefix: str = "", endpoint_url: Optional[str] = None, region: Optional[str] = None):
+        import boto3
+
+        self.bucket = bucket
+        self.prefix = prefix
+        self._client = boto3.client("s3", endpoint_url=endpoint_url or None, region_name=region or None)
+
+    def put(self, key: str, data: bytes, content_type: str, metadata: Optional[Dict[str, str]] = None) -> None:
+        self._client.put_object(Bucket=self.bucket, Key=key, Body=data, ContentType=content_type, Metadata=metadata or {})
+
+    def signed_url(self, key: str, expires_in: int) -> str:
+        return self._client.generate_presigned_url(
+            "get_object", Params={"Bucket": self.bucket, "Key": key}, ExpiresIn=expires_in
+        )
+
+
+class GCSBlobStore(BlobStore):
+    """Google Cloud Storage; V4 signed GET URLs."""
+
+    name = "gcs"
+
+    def __init__(self, bucket: str, prefix: str = ""):
+        from google.cloud import storage
+
+        self.prefix = prefix
+        self._bucket = storage.Client().bucket(bucket)
+
+    def put(self, key: str, data: bytes, content_type: str, metadata: Optional[Dict[str, str]] = None) -> None:
+        blob = self._bucket.blob(key)
+        blob.metadata = metadata or None
+        blob.upload_from_string(data, content_type=content_type)
+
+    def signed_url(self, key: str, expires_in: int) -> str:
+        from datetime import timedelta
+
+        return self._bucket.blob(key).generate_signed_url(version="v4", expiration=timedelta(seconds=expires_in), method="GET")
+
+
+def create_blob_store(spec: str = BLOB_STORE_URI) -> Optional[BlobStore]:
+    """The store named by spec (see the module docstring); None when spec is empty, ValueError when it is unknown."""
+    spec = (spec or "").strip()
+    if not spec:
+        return None
+    if spec.lower().startswith("s3://"):
+        return S3BlobStore(*_bucket_and_prefix(spec), endpoint_url=BLOB_ENDPOINT_URL, region=BLOB_REGION)
+    if spec.lower().startswith("gs://"):
+        return GCSBlobStore(*_bucket_and_prefix(spec))
+    module_name, _, attr = spec.partition(":")
+    if not module_name or not attr or "/" in attr:
+        raise ValueError(f"DWANI_BLOB_STORE_URI must be s3://bucket, gs://bucket, or module:Class, not {spec!r}")
+    store = getattr(importlib.import_module(module_name), attr)()
+    if not isinstance(store, BlobStore):
+        raise ValueError(f"{spec} did not produce a BlobStore")
+    return store
+
+
+_STORE: Optional[BlobStore] = None
+
+
+def blob_store() -> Optional[BlobStore]:
+    global _STORE
+    if _STORE is None and BLOB_STORE_URI:
+        _STORE = create_blob_store()
+        logger.info("Blob store for reply audio: %s", _STORE.name)
+    return _STORE
+
+
+def set_blob_store(store: Optional[BlobStore]) -> None:
+    """Use store from now on (None: recreate from DWANI_BLOB_STORE_URI on next use)."""
+    global _STORE
+    _STORE = store
+
+
+def object_key(content_type: str, prefix: str = "") -> str:
+    extension = next((fmt for fmt, media in CONTENT_TYPES.items() if media == content_type), None) or "bin"
+    name = f"{time.strftime('%Y-%m-%d', time.gmtime())}/{new_id('reply')}.{extension}"
+    return f"{prefix}/{name}" if prefix else name
+
+
+async def deliver(audio: bytes, content_type: str, request_id: Optional[str] = None) -> Dict[str, Any]:
+    """Store audio and return the response fields that point at it: audio_url and audio_url_expires_at."""
+    store = blob_store()
+    if store is None:
+        raise StageError("storage", 503, "No blob store is configured (DWANI_BLOB_STORE_URI)")
+    content_type = CONTENT_TYPES.get(detect_format(content_type, audio[:12]) or "", content_type)
+    key = object_key(content_type, getattr(store, "prefix", ""))
+    try:
+        await asyncio.to_thread(store.put, key, audio, content_type, {"request-id": request_id} if request_id else None)
+        url = await asyncio.to_thread(store.signed_url, key, BLOB_URL_TTL_SECONDS)
+    except Exception as exc:
+        logger.error("Reply audio upload to the %s blob store failed: %s", store.name, exc, extra={"request_id": request_id})
+        raise StageError("storage", 502, "Reply audio could not be stored")
+    return {
+        "audio_url": url,
+        "audio_url_expires_at": int(time.time()) + BLOB_URL_TTL_SECONDS,
+        "audio_content_type": content_type,
+    }
@@ -0,0 +1,132 @@
+"""White-label branding: the operator's own wording, or recordings, for the phrases the server speaks.
+
+DWANI_BRANDING_FILE is a JSON file of overrides for the built-in messages (services/messages.py)
+and the phone greeting, for the whole deployment ("default") and per tenant ("tenants"):
+
+    {"default": {"clarify": "Pardon me, could you say that again?",
+                 "call_error": {"hindi": "...", "*": {"text": "Acme is busy right now.", "audio": "busy.wav"}}},
+     "tenants": {"acme": {"greeting": {"*": {"text": "Welcome to Acme.", "audio": "acme/welcome.wav"}}}}}
+
+A phrase is text for every language, or language -> text ("*" for the others), where text may
+instead be {"text", "audio"}: audio is a WAV, MP3 or Ogg file (relative to the branding file)
+played instead of synthesizing the text, which stays the transcript and caption. Without "text",
+the recording replaces the built-in wording in that language. A request's tenant is the host it
+was sent to, matched in full (acme.example.com) or by its first label (acme); a tenant's phrases
+take precedence over the defaults, which take precedence over the environment's and the catalog's.
+"""
+import json
+import os
+from contextvars import ContextVar
+from dataclasses import dataclass
+from typing import Any, Collection, Dict, Optional
+
+from config import logger
+from models import language_alias
+from services.audio_format import content_type_for, detect_format
+
+ANY_LANGUAGE = "*"
+DEFAULT_TENANT = "default"
+
+_tenant: ContextVar[Optional[str]] = ContextVar("dwani_tenant", default=None)
+
+
+@dataclass(frozen=True)
+class Phrase:
+    text: Optional[str] = None  # None: the built-in wording, said by the recording
+    audio: Optional[bytes] = None
+    content_type: Optional[str] = None
+
+
+def _language(value: Optional[str]) -> str:
+    return str(language_alias(value or "")).strip().lower()
+
+
+def _phrase(spec: Any, where: str, base_dir: str) -> Phrase:
+    if isinstance(spec, str):
+        spec = {"text": spec}
+    if not isinstance(spec, dict) or not (spec.get("text") or spec.get("audio")):
+        raise ValueError(f"branding {where} must be text or an object with text and/or audio")
+    text = spec.get("text") or None
+    if text is not None and not isinstance(text, str):
+        raise ValueError(f"branding {where}: text must be a string")
+    if not spec.get("audio"):
+        return Phrase(text=text.strip())
+    with open(os.path.join(base_dir, spec["audio"]), "rb") as f:
+        audio = f.read()
+    audio_format = detect_format(None, audio[:12])
+    if audio_format is None:
+        raise ValueError(f"branding {where}: {spec['audio']} is not WAV, MP3 or Ogg audio")
+    return Phrase(text=text.strip() if text else None, audio=audio, content_type=content_type_for(audio_format))
+
+
+def _phrases(specs: Any, where: str, known: Collection[str], base_dir: str) -> Dict[str, Dict[str, Phrase]]:
+    """key -> language -> Phrase for one tenant's (or the default) section."""
+    if not isinstance(specs, dict):
+        raise ValueError(f"branding {where} must be an object of message key -> phrase")
+    phrases: Dict[str, Dict[str, Phrase]] = {}
+    for key, spec in specs.items():
+        if key not in known:
+            raise ValueError(f"branding {where}: unknown message {key!r}, expected one of {sorted(known)}")
+        if isinstance(spec, dict) and not ("text" in spec or "audio" in spec):
+            phrases[key] = {
+                ANY_LANGUAGE if language == ANY_LANGUAGE else _language(language): _phrase(value, f"{where}.{key}.{language}", base_dir)
+                for language, value in spec.items()
+            }
+        else:
+            phrases[key] = {ANY_LANGUAGE: _phrase(spec, f"{where}.{key}", base_dir)}
+    return phrases
+
+
+class Branding:
+    """Parsed DWANI_BRANDING_FILE; empty when it is not set."""
+
+    def __init__(self, tenants: Optional[Dict[str, Dict[str, Dict[str, Phrase]]]] = None):
+        self.tenants = tenants or {}
+
+    def phrase(self, key: str, language: Optional[str], tenant: Optional[str] = None) -> Optional[Phrase]:
+        """The tenant's phrase for key in language, else the default one; None when neither brands it."""
+        for name in (tenant, DEFAULT_TENANT):
+            by_language = self.tenants.get(name or "", {}).get(key)
+            if by_language:
+                found = by_language.get(_language(language)) or by_language.get(ANY_LANGUAGE)
+                if found is not None:
+                    return found
+        return None
+
+
+def load_branding(path: str, known: Collection[str]) -> Branding:
+    """The branding in path (see the module docstring) for the message keys in known; ValueError when malformed."""
+    if not path:
+        return Branding()
+    with open(path, encoding="utf-8") as f:
+        spec = json.load(f)
+    if not isinstance(spec, dict) or not set(spec) <= {DEFAULT_TENANT, "tenants"}:
+        raise ValueError(f"{path} must be an object with \"default\" and/or \"tenants\"")
+    base_dir = os.path.dirname(os.path.abspath(path))
+    tenants = {DEFAULT_TENANT: _phrases(spec.get(DEFAULT_TENANT) or {}, DEFAULT_TENANT, known, base_dir)}
+    for name, specs in (spec.get("tenants") or {}).items():
+        tenants[name.strip().lower()] = _phrases(specs, f"tenants.{name}", known, base_dir)
+    logger.info("Loaded branding for %s tenants from %s", len(tenants) - 1, path)
+    return Branding(tenants)
+
+
+def tenant_for_host(host: Optional[str], tenants: Collection[str]) -> Optional[str]:
+    """The tenant a Host header names, in full or by its first label; None for any other host."""
+    name = (host or "").strip().lower()
+    if not name or name.startswith("["):
+        return None
+    name = name.split(":", 1)[0]
+    if name in tenants and name != DEFAULT_TENANT:
+        return name
+    label = name.split(".", 1)[0]
+    return label if label in tenants and label != DEFAULT_TENANT else None
+
+
+def use_tenant(tenant: Optional[str]) -> None:
+    """Brand what is said while handling the current request (or call) for tenant."""
+    _tenant.set(tenant)
+
+
+def current_tenant() -> Optional[str]:
+    return _tenant.get()
+
@@ -0,0 +1,159 @@
+"""Hard per-turn budgets on the reply: LLM tokens and TTS characters.
+
+DWANI_TURN_MAX_LLM_TOKENS caps max_tokens for the turn's LLM call, whatever the request
+asks for; a reply the cap cut off is trimmed back to its last complete sentence.
+DWANI_TURN_MAX_TTS_CHARS caps the text sent to TTS: an over-long reply is shortened by the
+LLM (unless DWANI_BUDGET_SHORTEN=0), and cut at a sentence boundary if it still does not fit.
+Text spoken as it was written or summarized (image_to_speech, spoken meeting minutes) is only
+cut, since shortening would reword it. Embedded pipelines set their own budgets in PipelineConfig. Each hit is counted in
+dwani_budget_hits_total and reported on the turn.
+
+Requests with a deadline (services/deadline.py) also have a latency budget, so the user gets a
+short answer rather than a 504 when ASR was slow. Before the LLM is called, the time left is what
+remains of the deadline less DWANI_LATENCY_TTS_RESERVE_MS for TTS. max_tokens is lowered to what
+the LLM produces in that time at DWANI_LATENCY_LLM_TOKENS_PER_SECOND, and below
+DWANI_LATENCY_SKIP_BELOW_MS the optional stages are skipped: retrieval, the loop breaker's retry,
+and shortening a long reply with the LLM (it is cut at a sentence instead). Such a turn reports
+the "latency" budget hit.
+"""
+import re
+from typing import Optional, Tuple
+
+from prometheus_client import Counter
+
+from config import (
+    BUDGET_SHORTEN,
+    LATENCY_DEGRADE,
+    LATENCY_LLM_TOKENS_PER_SECOND,
+    LATENCY_MIN_TOKENS,
+    LATENCY_SKIP_BELOW_MS,
+    LATENCY_TTS_RESERVE_MS,
+    LLM_MAX_TOKENS,
+    logger,
+)
+from models import GenerationParams
+from services import backends
+from services.chat_svc import call_llm
+from services.deadline import remaining
+from services.errors import StageError
+from services.prompts import language_instruction
+
+_HITS = Counter("dwani_budget_hits_total", "Replies over a per-turn budget", ["budget", "action"])
+# Sentence-final punctuation (including the Devanagari danda) followed by a space or the end.
+_SENTENCE_END = re.compile(r"[.!?।॥…](?=\s|$)")
+_SHORTEN_PROMPT = (
+    "Shorten the user's text to at most {limit} characters for a voice assistant to say. "
+    "Keep its meaning and language. Reply with the shortened text only, with no quotes or labels."
+)
+
+
+def llm_generation(generation: Optional[GenerationParams]) -> Tuple[Optional[GenerationParams], bool]:
+    """generation with max_tokens held to the turn's budget, and whether the budget lowered it."""
+    budget = backends.max_llm_tokens()
+    requested = (generation.max_tokens if generation else None) or LLM_MAX_TOKENS
+    if not budget or requested <= budget:
+        return generation, False
+    return (generation or GenerationParams()).model_copy(update={"max_tokens": budget}), True
+
+
+def llm_time_left() -> Optional[float]:
+    """Seconds the LLM may take with TTS's share of the deadline kept back; None without a deadline or degradation."""
+    left = remaining()
+    if left is None or not LATENCY_DEGRADE:
+        return None
+    return left - LATENCY_TTS_RESERVE_MS / 1000
+
+
+def hurried() -> bool:
+    """Whether the deadline is too close for optional stages."""
+    left = llm_time_left()
+    return left is not None and left * 1000 < LATENCY_SKIP_BELOW_MS
+
+
+def skip_optional(stage: str) -> bool:
+    """Whether to skip an optional stage (retrieval, loop_retry, shortening) for the deadline; counted when so."""
+    if not hurried():
+        return False
+    left = llm_time_left() or 0.0
+    _HITS.labels(budget="latency", action=f"skipped_{stage}").inc()
+    logger.info("Skipping %s: %.0f ms left for the LLM before the deadline", stage, max(0.0, left * 1000))
+    return True
+
+
+def latency_generation(generation: Optional[GenerationParams]) -> Tuple[Optional[GenerationParams], bool]:
+    """generation with max_tokens lowered to what the LLM can produce before the deadline, and whether it was."""
+    left = llm_time_left()
+    if left is None or LATENCY_LLM_TOKENS_PER_SECOND <= 0:
+        return generation, False
+    fits = max(LATENCY_MIN_TOKENS, int(left * LATENCY_LLM_TOKENS_PER_SECOND))
+    requested = (generation.max_tokens if generation else None) or LLM_MAX_TOKENS
+    if requested <= fits:
+        return generation, False
+    _HITS.labels(budget="latency", action="max_tokens").inc()
+    return (generation or GenerationParams()).model_copy(update={"max_tokens": fits}), True
+
+
+def complete_sentences(text: str) -> str:
+    """text up to its last sentence end; all of it when it has none."""
+    ends = [m.end() for m in _SENTENCE_END.finditer(text)]
+    return text[:ends[-1]].strip() if ends else text
+
+
+def truncate_at_sentence(text: str, limit: int) -> str:
+    """At most limit characters of text, cut after a sentence, else a word (marked with …)."""
+    if len(text) <= limit:
+        return text
+    ends = [m.end() for m in _SENTENCE_END.finditer(text[:limit])]
+    if ends:
+        return text[:ends[-1]].strip()
+    cut = text[:max(limit - 1, 1)]
+    if " " in cut:
+        cut = cut.rsplit(" ", 1)[0]
+    return cut.rstrip(" ,;:-") + "…"
+
+
+def cut_to_tts_budget(text: str) -> Tuple[str, bool]:
+    """text cut at a sentence boundary to the TTS character budget, without the LLM; (text, budget hit)."""
+    limit = backends.max_tts_chars()
+    if not limit or len(text) <= limit:
+        return text, False
+    _HITS.labels(budget="tts_chars", action="truncated").inc()
+    return truncate_at_sentence(text, limit), True
+
+
+def trim_llm_reply(reply: str, capped: bool, finish_reason: Optional[str]) -> Tuple[str, bool]:
+    """reply without the unfinished sentence the token budget cut it at; (reply, budget hit)."""
+    if not capped or finish_reason != "length":
+        return reply, False
+    _HITS.labels(budget="llm_tokens", action="truncated").inc()
+    return complete_sentences(reply), True
+
+
+async def fit_tts_budget(
+    text: str,
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+    max_chars: int = 0,
+) -> Tuple[str, bool]:
+    """text within the turn's TTS character budget, or max_chars when lower; (text, budget hit)."""
+    limit = min((n for n in (backends.max_tts_chars(), max_chars) if n), default=0)
+    if not limit or len(text) <= limit:
+        return text, False
+    if BUDGET_SHORTEN and not skip_optional("shortening"):
+        try:
+            shorter = await call_llm(
+                text,
+                request_id=request_id,
+                language=language,
+                generation=GenerationParams(temperature=0, max_tokens=backends.max_llm_tokens() or LLM_MAX_TOKENS),
+                system_prompt=f"{_SHORTEN_PROMPT.format(limit=limit)} {language_instruction(language)}".strip(),
+            )
+        except StageError as exc:
+            logger.warning("Could not shorten an over-budget reply: %s", exc.detail)
+        else:
+            if len(shorter) <= limit:
+                _HITS.labels(budget="tts_chars", action="shortened").inc()
+                return shorter, True
+            text = shorter
+    _HITS.labels(budget="tts_chars", action="truncated").inc()
+    return truncate_at_sentence(text, limit), True
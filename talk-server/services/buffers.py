@@ -0,0 +1,53 @@
+"""Reusable byte buffers for streaming uploads to upstreams."""
+import threading
+from contextlib import contextmanager
+from typing import Iterator, List
+
+from prometheus_client import Counter, Gauge
+
+_POOL_GETS = Counter("dwani_buffer_pool_gets_total", "Buffers handed out by the pool", ["pool"])
+_POOL_ALLOCS = Counter("dwani_buffer_pool_allocations_total", "Buffers allocated because the pool was empty", ["pool"])
+_POOL_IDLE = Gauge("dwani_buffer_pool_idle", "Buffers currently idle in the pool", ["pool"])
+
+
+class BufferPool:
+    """Bounded free list of equally sized bytearrays.
+
+    Buffers beyond max_idle are dropped on release so bursts don't pin memory forever.
+    """
+
+    def __init__(self, name: str, size: int, max_idle: int = 32):
+        self.name = name
+        self.size = size
+        self.max_idle = max_idle
+        self._free: List[bytearray] = []
+        self._lock = threading.Lock()
+        _POOL_IDLE.labels(pool=name).set(0)
+
+    def get(self) -> bytearray:
+        _POOL_GETS.labels(pool=self.name).inc()
+        with self._lock:
+            if self._free:
+                buf = self._free.pop()
+                _POOL_IDLE.labels(pool=self.name).set(len(self._free))
+                return buf
+        _POOL_ALLOCS.labels(pool=self.name).inc()
+        return bytearray(self.size)
+
+    def put(self, buf: bytearray) -> None:
+        if len(buf) != self.size:
+            return
+        with self._lock:
+            if len(self._free) < self.max_idle:
+                self._free.append(buf)
+            _POOL_IDLE.labels(pool=self.name).set(len(self._free))
+
+    @contextmanager
+    def buffer(self) -> Iterator[memoryview]:
+        buf = self.get()
+        view = memoryview(buf)
+        try:
+            yield view
+        finally:
+            view.release()
+            self.put(buf)
@@ -0,0 +1,86 @@
+"""Which build is running, for GET /version and bug reports.
+
+The commit and build date are baked into the image at build time (the Dockerfile's GIT_COMMIT
+and BUILD_DATE arguments become DWANI_BUILD_COMMIT and DWANI_BUILD_DATE). Run from a git
+checkout without them, the commit is read from git once; otherwise both are "unknown".
+Features lists the optional parts this worker's configuration turns on, by name.
+"""
+import functools
+import os
+import platform
+import subprocess
+from typing import Any, Dict, List
+
+from config import (
+    AUDIO_METADATA,
+    CHAOS_MODE,
+    DEBUG_ENDPOINTS,
+    DEMO_MODE,
+    DISCORD_CHANNEL_ID,
+    MOCK_BACKENDS,
+    MODERATION_URL,
+    OFFLINE_ASR_MODEL,
+    OFFLINE_TTS_VOICES,
+    PII_REDACT,
+    RECORD_ARTIFACTS,
+    SESSION_STORE,
+    SHADOW_ASR_URL,
+    SHADOW_TTS_URL,
+    STORE_CONVERSATIONS,
+    TELEGRAM_POLLING,
+    TTS_SSML,
+    WARMUP,
+)
+
+VERSION = "1.0.0"
+
+
+@functools.lru_cache(maxsize=1)
+def commit() -> str:
+    baked = os.getenv("DWANI_BUILD_COMMIT", "").strip()
+    if baked:
+        return baked
+    try:
+        found = subprocess.run(
+            ["git", "rev-parse", "HEAD"],
+            cwd=os.path.dirname(os.path.abspath(__file__)),
+            capture_output=True,
+            text=True,
+            timeout=2,
+        )
+    except (OSError, subprocess.SubprocessError):
+        return "unknown"
+    return found.stdout.strip() if found.returncode == 0 else "unknown"
+
+
+def features() -> List[str]:
+    enabled = {
+        "audio_metadata": AUDIO_METADATA,
+        "chaos": CHAOS_MODE,
+        "debug_endpoints": DEBUG_ENDPOINTS,
+        "demo_mode": DEMO_MODE,
+        "discord_voice": bool(DISCORD_CHANNEL_ID),
+        "mock_backends": MOCK_BACKENDS,
+        "moderation": bool(MODERATION_URL),
+        "offline_asr": bool(OFFLINE_ASR_MODEL),
+        "offline_tts": bool(OFFLINE_TTS_VOICES),
+        "pii_redaction": PII_REDACT,
+        "recordings": RECORD_ARTIFACTS,
+        "shadow_traffic": bool(SHADOW_ASR_URL or SHADOW_TTS_URL),
+        "ssml": TTS_SSML,
+        "stored_conversations": STORE_CONVERSATIONS,
+        "telegram_polling": TELEGRAM_POLLING,
+        "warmup": WARMUP,
+        f"sessions_{SESSION_STORE or 'memory'}": True,
+    }
+    return sorted(name for name, on in enabled.items() if on)
+
+
+def build_info() -> Dict[str, Any]:
+    return {
+        "version": VERSION,
+        "commit": commit(),
+        "build_date": os.getenv("DWANI_BUILD_DATE", "").strip() or "unknown",
+        "python": platform.python_version(),
+        "features": features(),
+    }
@@ -0,0 +1,133 @@
+"""Caption cues and word timings for reply audio, so clients can show subtitles in step with
+the speech and highlight each word as it is spoken.
+
+The reply is split at sentence (then word) boundaries into cues of at most
+DWANI_CAPTION_MAX_CHARS. When the TTS backend sends word timing marks (a JSON list in the
+DWANI_TTS_TIMESTAMPS_HEADER response header) they time the words and cues as given.
+Otherwise every word gets a share of the audio's duration in proportion to its length: the
+duration is measured from the audio when all of it is at hand (WAV or MP3), else estimated
+at DWANI_CAPTION_CHARS_PER_SECOND. The cues can also be written out as an SRT or WebVTT file.
+"""
+import json
+from dataclasses import asdict, dataclass
+from typing import Any, Dict, List, Optional, Sequence, Tuple
+
+from config import CAPTION_CHARS_PER_SECOND, CAPTION_MAX_CHARS, TTS_TIMESTAMPS_HEADER, logger
+from services.audio_format import mp3_duration
+from services.latency import wav_duration
+from services.read_aloud import chunk_sentences
+
+
+SUBTITLE_FORMATS = ("srt", "vtt")
+
+
+@dataclass(frozen=True)
+class WordTiming:
+    word: str
+    start_seconds: float
+    end_seconds: float
+
+
+@dataclass(frozen=True)
+class CaptionCue:
+    index: int
+    start_seconds: float
+    end_seconds: float
+    text: str
+    words: Tuple[WordTiming, ...] = ()
+
+    def as_dict(self) -> Dict[str, Any]:
+        return asdict(self)
+
+
+def audio_duration(audio: bytes) -> Optional[float]:
+    """Length of WAV or MP3 audio in seconds; None for other formats."""
+    return wav_duration(audio[:4096]) or mp3_duration(audio)
+
+
+def estimate_duration(text: str) -> float:
+    return len(text) / CAPTION_CHARS_PER_SECOND
+
+
+def tts_word_timings(tts_stream: Any, text: str) -> Optional[List[WordTiming]]:
+    """The word marks a TTSStream's backend sent for text, if any and one per word."""
+    timings = parse_word_timings(tts_stream.header(TTS_TIMESTAMPS_HEADER))
+    if timings and len(timings) != len(text.split()):
+        logger.warning("TTS word timestamps cover %s words, the reply has %s; estimating instead", len(timings), len(text.split()))
+        return None
+    return timings
+
+
+def parse_word_timings(value: Optional[str]) -> Optional[List[WordTiming]]:
+    """Marks from a TTS backend: [{"word", "start", "end"}, ...] in seconds; None if absent or invalid."""
+    if not value:
+        return None
+    try:
+        marks = json.loads(value)
+        timings = [
+            WordTiming(
+                str(mark.get("word", mark.get("text"))),
+                float(mark.get("start", mark.get("start_seconds"))),
+                float(mark.get("end", mark.get("end_seconds"))),
+            )
+            for mark in marks
+        ]
+    except (ValueError, TypeError, AttributeError):
+        logger.warning("Ignoring malformed TTS word timestamps")
+        return None
+    return timings or None
+
+
+def _estimated_words(words: Sequence[str], start: float, end: float) -> List[WordTiming]:
+    # A space's worth of weight per word, so short words still get a visible highlight.
+    total = sum(len(word) + 1 for word in words)
+    timings, position = [], 0
+    for word in words:
+        word_start = start + (end - start) * position / total
+        position += len(word) + 1
+        timings.append(WordTiming(word, round(word_start, 3), round(start + (end - start) * position / total, 3)))
+    return timings
+
+
+def caption_cues(
+    text: str, duration_seconds: Optional[float] = None, word_timings: Optional[Sequence[WordTiming]] = None
+) -> List[CaptionCue]:
+    """Cues covering text with per-word timings: word_timings when they match the reply's words,
+    else spread over duration_seconds (estimated from the text when None)."""
+    pieces = [piece for paragraph in text.split("\n") for piece in chunk_sentences(paragraph, CAPTION_MAX_CHARS)]
+    piece_words = [piece.split() for piece in pieces]
+    count = sum(len(words) for words in piece_words)
+    if not count:
+        return []
+    if not word_timings or len(word_timings) != count:
+        duration = duration_seconds if duration_seconds else estimate_duration(text)
+        word_timings = _estimated_words([word for words in piece_words for word in words], 0.0, duration)
+    cues, offset = [], 0
+    for index, (piece, words) in enumerate(zip(pieces, piece_words)):
+        timed = tuple(word_timings[offset:offset + len(words)])
+        offset += len(words)
+        cues.append(CaptionCue(index, timed[0].start_seconds, timed[-1].end_seconds, piece, timed))
+    return cues
+
+
+def _timestamp(seconds: float, separator: str) -> str:
+    millis = int(round(max(0.0, seconds) * 1000))
+    hours, millis = divmod(millis, 3_600_000)
+    minutes, millis = divmod(millis, 60_000)
+    secs, millis = divmod(millis, 1000)
+    return f"{hours:02d}:{minutes:02d}:{secs:02d}{separator}{millis:03d}"
+
+
+def subtitles(cues: Sequence[CaptionCue], subtitle_format: str) -> str:
+    """The cues as an SRT ("srt") or WebVTT ("vtt") file."""
+    if subtitle_format == "vtt":
+        blocks = ["WEBVTT"]
+        for cue in cues:
+            text = cue.text.replace("&", "&amp;").replace("<", "&lt;").replace(">", "&gt;")
+            blocks.append(f"{_timestamp(cue.start_seconds, '.')} --> {_timestamp(cue.end_seconds, '.')}\n{text}")
+    else:
+        blocks = [
+            f"{cue.index + 1}\n{_timestamp(cue.start_seconds, ',')} --> {_timestamp(cue.end_seconds, ',')}\n{cue.text}"
+            for cue in cues
+        ]
+    return "\n\n".join(blocks) + "\n"
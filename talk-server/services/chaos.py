@@ -0,0 +1,112 @@
+"""Fault injection, so client apps can be tested against the pipeline's real failure modes.
+
+With DWANI_CHAOS_MODE=1, pipeline hooks (services/hooks.py) in front of the ASR, LLM and TTS
+stages add DWANI_CHAOS_LATENCY_MS of latency with the stage's DWANI_CHAOS_LATENCY_RATE
+probability, and fail the stage with the stage's DWANI_CHAOS_ERROR_RATE probability: a
+DWANI_CHAOS_ERROR_STATUS stage error with error.details.injected set, shaped like a real
+backend failure. With DWANI_CHAOS_TRUNCATE_RATE probability the TTS audio is cut off
+part-way, as when a backend drops the connection. Set DWANI_CHAOS_SEED for repeatable runs.
+
+Faults hit every transcription, turn and synthesis of the worker; use a dedicated test server.
+"""
+import asyncio
+import random
+from typing import Dict, Optional
+
+from prometheus_client import Counter
+
+from config import (
+    CHAOS_ERROR_RATE,
+    CHAOS_ERROR_STATUS,
+    CHAOS_LATENCY_MS,
+    CHAOS_LATENCY_RATE,
+    CHAOS_MODE,
+    CHAOS_SEED,
+    CHAOS_TRUNCATE_RATE,
+    logger,
+)
+from services.errors import StageError
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.tts import TTSStream
+
+_FAULTS = Counter("dwani_chaos_faults_total", "Faults injected by chaos mode", ["stage", "fault"])
+
+
+class ChaosMonkey:
+    def __init__(
+        self,
+        latency_rate: Optional[Dict[str, float]] = None,
+        error_rate: Optional[Dict[str, float]] = None,
+        latency_ms: int = CHAOS_LATENCY_MS,
+        error_status: int = CHAOS_ERROR_STATUS,
+        truncate_rate: float = CHAOS_TRUNCATE_RATE,
+        seed: Optional[str] = CHAOS_SEED or None,
+    ):
+        self.latency_rate = CHAOS_LATENCY_RATE if latency_rate is None else latency_rate
+        self.error_rate = CHAOS_ERROR_RATE if error_rate is None else error_rate
+        self.latency_ms = latency_ms
+        self.error_status = error_status
+        self.truncate_rate = truncate_rate
+        self.random = random.Random(seed)
+
+    def _roll(self, probability: float) -> bool:
+        return probability > 0 and self.random.random() < probability
+
+    async def before(self, stage: str) -> None:
+        """Delay and/or fail stage, each with its configured probability."""
+        if self._roll(self.latency_rate.get(stage, 0.0)):
+            _FAULTS.labels(stage=stage, fault="latency").inc()
+            await asyncio.sleep(self.latency_ms / 1000)
+        if self._roll(self.error_rate.get(stage, 0.0)):
+            _FAULTS.labels(stage=stage, fault="error").inc()
+            raise StageError(
+                stage,
+                status_code=self.error_status,
+                detail=f"Injected {stage} failure (chaos mode)",
+                headers={"Retry-After": "1"} if self.error_status == 503 else None,
+                details={"injected": True},
+            )
+
+    async def truncate(self, stream: TTSStream) -> Optional[TTSStream]:
+        """The stream's audio cut off between 10% and 90% of the way through, or None to leave it."""
+        if not self._roll(self.truncate_rate):
+            return None
+        _FAULTS.labels(stage="tts", fault="truncated").inc()
+        content_type = stream.content_type or "audio/mpeg"
+        audio = await stream.read()
+        return TTSStream.cached(content_type, audio[: int(len(audio) * self.random.uniform(0.1, 0.9))])
+
+
+monkey = ChaosMonkey()
+
+
+def _stage_hook(stage: str):
+    async def hook(ctx: HookContext, value) -> None:
+        await monkey.before(stage)
+    hook.__name__ = f"chaos_{stage}"
+    return hook
+
+
+async def _truncate_tts(ctx: HookContext, stream: TTSStream) -> Optional[TTSStream]:
+    return await monkey.truncate(stream)
+
+
+_HOOKS = {
+    "before_asr": _stage_hook("asr"),
+    "before_llm": _stage_hook("llm"),
+    "before_tts": _stage_hook("tts"),
+    "after_tts": _truncate_tts,
+}
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the fault hooks when DWANI_CHAOS_MODE is on."""
+    if not CHAOS_MODE or _truncate_tts in registry.registered("after_tts"):
+        return False
+    for point, hook in _HOOKS.items():
+        registry.register(point, hook)
+    logger.warning(
+        "Chaos mode: injecting latency %s, errors %s, truncated TTS audio %.0f%%",
+        monkey.latency_rate, monkey.error_rate, monkey.truncate_rate * 100,
+    )
+    return True
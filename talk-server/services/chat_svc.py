@@ -1,56 +1,230 @@
-import os
+import asyncio
+import time
+from contextvars import ContextVar
 from typing import Any, Dict, List, Optional
 
 import httpx
 from fastapi import HTTPException
 from openai import AsyncOpenAI
+from openai import APIConnectionError as OpenAIConnectionError
 from openai import APIError as OpenAIAPIError
+from openai import APIStatusError as OpenAIStatusError
+from openai import APITimeoutError as OpenAITimeoutError
 
-from config import AGENT_BASE_URL, LLM_MODEL, LLM_TIMEOUT, logger
+from config import (
+    GARBAGE_RETRIES,
+    LLM_EMPTY_RETRIES,
+    LLM_HEDGE,
+    LLM_HEDGE_DELAY_MS,
+    LLM_MAX_TOKENS,
+    LLM_TEMPERATURE,
+    LLM_TIMEOUT,
+    LLM_TOOL_ROUNDS,
+    LLM_TOP_P,
+    logger,
+)
+from models import GenerationParams
+from services import backends
+from services.deadline import StageTimeout, stage_timeout
+from services.errors import StageError
+from services.experiments import tag_llm
+from services.hooks import current_context
+from services.http_client import http_client
+from services.ids import current_request_id
+from services.llm_health import FALLBACKS, llm_health
+from services.llm_variants import observe as observe_variant
+from services.llm_variants import use_variant
+from services.prompts import build_system_prompt
 from services.retry import retry_async
+from services.sanity import echoes_prompt, garbage
+from services.tools import in_conversation, offered, run_tool_call
+from services.usage import record_usage
+
+# Added to the system prompt when the LLM is asked again after an empty reply.
+EMPTY_NUDGE = "Your previous answer was empty. Reply to the user's last message in plain text."
+
+# finish_reason of the task's latest LLM completion ("length" when max_tokens cut it off).
+_finish_reason: ContextVar[Optional[str]] = ContextVar("dwani_llm_finish_reason", default=None)
+
+
+def _llm_failover(exc: BaseException) -> bool:
+    """Unreachable instance or server error: worth trying another LLM instance."""
+    if isinstance(exc, OpenAIStatusError):
+        return exc.status_code >= 500
+    return isinstance(exc, OpenAIConnectionError) and not isinstance(exc, OpenAITimeoutError)
 
 
 async def call_llm(
     user_text: str,
     context: Optional[List[Dict[str, str]]] = None,
     request_id: Optional[str] = None,
+    language: Optional[str] = None,
+    generation: Optional[GenerationParams] = None,
+    system_prompt: Optional[str] = None,
 ) -> str:
-    """Send text to OpenAI-compatible LLM with optional conversation context."""
-    base_url = os.getenv("DWANI_API_BASE_URL_LLM", "").rstrip("/")
-    if not base_url:
-        raise ValueError("DWANI_API_BASE_URL_LLM is not set")
-    api_base = f"{base_url}/v1" if not base_url.endswith("/v1") else base_url
+    """Send text to OpenAI-compatible LLM with optional conversation context.
+
+    When language is given, the system prompt instructs the model to reply in it.
+    generation overrides the configured model and sampling parameters; system_prompt
+    replaces the conversational prompt for non-chat tasks (e.g. punctuation restoration).
+    Conversational calls (no system_prompt, or inside tools.conversation()) may use the
+    DWANI_LLM_TOOLS tools before replying (services/tools.py). Without a model in generation,
+    DWANI_LLM_VARIANTS picks one by session (services/llm_variants.py).
+    """
+    _finish_reason.set(None)
+    if not backends.llm_base_url():
+        logger.error("DWANI_API_BASE_URL_LLM is not set")
+        raise StageError("llm", detail="LLM service base URL is not configured")
     messages = [
-        {"role": "system", "content": "You must respond in at most one line. Keep your reply to a single short sentence. Maintain conversation context when given previous messages."},
+        {"role": "system", "content": system_prompt or build_system_prompt(language)},
     ]
     if context:
         messages.extend(context)
     messages.append({"role": "user", "content": user_text})
-    try:
-        llm_api_key = os.getenv("DWANI_LLM_API_KEY", "dummy")
-        client = AsyncOpenAI(base_url=api_base, api_key=llm_api_key, timeout=httpx.Timeout(LLM_TIMEOUT))
-        response = await client.chat.completions.create(
-            model=LLM_MODEL,
-            messages=messages,
-            max_tokens=256,
-            extra_headers={"X-Request-ID": request_id} if request_id else None,
-            extra_body={"chat_template_kwargs": {"enable_thinking": False}},
-        )
-    except OpenAIAPIError as e:
-        logger.error(f"LLM API error: {e}")
-        raise HTTPException(status_code=502, detail=f"LLM error: {str(e)}")
-    except Exception as e:
-        logger.error(f"LLM request failed: {e}")
-        raise HTTPException(status_code=502, detail=f"LLM error: {str(e)}")
-    if not response.choices:
-        raise HTTPException(status_code=502, detail="LLM returned no choices")
-    msg = response.choices[0].message
-    content = getattr(msg, "content", None) or None
-    if not content or not str(content).strip():
-        content = getattr(msg, "reasoning", None) or getattr(msg, "reasoning_content", None)
-    if not content or not str(content).strip():
-        raise HTTPException(status_code=502, detail="LLM returned empty response")
-    return " ".join(str(content).strip().split())
+    generation = generation or GenerationParams()
+    sampling: Dict[str, Any] = {}
+    temperature = generation.temperature if generation.temperature is not None else LLM_TEMPERATURE
+    top_p = generation.top_p if generation.top_p is not None else LLM_TOP_P
+    if temperature is not None:
+        sampling["temperature"] = temperature
+    if top_p is not None:
+        sampling["top_p"] = top_p
+    tool_specs = offered() if system_prompt is None or in_conversation() else []
+    # Tools offered in the next completion; the last round offers none, so the reply is text.
+    offering: List[Dict[str, Any]] = []
+
+    def _complete_with(model: str, timeout: float):
+        async def _complete(base_url: str):
+            client = AsyncOpenAI(
+                base_url=f"{base_url}/v1" if not base_url.endswith("/v1") else base_url,
+                api_key=backends.llm_api_key(),
+                http_client=http_client(),
+            )
+            response = await client.chat.completions.create(
+                model=model,
+                messages=messages,
+                max_tokens=generation.max_tokens or LLM_MAX_TOKENS,
+                **sampling,
+                **({"tools": offering} if offering else {}),
+                extra_headers={"X-Request-ID": request_id} if request_id else None,
+                extra_body={"chat_template_kwargs": {"enable_thinking": False}},
+                timeout=stage_timeout("llm", timeout),
+            )
+            tag_llm(base_url, model)
+            return response
+
+        return _complete
+
+    async def _completion(model: str, timeout: float):
+        try:
+            llm = backends.llm_pool()
+            if LLM_HEDGE:
+                return await llm.hedged(_complete_with(model, timeout), LLM_HEDGE_DELAY_MS / 1000, failed_error=_llm_failover)
+            return await llm.call(_complete_with(model, timeout), failed_error=_llm_failover)
+        except HTTPException:
+            # StageTimeout, or PipelineBusy when the LLM stage has no free slot.
+            raise
+        except OpenAITimeoutError as e:
+            logger.error(f"LLM request timed out: {e}")
+            raise StageTimeout("llm")
+        except OpenAIAPIError as e:
+            logger.error(f"LLM API error: {e}")
+            raise StageError("llm")
+        except Exception as e:
+            logger.error(f"LLM request failed: {e}")
+            raise StageError("llm")
+
+    async def _healthy_completion():
+        """A completion from the LLM, or from the fallback while the LLM is slow (services/llm_health.py)."""
+        base_url = backends.llm_base_url()
+        fallback_url = backends.llm_fallback_base_url()
+        if not fallback_url or base_url == fallback_url:
+            return await _completion(generation.model or backends.llm_model(), LLM_TIMEOUT)
+        health = llm_health(base_url)
+        if health.degraded():
+            FALLBACKS.labels("slow").inc()
+        else:
+            timeout, started = health.timeout(), time.monotonic()
+            try:
+                response = await _completion(generation.model or backends.llm_model(), timeout)
+            except StageTimeout:
+                health.observe(time.monotonic() - started)
+                FALLBACKS.labels("timeout").inc()
+                logger.warning("LLM gave no reply within %.1fs; trying the fallback", timeout)
+            else:
+                health.observe(time.monotonic() - started)
+                return response
+        with backends.use_llm_fallback():
+            return await _completion(backends.llm_model(), LLM_TIMEOUT)
+
+    async def _reply() -> str:
+        nonlocal offering
+        for tool_round in range(LLM_TOOL_ROUNDS + 1):
+            offering = tool_specs if tool_round < LLM_TOOL_ROUNDS else []
+            response = await _healthy_completion()
+            tokens = getattr(response, "usage", None)
+            if tokens is not None:
+                record_usage(
+                    llm_prompt_tokens=getattr(tokens, "prompt_tokens", 0) or 0,
+                    llm_completion_tokens=getattr(tokens, "completion_tokens", 0) or 0,
+                )
+            if not response.choices:
+                raise StageError("llm", detail="LLM returned no choices")
+            _finish_reason.set(getattr(response.choices[0], "finish_reason", None))
+            msg = response.choices[0].message
+            calls = getattr(msg, "tool_calls", None) if offering else None
+            if not calls:
+                break
+            messages.append({
+                "role": "assistant",
+                "content": getattr(msg, "content", None) or None,
+                "tool_calls": [
+                    {"id": c.id, "type": "function", "function": {"name": c.function.name, "arguments": c.function.arguments}}
+                    for c in calls
+                ],
+            })
+            messages.extend(await asyncio.gather(*(run_tool_call(c) for c in calls)))
+        content = getattr(msg, "content", None) or None
+        if not content or not str(content).strip():
+            content = getattr(msg, "reasoning", None) or getattr(msg, "reasoning_content", None)
+        if not content or not str(content).strip():
+            raise garbage("llm", "empty", "LLM returned empty response")
+        return " ".join(str(content).strip().split())
+
+    async def _answered() -> str:
+        """_reply(), asked again up to DWANI_LLM_EMPTY_RETRIES times, nudged to answer, while it comes back empty."""
+        system = messages[0]["content"]
+        for attempt in range(LLM_EMPTY_RETRIES + 1):
+            try:
+                return await _reply()
+            except StageError as exc:
+                if exc.details.get("reason") != "empty" or attempt == LLM_EMPTY_RETRIES:
+                    raise
+                logger.warning("LLM returned an empty reply; asking again (attempt %s)", attempt + 1)
+                messages[0] = {"role": "system", "content": f"{system} {EMPTY_NUDGE}"}
+        raise AssertionError("unreachable")
+
+    key = current_context(request_id).session_id or request_id or current_request_id()
+    with use_variant(key, generation.model) as variant:
+        started, outcome = time.monotonic(), "error"
+        try:
+            for attempt in range(GARBAGE_RETRIES + 1):
+                reply = await _answered()
+                # Only conversational replies: tasks with their own prompt (punctuation, translation) may return the input.
+                if system_prompt is not None or not echoes_prompt(reply, user_text, messages[0]["content"]):
+                    outcome = "truncated" if _finish_reason.get() == "length" else "ok"
+                    return reply
+                logger.warning("LLM repeated the prompt instead of replying (attempt %s)", attempt + 1)
+            outcome = "echo"
+            raise garbage("llm", "echo", "LLM repeated the prompt instead of replying")
+        finally:
+            if variant is not None:
+                observe_variant(variant, time.monotonic() - started, outcome)
+
+
+def last_finish_reason() -> Optional[str]:
+    """finish_reason of the last call_llm in this task, if it got a completion."""
+    return _finish_reason.get()
 
 
 async def call_agent(
@@ -60,41 +234,49 @@ async def call_agent(
     request_id: Optional[str] = None,
 ) -> Dict[str, Any]:
     """Send text to agents service. Returns reply and optional state payloads."""
-    if not AGENT_BASE_URL:
-        raise HTTPException(status_code=502, detail="Agent service base URL is not configured")
+    agent_base = backends.agent_base_url()
+    if not agent_base:
+        raise StageError("agent", detail="Agent service base URL is not configured")
     if not session_id:
         raise HTTPException(status_code=400, detail="Agent mode requires a session_id")
 
-    url = f"{AGENT_BASE_URL}/v1/agents/{agent_name}/chat"
+    url = f"{agent_base}/v1/agents/{agent_name}/chat"
     payload = {"session_id": session_id, "message": user_text}
-    agents_api_key = os.getenv("AGENTS_API_KEY", "").strip()
-    headers = {"Content-Type": "application/json"}
-    if agents_api_key:
-        headers["X-API-Key"] = agents_api_key
+    headers = {"Content-Type": "application/json", **backends.auth_headers("agent")}
     if request_id:
         headers["X-Request-ID"] = request_id
 
     async def _do():
-        async with httpx.AsyncClient(timeout=LLM_TIMEOUT) as client:
-            return await client.post(url, json=payload, headers=headers)
+        return await http_client().post(
+            url, json=payload, headers=headers, timeout=stage_timeout("agent", LLM_TIMEOUT)
+        )
 
     try:
         resp = await retry_async(_do)
+    except HTTPException:
+        raise
+    except httpx.TimeoutException:
+        logger.error("Agent service request timed out")
+        raise StageTimeout("agent")
     except Exception as e:
         logger.error(f"Agent service request failed: {e}")
-        raise HTTPException(status_code=502, detail=f"Agent service error: {str(e)}")
+        raise StageError("agent")
 
     if resp.status_code != 200:
         logger.error(f"Agent service returned {resp.status_code}: {resp.text}")
-        raise HTTPException(status_code=502, detail="Agent service returned an error")
+        raise StageError("agent", detail="Agent service returned an error")
 
     data = resp.json()
     reply = data.get("reply")
     if not reply or not str(reply).strip():
-        raise HTTPException(status_code=502, detail="Agent returned empty response")
+        raise StageError("agent", detail="Agent returned empty response")
     result: Dict[str, Any] = {"reply": " ".join(str(reply).strip().split())}
     if data.get("warehouse_state") is not None and isinstance(data["warehouse_state"], dict):
         result["warehouse_state"] = data["warehouse_state"]
     if data.get("chess_state") is not None and isinstance(data["chess_state"], dict):
         result["chess_state"] = data["chess_state"]
+    if isinstance(data.get("intent"), dict):
+        result["intent"] = data["intent"]
+    if isinstance(data.get("slots"), dict):
+        result["slots"] = data["slots"]
     return result
@@ -0,0 +1,72 @@
+"""gzip for JSON responses, for clients on slow mobile links.
+
+A JSON response of at least DWANI_COMPRESS_MIN_BYTES is gzipped when the request's
+Accept-Encoding allows it. Audio, which does not compress, and streamed bodies (NDJSON,
+server-sent events, relayed reply audio) are sent as they are, so nothing is held back
+waiting for the end of a stream. DWANI_COMPRESSION=0 turns it off.
+"""
+import gzip
+from typing import Optional
+
+from starlette.datastructures import Headers, MutableHeaders
+from starlette.types import ASGIApp, Message, Receive, Scope, Send
+
+from config import COMPRESS_LEVEL, COMPRESS_MIN_BYTES
+
+
+def accepts_gzip(accept_encoding: Optional[str]) -> bool:
+    for coding in (accept_encoding or "").lower().split(","):
+        name, _, params = coding.partition(";")
+        if name.strip() in ("gzip", "*"):
+            q = params.strip()
+            try:
+                return (float(q[2:]) if q.startswith("q=") else 1.0) > 0
+            except ValueError:
+                return True
+    return False
+
+
+def _json(content_type: Optional[str]) -> bool:
+    media_type = (content_type or "").split(";")[0].strip().lower()
+    return media_type == "application/json" or media_type.endswith("+json")
+
+
+class JSONCompression:
+    """ASGI middleware: gzip whole JSON bodies (see the module docstring)."""
+
+    def __init__(self, app: ASGIApp, minimum_size: int = COMPRESS_MIN_BYTES, level: int = COMPRESS_LEVEL):
+        self.app = app
+        self.minimum_size = minimum_size
+        self.level = level
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send) -> None:
+        if scope["type"] != "http" or not accepts_gzip(Headers(scope=scope).get("accept-encoding")):
+            await self.app(scope, receive, send)
+            return
+        held: Optional[Message] = None
+
+        async def send_compressed(message: Message) -> None:
+            nonlocal held
+            if message["type"] == "http.response.start":
+                # Held until the first body message shows whether the body comes whole.
+                held = message
+                return
+            if held is not None:
+                start, held = held, None
+                if message["type"] == "http.response.body" and not message.get("more_body", False):
+                    message = self._compressed(start, message)
+                await send(start)
+            await send(message)
+
+        await self.app(scope, receive, send_compressed)
+
+    def _compressed(self, start: Message, message: Message) -> Message:
+        headers = MutableHeaders(scope=start)
+        body = message.get("body", b"")
+        if not _json(headers.get("content-type")) or "content-encoding" in headers or len(body) < self.minimum_size:
+            return message
+        body = gzip.compress(body, compresslevel=self.level)
+        headers["Content-Encoding"] = "gzip"
+        headers["Content-Length"] = str(len(body))
+        headers.add_vary_header("Accept-Encoding")
+        return {**message, "body": body}
@@ -0,0 +1,217 @@
+"""Cap on concurrent pipeline work (ASR/LLM/TTS fan-out) to protect small GPU backends.
+
+Up to DWANI_PIPELINE_MAX_CONCURRENT requests run at once, DWANI_PIPELINE_MAX_QUEUE more
+wait (at most DWANI_PIPELINE_QUEUE_TIMEOUT seconds, or until the request deadline), and the
+rest are turned away with 503 and Retry-After. The limit is per worker process.
+
+Each backend stage also has its own limit, so the number of calls in flight can match what
+each GPU backend can take: DWANI_STAGE_MAX_CONCURRENT="asr=8,llm=2,tts=4" lets a worker make
+8 ASR calls at once but only 2 LLM calls. Every call through a stage's BackendPool
+(services/balancer.py) takes a slot of that stage's limiter, so translation, shortening and
+other extra LLM calls count too. Up to DWANI_STAGE_MAX_QUEUE calls wait per stage, for at
+most DWANI_STAGE_QUEUE_TIMEOUT seconds, before the request gets the same 503. A slot is held
+until the backend answers: for TTS, while the response starts, not while audio is relayed.
+Background jobs wait for stage slots without a bound, as they do for pipeline slots.
+
+Requests are interactive or batch (see use_priority): a freed slot goes to the interactive
+requests waiting before any batch one, and batch work never holds the last
+DWANI_PRIORITY_INTERACTIVE_RESERVED slots of a limiter, so bulk transcription cannot crowd out
+live conversations. Background jobs are batch (they ask for it); a request is batch when its API key is listed so
+in DWANI_PRIORITY_API_KEYS or it asks for it in DWANI_PRIORITY_HEADER.
+"""
+import asyncio
+from collections import deque
+from contextlib import asynccontextmanager
+from contextvars import ContextVar
+from typing import AsyncIterator, Deque, Dict, Optional, Tuple
+
+from fastapi import HTTPException
+from prometheus_client import Counter, Gauge
+
+from config import (
+    PIPELINE_MAX_CONCURRENT,
+    PIPELINE_MAX_QUEUE,
+    PIPELINE_QUEUE_TIMEOUT,
+    PIPELINE_RETRY_AFTER,
+    PRIORITY_API_KEYS,
+    PRIORITY_INTERACTIVE_RESERVED,
+    STAGE_MAX_CONCURRENT,
+    STAGE_MAX_QUEUE,
+    STAGE_QUEUE_TIMEOUT,
+)
+from services.deadline import remaining
+
+_ACTIVE = Gauge("dwani_pipeline_active_requests", "Requests currently holding a pipeline slot")
+_QUEUED = Gauge("dwani_pipeline_queued_requests", "Requests waiting for a pipeline slot")
+_REJECTED = Counter("dwani_pipeline_rejected_total", "Requests turned away for lack of a pipeline slot", ["reason"])
+_STAGE_ACTIVE = Gauge("dwani_stage_active_calls", "Upstream calls currently holding a stage slot", ["stage"])
+_STAGE_QUEUED = Gauge("dwani_stage_queued_calls", "Upstream calls waiting for a stage slot", ["stage"])
+_STAGE_REJECTED = Counter("dwani_stage_rejected_total", "Upstream calls turned away for lack of a stage slot", ["stage", "reason"])
+
+# Set while a background job holds its pipeline slot, so its stage calls wait instead of failing.
+_blocking: ContextVar[bool] = ContextVar("dwani_slot_blocking", default=False)
+
+PRIORITIES: Tuple[str, ...] = ("interactive", "batch")
+_priority: ContextVar[str] = ContextVar("dwani_priority", default="interactive")
+
+
+def use_priority(api_key: Optional[str], requested: Optional[str] = None) -> str:
+    """Set the current request's priority class: its API key's, lowered to batch when it asks for that."""
+    priority = PRIORITY_API_KEYS.get(api_key or "", "interactive")
+    if (requested or "").strip().lower() == "batch":
+        priority = "batch"
+    _priority.set(priority)
+    return priority
+
+
+def current_priority() -> str:
+    return _priority.get()
+
+
+class PipelineBusy(HTTPException):
+    """503 with Retry-After: every pipeline slot is taken and the wait queue is full or too slow."""
+
+    def __init__(self, retry_after: int = PIPELINE_RETRY_AFTER):
+        super().__init__(
+            status_code=503,
+            detail="Server is busy; retry shortly",
+            headers={"Retry-After": str(max(1, retry_after))},
+        )
+
+
+class ConcurrencyLimiter:
+    """FIFO semaphore with a bounded wait queue; max_concurrent <= 0 disables it."""
+
+    def __init__(
+        self,
+        max_concurrent: int = PIPELINE_MAX_CONCURRENT,
+        max_queue: int = PIPELINE_MAX_QUEUE,
+        queue_timeout: float = PIPELINE_QUEUE_TIMEOUT,
+        stage: Optional[str] = None,
+        interactive_reserved: int = PRIORITY_INTERACTIVE_RESERVED,
+    ):
+        self.max_concurrent = max_concurrent
+        self.max_queue = max_queue
+        self.queue_timeout = queue_timeout
+        self.stage = stage
+        # Batch work may use every slot but the reserved ones, and always at least one.
+        self.batch_limit = max(1, max_concurrent - max(0, interactive_reserved))
+        self._active = 0
+        self._waiters: Dict[str, Deque[asyncio.Future]] = {priority: deque() for priority in PRIORITIES}
+        # The pipeline limiter keeps its unlabelled metrics; stage limiters report per stage.
+        self._active_gauge = _ACTIVE if stage is None else _STAGE_ACTIVE.labels(stage=stage)
+        self._queued_gauge = _QUEUED if stage is None else _STAGE_QUEUED.labels(stage=stage)
+
+    @property
+    def active(self) -> int:
+        return self._active
+
+    @property
+    def queued(self) -> int:
+        return sum(len(waiters) for waiters in self._waiters.values())
+
+    def _free_for(self, priority: str) -> bool:
+        """Whether a request of priority may take a slot now without jumping the queue."""
+        if priority == "batch":
+            return self._active < self.batch_limit and not self.queued
+        return self._active < self.max_concurrent and not self._waiters["interactive"]
+
+    async def acquire(self, block: bool = False, priority: Optional[str] = None) -> None:
+        """Take a slot. block=True (background jobs) waits however long it takes, outside the queue bound.
+
+        priority defaults to the current request's (current_priority()).
+        """
+        if self.max_concurrent <= 0:
+            return
+        priority = priority or current_priority()
+        if self._free_for(priority):
+            self._take()
+            return
+        if not block and self.queued >= self.max_queue:
+            self._reject("queue_full")
+            raise PipelineBusy()
+        timeout = None
+        if not block:
+            left = remaining()
+            timeout = self.queue_timeout if left is None else max(0.0, min(self.queue_timeout, left))
+        waiter = asyncio.get_running_loop().create_future()
+        waiters = self._waiters[priority]
+        waiters.append(waiter)
+        self._queued_gauge.inc()
+        try:
+            await asyncio.wait_for(waiter, timeout)
+        except BaseException as exc:
+            if waiter.done() and not waiter.cancelled():
+                self.release()  # the slot was handed over just as we gave up
+            elif waiter in waiters:
+                waiters.remove(waiter)
+                self._queued_gauge.dec()
+            if isinstance(exc, asyncio.TimeoutError):
+                self._reject("queue_timeout")
+                raise PipelineBusy() from None
+            raise
+
+    def release(self) -> None:
+        if self.max_concurrent <= 0:
+            return
+        for priority in PRIORITIES:
+            waiters = self._waiters[priority]
+            # Batch waiters only get a slot outside the interactive reserve.
+            while waiters and (priority == "interactive" or self._active - 1 < self.batch_limit):
+                waiter = waiters.popleft()
+                self._queued_gauge.dec()
+                if not waiter.done():
+                    # Hand the slot straight to the next waiter so arrivals cannot jump the queue.
+                    waiter.set_result(None)
+                    return
+        self._active -= 1
+        self._active_gauge.dec()
+
+    def _reject(self, reason: str) -> None:
+        if self.stage is None:
+            _REJECTED.labels(reason=reason).inc()
+        else:
+            _STAGE_REJECTED.labels(stage=self.stage, reason=reason).inc()
+
+    def _take(self) -> None:
+        self._active += 1
+        self._active_gauge.inc()
+
+    @asynccontextmanager
+    async def slot(self, block: bool = False, priority: Optional[str] = None) -> AsyncIterator[None]:
+        """Hold a slot; with priority, the stage calls made while holding it are of that class too."""
+        await self.acquire(block=block, priority=priority)
+        token = _blocking.set(True) if block else None
+        priority_token = _priority.set(priority) if priority else None
+        try:
+            yield
+        finally:
+            if priority_token is not None:
+                _priority.reset(priority_token)
+            if token is not None:
+                _blocking.reset(token)
+            self.release()
+
+
+pipeline_limiter = ConcurrencyLimiter()
+stage_limiters: Dict[str, ConcurrencyLimiter] = {
+    stage: ConcurrencyLimiter(limit, STAGE_MAX_QUEUE, STAGE_QUEUE_TIMEOUT, stage=stage)
+    for stage, limit in STAGE_MAX_CONCURRENT.items()
+    if limit > 0
+}
+
+
+def background_call() -> bool:
+    """Whether the current upstream call is a background job's, which waits rather than being turned away."""
+    return _blocking.get()
+
+
+@asynccontextmanager
+async def stage_slot(stage: str) -> AsyncIterator[None]:
+    """A slot of stage's limiter for one upstream call; free when the stage has no limit."""
+    limiter = stage_limiters.get(stage)
+    if limiter is None:
+        yield
+        return
+    async with limiter.slot(block=background_call()):
+        yield
@@ -0,0 +1,188 @@
+"""Spoken confirmation before sensitive intents (payments, cancellations) take effect.
+
+An intent comes from the agent service (an "intent" object in its reply: name, slots, and an
+optional human-readable description) or from an after_llm hook setting ctx.intent. Intents
+named in DWANI_CONFIRM_INTENTS are not emitted right away: the reply becomes a generated
+confirmation question and the intent waits in the session. The next turn is matched against
+yes/no; only "yes" emits it (response field and DWANI_INTENT_WEBHOOK_URL CloudEvent), "no"
+drops it, and an unclear answer re-asks up to DWANI_CONFIRM_MAX_ATTEMPTS times.
+"""
+import json
+from dataclasses import asdict, dataclass, field
+from typing import Any, Dict, Optional
+
+from fastapi import HTTPException
+
+from config import CONFIRM_INTENTS, CONFIRM_MAX_ATTEMPTS, INTENT_WEBHOOK_URL, logger
+from models import GenerationParams
+from services.chat_svc import call_llm
+from services.errors import StageError
+from services.events import cloud_event, deliver_webhook
+from services.grammar import BUILTIN_GRAMMARS
+from services.messages import localized, message
+from services.prompts import language_instruction
+from services.session import get_session_settings, session_digest, update_session_settings
+
+_SETTING = "pending_intent"
+_SAY_PROMPT = (
+    "You speak for a voice assistant. Write exactly what the assistant should say next, as one "
+    "or two short sentences, with no quotes, labels, or markdown."
+)
+
+
+@dataclass
+class Intent:
+    name: str
+    slots: Dict[str, Any] = field(default_factory=dict)
+    description: Optional[str] = None
+
+    @classmethod
+    def from_payload(cls, payload: Any) -> Optional["Intent"]:
+        """Intent from an agent/hook payload; None when it is missing or malformed."""
+        if not isinstance(payload, dict) or not str(payload.get("name") or "").strip():
+            return None
+        slots = payload.get("slots")
+        return cls(
+            name=str(payload["name"]).strip(),
+            slots=slots if isinstance(slots, dict) else {},
+            description=str(payload["description"]).strip() if payload.get("description") else None,
+        )
+
+    def summary(self) -> str:
+        if self.description:
+            return self.description
+        text = self.name.replace("_", " ")
+        if self.slots:
+            text += " (" + ", ".join(f"{k.replace('_', ' ')}: {v}" for k, v in self.slots.items()) + ")"
+        return text
+
+
+@dataclass
+class ConfirmationOutcome:
+    """What a turn did with an intent; intent is set only once it has been emitted."""
+
+    status: str  # "emitted", "pending", "confirmed", "cancelled", or "failed"
+    reply: Optional[str] = None
+    intent: Optional[Intent] = None
+
+
+def needs_confirmation(intent: Intent) -> bool:
+    return intent.name.lower() in CONFIRM_INTENTS
+
+
+def _pending(session_id: str) -> Optional[Dict[str, Any]]:
+    raw = get_session_settings(session_id).get(_SETTING)
+    if not raw:
+        return None
+    try:
+        return json.loads(raw)
+    except json.JSONDecodeError:
+        return None
+
+
+def has_pending(session_id: Optional[str]) -> bool:
+    return bool(CONFIRM_INTENTS) and bool(session_id) and _pending(session_id) is not None
+
+
+def _store(session_id: str, pending: Optional[Dict[str, Any]]) -> None:
+    update_session_settings(session_id, {_SETTING: json.dumps(pending, ensure_ascii=False) if pending else ""})
+
+
+async def _say(english: str, instruction: str, language: Optional[str], request_id: Optional[str]) -> str:
+    """english as is for English sessions; otherwise phrased by the LLM in language, english on failure."""
+    if not language or language == "english":
+        return english
+    try:
+        return await call_llm(
+            instruction,
+            request_id=request_id,
+            language=language,
+            generation=GenerationParams(temperature=0, max_tokens=128),
+            system_prompt=f"{_SAY_PROMPT} {language_instruction(language)}",
+        )
+    except StageError as exc:
+        logger.warning("Could not phrase confirmation text in %s: %s", language, exc.detail)
+        return english
+
+
+async def _question(intent: Intent, language: Optional[str], request_id: Optional[str]) -> str:
+    summary = intent.summary()
+    return await _say(
+        f"Please confirm: {summary}. Should I go ahead? Say yes or no.",
+        f"Ask the user to confirm that they want to: {summary}. Repeat the key details and ask them to answer yes or no.",
+        language,
+        request_id,
+    )
+
+
+async def emit(intent: Intent, session_id: Optional[str], request_id: Optional[str], confirmed: bool) -> bool:
+    """Publish the intent to DWANI_INTENT_WEBHOOK_URL; True when delivered (or no webhook is set)."""
+    if not INTENT_WEBHOOK_URL:
+        return True
+    subject = f"sessions/{session_digest(session_id)}" if session_id else "sessions/none"
+    event = cloud_event(
+        "intent.confirmed" if confirmed else "intent.detected",
+        subject,
+        {**asdict(intent), "confirmed": confirmed},
+    )
+    return await deliver_webhook(INTENT_WEBHOOK_URL, event, request_id=request_id)
+
+
+async def handle_intent(
+    intent: Intent,
+    session_id: Optional[str],
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+) -> ConfirmationOutcome:
+    """Emit intent now or, when it is sensitive, park it and return the confirmation question."""
+    if not needs_confirmation(intent):
+        await emit(intent, session_id, request_id, confirmed=False)
+        return ConfirmationOutcome("emitted", intent=intent)
+    if not session_id:
+        raise HTTPException(status_code=400, detail=f"Confirming '{intent.name}' requires an X-Session-ID")
+    question = await _question(intent, language, request_id)
+    _store(session_id, {"intent": asdict(intent), "question": question, "attempts": 0})
+    return ConfirmationOutcome("pending", reply=question)
+
+
+async def resolve_pending(
+    session_id: str,
+    answer: str,
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+) -> ConfirmationOutcome:
+    """Act on the user's answer to the pending confirmation question."""
+    pending = _pending(session_id) or {}
+    intent = Intent.from_payload(pending.get("intent"))
+    if intent is None:
+        _store(session_id, None)
+        return ConfirmationOutcome("cancelled", reply=localized("confirm_lost", language) or await _say(
+            message("confirm_lost"),
+            "Tell the user you lost track of their request and ask them to say it again.",
+            language, request_id,
+        ))
+    value = BUILTIN_GRAMMARS["yes_no"].match(answer)
+    if value is None:
+        attempts = int(pending.get("attempts", 0)) + 1
+        if attempts <= CONFIRM_MAX_ATTEMPTS:
+            _store(session_id, {**pending, "attempts": attempts})
+            return ConfirmationOutcome("pending", reply=pending.get("question") or await _question(intent, language, request_id))
+        value = "no"
+    _store(session_id, None)
+    if value == "no":
+        return ConfirmationOutcome("cancelled", reply=await _say(
+            f"Okay, cancelled: {intent.summary()}.",
+            f"Tell the user that, as they asked, you will not: {intent.summary()}.",
+            language, request_id,
+        ))
+    if not await emit(intent, session_id, request_id, confirmed=True):
+        return ConfirmationOutcome("failed", reply=localized("confirm_failed", language) or await _say(
+            message("confirm_failed"),
+            "Apologize to the user: their confirmed request could not be completed right now, and they should try again later.",
+            language, request_id,
+        ))
+    return ConfirmationOutcome("confirmed", intent=intent, reply=await _say(
+        f"Okay, going ahead: {intent.summary()}.",
+        f"Tell the user you are going ahead with: {intent.summary()}.",
+        language, request_id,
+    ))
@@ -0,0 +1,224 @@
+"""Multi-turn conversations over a WebSocket, as typed JSON events.
+
+The client streams 16-bit little-endian mono PCM (binary messages) or sends typed turns as
+{"type": "text", "text": ...}; the session's turn-detection preset cuts the audio into turns,
+as on /v1/transcribe/stream. For each turn the server sends, in order:
+
+    turn_start          the turn began (speech was heard, or text arrived)
+    partial_transcript  interim ASR of the speech so far, every DWANI_CONVERSATION_PARTIAL_MS
+    final_transcript    what ASR made of the turn (or the typed text), before the LLM answers
+    assistant_text      the reply
+    audio_chunk         the reply's audio, base64, as TTS produces it
+    turn_end            status completed, failed, or cancelled
+
+and error (with the error envelope's code fields) before a failed turn's turn_end. Every event
+carries the turn number. Turns are answered one at a time, in order, in the session given
+(a new one per connection otherwise), so history carries across them.
+"""
+import asyncio
+import base64
+import time
+from typing import Any, Awaitable, Callable, Dict, Optional, Tuple
+
+import httpx
+from fastapi import HTTPException
+
+from config import CONVERSATION_PARTIAL_MS, logger
+from services.concurrency import pipeline_limiter
+from services.error_codes import code_for, error_fields
+from services.language_id import language_tag
+from services.pipeline import audio_upload, converse
+from services.telephony import wav_bytes
+from services.transcribe import transcribe_audio
+from services.tts import stream_tts
+from services.turn_detection import Endpointer, TurnDetectionPreset
+
+PROTOCOL = "dwani.conversation.v1"
+
+Send = Callable[[Dict[str, Any]], Awaitable[None]]
+
+
+class ConversationStream:
+    """One client's conversation: audio and text turns in, typed events out."""
+
+    def __init__(
+        self,
+        send: Send,
+        preset: TurnDetectionPreset,
+        session_id: str,
+        sample_rate: int = 16000,
+        language: Optional[str] = None,
+        mode: str = "llm",
+        agent_name: Optional[str] = None,
+        audio_format: Optional[str] = None,
+        request_id: Optional[str] = None,
+        partial_ms: Optional[int] = None,
+    ):
+        self._send = send
+        self.preset = preset
+        self.session_id = session_id
+        self.sample_rate = sample_rate
+        self.language = language
+        self.mode = mode
+        self.agent_name = agent_name
+        self.audio_format = audio_format
+        self.request_id = request_id
+        self.partial_ms = CONVERSATION_PARTIAL_MS if partial_ms is None else partial_ms
+        self._endpointer = Endpointer(preset, sample_rate=sample_rate)
+        self._queue: "asyncio.Queue[Optional[Tuple[int, str, Any]]]" = asyncio.Queue()
+        self._worker: Optional[asyncio.Task] = None
+        self._current: Optional[asyncio.Task] = None
+        self._turns = 0
+        self._listening: Optional[int] = None  # the audio turn being heard
+        self._partial: Optional[asyncio.Task] = None
+        self._partial_bytes = 0
+
+    async def start(self) -> None:
+        self._worker = asyncio.create_task(self._answer_queued())
+        await self._send({
+            "type": "ready",
+            "protocol": PROTOCOL,
+            "session_id": self.session_id,
+            "sample_rate": self.sample_rate,
+            "turn_detection": self.preset.as_dict(),
+        })
+
+    async def feed(self, pcm: bytes) -> None:
+        for event in self._endpointer.feed_events(pcm):
+            if event.type == "speech_started":
+                self._listening = await self._start_turn("audio", at_seconds=event.at_seconds)
+                self._partial_bytes = 0
+            else:
+                await self._end_speech(event.audio)
+        if self._listening is not None and self.partial_ms:
+            self._maybe_partial()
+
+    async def text(self, text: str) -> None:
+        turn = await self._start_turn("text")
+        await self._queue.put((turn, "text", text))
+
+    async def flush(self) -> None:
+        event = self._endpointer.flush()
+        if event is not None:
+            await self._end_speech(event.audio)
+
+    def cancel(self) -> bool:
+        """Stop the turn being answered; False when there is none."""
+        if self._current is None or self._current.done():
+            return False
+        self._current.cancel()
+        return True
+
+    async def finish(self) -> None:
+        """End of stream: answer what is left and wait for every event to be sent."""
+        await self.flush()
+        await self._queue.put(None)
+        if self._worker is not None:
+            await self._worker
+
+    async def close(self) -> None:
+        """The client went away: drop the turns in progress."""
+        for task in (self._partial, self._worker):
+            if task is not None and not task.done():
+                task.cancel()
+                await asyncio.gather(task, return_exceptions=True)
+
+    async def _start_turn(self, input_type: str, **fields: Any) -> int:
+        turn = self._turns
+        self._turns += 1
+        await self._send({"type": "turn_start", "turn": turn, "input": input_type, **fields})
+        return turn
+
+    async def _end_speech(self, pcm: bytes) -> None:
+        turn, self._listening = self._listening, None
+        if self._partial is not None and not self._partial.done():
+            # A partial arriving after the final transcript would only confuse the captions.
+            self._partial.cancel()
+        await self._queue.put((turn, "audio", pcm))
+
+    def _maybe_partial(self) -> None:
+        heard = len(self._endpointer.utterance_audio)
+        step = self.partial_ms * self.sample_rate * 2 // 1000
+        if heard - self._partial_bytes < step or (self._partial is not None and not self._partial.done()):
+            return
+        self._partial_bytes = heard
+        self._partial = asyncio.create_task(self._send_partial(self._listening, self._endpointer.utterance_audio))
+
+    async def _send_partial(self, turn: int, pcm: bytes) -> None:
+        try:
+            # Interim captions are a nicety: skipped rather than queued when the server is busy.
+            async with pipeline_limiter.slot():
+                result = await transcribe_audio(
+                    audio_upload(wav_bytes(pcm, self.sample_rate), "audio/wav", f"turn-{turn}-partial.wav"),
+                    language=self.language,
+                    request_id=self.request_id,
+                )
+        except (HTTPException, httpx.HTTPError) as exc:
+            logger.debug("Partial transcript of turn %s skipped: %s", turn, exc)
+            return
+        if self._listening == turn:
+            await self._send({"type": "partial_transcript", "turn": turn, "text": result.text})
+
+    async def _answer_queued(self) -> None:
+        while True:
+            item = await self._queue.get()
+            if item is None:
+                return
+            turn = item[0]
+            self._current = asyncio.create_task(self._answer(*item))
+            try:
+                await asyncio.wait([self._current])
+            except asyncio.CancelledError:
+                self._current.cancel()
+                raise
+            if self._current.cancelled():
+                await self._send({"type": "turn_end", "turn": turn, "status": "cancelled"})
+
+    async def _answer(self, turn: int, input_type: str, value: Any) -> None:
+        started = time.perf_counter()
+
+        async def final_transcript(text: str, language: Optional[str]) -> None:
+            await self._send({"type": "final_transcript", "turn": turn, "text": text, "language": language_tag(language)})
+
+        try:
+            async with pipeline_limiter.slot(block=True):
+                result = await converse(
+                    audio_upload(wav_bytes(value, self.sample_rate), "audio/wav", f"turn-{turn}.wav") if input_type == "audio" else None,
+                    mode=self.mode,
+                    agent_name=self.agent_name,
+                    session_id=self.session_id,
+                    request_id=self.request_id,
+                    language=self.language,
+                    text=value if input_type == "text" else None,
+                    on_transcript=final_transcript,
+                )
+                await self._send({"type": "assistant_text", "turn": turn, "text": result.reply, "language": language_tag(result.language)})
+                tts_stream = await stream_tts(result.reply, request_id=self.request_id, language=result.language, audio_format=self.audio_format)
+            sequence = 0
+            async for chunk in tts_stream.iter_bytes():
+                await self._send({
+                    "type": "audio_chunk",
+                    "turn": turn,
+                    "sequence": sequence,
+                    "content_type": tts_stream.media_type,
+                    "audio_base64": base64.b64encode(chunk).decode("ascii"),
+                })
+                sequence += 1
+        except (HTTPException, httpx.HTTPError) as exc:
+            code, detail = (exc.status_code, exc.detail) if isinstance(exc, HTTPException) else (502, "External API error")
+            logger.warning("Conversation turn %s failed: %s", turn, detail, extra={"request_id": self.request_id})
+            await self._send({
+                "type": "error",
+                "turn": turn,
+                "code": str(code),
+                "message": str(detail),
+                **error_fields(code_for(exc) if isinstance(exc, HTTPException) else "tts_failed", self.language),
+            })
+            await self._send({"type": "turn_end", "turn": turn, "status": "failed"})
+            return
+        await self._send({
+            "type": "turn_end",
+            "turn": turn,
+            "status": "completed",
+            "duration_ms": round((time.perf_counter() - started) * 1000),
+        })
@@ -0,0 +1,187 @@
+"""Transcript corrections: a deployment's dictionary of fixes for words ASR keeps getting wrong.
+
+ASR backends consistently mishear some product names, place names and jargon. Each entry
+replaces a phrase or a regular expression in every transcript before the LLM sees it:
+
+    [{"match": "the money app", "replace": "Dwani app"},
+     {"match": "ಬೆಂಗಳೂರ್", "replace": "ಬೆಂಗಳೂರು", "language": "kannada"},
+     {"match": "pin code (\\d) (\\d)", "replace": "PIN code \\1\\2", "regex": true}]
+
+Phrases match as whole words, case-insensitively, in any script, and are replaced as written;
+regular expressions use re syntax, with \\1 groups in the replacement. An entry with a language
+only applies to turns in it. Entries apply in order, as an after_asr hook that runs after PII
+redaction and the recorder (recordings keep what ASR heard) and before plugins.
+
+Entries come from DWANI_CORRECTIONS_FILE and are managed at runtime over /admin/corrections.
+Changes are written back to the file, and every worker reloads it when its modification time
+changes; without a file they live in the serving worker's memory only. Replacements are counted
+in dwani_transcript_corrections_total{entry}.
+"""
+import hashlib
+import json
+import os
+import re
+import tempfile
+import threading
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional
+
+from prometheus_client import Counter
+
+from config import CORRECTIONS_FILE, logger
+from models import ALLOWED_LANGUAGES, language_alias
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.moderation import blocklist_pattern
+
+_FIELDS = {"match", "replace", "regex", "language"}
+_CORRECTIONS = Counter("dwani_transcript_corrections_total", "Transcript replacements by the correction dictionary", ["entry"])
+
+
+@dataclass(frozen=True)
+class Correction:
+    match: str
+    replace: str
+    regex: bool = False
+    language: Optional[str] = None
+
+    @property
+    def id(self) -> str:
+        """Stable for the same match and language, so adding an entry again updates it."""
+        return hashlib.sha256(f"{self.language or ''}\0{self.match}".encode("utf-8")).hexdigest()[:12]
+
+    def pattern(self) -> re.Pattern:
+        return re.compile(self.match) if self.regex else blocklist_pattern([self.match])
+
+    def apply(self, text: str) -> str:
+        pattern = self.pattern()
+        if self.regex:
+            corrected, count = pattern.subn(self.replace, text)
+        else:
+            corrected, count = pattern.subn(lambda _: self.replace, text)
+        if count:
+            _CORRECTIONS.labels(entry=self.id).inc(count)
+        return corrected
+
+    def as_dict(self) -> Dict[str, Any]:
+        return {"id": self.id, "match": self.match, "replace": self.replace, "regex": self.regex, "language": self.language}
+
+
+def correction_from_spec(spec: Any) -> Correction:
+    """A Correction from its JSON object; ValueError when malformed."""
+    if not isinstance(spec, dict):
+        raise ValueError("a correction must be an object with match and replace")
+    unknown = set(spec) - _FIELDS - {"id"}
+    if unknown:
+        raise ValueError(f"unknown correction fields {sorted(unknown)}, expected some of {sorted(_FIELDS)}")
+    match, replace, regex = spec.get("match"), spec.get("replace"), spec.get("regex", False)
+    if not isinstance(match, str) or not match.strip() or not isinstance(replace, str) or not isinstance(regex, bool):
+        raise ValueError("a correction needs a non-empty match, a replace string and a boolean regex")
+    language = spec.get("language")
+    if language is not None:
+        language = language_alias(str(language)).lower()
+        if language not in ALLOWED_LANGUAGES:
+            raise ValueError(f"unsupported correction language {spec['language']!r}")
+    correction = Correction(match if regex else match.strip(), replace, regex, language)
+    try:
+        correction.pattern().sub(replace if regex else "", "")  # a bad \\1 in the replacement fails here too
+    except re.error as exc:
+        raise ValueError(f"invalid correction regex {match!r}: {exc}")
+    return correction
+
+
+def load_corrections(path: str) -> List[Correction]:
+    """The entries in path; [] when it does not exist yet."""
+    try:
+        with open(path, encoding="utf-8") as f:
+            specs = json.load(f)
+    except FileNotFoundError:
+        return []
+    if not isinstance(specs, list):
+        raise ValueError(f"{path} must be a list of corrections")
+    return [correction_from_spec(spec) for spec in specs]
+
+
+class CorrectionDictionary:
+    """The deployment's corrections, kept in step with the file when there is one."""
+
+    def __init__(self, path: str = CORRECTIONS_FILE):
+        self.path = path
+        self._lock = threading.Lock()
+        self._mtime: Optional[float] = None
+        self._entries: List[Correction] = []
+        if path:
+            self._entries = load_corrections(path)
+            self._mtime = self._stat()
+            logger.info("Loaded %s transcript corrections from %s", len(self._entries), path)
+
+    def _stat(self) -> Optional[float]:
+        try:
+            return os.stat(self.path).st_mtime
+        except OSError:
+            return None
+
+    def entries(self) -> List[Correction]:
+        if self.path:
+            mtime = self._stat()
+            with self._lock:
+                if mtime != self._mtime:
+                    try:
+                        self._entries = load_corrections(self.path)
+                        logger.info("Reloaded %s transcript corrections from %s", len(self._entries), self.path)
+                    except (OSError, ValueError) as exc:
+                        logger.error("Keeping the previous transcript corrections; %s is invalid: %s", self.path, exc)
+                    self._mtime = mtime
+        return list(self._entries)
+
+    def add(self, correction: Correction) -> Correction:
+        """Add correction, or replace the entry with the same match and language."""
+        entries = self.entries()
+        for index, entry in enumerate(entries):
+            if entry.id == correction.id:
+                entries[index] = correction
+                break
+        else:
+            entries.append(correction)
+        self._save(entries)
+        return correction
+
+    def remove(self, entry_id: str) -> bool:
+        entries = self.entries()
+        kept = [entry for entry in entries if entry.id != entry_id]
+        if len(kept) == len(entries):
+            return False
+        self._save(kept)
+        return True
+
+    def _save(self, entries: List[Correction]) -> None:
+        with self._lock:
+            self._entries = entries
+            if not self.path:
+                return
+            specs = [{key: value for key, value in entry.as_dict().items() if key != "id"} for entry in entries]
+            directory = os.path.dirname(os.path.abspath(self.path))
+            with tempfile.NamedTemporaryFile("w", encoding="utf-8", dir=directory, delete=False, suffix=".tmp") as f:
+                json.dump(specs, f, ensure_ascii=False, indent=2)
+            os.replace(f.name, self.path)
+            self._mtime = self._stat()
+
+    def apply(self, text: str, language: Optional[str] = None) -> str:
+        for entry in self.entries():
+            if entry.language is None or entry.language == language:
+                text = entry.apply(text)
+        return text
+
+
+corrections = CorrectionDictionary()
+
+
+def _correct(ctx: HookContext, text: str) -> str:
+    return corrections.apply(text, ctx.language)
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the after_asr correction hook (always: entries can be added at runtime); False when it already is."""
+    if _correct in registry.registered("after_asr"):
+        return False
+    registry.register("after_asr", _correct)
+    return True
@@ -0,0 +1,130 @@
+"""Backend credentials from the environment, mounted files, or a secrets provider.
+
+A credential such as DWANI_TTS_API_KEY is looked up, in order, from:
+
+- the provider named by DWANI_SECRETS_PROVIDER ("module:Class" or "module:factory"), any object
+  with get(name) -> Optional[str], for Vault, a cloud secrets manager, and the like;
+- the environment variable itself;
+- the file named by <NAME>_FILE (Docker and Kubernetes secrets), read again whenever its
+  modification time changes, so a rotated file is used from the next call on.
+
+signing_secret() is for the server's own signing and sealing keys: unset, it falls back to a
+random key per process, with a warning, so what it signs only works in that process.
+
+Every value handed out is masked as *** in log messages once the log filter is installed.
+"""
+import importlib
+import logging
+import os
+import threading
+from typing import Dict, Optional, Protocol, Set, Tuple
+
+from config import SECRETS_PROVIDER, logger
+
+# Shorter values are not masked in logs: they would hide ordinary words.
+_MIN_REDACT_CHARS = 6
+
+
+class SecretProvider(Protocol):
+    def get(self, name: str) -> Optional[str]:
+        ...
+
+
+class FileSecrets:
+    """Values of <NAME>_FILE files, cached until the file's modification time changes."""
+
+    def __init__(self):
+        self._cache: Dict[str, Tuple[float, str]] = {}
+        self._lock = threading.Lock()
+
+    def get(self, name: str) -> Optional[str]:
+        path = os.getenv(f"{name}_FILE", "").strip()
+        if not path:
+            return None
+        try:
+            mtime = os.stat(path).st_mtime
+            with self._lock:
+                cached = self._cache.get(path)
+                if cached is None or cached[0] != mtime:
+                    with open(path, encoding="utf-8") as handle:
+                        self._cache[path] = (mtime, handle.read().strip())
+                    if cached is not None:
+                        logger.info("Reloaded %s from %s", name, path)
+                return self._cache[path][1] or None
+        except OSError as exc:
+            logger.warning("Could not read %s from %s: %s", name, path, exc)
+            return None
+
+
+def load_provider(spec: str = SECRETS_PROVIDER) -> Optional[SecretProvider]:
+    spec = (spec or "").strip()
+    if not spec:
+        return None
+    module_name, _, attr = spec.partition(":")
+    if not module_name or not attr:
+        raise ValueError(f"DWANI_SECRETS_PROVIDER must be module:Class, not {spec!r}")
+    provider = getattr(importlib.import_module(module_name), attr)()
+    if not callable(getattr(provider, "get", None)):
+        raise ValueError(f"{spec} did not produce an object with get(name)")
+    return provider
+
+
+class Secrets:
+    def __init__(self, provider: Optional[SecretProvider] = None, files: Optional[FileSecrets] = None):
+        self.provider = provider
+        self.files = files or FileSecrets()
+        self._handed_out: Set[str] = set()
+
+    def get(self, name: str, default: str = "") -> str:
+        value = (self.provider.get(name) if self.provider is not None else None) or os.getenv(name, "").strip()
+        value = value or self.files.get(name) or default
+        if len(value) >= _MIN_REDACT_CHARS:
+            self._handed_out.add(value)
+        return value
+
+    def redact(self, text: str) -> str:
+        for value in self._handed_out:
+            if value in text:
+                text = text.replace(value, "***")
+        return text
+
+
+secrets = Secrets(load_provider())
+
+
+def secret(name: str, default: str = "") -> str:
+    return secrets.get(name, default)
+
+
+_random_keys: Dict[str, bytes] = {}
+_random_lock = threading.Lock()
+
+
+def signing_secret(name: str, signed: str) -> bytes:
+    """name's value as bytes; unset, a random key for this process, after a warning that signed stop
+    working on restart and are refused by other workers and replicas."""
+    value = secret(name)
+    if value:
+        return value.encode("utf-8")
+    with _random_lock:
+        if name not in _random_keys:
+            logger.warning("%s is not set; %s only work in this process", name, signed)
+            _random_keys[name] = os.urandom(32)
+        return _random_keys[name]
+
+
+class RedactingFilter(logging.Filter):
+    """Masks credentials handed out by secret() in formatted log messages."""
+
+    def filter(self, record: logging.LogRecord) -> bool:
+        message = record.getMessage()
+        redacted = secrets.redact(message)
+        if redacted != message:
+            record.msg, record.args = redacted, None
+        return True
+
+
+def install_log_filter() -> None:
+    for handler in logging.getLogger().handlers:
+        if not any(isinstance(f, RedactingFilter) for f in handler.filters):
+            handler.addFilter(RedactingFilter())
@@ -0,0 +1,62 @@
+"""Per-request deadline shared by all pipeline stages of the current request.
+
+Machine callers set it with X-Deadline-Ms (the budget in milliseconds) and read back what the
+request used and left in X-Deadline-Consumed-Ms and X-Deadline-Remaining-Ms, so a caller can pass
+the rest of its own budget on to the next hop. Requests relayed to a peer carry the remainder.
+"""
+import time
+from contextvars import ContextVar
+from typing import Dict, Optional
+
+from services.errors import StageError
+
+HEADER = "X-Deadline-Ms"
+CONSUMED_HEADER = "X-Deadline-Consumed-Ms"
+REMAINING_HEADER = "X-Deadline-Remaining-Ms"
+
+_deadline: ContextVar[Optional[float]] = ContextVar("dwani_request_deadline", default=None)
+_started: ContextVar[Optional[float]] = ContextVar("dwani_request_started", default=None)
+
+
+class StageTimeout(StageError):
+    """504 naming the pipeline stage (asr, llm, tts, agent, vision) that ran out of time."""
+
+    def __init__(self, stage: str):
+        super().__init__(
+            stage,
+            status_code=504,
+            detail=f"{stage.upper()} stage timed out",
+            headers={"X-Timeout-Stage": stage},
+        )
+
+
+def set_deadline(seconds: Optional[float]) -> None:
+    now = time.monotonic()
+    _started.set(now)
+    _deadline.set(now + seconds if seconds and seconds > 0 else None)
+
+
+def remaining() -> Optional[float]:
+    deadline = _deadline.get()
+    if deadline is None:
+        return None
+    return deadline - time.monotonic()
+
+
+def budget_headers() -> Dict[str, str]:
+    """X-Deadline-Consumed-Ms and X-Deadline-Remaining-Ms of the request; {} without a deadline."""
+    deadline, started = _deadline.get(), _started.get()
+    if deadline is None or started is None:
+        return {}
+    now = time.monotonic()
+    return {CONSUMED_HEADER: str(round((now - started) * 1000)), REMAINING_HEADER: str(max(0, round((deadline - now) * 1000)))}
+
+
+def stage_timeout(stage: str, default: float) -> float:
+    """Timeout for the next upstream call: the stage default capped by the request deadline."""
+    left = remaining()
+    if left is None:
+        return default
+    if left <= 0:
+        raise StageTimeout(stage)
+    return min(default, left)
@@ -0,0 +1,86 @@
+"""Public demo profile: DWANI_DEMO_MODE=1 makes one binary safe to host as an open playground.
+
+config.py keeps sessions in memory and turns off stored conversations and request recordings,
+lowers the default upload size and per-IP rate, and main.py adds a per-IP quota of
+DWANI_DEMO_DAILY_QUOTA pipeline requests a day. Sign-up is closed. API requests need a key:
+DWANI_API_KEY as usual, or the demo key, an HMAC of the current DWANI_DEMO_KEY_ROTATE_SECONDS
+window that the demo page fetches from /demo/key. A key copied out of the page stops working
+one window after it was issued.
+
+TTS audio is watermarked with DWANI_DEMO_WATERMARK: an ID3 TXXX frame in the MP3's tag, a
+LIST/INFO comment chunk in WAV. Ogg is left as is; its comments live inside the codec stream.
+"""
+import hashlib
+import hmac
+import struct
+import time
+from typing import Any, Dict, Optional
+
+from config import DEMO_KEY_ROTATE_SECONDS, DEMO_MODE, DEMO_WATERMARK, logger
+from services.audio_format import detect_format, id3_frame, tag_mp3
+from services.credentials import signing_secret
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.tts import TTSStream
+
+KEY_PREFIX = "demo_"
+
+
+def demo_key(window: int) -> str:
+    key = signing_secret("DWANI_DEMO_KEY_SECRET", "demo keys")
+    return KEY_PREFIX + hmac.new(key, f"demo-key:{window}".encode("ascii"), hashlib.sha256).hexdigest()[:32]
+
+
+def issue_key(now: Optional[float] = None) -> Dict[str, Any]:
+    """The current demo key and when the next one takes over (it stays valid one window longer)."""
+    window = int((time.time() if now is None else now) // DEMO_KEY_ROTATE_SECONDS)
+    return {"api_key": demo_key(window), "rotates_at": (window + 1) * DEMO_KEY_ROTATE_SECONDS}
+
+
+def valid_demo_key(key: Optional[str], now: Optional[float] = None) -> bool:
+    """True for the current or previous window's key while demo mode is on."""
+    if not DEMO_MODE or not key or not key.startswith(KEY_PREFIX):
+        return False
+    window = int((time.time() if now is None else now) // DEMO_KEY_ROTATE_SECONDS)
+    return any(hmac.compare_digest(key.encode("utf-8"), demo_key(w).encode("ascii")) for w in (window, window - 1))
+
+
+def _wav_info_chunk(text: str) -> bytes:
+    comment = text.encode("utf-8") + b"\x00"
+    comment += b"\x00" * (len(comment) & 1)
+    info = b"INFO" + b"ICMT" + struct.pack("<I", len(comment)) + comment
+    return b"LIST" + struct.pack("<I", len(info)) + info
+
+
+def watermark(audio: bytes, text: str = DEMO_WATERMARK) -> bytes:
+    """audio with text added as metadata; unchanged when already marked or not MP3/WAV."""
+    marker = text.encode("utf-8")
+    if not text or marker in audio[:512]:
+        return audio
+    audio_format = detect_format(None, audio[:12])
+    if audio_format == "mp3":
+        return tag_mp3(audio, [id3_frame("TXXX", text, "watermark")])
+    if audio_format == "wav" and len(audio) >= 20:
+        chunk = _wav_info_chunk(text)
+        # After the fmt chunk when it comes first, as some readers expect.
+        offset = 12
+        if audio[12:16] == b"fmt ":
+            size = int.from_bytes(audio[16:20], "little")
+            offset = 20 + size + (size & 1) if 20 + size <= len(audio) else 12
+        riff_size = int.from_bytes(audio[4:8], "little")
+        if riff_size not in (0, 0xFFFFFFFF):  # streamed WAV often leaves a placeholder
+            riff_size = min(riff_size + len(chunk), 0xFFFFFFFE)
+        return audio[:4] + struct.pack("<I", riff_size) + audio[8:offset] + chunk + audio[offset:]
+    return audio
+
+
+async def _watermark_tts(ctx: HookContext, stream: TTSStream) -> None:
+    stream.rewrite_head(watermark)
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the watermark hook in demo mode; call it after plugins so it marks the audio as sent."""
+    if not DEMO_MODE or _watermark_tts in registry.registered("after_tts"):
+        return False
+    registry.register("after_tts", _watermark_tts)
+    logger.warning("Demo mode: no persistence, per-IP quotas, rotating demo API key, watermarked audio")
+    return True
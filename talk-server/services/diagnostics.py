@@ -0,0 +1,170 @@
+"""Runtime diagnostics for the /debug endpoints: live config, memory, tasks, and a sampling profiler.
+
+Everything here describes the one worker process that serves the request (see "pid").
+Heap statistics need tracemalloc, which DWANI_DEBUG_TRACEMALLOC_FRAMES starts at startup,
+since it slows allocation and only sees memory allocated after it starts.
+"""
+import asyncio
+import gc
+import os
+import platform
+import re
+import resource
+import sys
+import threading
+import time
+import traceback
+import tracemalloc
+from collections import Counter
+from typing import Any, Dict, List, Optional
+
+import config
+from services.balancer import pools
+from services.concurrency import pipeline_limiter, stage_limiters
+
+_STARTED = time.time()
+_SECRET_NAME = re.compile(r"SECRET|TOKEN|PASSWORD|API_KEY|_KEY$")
+_URL_CREDENTIALS = re.compile(r"(://[^/@\s]*?:)[^/@\s]+@")
+_SAMPLE_INTERVAL = 0.005
+
+
+def _redact(name: str, value: Any) -> Any:
+    if isinstance(value, str):
+        if value and _SECRET_NAME.search(name):
+            return "***"
+        return _URL_CREDENTIALS.sub(r"\1***@", value)
+    if isinstance(value, (dict, list, tuple)):
+        return value if not _SECRET_NAME.search(name) else "***"
+    return value
+
+
+def live_config() -> Dict[str, Any]:
+    """The effective settings from config.py, with secrets and URL passwords masked."""
+    values = {}
+    for name in sorted(dir(config)):
+        value = getattr(config, name)
+        if name.isupper() and isinstance(value, (str, int, float, bool, dict, list, tuple, type(None))):
+            values[name] = _redact(name, value)
+    return values
+
+
+def rss_bytes() -> Optional[int]:
+    """Current resident set size (Linux), else None."""
+    try:
+        with open("/proc/self/statm", encoding="ascii") as fh:
+            return int(fh.read().split()[1]) * os.sysconf("SC_PAGE_SIZE")
+    except (OSError, ValueError, IndexError):
+        return None
+
+
+def runtime_stats() -> Dict[str, Any]:
+    usage = resource.getrusage(resource.RUSAGE_SELF)
+    try:
+        tasks = len(asyncio.all_tasks())
+    except RuntimeError:
+        tasks = None
+    traced = tracemalloc.get_traced_memory() if tracemalloc.is_tracing() else None
+    return {
+        "pid": os.getpid(),
+        "python": platform.python_version(),
+        "uptime_seconds": round(time.time() - _STARTED, 1),
+        "rss_bytes": rss_bytes(),
+        # ru_maxrss is in kilobytes on Linux.
+        "max_rss_bytes": usage.ru_maxrss * 1024,
+        "cpu_seconds": round(usage.ru_utime + usage.ru_stime, 3),
+        "threads": threading.active_count(),
+        "asyncio_tasks": tasks,
+        "gc": {
+            "counts": gc.get_count(),
+            "thresholds": gc.get_threshold(),
+            "frozen": gc.get_freeze_count(),
+            "generations": gc.get_stats(),
+        },
+        "tracemalloc": {"current_bytes": traced[0], "peak_bytes": traced[1]} if traced else None,
+        "pipeline": {"active": pipeline_limiter.active, "queued": pipeline_limiter.queued},
+        "stages": {
+            stage: {"limit": limiter.max_concurrent, "active": limiter.active, "queued": limiter.queued}
+            for stage, limiter in stage_limiters.items()
+        },
+    }
+
+
+def backend_health() -> List[Dict[str, Any]]:
+    now = time.monotonic()
+    return [
+        {"stage": p.stage, "instances": [i.snapshot(now) for i in p.instances]}
+        for p in pools()
+        if any(i.url for i in p.instances)
+    ]
+
+
+def collect_garbage() -> Dict[str, Any]:
+    before = rss_bytes()
+    collected = gc.collect()
+    return {"collected": collected, "rss_bytes_before": before, "rss_bytes_after": rss_bytes()}
+
+
+def heap_top(limit: int = 25, group_by: str = "lineno") -> Optional[List[Dict[str, Any]]]:
+    """Largest live allocations by source line (or "filename", "traceback"); None unless tracing."""
+    if not tracemalloc.is_tracing():
+        return None
+    stats = tracemalloc.take_snapshot().filter_traces((
+        tracemalloc.Filter(False, tracemalloc.__file__),
+        tracemalloc.Filter(False, "<frozen importlib._bootstrap>"),
+    )).statistics(group_by)
+    return [
+        {"size_bytes": stat.size, "count": stat.count, "traceback": [str(frame) for frame in stat.traceback]}
+        for stat in stats[:limit]
+    ]
+
+
+def stack_dump() -> str:
+    """Every thread's stack and every asyncio task's, like a goroutine dump."""
+    names = {thread.ident: thread.name for thread in threading.enumerate()}
+    lines = []
+    for ident, frame in sys._current_frames().items():
+        lines.append(f"Thread {names.get(ident, ident)}:")
+        lines.extend(line.rstrip("\n") for line in traceback.format_stack(frame))
+        lines.append("")
+    try:
+        tasks = asyncio.all_tasks()
+    except RuntimeError:
+        tasks = set()
+    for task in tasks:
+        lines.append(f"Task {task.get_name()} ({task.get_coro()!r}):")
+        for frame in task.get_stack():
+            lines.extend(line.rstrip("\n") for line in traceback.format_stack(frame, limit=1))
+        lines.append("")
+    return "\n".join(lines)
+
+
+def _folded(frame: Any) -> str:
+    names = []
+    while frame is not None:
+        code = frame.f_code
+        names.append(f"{code.co_name} ({os.path.basename(code.co_filename)}:{frame.f_lineno})")
+        frame = frame.f_back
+    return ";".join(reversed(names))
+
+
+def sample_profile(seconds: float, interval: float = _SAMPLE_INTERVAL) -> str:
+    """Sample every other thread's stack for seconds; folded stacks ("a;b;c count") for flame graphs.
+
+    Blocks, so run it in a thread; the event loop keeps serving while it is sampled.
+    """
+    me = threading.get_ident()
+    names = {thread.ident: thread.name for thread in threading.enumerate()}
+    samples: Counter = Counter()
+    deadline = time.monotonic() + seconds
+    while time.monotonic() < deadline:
+        for ident, frame in sys._current_frames().items():
+            if ident != me:
+                samples[f"{names.get(ident, ident)};{_folded(frame)}"] += 1
+        time.sleep(interval)
+    return "".join(f"{stack} {count}\n" for stack, count in samples.most_common())
+
+
+def start_tracing(frames: int) -> None:
+    if frames > 0 and not tracemalloc.is_tracing():
+        tracemalloc.start(frames)
+        config.logger.info("tracemalloc started with %s frames for /debug/pprof/heap", frames)
@@ -0,0 +1,283 @@
+"""Dictation: long-form, ASR-only transcription returned as punctuated paragraphs.
+
+WAV uploads are cut into segments of about DWANI_DICTATION_SEGMENT_SECONDS at the quietest
+moment near each boundary, so words are not split and each segment fits one ASR call; other
+formats are transcribed whole. Each segment becomes a paragraph. When the ASR backend returns
+raw text (no punctuation, no casing), an LLM pass restores punctuation, casing, and written
+forms of numbers and dates (inverse text normalization) without changing the words.
+
+Before splitting, 16-bit WAV recordings have every silence longer than DWANI_DICTATION_SILENCE_MS
+shortened to DWANI_DICTATION_SILENCE_KEEP_MS, so the long pauses of lectures and meetings are
+not sent to ASR. Segment times still refer to the original recording, and the amount removed
+is reported with the result.
+"""
+import array
+import asyncio
+import bisect
+import io
+import sys
+import wave
+from dataclasses import dataclass, field
+from typing import Any, AsyncIterator, Callable, Dict, List, Optional, Tuple
+
+from fastapi import HTTPException, UploadFile
+from prometheus_client import Counter
+
+from config import (
+    DICTATION_RESTORE,
+    DICTATION_SEGMENT_SECONDS,
+    DICTATION_SILENCE_KEEP_MS,
+    DICTATION_SILENCE_MS,
+    MAX_UPLOAD_BYTES,
+    logger,
+)
+from models import GenerationParams
+from services.chat_svc import call_llm
+from services.concurrency import pipeline_limiter
+from services.errors import RequestError
+from services.latency import observe_stage, set_input_audio, wav_duration
+from services.pipeline import audio_upload
+from services.prompts import language_display_name
+from services.transcribe import transcribe_audio
+from services.turn_detection import frame_dbfs
+
+_SILENCE_REMOVED = Counter("dwani_dictation_silence_removed_seconds_total", "Seconds of silence cut from dictation audio before ASR")
+
+_SENTENCE_PUNCTUATION = set(".?!,;:।॥")
+# Quietest point is searched for in the last few seconds before each nominal boundary.
+_CUT_SEARCH_SECONDS = 3.0
+_CUT_WINDOW_SECONDS = 0.1
+_SILENCE_FRAME_MS = 20
+_SILENCE_DBFS = -45.0
+
+_RESTORE_PROMPT = (
+    "You format raw speech-recognition output. Add punctuation and sentence casing, and write "
+    "numbers, dates, times, and currency amounts in their usual written form. "
+    "Do not add, remove, translate, or reorder words, and do not answer or comment on the text. "
+    "Output only the formatted text."
+)
+
+
+@dataclass
+class DictationSegment:
+    index: int
+    start_seconds: float
+    end_seconds: Optional[float]
+    raw_text: str
+    text: str
+    restored: bool
+
+
+@dataclass
+class SilenceCompression:
+    rate: int
+    original_frames: int
+    compressed_frames: int
+    silences: int = 0
+    # (compressed, original) frame where each stretch of kept audio starts.
+    offsets: List[Tuple[int, int]] = field(default_factory=lambda: [(0, 0)])
+
+    def original_time(self, seconds: float) -> float:
+        """Where a moment of the compressed audio is in the original recording."""
+        frame = round(seconds * self.rate)
+        compressed, original = self.offsets[bisect.bisect_right(self.offsets, (frame, float("inf"))) - 1]
+        return (original + frame - compressed) / self.rate
+
+    def as_dict(self) -> Dict[str, Any]:
+        original = self.original_frames / self.rate
+        compressed = self.compressed_frames / self.rate
+        return {
+            "original_seconds": round(original, 3),
+            "compressed_seconds": round(compressed, 3),
+            "removed_seconds": round(original - compressed, 3),
+            "silences": self.silences,
+            "ratio": round(compressed / original, 3) if original else 1.0,
+        }
+
+
+def compress_silence(data: bytes, min_silence_ms: int, keep_ms: int) -> Optional[Tuple[bytes, SilenceCompression]]:
+    """data with silences of min_silence_ms or more cut down to keep_ms; None unless it is 16-bit PCM WAV.
+
+    Half of keep_ms stays at each end of a silence, so the words either side are not clipped.
+    """
+    try:
+        with wave.open(io.BytesIO(data)) as reader:
+            channels, width, rate, total = (
+                reader.getnchannels(), reader.getsampwidth(), reader.getframerate(), reader.getnframes()
+            )
+            frames = reader.readframes(total)
+    except (wave.Error, EOFError):
+        return None
+    if width != 2 or not rate:
+        return None
+    total = len(frames) // (channels * width)
+    stats = SilenceCompression(rate=rate, original_frames=total, compressed_frames=total)
+    window = max(1, rate * _SILENCE_FRAME_MS // 1000)
+    half_keep = rate * max(0, keep_ms) // 2000
+    if min_silence_ms <= 0 or min_silence_ms <= keep_ms:
+        return data, stats
+    frame_bytes = channels * width
+    cuts: List[Tuple[int, int]] = []
+    quiet_from: Optional[int] = None
+    for start in range(0, total + 1, window):
+        quiet = start + window <= total and frame_dbfs(frames[start * frame_bytes:(start + window) * frame_bytes]) < _SILENCE_DBFS
+        if quiet and quiet_from is None:
+            quiet_from = start
+        elif not quiet and quiet_from is not None:
+            end = min(start, total)
+            if (end - quiet_from) * 1000 >= min_silence_ms * rate:
+                cuts.append((quiet_from + half_keep, end - half_keep))
+            quiet_from = None
+    if not cuts:
+        return data, stats
+    kept, position, compressed = [], 0, 0
+    for begin, end in cuts:
+        kept.append(frames[position * frame_bytes:begin * frame_bytes])
+        compressed += begin - position
+        stats.offsets.append((compressed, end))
+        position = end
+    kept.append(frames[position * frame_bytes:total * frame_bytes])
+    stats.compressed_frames = compressed + total - position
+    stats.silences = len(cuts)
+    out = io.BytesIO()
+    with wave.open(out, "wb") as writer:
+        writer.setnchannels(channels)
+        writer.setsampwidth(width)
+        writer.setframerate(rate)
+        writer.writeframes(b"".join(kept))
+    return out.getvalue(), stats
+
+
+def _quietest_frame(frames: bytes, frame_bytes: int, sample_width: int, lo: int, hi: int, window: int) -> int:
+    if sample_width != 2 or hi - lo < window:
+        return hi
+    best, best_energy = hi, None
+    for start in range(lo, hi - window + 1, window):
+        samples = array.array("h", frames[start * frame_bytes:(start + window) * frame_bytes])
+        if sys.byteorder == "big":
+            samples.byteswap()  # WAV PCM is little-endian
+        energy = sum(s * s for s in samples)
+        if best_energy is None or energy < best_energy:
+            best, best_energy = start + window // 2, energy
+    return best
+
+
+def split_wav(data: bytes, segment_seconds: float) -> Optional[List[Tuple[float, float, bytes]]]:
+    """(start, end, wav bytes) per segment, or None when data is not PCM WAV."""
+    try:
+        with wave.open(io.BytesIO(data)) as reader:
+            channels, width, rate, total = (
+                reader.getnchannels(), reader.getsampwidth(), reader.getframerate(), reader.getnframes()
+            )
+            frames = reader.readframes(total)
+    except (wave.Error, EOFError):
+        return None
+    if not rate or segment_seconds <= 0 or total <= segment_seconds * rate:
+        return [(0.0, total / rate if rate else 0.0, data)]
+    frame_bytes = channels * width
+    segment = int(segment_seconds * rate)
+    search = int(_CUT_SEARCH_SECONDS * rate)
+    window = max(1, int(_CUT_WINDOW_SECONDS * rate))
+    bounds = [0]
+    while total - bounds[-1] > segment:
+        target = bounds[-1] + segment
+        bounds.append(_quietest_frame(frames, frame_bytes, width, max(bounds[-1] + window, target - search), target, window))
+    bounds.append(total)
+    parts = []
+    for begin, end in zip(bounds, bounds[1:]):
+        out = io.BytesIO()
+        with wave.open(out, "wb") as writer:
+            writer.setnchannels(channels)
+            writer.setsampwidth(width)
+            writer.setframerate(rate)
+            writer.writeframes(frames[begin * frame_bytes:end * frame_bytes])
+        parts.append((begin / rate, end / rate, out.getvalue()))
+    return parts
+
+
+def needs_restoration(text: str) -> bool:
+    """True for raw ASR output: several words, no punctuation, and no upper case."""
+    if len(text.split()) < 4:
+        return False
+    return not any(ch in _SENTENCE_PUNCTUATION for ch in text) and text == text.lower()
+
+
+async def restore_punctuation(text: str, language: Optional[str] = None, request_id: Optional[str] = None) -> Optional[str]:
+    """Formatted text, or None when the LLM fails or rewrites the content (the raw text is kept)."""
+    prompt = _RESTORE_PROMPT
+    if language:
+        prompt = f"The text is in {language_display_name(language)}. {prompt}"
+    try:
+        restored = await call_llm(
+            text,
+            request_id=request_id,
+            generation=GenerationParams(temperature=0, max_tokens=min(4096, max(256, len(text)))),
+            system_prompt=prompt,
+        )
+    except HTTPException as exc:
+        logger.warning("Punctuation restoration failed; keeping raw transcript: %s", exc.detail)
+        return None
+    words, restored_words = len(text.split()), len(restored.split())
+    # Number formatting shrinks the word count a little; a large change means the model rewrote it.
+    if abs(restored_words - words) > max(3, words * 0.3):
+        logger.warning("Punctuation restoration changed %s words to %s; keeping raw transcript", words, restored_words)
+        return None
+    return restored
+
+
+def _should_restore(text: str) -> bool:
+    return DICTATION_RESTORE == "always" or (DICTATION_RESTORE == "auto" and needs_restoration(text))
+
+
+async def dictate(
+    file: UploadFile,
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+    on_compressed: Optional[Callable[[SilenceCompression], None]] = None,
+) -> AsyncIterator[DictationSegment]:
+    """Transcribe an upload segment by segment, yielding each formatted paragraph as it is ready.
+
+    on_compressed gets the silence-compression stats before the first segment is transcribed
+    (WAV uploads only).
+    """
+    data = await file.read()
+    if len(data) > MAX_UPLOAD_BYTES:
+        raise HTTPException(status_code=413, detail=f"File too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+    if not data:
+        raise RequestError(400, "empty_input", "Empty audio file")
+    compression = None
+    if DICTATION_SILENCE_MS > 0:
+        compressed = await asyncio.to_thread(compress_silence, data, DICTATION_SILENCE_MS, DICTATION_SILENCE_KEEP_MS)
+        if compressed is not None:
+            data, compression = compressed
+            removed = (compression.original_frames - compression.compressed_frames) / compression.rate
+            if removed:
+                _SILENCE_REMOVED.inc(removed)
+                logger.info("Dictation: removed %.1fs of silence in %s pauses", removed, compression.silences)
+            if on_compressed is not None:
+                on_compressed(compression)
+    parts = split_wav(data, DICTATION_SEGMENT_SECONDS)
+    mime_type = "audio/wav" if parts is not None else (file.content_type or "audio/wav")
+    if parts is None:
+        parts = [(0.0, None, data)]
+    for index, (start, end, audio) in enumerate(parts):
+        if compression is not None:
+            start = compression.original_time(start)
+            end = compression.original_time(end) if end is not None else None
+        set_input_audio(wav_duration(audio[:4096]))
+        # One slot per segment so a long recording does not hold ASR capacity for its whole length.
+        async with pipeline_limiter.slot():
+            with observe_stage("asr"):
+                result = await transcribe_audio(
+                    audio_upload(audio, mime_type, file.filename or "audio"), language=language, request_id=request_id
+                )
+            raw = " ".join(result.text.split())
+            restored = await restore_punctuation(raw, language, request_id) if _should_restore(raw) else None
+        yield DictationSegment(
+            index=index,
+            start_seconds=round(start, 3),
+            end_seconds=round(end, 3) if end is not None else None,
+            raw_text=raw,
+            text=restored or raw,
+            restored=restored is not None,
+        )
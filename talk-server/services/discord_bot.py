@@ -0,0 +1,220 @@
+"""A Discord voice bot: it sits in a voice channel, answers what members say, and speaks the replies.
+
+With DWANI_DISCORD_BOT_TOKEN set, the server logs in at startup and joins the voice channel
+DWANI_DISCORD_CHANNEL_ID (one worker only: Discord keeps one voice connection per bot and guild).
+Each member's speech arrives as 48 kHz stereo PCM; it is downmixed to 16 kHz mono and fed to a
+ConversationStream of their own, the session manager behind /v1/conversation/stream, so turn
+detection, history and slots work as they do there. Each member is a session
+("discord:<guild id>:<user id>"); language, mode and agent come from DWANI_DISCORD_*. Replies
+are played into the channel one at a time, in the order they are ready. Needs the optional
+discord.py and discord-ext-voice-recv packages (requirements-discord.txt), and ffmpeg to play.
+"""
+import asyncio
+import base64
+import io
+from typing import Any, Awaitable, Callable, Dict, Optional, Tuple
+
+from prometheus_client import Counter
+
+from config import (
+    DISCORD_AGENT,
+    DISCORD_ALLOWED_USERS,
+    DISCORD_CHANNEL_ID,
+    DISCORD_LANGUAGE,
+    DISCORD_MODE,
+    FFMPEG_PATH,
+    logger,
+)
+from services.conversation_stream import ConversationStream
+from services.credentials import secret
+from services.ids import new_id
+from services.language_id import normalize_language
+from services.telephony import downmix
+from services.turn_detection import session_preset
+
+try:
+    import discord
+    from discord.ext import voice_recv
+except ImportError:  # requirements-discord.txt is optional
+    discord = voice_recv = None
+
+DISCORD_RATE = 48000
+SAMPLE_RATE = 16000
+
+_TURNS = Counter("dwani_discord_turns_total", "Turns heard in the Discord voice channel, by how they ended", ["status"])
+
+Play = Callable[[bytes], Awaitable[None]]
+
+
+def to_pipeline_pcm(pcm: bytes) -> bytes:
+    """Discord's 48 kHz stereo 16-bit PCM as the 16 kHz mono the turn detector expects."""
+    return downmix(pcm, DISCORD_RATE, 2, SAMPLE_RATE)
+
+
+class VoiceBridge:
+    """A voice channel's conversations: one ConversationStream per member, replies played in turn."""
+
+    def __init__(self, guild_id: int, play: Play):
+        self.guild_id = guild_id
+        self._play = play
+        self._streams: Dict[int, ConversationStream] = {}
+        self._replies: Dict[Tuple[int, int], bytearray] = {}
+        self._speaking = asyncio.Lock()
+        self._heard: "asyncio.Queue[Tuple[int, bytes]]" = asyncio.Queue()
+        self._listener: Optional[asyncio.Task] = None
+
+    def start(self) -> None:
+        self._listener = asyncio.create_task(self._listen())
+
+    def hear(self, user_id: int, pcm: bytes) -> None:
+        """Queue a member's audio, in the order it was received (call on the event loop)."""
+        self._heard.put_nowait((user_id, pcm))
+
+    async def feed(self, user_id: int, pcm: bytes) -> None:
+        if DISCORD_ALLOWED_USERS and str(user_id) not in DISCORD_ALLOWED_USERS:
+            return
+        stream = self._streams.get(user_id)
+        if stream is None:
+            stream = self._streams[user_id] = await self._open(user_id)
+        await stream.feed(to_pipeline_pcm(pcm))
+
+    async def close(self) -> None:
+        if self._listener is not None and not self._listener.done():
+            self._listener.cancel()
+            await asyncio.gather(self._listener, return_exceptions=True)
+        for stream in self._streams.values():
+            await stream.close()
+        self._streams.clear()
+
+    async def _listen(self) -> None:
+        while True:
+            user_id, pcm = await self._heard.get()
+            try:
+                await self.feed(user_id, pcm)
+            except Exception as exc:
+                logger.error("Discord audio from user %s could not be handled: %s", user_id, exc)
+
+    async def _open(self, user_id: int) -> ConversationStream:
+        session_id = f"discord:{self.guild_id}:{user_id}"
+
+        async def send(event: Dict[str, Any]) -> None:
+            await self._on_event(user_id, event)
+
+        stream = ConversationStream(
+            send,
+            session_preset(session_id),
+            session_id,
+            sample_rate=SAMPLE_RATE,
+            language=normalize_language(DISCORD_LANGUAGE),
+            mode=DISCORD_MODE,
+            agent_name=DISCORD_AGENT or None,
+            request_id=new_id("request"),
+        )
+        await stream.start()
+        return stream
+
+    async def _on_event(self, user_id: int, event: Dict[str, Any]) -> None:
+        key = (user_id, event.get("turn"))
+        if event["type"] == "audio_chunk":
+            self._replies.setdefault(key, bytearray()).extend(base64.b64decode(event["audio_base64"]))
+        elif event["type"] == "error":
+            logger.warning("Discord turn %s from user %s failed: %s", event["turn"], user_id, event["message"])
+        elif event["type"] == "turn_end":
+            audio = bytes(self._replies.pop(key, b""))
+            _TURNS.labels(status=event["status"]).inc()
+            if audio and event["status"] == "completed":
+                async with self._speaking:
+                    await self._play(audio)
+
+
+if voice_recv is not None:
+    class _Sink(voice_recv.AudioSink):
+        """Hands decoded member audio from the voice receive thread to the bridge."""
+
+        def __init__(self, bridge: VoiceBridge, loop: asyncio.AbstractEventLoop):
+            super().__init__()
+            self.bridge = bridge
+            self.loop = loop
+
+        def wants_opus(self) -> bool:
+            return False
+
+        def write(self, user: Any, data: Any) -> None:
+            if user is not None and data.pcm:
+                self.loop.call_soon_threadsafe(self.bridge.hear, user.id, data.pcm)
+
+        def cleanup(self) -> None:
+            pass
+
+
+class DiscordVoiceBot:
+    """The Discord client, and the bridge for the voice channel it joins once logged in."""
+
+    def __init__(self, token: str):
+        self.token = token
+        self.client = discord.Client(intents=discord.Intents.default())
+        self.client.event(self.on_ready)
+        self.voice: Optional[Any] = None
+        self.bridge: Optional[VoiceBridge] = None
+
+    async def on_ready(self) -> None:
+        if self.voice is not None:  # on_ready fires again after a reconnect
+            return
+        channel = self.client.get_channel(DISCORD_CHANNEL_ID)
+        if not isinstance(channel, discord.VoiceChannel):
+            logger.error("DWANI_DISCORD_CHANNEL_ID %s is not a voice channel the bot can see", DISCORD_CHANNEL_ID)
+            return
+        self.voice = await channel.connect(cls=voice_recv.VoiceRecvClient)
+        self.bridge = VoiceBridge(channel.guild.id, self.play)
+        self.bridge.start()
+        self.voice.listen(_Sink(self.bridge, asyncio.get_running_loop()))
+        logger.info("Discord bot joined voice channel %s", channel.name)
+
+    async def play(self, audio: bytes) -> None:
+        """Play reply audio into the channel, returning when it has finished."""
+        loop = asyncio.get_running_loop()
+        done = asyncio.Event()
+        self.voice.play(
+            discord.FFmpegPCMAudio(io.BytesIO(audio), pipe=True, executable=FFMPEG_PATH),
+            after=lambda error: loop.call_soon_threadsafe(done.set),
+        )
+        await done.wait()
+
+    async def close(self) -> None:
+        if self.bridge is not None:
+            await self.bridge.close()
+        if self.voice is not None:
+            await self.voice.disconnect(force=True)
+        await self.client.close()
+
+
+_BOT: Optional[DiscordVoiceBot] = None
+_RUNNER: Optional[asyncio.Task] = None
+
+
+async def start() -> None:
+    """Log in and join the voice channel; nothing without a bot token."""
+    global _BOT, _RUNNER
+    token = secret("DWANI_DISCORD_BOT_TOKEN")
+    if not token:
+        return
+    if discord is None or voice_recv is None:
+        logger.error("DWANI_DISCORD_BOT_TOKEN is set but discord.py is missing: pip install -r requirements-discord.txt")
+        return
+    if not DISCORD_CHANNEL_ID:
+        logger.error("DWANI_DISCORD_BOT_TOKEN is set without DWANI_DISCORD_CHANNEL_ID to join")
+        return
+    _BOT = DiscordVoiceBot(token)
+    _RUNNER = asyncio.create_task(_BOT.client.start(token))
+    logger.info("Discord bot is logging in")
+
+
+async def stop() -> None:
+    global _BOT, _RUNNER
+    if _BOT is not None:
+        await _BOT.close()
+        _BOT = None
+    if _RUNNER is not None:
+        _RUNNER.cancel()
+        await asyncio.gather(_RUNNER, return_exceptions=True)
+        _RUNNER = None
@@ -0,0 +1,151 @@
+"""Backend instances discovered from DNS SRV records or the Consul catalog.
+
+An entry of DWANI_CHAT_COMPLETIONS_URL, DWANI_API_BASE_URL_LLM, DWANI_API_BASE_URL_TTS or
+DWANI_VISION_URL (or a tenant's backend URL) may name a service instead of one instance:
+
+- srv+http://_llm._tcp.example.internal/v1: the SRV record's targets with the lowest priority,
+  as http://<target>:<port>/v1 (srv+https for TLS).
+- consul+http://llm/v1: the instances of service "llm" passing their health checks, from
+  DWANI_CONSUL_URL with DWANI_CONSUL_TOKEN.
+
+The balancer (services/balancer.py) spreads requests over the instances found. Services named
+in the environment are looked up at startup, and every service in use again every
+DWANI_DISCOVERY_REFRESH_SECONDS; instances that appear or go keep the health record of those
+that stay. A failed lookup keeps the last instances found, and until a service has been found
+its requests fail with 503.
+"""
+import asyncio
+import os
+from typing import Dict, List, Optional, Set, Tuple
+from urllib.parse import quote, urlsplit
+
+from prometheus_client import Counter, Gauge
+
+from config import CONSUL_URL, DISCOVERY_REFRESH_SECONDS, logger
+from services.credentials import secret
+from services.http_client import http_client
+
+SCHEMES = ("srv+", "consul+")
+_URL_VARIABLES = ("DWANI_CHAT_COMPLETIONS_URL", "DWANI_API_BASE_URL_LLM", "DWANI_API_BASE_URL_TTS", "DWANI_VISION_URL")
+_LOOKUPS = Counter("dwani_discovery_lookups_total", "Backend discovery lookups", ["source", "outcome"])
+_INSTANCES = Gauge("dwani_discovery_instances", "Backend instances a discovered service has", ["service"])
+
+_found: Dict[str, Tuple[str, ...]] = {}
+_watched: Set[str] = set()
+_refresher: Optional[asyncio.Task] = None
+
+
+def is_discovered(url: str) -> bool:
+    return url.lower().startswith(SCHEMES)
+
+
+def _parse(spec: str) -> Tuple[str, str, str, str]:
+    """(source, scheme, service name, path) of a discovery URL; ValueError when it names no service."""
+    source, _, rest = spec.partition("+")
+    parts = urlsplit(rest)
+    if parts.scheme not in ("http", "https") or not parts.hostname:
+        raise ValueError(f"Backend discovery URL {spec!r} must look like {source}+http://<service>/<path>")
+    return source.lower(), parts.scheme, parts.hostname, parts.path.rstrip("/")
+
+
+async def _srv(name: str) -> List[Tuple[str, int]]:
+    import dns.asyncresolver
+
+    answer = await dns.asyncresolver.resolve(name, "SRV")
+    records = list(answer)
+    if not records:
+        return []
+    best = min(record.priority for record in records)
+    chosen = sorted((r for r in records if r.priority == best), key=lambda r: -r.weight)
+    return [(str(r.target).rstrip("."), r.port) for r in chosen]
+
+
+async def _consul(name: str) -> List[Tuple[str, int]]:
+    token = secret("DWANI_CONSUL_TOKEN")
+    response = await http_client().get(
+        f"{CONSUL_URL.rstrip('/')}/v1/health/service/{quote(name)}",
+        params={"passing": "true"},
+        headers={"X-Consul-Token": token} if token else {},
+    )
+    response.raise_for_status()
+    return [
+        (entry["Service"].get("Address") or entry["Node"]["Address"], int(entry["Service"]["Port"]))
+        for entry in response.json()
+    ]
+
+
+async def resolve(spec: str) -> Tuple[str, ...]:
+    """The instance URLs spec names now; raises when the lookup fails."""
+    source, scheme, name, path = _parse(spec)
+    if source == "srv":
+        targets = await _srv(name)
+    elif source == "consul":
+        targets = await _consul(name)
+    else:
+        raise ValueError(f"Unknown backend discovery source in {spec!r}; expected one of {list(SCHEMES)}")
+    return tuple(f"{scheme}://{host}:{port}{path}" for host, port in targets)
+
+
+def instances(spec: str) -> Tuple[str, ...]:
+    """The instances last found for spec (none before its first lookup); spec is looked up from now on."""
+    _watched.add(spec)
+    return _found.get(spec, ())
+
+
+def expand(urls: Tuple[str, ...]) -> Tuple[str, ...]:
+    """urls with each discovery URL replaced by its instances."""
+    expanded: List[str] = []
+    for url in urls:
+        expanded.extend(instances(url) if is_discovered(url) else (url,))
+    return tuple(dict.fromkeys(expanded))
+
+
+async def refresh() -> None:
+    """Look up every watched service and move the balancer's pools to the instances found."""
+    from services.balancer import refresh_pools
+
+    for spec in sorted(_watched):
+        source = spec.partition("+")[0].lower()
+        try:
+            found = await resolve(spec)
+        except Exception as exc:  # HTTP errors, a bad Consul answer, dnspython's NXDOMAIN, NoAnswer and timeouts
+            _LOOKUPS.labels(source=source, outcome="error").inc()
+            logger.warning("Backend discovery for %s failed, keeping %s instances: %s", spec, len(_found.get(spec, ())), exc)
+            continue
+        _LOOKUPS.labels(source=source, outcome="ok").inc()
+        if found != _found.get(spec):
+            logger.info("Backend discovery: %s has %s instances", spec, len(found), extra={"instances": list(found)})
+        _found[spec] = found
+        _INSTANCES.labels(service=spec).set(len(found))
+    refresh_pools()
+
+
+async def _refresh_forever() -> None:
+    while True:
+        await asyncio.sleep(DISCOVERY_REFRESH_SECONDS)
+        if _watched:
+            await refresh()
+
+
+async def start() -> None:
+    """Look up the services the environment names, then keep refreshing those in use."""
+    global _refresher
+    for variable in _URL_VARIABLES:
+        for url in (os.getenv(variable) or "").split(","):
+            url = url.strip().rstrip("/")
+            if is_discovered(url):
+                _parse(url)
+                _watched.add(url)
+    if _watched:
+        await refresh()
+    # Tenants' backends may name services too; they are watched once first used.
+    if DISCOVERY_REFRESH_SECONDS > 0:
+        _refresher = asyncio.create_task(_refresh_forever())
+
+
+async def stop() -> None:
+    global _refresher
+    if _refresher is not None:
+        _refresher.cancel()
+        await asyncio.gather(_refresher, return_exceptions=True)
+        _refresher = None
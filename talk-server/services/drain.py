@@ -0,0 +1,88 @@
+"""Draining before shutdown, so a rolling update does not cut off turns in progress.
+
+Kubernetes sends SIGTERM while the load balancer may still route to the pod. A preStop hook that
+calls POST /admin/drain (or runs `python talk.py drain`) first turns /ready into a 503, so the
+pod leaves the endpoints, then waits up to DWANI_DRAIN_TIMEOUT seconds for the pipeline requests
+(/v1/...) in flight to finish, streamed replies included. Requests that still arrive are served.
+
+The draining flag is the file DWANI_DRAIN_FILE, holding the Gunicorn master's PID, so every worker
+of the pod reports it while a file left by an earlier run does not count. In-flight requests are
+counted per worker: the drain call waits for its own worker's, and Gunicorn's graceful shutdown
+(DWANI_GRACEFUL_TIMEOUT) for the rest.
+"""
+import asyncio
+import os
+import time
+from contextlib import contextmanager
+from typing import AsyncIterator, Iterator
+
+from config import DRAIN_FILE, logger
+
+_in_flight = 0
+_draining = False
+
+
+def _owner() -> str:
+    # Workers share the master as parent; a single `uvicorn main:app` process is its own owner.
+    return str(os.getppid())
+
+
+def draining() -> bool:
+    if _draining:
+        return True
+    try:
+        with open(DRAIN_FILE, encoding="utf-8") as f:
+            return f.read().strip() == _owner()
+    except OSError:
+        return False
+
+
+def start_drain() -> None:
+    """Report not ready from every worker of this server."""
+    global _draining
+    _draining = True
+    try:
+        with open(DRAIN_FILE, "w", encoding="utf-8") as f:
+            f.write(_owner())
+    except OSError as exc:
+        logger.warning("Could not write %s; only this worker reports draining: %s", DRAIN_FILE, exc)
+
+
+def stop_drain() -> None:
+    """Report ready again (a drain called by mistake, or a preStop hook that was cancelled)."""
+    global _draining
+    _draining = False
+    try:
+        os.remove(DRAIN_FILE)
+    except OSError:
+        pass
+
+
+def in_flight() -> int:
+    return _in_flight
+
+
+@contextmanager
+def tracked() -> Iterator[None]:
+    """Count a pipeline request as in flight until the block exits."""
+    global _in_flight
+    _in_flight += 1
+    try:
+        yield
+    finally:
+        _in_flight -= 1
+
+
+async def tracked_body(body: AsyncIterator[bytes]) -> AsyncIterator[bytes]:
+    """body, with its request counted as in flight until the last chunk is sent."""
+    with tracked():
+        async for chunk in body:
+            yield chunk
+
+
+async def wait_idle(timeout: float, poll: float = 0.1) -> bool:
+    """Wait up to timeout seconds for this worker's in-flight requests; whether they all finished."""
+    deadline = time.monotonic() + max(0.0, timeout)
+    while _in_flight > 0 and time.monotonic() < deadline:
+        await asyncio.sleep(poll)
+    return _in_flight == 0
@@ -0,0 +1,66 @@
+"""Requests to URLs callers choose: read-aloud pages, input audio, job webhooks.
+
+check_host refuses URLs that are not http(s) or whose host resolves to a private, loopback or
+link-local address. Checking is not enough on its own: the HTTP client would look the name up
+again when it connects, and a host whose DNS answer changes in between (rebinding: a public
+address for the check, 127.0.0.1 or 169.254.169.254 for the connection) would get through.
+PinnedTransport checks every request it sends, each redirect hop included, and connects to the
+address it checked, with the URL's host still sent as Host and used for TLS (SNI and the
+certificate check).
+"""
+import asyncio
+import ipaddress
+import socket
+from typing import Optional
+from urllib.parse import urlparse
+
+import httpx
+from fastapi import HTTPException
+
+
+async def check_host(url: str, allow_private: bool = False, name: str = "url") -> Optional[str]:
+    """400 unless url is http(s) and its host resolves only to public addresses (or allow_private).
+
+    Returns the checked address to connect to; None with allow_private.
+    """
+    parsed = urlparse(url)
+    if parsed.scheme not in {"http", "https"} or not parsed.hostname:
+        raise HTTPException(status_code=400, detail=f"{name} must be an http(s) URL")
+    if allow_private:
+        return None
+    try:
+        infos = await asyncio.to_thread(socket.getaddrinfo, parsed.hostname, parsed.port or None)
+    except OSError:
+        raise HTTPException(status_code=400, detail=f"{name} host could not be resolved")
+    addresses = [info[4][0].split("%")[0] for info in infos]
+    if not addresses:
+        raise HTTPException(status_code=400, detail=f"{name} host could not be resolved")
+    for address in addresses:
+        if not ipaddress.ip_address(address).is_global:
+            raise HTTPException(status_code=400, detail=f"{name} must point to a public host")
+    return addresses[0]
+
+
+class PinnedTransport(httpx.AsyncBaseTransport):
+    """Sends each request to the address check_host approved for its host; 400s as check_host does.
+
+    Use it with follow_redirects=False and follow redirects by hand, so every hop goes through it.
+    """
+
+    def __init__(
+        self, allow_private: bool = False, name: str = "url", transport: Optional[httpx.AsyncBaseTransport] = None
+    ):
+        self.allow_private = allow_private
+        self.name = name
+        self._transport = transport or httpx.AsyncHTTPTransport()
+
+    async def handle_async_request(self, request: httpx.Request) -> httpx.Response:
+        address = await check_host(str(request.url), self.allow_private, self.name)
+        if address is not None:
+            # The Host header was set from the URL when the request was built.
+            request.extensions = {**request.extensions, "sni_hostname": request.url.host}
+            request.url = request.url.copy_with(host=address)
+        return await self._transport.handle_async_request(request)
+
+    async def aclose(self) -> None:
+        await self._transport.aclose()
@@ -0,0 +1,43 @@
+"""Erasure of everything stored about one session, for data-subject deletion requests.
+
+DELETE /v1/sessions/{id} (routers/session.py) removes at once, without a grace period:
+
+- the session's history, slots and settings from the session store (services/session.py);
+- its stored conversation turns (DWANI_STORE_CONVERSATIONS=1), unless the conversation is under
+  legal hold (conversation_store.LegalHold, a 409), in which case nothing is erased;
+- its request recordings (DWANI_RECORD_ARTIFACTS=1, services/recorder.py).
+
+Only the caller's tenant's session is erased (services/tenants.py), even when another tenant
+uses the same session ID. Each erasure is logged with the session digest and what was removed,
+never the session ID. Turns already exported to Parquet (services/exporter.py) and audit logs are not recalled.
+"""
+from typing import Any, Dict
+
+from prometheus_client import Counter
+
+import conversation_store
+from config import STORE_CONVERSATIONS, logger
+from services.recorder import recorder
+from services.session import delete_session, session_digest
+from services.tenants import current_tenant_name
+
+_ERASURES = Counter("dwani_session_erasures_total", "Sessions erased on request, by outcome", ["outcome"])
+
+
+def erase_session(session_id: str) -> Dict[str, Any]:
+    """Erase the session everywhere it is stored; what was removed. LegalHold leaves everything in place."""
+    key = session_digest(session_id)
+    flags = conversation_store.conversation_flags(key) if STORE_CONVERSATIONS else None
+    # Checked before anything is erased, so a held session is left whole.
+    if flags is not None and flags.legal_hold:
+        _ERASURES.labels(outcome="held").inc()
+        raise conversation_store.LegalHold(key)
+    erased = {
+        "session": delete_session(session_id),
+        "conversation_turns": conversation_store.erase_conversation(key) if STORE_CONVERSATIONS else 0,
+        "recordings": recorder.delete_session(session_id, current_tenant_name()),
+    }
+    _ERASURES.labels(outcome="erased" if any(erased.values()) else "not_found").inc()
+    logger.warning("Session erased on request", extra={"session_key": key, "erased": erased})
+    return erased
+
@@ -0,0 +1,246 @@
+"""Machine-readable error codes, and their messages in the request's language.
+
+Every error envelope carries error.error_code next to the HTTP status in error.code, and the
+streamed (NDJSON, WebSocket) error events and failed jobs carry it at the top level. The codes:
+
+    asr_unavailable  asr_timeout  asr_failed      llm_unavailable  llm_timeout  llm_failed
+    tts_unavailable  tts_timeout  tts_failed      <stage>_unavailable|_timeout|_failed for
+    no_speech_detected  empty_input  file_too_large  the other stages (vision, agent, ...)
+    unsupported_audio (an upload that is not audio in an accepted format; services/uploads.py)
+    invalid_request  unauthorized  forbidden  not_found  rate_limited  server_busy  internal_error
+    feature_disabled (a feature flag is off for the caller; services/flags.py)
+
+error.message stays the English detail; error.localized_message is a short message for the user,
+in English, Kannada, Hindi or Tamil, with error.language naming which. The language is the
+request's language parameter once it has been read (services/language_id.parse_language), else
+the first supported language of Accept-Language, else English.
+"""
+from contextvars import ContextVar
+from typing import Any, Dict, Optional
+
+from fastapi import HTTPException
+
+from models import language_alias
+from services.errors import StageError
+
+DEFAULT_LANGUAGE = "english"
+
+CATALOG: Dict[str, Dict[str, str]] = {
+    "asr_unavailable": {
+        "english": "Speech recognition is not available right now. Please try again shortly.",
+        "kannada": "ಧ್ವನಿ ಗುರುತಿಸುವಿಕೆ ಸೇವೆ ಈಗ ಲಭ್ಯವಿಲ್ಲ. ದಯವಿಟ್ಟು ಸ್ವಲ್ಪ ಸಮಯದ ನಂತರ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "वाक् पहचान सेवा अभी उपलब्ध नहीं है। कृपया थोड़ी देर बाद फिर कोशिश कीजिए।",
+        "tamil": "பேச்சு அறிதல் சேவை இப்போது கிடைக்கவில்லை. சிறிது நேரம் கழித்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "asr_timeout": {
+        "english": "Speech recognition took too long. Please try again.",
+        "kannada": "ಧ್ವನಿ ಗುರುತಿಸುವಿಕೆಗೆ ತುಂಬಾ ಸಮಯ ಹಿಡಿಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "वाक् पहचान में बहुत समय लग गया। कृपया फिर कोशिश कीजिए।",
+        "tamil": "பேச்சு அறிதலுக்கு அதிக நேரம் ஆனது. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "asr_failed": {
+        "english": "Your speech could not be recognised. Please try again.",
+        "kannada": "ನಿಮ್ಮ ಮಾತನ್ನು ಗುರುತಿಸಲು ಆಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "आपकी बात पहचानी नहीं जा सकी। कृपया फिर कोशिश कीजिए।",
+        "tamil": "உங்கள் பேச்சை அறிய முடியவில்லை. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "llm_unavailable": {
+        "english": "The assistant is not available right now. Please try again shortly.",
+        "kannada": "ಸಹಾಯಕ ಈಗ ಲಭ್ಯವಿಲ್ಲ. ದಯವಿಟ್ಟು ಸ್ವಲ್ಪ ಸಮಯದ ನಂತರ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "सहायक अभी उपलब्ध नहीं है। कृपया थोड़ी देर बाद फिर कोशिश कीजिए।",
+        "tamil": "உதவியாளர் இப்போது கிடைக்கவில்லை. சிறிது நேரம் கழித்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "llm_timeout": {
+        "english": "The assistant took too long to answer. Please try again.",
+        "kannada": "ಸಹಾಯಕ ಉತ್ತರಿಸಲು ತುಂಬಾ ಸಮಯ ತೆಗೆದುಕೊಂಡಿತು. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "सहायक ने जवाब देने में बहुत समय लिया। कृपया फिर कोशिश कीजिए।",
+        "tamil": "உதவியாளர் பதிலளிக்க அதிக நேரம் எடுத்துக்கொண்டது. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "llm_failed": {
+        "english": "The assistant could not answer. Please try again.",
+        "kannada": "ಸಹಾಯಕನಿಗೆ ಉತ್ತರಿಸಲು ಆಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "सहायक जवाब नहीं दे सका। कृपया फिर कोशिश कीजिए।",
+        "tamil": "உதவியாளரால் பதிலளிக்க முடியவில்லை. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "tts_unavailable": {
+        "english": "Speech synthesis is not available right now. Please try again shortly.",
+        "kannada": "ಧ್ವನಿ ಸಂಶ್ಲೇಷಣೆ ಸೇವೆ ಈಗ ಲಭ್ಯವಿಲ್ಲ. ದಯವಿಟ್ಟು ಸ್ವಲ್ಪ ಸಮಯದ ನಂತರ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "आवाज़ बनाने की सेवा अभी उपलब्ध नहीं है। कृपया थोड़ी देर बाद फिर कोशिश कीजिए।",
+        "tamil": "குரல் உருவாக்கும் சேவை இப்போது கிடைக்கவில்லை. சிறிது நேரம் கழித்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "tts_timeout": {
+        "english": "Preparing the spoken reply took too long. Please try again.",
+        "kannada": "ಧ್ವನಿ ಉತ್ತರ ಸಿದ್ಧಪಡಿಸಲು ತುಂಬಾ ಸಮಯ ಹಿಡಿಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "बोला गया जवाब तैयार करने में बहुत समय लग गया। कृपया फिर कोशिश कीजिए।",
+        "tamil": "குரல் பதிலைத் தயாரிக்க அதிக நேரம் ஆனது. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "tts_failed": {
+        "english": "The reply could not be spoken. Please try again.",
+        "kannada": "ಉತ್ತರವನ್ನು ಧ್ವನಿಯಾಗಿ ಪರಿವರ್ತಿಸಲು ಆಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "जवाब को आवाज़ में नहीं बदला जा सका। कृपया फिर कोशिश कीजिए।",
+        "tamil": "பதிலைக் குரலாக மாற்ற முடியவில்லை. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    # Messages for <stage>_unavailable, _timeout and _failed of the stages without their own.
+    "unavailable": {
+        "english": "A service this request needs is not available right now. Please try again shortly.",
+        "kannada": "ಈ ವಿನಂತಿಗೆ ಬೇಕಾದ ಸೇವೆ ಈಗ ಲಭ್ಯವಿಲ್ಲ. ದಯವಿಟ್ಟು ಸ್ವಲ್ಪ ಸಮಯದ ನಂತರ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "इस अनुरोध के लिए ज़रूरी सेवा अभी उपलब्ध नहीं है। कृपया थोड़ी देर बाद फिर कोशिश कीजिए।",
+        "tamil": "இந்தக் கோரிக்கைக்குத் தேவையான சேவை இப்போது கிடைக்கவில்லை. சிறிது நேரம் கழித்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "timeout": {
+        "english": "This took too long. Please try again.",
+        "kannada": "ಇದಕ್ಕೆ ತುಂಬಾ ಸಮಯ ಹಿಡಿಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "इसमें बहुत समय लग गया। कृपया फिर कोशिश कीजिए।",
+        "tamil": "இதற்கு அதிக நேரம் ஆனது. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "failed": {
+        "english": "Something went wrong while handling this request. Please try again.",
+        "kannada": "ಈ ವಿನಂತಿಯನ್ನು ನಿರ್ವಹಿಸುವಾಗ ಏನೋ ತಪ್ಪಾಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "इस अनुरोध को संभालते समय कुछ गड़बड़ हो गई। कृपया फिर कोशिश कीजिए।",
+        "tamil": "இந்தக் கோரிக்கையைக் கையாளும்போது ஏதோ தவறு நடந்தது. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "no_speech_detected": {
+        "english": "No speech was heard in the audio. Please speak and try again.",
+        "kannada": "ಆಡಿಯೋದಲ್ಲಿ ಯಾವುದೇ ಮಾತು ಕೇಳಿಸಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಮಾತನಾಡಿ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "ऑडियो में कोई आवाज़ सुनाई नहीं दी। कृपया बोलकर फिर कोशिश कीजिए।",
+        "tamil": "ஒலிப்பதிவில் எந்தப் பேச்சும் கேட்கவில்லை. தயவுசெய்து பேசி மீண்டும் முயற்சிக்கவும்.",
+    },
+    "empty_input": {
+        "english": "Nothing was sent. Please send audio or text.",
+        "kannada": "ಏನೂ ಕಳುಹಿಸಲಾಗಿಲ್ಲ. ದಯವಿಟ್ಟು ಆಡಿಯೋ ಅಥವಾ ಪಠ್ಯ ಕಳುಹಿಸಿ.",
+        "hindi": "कुछ भी नहीं भेजा गया। कृपया ऑडियो या टेक्स्ट भेजिए।",
+        "tamil": "எதுவும் அனுப்பப்படவில்லை. தயவுசெய்து ஒலிப்பதிவு அல்லது உரையை அனுப்புங்கள்.",
+    },
+    "file_too_large": {
+        "english": "The file is too large.",
+        "kannada": "ಫೈಲ್ ತುಂಬಾ ದೊಡ್ಡದಾಗಿದೆ.",
+        "hindi": "फ़ाइल बहुत बड़ी है।",
+        "tamil": "கோப்பு மிகப் பெரியதாக உள்ளது.",
+    },
+    "unsupported_audio": {
+        "english": "This file is not audio we can use. Please send a recording in a common audio format.",
+        "kannada": "ಈ ಫೈಲ್ ನಾವು ಬಳಸಬಹುದಾದ ಆಡಿಯೋ ಅಲ್ಲ. ದಯವಿಟ್ಟು ಸಾಮಾನ್ಯ ಆಡಿಯೋ ಫಾರ್ಮ್ಯಾಟ್‌ನಲ್ಲಿ ರೆಕಾರ್ಡಿಂಗ್ ಕಳುಹಿಸಿ.",
+        "hindi": "यह फ़ाइल ऐसा ऑडियो नहीं है जिसे हम इस्तेमाल कर सकें। कृपया किसी आम ऑडियो फ़ॉर्मैट में रिकॉर्डिंग भेजिए।",
+        "tamil": "இந்தக் கோப்பு நாங்கள் பயன்படுத்தக்கூடிய ஒலிப்பதிவு அல்ல. தயவுசெய்து பொதுவான ஒலி வடிவத்தில் பதிவை அனுப்புங்கள்.",
+    },
+    "invalid_request": {
+        "english": "The request is not valid. Please check it and try again.",
+        "kannada": "ವಿನಂತಿ ಸರಿಯಾಗಿಲ್ಲ. ದಯವಿಟ್ಟು ಪರಿಶೀಲಿಸಿ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "अनुरोध सही नहीं है। कृपया जाँचकर फिर कोशिश कीजिए।",
+        "tamil": "கோரிக்கை சரியாக இல்லை. தயவுசெய்து சரிபார்த்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "unauthorized": {
+        "english": "Please sign in or use a valid API key.",
+        "kannada": "ದಯವಿಟ್ಟು ಸೈನ್ ಇನ್ ಮಾಡಿ ಅಥವಾ ಸರಿಯಾದ API ಕೀ ಬಳಸಿ.",
+        "hindi": "कृपया साइन इन कीजिए या सही API कुंजी इस्तेमाल कीजिए।",
+        "tamil": "தயவுசெய்து உள்நுழையுங்கள் அல்லது சரியான API விசையைப் பயன்படுத்துங்கள்.",
+    },
+    "forbidden": {
+        "english": "You are not allowed to do this.",
+        "kannada": "ಇದನ್ನು ಮಾಡಲು ನಿಮಗೆ ಅನುಮತಿ ಇಲ್ಲ.",
+        "hindi": "आपको यह करने की अनुमति नहीं है।",
+        "tamil": "இதைச் செய்ய உங்களுக்கு அனுமதி இல்லை.",
+    },
+    "feature_disabled": {
+        "english": "This feature is not available for your account yet.",
+        "kannada": "ಈ ಸೌಲಭ್ಯ ನಿಮ್ಮ ಖಾತೆಗೆ ಇನ್ನೂ ಲಭ್ಯವಿಲ್ಲ.",
+        "hindi": "यह सुविधा अभी आपके खाते के लिए उपलब्ध नहीं है।",
+        "tamil": "இந்த வசதி உங்கள் கணக்கிற்கு இன்னும் கிடைக்கவில்லை.",
+    },
+    "not_found": {
+        "english": "What you asked for was not found.",
+        "kannada": "ನೀವು ಕೇಳಿದ್ದು ಸಿಗಲಿಲ್ಲ.",
+        "hindi": "आपने जो माँगा वह नहीं मिला।",
+        "tamil": "நீங்கள் கேட்டது கிடைக்கவில்லை.",
+    },
+    "rate_limited": {
+        "english": "Too many requests. Please wait a moment and try again.",
+        "kannada": "ತುಂಬಾ ವಿನಂತಿಗಳು ಬಂದಿವೆ. ದಯವಿಟ್ಟು ಸ್ವಲ್ಪ ಕಾದು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "बहुत सारे अनुरोध आए हैं। कृपया थोड़ा रुककर फिर कोशिश कीजिए।",
+        "tamil": "அதிகமான கோரிக்கைகள் வந்துள்ளன. சற்று நேரம் காத்திருந்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "server_busy": {
+        "english": "The server is busy. Please try again shortly.",
+        "kannada": "ಸರ್ವರ್ ಈಗ ಕಾರ್ಯನಿರತವಾಗಿದೆ. ದಯವಿಟ್ಟು ಸ್ವಲ್ಪ ಸಮಯದ ನಂತರ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "सर्वर अभी व्यस्त है। कृपया थोड़ी देर बाद फिर कोशिश कीजिए।",
+        "tamil": "சேவையகம் இப்போது பரபரப்பாக உள்ளது. சிறிது நேரம் கழித்து மீண்டும் முயற்சிக்கவும்.",
+    },
+    "internal_error": {
+        "english": "Something went wrong on our side. Please try again.",
+        "kannada": "ನಮ್ಮ ಕಡೆಯಿಂದ ಏನೋ ತಪ್ಪಾಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "हमारी ओर से कुछ गड़बड़ हो गई। कृपया फिर कोशिश कीजिए।",
+        "tamil": "எங்கள் பக்கத்தில் ஏதோ தவறு நடந்தது. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+}
+
+_BY_STATUS = {
+    400: "invalid_request",
+    401: "unauthorized",
+    403: "forbidden",
+    404: "not_found",
+    410: "not_found",
+    413: "file_too_large",
+    415: "unsupported_audio",
+    429: "rate_limited",
+    503: "server_busy",
+}
+
+# The request's language as a one-item list, so a language read in a worker thread reaches the handler.
+_language: ContextVar[Optional[list]] = ContextVar("error_language", default=None)
+
+
+def _accepted(accept_language: Optional[str]) -> Optional[str]:
+    for entry in (accept_language or "").split(","):
+        language = str(language_alias(entry.split(";")[0].strip())).lower()
+        if language in CATALOG["internal_error"]:
+            return language
+    return None
+
+
+def start_error_language(accept_language: Optional[str]) -> None:
+    """Per request (main.py): errors are in the first supported language of Accept-Language until one is set."""
+    _language.set([_accepted(accept_language)])
+
+
+def set_error_language(language: Optional[str]) -> None:
+    """The request named language; its errors are said in it when it is in the catalog."""
+    holder = _language.get()
+    if holder is not None and language in CATALOG["internal_error"]:
+        holder[0] = language
+
+
+def error_language() -> str:
+    holder = _language.get()
+    return (holder[0] if holder else None) or DEFAULT_LANGUAGE
+
+
+def code_for_status(status_code: int) -> str:
+    if status_code in _BY_STATUS:
+        return _BY_STATUS[status_code]
+    return "internal_error" if status_code >= 500 else "invalid_request"
+
+
+def code_for(exc: Exception) -> str:
+    """The error code of an exception raised while serving a request."""
+    code = getattr(exc, "error_code", None)
+    if code:
+        return code
+    if isinstance(exc, StageError):
+        kind = "timeout" if exc.status_code == 504 else "unavailable" if exc.status_code == 503 else "failed"
+        return f"{exc.stage}_{kind}"
+    if isinstance(exc, HTTPException):
+        return code_for_status(exc.status_code)
+    return "internal_error"
+
+
+def localized_message(code: str, language: Optional[str] = None) -> str:
+    """code's message in language (default: the request's), falling back to English."""
+    messages = CATALOG.get(code) or CATALOG.get(code.rpartition("_")[2]) or CATALOG["internal_error"]
+    return messages.get(language or error_language()) or messages[DEFAULT_LANGUAGE]
+
+
+def error_fields(code: str, language: Optional[str] = None) -> Dict[str, Any]:
+    """error_code, localized_message and language, for an error envelope or event."""
+    if language not in CATALOG["internal_error"]:
+        language = error_language()
+    return {"error_code": code, "localized_message": localized_message(code, language), "language": language}
@@ -0,0 +1,48 @@
+"""Pipeline stage errors with stable public messages.
+
+Upstream error bodies and exception text can name internal hosts, models, or stack details,
+so they are logged and never returned; clients get the stage and a fixed message instead.
+"""
+from typing import Any, Dict, Optional
+
+from fastapi import HTTPException
+
+STAGE_NAMES = {"asr": "ASR", "llm": "LLM", "tts": "TTS", "agent": "Agent", "vision": "Vision", "moderation": "Moderation", "translation": "Translation", "storage": "Storage"}
+
+
+class StageError(HTTPException):
+    """Failure of one pipeline stage (asr, llm, tts, agent, vision, moderation, translation, storage); reported as error.stage.
+
+    details are added to error.details for clients that act on the failure; error_code overrides
+    the <stage>_timeout / _unavailable / _failed code derived from the status (services/error_codes.py).
+    """
+
+    def __init__(
+        self,
+        stage: str,
+        status_code: int = 502,
+        detail: Optional[str] = None,
+        headers: Optional[Dict[str, str]] = None,
+        details: Optional[Dict[str, Any]] = None,
+        error_code: Optional[str] = None,
+    ):
+        self.stage = stage
+        self.details = details or {}
+        self.error_code = error_code
+        super().__init__(
+            status_code=status_code,
+            detail=detail or f"{STAGE_NAMES.get(stage, stage)} service error",
+            headers=headers,
+        )
+
+
+class RequestError(HTTPException):
+    """A request the server cannot serve, with an error code more specific than its status (services/error_codes.py).
+
+    details are added to error.details, e.g. the hints of no_speech_detected (services/speech_hints.py).
+    """
+
+    def __init__(self, status_code: int, error_code: str, detail: str, details: Optional[Dict[str, Any]] = None):
+        self.error_code = error_code
+        self.details = details or {}
+        super().__init__(status_code=status_code, detail=detail)
@@ -0,0 +1,69 @@
+"""CloudEvents 1.0 envelopes for webhook (and future queue) delivery.
+
+DWANI_CLOUDEVENTS_MODE selects "structured" (JSON envelope, application/cloudevents+json)
+or "binary" (ce-* headers, data as the body).
+"""
+import os
+from datetime import datetime, timezone
+from typing import Any, Dict, Optional
+
+from config import LLM_TIMEOUT, logger
+from services.experiments import current_tags
+from services.http_client import webhook_client
+from services.ids import current_request_id, new_id
+from services.retry import retry_async
+
+EVENT_TYPE_PREFIX = "ai.dwani.talk"
+EVENT_SOURCE = os.getenv("DWANI_EVENT_SOURCE", "/talk-server").strip() or "/talk-server"
+CLOUDEVENTS_MODE = os.getenv("DWANI_CLOUDEVENTS_MODE", "structured").strip().lower()
+
+
+def cloud_event(event_type: str, subject: str, data: Dict[str, Any]) -> Dict[str, Any]:
+    """Build a CloudEvent; event_type is appended to the ai.dwani.talk prefix.
+
+    Events raised while serving a request (or running a job) carry its ID in the requestid
+    extension attribute, and its experiment, variant, provider, model and voice
+    (services/experiments.py) in extension attributes of those names, when set.
+    """
+    request_id = current_request_id()
+    return {
+        "specversion": "1.0",
+        "id": new_id("event"),
+        "source": EVENT_SOURCE,
+        "type": f"{EVENT_TYPE_PREFIX}.{event_type}",
+        "subject": subject,
+        "time": datetime.now(timezone.utc).isoformat().replace("+00:00", "Z"),
+        "datacontenttype": "application/json",
+        "data": data,
+        **({"requestid": request_id} if request_id else {}),
+        **{name: value for name, value in current_tags().items() if value},
+    }
+
+
+def _encode(event: Dict[str, Any]):
+    """Return (headers, json body) for the configured CloudEvents HTTP binding mode."""
+    if CLOUDEVENTS_MODE == "binary":
+        headers = {f"ce-{k}": str(v) for k, v in event.items() if k not in {"data", "datacontenttype"}}
+        headers["Content-Type"] = event.get("datacontenttype", "application/json")
+        return headers, event.get("data")
+    return {"Content-Type": "application/cloudevents+json"}, event
+
+
+async def deliver_webhook(url: str, event: Dict[str, Any], request_id: Optional[str] = None, pinned: bool = False) -> bool:
+    """POST event to url; pinned for URLs callers chose (webhook_client). False when it was not delivered."""
+    headers, body = _encode(event)
+    if request_id:
+        headers["X-Request-ID"] = request_id
+
+    async def _do():
+        return await webhook_client(pinned).post(url, json=body, headers=headers, timeout=LLM_TIMEOUT)
+
+    try:
+        resp = await retry_async(_do)
+    except Exception as exc:
+        logger.warning("Webhook delivery of %s failed: %s", event.get("type"), exc)
+        return False
+    if resp.status_code >= 400:
+        logger.warning("Webhook for %s returned %s", event.get("type"), resp.status_code)
+        return False
+    return True
@@ -0,0 +1,61 @@
+"""Experiment and backend tags on each turn, so exported analytics and webhook events can be segmented.
+
+A request names its experiment and variant in the X-Experiment and X-Experiment-Variant
+headers; DWANI_EXPERIMENT and DWANI_EXPERIMENT_VARIANT tag a whole deployment (e.g. a
+canary) instead. The stages add what served the turn: the LLM provider (its base URL's
+host) and model that answered, the fallback's when it did, and the TTS voice. The model is
+also returned in the X-LLM-Model response header. The tags are
+stored with conversation turns (and so in the Parquet export), kept on jobs, and sent as
+CloudEvents extension attributes on every webhook event. Values are at most 128 letters,
+digits and ".:/@+_-"; anything else is dropped.
+"""
+import re
+from contextvars import ContextVar
+from typing import Dict, Optional
+from urllib.parse import urlparse
+
+from config import EXPERIMENT, EXPERIMENT_VARIANT
+
+EXPERIMENT_HEADER = "X-Experiment"
+VARIANT_HEADER = "X-Experiment-Variant"
+MODEL_HEADER = "X-LLM-Model"
+TAGS = ("experiment", "variant", "provider", "model", "voice")
+_VALUE = re.compile(r"^[\w.:/@+-]{1,128}$")
+
+_tags: ContextVar[Optional[Dict[str, str]]] = ContextVar("dwani_turn_tags", default=None)
+
+
+def clean(value: Optional[str]) -> Optional[str]:
+    value = (value or "").strip()
+    return value if _VALUE.match(value) else None
+
+
+def start_tags(experiment: Optional[str] = None, variant: Optional[str] = None) -> Dict[str, str]:
+    """Start tagging this request (or job); both default to the deployment's."""
+    tags: Dict[str, str] = {}
+    _tags.set(tags)
+    tag_turn(experiment=clean(experiment) or clean(EXPERIMENT), variant=clean(variant) or clean(EXPERIMENT_VARIANT))
+    return tags
+
+
+def tag_turn(**values: Optional[str]) -> None:
+    """Set tags of the current request, e.g. tag_turn(voice="kn_female"); a no-op outside one."""
+    tags = _tags.get()
+    if tags is None:
+        return
+    for name, value in values.items():
+        if name not in TAGS:
+            raise ValueError(f"Unknown turn tag {name!r}; expected one of {TAGS}")
+        value = clean(value)
+        if value:
+            tags[name] = value
+
+
+def tag_llm(base_url: str, model: str) -> None:
+    tag_turn(provider=urlparse(base_url).hostname or base_url, model=model)
+
+
+def current_tags() -> Dict[str, Optional[str]]:
+    """Every tag of the current request, None where unset."""
+    tags = _tags.get() or {}
+    return {name: tags.get(name) for name in TAGS}
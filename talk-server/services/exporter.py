@@ -0,0 +1,152 @@
+"""Turn-level Parquet export for analytics warehouses (Athena, BigQuery, ...).
+
+Stored conversation turns (text and metrics, never audio) are written under
+DWANI_PARQUET_EXPORT_URI as date=YYYY-MM-DD/tenant=<tenant>/turns-<first>-<last>.parquet,
+where the deployment's own turns (and those stored before turns recorded it) are tenant=default.
+The URI may be a local path or anything pyarrow.fs understands (s3://, gs://).
+Run on a schedule in-process (DWANI_PARQUET_EXPORT_INTERVAL_SECONDS) or from cron:
+
+    python -m services.exporter
+"""
+import asyncio
+import os
+from collections import defaultdict
+from typing import Dict, List, Optional
+
+import conversation_store
+from config import logger
+from services.branding import DEFAULT_TENANT
+
+EXPORT_URI = os.getenv("DWANI_PARQUET_EXPORT_URI", "").strip()
+EXPORT_INTERVAL_SECONDS = int(os.getenv("DWANI_PARQUET_EXPORT_INTERVAL_SECONDS", "0") or 0)
+EXPORT_BATCH_SIZE = int(os.getenv("DWANI_PARQUET_EXPORT_BATCH_SIZE", "10000") or 10000)
+_WATERMARK = "parquet"
+
+_COLUMNS = [
+    "id",
+    "created_at",
+    "session_key",
+    "mode",
+    "agent_name",
+    "language",
+    "input_type",
+    "transcript",
+    "reply",
+    "latency_ms",
+    "transcript_chars",
+    "reply_chars",
+    "cost_usd",
+    "request_id",
+    "playback_bytes",
+    "playback_ms",
+    "playback_interrupted",
+    "experiment",
+    "variant",
+    "provider",
+    "model",
+    "voice",
+]
+
+
+def _schema():
+    import pyarrow as pa
+
+    return pa.schema([
+        ("id", pa.int64()),
+        ("created_at", pa.timestamp("ms", tz="UTC")),
+        ("session_key", pa.string()),
+        ("mode", pa.string()),
+        ("agent_name", pa.string()),
+        ("language", pa.string()),
+        ("input_type", pa.string()),
+        ("transcript", pa.string()),
+        ("reply", pa.string()),
+        ("latency_ms", pa.int64()),
+        ("transcript_chars", pa.int64()),
+        ("reply_chars", pa.int64()),
+        ("cost_usd", pa.float64()),
+        ("request_id", pa.string()),
+        ("playback_bytes", pa.int64()),
+        ("playback_ms", pa.int64()),
+        ("playback_interrupted", pa.bool_()),
+        ("experiment", pa.string()),
+        ("variant", pa.string()),
+        ("provider", pa.string()),
+        ("model", pa.string()),
+        ("voice", pa.string()),
+    ])
+
+
+def _write_partition(uri: str, partition: str, rows: List[Dict[str, object]]) -> str:
+    import pyarrow as pa
+    import pyarrow.parquet as pq
+    from pyarrow import fs as pafs
+
+    filesystem, base = pafs.FileSystem.from_uri(uri)
+    directory = f"{base.rstrip('/')}/{partition}"
+    filesystem.create_dir(directory, recursive=True)
+    # Named by id range: a batch exported twice overwrites the same file instead of duplicating rows.
+    path = f"{directory}/turns-{rows[0]['id']:012d}-{rows[-1]['id']:012d}.parquet"
+    table = pa.Table.from_pylist(rows, schema=_schema())
+    pq.write_table(table, path, filesystem=filesystem, compression="zstd")
+    return path
+
+
+def export_once(uri: str = EXPORT_URI, batch_size: int = EXPORT_BATCH_SIZE) -> int:
+    """Export turns newer than the watermark in batches; returns the number of turns written."""
+    if not uri:
+        raise ValueError("DWANI_PARQUET_EXPORT_URI is not set")
+    exported = 0
+    while True:
+        last_id = conversation_store.export_watermark(_WATERMARK)
+        turns = conversation_store.turns_after(last_id, batch_size)
+        if not turns:
+            return exported
+        partitions: Dict[str, List[Dict[str, object]]] = defaultdict(list)
+        for turn in turns:
+            day = turn.created_at.date().isoformat()
+            partitions[f"date={day}/tenant={turn.tenant or DEFAULT_TENANT}"].append({c: getattr(turn, c) for c in _COLUMNS})
+        for partition, rows in partitions.items():
+            _write_partition(uri, partition, rows)
+        if not conversation_store.advance_export_watermark(_WATERMARK, last_id, turns[-1].id):
+            logger.info("Parquet batch after id %s was exported concurrently", last_id)
+            continue
+        exported += len(turns)
+
+
+class ParquetExporter:
+    """Periodic export inside the server process; off unless interval and URI are set."""
+
+    def __init__(self, interval_seconds: int = EXPORT_INTERVAL_SECONDS, uri: str = EXPORT_URI):
+        self._interval = interval_seconds
+        self._uri = uri
+        self._task: Optional[asyncio.Task] = None
+
+    async def start(self) -> None:
+        if self._task is not None or self._interval <= 0 or not self._uri:
+            return
+        self._task = asyncio.create_task(self._run())
+
+    async def stop(self) -> None:
+        if self._task is None:
+            return
+        self._task.cancel()
+        await asyncio.gather(self._task, return_exceptions=True)
+        self._task = None
+
+    async def _run(self) -> None:
+        while True:
+            await asyncio.sleep(self._interval)
+            try:
+                count = await asyncio.to_thread(export_once, self._uri)
+                if count:
+                    logger.info("Exported %s conversation turns to Parquet", count)
+            except Exception as exc:
+                logger.warning("Parquet export failed: %s", exc)
+
+
+parquet_exporter = ParquetExporter()
+
+
+if __name__ == "__main__":
+    print(f"Exported {export_once()} turns")
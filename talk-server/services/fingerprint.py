@@ -0,0 +1,92 @@
+"""Duplicate-audio detection: the same clip uploaded again reuses what was made of it the first time.
+
+Automated test suites and retry loops send identical recordings over and over. With
+DWANI_FINGERPRINT_TTL_SECONDS set, each upload's fingerprint (the SHA-256 of its bytes, with the
+language, diarization and tenant that shape the transcript) is looked up before ASR; a clip
+transcribed within the TTL gets its transcript back without a backend call, whoever sent it.
+The after_asr hooks still run on it, so redaction and transliteration apply as usual. Only
+successful transcripts are kept, at most DWANI_FINGERPRINT_MAX_ENTRIES of them, oldest first.
+
+DWANI_FINGERPRINT_RESULTS=1 also reuses the full /v1/speech_to_speech response for the same
+clip from the same caller, with the same query, form fields and session, for the TTL (marked
+Idempotent-Replayed: true); the session then gets no second turn. Replay protection
+(services/replay.py) takes precedence when it is on. Entries live in process memory, per worker.
+"""
+import time
+from collections import OrderedDict
+from typing import Awaitable, Callable, Optional, Tuple
+
+from fastapi.responses import Response
+from prometheus_client import Counter
+
+from config import FINGERPRINT_MAX_ENTRIES, FINGERPRINT_RESULTS, FINGERPRINT_TTL_SECONDS
+from models import TranscriptionResponse
+from services.idempotency import IdempotencyCache, fingerprint
+from services.tenants import current_tenant_settings
+
+_HITS = Counter("dwani_fingerprint_hits_total", "Duplicate uploads answered from the fingerprint cache", ["kind"])
+
+
+def audio_fingerprint(upload: str, language: Optional[str], diarize: bool) -> Optional[str]:
+    """The cache key of an upload (its upload_digest) transcribed this way; None for an empty one."""
+    if not upload:
+        return None
+    tenant = current_tenant_settings()
+    return fingerprint("transcript", tenant.name if tenant else None, upload, language, str(diarize))
+
+
+class TranscriptCache:
+    """Transcripts by audio fingerprint, each kept for the TTL."""
+
+    def __init__(self, ttl_seconds: int = FINGERPRINT_TTL_SECONDS, max_entries: int = FINGERPRINT_MAX_ENTRIES):
+        self.ttl_seconds = ttl_seconds
+        self.max_entries = max_entries
+        self._entries: "OrderedDict[str, Tuple[float, TranscriptionResponse]]" = OrderedDict()
+
+    @property
+    def enabled(self) -> bool:
+        return self.ttl_seconds > 0 and self.max_entries > 0
+
+    def clear(self) -> None:
+        self._entries.clear()
+
+    def __len__(self) -> int:
+        return len(self._entries)
+
+    def get(self, key: Optional[str]) -> Optional[TranscriptionResponse]:
+        if not self.enabled or key is None:
+            return None
+        entry = self._entries.get(key)
+        if entry is None:
+            return None
+        if entry[0] <= time.monotonic():
+            del self._entries[key]
+            return None
+        self._entries.move_to_end(key)
+        _HITS.labels(kind="transcript").inc()
+        return entry[1].model_copy(deep=True)
+
+    def put(self, key: Optional[str], result: TranscriptionResponse) -> None:
+        if not self.enabled or key is None:
+            return
+        self._entries[key] = (time.monotonic() + self.ttl_seconds, result.model_copy(deep=True))
+        self._entries.move_to_end(key)
+        while len(self._entries) > self.max_entries:
+            self._entries.popitem(last=False)
+
+
+transcript_cache = TranscriptCache()
+result_cache = IdempotencyCache(ttl_seconds=FINGERPRINT_TTL_SECONDS, max_entries=FINGERPRINT_MAX_ENTRIES)
+
+
+def results_enabled() -> bool:
+    return FINGERPRINT_RESULTS and FINGERPRINT_TTL_SECONDS > 0
+
+
+async def reuse_result(key: Optional[str], respond: Callable[[], Awaitable[Response]]) -> Response:
+    """respond(), or the response it gave for key (a replay_key) within the TTL."""
+    if key is None:
+        return await respond()
+    if result_cache.holds(key):
+        _HITS.labels(kind="result").inc()
+    return await result_cache.run(key, key, respond)
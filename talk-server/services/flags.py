@@ -0,0 +1,131 @@
+"""Feature flags: experimental behaviors switched on per API key, so they can be rolled out gradually.
+
+Each flag in FLAGS is on unless DWANI_FEATURE_FLAGS says otherwise, as comma-separated name=on,
+name=off or name=N%. A percentage turns the flag on for about N in 100 API keys, always the same
+ones (a hash of the flag and the key picks them), and off for requests without a key.
+DWANI_FEATURE_FLAGS_FILE then turns flags on or off for particular API keys or tenants
+(services/tenants.py):
+
+    {"api_keys": {"beta-key-1": {"reply_segments": true}}, "tenants": {"acme": {"translation": false}}}
+
+An API key's setting wins over its tenant's, which wins over the deployment's. Each request is
+judged by the key it carries (main.py); a disabled feature answers 403 "feature_disabled".
+"""
+import hashlib
+import json
+from contextvars import ContextVar
+from typing import Any, Dict, Optional
+
+from config import FEATURE_FLAGS, FEATURE_FLAGS_FILE, logger
+from services.errors import RequestError
+from services.tenants import current_tenant_settings
+
+FLAGS: Dict[str, str] = {
+    "moderation": "content moderation of transcripts and replies, when it is configured",
+    "reply_segments": "format=segments on /v1/speech_to_speech",
+    "streaming_replies": "format=ndjson on /v1/speech_to_speech",
+    "translation": "input_language and output_language on /v1/speech_to_speech",
+}
+
+_api_key: ContextVar[Optional[str]] = ContextVar("dwani_flags_api_key", default=None)
+
+
+def _flag(name: Any, where: str) -> str:
+    if name not in FLAGS:
+        raise ValueError(f"{where}: unknown feature flag {name!r}, expected one of {sorted(FLAGS)}")
+    return name
+
+
+def parse_rollout(spec: str) -> Dict[str, float]:
+    """DWANI_FEATURE_FLAGS as flag -> percent of API keys it is on for; ValueError when malformed."""
+    rollout: Dict[str, float] = {}
+    for item in spec.split(","):
+        name, _, value = item.partition("=")
+        if not name.strip():
+            continue
+        name = _flag(name.strip().lower(), "DWANI_FEATURE_FLAGS")
+        value = value.strip().lower()
+        if value in ("on", "true", "1"):
+            rollout[name] = 100.0
+        elif value in ("off", "false", "0"):
+            rollout[name] = 0.0
+        elif value.endswith("%") and value[:-1].replace(".", "", 1).isdigit() and float(value[:-1]) <= 100:
+            rollout[name] = float(value[:-1])
+        else:
+            raise ValueError(f"DWANI_FEATURE_FLAGS: {name} must be on, off or a percentage, not {value!r}")
+    return rollout
+
+
+def _overrides(specs: Any, where: str) -> Dict[str, Dict[str, bool]]:
+    if not isinstance(specs, dict):
+        raise ValueError(f"{where} must be an object of name -> {{flag: true|false}}")
+    overrides: Dict[str, Dict[str, bool]] = {}
+    for owner, spec in specs.items():
+        if not isinstance(spec, dict) or not all(isinstance(value, bool) for value in spec.values()):
+            raise ValueError(f"{where}.{owner} must be an object of flag -> true|false")
+        overrides[owner] = {_flag(name, f"{where}.{owner}"): value for name, value in spec.items()}
+    return overrides
+
+
+class FeatureFlags:
+    """The deployment's rollouts and the per-key and per-tenant overrides on top of them."""
+
+    def __init__(
+        self,
+        rollout: Optional[Dict[str, float]] = None,
+        api_keys: Optional[Dict[str, Dict[str, bool]]] = None,
+        tenants: Optional[Dict[str, Dict[str, bool]]] = None,
+    ):
+        self.rollout = rollout or {}
+        self.api_keys = api_keys or {}
+        self.tenants = {name.strip().lower(): spec for name, spec in (tenants or {}).items()}
+
+    @classmethod
+    def load(cls, spec: str = FEATURE_FLAGS, path: str = FEATURE_FLAGS_FILE) -> "FeatureFlags":
+        """The flags of DWANI_FEATURE_FLAGS and DWANI_FEATURE_FLAGS_FILE (see the module docstring)."""
+        overrides: Dict[str, Any] = {}
+        if path:
+            with open(path, encoding="utf-8") as f:
+                overrides = json.load(f)
+            if not isinstance(overrides, dict) or set(overrides) - {"api_keys", "tenants"}:
+                raise ValueError(f"{path} must be an object with api_keys and/or tenants")
+            logger.info("Loaded feature flag overrides from %s", path)
+        return cls(
+            parse_rollout(spec),
+            _overrides(overrides.get("api_keys", {}), f"{path}: api_keys"),
+            _overrides(overrides.get("tenants", {}), f"{path}: tenants"),
+        )
+
+    def enabled(self, name: str, api_key: Optional[str], tenant: Optional[str] = None) -> bool:
+        _flag(name, "enabled")
+        if api_key and name in self.api_keys.get(api_key, {}):
+            return self.api_keys[api_key][name]
+        if tenant and name in self.tenants.get(tenant, {}):
+            return self.tenants[tenant][name]
+        percent = self.rollout.get(name, 100.0)
+        if percent >= 100:
+            return True
+        if percent <= 0 or not api_key:
+            return False
+        bucket = int.from_bytes(hashlib.sha256(f"{name}:{api_key}".encode("utf-8")).digest()[:8], "big") % 10000
+        return bucket < percent * 100
+
+
+flags = FeatureFlags.load()
+
+
+def use_flags(api_key: Optional[str]) -> None:
+    """Judge the flags of the rest of the current request, call or job by api_key (and its tenant)."""
+    _api_key.set(api_key)
+
+
+def enabled(name: str) -> bool:
+    """Whether flag name is on for the current request."""
+    tenant = current_tenant_settings()
+    return flags.enabled(name, _api_key.get(), tenant.name if tenant else None)
+
+
+def require(name: str) -> None:
+    """403 feature_disabled unless flag name is on for the current request."""
+    if not enabled(name):
+        raise RequestError(403, "feature_disabled", f"Feature {name!r} ({FLAGS[name]}) is not enabled for this API key")
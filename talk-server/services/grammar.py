@@ -0,0 +1,214 @@
+"""Closed grammars for constrained prompts ("Say yes or no", "Tell me your PIN", "Which city?").
+
+When a turn names a grammar, the transcript is matched against it instead of being sent to
+the LLM: a match is normalized to the grammar's canonical value and confirmed back; anything
+else gets the grammar's re-prompt. Built-in grammars are yes_no and digits; deployments add
+their own in DWANI_GRAMMARS_FILE, and clients may send one inline as JSON:
+
+    {"name": "city", "choices": {"Bengaluru": ["bangalore", "ಬೆಂಗಳೂರು"], "Mysuru": ["mysore"]},
+     "reprompt": "Please say Bengaluru or Mysuru.", "confirm": "{value}, got it."}
+    {"name": "pin", "type": "digits", "min_digits": 4, "max_digits": 6}
+
+Choice grammars match the whole utterance, then a choice said inside it ("yes please"), then
+a close spelling; an utterance naming two different choices is a mismatch. A grammar with a
+"slot" stores the matched value in that session slot (services/slots.py).
+"""
+import difflib
+import json
+import unicodedata
+from dataclasses import dataclass, field
+from typing import Any, Dict, Optional, Tuple, Union
+
+from fastapi import HTTPException
+
+from config import GRAMMARS_FILE, logger
+from services.messages import message
+from services.slots import SLOT_NAME
+
+GRAMMAR_TYPES = ("choice", "digits")
+_FUZZY_CUTOFF = 0.8
+
+_DIGIT_WORDS = {
+    # English
+    "zero": "0", "oh": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+    "five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+    # Hindi
+    "शून्य": "0", "एक": "1", "दो": "2", "तीन": "3", "चार": "4", "पांच": "5", "पाँच": "5",
+    "छह": "6", "छः": "6", "सात": "7", "आठ": "8", "नौ": "9",
+    "shunya": "0", "ek": "1", "do": "2", "teen": "3", "char": "4", "paanch": "5", "panch": "5",
+    "chhah": "6", "chhe": "6", "saat": "7", "aath": "8", "nau": "9",
+    # Kannada
+    "ಸೊನ್ನೆ": "0", "ಒಂದು": "1", "ಎರಡು": "2", "ಮೂರು": "3", "ನಾಲ್ಕು": "4", "ಐದು": "5",
+    "ಆರು": "6", "ಏಳು": "7", "ಎಂಟು": "8", "ಒಂಬತ್ತು": "9",
+    "sonne": "0", "ondu": "1", "eradu": "2", "mooru": "3", "naalku": "4", "aidu": "5",
+    "aaru": "6", "elu": "7", "entu": "8", "ombattu": "9",
+}
+_REPEAT_WORDS = {"double": 2, "triple": 3}
+
+
+def normalize(text: str) -> str:
+    """Lowercase, punctuation and symbols replaced by spaces (any script), whitespace collapsed."""
+    chars = [" " if unicodedata.category(ch)[0] in {"P", "S"} else ch for ch in text.lower()]
+    return " ".join("".join(chars).split())
+
+
+@dataclass(frozen=True)
+class GrammarMatch:
+    name: str
+    matched: bool
+    value: Optional[str] = None
+
+
+@dataclass
+class Grammar:
+    name: str
+    type: str = "choice"
+    # Canonical value -> normalized spoken forms (the value itself included).
+    choices: Dict[str, Tuple[str, ...]] = field(default_factory=dict)
+    min_digits: int = 1
+    max_digits: int = 32
+    reprompt: Union[str, Dict[str, str], None] = None
+    confirm: str = "{value}"
+    slot: Optional[str] = None  # session slot the matched value is stored in
+
+    def match(self, text: str) -> Optional[str]:
+        """Canonical value for text, or None when it does not fit the grammar."""
+        if self.type == "digits":
+            return self._match_digits(text)
+        return self._match_choice(normalize(text))
+
+    def _match_choice(self, said: str) -> Optional[str]:
+        if not said:
+            return None
+        for value, forms in self.choices.items():
+            if said in forms:
+                return value
+        padded = f" {said} "
+        found = {value for value, forms in self.choices.items() if any(f" {form} " in padded for form in forms)}
+        if len(found) == 1:
+            return found.pop()
+        if found:
+            return None  # "yes no": ambiguous
+        by_form = {form: value for value, forms in self.choices.items() for form in forms}
+        close = difflib.get_close_matches(said, list(by_form), n=1, cutoff=_FUZZY_CUTOFF)
+        return by_form[close[0]] if close else None
+
+    def _match_digits(self, text: str) -> Optional[str]:
+        digits = []
+        repeat = 1
+        for token in normalize(text).split():
+            if token in _REPEAT_WORDS:
+                repeat = _REPEAT_WORDS[token]
+                continue
+            if all(unicodedata.digit(ch, None) is not None for ch in token):
+                spoken = "".join(str(unicodedata.digit(ch)) for ch in token)
+            else:
+                spoken = _DIGIT_WORDS.get(token, "")
+            if spoken:
+                digits.append(spoken[0] * repeat + spoken[1:])  # "double five" -> 55
+            repeat = 1
+        value = "".join(digits)
+        return value if self.min_digits <= len(value) <= self.max_digits else None
+
+    def reprompt_text(self, language: Optional[str] = None) -> str:
+        if isinstance(self.reprompt, dict):
+            text = self.reprompt.get(language or "") or self.reprompt.get("english") or next(iter(self.reprompt.values()), "")
+            if text:
+                return text
+        elif self.reprompt:
+            return self.reprompt
+        if self.type == "digits":
+            return message("reprompt_digits", language)
+        options = list(self.choices)
+        if len(options) == 2:
+            return message("reprompt_either", language, first=options[0], second=options[1])
+        return message("reprompt_choices", language, options=", ".join(options[:6]))
+
+    def respond(self, text: str, language: Optional[str] = None) -> Tuple[GrammarMatch, str]:
+        """The match for text and the reply to speak: a confirmation or the re-prompt."""
+        value = self.match(text)
+        if value is None:
+            return GrammarMatch(self.name, False), self.reprompt_text(language)
+        return GrammarMatch(self.name, True, value), self.confirm.replace("{value}", value)
+
+
+def grammar_from_spec(spec: Dict[str, Any], name: Optional[str] = None) -> Grammar:
+    """Grammar from its JSON form (see the module docstring); ValueError when malformed."""
+    if not isinstance(spec, dict):
+        raise ValueError("grammar must be a JSON object")
+    kind = spec.get("type", "choice")
+    if kind not in GRAMMAR_TYPES:
+        raise ValueError(f"grammar type must be one of {GRAMMAR_TYPES}")
+    grammar = Grammar(
+        name=str(spec.get("name") or name or "inline"),
+        type=kind,
+        reprompt=spec.get("reprompt"),
+        confirm=str(spec.get("confirm") or "{value}"),
+        slot=spec.get("slot") or None,
+    )
+    if grammar.slot is not None and not (isinstance(grammar.slot, str) and SLOT_NAME.match(grammar.slot)):
+        raise ValueError("grammar 'slot' must be a slot name (letters, digits, underscores)")
+    if kind == "digits":
+        grammar.min_digits = int(spec.get("min_digits", 1))
+        grammar.max_digits = int(spec.get("max_digits", 32))
+        if not 1 <= grammar.min_digits <= grammar.max_digits:
+            raise ValueError("grammar needs 1 <= min_digits <= max_digits")
+        return grammar
+    choices = spec.get("choices")
+    if isinstance(choices, list):
+        choices = {str(value): [] for value in choices}
+    if not isinstance(choices, dict) or not choices:
+        raise ValueError("choice grammar needs a non-empty 'choices' list or object")
+    for value, synonyms in choices.items():
+        if not isinstance(synonyms, list):
+            raise ValueError(f"synonyms for {value!r} must be a list")
+        forms = tuple(dict.fromkeys(f for f in (normalize(str(s)) for s in [value, *synonyms]) if f))
+        if not forms:
+            raise ValueError(f"choice {value!r} has no speakable form")
+        grammar.choices[str(value)] = forms
+    return grammar
+
+
+BUILTIN_GRAMMARS: Dict[str, Grammar] = {
+    "yes_no": grammar_from_spec({
+        "name": "yes_no",
+        "choices": {
+            "yes": ["yeah", "yep", "yup", "sure", "correct", "right", "okay", "ok",
+                    "haan", "ha", "haa", "ji", "ji haan", "हाँ", "हां", "जी", "जी हाँ", "sari", "houdu", "ಹೌದು", "ಸರಿ"],
+            "no": ["nope", "nah", "wrong", "nahi", "nahin", "na", "नहीं", "ना", "illa", "beda", "ಇಲ್ಲ", "ಬೇಡ"],
+        },
+    }),
+    "digits": grammar_from_spec({"name": "digits", "type": "digits"}),
+}
+
+
+def load_grammars(path: str = GRAMMARS_FILE) -> Dict[str, Grammar]:
+    """Built-in grammars plus those defined in path, a JSON object of name -> spec."""
+    grammars = dict(BUILTIN_GRAMMARS)
+    if not path:
+        return grammars
+    with open(path, encoding="utf-8") as f:
+        specs = json.load(f)
+    for name, spec in specs.items():
+        grammars[name] = grammar_from_spec(spec, name=name)
+    logger.info("Loaded %s grammars from %s", len(specs), path)
+    return grammars
+
+
+grammars = load_grammars()
+
+
+def resolve_grammar(value: Optional[str]) -> Optional[Grammar]:
+    """Grammar named by a request (a known name or inline JSON); 400 when unknown or malformed."""
+    value = (value or "").strip()
+    if not value:
+        return None
+    if value.startswith("{"):
+        try:
+            return grammar_from_spec(json.loads(value))
+        except (json.JSONDecodeError, ValueError, TypeError) as exc:
+            raise HTTPException(status_code=400, detail=f"Invalid grammar: {exc}")
+    grammar = grammars.get(value)
+    if grammar is None:
+        raise HTTPException(status_code=400, detail=f"grammar must be one of {sorted(grammars)} or an inline JSON grammar")
+    return grammar
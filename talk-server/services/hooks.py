@@ -0,0 +1,139 @@
+"""Pipeline stage hooks: plug text normalization, logging, moderation, or routing into every turn.
+
+A hook is a function (sync or async) taking (ctx, value) and returning a replacement value,
+or None to keep it. Hooks run in registration order at six points:
+
+    before_asr  UploadFile about to be transcribed       after_asr  transcript (str)
+    before_llm  text about to be answered (str)          after_llm  reply (str)
+    before_tts  text about to be synthesized (str)       after_tts  TTSStream
+
+The ASR and TTS points run for every transcription and synthesis (turns, dictation,
+read-aloud); the LLM points wrap the reply stage of a conversational turn, LLM or agent.
+A before_llm hook can route the turn by changing ctx.mode / ctx.agent_name, or answer it
+itself by setting ctx.reply, which skips the LLM; an after_llm hook may set ctx.intent (see
+services/confirmation.py). Raising HTTPException stops the request
+with that error (e.g. moderation); any other exception fails it as a 500.
+
+Deployments load hooks without forking the server by listing "module:function" entries in
+DWANI_PIPELINE_PLUGINS; each function is called once at startup with the hook registry.
+"""
+import importlib
+import inspect
+from contextlib import contextmanager
+from contextvars import ContextVar
+from dataclasses import dataclass, field
+from typing import Any, Callable, Dict, Iterator, List, Optional
+
+from config import PIPELINE_PLUGINS, logger
+
+HOOK_POINTS = ("before_asr", "after_asr", "before_llm", "after_llm", "before_tts", "after_tts")
+
+Hook = Callable[["HookContext", Any], Any]
+
+
+@dataclass
+class HookContext:
+    """What hooks know about the request; metadata is free for hooks to share state in."""
+
+    request_id: Optional[str] = None
+    session_id: Optional[str] = None
+    language: Optional[str] = None
+    mode: Optional[str] = None
+    agent_name: Optional[str] = None
+    input_type: Optional[str] = None
+    reply: Optional[str] = None
+    intent: Optional[Dict[str, Any]] = None
+    slots: Dict[str, Any] = field(default_factory=dict)  # the session's slots; changes are saved after the turn
+    metadata: Dict[str, Any] = field(default_factory=dict)
+
+
+class PipelineHooks:
+    """Ordered hooks per point."""
+
+    def __init__(self):
+        self._hooks: Dict[str, List[Hook]] = {point: [] for point in HOOK_POINTS}
+
+    def register(self, point: str, hook: Hook) -> Hook:
+        if point not in self._hooks:
+            raise ValueError(f"Unknown pipeline hook point {point!r}; expected one of {HOOK_POINTS}")
+        self._hooks[point].append(hook)
+        return hook
+
+    def on(self, point: str) -> Callable[[Hook], Hook]:
+        """Decorator form of register()."""
+        return lambda hook: self.register(point, hook)
+
+    def registered(self, point: str) -> List[Hook]:
+        return list(self._hooks[point])
+
+    def clear(self) -> None:
+        for hooks in self._hooks.values():
+            hooks.clear()
+
+    def __bool__(self) -> bool:
+        return any(self._hooks.values())
+
+    async def run(self, point: str, ctx: HookContext, value: Any) -> Any:
+        for hook in self._hooks[point]:
+            result = hook(ctx, value)
+            if inspect.isawaitable(result):
+                result = await result
+            if result is not None:
+                value = result
+        return value
+
+
+hooks = PipelineHooks()
+_active: ContextVar[Optional[PipelineHooks]] = ContextVar("dwani_pipeline_hooks", default=None)
+_turn: ContextVar[Optional[HookContext]] = ContextVar("dwani_hook_context", default=None)
+
+
+@contextmanager
+def use_hooks(registry: Optional[PipelineHooks]) -> Iterator[None]:
+    """Run the current task with registry instead of the server-wide hooks (embedded Pipeline)."""
+    token = _active.set(registry)
+    try:
+        yield
+    finally:
+        _active.reset(token)
+
+
+def active_hooks() -> PipelineHooks:
+    registry = _active.get()
+    return registry if registry is not None else hooks
+
+
+@contextmanager
+def turn_context(ctx: HookContext) -> Iterator[HookContext]:
+    """Share ctx with the ASR hooks that run inside a conversational turn."""
+    token = _turn.set(ctx)
+    try:
+        yield ctx
+    finally:
+        _turn.reset(token)
+
+
+def current_context(request_id: Optional[str] = None, language: Optional[str] = None) -> HookContext:
+    """The enclosing turn's context, or a fresh one for stand-alone ASR/TTS calls."""
+    return _turn.get() or HookContext(request_id=request_id, language=language)
+
+
+async def run_hooks(point: str, ctx: HookContext, value: Any) -> Any:
+    registry = active_hooks()
+    if not registry:
+        return value
+    return await registry.run(point, ctx, value)
+
+
+def load_plugins(spec: str = PIPELINE_PLUGINS, registry: PipelineHooks = hooks) -> int:
+    """Import each "module:function" in spec and call it with registry; errors abort startup."""
+    loaded = 0
+    for entry in (item.strip() for item in (spec or "").split(",")):
+        if not entry:
+            continue
+        module_name, _, attr = entry.partition(":")
+        setup = getattr(importlib.import_module(module_name), attr or "register")
+        setup(registry)
+        loaded += 1
+        logger.info("Loaded pipeline plugin %s", entry)
+    return loaded
@@ -0,0 +1,102 @@
+"""Process-wide pooled httpx client shared by every upstream call.
+
+Creating a client per request throws away keep-alive connections (and HTTP/2 streams)
+to the ASR, LLM, TTS and agent backends. Callers pass per-request timeouts instead.
+In mock mode (services/mock.py) the client answers every request in-process.
+
+Webhooks get their own client (webhook_client): the backend CA and client certificate (mTLS)
+must never be presented to them. Webhooks to URLs callers chose (a job's webhook_url) go
+through a pinned one, which checks every host and connects to the address it checked.
+"""
+import ssl
+from typing import Dict, Optional, Union
+
+import httpx
+
+from config import (
+    BACKEND_CA_FILE,
+    BACKEND_CLIENT_CERT_FILE,
+    BACKEND_CLIENT_KEY_FILE,
+    HTTP2,
+    HTTP_KEEPALIVE_EXPIRY,
+    HTTP_MAX_CONNECTIONS,
+    HTTP_MAX_KEEPALIVE,
+    HTTP_PROXY,
+    LLM_TIMEOUT,
+    WEBHOOK_ALLOW_PRIVATE,
+    logger,
+)
+from services import mock
+from services.egress import PinnedTransport
+
+_client: Optional[httpx.AsyncClient] = None
+_webhook_clients: Dict[bool, httpx.AsyncClient] = {}
+
+
+def _http2_available() -> bool:
+    if not HTTP2:
+        return False
+    try:
+        import h2  # noqa: F401
+    except ImportError:
+        logger.warning("DWANI_HTTP2=1 but the h2 package is not installed; using HTTP/1.1")
+        return False
+    return True
+
+
+def _tls_verify() -> Union[bool, ssl.SSLContext]:
+    """Default verification, or a context with the backend CA and client certificate (mTLS)."""
+    if not (BACKEND_CA_FILE or BACKEND_CLIENT_CERT_FILE):
+        return True
+    context = ssl.create_default_context(cafile=BACKEND_CA_FILE)
+    if BACKEND_CLIENT_CERT_FILE:
+        context.load_cert_chain(BACKEND_CLIENT_CERT_FILE, keyfile=BACKEND_CLIENT_KEY_FILE)
+    return context
+
+
+def http_client() -> httpx.AsyncClient:
+    """Shared client, created on first use (and again after close_http_client)."""
+    global _client
+    if _client is None or _client.is_closed:
+        _client = httpx.AsyncClient(
+            timeout=LLM_TIMEOUT,
+            limits=httpx.Limits(
+                max_connections=HTTP_MAX_CONNECTIONS,
+                max_keepalive_connections=HTTP_MAX_KEEPALIVE,
+                keepalive_expiry=HTTP_KEEPALIVE_EXPIRY,
+            ),
+            proxy=HTTP_PROXY,
+            verify=_tls_verify(),
+            http2=_http2_available(),
+            transport=mock.transport() if mock.enabled() else None,
+        )
+    return _client
+
+
+def webhook_client(pinned: bool = False) -> httpx.AsyncClient:
+    """Client for webhook deliveries: default TLS verification, no backend certificate, no redirects.
+
+    pinned is for URLs callers chose: hosts must be public (unless DWANI_WEBHOOK_ALLOW_PRIVATE=1),
+    and the name is not looked up again between the check and the connection (services/egress.py).
+    """
+    client = _webhook_clients.get(pinned)
+    if client is None or client.is_closed:
+        transport, proxy = (mock.transport() if mock.enabled() else None), HTTP_PROXY
+        if pinned and transport is None:
+            # The proxy moves inside: a client-level one would be used instead of this transport.
+            transport = PinnedTransport(WEBHOOK_ALLOW_PRIVATE, "webhook_url", httpx.AsyncHTTPTransport(proxy=HTTP_PROXY))
+            proxy = None
+        client = _webhook_clients[pinned] = httpx.AsyncClient(
+            timeout=LLM_TIMEOUT, proxy=proxy, follow_redirects=False, transport=transport
+        )
+    return client
+
+
+async def close_http_client() -> None:
+    global _client
+    if _client is not None:
+        await _client.aclose()
+        _client = None
+    for client in _webhook_clients.values():
+        await client.aclose()
+    _webhook_clients.clear()
@@ -0,0 +1,147 @@
+"""Idempotency keys for /v1/speech_to_speech.
+
+Mobile clients on flaky networks resend an upload when the reply never arrived. With an
+Idempotency-Key header the first request runs the pipeline and its response is kept for
+DWANI_IDEMPOTENCY_TTL_SECONDS; a retry with the same key gets that response back (marked
+Idempotent-Replayed: true) instead of a second ASR, LLM and TTS run and a duplicate session
+turn. A retry that arrives while the first request is still running waits for it. Reusing a
+key for a different request is a 422. Only successful responses are kept, so a request that
+failed can be retried with the same key.
+
+Keys are scoped to the caller's credential. The response is buffered in full so that it is
+kept even when the client has already gone away, which means it is not streamed. Entries
+live in process memory: with several workers a retry only hits when it reaches the same one.
+"""
+import asyncio
+import hashlib
+import time
+from collections import OrderedDict
+from dataclasses import dataclass
+from typing import Awaitable, Callable, Dict, List, Optional, Tuple, Union
+
+from fastapi import HTTPException, Request
+from fastapi.responses import Response, StreamingResponse
+from prometheus_client import Counter
+
+from config import IDEMPOTENCY_MAX_ENTRIES, IDEMPOTENCY_TTL_SECONDS
+
+HEADER = "Idempotency-Key"
+REPLAYED_HEADER = "Idempotent-Replayed"
+MAX_KEY_LENGTH = 255
+
+_REQUESTS = Counter("dwani_idempotent_requests_total", "Requests with an Idempotency-Key by outcome", ["result"])
+
+
+@dataclass
+class StoredResponse:
+    fingerprint: str
+    status_code: int
+    headers: List[Tuple[str, str]]
+    body: bytes
+    expires_at: float
+
+    def response(self, replayed: bool) -> Response:
+        response = Response(self.body, status_code=self.status_code, headers=dict(self.headers))
+        response.headers[REPLAYED_HEADER] = "true" if replayed else "false"
+        return response
+
+
+def request_key(request: Request) -> Optional[str]:
+    """The Idempotency-Key scoped to the caller's credential and path; None without one."""
+    key = (request.headers.get(HEADER) or "").strip()
+    if not key:
+        return None
+    if len(key) > MAX_KEY_LENGTH:
+        raise HTTPException(status_code=400, detail=f"{HEADER} must be <= {MAX_KEY_LENGTH} characters")
+    credential = request.headers.get("X-API-Key") or request.headers.get("Authorization") or ""
+    return fingerprint(credential, request.url.path, key)
+
+
+def fingerprint(*parts: Union[str, bytes, None]) -> str:
+    digest = hashlib.sha256()
+    for part in parts:
+        data = part.encode("utf-8") if isinstance(part, str) else (part or b"")
+        digest.update(len(data).to_bytes(8, "big"))
+        digest.update(data)
+    return digest.hexdigest()
+
+
+async def _body(response: Response) -> bytes:
+    if not isinstance(response, StreamingResponse):
+        return bytes(response.body)
+    parts = []
+    async for chunk in response.body_iterator:
+        parts.append(chunk.encode("utf-8") if isinstance(chunk, str) else bytes(chunk))
+    return b"".join(parts)
+
+
+class IdempotencyCache:
+    """Finished responses by scoped key, oldest evicted first, plus the requests still running."""
+
+    def __init__(self, ttl_seconds: int = IDEMPOTENCY_TTL_SECONDS, max_entries: int = IDEMPOTENCY_MAX_ENTRIES):
+        self.ttl_seconds = ttl_seconds
+        self.max_entries = max_entries
+        self._entries: "OrderedDict[str, StoredResponse]" = OrderedDict()
+        self._running: Dict[str, Tuple[str, "asyncio.Future[None]"]] = {}
+
+    def clear(self) -> None:
+        self._entries.clear()
+
+    def __len__(self) -> int:
+        return len(self._entries)
+
+    def holds(self, key: str) -> bool:
+        """True when key has an unexpired response or a request still running."""
+        self._expire()
+        return key in self._entries or key in self._running
+
+    def _expire(self) -> None:
+        now = time.time()
+        for key in [k for k, stored in self._entries.items() if stored.expires_at <= now]:
+            del self._entries[key]
+
+    @staticmethod
+    def _check(expected: str, actual: str) -> None:
+        if expected != actual:
+            _REQUESTS.labels(result="conflict").inc()
+            raise HTTPException(status_code=422, detail=f"{HEADER} was already used for a different request")
+
+    async def run(self, key: str, request_fingerprint: str, respond: Callable[[], Awaitable[Response]]) -> Response:
+        """The stored response for key, or respond()'s, stored when it succeeds."""
+        while True:
+            self._expire()
+            stored = self._entries.get(key)
+            if stored is not None:
+                self._check(stored.fingerprint, request_fingerprint)
+                _REQUESTS.labels(result="replayed").inc()
+                return stored.response(replayed=True)
+            running = self._running.get(key)
+            if running is None:
+                break
+            self._check(running[0], request_fingerprint)
+            # Resolved when the first request ends; if it failed, this one runs the pipeline itself.
+            await asyncio.shield(running[1])
+
+        done: "asyncio.Future[None]" = asyncio.get_running_loop().create_future()
+        self._running[key] = (request_fingerprint, done)
+        try:
+            response = await respond()
+            stored = StoredResponse(
+                fingerprint=request_fingerprint,
+                status_code=response.status_code,
+                headers=[(k, v) for k, v in response.headers.items() if k.lower() != "content-length"],
+                body=await _body(response),
+                expires_at=time.time() + self.ttl_seconds,
+            )
+            if stored.status_code < 400 and self.max_entries > 0 and self.ttl_seconds > 0:
+                self._entries[key] = stored
+                _REQUESTS.labels(result="stored").inc()
+                while len(self._entries) > self.max_entries:
+                    self._entries.popitem(last=False)
+        finally:
+            del self._running[key]
+            done.set_result(None)
+        return stored.response(replayed=False)
+
+
+idempotency_cache = IdempotencyCache()
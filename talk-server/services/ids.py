@@ -0,0 +1,101 @@
+"""IDs for requests, jobs, events and sessions, and correlation IDs supplied by clients.
+
+DWANI_ID_FORMAT picks how the server makes IDs up: uuid4 (random), uuid7 (time-ordered
+UUID), or ulid (time-ordered, 26 Crockford base32 characters). DWANI_ID_PREFIXES adds a
+prefix per kind, such as "request=req_,job=job_", so an ID says what it names.
+
+A client's own correlation ID is used as the request ID when it comes in one of
+DWANI_REQUEST_ID_HEADERS (X-Request-ID, then X-Correlation-ID) and is up to 128 letters,
+digits and ._:- characters; anything else is replaced by a new ID. The request ID is returned
+in X-Request-ID, added to every log record of the request, set on its trace span as
+dwani.request_id, carried by CloudEvents as the requestid extension, and stored with the turn.
+"""
+import logging
+import os
+import re
+import time
+import uuid
+from contextvars import ContextVar
+from typing import Mapping, Optional
+
+from config import ID_FORMAT, ID_PREFIXES, REQUEST_ID_HEADERS
+
+ID_FORMATS = ("uuid4", "uuid7", "ulid")
+_CROCKFORD = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+_CLIENT_ID = re.compile(r"[A-Za-z0-9][A-Za-z0-9._:-]{0,127}")
+
+if ID_FORMAT not in ID_FORMATS:
+    raise ValueError(f"DWANI_ID_FORMAT must be one of {ID_FORMATS}, not {ID_FORMAT!r}")
+
+_request_id: ContextVar[Optional[str]] = ContextVar("dwani_request_id", default=None)
+
+
+def uuid7() -> uuid.UUID:
+    """RFC 9562 version 7: 48-bit Unix milliseconds, then random bits."""
+    value = (time.time_ns() // 1_000_000) << 80 | int.from_bytes(os.urandom(10), "big")
+    value = value & ~(0xF << 76) | 0x7 << 76  # version
+    value = value & ~(0x3 << 62) | 0x2 << 62  # variant
+    return uuid.UUID(int=value)
+
+
+def ulid() -> str:
+    value = (time.time_ns() // 1_000_000) << 80 | int.from_bytes(os.urandom(10), "big")
+    return "".join(_CROCKFORD[(value >> shift) & 0x1F] for shift in range(125, -1, -5))
+
+
+def new_id(kind: str = "request", id_format: Optional[str] = None) -> str:
+    """A new ID of kind (request, job, event, session) in DWANI_ID_FORMAT, with its prefix."""
+    id_format = id_format or ID_FORMAT
+    if id_format == "ulid":
+        body = ulid()
+    elif id_format == "uuid7":
+        body = str(uuid7())
+    else:
+        body = str(uuid.uuid4())
+    return f"{ID_PREFIXES.get(kind, '')}{body}"
+
+
+def client_request_id(headers: Mapping[str, str]) -> Optional[str]:
+    """The first usable correlation ID among DWANI_REQUEST_ID_HEADERS; None when there is none."""
+    for name in REQUEST_ID_HEADERS:
+        value = (headers.get(name) or "").strip()
+        if value and _CLIENT_ID.fullmatch(value):
+            return value
+    return None
+
+
+def bind_request_id(request_id: str):
+    """Make request_id the current one for logs and events; returns the token to reset it with."""
+    _tag_span(request_id)
+    return _request_id.set(request_id)
+
+
+def reset_request_id(token) -> None:
+    _request_id.reset(token)
+
+
+def current_request_id() -> Optional[str]:
+    return _request_id.get()
+
+
+def _tag_span(request_id: str) -> None:
+    try:
+        from opentelemetry import trace
+    except ImportError:
+        return
+    trace.get_current_span().set_attribute("dwani.request_id", request_id)
+
+
+class RequestIDFilter(logging.Filter):
+    """Fills in request_id on records logged without one."""
+
+    def filter(self, record: logging.LogRecord) -> bool:
+        if getattr(record, "request_id", None) is None:
+            record.request_id = _request_id.get()
+        return True
+
+
+def install_log_filter() -> None:
+    for handler in logging.getLogger().handlers:
+        if not any(isinstance(f, RequestIDFilter) for f in handler.filters):
+            handler.addFilter(RequestIDFilter())
@@ -0,0 +1,125 @@
+"""Prompt-injection guard between ASR and the LLM.
+
+Transcripts (and typed input) end up in the LLM's user message, so a caller can speak
+"ignore previous instructions and ..." at the assistant. The guard looks for such
+instruction-like content: requests to drop or reveal the instructions, role changes, and
+chat-template markers (<|im_start|>, [INST], "system:"), plus the regular expressions in
+DWANI_INJECTION_PATTERNS_FILE (one per line, # comments). DWANI_INJECTION_ACTION decides what
+happens on a match:
+
+- log (the default): the turn goes on unchanged; the detection is logged and counted.
+- neutralize: each match is replaced by DWANI_INJECTION_MASK and the turn goes on.
+- block: the turn is rejected like blocked content, a 422 with error.stage "moderation"
+  and category "prompt_injection".
+- off: no checks.
+
+Detections are logged with the rules that matched (not the transcript) and counted in
+dwani_prompt_injection_total{rule, action}. The guard runs as a before_llm hook
+(services/hooks.py), after plugin normalization and moderation.
+"""
+import re
+from typing import List, Optional, Tuple
+
+from prometheus_client import Counter
+
+from config import INJECTION_ACTION, INJECTION_MASK, INJECTION_PATTERNS_FILE, logger
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.moderation import ContentBlocked
+
+_DETECTIONS = Counter("dwani_prompt_injection_total", "Instruction-like content found in user input", ["rule", "action"])
+ACTIONS = ("off", "log", "neutralize", "block")
+_INSTRUCTIONS = r"(?:instructions?|prompts?|rules|guidelines)"
+
+# (rule, pattern): the rule names minding the counter's cardinality; file patterns count as "custom".
+RULES: List[Tuple[str, str]] = [
+    (
+        "override",
+        rf"\b(?:ignore|disregard|forget|override|bypass)\s+(?:(?:all|any|the|your|my|of|these|those)\s+)*"
+        rf"(?:(?:previous|prior|above|earlier|preceding|original|system|initial)\s+)+{_INSTRUCTIONS}",
+    ),
+    ("override", rf"\bforget\s+(?:everything|all)\s+(?:you\s+(?:were|have\s+been)\s+told|above|before)"),
+    (
+        "reveal",
+        r"\b(?:reveal|show|print|repeat|tell\s+me|what\s+(?:is|are))\s+(?:me\s+)?"
+        r"(?:your\s+(?:system\s+|hidden\s+|initial\s+)*|the\s+(?:system|hidden|initial)\s+)(?:instructions?|prompts?)",
+    ),
+    ("role", r"\b(?:you\s+are\s+now|from\s+now\s+on\s+you\s+are|pretend\s+(?:to\s+be|you\s+are)|act\s+as\s+(?:an?\s+)?(?:unrestricted|unfiltered|jailbroken))\b"),
+    ("role", r"\b(?:developer|jailbreak|dan)\s+mode\b"),
+    ("role", rf"\bnew\s+{_INSTRUCTIONS}\s*:"),
+    ("template", r"<\|?(?:im_start|im_end|system|endoftext|eot_id|start_header_id|end_header_id)\|?>|\[/?INST\]|<</?SYS>>"),
+    ("template", r"(?:^|\n)\s*(?:#{2,}\s*)?(?:system|assistant|instruction)s?\s*:"),
+]
+
+
+def load_patterns(path: str = INJECTION_PATTERNS_FILE) -> List[Tuple[str, str]]:
+    """The built-in rules and those in path; ValueError for a pattern that does not compile."""
+    rules = list(RULES)
+    if path:
+        with open(path, encoding="utf-8") as f:
+            for number, line in enumerate(f, 1):
+                pattern = line.strip()
+                if not pattern or pattern.startswith("#"):
+                    continue
+                try:
+                    re.compile(pattern)
+                except re.error as exc:
+                    raise ValueError(f"{path}:{number}: invalid pattern {pattern!r}: {exc}") from exc
+                rules.append(("custom", pattern))
+    return rules
+
+
+class InjectionGuard:
+    def __init__(
+        self,
+        rules: Optional[List[Tuple[str, str]]] = None,
+        action: str = INJECTION_ACTION,
+        mask: str = INJECTION_MASK,
+    ):
+        if action not in ACTIONS:
+            raise ValueError(f"DWANI_INJECTION_ACTION must be one of {list(ACTIONS)}, not {action!r}")
+        self.action = action
+        self.mask = mask
+        self.rules = [(name, re.compile(pattern, re.IGNORECASE)) for name, pattern in (rules if rules is not None else load_patterns())]
+
+    @property
+    def enabled(self) -> bool:
+        return self.action != "off" and bool(self.rules)
+
+    def detect(self, text: str) -> List[str]:
+        """Names of the rules text matches, in rule order."""
+        return list(dict.fromkeys(name for name, pattern in self.rules if pattern.search(text)))
+
+    def check(self, text: str, request_id: Optional[str] = None) -> str:
+        """text, neutralized where the policy says so; raises ContentBlocked under block."""
+        matched = self.detect(text)
+        if not matched:
+            return text
+        for name in matched:
+            _DETECTIONS.labels(rule=name, action=self.action).inc()
+        logger.warning(
+            "Possible prompt injection in user input (%s)", self.action,
+            extra={"rules": matched, "request_id": request_id},
+        )
+        if self.action == "block":
+            raise ContentBlocked("input", ["prompt_injection"])
+        if self.action == "neutralize":
+            for _, pattern in self.rules:
+                text = pattern.sub(lambda _: f" {self.mask} ", text)
+            text = re.sub(r"\s+([.,!?;:])", r"\1", " ".join(text.split()))
+        return text
+
+
+guard = InjectionGuard()
+
+
+async def _guard_input(ctx: HookContext, text: str) -> str:
+    return guard.check(text, ctx.request_id)
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the guard's before_llm hook unless DWANI_INJECTION_ACTION=off."""
+    if not guard.enabled or _guard_input in registry.registered("before_llm"):
+        return False
+    registry.register("before_llm", _guard_input)
+    logger.info("Prompt-injection guard enabled (%s)", guard.action)
+    return True
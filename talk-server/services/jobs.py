@@ -0,0 +1,271 @@
+"""Background speech-to-speech jobs: queued in-process, state kept in Redis when configured."""
+import asyncio
+import base64
+import json
+import os
+import time
+from dataclasses import asdict, dataclass, field
+from typing import Any, Dict, List, Optional
+
+import httpx
+from fastapi import HTTPException, UploadFile
+from starlette.datastructures import Headers
+
+from config import JOB_QUEUE_SIZE, JOB_TTL_SECONDS, JOB_WORKERS, MAX_UPLOAD_BYTES, logger
+from services.blob_store import deliver
+from services.branding import use_tenant
+from services.concurrency import pipeline_limiter
+from services.error_codes import code_for, error_fields
+from services.errors import RequestError
+from services.pipeline import converse
+from services.events import cloud_event, deliver_webhook
+from services.experiments import start_tags
+from services.ids import new_id
+from services.language_id import AUTO_LANGUAGE, language_tag
+from services.personas import personas
+from services.preprocess import use_preprocessing
+from services.session import redis_client
+from services.tenants import tenants, use_tenant_settings
+from services.tts import stream_tts
+from services.uploads import spooled_file
+
+_MAX_JOBS = 5000
+
+
+@dataclass
+class Job:
+    id: str
+    status: str = "queued"
+    mode: str = "llm"
+    agent_name: Optional[str] = None
+    session_id: Optional[str] = None
+    request_id: Optional[str] = None
+    webhook_url: Optional[str] = None
+    language: Optional[str] = None
+    persona: Optional[str] = None
+    experiment: Optional[str] = None
+    variant: Optional[str] = None
+    preprocess: Optional[str] = None
+    tenant: Optional[str] = None
+    delivery: Optional[str] = None  # "url": the reply audio goes to object storage (services/blob_store.py)
+    created_at: float = field(default_factory=time.time)
+    updated_at: float = field(default_factory=time.time)
+    result: Optional[Dict[str, Any]] = None
+    error: Optional[Dict[str, Any]] = None
+
+    def public(self) -> Dict[str, Any]:
+        data = asdict(self)
+        data.pop("session_id", None)
+        data["language"] = language_tag(self.language)
+        return data
+
+
+def _take_upload(file: UploadFile) -> UploadFile:
+    """Take ownership of the request's spooled upload without copying it.
+
+    Starlette closes every UploadFile of the parsed form once the response is sent;
+    swapping an empty placeholder into the request's object leaves the real spool
+    untouched for the background worker, which closes it when done.
+    """
+    spool = file.file
+    size = file.size
+    if size is None:
+        spool.seek(0, os.SEEK_END)
+        size = spool.tell()
+    spool.seek(0)
+    file.file = spooled_file()
+    return UploadFile(
+        file=spool,
+        size=size,
+        filename=file.filename,
+        headers=Headers({"content-type": file.content_type or "audio/wav"}),
+    )
+
+
+def failure(exc: Exception, language: Optional[str] = None) -> Dict[str, Any]:
+    """The error object of a failed job (or batch file): code, message, stage and the localized fields."""
+    if isinstance(exc, HTTPException):
+        return {
+            "code": str(exc.status_code),
+            "message": str(exc.detail),
+            "stage": getattr(exc, "stage", None),
+            **({"details": exc.details} if isinstance(exc, RequestError) and exc.details else {}),
+            **error_fields(code_for(exc), language),
+        }
+    if isinstance(exc, httpx.TimeoutException):
+        return {"code": "504", "message": "External API timeout", **error_fields("timeout", language)}
+    return {"code": "502", "message": "External API error", **error_fields("failed", language)}
+
+
+def _job_key(job_id: str) -> str:
+    return f"dwani:job:{job_id}"
+
+
+class JobManager:
+    def __init__(self, workers: int = JOB_WORKERS, queue_size: int = JOB_QUEUE_SIZE):
+        self._workers = max(1, workers)
+        self._queue_size = max(1, queue_size)
+        self._queue: Optional[asyncio.Queue] = None
+        self._tasks: List[asyncio.Task] = []
+        self._jobs: Dict[str, Job] = {}
+        self._order: List[str] = []
+
+    async def start(self) -> None:
+        if self._tasks:
+            return
+        self._queue = asyncio.Queue(maxsize=self._queue_size)
+        self._tasks = [asyncio.create_task(self._worker(i)) for i in range(self._workers)]
+
+    async def stop(self) -> None:
+        for task in self._tasks:
+            task.cancel()
+        await asyncio.gather(*self._tasks, return_exceptions=True)
+        self._tasks = []
+        self._queue = None
+
+    def _save(self, job: Job) -> None:
+        job.updated_at = time.time()
+        client = redis_client()
+        if client is not None:
+            try:
+                client.setex(_job_key(job.id), JOB_TTL_SECONDS, json.dumps(asdict(job)))
+                return
+            except Exception as exc:
+                logger.warning("Redis job write failed; falling back to memory: %s", exc)
+        if job.id not in self._jobs:
+            self._order.append(job.id)
+            while len(self._order) > _MAX_JOBS:
+                self._jobs.pop(self._order.pop(0), None)
+        self._jobs[job.id] = job
+
+    def get(self, job_id: str) -> Optional[Job]:
+        client = redis_client()
+        if client is not None:
+            try:
+                payload = client.get(_job_key(job_id))
+                if payload:
+                    return Job(**json.loads(payload))
+            except Exception as exc:
+                logger.warning("Redis job read failed; falling back to memory: %s", exc)
+        job = self._jobs.get(job_id)
+        if job is not None and time.time() - job.created_at > JOB_TTL_SECONDS:
+            return None
+        return job
+
+    async def submit(
+        self,
+        file: UploadFile,
+        mode: str = "llm",
+        agent_name: Optional[str] = None,
+        session_id: Optional[str] = None,
+        request_id: Optional[str] = None,
+        webhook_url: Optional[str] = None,
+        language: Optional[str] = None,
+        persona: Optional[str] = None,
+        experiment: Optional[str] = None,
+        variant: Optional[str] = None,
+        preprocess: Optional[str] = None,
+        tenant: Optional[str] = None,
+        delivery: Optional[str] = None,
+    ) -> Job:
+        if self._queue is None:
+            raise HTTPException(status_code=503, detail="Job workers are not running")
+        if self._queue.full():
+            raise HTTPException(status_code=503, detail="Job queue is full. Try again later.")
+        upload = _take_upload(file)
+        if upload.size > MAX_UPLOAD_BYTES:
+            await upload.close()
+            raise HTTPException(status_code=413, detail=f"File too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+        if not upload.size:
+            await upload.close()
+            raise RequestError(400, "empty_input", "Empty audio file")
+        job = Job(
+            id=new_id("job"),
+            mode=mode,
+            agent_name=agent_name,
+            session_id=session_id,
+            request_id=request_id,
+            webhook_url=webhook_url,
+            language=language,
+            persona=persona,
+            experiment=experiment,
+            variant=variant,
+            preprocess=preprocess,
+            tenant=tenant,
+            delivery=delivery,
+        )
+        self._save(job)
+        self._queue.put_nowait((job, upload))
+        return job
+
+    async def _worker(self, index: int) -> None:
+        assert self._queue is not None
+        while True:
+            job, upload = await self._queue.get()
+            try:
+                await self._run(job, upload)
+            except Exception as exc:  # pragma: no cover - defensive; _run records failures
+                logger.error("Job worker %s crashed on job %s: %s", index, job.id, exc)
+            finally:
+                await upload.close()
+                self._queue.task_done()
+
+    async def _run(self, job: Job, upload: UploadFile) -> None:
+        # The job's turn and webhook event carry the submitting request's experiment (services/experiments.py),
+        # its audio is preprocessed as that request asked (services/preprocess.py), and it is served
+        # with its tenant's branding and settings (services/tenants.py).
+        start_tags(job.experiment, job.variant)
+        use_preprocessing(job.preprocess)
+        use_tenant(job.tenant)
+        use_tenant_settings(tenants.get(job.tenant or ""))
+        job.status = "running"
+        self._save(job)
+        try:
+            # Jobs are already queued, so they wait for a slot rather than being rejected, and as batch
+            # work they give way to live requests.
+            async with pipeline_limiter.slot(block=True, priority="batch"):
+                turn = await converse(
+                    upload,
+                    mode=job.mode,
+                    agent_name=job.agent_name,
+                    session_id=job.session_id,
+                    request_id=job.request_id,
+                    language=job.language,
+                    persona=personas.get(job.persona or ""),
+                )
+                tts_stream = await stream_tts(turn.reply, request_id=job.request_id, language=turn.language)
+                audio_bytes = await tts_stream.read()
+            if job.delivery == "url":
+                audio_fields = await deliver(audio_bytes, tts_stream.media_type, job.request_id)
+            else:
+                audio_fields = {"audio_base64": base64.b64encode(audio_bytes).decode("utf-8")}
+            job.result = {
+                "transcription": turn.transcription,
+                "llm_response": turn.reply,
+                **audio_fields,
+                **({"detected_language": language_tag(turn.language)} if job.language == AUTO_LANGUAGE else {}),
+                **({"language_fallback": {
+                    "requested": language_tag(turn.language_fallback[0]),
+                    "used": language_tag(turn.language_fallback[1]),
+                }} if turn.language_fallback else {}),
+                **({"alternatives": list(turn.alternatives)} if turn.alternatives else {}),
+                **({"low_confidence": True} if turn.low_confidence else {}),
+            }
+            job.status = "succeeded"
+        except Exception as exc:
+            if not isinstance(exc, (HTTPException, httpx.TimeoutException)):
+                logger.error("Job %s failed: %s", job.id, exc)
+            job.status = "failed"
+            job.error = failure(exc, job.language)
+        self._save(job)
+        if job.webhook_url:
+            await self._notify(job)
+
+    async def _notify(self, job: Job) -> None:
+        # Pinned: the host is checked again as it is connected to, since it may resolve somewhere
+        # else now than when the job was submitted.
+        event = cloud_event(f"job.{job.status}", f"jobs/{job.id}", job.public())
+        await deliver_webhook(job.webhook_url, event, request_id=job.request_id, pinned=True)
+
+
+job_manager = JobManager()
@@ -0,0 +1,121 @@
+"""Spoken-language detection for language=auto.
+
+The ASR backend's own detection is used when its response reports a language (a top-level or
+per-choice "language", as Whisper-style servers return). Otherwise the transcript goes
+through a lightweight language-ID pass: the dominant script decides most languages, and
+common function words separate the ones that share a script (Hindi/Marathi in Devanagari,
+English/German in Latin).
+
+Metadata and stored records name languages by BCP-47 tag (kn-IN, via language_tag), and
+language parameters take a tag as well as a name; internally the name is used throughout.
+"""
+import unicodedata
+from collections import Counter
+from typing import Any, Dict, Optional, Set
+
+from fastapi import HTTPException
+
+from models import ALLOWED_LANGUAGES, LANGUAGE_TAGS
+from services.error_codes import set_error_language
+from services.grammar import normalize
+from services.tenants import current_tenant_settings
+
+AUTO_LANGUAGE = "auto"
+
+_CODES = {
+    "kn": "kannada", "kan": "kannada",
+    "hi": "hindi", "hin": "hindi",
+    "ta": "tamil", "tam": "tamil",
+    "ml": "malayalam", "mal": "malayalam",
+    "te": "telugu", "tel": "telugu",
+    "mr": "marathi", "mar": "marathi",
+    "en": "english", "eng": "english",
+    "de": "german", "deu": "german", "ger": "german",
+}
+# Unicode character-name prefix -> language (or a script shared by several languages).
+_SCRIPTS = {
+    "KANNADA": "kannada",
+    "TAMIL": "tamil",
+    "MALAYALAM": "malayalam",
+    "TELUGU": "telugu",
+    "DEVANAGARI": "devanagari",
+    "LATIN": "latin",
+}
+# Function words per language for scripts several languages share; the first entry wins ties.
+_DEVANAGARI_WORDS = {
+    "hindi": {"है", "हैं", "नहीं", "क्या", "मैं", "मेरा", "मुझे", "आप", "और", "था", "करो", "हम", "कहाँ", "में"},
+    "marathi": {"आहे", "आहेत", "नाही", "काय", "मला", "माझे", "माझा", "तुम्ही", "आणि", "होते", "करा", "आम्ही", "कुठे"},
+}
+_LATIN_WORDS = {
+    "english": {"the", "is", "and", "not", "what", "how", "you", "my", "please", "thanks", "a", "an", "i", "we", "it", "to"},
+    "german": {"ich", "und", "nicht", "ist", "das", "der", "die", "ein", "eine", "wie", "was", "bitte", "danke", "mein", "wir"},
+    # Romanized speech, as ASR often writes code-mixed input.
+    "hindi": {"hai", "hain", "kya", "nahi", "nahin", "mujhe", "mera", "aap", "kaise", "kahan", "haan", "karo", "kitna"},
+    "kannada": {"enu", "illa", "beku", "naanu", "nanage", "hegiddira", "hegiddiya", "elli", "yenu", "madi", "banni", "houdu"},
+}
+
+
+def normalize_language(value: Any) -> Optional[str]:
+    """Supported language name for an ISO code or name ("kn", "kn-IN", "Kannada"); None otherwise."""
+    if not isinstance(value, str) or not value.strip():
+        return None
+    value = value.strip().lower()
+    if value in ALLOWED_LANGUAGES:
+        return value
+    return _CODES.get(value.replace("_", "-").split("-")[0])
+
+
+def language_tag(language: Optional[str]) -> Optional[str]:
+    """BCP-47 tag (kn-IN) of a supported language name, for metadata; other values unchanged."""
+    return LANGUAGE_TAGS.get(language, language) if language else None
+
+
+def parse_language(value: Optional[str], name: str = "language", allow_auto: bool = False) -> Optional[str]:
+    """Language name from a request parameter given as a name, ISO code, or BCP-47 tag; 400 otherwise."""
+    value = (value or "").strip()
+    if not value:
+        return None
+    if allow_auto and value.lower() == AUTO_LANGUAGE:
+        return AUTO_LANGUAGE
+    language = normalize_language(value)
+    if language is None:
+        also = f" or '{AUTO_LANGUAGE}'" if allow_auto else ""
+        raise HTTPException(status_code=400, detail=f"{name} must be one of {ALLOWED_LANGUAGES}, a BCP-47 tag such as kn-IN{also}")
+    if not tenant_allows_language(language):
+        raise HTTPException(status_code=400, detail=f"{name} must be one of {sorted(current_tenant_settings().languages)}")
+    set_error_language(language)
+    return language
+
+
+def tenant_allows_language(language: str) -> bool:
+    """Whether the request's tenant (services/tenants.py), if it restricts languages, allows language."""
+    tenant = current_tenant_settings()
+    return tenant is None or not tenant.languages or language in tenant.languages
+
+
+def _script(ch: str) -> Optional[str]:
+    try:
+        name = unicodedata.name(ch)
+    except ValueError:
+        return None
+    return _SCRIPTS.get(name.split(" ")[0])
+
+
+def _pick(words: Set[str], candidates: Dict[str, Set[str]]) -> str:
+    return max(candidates, key=lambda language: len(words & candidates[language]))
+
+
+def detect_language(text: str) -> Optional[str]:
+    """Language of text among the supported ones, or None when it has no recognizable letters."""
+    scripts = Counter(script for script in map(_script, text) if script)
+    if not scripts:
+        return None
+    script = scripts.most_common(1)[0][0]
+    words = set(normalize(text).split())
+    if script == "devanagari":
+        return _pick(words, _DEVANAGARI_WORDS)
+    if script == "latin":
+        if any(ch in text for ch in "äöüßÄÖÜ"):
+            return "german"
+        return _pick(words, _LATIN_WORDS)
+    return script
@@ -0,0 +1,130 @@
+"""Stage processing time against input audio duration, for capacity planning.
+
+dwani_stage_processing_seconds is a histogram matrix: one series per stage and coarse
+input-duration bucket, so a heat-map shows how ASR/LLM/TTS time grows with longer audio.
+The two counters give "seconds of audio per second of compute" in PromQL:
+
+    rate(dwani_input_audio_seconds_total[5m]) / rate(dwani_stage_compute_seconds_total{stage="asr"}[5m])
+
+The same stage times are added up per HTTP request (track_request) for the access log and
+the Server-Timing header, so browser devtools show which stage made a request slow, and for
+the timings of JSON turn responses (request_timings).
+"""
+import struct
+import time
+from contextlib import contextmanager
+from contextvars import ContextVar
+from typing import IO, Dict, Iterator, Optional, Tuple
+
+from prometheus_client import Counter, Histogram
+
+from services.usage import record_usage
+
+# Upper bounds (seconds) of the input-duration label; kept small to bound cardinality.
+_DURATION_BUCKETS = [(2, "0-2s"), (5, "2-5s"), (10, "5-10s"), (30, "10-30s"), (60, "30-60s")]
+
+_STAGE_SECONDS = Histogram(
+    "dwani_stage_processing_seconds",
+    "Pipeline stage processing time by input audio duration",
+    ["stage", "audio_duration"],
+    buckets=(0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60),
+)
+_STAGE_COMPUTE = Counter("dwani_stage_compute_seconds_total", "Processing time spent per stage on audio input", ["stage"])
+_AUDIO_SECONDS = Counter("dwani_input_audio_seconds_total", "Seconds of input audio with a known duration")
+
+_audio_duration: ContextVar[Optional[float]] = ContextVar("dwani_audio_duration", default=None)
+_audio_input: ContextVar[bool] = ContextVar("dwani_audio_input", default=False)
+# Milliseconds per stage for the current HTTP request; the dict is shared with the tasks it starts.
+_request_stages: ContextVar[Optional[Dict[str, float]]] = ContextVar("dwani_request_stages", default=None)
+_request_started: ContextVar[Optional[float]] = ContextVar("dwani_request_started", default=None)
+
+
+def duration_label(seconds: Optional[float], audio_input: bool = True) -> str:
+    if not audio_input:
+        return "text"
+    if seconds is None:
+        return "unknown"
+    for bound, label in _DURATION_BUCKETS:
+        if seconds < bound:
+            return label
+    return "60s+"
+
+
+def wav_layout(header: bytes) -> Optional[Tuple[int, int, int]]:
+    """(byte rate, offset of the samples, data size) from a RIFF/WAVE header, else None."""
+    if len(header) < 12 or header[:4] != b"RIFF" or header[8:12] != b"WAVE":
+        return None
+    offset, byte_rate = 12, None
+    while offset + 8 <= len(header):
+        chunk_id, size = header[offset:offset + 4], struct.unpack("<I", header[offset + 4:offset + 8])[0]
+        if chunk_id == b"fmt " and offset + 20 <= len(header):
+            byte_rate = struct.unpack("<I", header[offset + 16:offset + 20])[0]
+        elif chunk_id == b"data":
+            return (byte_rate, offset + 8, size) if byte_rate else None
+        offset += 8 + size + (size & 1)
+    return None
+
+
+def wav_duration(header: bytes) -> Optional[float]:
+    """Duration from a RIFF/WAVE header (fmt byte rate and data size), else None."""
+    layout = wav_layout(header)
+    return layout[2] / layout[0] if layout else None
+
+
+def probe_duration(fileobj: IO[bytes], header_bytes: int = 4096) -> Optional[float]:
+    """Best-effort duration of an uploaded file; leaves the position at the start."""
+    try:
+        fileobj.seek(0)
+        header = fileobj.read(header_bytes)
+        fileobj.seek(0)
+    except (OSError, ValueError):
+        return None
+    return wav_duration(header)
+
+
+def set_input_audio(seconds: Optional[float], audio_input: bool = True) -> None:
+    """Record the current turn's input; later stages in this context are labelled with it."""
+    _audio_duration.set(seconds)
+    _audio_input.set(audio_input)
+    if audio_input and seconds is not None:
+        _AUDIO_SECONDS.inc(seconds)
+        record_usage(audio_seconds=seconds)
+
+
+@contextmanager
+def observe_stage(stage: str) -> Iterator[None]:
+    started = time.perf_counter()
+    try:
+        yield
+    finally:
+        elapsed = time.perf_counter() - started
+        audio_input = _audio_input.get()
+        seconds = _audio_duration.get()
+        _STAGE_SECONDS.labels(stage=stage, audio_duration=duration_label(seconds, audio_input)).observe(elapsed)
+        if audio_input and seconds is not None:
+            _STAGE_COMPUTE.labels(stage=stage).inc(elapsed)
+        stages = _request_stages.get()
+        if stages is not None:
+            stages[stage] = stages.get(stage, 0.0) + elapsed * 1000
+
+
+def track_request() -> Dict[str, float]:
+    """Start adding up stage times for this request; the returned dict fills in as stages finish."""
+    stages: Dict[str, float] = {}
+    _request_stages.set(stages)
+    _request_started.set(time.perf_counter())
+    return stages
+
+
+def request_timings() -> Dict[str, float]:
+    """The current request's milliseconds so far: <stage>_ms for each stage that ran, and total_ms."""
+    started = _request_started.get()
+    timings = {f"{stage}_ms": round(ms, 1) for stage, ms in (_request_stages.get() or {}).items()}
+    timings["total_ms"] = round((time.perf_counter() - started) * 1000, 1) if started is not None else 0.0
+    return timings
+
+
+def server_timing(stages: Dict[str, float], total_ms: float) -> str:
+    """Server-Timing value: each stage's milliseconds in the order they ran, then the total."""
+    parts = [f"{stage};dur={ms:.1f}" for stage, ms in stages.items()]
+    return ", ".join(parts + [f"total;dur={total_ms:.1f}"])
@@ -0,0 +1,95 @@
+"""Start and shutdown hooks, so subsystems and embedders set up and clean up the same way.
+
+A hook is a function (sync or async) taking a LifecycleContext, or no arguments. Start hooks
+run in registration order; shutdown hooks in reverse, so what started first stops last.
+Each shutdown hook gets DWANI_SHUTDOWN_HOOK_TIMEOUT seconds, and one that fails or times out
+is logged without keeping the others from running. When a start hook fails, the shutdown
+hooks run (ctx.reason "start_failed") before the error is raised, so they must tolerate a
+subsystem that never started.
+
+The server runs the module-wide lifecycle from its startup and shutdown events; plugins
+loaded with DWANI_PIPELINE_PLUGINS may register on it. An embedded Pipeline has its own,
+run by Pipeline.start() / Pipeline.close() or `async with`.
+"""
+import asyncio
+import inspect
+from dataclasses import dataclass, field
+from typing import Any, Callable, Dict, List, Optional
+
+from config import SHUTDOWN_HOOK_TIMEOUT, logger
+from services.backends import PipelineConfig
+
+LifecycleHook = Callable[..., Any]
+
+
+@dataclass
+class LifecycleContext:
+    """What hooks know about the start or shutdown; metadata is free for hooks to share state in."""
+
+    name: str = "server"
+    config: Optional[PipelineConfig] = None
+    reason: Optional[str] = None  # on shutdown: "shutdown" or "start_failed"
+    metadata: Dict[str, Any] = field(default_factory=dict)
+
+
+async def _call(hook: LifecycleHook, ctx: LifecycleContext) -> None:
+    result = hook(ctx) if inspect.signature(hook).parameters else hook()
+    if inspect.isawaitable(result):
+        await result
+
+
+class Lifecycle:
+    """Ordered start and shutdown hooks, run once each per start."""
+
+    def __init__(self, shutdown_timeout: float = SHUTDOWN_HOOK_TIMEOUT):
+        self.shutdown_timeout = shutdown_timeout
+        self._start: List[LifecycleHook] = []
+        self._shutdown: List[LifecycleHook] = []
+        self._state = "new"  # running, then stopped; start() again after a shutdown is allowed
+        self.context: Optional[LifecycleContext] = None
+
+    def on_start(self, hook: LifecycleHook) -> LifecycleHook:
+        """Register hook; usable as a decorator."""
+        self._start.append(hook)
+        return hook
+
+    def on_shutdown(self, hook: LifecycleHook) -> LifecycleHook:
+        self._shutdown.append(hook)
+        return hook
+
+    @property
+    def running(self) -> bool:
+        return self._state == "running"
+
+    async def start(self, ctx: Optional[LifecycleContext] = None) -> None:
+        if self._state == "running":
+            return
+        self.context = ctx or LifecycleContext()
+        self._state = "running"
+        for hook in list(self._start):
+            try:
+                await _call(hook, self.context)
+            except Exception:
+                logger.exception("Start hook %s failed; shutting down", getattr(hook, "__qualname__", hook))
+                await self.shutdown(reason="start_failed")
+                raise
+
+    async def shutdown(self, reason: str = "shutdown") -> None:
+        """Run the shutdown hooks, newest first, once; a lifecycle that was never started runs them too."""
+        if self._state == "stopped":
+            return
+        ctx = self.context or LifecycleContext()
+        ctx.reason = reason
+        self._state = "stopped"
+        self.context = None
+        for hook in reversed(self._shutdown):
+            name = getattr(hook, "__qualname__", hook)
+            try:
+                await asyncio.wait_for(_call(hook, ctx), timeout=self.shutdown_timeout)
+            except asyncio.TimeoutError:
+                logger.warning("Shutdown hook %s did not finish within %ss", name, self.shutdown_timeout)
+            except Exception:
+                logger.exception("Shutdown hook %s failed", name)
+
+
+lifecycle = Lifecycle()
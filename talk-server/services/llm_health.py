@@ -0,0 +1,88 @@
+"""Time-to-first-token health of the LLM backend, for switching to DWANI_LLM_FALLBACK_* before users wait out DWANI_LLM_TIMEOUT.
+
+LLM completions are not streamed, so their first token arrives with the reply: a call's response
+time is its time to first token. The last DWANI_LLM_HEALTH_WINDOW of them are kept per LLM base
+URL. While their median is at most DWANI_LLM_SLOW_MS, a call may take four times that median, but
+at least twice DWANI_LLM_SLOW_MS and at most DWANI_LLM_TIMEOUT, before it is given up and retried
+on the fallback. Once the median goes over DWANI_LLM_SLOW_MS, new requests go straight to the
+fallback for DWANI_LLM_FALLBACK_SECONDS, after which the backend is tried again with a fresh window.
+Nothing changes without DWANI_LLM_FALLBACK_BASE_URL. Health is tracked per worker process.
+"""
+import statistics
+import threading
+import time
+from collections import deque
+from typing import Callable, Deque, Dict, Optional
+
+from prometheus_client import Counter
+
+from config import LLM_FALLBACK_SECONDS, LLM_HEALTH_WINDOW, LLM_SLOW_MS, LLM_TIMEOUT, logger
+
+FALLBACKS = Counter("dwani_llm_fallback_total", "LLM calls sent to the fallback backend", ["reason"])
+# A healthy call may take this many times the recent median, and at least this many times DWANI_LLM_SLOW_MS.
+_MEDIAN_FACTOR = 4
+_SLOW_FACTOR = 2
+
+
+class LLMHealth:
+    """Recent response times of one LLM backend, and whether it is in its fallback period."""
+
+    def __init__(
+        self,
+        url: str,
+        window: int = LLM_HEALTH_WINDOW,
+        slow_ms: int = LLM_SLOW_MS,
+        fallback_seconds: int = LLM_FALLBACK_SECONDS,
+        clock: Callable[[], float] = time.monotonic,
+    ):
+        self.url = url
+        self.slow = slow_ms / 1000
+        self.fallback_seconds = fallback_seconds
+        self._clock = clock
+        self._lock = threading.Lock()
+        self._samples: Deque[float] = deque(maxlen=max(1, window))
+        self._fallback_until = 0.0
+
+    def median(self) -> Optional[float]:
+        with self._lock:
+            return statistics.median(self._samples) if self._samples else None
+
+    def degraded(self) -> bool:
+        return self._clock() < self._fallback_until
+
+    def timeout(self, ceiling: float = LLM_TIMEOUT) -> float:
+        """How long the next call may take before it is retried on the fallback."""
+        median = self.median()
+        allowed = max(_SLOW_FACTOR * self.slow, _MEDIAN_FACTOR * median if median is not None else 0)
+        return min(ceiling, allowed)
+
+    def observe(self, seconds: float) -> None:
+        """Record one call's time to first token (its timeout, when it ran out)."""
+        with self._lock:
+            self._samples.append(seconds)
+            median = statistics.median(self._samples)
+            if median <= self.slow:
+                return
+            self._samples.clear()
+            self._fallback_until = self._clock() + self.fallback_seconds
+        logger.warning(
+            "LLM time to first token is %.1fs (over %.1fs); using the fallback for %ss",
+            median, self.slow, self.fallback_seconds, extra={"base_url": self.url},
+        )
+
+
+_health: Dict[str, LLMHealth] = {}
+_health_lock = threading.Lock()
+
+
+def llm_health(url: str) -> LLMHealth:
+    with _health_lock:
+        health = _health.get(url)
+        if health is None:
+            health = _health[url] = LLMHealth(url)
+        return health
+
+
+def reset_llm_health() -> None:
+    with _health_lock:
+        _health.clear()
@@ -0,0 +1,111 @@
+"""Weighted A/B testing of LLM models.
+
+DWANI_LLM_VARIANTS lists the models a deployment splits its LLM traffic over, with weights,
+e.g. "gemma3=90,qwen3=10"; "qwen3@http://qwen:8000/v1=10" sends a model to its own
+OpenAI-compatible backend (with DWANI_LLM_API_KEY). The split is by session (by request
+without one), so a conversation keeps its model. Calls that already name a model (the
+request's model, a tenant's or embedded pipeline's llm_model, the translation backend) are
+not split, and the slow-LLM fallback still applies on top.
+
+The model that answered is tagged on the turn (services/experiments.py), returned in the
+X-LLM-Model response header and logged. dwani_llm_variant_seconds and
+dwani_llm_variant_replies_total{model,outcome} (ok, truncated, echo, error) compare the models;
+replies the fallback gave while a model was slow count toward that model.
+
+With DWANI_LLM_ALLOWED_MODELS, a request's model parameter must name one of those models,
+DWANI_LLM_MODEL (or its tenant's llm_model) or a variant's model, 400 otherwise; unset, a
+request may name any model its backend serves.
+"""
+import hashlib
+import random
+from contextlib import contextmanager
+from dataclasses import dataclass
+from typing import Iterator, List, Optional
+
+from fastapi import HTTPException
+from prometheus_client import Counter, Histogram
+
+from config import LLM_ALLOWED_MODELS, LLM_VARIANTS, logger
+from services import backends
+
+VARIANT_SECONDS = Histogram(
+    "dwani_llm_variant_seconds",
+    "LLM reply time per A/B model",
+    ["model"],
+    buckets=(0.25, 0.5, 1, 2, 4, 8, 15, 30, 60),
+)
+VARIANT_REPLIES = Counter("dwani_llm_variant_replies_total", "LLM replies per A/B model and outcome", ["model", "outcome"])
+
+
+@dataclass(frozen=True)
+class LLMVariant:
+    model: str
+    weight: int
+    base_url: Optional[str] = None  # None: DWANI_API_BASE_URL_LLM
+
+
+def parse_variants(spec: str) -> List[LLMVariant]:
+    """Variants from "model[@base_url]=weight,..."; a missing weight is 1, and 0 disables one."""
+    variants = []
+    for item in (part.strip() for part in (spec or "").split(",")):
+        if not item:
+            continue
+        name, _, weight = item.rpartition("=") if "=" in item else (item, "", "1")
+        model, _, base_url = name.partition("@")
+        if not model.strip() or not weight.strip().isdigit():
+            raise ValueError(f"DWANI_LLM_VARIANTS entry {item!r} must be model[@base_url]=weight")
+        if int(weight):
+            variants.append(LLMVariant(model.strip(), int(weight), base_url.strip().rstrip("/") or None))
+    return variants
+
+
+VARIANTS = parse_variants(LLM_VARIANTS)
+
+
+def choose(key: Optional[str], variants: Optional[List[LLMVariant]] = None) -> Optional[LLMVariant]:
+    """The variant for a session or request key (random without one); None when there are none."""
+    variants = VARIANTS if variants is None else variants
+    total = sum(variant.weight for variant in variants)
+    if not total:
+        return None
+    if key:
+        point = int.from_bytes(hashlib.sha256(key.encode()).digest()[:8], "big") % total
+    else:
+        point = random.randrange(total)
+    for variant in variants:
+        if point < variant.weight:
+            return variant
+        point -= variant.weight
+    return variants[-1]
+
+
+def resolve_model(value: Optional[str]) -> Optional[str]:
+    """Model named by a request; None when it names none, 400 when DWANI_LLM_ALLOWED_MODELS does not allow it."""
+    value = (value or "").strip()
+    if not value:
+        return None
+    if LLM_ALLOWED_MODELS:
+        allowed = {*LLM_ALLOWED_MODELS, backends.llm_model(), *(variant.model for variant in VARIANTS)}
+        if value not in allowed:
+            raise HTTPException(status_code=400, detail=f"model must be one of {sorted(allowed)}")
+    return value
+
+
+@contextmanager
+def use_variant(key: Optional[str], requested_model: Optional[str] = None) -> Iterator[Optional[LLMVariant]]:
+    """Point LLM calls at key's variant for the duration, unless the request or config already chose the model."""
+    variant = choose(key) if VARIANTS and not requested_model and not backends.llm_model_pinned() else None
+    if variant is None:
+        yield None
+        return
+    with backends.use_llm_model(variant.model, variant.base_url):
+        yield variant
+
+
+def observe(variant: LLMVariant, seconds: float, outcome: str) -> None:
+    VARIANT_SECONDS.labels(variant.model).observe(seconds)
+    VARIANT_REPLIES.labels(variant.model, outcome).inc()
+    logger.info(
+        "LLM variant replied",
+        extra={"llm_model": variant.model, "llm_outcome": outcome, "llm_seconds": round(seconds, 3)},
+    )
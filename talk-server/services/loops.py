@@ -0,0 +1,108 @@
+"""Loop breaker for LLM replies that repeat themselves, a common failure of small local models.
+
+Two patterns are caught: a reply that loops within itself (a sentence said three or more
+times, or a phrase repeated to the end, "no no no no ..."), and a reply that is nearly the
+same as one of the assistant's last DWANI_LOOP_HISTORY replies in the session (similarity
+of at least DWANI_LOOP_SIMILARITY). The LLM is then asked once more with a fresh prompt:
+without the conversation history that primed the repetition, and told not to repeat itself.
+If that reply loops too, a reply looping within itself is cut to its sentences said once,
+and otherwise DWANI_LOOP_FALLBACK_REPLY, or the built-in apology in the turn's language
+(services/messages.py), is used. Each detection is logged as a quality event
+(extra quality_event=llm_loop) and counted in dwani_llm_loops_total{kind, action}.
+"""
+import re
+from difflib import SequenceMatcher
+from typing import Dict, Iterable, List, Optional
+
+from prometheus_client import Counter
+
+from config import LOOP_FALLBACK_REPLY, LOOP_HISTORY, LOOP_SIMILARITY, logger
+from services.messages import message
+
+FRESH_INSTRUCTION = "Answer the user's latest message directly and briefly. Do not repeat earlier answers or yourself."
+
+_SENTENCE_END = re.compile(r"(?<=[.!?।॥])\s+")
+_WORD = re.compile(r"\w+")
+_MIN_WORDS = 4
+_SENTENCE_REPEATS = 3
+_TAIL_REPEATS = 4
+_MAX_PHRASE_WORDS = 8
+
+_LOOPS = Counter("dwani_llm_loops_total", "LLM replies caught repeating themselves", ["kind", "action"])
+
+
+def _words(text: str) -> List[str]:
+    return _WORD.findall(text.casefold())
+
+
+def _sentences(text: str) -> List[str]:
+    return [s.strip() for s in _SENTENCE_END.split(text) if s.strip()]
+
+
+def _tail_loops(words: List[str]) -> bool:
+    """The reply ends in one phrase (up to _MAX_PHRASE_WORDS words) said _TAIL_REPEATS or more times."""
+    for size in range(1, _MAX_PHRASE_WORDS + 1):
+        phrase = words[-size:]
+        repeats = 1
+        while words[-size * (repeats + 1):len(words) - size * repeats] == phrase:
+            repeats += 1
+        if repeats >= _TAIL_REPEATS and size * repeats >= 2 * _MIN_WORDS:
+            return True
+    return False
+
+
+def loop_kind(reply: str, previous: Iterable[str] = ()) -> Optional[str]:
+    """"self" when the reply loops within itself, "repeat" when it echoes a previous reply, else None."""
+    words = _words(reply)
+    if len(words) < _MIN_WORDS:
+        return None
+    counts: Dict[str, int] = {}
+    for sentence in _sentences(reply):
+        key = " ".join(_words(sentence))
+        if len(key.split()) >= 2:
+            counts[key] = counts.get(key, 0) + 1
+    if max(counts.values(), default=0) >= _SENTENCE_REPEATS or _tail_loops(words):
+        return "self"
+    normalized = " ".join(words)
+    for earlier in previous:
+        if SequenceMatcher(None, normalized, " ".join(_words(earlier))).ratio() >= LOOP_SIMILARITY:
+            return "repeat"
+    return None
+
+
+def recent_replies(context: List[Dict[str, str]]) -> List[str]:
+    """The assistant's last DWANI_LOOP_HISTORY replies in the session context."""
+    replies = [m.get("content") or "" for m in context if m.get("role") == "assistant"]
+    return replies[-LOOP_HISTORY:] if LOOP_HISTORY > 0 else []
+
+
+def deduplicated(reply: str) -> str:
+    """reply with each sentence kept once, and a phrase looping at the end said once."""
+    seen, kept = set(), []
+    for sentence in _sentences(reply):
+        key = " ".join(_words(sentence))
+        if key not in seen:
+            seen.add(key)
+            kept.append(sentence)
+    text = " ".join(kept)
+    tokens = text.split()
+    for size in range(1, _MAX_PHRASE_WORDS + 1):
+        while len(tokens) >= 2 * size and [t.casefold() for t in tokens[-size:]] == [t.casefold() for t in tokens[-2 * size:-size]]:
+            del tokens[-size:]
+    return " ".join(tokens)
+
+
+def record(kind: str, action: str, request_id: Optional[str] = None) -> None:
+    _LOOPS.labels(kind=kind, action=action).inc()
+    logger.warning(
+        "LLM reply loop (%s): %s", kind, action, extra={"quality_event": "llm_loop", "kind": kind, "action": action, "request_id": request_id}
+    )
+
+
+def fallback(reply: str, kind: str, language: Optional[str] = None) -> str:
+    """What to say when the fresh prompt looped as well."""
+    if kind == "self":
+        shorter = deduplicated(reply)
+        if shorter and loop_kind(shorter) is None:
+            return shorter
+    return message("loop_fallback", language, configured=LOOP_FALLBACK_REPLY)
@@ -0,0 +1,77 @@
+"""Reply audio post-processing: loudness normalization and leading silence.
+
+TTS backends return very different volumes, so a deployment that mixes them (or their
+voices) sounds uneven on speakers and kiosks. With DWANI_TTS_LOUDNESS_LUFS set (e.g. -16),
+every synthesized clip is brought to that integrated loudness with ffmpeg's loudnorm filter
+(EBU R128), peaks held under DWANI_TTS_TRUE_PEAK dBTP. DWANI_TTS_LEAD_SILENCE_MS puts that
+much silence in front of every clip, for speakers whose amplifier wakes up late and swallows
+the first syllable; streamed replies get it before each sentence.
+
+Either one means the reply is buffered whole before it is sent, so the first audio reaches
+the client later. The audio keeps its format and sample rate. When ffmpeg is missing or
+fails, or the format is not one we can re-encode (mp3, wav, ogg), the reply is sent as the
+backend returned it. The post-processing runs as an after_tts hook (services/hooks.py),
+after plugins and before the demo watermark. Cached audio is stored as the backend returned it
+and processed on the way out, so changing the settings needs no cache flush. Word timestamps
+from the backend no longer line up and are dropped; captions fall back to estimated timings.
+"""
+from typing import Optional
+
+from prometheus_client import Counter
+
+from config import TTS_LEAD_SILENCE_MS, TTS_LOUDNESS_LUFS, TTS_TRUE_PEAK, logger
+from services.audio_format import content_type_for, sample_rate, transcode
+from services.errors import StageError
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.tts import TTSStream
+
+_PROCESSED = Counter("dwani_tts_postprocess_total", "Reply audio clips post-processed, by outcome", ["outcome"])
+# loudnorm's loudness range target in LU, its default; speech sits well inside it.
+_LOUDNESS_RANGE = 11
+
+
+def filter_graph(
+    lufs: float = TTS_LOUDNESS_LUFS, true_peak: float = TTS_TRUE_PEAK, lead_silence_ms: int = TTS_LEAD_SILENCE_MS
+) -> Optional[str]:
+    """The ffmpeg audio filters for the settings; None when there is nothing to do."""
+    filters = []
+    if lead_silence_ms > 0:
+        filters.append(f"adelay={lead_silence_ms}:all=1")
+    if lufs:
+        filters.append(f"loudnorm=I={lufs:g}:TP={true_peak:g}:LRA={_LOUDNESS_RANGE}")
+    return ",".join(filters) or None
+
+
+FILTERS = filter_graph()
+
+
+async def postprocess(audio: bytes, audio_format: str, filters: Optional[str] = FILTERS) -> bytes:
+    """audio run through filters and re-encoded as audio_format at its own sample rate; unchanged on failure."""
+    if not filters:
+        return audio
+    try:
+        processed = await transcode(audio, audio_format, filters=filters, sample_rate=sample_rate(audio))
+    except StageError:
+        _PROCESSED.labels(outcome="failed").inc()
+        logger.warning("Reply audio post-processing failed; sending it unprocessed")
+        return audio
+    _PROCESSED.labels(outcome="processed").inc()
+    return processed
+
+
+async def _postprocess_tts(ctx: HookContext, stream: TTSStream) -> Optional[TTSStream]:
+    audio_format = stream.audio_format
+    if audio_format is None:
+        _PROCESSED.labels(outcome="skipped").inc()
+        return None
+    audio = await postprocess(await stream.read(), audio_format)
+    return TTSStream.cached(content_type_for(audio_format), audio)
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the post-processing hook when loudness or lead silence is configured; call it after plugins."""
+    if not FILTERS or _postprocess_tts in registry.registered("after_tts"):
+        return False
+    registry.register("after_tts", _postprocess_tts)
+    logger.info("Reply audio post-processing enabled (%s)", FILTERS)
+    return True
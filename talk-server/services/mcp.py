@@ -0,0 +1,213 @@
+"""Model Context Protocol server exposing the voice pipeline as tools.
+
+handle_message() takes one decoded JSON-RPC 2.0 message and returns the reply (None for
+notifications), so the same tools are served over stdio (mcp_server.py) and SSE
+(routers/mcp.py).
+"""
+import base64
+import binascii
+from typing import Any, Dict, Optional
+
+from fastapi import HTTPException, UploadFile
+
+from config import MAX_UPLOAD_BYTES, logger
+from models import ALLOWED_AGENTS, ALLOWED_LANGUAGES, LANGUAGE_TAGS
+from services.concurrency import pipeline_limiter
+from services.language_id import normalize_language
+from services.pipeline import audio_upload, converse
+from services.transcribe import transcribe_audio
+from services.tts import stream_tts
+
+PROTOCOL_VERSION = "2025-03-26"
+SERVER_INFO = {"name": "dwani-talk", "version": "1.0.0"}
+
+_PARSE_ERROR = -32700
+_INVALID_REQUEST = -32600
+_METHOD_NOT_FOUND = -32601
+_INVALID_PARAMS = -32602
+_INTERNAL_ERROR = -32603
+
+_AUDIO_PROPERTIES = {
+    "audio_base64": {"type": "string", "description": "Base64-encoded audio (wav, mp3, webm, ...)"},
+    "mime_type": {"type": "string", "description": "Audio MIME type, default audio/wav"},
+}
+_LANGUAGE_PROPERTY = {
+    "type": "string",
+    "enum": ALLOWED_LANGUAGES + sorted(LANGUAGE_TAGS.values()),
+    "description": "Spoken and reply language, by name or BCP-47 tag",
+}
+
+TOOLS = [
+    {
+        "name": "transcribe_audio",
+        "description": "Transcribe speech in an Indian language to text in its native script.",
+        "inputSchema": {
+            "type": "object",
+            "properties": {**_AUDIO_PROPERTIES, "language": _LANGUAGE_PROPERTY},
+            "required": ["audio_base64"],
+        },
+    },
+    {
+        "name": "synthesize_speech",
+        "description": "Convert text to spoken audio (MP3).",
+        "inputSchema": {
+            "type": "object",
+            "properties": {
+                "text": {"type": "string", "description": "Text to speak"},
+                "language": {**_LANGUAGE_PROPERTY, "description": "Language of the text, which picks its voice"},
+            },
+            "required": ["text"],
+        },
+    },
+    {
+        "name": "voice_chat",
+        "description": "One conversational turn: transcribe audio (or take text), get a reply from the LLM or an agent, and speak it.",
+        "inputSchema": {
+            "type": "object",
+            "properties": {
+                **_AUDIO_PROPERTIES,
+                "text": {"type": "string", "description": "Typed input instead of audio"},
+                "mode": {"type": "string", "enum": ["llm", "agent"], "default": "llm"},
+                "agent_name": {"type": "string", "enum": ALLOWED_AGENTS},
+                "session_id": {"type": "string", "description": "Keeps conversation context across calls"},
+                "language": _LANGUAGE_PROPERTY,
+            },
+        },
+    },
+]
+
+
+class InvalidParams(Exception):
+    pass
+
+
+def _upload(arguments: Dict[str, Any]) -> UploadFile:
+    try:
+        data = base64.b64decode(arguments.get("audio_base64") or "", validate=True)
+    except (binascii.Error, ValueError):
+        raise InvalidParams("audio_base64 must be valid base64")
+    if not data:
+        raise InvalidParams("audio_base64 is required")
+    if len(data) > MAX_UPLOAD_BYTES:
+        raise InvalidParams(f"Audio too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+    return audio_upload(data, arguments.get("mime_type") or "audio/wav")
+
+
+def _language(arguments: Dict[str, Any]) -> Optional[str]:
+    raw = (arguments.get("language") or "").strip() or None
+    language = normalize_language(raw)
+    if raw and language is None:
+        raise InvalidParams(f"language must be one of {ALLOWED_LANGUAGES} or a BCP-47 tag such as kn-IN")
+    return language
+
+
+async def _speak(text: str, request_id: Optional[str], language: Optional[str] = None) -> Dict[str, Any]:
+    stream = await stream_tts(text, request_id=request_id, language=language)
+    audio = await stream.read()
+    return {
+        "type": "audio",
+        "data": base64.standard_b64encode(audio).decode("ascii"),
+        "mimeType": stream.media_type,
+    }
+
+
+async def _transcribe_audio(arguments: Dict[str, Any], request_id: Optional[str]) -> Dict[str, Any]:
+    result = await transcribe_audio(_upload(arguments), language=_language(arguments), request_id=request_id)
+    return {"content": [{"type": "text", "text": result.text}]}
+
+
+async def _synthesize_speech(arguments: Dict[str, Any], request_id: Optional[str]) -> Dict[str, Any]:
+    text = (arguments.get("text") or "").strip()
+    if not text:
+        raise InvalidParams("text is required")
+    return {"content": [await _speak(text, request_id, _language(arguments))]}
+
+
+async def _voice_chat(arguments: Dict[str, Any], request_id: Optional[str]) -> Dict[str, Any]:
+    mode = arguments.get("mode") or "llm"
+    if mode not in {"llm", "agent"}:
+        raise InvalidParams("mode must be 'llm' or 'agent'")
+    text = arguments.get("text")
+    has_audio = bool(arguments.get("audio_base64"))
+    if (text is None) == (not has_audio):
+        raise InvalidParams("Provide exactly one of audio_base64 or text")
+    language = _language(arguments)
+    turn = await converse(
+        file=_upload(arguments) if has_audio else None,
+        mode=mode,
+        agent_name=arguments.get("agent_name"),
+        session_id=arguments.get("session_id"),
+        request_id=request_id,
+        language=language,
+        text=text,
+    )
+    return {
+        "content": [
+            {"type": "text", "text": turn.reply},
+            await _speak(turn.reply, request_id, language),
+        ],
+        "structuredContent": {"transcription": turn.transcription, "llm_response": turn.reply},
+    }
+
+
+_TOOL_HANDLERS = {
+    "transcribe_audio": _transcribe_audio,
+    "synthesize_speech": _synthesize_speech,
+    "voice_chat": _voice_chat,
+}
+# Called by the names of the HTTP endpoints too; tools/list only shows the names above.
+_TOOL_ALIASES = {"transcribe": "transcribe_audio", "synthesize": "synthesize_speech", "speech_to_speech": "voice_chat"}
+
+
+def _error(msg_id: Any, code: int, message: str) -> Dict[str, Any]:
+    return {"jsonrpc": "2.0", "id": msg_id, "error": {"code": code, "message": message}}
+
+
+async def _call_tool(params: Dict[str, Any], request_id: Optional[str]) -> Dict[str, Any]:
+    name = params.get("name")
+    handler = _TOOL_HANDLERS.get(_TOOL_ALIASES.get(name, name))
+    if handler is None:
+        raise InvalidParams(f"Unknown tool: {params.get('name')}")
+    try:
+        async with pipeline_limiter.slot():
+            return await handler(params.get("arguments") or {}, request_id)
+    except HTTPException as exc:
+        # Pipeline failures are tool results so the calling model can see and react to them.
+        return {"content": [{"type": "text", "text": f"{exc.status_code}: {exc.detail}"}], "isError": True}
+
+
+async def handle_message(message: Any, request_id: Optional[str] = None) -> Optional[Dict[str, Any]]:
+    """Handle one JSON-RPC request or notification."""
+    if not isinstance(message, dict) or message.get("jsonrpc") != "2.0" or "method" not in message:
+        return _error(message.get("id") if isinstance(message, dict) else None, _INVALID_REQUEST, "Invalid request")
+    method = message["method"]
+    msg_id = message.get("id")
+    params = message.get("params") or {}
+    if "id" not in message:
+        return None  # notifications/initialized, notifications/cancelled, ...
+
+    try:
+        if method == "initialize":
+            result = {
+                "protocolVersion": PROTOCOL_VERSION,
+                "capabilities": {"tools": {"listChanged": False}},
+                "serverInfo": SERVER_INFO,
+            }
+        elif method == "ping":
+            result = {}
+        elif method == "tools/list":
+            result = {"tools": TOOLS}
+        elif method == "tools/call":
+            result = await _call_tool(params, request_id)
+        else:
+            return _error(msg_id, _METHOD_NOT_FOUND, f"Method not found: {method}")
+    except InvalidParams as exc:
+        return _error(msg_id, _INVALID_PARAMS, str(exc))
+    except Exception as exc:
+        logger.error(f"MCP {method} failed: {exc}")
+        return _error(msg_id, _INTERNAL_ERROR, "Internal error")
+    return {"jsonrpc": "2.0", "id": msg_id, "result": result}
+
+
+def parse_error() -> Dict[str, Any]:
+    return _error(None, _PARSE_ERROR, "Parse error")
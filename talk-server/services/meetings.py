@@ -0,0 +1,203 @@
+"""Meeting summaries: POST /v1/meetings/summarize turns a recording into minutes.
+
+The recording takes the dictation path (services/dictation.py): long silences are shortened
+and WAV is cut at pauses into parts of about DWANI_DICTATION_SEGMENT_SECONDS, and each part is
+transcribed with ASR asked to label who is speaking. The LLM then writes a summary, key points,
+decisions, and action items (task, owner, due date) from the speaker-labelled transcript, in
+the requested language; the route can also speak the summary.
+
+ASR numbers speakers within each part only, so "Speaker 1" in one part need not be the same
+person in the next. The summary prompt says so, and names are used where the transcript has them.
+"""
+import asyncio
+from dataclasses import asdict, dataclass, field
+from typing import Any, Dict, List, Optional, Tuple
+
+from fastapi import HTTPException, UploadFile
+
+from config import (
+    DICTATION_SEGMENT_SECONDS,
+    DICTATION_SILENCE_KEEP_MS,
+    DICTATION_SILENCE_MS,
+    MAX_UPLOAD_BYTES,
+    MEETING_SUMMARY_MAX_TOKENS,
+    logger,
+)
+from models import GenerationParams
+from services.assessment import json_object
+from services.chat_svc import call_llm
+from services.concurrency import pipeline_limiter
+from services.dictation import SilenceCompression, compress_silence, split_wav
+from services.errors import RequestError, StageError
+from services.latency import observe_stage, set_input_audio, wav_duration
+from services.pipeline import audio_upload
+from services.prompts import language_display_name
+from services.transcribe import prompted_segments, transcribe_audio
+
+_PROMPT = (
+    "You write meeting minutes from a speech-recognition transcript. The recording was "
+    "transcribed in parts and speakers are numbered separately in each part, so the same person "
+    "may have different labels in different parts; call people by name when the transcript "
+    "gives one. Write everything in {language}. Keep the summary to a short paragraph. List "
+    "each action item with who it is assigned to and when it is due, or null when the "
+    "transcript does not say. Reply with only a JSON object: "
+    '{{"summary": "...", "key_points": ["..."], "decisions": ["..."], '
+    '"action_items": [{{"task": "...", "owner": null, "due": null}}]}}'
+)
+
+
+@dataclass
+class MeetingTurn:
+    part: int
+    speaker: Optional[str]
+    text: str
+    start_seconds: float
+    end_seconds: Optional[float]
+
+
+@dataclass
+class ActionItem:
+    task: str
+    owner: Optional[str] = None
+    due: Optional[str] = None
+
+
+@dataclass
+class MeetingSummary:
+    summary: str
+    key_points: List[str] = field(default_factory=list)
+    decisions: List[str] = field(default_factory=list)
+    action_items: List[ActionItem] = field(default_factory=list)
+    transcript: List[MeetingTurn] = field(default_factory=list)
+    silence: Optional[Dict[str, Any]] = None
+
+    def as_dict(self) -> Dict[str, Any]:
+        return asdict(self)
+
+    def spoken_text(self) -> str:
+        """The summary and action items as one text for TTS."""
+        lines = [self.summary]
+        for item in self.action_items:
+            details = ", ".join(part for part in (item.owner, item.due) if part)
+            lines.append(f"{item.task} ({details})." if details else f"{item.task}.")
+        return " ".join(line.strip() for line in lines if line.strip())
+
+
+def _clock(seconds: float) -> str:
+    minutes, secs = divmod(int(seconds), 60)
+    return f"{minutes // 60:02d}:{minutes % 60:02d}:{secs:02d}"
+
+
+def meeting_transcript(turns: List[MeetingTurn]) -> str:
+    lines: List[str] = []
+    part = None
+    for turn in turns:
+        if turn.part != part:
+            part = turn.part
+            lines.append(f"--- Part {part + 1} (from {_clock(turn.start_seconds)}) ---")
+        lines.append(f"{turn.speaker}: {turn.text}" if turn.speaker else turn.text)
+    return "\n".join(lines)
+
+
+def _optional(value: Any) -> Optional[str]:
+    text = str(value).strip() if value is not None else ""
+    return text if text and text.lower() not in ("null", "none", "unknown") else None
+
+
+def _strings(value: Any) -> List[str]:
+    if not isinstance(value, list):
+        return []
+    return [str(v).strip() for v in value if str(v).strip()]
+
+
+def parse_summary(raw: str) -> MeetingSummary:
+    """MeetingSummary from the LLM's JSON; StageError when it is not usable."""
+    try:
+        data = json_object(raw)
+    except ValueError as exc:
+        logger.warning("Meeting summary was not JSON (%s): %.200s", exc, raw)
+        raise StageError("llm", detail="LLM returned an invalid meeting summary")
+    items = [
+        ActionItem(task=str(item.get("task")).strip(), owner=_optional(item.get("owner")), due=_optional(item.get("due")))
+        for item in data.get("action_items") or []
+        if isinstance(item, dict) and str(item.get("task") or "").strip()
+    ]
+    return MeetingSummary(
+        summary=str(data.get("summary") or "").strip(),
+        key_points=_strings(data.get("key_points")),
+        decisions=_strings(data.get("decisions")),
+        action_items=items,
+    )
+
+
+async def transcribe_meeting(
+    data: bytes,
+    content_type: Optional[str],
+    filename: Optional[str],
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+) -> Tuple[List[MeetingTurn], Optional[SilenceCompression]]:
+    """Speaker turns part by part, with times in the original recording."""
+    compression = None
+    if DICTATION_SILENCE_MS > 0:
+        compressed = await asyncio.to_thread(compress_silence, data, DICTATION_SILENCE_MS, DICTATION_SILENCE_KEEP_MS)
+        if compressed is not None:
+            data, compression = compressed
+    parts = split_wav(data, DICTATION_SEGMENT_SECONDS)
+    mime_type = "audio/wav" if parts is not None else (content_type or "audio/wav")
+    turns: List[MeetingTurn] = []
+    for index, (start, end, audio) in enumerate(parts if parts is not None else [(0.0, None, data)]):
+        set_input_audio(wav_duration(audio[:4096]))
+        # One slot per part, as for dictation.
+        async with pipeline_limiter.slot():
+            with observe_stage("asr"):
+                result = await transcribe_audio(
+                    audio_upload(audio, mime_type, filename or "audio"),
+                    language=language,
+                    request_id=request_id,
+                    diarize=True,
+                )
+        for segment in result.segments or prompted_segments(result.text):
+            # Times of natively diarized segments are within the part; the others get the part's.
+            turn_start = start + segment.start_seconds if segment.start_seconds is not None else start
+            turn_end = start + segment.end_seconds if segment.end_seconds is not None else end
+            if compression is not None:
+                turn_start = compression.original_time(turn_start)
+                turn_end = compression.original_time(turn_end) if turn_end is not None else None
+            turns.append(MeetingTurn(
+                part=index,
+                speaker=segment.speaker,
+                text=segment.text,
+                start_seconds=round(turn_start, 3),
+                end_seconds=round(turn_end, 3) if turn_end is not None else None,
+            ))
+    return turns, compression
+
+
+async def summarize_meeting(
+    file: UploadFile,
+    language: Optional[str] = None,
+    summary_language: Optional[str] = None,
+    request_id: Optional[str] = None,
+) -> MeetingSummary:
+    """Transcribe a meeting recording with speaker labels and have the LLM write the minutes."""
+    data = await file.read()
+    if len(data) > MAX_UPLOAD_BYTES:
+        raise HTTPException(status_code=413, detail=f"File too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+    if not data:
+        raise RequestError(400, "empty_input", "Empty audio file")
+    turns, compression = await transcribe_meeting(data, file.content_type, file.filename, language, request_id)
+    if not turns:
+        raise RequestError(400, "no_speech_detected", "No speech found in the recording")
+    # The minutes take a slot of their own, after the transcription's have been given back.
+    async with pipeline_limiter.slot():
+        raw = await call_llm(
+            meeting_transcript(turns),
+            request_id=request_id,
+            generation=GenerationParams(temperature=0, max_tokens=MEETING_SUMMARY_MAX_TOKENS),
+            system_prompt=_PROMPT.format(language=language_display_name(summary_language or language or "english")),
+        )
+    summary = parse_summary(raw)
+    summary.transcript = turns
+    summary.silence = compression.as_dict() if compression is not None else None
+    return summary
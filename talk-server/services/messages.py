@@ -0,0 +1,126 @@
+"""Built-in catalog of the fixed sentences the server itself says.
+
+Re-prompts for grammars without their own, the confirmation flow's apologies, the loop
+breaker's fallback, the reply when the LLM stays empty, the request to repeat an unclear
+transcript and the phone error prompt are spoken to the user, so they are kept here in English,
+Kannada, Hindi and Tamil and picked by the turn's language. Other languages get English. Text
+configured in the environment (DWANI_LOOP_FALLBACK_REPLY, DWANI_LLM_EMPTY_FALLBACK_REPLY,
+DWANI_ASR_CLARIFY_REPLY, DWANI_TWILIO_ERROR_PROMPT, DWANI_TWILIO_GREETING) or in a grammar's
+reprompt takes precedence, and DWANI_BRANDING_FILE over that (services/branding.py).
+"""
+import threading
+from typing import Dict, Optional, Tuple
+
+from config import BRANDING_FILE
+from models import language_alias
+from services.branding import Phrase, current_tenant, load_branding, tenant_for_host, use_tenant
+
+CATALOG: Dict[str, Dict[str, str]] = {
+    "reprompt_digits": {
+        "english": "Sorry, I didn't catch that. Please say the number one digit at a time.",
+        "kannada": "ಕ್ಷಮಿಸಿ, ನನಗೆ ಅರ್ಥವಾಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಸಂಖ್ಯೆಯನ್ನು ಒಂದೊಂದೇ ಅಂಕಿಯಾಗಿ ಹೇಳಿ.",
+        "hindi": "माफ़ कीजिए, मैं समझ नहीं पाया। कृपया नंबर एक-एक अंक करके बोलिए।",
+        "tamil": "மன்னிக்கவும், எனக்குப் புரியவில்லை. தயவுசெய்து எண்ணை ஒவ்வொரு இலக்கமாகச் சொல்லுங்கள்.",
+    },
+    "reprompt_either": {
+        "english": "Sorry, I didn't catch that. Please say {first} or {second}.",
+        "kannada": "ಕ್ಷಮಿಸಿ, ನನಗೆ ಅರ್ಥವಾಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು {first} ಅಥವಾ {second} ಎಂದು ಹೇಳಿ.",
+        "hindi": "माफ़ कीजिए, मैं समझ नहीं पाया। कृपया {first} या {second} बोलिए।",
+        "tamil": "மன்னிக்கவும், எனக்குப் புரியவில்லை. தயவுசெய்து {first} அல்லது {second} என்று சொல்லுங்கள்.",
+    },
+    "reprompt_choices": {
+        "english": "Sorry, I didn't catch that. Please say one of: {options}.",
+        "kannada": "ಕ್ಷಮಿಸಿ, ನನಗೆ ಅರ್ಥವಾಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಇವುಗಳಲ್ಲಿ ಒಂದನ್ನು ಹೇಳಿ: {options}.",
+        "hindi": "माफ़ कीजिए, मैं समझ नहीं पाया। कृपया इनमें से एक बोलिए: {options}।",
+        "tamil": "மன்னிக்கவும், எனக்குப் புரியவில்லை. தயவுசெய்து இவற்றில் ஒன்றைச் சொல்லுங்கள்: {options}.",
+    },
+    "confirm_lost": {
+        "english": "Sorry, I lost track of that request. Please ask again.",
+        "kannada": "ಕ್ಷಮಿಸಿ, ಆ ವಿನಂತಿ ನನಗೆ ತಪ್ಪಿಹೋಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೊಮ್ಮೆ ಕೇಳಿ.",
+        "hindi": "माफ़ कीजिए, वह अनुरोध मुझसे छूट गया। कृपया फिर से बताइए।",
+        "tamil": "மன்னிக்கவும், அந்தக் கோரிக்கையை நான் தவறவிட்டேன். தயவுசெய்து மீண்டும் கேளுங்கள்.",
+    },
+    "confirm_failed": {
+        "english": "Sorry, I couldn't complete that right now. Please try again later.",
+        "kannada": "ಕ್ಷಮಿಸಿ, ಈಗ ಅದನ್ನು ಪೂರ್ಣಗೊಳಿಸಲು ಆಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ನಂತರ ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "माफ़ कीजिए, अभी यह पूरा नहीं हो सका। कृपया बाद में फिर कोशिश कीजिए।",
+        "tamil": "மன்னிக்கவும், இப்போது அதை முடிக்க முடியவில்லை. தயவுசெய்து பிறகு மீண்டும் முயற்சிக்கவும்.",
+    },
+    "loop_fallback": {
+        "english": "Sorry, I lost my train of thought. Could you say that again?",
+        "kannada": "ಕ್ಷಮಿಸಿ, ನಾನು ಹೇಳುತ್ತಿದ್ದುದು ಮರೆತುಹೋಯಿತು. ದಯವಿಟ್ಟು ಮತ್ತೊಮ್ಮೆ ಹೇಳುತ್ತೀರಾ?",
+        "hindi": "माफ़ कीजिए, मैं अपनी बात भूल गया। क्या आप फिर से कह सकते हैं?",
+        "tamil": "மன்னிக்கவும், நான் சொல்ல வந்ததை மறந்துவிட்டேன். மீண்டும் சொல்ல முடியுமா?",
+    },
+    "no_reply": {
+        "english": "Sorry, I don't have an answer for that. Could you ask another way?",
+        "kannada": "ಕ್ಷಮಿಸಿ, ಅದಕ್ಕೆ ನನ್ನ ಬಳಿ ಉತ್ತರವಿಲ್ಲ. ದಯವಿಟ್ಟು ಬೇರೆ ರೀತಿಯಲ್ಲಿ ಕೇಳುತ್ತೀರಾ?",
+        "hindi": "माफ़ कीजिए, मेरे पास इसका जवाब नहीं है। क्या आप किसी और तरह से पूछ सकते हैं?",
+        "tamil": "மன்னிக்கவும், அதற்கு என்னிடம் பதில் இல்லை. வேறு விதமாகக் கேட்க முடியுமா?",
+    },
+    "clarify": {
+        "english": "Sorry, I didn't quite catch that. Could you repeat it?",
+        "kannada": "ಕ್ಷಮಿಸಿ, ನನಗೆ ಸರಿಯಾಗಿ ಕೇಳಿಸಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಮತ್ತೊಮ್ಮೆ ಹೇಳುತ್ತೀರಾ?",
+        "hindi": "माफ़ कीजिए, मैं ठीक से सुन नहीं पाया। क्या आप दोहरा सकते हैं?",
+        "tamil": "மன்னிக்கவும், எனக்குச் சரியாகக் கேட்கவில்லை. மீண்டும் சொல்ல முடியுமா?",
+    },
+    "call_error": {
+        "english": "Sorry, I could not answer that. Please try again.",
+        "kannada": "ಕ್ಷಮಿಸಿ, ಅದಕ್ಕೆ ಉತ್ತರಿಸಲು ನನಗೆ ಆಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು ಮತ್ತೆ ಪ್ರಯತ್ನಿಸಿ.",
+        "hindi": "माफ़ कीजिए, मैं इसका जवाब नहीं दे सका। कृपया फिर से कोशिश कीजिए।",
+        "tamil": "மன்னிக்கவும், அதற்கு என்னால் பதிலளிக்க முடியவில்லை. தயவுசெய்து மீண்டும் முயற்சிக்கவும்.",
+    },
+}
+
+# Phrases with no built-in wording, only what DWANI_BRANDING_FILE or the environment gives them.
+GREETING = "greeting"
+
+BRANDING = load_branding(BRANDING_FILE, [*CATALOG, GREETING])
+
+# (tenant, language, text) -> branded recording, for each recorded phrase once it has been said.
+_recordings: Dict[Tuple[Optional[str], str, str], Phrase] = {}
+_recordings_lock = threading.Lock()
+
+
+def _language(language: Optional[str]) -> str:
+    return str(language_alias(language or "")).strip().lower()
+
+
+def brand_for_host(host: Optional[str]) -> None:
+    """Say the phrases of the tenant the Host header names for the rest of this request or call."""
+    use_tenant(tenant_for_host(host, BRANDING.tenants))
+
+
+def _branded(key: str, language: Optional[str]) -> Optional[Phrase]:
+    return BRANDING.phrase(key, language, current_tenant())
+
+
+def _said(phrase: Optional[Phrase], language: Optional[str], text: str) -> str:
+    if phrase is not None and phrase.audio is not None and text:
+        with _recordings_lock:
+            _recordings[(current_tenant(), _language(language), text)] = phrase
+    return text
+
+
+def recording(text: str, language: Optional[str]) -> Optional[Tuple[str, bytes]]:
+    """(content type, audio) of the branded recording whose phrase text is, to play instead of synthesizing text."""
+    phrase = _recordings.get((current_tenant(), _language(language), text))
+    return (phrase.content_type, phrase.audio) if phrase is not None else None
+
+
+def localized(key: str, language: Optional[str], **params: str) -> Optional[str]:
+    """The branded or catalog text for key in language; None when neither has it in that language."""
+    phrase = _branded(key, language)
+    text = phrase.text if phrase is not None and phrase.text else CATALOG.get(key, {}).get(_language(language))
+    return _said(phrase, language, text.format(**params)) if text is not None else None
+
+
+def message(key: str, language: Optional[str] = None, configured: str = "", **params: str) -> str:
+    """The branded text for key, else configured (the environment's), else the catalog's in language or English."""
+    phrase = _branded(key, language)
+    if phrase is not None and phrase.text:
+        return _said(phrase, language, phrase.text.format(**params))
+    if configured:
+        return _said(phrase, language, configured)
+    text = localized(key, language, **params) or CATALOG.get(key, {}).get("english", "").format(**params)
+    return _said(phrase, language, text)
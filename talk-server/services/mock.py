@@ -0,0 +1,117 @@
+"""Offline stand-ins for the ASR, LLM and TTS backends, for running the gateway on a laptop.
+
+With DWANI_MOCK_BACKENDS=1 (or `python main.py --mock` / `python talk.py serve --mock`) the
+shared HTTP client (services/http_client.py) answers every upstream request in-process, so
+nothing leaves the machine: ASR returns the DWANI_MOCK_TRANSCRIPTS phrases in turn, the LLM
+replies with DWANI_MOCK_REPLY filled in with the user's text, and TTS returns the bundled
+sample, a silent MP3 as long as the reply would take to say (or DWANI_MOCK_TTS_FILE, when
+set). Backend URLs that are not configured point at mock hosts so no setting is required.
+The rest of the pipeline (sessions, hooks, captions, error envelopes) runs unchanged.
+"""
+import itertools
+import json
+import time
+from typing import Any, Dict, List
+
+import httpx
+
+from config import (
+    CAPTION_CHARS_PER_SECOND,
+    MOCK_BACKENDS,
+    MOCK_REPLY,
+    MOCK_TRANSCRIPTS,
+    MOCK_TTS_FILE,
+)
+from services.audio_format import detect_format
+
+MOCK_URLS = {
+    "asr": "http://mock-asr/v1/chat/completions",
+    "llm": "http://mock-llm",
+    "tts": "http://mock-tts",
+    "agent": "http://mock-agent",
+}
+# MPEG-1 Layer III, 32 kbit/s, 44.1 kHz, mono: 104-byte frames of 1152 samples whose
+# zeroed side information decodes as silence.
+_MP3_FRAME = b"\xff\xfb\x10\xc4" + bytes(100)
+_MP3_FRAME_SECONDS = 1152 / 44100
+
+_enabled = MOCK_BACKENDS
+_transcripts = itertools.cycle(MOCK_TRANSCRIPTS or ["Hello, this is a mock transcript."])
+
+
+def enable() -> None:
+    """Turn mock mode on for this process (before the first upstream call)."""
+    global _enabled
+    _enabled = True
+
+
+def enabled() -> bool:
+    return _enabled
+
+
+def silent_mp3(seconds: float) -> bytes:
+    return _MP3_FRAME * max(1, round(seconds / _MP3_FRAME_SECONDS))
+
+
+def _completion(content: str, model: str) -> Dict[str, Any]:
+    return {
+        "id": "mock-completion",
+        "object": "chat.completion",
+        "created": int(time.time()),
+        "model": model or "mock",
+        "choices": [{"index": 0, "message": {"role": "assistant", "content": content}, "finish_reason": "stop"}],
+        "usage": {"prompt_tokens": 0, "completion_tokens": len(content.split()), "total_tokens": len(content.split())},
+    }
+
+
+def _parts(message: Dict[str, Any]) -> List[Any]:
+    content = message.get("content")
+    return content if isinstance(content, list) else [{"type": "text", "text": content or ""}]
+
+
+def _chat(payload: Dict[str, Any]) -> Dict[str, Any]:
+    messages = payload.get("messages") or [{}]
+    parts = [part for message in messages for part in _parts(message) if isinstance(part, dict)]
+    kinds = {part.get("type") for part in parts}
+    if kinds & {"audio_url", "input_audio"}:
+        return _completion(next(_transcripts), payload.get("model", ""))
+    if "image_url" in kinds:
+        return _completion("This is a mock description of the image.", payload.get("model", ""))
+    text = " ".join(part.get("text", "") for part in _parts(messages[-1]) if isinstance(part, dict)).strip()
+    return _completion(MOCK_REPLY.replace("{text}", text), payload.get("model", ""))
+
+
+def _speech(payload: Dict[str, Any]) -> httpx.Response:
+    if MOCK_TTS_FILE:
+        with open(MOCK_TTS_FILE, "rb") as f:
+            audio = f.read()
+        content_type = {"wav": "audio/wav", "ogg": "audio/ogg"}.get(detect_format(None, audio[:12]), "audio/mpeg")
+        return httpx.Response(200, content=audio, headers={"Content-Type": content_type})
+    seconds = len(str(payload.get("text", ""))) / CAPTION_CHARS_PER_SECOND
+    return httpx.Response(200, content=silent_mp3(seconds), headers={"Content-Type": "audio/mpeg"})
+
+
+def handler(request: httpx.Request) -> httpx.Response:
+    """Answer one upstream request the way the matching backend would."""
+    path = request.url.path
+    if request.method != "POST":
+        return httpx.Response(200, json={"object": "list", "data": [{"id": "mock", "object": "model"}]})
+    try:
+        payload = json.loads(request.content or b"{}")
+    except ValueError:
+        payload = {}
+    if not isinstance(payload, dict):
+        payload = {}
+    if path.startswith("/v1/agents/"):
+        return httpx.Response(200, json={"reply": MOCK_REPLY.replace("{text}", str(payload.get("message", "")))})
+    if path.endswith("/chat/completions"):
+        return httpx.Response(200, json=_chat(payload))
+    if path.endswith("/audio/speech"):
+        return _speech(payload)
+    if path.endswith("/moderations"):
+        return httpx.Response(200, json={"results": [{"flagged": False, "categories": {}}]})
+    return httpx.Response(200, json={})
+
+
+def transport() -> httpx.MockTransport:
+    return httpx.MockTransport(handler)
@@ -0,0 +1,150 @@
+"""Content moderation between ASR and the LLM, and between the LLM and TTS.
+
+Text is checked against a blocklist (DWANI_MODERATION_BLOCKLIST, comma-separated, and/or
+DWANI_MODERATION_BLOCKLIST_FILE, one term per line) and, when DWANI_MODERATION_URL is set,
+an OpenAI-compatible /v1/moderations endpoint. Blocklisted terms are masked
+(DWANI_MODERATION_ACTION=mask) or reject the turn (block); text the moderation API flags is
+always rejected, as a 422 with error.stage "moderation" and the direction and categories in
+error.details. The checks run as before_llm / after_llm pipeline hooks (services/hooks.py),
+so they see the text after any plugin normalization, and on /v1/chat.
+"""
+import re
+from typing import Dict, List, Optional, Tuple
+
+import httpx
+from prometheus_client import Counter
+
+from config import (
+    MODERATION_ACTION,
+    MODERATION_BLOCKLIST,
+    MODERATION_BLOCKLIST_FILE,
+    MODERATION_FAIL_OPEN,
+    MODERATION_MASK,
+    MODERATION_STAGES,
+    MODERATION_TIMEOUT,
+    MODERATION_URL,
+    logger,
+)
+from services.errors import StageError
+from services.flags import enabled as flag_enabled
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.http_client import http_client
+
+_ACTIONS = Counter("dwani_moderation_actions_total", "Texts masked or blocked by moderation", ["direction", "action"])
+# Letters of any script the replies use, including Indic vowel signs, which \w does not cover.
+_WORD_CHARS = r"\w\u0900-\u0DFF"
+
+
+class ContentBlocked(StageError):
+    """Text rejected by moderation; reported as a 422 with stage "moderation"."""
+
+    def __init__(self, direction: str, categories: List[str]):
+        self.direction = direction
+        self.categories = categories
+        message = "Your message was blocked by content moderation" if direction == "input" else (
+            "The reply was blocked by content moderation"
+        )
+        super().__init__(
+            "moderation", status_code=422, detail=message, details={"direction": direction, "categories": categories}
+        )
+
+
+def load_blocklist(terms: str = MODERATION_BLOCKLIST, path: str = MODERATION_BLOCKLIST_FILE) -> List[str]:
+    found = [t.strip() for t in terms.split(",") if t.strip()]
+    if path:
+        with open(path, encoding="utf-8") as f:
+            found.extend(line.strip() for line in f if line.strip() and not line.lstrip().startswith("#"))
+    return list(dict.fromkeys(found))
+
+
+def blocklist_pattern(terms: List[str]) -> Optional[re.Pattern]:
+    """Whole-word, case-insensitive match of any term; longest terms first so phrases win."""
+    if not terms:
+        return None
+    alternatives = "|".join(re.escape(t) for t in sorted(terms, key=len, reverse=True))
+    return re.compile(rf"(?<![{_WORD_CHARS}])(?:{alternatives})(?![{_WORD_CHARS}])", re.IGNORECASE)
+
+
+class Moderator:
+    def __init__(
+        self,
+        blocklist: Optional[List[str]] = None,
+        action: str = MODERATION_ACTION,
+        mask: str = MODERATION_MASK,
+        url: str = MODERATION_URL,
+        fail_open: bool = MODERATION_FAIL_OPEN,
+    ):
+        self.pattern = blocklist_pattern(blocklist if blocklist is not None else load_blocklist())
+        self.action = action
+        self.mask = mask
+        self.url = url
+        self.fail_open = fail_open
+
+    @property
+    def enabled(self) -> bool:
+        return self.pattern is not None or bool(self.url)
+
+    def apply_blocklist(self, text: str, direction: str) -> str:
+        if self.pattern is None or not self.pattern.search(text):
+            return text
+        if self.action == "block":
+            _ACTIONS.labels(direction=direction, action="blocked").inc()
+            raise ContentBlocked(direction, ["blocklist"])
+        _ACTIONS.labels(direction=direction, action="masked").inc()
+        return " ".join(self.pattern.sub(self.mask, text).split())
+
+    async def flagged(self, text: str, request_id: Optional[str] = None) -> Tuple[bool, List[str]]:
+        """(flagged, categories) from the moderation API."""
+        headers = {"X-Request-ID": request_id} if request_id else {}
+        try:
+            resp = await http_client().post(self.url, json={"input": text}, headers=headers, timeout=MODERATION_TIMEOUT)
+            resp.raise_for_status()
+            results: List[Dict] = resp.json().get("results") or []
+        except (httpx.HTTPError, ValueError, AttributeError) as exc:
+            logger.error("Moderation request failed: %s", exc)
+            if self.fail_open:
+                return False, []
+            raise StageError("moderation", status_code=503, detail="Moderation service unavailable")
+        categories = sorted({
+            name for result in results if result.get("flagged")
+            for name, hit in (result.get("categories") or {}).items() if hit
+        })
+        return any(result.get("flagged") for result in results), categories
+
+    async def check(self, text: str, direction: str, request_id: Optional[str] = None) -> str:
+        """text, masked where needed; raises ContentBlocked when it may not pass."""
+        text = self.apply_blocklist(text, direction)
+        if self.url:
+            flagged, categories = await self.flagged(text, request_id)
+            if flagged:
+                _ACTIONS.labels(direction=direction, action="blocked").inc()
+                raise ContentBlocked(direction, categories)
+        return text
+
+
+moderator = Moderator()
+
+
+async def moderate(text: str, direction: str, request_id: Optional[str] = None) -> str:
+    """Check text with the server-wide moderator when direction (and the moderation flag) is enabled; unchanged otherwise."""
+    if not moderator.enabled or direction not in MODERATION_STAGES or not flag_enabled("moderation"):
+        return text
+    return await moderator.check(text, direction, request_id)
+
+
+async def _moderate_input(ctx: HookContext, text: str) -> str:
+    return await moderate(text, "input", ctx.request_id)
+
+
+async def _moderate_output(ctx: HookContext, text: str) -> str:
+    return await moderate(text, "output", ctx.request_id)
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the moderation hooks when a blocklist or moderation API is configured."""
+    if not moderator.enabled or _moderate_input in registry.registered("before_llm"):
+        return False
+    registry.register("before_llm", _moderate_input)
+    registry.register("after_llm", _moderate_output)
+    logger.info("Content moderation enabled for %s", ", ".join(sorted(MODERATION_STAGES)) or "no stages")
+    return True
@@ -0,0 +1,113 @@
+"""Local ASR and TTS for when the remote backends cannot be reached.
+
+Kiosks in places with poor connectivity keep basic speech in and out. With DWANI_OFFLINE_ASR_MODEL
+(a whisper.cpp ggml model, through pywhispercpp) transcription falls back to it, and with
+DWANI_OFFLINE_TTS_VOICES (piper voices per language, "default" for the rest) synthesis does,
+whenever the backend is unreachable: a connection failure, a timeout, or no instance to send to.
+A backend that answers with an error or garbage is not bypassed.
+
+The bindings are optional (requirements-offline.txt) and imported on first use, and each model is
+loaded once per worker. Local transcripts have no confidence, alternatives or timed segments, and
+local speech is WAV whatever format was asked for. If the local model fails too, the request gets
+the backend's error.
+"""
+import asyncio
+import io
+import shutil
+import tempfile
+import threading
+import wave
+from typing import IO, Any, Dict, Optional
+
+from fastapi import UploadFile
+from prometheus_client import Counter
+
+from config import OFFLINE_ASR_MODEL, OFFLINE_ASR_THREADS, OFFLINE_TTS_VOICES, UPLOAD_SPOOL_DIR, logger
+from models import LANGUAGE_TAGS
+from services.deadline import StageTimeout
+from services.errors import StageError
+
+_FALLBACKS = Counter("dwani_offline_fallbacks_total", "Requests served locally because the backend was unreachable", ["stage", "outcome"])
+
+_asr_lock = threading.Lock()
+_tts_lock = threading.Lock()
+_asr_model: Any = None
+_voices: Dict[str, Any] = {}
+
+
+def unreachable(exc: StageError) -> bool:
+    """Whether exc means the stage's backend could not be reached, rather than that it answered badly."""
+    return isinstance(exc, StageTimeout) or exc.status_code == 503 or exc.error_code == f"{exc.stage}_unavailable"
+
+
+def voice_path(language: Optional[str]) -> Optional[str]:
+    """The piper voice for language from DWANI_OFFLINE_TTS_VOICES; None when there is none."""
+    return OFFLINE_TTS_VOICES.get((language or "").lower()) or OFFLINE_TTS_VOICES.get("default")
+
+
+def _model() -> Any:
+    global _asr_model
+    if _asr_model is None:
+        from pywhispercpp.model import Model
+
+        _asr_model = Model(OFFLINE_ASR_MODEL, n_threads=OFFLINE_ASR_THREADS, print_progress=False, print_realtime=False)
+        logger.info("Loaded the offline ASR model %s", OFFLINE_ASR_MODEL)
+    return _asr_model
+
+
+def _transcribe(file: IO[bytes], language: Optional[str]) -> str:
+    tag = LANGUAGE_TAGS.get(language or "")
+    file.seek(0)
+    # whisper.cpp decodes from a path (with ffmpeg), so the upload is copied out of its spool.
+    with tempfile.NamedTemporaryFile(dir=UPLOAD_SPOOL_DIR, suffix=".audio") as copy:
+        shutil.copyfileobj(file, copy)
+        copy.flush()
+        with _asr_lock:
+            segments = _model().transcribe(copy.name, language=tag.split("-")[0] if tag else "auto")
+    file.seek(0)
+    return " ".join(segment.text.strip() for segment in segments if segment.text.strip())
+
+
+def _synthesize(text: str, path: str) -> bytes:
+    with _tts_lock:
+        voice = _voices.get(path)
+        if voice is None:
+            from piper import PiperVoice
+
+            voice = _voices[path] = PiperVoice.load(path)
+            logger.info("Loaded the offline TTS voice %s", path)
+    buffer = io.BytesIO()
+    with wave.open(buffer, "wb") as wav:
+        voice.synthesize_wav(text, wav)
+    return buffer.getvalue()
+
+
+async def recognize(file: UploadFile, language: Optional[str], exc: StageError) -> Optional[str]:
+    """The local transcript of file when exc says the ASR backend is unreachable; None when there is none."""
+    if not OFFLINE_ASR_MODEL or not unreachable(exc):
+        return None
+    logger.warning("ASR backend unreachable (%s); transcribing locally", exc.detail)
+    try:
+        text = await asyncio.to_thread(_transcribe, file.file, language)
+    except Exception:
+        _FALLBACKS.labels(stage="asr", outcome="error").inc()
+        logger.exception("Offline ASR failed")
+        return None
+    _FALLBACKS.labels(stage="asr", outcome="ok").inc()
+    return text
+
+
+async def speak(text: str, language: Optional[str], exc: StageError) -> Optional[bytes]:
+    """WAV of text from the local voice when exc says the TTS backend is unreachable; None when there is none."""
+    path = voice_path(language)
+    if path is None or not unreachable(exc):
+        return None
+    logger.warning("TTS backend unreachable (%s); synthesizing locally", exc.detail)
+    try:
+        audio = await asyncio.to_thread(_synthesize, text, path)
+    except Exception:
+        _FALLBACKS.labels(stage="tts", outcome="error").inc()
+        logger.exception("Offline TTS failed")
+        return None
+    _FALLBACKS.labels(stage="tts", outcome="ok").inc()
+    return audio
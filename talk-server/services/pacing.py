@@ -0,0 +1,112 @@
+"""Pacing of calls toward each backend instance, so a burst of clients does not trip its rate limiter.
+
+Hosted providers enforce their own limits and answer a burst with 429s that the client would
+see. DWANI_UPSTREAM_MAX_RPS="llm=5,tts=20" holds each LLM instance (each URL in its pool,
+services/balancer.py) to 5 calls a second and each TTS instance to 20;
+DWANI_UPSTREAM_MAX_CONCURRENT="llm=4" also caps the calls one instance has in flight. Up to
+DWANI_UPSTREAM_BURST calls go at once after a quiet spell. A call over the pace waits its turn
+instead, for at most DWANI_UPSTREAM_MAX_WAIT seconds (or the request deadline); a call that
+would wait longer gets the 503 with Retry-After of a full pipeline. Background jobs wait as long
+as it takes.
+
+This is separate from the client rate limits (ratelimit.py), which reject rather than queue, and
+from DWANI_STAGE_MAX_CONCURRENT (services/concurrency.py), which caps a stage over all of its
+instances. Like them it is per worker process, so divide the provider's limit by the workers.
+"""
+import asyncio
+import time
+from contextlib import asynccontextmanager
+from typing import AsyncIterator, Dict, Optional, Tuple
+
+from prometheus_client import Counter
+
+from config import UPSTREAM_BURST, UPSTREAM_MAX_CONCURRENT, UPSTREAM_MAX_RPS, UPSTREAM_MAX_WAIT
+from services.concurrency import PipelineBusy, background_call
+from services.deadline import remaining
+
+_DELAYED = Counter("dwani_upstream_paced_calls_total", "Upstream calls held back to keep to the instance's pace", ["stage"])
+_DELAY_SECONDS = Counter("dwani_upstream_pacing_delay_seconds_total", "Seconds upstream calls were held back", ["stage"])
+_REJECTED = Counter("dwani_upstream_pacing_rejected_total", "Upstream calls turned away rather than held back too long", ["stage"])
+
+
+class Pacer:
+    """One instance's pace: at most rps calls a second (bursts of burst) and max_concurrent in flight; 0 disables either."""
+
+    def __init__(self, stage: str, rps: float = 0, max_concurrent: int = 0, burst: int = UPSTREAM_BURST, max_wait: float = UPSTREAM_MAX_WAIT):
+        self.stage = stage
+        self.rps = rps
+        self.burst = max(1, burst)
+        self.max_wait = max_wait
+        self._tokens = float(self.burst)
+        self._updated = time.monotonic()
+        self._in_flight = asyncio.Semaphore(max_concurrent) if max_concurrent > 0 else None
+
+    def _budget(self) -> Optional[float]:
+        """Seconds a call may be held back; None for background jobs."""
+        if background_call():
+            return None
+        left = remaining()
+        return self.max_wait if left is None else max(0.0, min(self.max_wait, left))
+
+    def _reject(self) -> None:
+        _REJECTED.labels(stage=self.stage).inc()
+        raise PipelineBusy()
+
+    def _reserve(self, budget: Optional[float]) -> float:
+        """Take the call's turn; seconds until it is due."""
+        now = time.monotonic()
+        self._tokens = min(float(self.burst), self._tokens + (now - self._updated) * self.rps)
+        self._updated = now
+        # Turns are handed out in arrival order: the bucket goes negative for calls already waiting.
+        wait = 0.0 if self._tokens >= 1 else (1 - self._tokens) / self.rps
+        if budget is not None and wait > budget:
+            self._reject()
+        self._tokens -= 1
+        return wait
+
+    @asynccontextmanager
+    async def slot(self) -> AsyncIterator[None]:
+        budget = self._budget()
+        started = time.monotonic()
+        if self._in_flight is not None:
+            try:
+                await asyncio.wait_for(self._in_flight.acquire(), budget)
+            except asyncio.TimeoutError:
+                self._reject()
+        try:
+            if self.rps > 0:
+                left = None if budget is None else max(0.0, budget - (time.monotonic() - started))
+                wait = self._reserve(left)
+                if wait > 0:
+                    await asyncio.sleep(wait)
+            held = time.monotonic() - started
+            if held > 0.001:
+                _DELAYED.labels(stage=self.stage).inc()
+                _DELAY_SECONDS.labels(stage=self.stage).inc(held)
+            yield
+        finally:
+            if self._in_flight is not None:
+                self._in_flight.release()
+
+
+_pacers: Dict[Tuple[str, str], Optional[Pacer]] = {}
+
+
+def pacer(stage: str, url: str) -> Optional[Pacer]:
+    """The pacer of stage's instance at url; None when the stage is not paced."""
+    key = (stage, url)
+    if key not in _pacers:
+        rps, max_concurrent = UPSTREAM_MAX_RPS.get(stage, 0), UPSTREAM_MAX_CONCURRENT.get(stage, 0)
+        _pacers[key] = Pacer(stage, rps, max_concurrent) if rps > 0 or max_concurrent > 0 else None
+    return _pacers[key]
+
+
+@asynccontextmanager
+async def paced(stage: str, url: str) -> AsyncIterator[None]:
+    """Hold a call to stage's instance at url until it is due (see the module docstring)."""
+    instance_pacer = pacer(stage, url)
+    if instance_pacer is None:
+        yield
+        return
+    async with instance_pacer.slot():
+        yield
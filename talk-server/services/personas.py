@@ -0,0 +1,90 @@
+"""Assistant personas: the instructions at the start of the conversational system prompt, and
+how long a spoken reply may be.
+
+The built-in "default" persona is the one-line voice assistant; DWANI_SYSTEM_PROMPT replaces
+its instructions. Deployments define more (or redefine "default") in DWANI_PERSONAS_FILE:
+
+    {"tutor": {"instructions": "You are a patient language tutor. Correct mistakes gently.",
+               "max_reply_chars": 400},
+     "concierge": "You are a hotel concierge. Answer in one short sentence."}
+
+DWANI_PERSONA picks the server's persona, and a request may pick another by name with
+persona=<name>; there are no inline personas, so clients cannot rewrite the prompt. The
+instructions may reference {slots} like DWANI_SYSTEM_PROMPT (services/prompts.py), and the
+language instruction is appended as usual. max_reply_chars holds the reply to that many
+characters the way DWANI_TURN_MAX_TTS_CHARS does (services/budget.py), whichever is lower.
+A tenant (services/tenants.py) may have a persona of its own and only allow some of them.
+"""
+import json
+from dataclasses import dataclass
+from typing import Any, Dict, Optional
+
+from fastapi import HTTPException
+
+from config import PERSONA, PERSONAS_FILE, logger
+from services.tenants import current_tenant_settings, tenants
+
+DEFAULT_PERSONA = "default"
+
+
+@dataclass(frozen=True)
+class Persona:
+    name: str
+    instructions: str = ""  # empty: DWANI_SYSTEM_PROMPT, or the built-in one-line prompt
+    max_reply_chars: int = 0  # 0: only the per-turn budget applies
+
+
+def persona_from_spec(spec: Any, name: str) -> Persona:
+    """A Persona from its instructions alone or a profile object; ValueError when malformed."""
+    if isinstance(spec, str):
+        spec = {"instructions": spec}
+    if not isinstance(spec, dict):
+        raise ValueError(f"persona {name!r} must be a string or an object")
+    instructions = spec.get("instructions")
+    if not isinstance(instructions, str) or not instructions.strip():
+        raise ValueError(f"persona {name!r} needs non-empty instructions")
+    max_reply_chars = spec.get("max_reply_chars") or 0
+    if not isinstance(max_reply_chars, int) or isinstance(max_reply_chars, bool) or max_reply_chars < 0:
+        raise ValueError(f"persona {name!r}: max_reply_chars must be a non-negative integer")
+    return Persona(name=name, instructions=instructions.strip(), max_reply_chars=max_reply_chars)
+
+
+def load_personas(path: str = PERSONAS_FILE) -> Dict[str, Persona]:
+    """The built-in default persona plus those defined in path, a JSON object of name -> profile."""
+    personas = {DEFAULT_PERSONA: Persona(DEFAULT_PERSONA)}
+    if not path:
+        return personas
+    with open(path, encoding="utf-8") as f:
+        specs = json.load(f)
+    for name, spec in specs.items():
+        personas[name] = persona_from_spec(spec, name)
+    logger.info("Loaded %s personas from %s", len(specs), path)
+    return personas
+
+
+personas = load_personas()
+if PERSONA not in personas:
+    raise ValueError(f"DWANI_PERSONA={PERSONA!r} is not one of {sorted(personas)}")
+for _tenant in tenants.values():
+    _unknown = (_tenant.personas | {_tenant.persona or PERSONA}) - set(personas)
+    if _unknown:
+        raise ValueError(f"tenant {_tenant.name!r} names personas {sorted(_unknown)} that are not one of {sorted(personas)}")
+
+
+def default_persona() -> Persona:
+    """DWANI_PERSONA, or the persona of the request's tenant (services/tenants.py)."""
+    tenant = current_tenant_settings()
+    return personas[(tenant.persona if tenant is not None else None) or PERSONA]
+
+
+def resolve_persona(value: Optional[str]) -> Optional[Persona]:
+    """Persona named by a request; None when it names none, 400 when the name is unknown."""
+    value = (value or "").strip()
+    if not value:
+        return None
+    tenant = current_tenant_settings()
+    allowed = tenant.personas if tenant is not None and tenant.personas else personas
+    persona = personas.get(value) if value in allowed else None
+    if persona is None:
+        raise HTTPException(status_code=400, detail=f"persona must be one of {sorted(allowed)}")
+    return persona
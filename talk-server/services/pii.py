@@ -0,0 +1,140 @@
+"""PII redaction of transcripts and typed input, before the LLM and the logs see them.
+
+With DWANI_PII_REDACT=1, phone numbers (Indian mobiles with or without +91/0, and
+international numbers with a +), Aadhaar-like 12-digit IDs, email addresses and PAN card
+numbers are replaced by DWANI_PII_MASK ("[{type}]", e.g. "[PHONE]"). DWANI_PII_TYPES picks
+which of them are looked for, and DWANI_PII_PATTERNS_FILE adds types of its own, one
+"name regex" per line (# comments). Digits in Indic scripts count as digits.
+
+Redaction runs as an after_asr hook, ahead of the recorder's, so recordings, the session, stored
+conversations and the transcript in the response hold only the redacted text, and again as a
+before_llm hook for typed input. Each redaction is an audit event: a JSON line on the
+indic_all_server.pii_audit logger, and in DWANI_PII_AUDIT_FILE when set, with the time, request,
+session digest, stage and the count per type, never the values themselves. Counted in
+dwani_pii_redactions_total{type, stage}.
+"""
+import json
+import re
+import threading
+import time
+import unicodedata
+from typing import Dict, List, Optional, Tuple
+
+from prometheus_client import Counter
+
+from config import PII_AUDIT_FILE, PII_MASK, PII_PATTERNS_FILE, PII_REDACT, PII_TYPES, logger
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.session import session_digest
+
+_REDACTIONS = Counter("dwani_pii_redactions_total", "PII items redacted from user input", ["type", "stage"])
+_audit_logger = logger.getChild("pii_audit")
+_audit_lock = threading.Lock()
+
+# Longest first where one could contain another: a 12-digit ID holds a 10-digit number.
+PATTERNS: Dict[str, str] = {
+    "email": r"(?<![\w.+-])[\w.+-]+@[\w-]+(?:\.[\w-]+)+",
+    "aadhaar": r"(?<!\d)[2-9]\d{3}[ -]?\d{4}[ -]?\d{4}(?!\d)",
+    "pan": r"(?<![A-Za-z0-9])[A-Z]{5}\d{4}[A-Z](?![A-Za-z0-9])",
+    "phone": (
+        r"(?<![\d+])(?:(?:\+|00)91[ -]?|0)?[6-9]\d{4}[ -]?\d{5}(?!\d)"
+        r"|(?<![\d+])\+\d{1,3}(?:[ -]?\d{2,5}){2,4}(?!\d)"
+    ),
+}
+
+
+def _ascii_digits(text: str) -> str:
+    """text with every decimal digit (०-९, ೦-೯, ...) as its ASCII one; lengths, and so match spans, are kept."""
+    return "".join(str(unicodedata.decimal(ch)) if ch.isdecimal() and not ch.isascii() else ch for ch in text)
+
+
+def load_patterns(path: str = PII_PATTERNS_FILE, types: Tuple[str, ...] = PII_TYPES) -> List[Tuple[str, str]]:
+    """(type, pattern) for the built-in types asked for and those in path; ValueError for unknown types or bad lines."""
+    unknown = [name for name in types if name not in PATTERNS]
+    if unknown:
+        raise ValueError(f"DWANI_PII_TYPES has unknown types {unknown}; expected some of {list(PATTERNS)}")
+    rules = [(name, pattern) for name, pattern in PATTERNS.items() if name in types]
+    if path:
+        with open(path, encoding="utf-8") as f:
+            for number, line in enumerate(f, 1):
+                line = line.strip()
+                if not line or line.startswith("#"):
+                    continue
+                name, _, pattern = line.partition(" ")
+                pattern = pattern.strip()
+                try:
+                    if not pattern:
+                        raise re.error("no pattern")
+                    re.compile(pattern)
+                except re.error as exc:
+                    raise ValueError(f"{path}:{number}: invalid PII pattern {line!r}: {exc}") from exc
+                rules.append((name, pattern))
+    return rules
+
+
+class PIIRedactor:
+    def __init__(self, rules: Optional[List[Tuple[str, str]]] = None, mask: str = PII_MASK, audit_file: str = PII_AUDIT_FILE):
+        self.mask = mask
+        self.audit_file = audit_file
+        self.rules = [(name, re.compile(pattern)) for name, pattern in (rules if rules is not None else load_patterns())]
+
+    def redact(self, text: str) -> Tuple[str, Dict[str, int]]:
+        """text with each PII item masked, and how many of each type were found."""
+        found: Dict[str, int] = {}
+        for name, pattern in self.rules:
+            spans = [match.span() for match in pattern.finditer(_ascii_digits(text))]
+            if not spans:
+                continue
+            found[name] = len(spans)
+            replacement = self.mask.replace("{type}", name.upper())
+            for start, end in reversed(spans):
+                text = text[:start] + replacement + text[end:]
+        return text, found
+
+    def check(self, text: str, stage: str, request_id: Optional[str] = None, session_id: Optional[str] = None) -> str:
+        redacted, found = self.redact(text)
+        if found:
+            for name, count in found.items():
+                _REDACTIONS.labels(type=name, stage=stage).inc(count)
+            self.audit(stage, found, request_id, session_id)
+        return redacted
+
+    def audit(self, stage: str, found: Dict[str, int], request_id: Optional[str], session_id: Optional[str]) -> None:
+        event = {
+            "event": "pii_redacted",
+            "time": time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime()),
+            "request_id": request_id,
+            "session": session_digest(session_id) if session_id else None,
+            "stage": stage,
+            "types": found,
+        }
+        line = json.dumps(event, sort_keys=True)
+        _audit_logger.info(line)
+        if not self.audit_file:
+            return
+        try:
+            with _audit_lock, open(self.audit_file, "a", encoding="utf-8") as f:
+                f.write(line + "\n")
+        except OSError as exc:
+            logger.error("PII audit event could not be written to %s: %s", self.audit_file, exc)
+
+
+redactor = PIIRedactor()
+
+
+async def _redact_transcript(ctx: HookContext, text: str) -> str:
+    return redactor.check(text, "transcript", ctx.request_id, ctx.session_id)
+
+
+async def _redact_input(ctx: HookContext, text: str) -> str:
+    # Transcripts were redacted after ASR already; this catches typed input.
+    return redactor.check(text, "input", ctx.request_id, ctx.session_id)
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the after_asr and before_llm redaction hooks when DWANI_PII_REDACT=1."""
+    if not PII_REDACT or not redactor.rules or _redact_transcript in registry.registered("after_asr"):
+        return False
+    registry.register("after_asr", _redact_transcript)
+    registry.register("before_llm", _redact_input)
+    logger.info("PII redaction enabled for %s", sorted({name for name, _ in redactor.rules}))
+    return True
@@ -0,0 +1,477 @@
+"""Speech-to-speech pipeline stages shared by the HTTP endpoints, background jobs, and embedders.
+
+Other Python services can run the pipeline in-process without this HTTP server:
+
+    from services.pipeline import Pipeline, PipelineConfig
+
+    pipeline = Pipeline(PipelineConfig(llm_base_url="http://llm:10802", tts_base_url="http://tts:10804"))
+    result = await pipeline.speech_to_speech(audio=wav_bytes, language="kannada")
+
+Cleanup the embedder owns goes on pipeline.lifecycle (services/lifecycle.py), run by
+`async with pipeline:` or start() / close().
+"""
+import io
+import time
+from dataclasses import asdict, dataclass
+from typing import Any, Awaitable, Callable, Dict, Optional, Tuple
+
+from fastapi import HTTPException, UploadFile
+from starlette.concurrency import run_in_threadpool
+from starlette.datastructures import Headers
+
+from config import (
+    ASR_CLARIFY_REPLY,
+    ASR_CONFIDENCE_MIN,
+    AUTO_LANGUAGE_FALLBACK,
+    LLM_EMPTY_FALLBACK,
+    LLM_EMPTY_FALLBACK_REPLY,
+    STORE_CONVERSATIONS,
+    TRANSLATION_LANGUAGE,
+    logger,
+)
+from conversation_store import record_turn
+from models import ALLOWED_AGENTS, DEFAULT_AGENT_NAME, GenerationParams
+from services.backends import PipelineConfig, use_config
+from services.budget import fit_tts_budget, hurried, latency_generation, llm_generation, skip_optional, trim_llm_reply
+from services.chat_svc import call_agent, call_llm, last_finish_reason
+from services.confirmation import ConfirmationOutcome, Intent, handle_intent, has_pending, resolve_pending
+from services.errors import RequestError, StageError
+from services.experiments import current_tags, tag_turn
+from services.grammar import Grammar, GrammarMatch
+from services.hooks import HookContext, PipelineHooks, run_hooks, turn_context, use_hooks
+from services.language_id import AUTO_LANGUAGE, detect_language, language_tag
+from services.latency import observe_stage, probe_duration, set_input_audio
+from services.lifecycle import Lifecycle, LifecycleContext
+from services.loops import FRESH_INSTRUCTION, fallback, loop_kind, recent_replies, record
+from services.messages import message
+from services.personas import Persona, default_persona
+from services.prompts import build_system_prompt, verbosity_generation
+from services.retrieval import grounded, retrieve
+from services.session import append_to_session, get_session_context, session_digest, without_last_turn
+from services.slots import apply_turn_updates, get_slots, save_slots
+from services.speech_hints import no_speech_error
+from services.tenants import current_tenant_name
+from services.tools import conversation
+from services.transcribe import transcribe_audio
+from services.translation import translate
+from services.tts import stream_tts, voice_for
+
+
+@dataclass
+class Translation:
+    """A cross-lingual turn: what the LLM was given and answered, in its own language."""
+
+    input_language: str
+    output_language: str
+    llm_language: str
+    llm_input: str
+    llm_reply: str
+
+    def as_dict(self) -> Dict[str, str]:
+        """With the languages as BCP-47 tags, as in all response metadata."""
+        return {
+            **asdict(self),
+            "input_language": language_tag(self.input_language),
+            "output_language": language_tag(self.output_language),
+            "llm_language": language_tag(self.llm_language),
+        }
+
+
+@dataclass
+class TurnResult:
+    transcription: str
+    reply: str
+    grammar: Optional[GrammarMatch] = None
+    confirmation: Optional[ConfirmationOutcome] = None
+    language: Optional[str] = None  # the reply's language; for language="auto", the detected one
+    budget_hits: Tuple[str, ...] = ()  # per-turn budgets the reply exceeded: "llm_tokens", "tts_chars"
+    translation: Optional[Translation] = None
+    language_fallback: Optional[Tuple[str, str]] = None  # (requested, used) when low ASR confidence switched languages
+    asr_confidence: Optional[float] = None  # the ASR backend's confidence in the transcript, when it reports one
+    low_confidence: bool = False  # below DWANI_ASR_CONFIDENCE_MIN: the LLM was skipped and the reply asks to repeat
+    alternatives: Tuple[str, ...] = ()  # the ASR backend's other hypotheses, for the client to offer as corrections
+
+
+async def converse(
+    file: Optional[UploadFile] = None,
+    mode: str = "llm",
+    agent_name: Optional[str] = None,
+    session_id: Optional[str] = None,
+    request_id: Optional[str] = None,
+    language: Optional[str] = None,
+    text: Optional[str] = None,
+    generation: Optional[GenerationParams] = None,
+    grammar: Optional[Grammar] = None,
+    input_language: Optional[str] = None,
+    output_language: Optional[str] = None,
+    persona: Optional[Persona] = None,
+    verbosity: Optional[str] = None,
+    correction: bool = False,
+    echo: bool = False,
+    on_transcript: Optional[Callable[[str, Optional[str]], Awaitable[None]]] = None,
+) -> TurnResult:
+    """Run ASR and the LLM/agent stage, recording the turn in the session.
+
+    Passing text instead of file skips ASR for typed input; generation applies to mode='llm'.
+    With correction, text is the user's fix of the previous turn's transcript: the exchange
+    recorded for that turn is replaced rather than followed by this one.
+    With echo the reply is the user's own words, for pronunciation practice: the LLM/agent,
+    grammar, confirmation and their hooks are skipped, and the session is left as it was.
+    With a grammar the transcript is matched against it instead (services/grammar.py): the
+    reply is its confirmation or re-prompt, and the LLM/agent and its hooks are skipped.
+    Sensitive intents are confirmed with the user first (services/confirmation.py).
+    The session's slots (services/slots.py) reach the LLM prompt, and agents, grammars and
+    hooks may update them. The reply is held to the per-turn budgets (services/budget.py).
+    language="auto" detects the language from the ASR result or the text (services/language_id.py)
+    and uses it for the rest of the turn. When ASR switched to a neighbor of the requested
+    language (DWANI_NEIGHBOR_LANGUAGES), the turn continues in the neighbor.
+    input_language and output_language make the turn cross-lingual (services/translation.py):
+    the user's words are translated into language (DWANI_TRANSLATION_LANGUAGE when unset) for
+    the LLM/agent, and its reply into output_language (default input_language) for TTS. The
+    session keeps the LLM's side of the conversation.
+    persona (services/personas.py) sets the LLM's instructions and the reply's length; the
+    server's DWANI_PERSONA when unset. verbosity (short, normal, detailed; services/prompts.py)
+    sets how long a reply the LLM is asked for, and its max_tokens.
+    on_transcript(text, language) is awaited once the transcript is final, before the LLM, so
+    streaming clients can show it while the reply is prepared.
+    The pipeline hooks (services/hooks.py) run around each stage.
+    """
+    started = time.monotonic()
+    context = get_session_context(session_id) if session_id else []
+    if correction:
+        # The LLM answers the corrected transcript as if the misheard exchange never happened.
+        context = without_last_turn(context)
+    input_type = "text" if text is not None else "audio"
+    auto_language = language == AUTO_LANGUAGE
+    if auto_language:
+        language = None
+    cross_lingual = bool(input_language or output_language) and not auto_language and not echo
+    if cross_lingual:
+        language = language or TRANSLATION_LANGUAGE
+        input_language = input_language or language
+        output_language = output_language or input_language
+    translation = None
+    ctx = HookContext(
+        request_id=request_id,
+        session_id=session_id,
+        language=language,
+        mode=mode,
+        agent_name=agent_name,
+        input_type=input_type,
+        slots=get_slots(session_id),
+    )
+    slots_before = dict(ctx.slots)
+
+    with turn_context(ctx):
+        if text is None:
+            if file is None:
+                raise RequestError(400, "empty_input", "Provide either an audio file or text")
+            set_input_audio(probe_duration(file.file))
+            with observe_stage("asr"):
+                asr_text = await transcribe_audio(file=file, language=input_language or language, request_id=request_id)
+            text = asr_text.text
+            detected = asr_text.language
+            switched = (asr_text.requested_language, detected) if asr_text.requested_language else None
+            asr_confidence = asr_text.confidence
+            alternatives = tuple(asr_text.alternatives or ())
+            if not text or not text.strip():
+                # With what was wrong with the recording, if anything, so the client can coach the user.
+                raise await no_speech_error(file)
+        else:
+            set_input_audio(None, audio_input=False)
+            detected = switched = asr_confidence = None
+            alternatives = ()
+        text = text.strip()
+        if not text:
+            raise RequestError(400, "empty_input", "Text must not be empty")
+        if auto_language:
+            language = ctx.language = detected or detect_language(text) or AUTO_LANGUAGE_FALLBACK or None
+        elif switched and cross_lingual:
+            input_language = detected
+        elif switched:
+            # The audio was more confidently a neighbor of the requested language: carry on in that one.
+            language = ctx.language = detected
+        heard = text
+        if on_transcript is not None:
+            await on_transcript(text, language)
+        unclear = asr_confidence is not None and asr_confidence < ASR_CONFIDENCE_MIN
+        if cross_lingual and (unclear or grammar is not None or has_pending(session_id)):
+            # Clarification, grammar and confirmation replies come from fixed prompts: give them in the user's language.
+            language = ctx.language = output_language
+            cross_lingual = False
+
+        grammar_match = confirmation = None
+        budget_hits = []
+        if echo:
+            llm_text = text
+            mode = "echo"
+        elif unclear:
+            # Too uncertain a transcript to act on: ask the user to repeat rather than answer a mishearing.
+            logger.info("ASR confidence %.2f is below %.2f; asking the user to repeat", asr_confidence, ASR_CONFIDENCE_MIN)
+            llm_text = message("clarify", language, configured=ASR_CLARIFY_REPLY)
+            mode = "clarify"
+        elif grammar is not None:
+            grammar_match, llm_text = grammar.respond(text, language)
+            mode = "grammar"
+            if grammar.slot and grammar_match.matched:
+                ctx.slots = apply_turn_updates(ctx.slots, {grammar.slot: grammar_match.value}, f"grammar {grammar.name}")
+        elif has_pending(session_id):
+            # This turn answers the confirmation question asked for a sensitive intent.
+            confirmation = await resolve_pending(session_id, text, language, request_id)
+            llm_text = confirmation.reply
+            mode = "confirmation"
+        else:
+            if cross_lingual:
+                text = await translate(text, input_language, language, request_id)
+            text = await run_hooks("before_llm", ctx, text)
+            # before_llm hooks may answer the turn themselves or route it to another mode/agent.
+            mode, agent_name = ctx.mode, ctx.agent_name
+            if ctx.reply is not None:
+                llm_text = ctx.reply
+            else:
+                llm_text, extras = await _reply(
+                    text, mode, agent_name, context, session_id, request_id, language, generation, ctx.slots, persona, verbosity
+                )
+                ctx.intent = extras.get("intent")
+                budget_hits.extend(extras.get("budget_hits", ()))
+                ctx.slots = apply_turn_updates(ctx.slots, extras.get("slots"), f"agent {agent_name or DEFAULT_AGENT_NAME}")
+            llm_text = await run_hooks("after_llm", ctx, llm_text)
+            intent = Intent.from_payload(ctx.intent)
+            if intent is not None:
+                confirmation = await handle_intent(intent, session_id, language, request_id)
+                llm_text = confirmation.reply or llm_text
+            if cross_lingual:
+                translation = Translation(input_language, output_language, language, text, llm_text)
+                llm_text = await translate(llm_text, language, output_language, request_id)
+                language = output_language
+        if llm_text:
+            max_chars = (persona or default_persona()).max_reply_chars
+            llm_text, over_tts_budget = await fit_tts_budget(llm_text, language, request_id, max_chars)
+            if over_tts_budget:
+                budget_hits.append("tts_chars")
+
+    if not llm_text or not llm_text.strip():
+        raise HTTPException(status_code=502, detail="Text for TTS is empty")
+
+    tag_turn(voice=voice_for(language))
+    if session_id and not unclear and not echo:
+        if translation is not None:
+            append_to_session(session_id, translation.llm_input, translation.llm_reply, replace_last=correction)
+        else:
+            append_to_session(session_id, text, llm_text, replace_last=correction)
+        if ctx.slots != slots_before:
+            save_slots(session_id, ctx.slots)
+    if STORE_CONVERSATIONS:
+        await run_in_threadpool(
+            record_turn,
+            session_key=session_digest(session_id) if session_id else None,
+            mode=mode,
+            agent_name=agent_name or DEFAULT_AGENT_NAME,
+            language=language_tag(language),
+            input_type=input_type,
+            transcript=heard if translation is not None else text,
+            reply=llm_text,
+            latency_ms=int((time.monotonic() - started) * 1000),
+            request_id=request_id,
+            tenant=current_tenant_name(),
+            **current_tags(),
+        )
+    return TurnResult(
+        transcription=heard if translation is not None else text,
+        reply=llm_text,
+        grammar=grammar_match,
+        confirmation=confirmation,
+        language=language,
+        budget_hits=tuple(budget_hits),
+        translation=translation,
+        language_fallback=switched,
+        asr_confidence=asr_confidence,
+        low_confidence=unclear,
+        alternatives=alternatives,
+    )
+
+
+async def _reply(
+    text: str,
+    mode: str,
+    agent_name: Optional[str],
+    context: list,
+    session_id: Optional[str],
+    request_id: Optional[str],
+    language: Optional[str],
+    generation: Optional[GenerationParams],
+    slots: Dict[str, Any],
+    persona: Optional[Persona] = None,
+    verbosity: Optional[str] = None,
+) -> Tuple[str, Dict[str, Any]]:
+    """The LLM or agent reply, and the intent and slot updates the agent attached to it (or budget hits)."""
+    if mode == "agent":
+        selected_agent = agent_name or DEFAULT_AGENT_NAME
+        if selected_agent not in ALLOWED_AGENTS:
+            raise HTTPException(status_code=400, detail=f"agent_name must be one of {ALLOWED_AGENTS}")
+        with observe_stage("agent"):
+            agent_result = await call_agent(selected_agent, text, session_id=session_id, request_id=request_id)
+        return agent_result["reply"], agent_result
+    budget_hits = []
+    # Close to the deadline, retrieval and other optional stages give way so the user still gets a reply
+    # (services/budget.py).
+    rushed = hurried()
+    passages = await retrieve(text, language, request_id)
+    # The slots, a requested persona or verbosity and retrieved passages (services/retrieval.py) go into the
+    # system prompt; without them the LLM gets its usual prompt.
+    system_prompt = grounded(build_system_prompt(language, slots, persona, verbosity), passages)
+    prompt = {"system_prompt": system_prompt} if slots or persona or verbosity or passages else {}
+    generation, capped = llm_generation(verbosity_generation(generation, verbosity))
+    generation, late = latency_generation(generation)
+    if rushed or late:
+        budget_hits.append("latency")
+    with observe_stage("llm"), conversation():
+        try:
+            reply = await call_llm(
+                text, context=context, request_id=request_id, language=language, generation=generation, **prompt
+            )
+        except StageError as exc:
+            if not (LLM_EMPTY_FALLBACK and exc.details.get("reason") == "empty"):
+                raise
+            # Still empty after DWANI_LLM_EMPTY_RETRIES: an apology the user can answer beats a 502.
+            logger.warning("LLM gave only empty replies; answering with the fallback")
+            reply = message("no_reply", language, configured=LLM_EMPTY_FALLBACK_REPLY)
+            return reply, {"budget_hits": budget_hits} if budget_hits else {}
+        reply = await _unlooped(reply, text, context, request_id, language, generation, prompt.get("system_prompt"))
+    reply, over_budget = trim_llm_reply(reply, capped or late, last_finish_reason())
+    if over_budget and capped:
+        budget_hits.append("llm_tokens")
+    return reply, {"budget_hits": budget_hits} if budget_hits else {}
+
+
+async def _unlooped(
+    reply: str,
+    text: str,
+    context: list,
+    request_id: Optional[str],
+    language: Optional[str],
+    generation: Optional[GenerationParams],
+    system_prompt: Optional[str],
+) -> str:
+    """reply, or a fresh one when it loops or repeats the session's last replies (services/loops.py)."""
+    previous = recent_replies(context)
+    kind = loop_kind(reply, previous)
+    if kind is None:
+        return reply
+    if skip_optional("loop_retry"):
+        record(kind, "fallback", request_id)
+        return fallback(reply, kind, language)
+    fresh = await call_llm(
+        text,
+        request_id=request_id,
+        language=language,
+        generation=generation,
+        system_prompt=f"{system_prompt or build_system_prompt(language)} {FRESH_INSTRUCTION}",
+    )
+    if loop_kind(fresh, previous) is None:
+        record(kind, "retried", request_id)
+        return fresh
+    record(kind, "fallback", request_id)
+    return fallback(fresh, kind, language)
+
+
+def audio_upload(data: bytes, mime_type: str = "audio/wav", filename: str = "audio") -> UploadFile:
+    """Wrap in-memory audio as the UploadFile the ASR stage reads from."""
+    return UploadFile(
+        file=io.BytesIO(data),
+        size=len(data),
+        filename=filename,
+        headers=Headers({"content-type": mime_type}),
+    )
+
+
+@dataclass
+class SpeechResult:
+    transcription: str
+    reply: str
+    audio: bytes
+    content_type: str
+    language: Optional[str] = None
+
+
+class Pipeline:
+    """In-process speech-to-speech pipeline bound to one set of backends.
+
+    hooks replaces the server-wide stage hooks for this pipeline's calls; lifecycle holds its
+    start and shutdown hooks (a fresh Lifecycle by default).
+    """
+
+    def __init__(
+        self,
+        config: Optional[PipelineConfig] = None,
+        hooks: Optional[PipelineHooks] = None,
+        lifecycle: Optional[Lifecycle] = None,
+    ):
+        self.config = config or PipelineConfig()
+        self.hooks = hooks
+        self.lifecycle = lifecycle or Lifecycle()
+
+    async def start(self) -> None:
+        await self.lifecycle.start(LifecycleContext("pipeline", config=self.config))
+
+    async def close(self) -> None:
+        await self.lifecycle.shutdown()
+
+    async def __aenter__(self) -> "Pipeline":
+        await self.start()
+        return self
+
+    async def __aexit__(self, *exc_info) -> None:
+        await self.close()
+
+    async def transcribe(self, audio: bytes, mime_type: str = "audio/wav", language: Optional[str] = None) -> str:
+        with use_config(self.config), use_hooks(self.hooks):
+            result = await transcribe_audio(audio_upload(audio, mime_type), language=language)
+        return result.text
+
+    async def synthesize(self, text: str, language: Optional[str] = None) -> bytes:
+        with use_config(self.config), use_hooks(self.hooks):
+            stream = await stream_tts(text, language=language)
+            return await stream.read()
+
+    async def speech_to_speech(
+        self,
+        audio: Optional[bytes] = None,
+        mime_type: str = "audio/wav",
+        text: Optional[str] = None,
+        mode: str = "llm",
+        agent_name: Optional[str] = None,
+        session_id: Optional[str] = None,
+        language: Optional[str] = None,
+        generation: Optional[GenerationParams] = None,
+        grammar: Optional[Grammar] = None,
+        input_language: Optional[str] = None,
+        output_language: Optional[str] = None,
+        persona: Optional[Persona] = None,
+        verbosity: Optional[str] = None,
+    ) -> SpeechResult:
+        """One full turn; pass text instead of audio to skip ASR."""
+        with use_config(self.config), use_hooks(self.hooks):
+            turn = await converse(
+                audio_upload(audio, mime_type) if audio is not None else None,
+                mode=mode,
+                agent_name=agent_name,
+                session_id=session_id,
+                language=language,
+                text=text,
+                generation=generation,
+                grammar=grammar,
+                input_language=input_language,
+                output_language=output_language,
+                persona=persona,
+                verbosity=verbosity,
+            )
+            stream = await stream_tts(turn.reply, language=turn.language)
+            speech = await stream.read()
+        return SpeechResult(
+            transcription=turn.transcription,
+            reply=turn.reply,
+            audio=speech,
+            content_type=stream.media_type,
+            language=turn.language,
+        )
@@ -0,0 +1,81 @@
+"""Streamed reply audio that stops being synthesized as soon as the client stops listening.
+
+A reply is relayed from the TTS backend while it is still being synthesized. When the client
+goes away part-way (socket closed, playback aborted), PlaybackResponse closes the backend's
+response at once, which cancels the rest of the synthesis, rather than leaving that to the
+garbage collector. It also records how much audio the client received: logged, counted in
+dwani_reply_playback_total, and, with DWANI_STORE_CONVERSATIONS=1, saved on the stored turn
+as playback_bytes, playback_ms and playback_interrupted.
+"""
+import asyncio
+from typing import Any, Optional
+
+import anyio
+from prometheus_client import Counter
+from starlette.responses import StreamingResponse
+from starlette.types import Receive, Scope, Send
+
+from config import STORE_CONVERSATIONS, logger
+from conversation_store import record_playback
+from services.audio_format import detect_format, mp3_duration
+from services.latency import wav_layout
+from services.tts import TTSStream
+
+_PLAYBACK = Counter("dwani_reply_playback_total", "Streamed reply audio, by whether the client received all of it", ["outcome"])
+_UNKNOWN_WAV_SIZES = (0, 0xFFFFFFFF)
+
+
+def delivered_ms(audio: bytes) -> Optional[int]:
+    """Length of the first part of a WAV or MP3 stream in milliseconds; None for other formats."""
+    audio_format = detect_format(None, audio[:12])
+    if audio_format == "mp3":
+        seconds = mp3_duration(audio)
+    elif audio_format == "wav":
+        layout = wav_layout(audio[:4096])
+        if layout is None:
+            return None
+        byte_rate, offset, size = layout
+        samples = max(0, len(audio) - offset)
+        seconds = (samples if size in _UNKNOWN_WAV_SIZES else min(samples, size)) / byte_rate
+    else:
+        return None
+    return None if seconds is None else int(seconds * 1000)
+
+
+def track_playback(tts_stream: TTSStream, request_id: Optional[str] = None, store: bool = False) -> TTSStream:
+    """Log and count how much of tts_stream gets relayed; store=True saves it on request_id's turn."""
+    def finished(audio: bytes, complete: bool) -> None:
+        outcome = "complete" if complete else "interrupted"
+        _PLAYBACK.labels(outcome=outcome).inc()
+        ms = delivered_ms(audio)
+        if not complete:
+            logger.info("Client stopped reading reply audio after %s bytes (%s ms)", len(audio), ms)
+        if store and STORE_CONVERSATIONS and request_id:
+            asyncio.get_running_loop().run_in_executor(None, record_playback, request_id, len(audio), ms, not complete)
+
+    return tts_stream.on_finish(finished)
+
+
+class PlaybackResponse(StreamingResponse):
+    """StreamingResponse of reply audio (content defaults to the stream's bytes) that closes
+    the TTS stream when the response ends for any reason, including a client disconnect."""
+
+    def __init__(self, tts_stream: TTSStream, content: Optional[Any] = None, **kwargs: Any):
+        self.tts_stream = tts_stream
+        kwargs.setdefault("media_type", tts_stream.media_type)
+        super().__init__(tts_stream.iter_bytes() if content is None else content, **kwargs)
+        if content is None and tts_stream.size is not None:
+            self.headers.setdefault("Content-Length", str(tts_stream.size))
+        # Each chunk goes out as it arrives; nginx-style proxies would otherwise buffer it.
+        self.headers.setdefault("X-Accel-Buffering", "no")
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send) -> None:
+        try:
+            await super().__call__(scope, receive, send)
+        finally:
+            # Shielded: when the request task itself is being cancelled, the backend must still be closed.
+            with anyio.CancelScope(shield=True):
+                close = getattr(self.body_iterator, "aclose", None)
+                if close is not None:
+                    await close()
+                await self.tts_stream.aclose()
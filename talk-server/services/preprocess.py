@@ -0,0 +1,223 @@
+"""Audio preprocessing before ASR: silence trimming and loudness normalization.
+
+Phone recordings are often quiet and open or end on seconds of line noise, which some ASR
+backends answer with nothing or with hallucinated words. Two steps help:
+
+- trim: leading and trailing audio quieter than the loudest moment by DWANI_AUDIO_TRIM_DB is
+  cut, keeping DWANI_AUDIO_TRIM_PAD_MS either side of the speech.
+- normalize: the speech (what is not silence) is brought to DWANI_AUDIO_TARGET_DBFS RMS, by
+  at most DWANI_AUDIO_MAX_GAIN_DB either way and never so loud that peaks pass -1 dBFS.
+
+DWANI_AUDIO_PREPROCESS picks the steps for the deployment ("trim", "normalize", both, or
+"off"), and the X-Audio-Preprocess header for one request, with the same values plus "on"
+for both; an invalid header is ignored. Jobs keep the choice of the request that submitted them.
+
+The steps work on 16-bit PCM WAV; other uploads are first converted to 16 kHz mono WAV with
+ffmpeg (DWANI_FFMPEG_PATH). Uploads go to ASR as they came when that fails or when there is
+nothing to trim or amplify, and audio that is digital silence throughout is left alone. Preprocessing runs as a before_asr hook (services/hooks.py),
+so it covers every transcription: turns, dictation, meetings and read-aloud.
+"""
+import array
+import asyncio
+import io
+import math
+import sys
+import wave
+from contextvars import ContextVar
+from dataclasses import dataclass
+from typing import FrozenSet, List, Optional, Tuple
+
+from fastapi import UploadFile
+from prometheus_client import Counter
+
+from config import (
+    AUDIO_MAX_GAIN_DB,
+    AUDIO_PREPROCESS,
+    AUDIO_TARGET_DBFS,
+    AUDIO_TRIM_DB,
+    AUDIO_TRIM_PAD_MS,
+    MAX_UPLOAD_BYTES,
+    logger,
+)
+from services.audio_format import detect_format, transcode
+from services.errors import StageError
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.pipeline import audio_upload
+from services.turn_detection import frame_dbfs
+
+HEADER = "X-Audio-Preprocess"
+STEPS = ("trim", "normalize")
+_PEAK_DBFS = -1.0
+_FRAME_MS = 20
+# Gains this small are not worth rewriting the audio for.
+_MIN_GAIN_DB = 0.5
+
+_PREPROCESSED = Counter("dwani_audio_preprocess_total", "Uploads changed by audio preprocessing before ASR, by step", ["step"])
+_TRIMMED = Counter("dwani_audio_trimmed_seconds_total", "Seconds of leading and trailing silence trimmed before ASR")
+
+
+def parse_steps(value: Optional[str]) -> FrozenSet[str]:
+    """The steps named in value ("trim,normalize", "on", "off"); ValueError for anything else."""
+    value = (value or "").strip().lower()
+    if value in ("", "off", "none", "0", "false"):
+        return frozenset()
+    if value in ("on", "all", "1", "true"):
+        return frozenset(STEPS)
+    steps = frozenset(step.strip() for step in value.split(",") if step.strip())
+    unknown = steps - set(STEPS)
+    if unknown:
+        raise ValueError(f"Unknown audio preprocessing step(s) {sorted(unknown)}; expected {list(STEPS)}, 'on' or 'off'")
+    return steps
+
+
+try:
+    DEFAULT_STEPS = parse_steps(AUDIO_PREPROCESS)
+except ValueError as exc:
+    logger.warning("DWANI_AUDIO_PREPROCESS ignored: %s", exc)
+    DEFAULT_STEPS = frozenset()
+
+_requested: ContextVar[Optional[FrozenSet[str]]] = ContextVar("dwani_audio_preprocess", default=None)
+
+
+def use_preprocessing(value: Optional[str]) -> None:
+    """Preprocess this request's (or job's) audio as value says; unset or invalid keeps the deployment's steps."""
+    try:
+        _requested.set(parse_steps(value) if (value or "").strip() else None)
+    except ValueError:
+        _requested.set(None)
+
+
+def current_steps() -> FrozenSet[str]:
+    requested = _requested.get()
+    return DEFAULT_STEPS if requested is None else requested
+
+
+def steps_name(steps: FrozenSet[str]) -> str:
+    """steps as a header value, e.g. "trim,normalize" or "off"."""
+    return ",".join(step for step in STEPS if step in steps) or "off"
+
+
+@dataclass
+class Preprocessed:
+    original_seconds: float
+    trimmed_seconds: float = 0.0
+    gain_db: float = 0.0
+
+
+def _power(dbfs: float) -> float:
+    return 10 ** (dbfs / 10) if dbfs > float("-inf") else 0.0
+
+
+def preprocess_wav(
+    data: bytes,
+    trim: bool = True,
+    normalize: bool = True,
+    trim_db: float = AUDIO_TRIM_DB,
+    pad_ms: int = AUDIO_TRIM_PAD_MS,
+    target_dbfs: float = AUDIO_TARGET_DBFS,
+    max_gain_db: float = AUDIO_MAX_GAIN_DB,
+) -> Optional[Tuple[bytes, Preprocessed]]:
+    """data trimmed and/or normalized, and what was done; None unless it is 16-bit PCM WAV.
+
+    The data comes back unchanged when there is neither silence to trim nor gain to apply.
+    """
+    try:
+        with wave.open(io.BytesIO(data)) as reader:
+            channels, width, rate, total = (
+                reader.getnchannels(), reader.getsampwidth(), reader.getframerate(), reader.getnframes()
+            )
+            frames = reader.readframes(total)
+    except (wave.Error, EOFError):
+        return None
+    if width != 2 or not rate or not channels:
+        return None
+    frame_bytes = channels * width
+    total = len(frames) // frame_bytes
+    stats = Preprocessed(original_seconds=round(total / rate, 3))
+    window = max(1, rate * _FRAME_MS // 1000)
+    levels: List[float] = [
+        frame_dbfs(frames[start * frame_bytes:(start + window) * frame_bytes]) for start in range(0, total, window)
+    ]
+    loudest = max(levels, default=float("-inf"))
+    if loudest == float("-inf"):
+        return data, stats
+    voiced = [i for i, level in enumerate(levels) if level >= loudest - trim_db]
+    begin, end = 0, total
+    if trim:
+        pad = rate * max(0, pad_ms) // 1000
+        begin = max(0, voiced[0] * window - pad)
+        end = min(total, (voiced[-1] + 1) * window + pad)
+        stats.trimmed_seconds = round((total - (end - begin)) / rate, 3)
+    pcm = frames[begin * frame_bytes:end * frame_bytes]
+    if normalize:
+        speech_dbfs = 10 * math.log10(sum(_power(levels[i]) for i in voiced) / len(voiced))
+        samples = array.array("h", pcm)
+        if sys.byteorder == "big":
+            samples.byteswap()
+        peak = max((abs(s) for s in samples), default=0)
+        headroom = _PEAK_DBFS - 20 * math.log10(peak / 32768) if peak else max_gain_db
+        gain_db = max(-max_gain_db, min(target_dbfs - speech_dbfs, max_gain_db, headroom))
+        if abs(gain_db) >= _MIN_GAIN_DB:
+            factor = 10 ** (gain_db / 20)
+            samples = array.array("h", (max(-32768, min(32767, round(s * factor))) for s in samples))
+            if sys.byteorder == "big":
+                samples.byteswap()
+            pcm = samples.tobytes()
+            stats.gain_db = round(gain_db, 2)
+    if not stats.trimmed_seconds and not stats.gain_db:
+        return data, stats
+    out = io.BytesIO()
+    with wave.open(out, "wb") as writer:
+        writer.setnchannels(channels)
+        writer.setsampwidth(width)
+        writer.setframerate(rate)
+        writer.writeframes(pcm)
+    return out.getvalue(), stats
+
+
+async def _preprocess_upload(ctx: HookContext, file: UploadFile) -> Optional[UploadFile]:
+    steps = current_steps()
+    if not steps or (file.size or 0) > MAX_UPLOAD_BYTES:
+        return None
+    data = await file.read()
+    await file.seek(0)
+    if not data:
+        return None
+    trim, normalize = "trim" in steps, "normalize" in steps
+    result = None
+    if detect_format(file.content_type, data[:12]) == "wav":
+        result = await asyncio.to_thread(preprocess_wav, data, trim, normalize)
+    if result is None:
+        try:
+            converted = await transcode(data, "pcm16")
+        except StageError:
+            logger.warning("Audio preprocessing skipped: the upload could not be converted to WAV",
+                           extra={"request_id": ctx.request_id})
+            return None
+        result = await asyncio.to_thread(preprocess_wav, converted, trim, normalize)
+        if result is None:
+            return None
+    audio, stats = result
+    if stats.trimmed_seconds:
+        _PREPROCESSED.labels(step="trim").inc()
+        _TRIMMED.inc(stats.trimmed_seconds)
+    if stats.gain_db:
+        _PREPROCESSED.labels(step="normalize").inc()
+    ctx.metadata["audio_preprocess"] = stats
+    if not stats.trimmed_seconds and not stats.gain_db:
+        return None
+    logger.debug(
+        "Audio preprocessed: %.2fs trimmed of %.2fs, %+.1f dB", stats.trimmed_seconds, stats.original_seconds, stats.gain_db,
+        extra={"request_id": ctx.request_id},
+    )
+    return audio_upload(audio, "audio/wav", file.filename or "audio.wav")
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the preprocessing before_asr hook; it does nothing for requests whose steps are off."""
+    if _preprocess_upload in registry.registered("before_asr"):
+        return False
+    registry.register("before_asr", _preprocess_upload)
+    if DEFAULT_STEPS:
+        logger.info("Audio preprocessing before ASR enabled (%s)", steps_name(DEFAULT_STEPS))
+    return True
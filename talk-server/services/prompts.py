@@ -0,0 +1,139 @@
+"""System prompt construction with per-language reply instructions.
+
+The base instructions come from the persona (services/personas.py); the default persona's are
+DWANI_SYSTEM_PROMPT, else DEFAULT_SYSTEM_PROMPT. Language templates can be overridden with
+DWANI_PROMPTS_FILE, a JSON object mapping a language name (or "default") to a
+template. Templates may reference {language}, and the base prompt the session's slots
+(services/slots.py) as {slots} (all of them) or {slots.<name>}; when it does not, known slots
+are appended as a "details so far" sentence.
+
+The built-in prompt asks for replies of the verbosity mode's length: short (one sentence, the
+default), normal (two or three) or detailed (several), each with a max_tokens to match
+(DWANI_LLM_MAX_TOKENS for short, DWANI_VERBOSITY_*_MAX_TOKENS otherwise). DWANI_VERBOSITY picks
+the server's mode and a request may pick another; a custom prompt or persona gets the mode's
+instruction appended only when the request asks for one, since it may set a length itself.
+"""
+import json
+import os
+import re
+from typing import Any, Dict, Optional
+
+from fastapi import HTTPException
+
+from config import LLM_MAX_TOKENS, VERBOSITY, VERBOSITY_DETAILED_MAX_TOKENS, VERBOSITY_NORMAL_MAX_TOKENS, logger
+from models import GenerationParams
+from services.personas import Persona, default_persona
+
+VERBOSITY_INSTRUCTIONS: Dict[str, str] = {
+    "short": "You must respond in at most one line. Keep your reply to a single short sentence.",
+    "normal": "Keep your reply to two or three short sentences.",
+    "detailed": "Give a complete answer in several sentences of plain speech, with no lists, headings or markdown.",
+}
+_VERBOSITY_MAX_TOKENS = {"normal": VERBOSITY_NORMAL_MAX_TOKENS, "detailed": VERBOSITY_DETAILED_MAX_TOKENS}
+_CONTEXT_INSTRUCTION = "Maintain conversation context when given previous messages."
+
+if VERBOSITY not in VERBOSITY_INSTRUCTIONS:
+    logger.warning("DWANI_VERBOSITY must be one of %s; using short", list(VERBOSITY_INSTRUCTIONS))
+DEFAULT_VERBOSITY = VERBOSITY if VERBOSITY in VERBOSITY_INSTRUCTIONS else "short"
+DEFAULT_SYSTEM_PROMPT = f"{VERBOSITY_INSTRUCTIONS[DEFAULT_VERBOSITY]} {_CONTEXT_INSTRUCTION}"
+
+LANGUAGE_DISPLAY_NAMES: Dict[str, str] = {
+    "kannada": "Kannada (ಕನ್ನಡ)",
+    "hindi": "Hindi (हिन्दी)",
+    "tamil": "Tamil (தமிழ்)",
+    "malayalam": "Malayalam (മലയാളം)",
+    "telugu": "Telugu (తెలుగు)",
+    "marathi": "Marathi (मराठी)",
+    "english": "English",
+    "german": "German (Deutsch)",
+}
+
+_DEFAULT_LANGUAGE_TEMPLATE = (
+    "Always reply in {language}, written in its native script, even if the user mixes in other languages. "
+    "Your reply will be spoken aloud by a {language} voice."
+)
+
+
+def _load_templates() -> Dict[str, str]:
+    path = os.getenv("DWANI_PROMPTS_FILE", "").strip()
+    if not path:
+        return {}
+    try:
+        with open(path, encoding="utf-8") as fh:
+            data = json.load(fh)
+    except (OSError, json.JSONDecodeError) as exc:
+        logger.warning("Failed to load prompt templates from %s: %s", path, exc)
+        return {}
+    if not isinstance(data, dict):
+        logger.warning("Prompt templates file %s must contain a JSON object", path)
+        return {}
+    return {str(k).strip().lower(): str(v) for k, v in data.items()}
+
+
+_TEMPLATES = _load_templates()
+_SLOT_PLACEHOLDER = re.compile(r"\{slots(?:\.([A-Za-z_][A-Za-z0-9_]*))?\}")
+
+
+def language_display_name(language: str) -> str:
+    return LANGUAGE_DISPLAY_NAMES.get(language, language.title())
+
+
+def language_instruction(language: Optional[str]) -> str:
+    if not language:
+        return ""
+    template = _TEMPLATES.get(language) or _TEMPLATES.get("default") or _DEFAULT_LANGUAGE_TEMPLATE
+    return template.replace("{language}", language_display_name(language))
+
+
+def _slot_list(slots: Dict[str, Any]) -> str:
+    return "; ".join(f"{name.replace('_', ' ')}: {value}" for name, value in slots.items()) or "none"
+
+
+def render_slots(template: str, slots: Dict[str, Any]) -> str:
+    """template with {slots} and {slots.<name>} filled in; unknown slots render as "unknown"."""
+    def _fill(match: re.Match) -> str:
+        name = match.group(1)
+        if name is None:
+            return _slot_list(slots)
+        return str(slots[name]) if name in slots else "unknown"
+
+    return _SLOT_PLACEHOLDER.sub(_fill, template)
+
+
+def resolve_verbosity(value: Optional[str]) -> Optional[str]:
+    """A requested verbosity mode, normalized; None when unset, 400 when unknown."""
+    value = (value or "").strip().lower()
+    if not value:
+        return None
+    if value not in VERBOSITY_INSTRUCTIONS:
+        raise HTTPException(status_code=400, detail=f"verbosity must be one of {list(VERBOSITY_INSTRUCTIONS)}")
+    return value
+
+
+def verbosity_generation(generation: Optional[GenerationParams], verbosity: Optional[str]) -> Optional[GenerationParams]:
+    """generation with the verbosity mode's max_tokens, unless the request set its own."""
+    limit = _VERBOSITY_MAX_TOKENS.get(verbosity or DEFAULT_VERBOSITY, LLM_MAX_TOKENS)
+    if (generation and generation.max_tokens) or limit == LLM_MAX_TOKENS:
+        return generation
+    return (generation or GenerationParams()).model_copy(update={"max_tokens": limit})
+
+
+def build_system_prompt(
+    language: Optional[str] = None,
+    slots: Optional[Dict[str, Any]] = None,
+    persona: Optional[Persona] = None,
+    verbosity: Optional[str] = None,
+) -> str:
+    """The conversational system prompt; persona defaults to DWANI_PERSONA's, verbosity to DWANI_VERBOSITY."""
+    persona = persona or default_persona()
+    base = persona.instructions or os.getenv("DWANI_SYSTEM_PROMPT", "").strip()
+    if not base:
+        base = f"{VERBOSITY_INSTRUCTIONS[verbosity or DEFAULT_VERBOSITY]} {_CONTEXT_INSTRUCTION}"
+    elif verbosity:
+        base = f"{base} {VERBOSITY_INSTRUCTIONS[verbosity]}"
+    if _SLOT_PLACEHOLDER.search(base):
+        base = render_slots(base, slots or {})
+    elif slots:
+        base = f"{base} Details the user has given so far: {_slot_list(slots)}."
+    instruction = language_instruction(language)
+    return f"{base} {instruction}" if instruction else base
@@ -0,0 +1,227 @@
+"""Pronunciation assessment: the learner reads a given sentence aloud and each word is scored.
+
+The transcript is aligned with the reference text word by word (an edit-distance alignment
+whose substitution cost is the character distance between the two words), so every
+reference word is either read correctly, misread as something else, or left out; words the
+learner added (fillers, repetitions) are attached to the reference word they came before.
+A word's accuracy is how close what was heard is to it, character by character.
+
+Fluency has no word timings to go on, so it combines how few words were added with the
+reading pace: for WAV uploads the speaking rate over the voiced part of the audio (against
+DWANI_PRONUNCIATION_TARGET_WPM) and the pauses longer than DWANI_PRONUNCIATION_PAUSE_MS.
+The scores are heuristics meant for practice feedback, not for grading.
+"""
+import io
+import unicodedata
+import wave
+from dataclasses import asdict, dataclass, field
+from typing import Any, Dict, List, Optional, Tuple
+
+from fastapi import HTTPException, UploadFile
+
+from config import MAX_UPLOAD_BYTES, PRONUNCIATION_PAUSE_MS, PRONUNCIATION_TARGET_WPM
+from services.concurrency import pipeline_limiter
+from services.errors import RequestError
+from services.latency import observe_stage, set_input_audio, wav_duration
+from services.pipeline import audio_upload
+from services.transcribe import transcribe_audio
+from services.turn_detection import frame_dbfs
+
+MAX_REFERENCE_CHARS = 1000
+_FRAME_MS = 20
+_VOICED_DBFS = -40.0
+
+
+@dataclass
+class WordScore:
+    index: int
+    word: str
+    heard: Optional[str]
+    status: str  # correct, mispronounced or omitted
+    accuracy: int
+    extra_before: List[str] = field(default_factory=list)
+
+
+@dataclass
+class PronunciationAssessment:
+    reference_text: str
+    transcription: str
+    accuracy: int
+    completeness: int
+    fluency: int
+    overall: int
+    words: List[WordScore]
+    extra_words: List[str]
+    words_per_minute: Optional[float] = None
+    long_pauses: Optional[int] = None
+
+    def as_dict(self) -> Dict[str, Any]:
+        return asdict(self)
+
+
+def normalize_word(word: str) -> str:
+    """Case-folded, without punctuation or symbols; vowel signs and viramas are kept."""
+    word = unicodedata.normalize("NFC", word).casefold()
+    return "".join(ch for ch in word if unicodedata.category(ch)[0] not in "PSZC")
+
+
+def _words(text: str) -> List[Tuple[str, str]]:
+    """(as written, normalized) for each word that has letters or digits left."""
+    pairs = [(word, normalize_word(word)) for word in text.split()]
+    return [(word, norm) for word, norm in pairs if norm]
+
+
+def _edit_distance(a: str, b: str) -> int:
+    previous = list(range(len(b) + 1))
+    for i, ca in enumerate(a, 1):
+        current = [i]
+        for j, cb in enumerate(b, 1):
+            current.append(min(previous[j] + 1, current[j - 1] + 1, previous[j - 1] + (ca != cb)))
+        previous = current
+    return previous[-1]
+
+
+def similarity(reference: str, heard: str) -> float:
+    """1.0 for the same word, falling to 0.0 as more characters differ."""
+    if not reference or not heard:
+        return 0.0
+    return max(0.0, 1.0 - _edit_distance(reference, heard) / max(len(reference), len(heard)))
+
+
+def _substitution(reference: str, heard: str) -> float:
+    # Up to an omission plus an insertion, so unrelated words do not shift the rest of the alignment.
+    return 2 * (1 - similarity(reference, heard))
+
+
+def align(reference: List[str], heard: List[str]) -> List[Tuple[Optional[int], Optional[int]]]:
+    """Pairs of (reference index, heard index); None on one side for omissions and insertions."""
+    rows, cols = len(reference) + 1, len(heard) + 1
+    cost = [[0.0] * cols for _ in range(rows)]
+    for i in range(1, rows):
+        cost[i][0] = float(i)
+    for j in range(1, cols):
+        cost[0][j] = float(j)
+    for i in range(1, rows):
+        for j in range(1, cols):
+            cost[i][j] = min(
+                cost[i - 1][j] + 1,
+                cost[i][j - 1] + 1,
+                cost[i - 1][j - 1] + _substitution(reference[i - 1], heard[j - 1]),
+            )
+    pairs: List[Tuple[Optional[int], Optional[int]]] = []
+    i, j = len(reference), len(heard)
+    while i or j:
+        if i and j and cost[i][j] == cost[i - 1][j - 1] + _substitution(reference[i - 1], heard[j - 1]):
+            pairs.append((i - 1, j - 1))
+            i, j = i - 1, j - 1
+        elif i and cost[i][j] == cost[i - 1][j] + 1:
+            pairs.append((i - 1, None))
+            i -= 1
+        else:
+            pairs.append((None, j - 1))
+            j -= 1
+    return pairs[::-1]
+
+
+def reading_pace(audio: bytes) -> Optional[Tuple[float, int]]:
+    """(voiced seconds, pauses longer than DWANI_PRONUNCIATION_PAUSE_MS) of 16-bit WAV, else None."""
+    try:
+        with wave.open(io.BytesIO(audio)) as reader:
+            if reader.getsampwidth() != 2 or not reader.getframerate():
+                return None
+            frame_bytes = reader.getframerate() * _FRAME_MS // 1000 * reader.getnchannels() * 2
+            pcm = reader.readframes(reader.getnframes())
+    except (wave.Error, EOFError):
+        return None
+    voiced = [
+        frame_dbfs(pcm[offset:offset + frame_bytes]) >= _VOICED_DBFS
+        for offset in range(0, len(pcm) - frame_bytes + 1, frame_bytes)
+    ]
+    if True not in voiced:
+        return None
+    first, last = voiced.index(True), len(voiced) - 1 - voiced[::-1].index(True)
+    pauses, quiet = 0, 0
+    for loud in voiced[first:last + 1]:
+        if loud:
+            if quiet * _FRAME_MS >= PRONUNCIATION_PAUSE_MS:
+                pauses += 1
+            quiet = 0
+        else:
+            quiet += 1
+    return (last - first + 1) * _FRAME_MS / 1000, pauses
+
+
+def score_reading(reference_text: str, transcription: str, audio: Optional[bytes] = None) -> PronunciationAssessment:
+    reference, heard = _words(reference_text), _words(transcription)
+    if not reference:
+        raise HTTPException(status_code=400, detail="reference_text must contain at least one word")
+    words: List[WordScore] = []
+    extra: List[str] = []
+    pending: List[str] = []
+    for ref_index, heard_index in align([n for _, n in reference], [n for _, n in heard]):
+        if ref_index is None:
+            pending.append(heard[heard_index][0])
+            continue
+        written, norm = reference[ref_index]
+        if heard_index is None:
+            score = WordScore(ref_index, written, None, "omitted", 0)
+        else:
+            accuracy = round(100 * similarity(norm, heard[heard_index][1]))
+            score = WordScore(
+                ref_index, written, heard[heard_index][0], "correct" if accuracy == 100 else "mispronounced", accuracy
+            )
+        score.extra_before, pending = pending, []
+        extra.extend(score.extra_before)
+        words.append(score)
+    extra.extend(pending)
+
+    accuracy = sum(w.accuracy for w in words) / len(words)
+    read = [w for w in words if w.status != "omitted"]
+    completeness = 100 * len(read) / len(words)
+    fluency = 100 * len(read) / (len(read) + len(extra)) if read else 0.0
+    pace = reading_pace(audio) if audio else None
+    words_per_minute = None
+    if pace is not None and read:
+        seconds, pauses = pace
+        words_per_minute = round(60 * len(read) / seconds, 1)
+        fluency *= min(1.0, words_per_minute / PRONUNCIATION_TARGET_WPM) if PRONUNCIATION_TARGET_WPM > 0 else 1.0
+        fluency /= 1 + 0.1 * pauses
+    return PronunciationAssessment(
+        reference_text=reference_text,
+        transcription=transcription,
+        accuracy=round(accuracy),
+        completeness=round(completeness),
+        fluency=round(fluency),
+        overall=round((accuracy + completeness + fluency) / 3),
+        words=words,
+        extra_words=extra,
+        words_per_minute=words_per_minute,
+        long_pauses=pace[1] if pace is not None else None,
+    )
+
+
+async def assess_pronunciation(
+    file: UploadFile,
+    reference_text: str,
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+) -> PronunciationAssessment:
+    """Transcribe the learner's reading of reference_text and score it."""
+    if len(reference_text) > MAX_REFERENCE_CHARS:
+        raise HTTPException(status_code=400, detail=f"reference_text must be <= {MAX_REFERENCE_CHARS} characters")
+    if not _words(reference_text):
+        raise HTTPException(status_code=400, detail="reference_text must contain at least one word")
+    data = await file.read()
+    if len(data) > MAX_UPLOAD_BYTES:
+        raise HTTPException(status_code=413, detail=f"File too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+    if not data:
+        raise RequestError(400, "empty_input", "Empty audio file")
+    set_input_audio(wav_duration(data[:4096]))
+    async with pipeline_limiter.slot():
+        with observe_stage("asr"):
+            result = await transcribe_audio(
+                audio_upload(data, file.content_type or "audio/wav", file.filename or "audio"),
+                language=language,
+                request_id=request_id,
+            )
+    return score_reading(reference_text, " ".join(result.text.split()), data)
@@ -0,0 +1,209 @@
+"""Request and response templates for ASR and TTS backends whose API is shaped a little differently.
+
+The ASR stage speaks OpenAI chat completions and the TTS stage POSTs {"text", "voice",
+"response_format"} to {base}/v1/audio/speech. A backend that wants other field names, query
+parameters or another path can be adapted in DWANI_PROVIDER_TEMPLATES_FILE instead of code:
+
+    {"tts": [{"url": "https://tts.vendor.example",
+              "path": "/synthesize", "query": {"lang": "{language_code}"},
+              "request": {"input": {"text": "{text}"}, "voice": "{voice}", "format": "{response_format}"},
+              "response": {"audio": "audioContent"}}],
+     "asr": [{"url": "https://asr.vendor.example/recognize",
+              "request": {"audio": {"content": "{audio_base64}"}, "lang": "{language_tag}"},
+              "response": {"text": "results[0].transcript", "confidence": "results[0].confidence"}}]}
+
+Each stage has a list of templates; the first whose "url" is a prefix of the backend URL the
+request goes to applies (no "url": every backend of the stage), so one pool can mix providers.
+"request" is the JSON body, with {name} placeholders filled from the stage's VARIABLES. A string
+that is only a placeholder takes the value as is (a number stays a number), and an object key
+whose value is missing (no voice configured, say) is left out. "query" is rendered the same way
+into query parameters, and "path" (TTS only; ASR URLs are already complete) replaces
+/v1/audio/speech. "response" maps what the stage reads to a path into the backend's JSON
+response: "a.b", "items[0].text". ASR reads text, language, confidence and language_confidence;
+TTS reads audio, base64, for backends that wrap the audio in JSON (the whole reply is then
+buffered). Without "response" the backend's reply is read as usual.
+"""
+import base64
+import binascii
+import json
+import re
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Optional, Tuple
+from urllib.parse import urlencode
+
+from config import PROVIDER_TEMPLATES_FILE, logger
+
+VARIABLES = {
+    "asr": {"audio_base64", "audio_data_url", "mime_type", "language", "language_code", "language_tag", "prompt"},
+    "tts": {"text", "voice", "language", "language_code", "language_tag", "response_format"},
+}
+RESPONSE_FIELDS = {
+    "asr": {"text", "language", "confidence", "language_confidence"},
+    "tts": {"audio"},
+}
+_SETTINGS = {"url", "path", "query", "request", "response"}
+_PLACEHOLDER = re.compile(r"\{(\w+)\}")
+_PATH_STEP = re.compile(r"([^.\[\]]+)|\[(\d+)\]")
+
+
+@dataclass(frozen=True)
+class ProviderTemplate:
+    stage: str
+    url: str = ""  # backend URL prefix; empty for every backend of the stage
+    path: Optional[str] = None  # TTS: instead of /v1/audio/speech
+    query: Dict[str, Any] = field(default_factory=dict)
+    request: Any = None  # None: the built-in body
+    response: Dict[str, str] = field(default_factory=dict)  # field the stage reads -> path into the response
+
+
+def _placeholders(template: Any) -> List[str]:
+    if isinstance(template, str):
+        return _PLACEHOLDER.findall(template)
+    if isinstance(template, dict):
+        return [name for value in template.values() for name in _placeholders(value)]
+    if isinstance(template, list):
+        return [name for value in template for name in _placeholders(value)]
+    return []
+
+
+def _missing(value: Any) -> bool:
+    return value is None or value == ""
+
+
+def render(template: Any, variables: Dict[str, Any]) -> Any:
+    """template with its {name} placeholders filled from variables (see the module docstring)."""
+    if isinstance(template, str):
+        whole = _PLACEHOLDER.fullmatch(template)
+        if whole:
+            return variables.get(whole.group(1))
+        return _PLACEHOLDER.sub(lambda m: "" if _missing(variables.get(m.group(1))) else str(variables[m.group(1)]), template)
+    if isinstance(template, dict):
+        rendered = {key: render(value, variables) for key, value in template.items()}
+        return {key: value for key, value in rendered.items() if not _missing(value)}
+    if isinstance(template, list):
+        return [render(value, variables) for value in template]
+    return template
+
+
+def _steps(path: str) -> List[Any]:
+    steps = []
+    for part in path.split("."):
+        matches = list(_PATH_STEP.finditer(part))
+        if not part or "".join(m.group(0) for m in matches) != part:
+            raise ValueError(f"invalid response path {path!r}")
+        steps.extend(int(m.group(2)) if m.group(2) is not None else m.group(1) for m in matches)
+    return steps
+
+
+def extract(body: Any, path: str) -> Any:
+    """The value at path ("a.b", "items[0].text") in a parsed JSON body; None when it is not there."""
+    value = body
+    for step in _steps(path):
+        if isinstance(step, int):
+            value = value[step] if isinstance(value, list) and -len(value) <= step < len(value) else None
+        else:
+            value = value.get(step) if isinstance(value, dict) else None
+        if value is None:
+            return None
+    return value
+
+
+def template_from_spec(spec: Any, stage: str, where: str) -> ProviderTemplate:
+    """A ProviderTemplate for stage; ValueError when malformed or it uses an unknown placeholder."""
+    if not isinstance(spec, dict):
+        raise ValueError(f"provider template {where} must be an object")
+    unknown = set(spec) - _SETTINGS
+    if unknown:
+        raise ValueError(f"provider template {where}: unknown settings {sorted(unknown)}, expected some of {sorted(_SETTINGS)}")
+    if spec.get("path") is not None and (stage != "tts" or not isinstance(spec["path"], str) or not spec["path"].startswith("/")):
+        raise ValueError(f"provider template {where}: path is only for tts and must start with /")
+    if not isinstance(spec.get("query", {}), dict):
+        raise ValueError(f"provider template {where}: query must be an object")
+    names = set(_placeholders(spec.get("request"))) | set(_placeholders(spec.get("query", {})))
+    if names - VARIABLES[stage]:
+        raise ValueError(f"provider template {where}: unknown placeholders {sorted(names - VARIABLES[stage])}, expected some of {sorted(VARIABLES[stage])}")
+    if stage == "asr" and spec.get("request") is not None:
+        audio = _placeholders(spec["request"]).count("audio_base64") + _placeholders(spec["request"]).count("audio_data_url")
+        if audio != 1:
+            raise ValueError(f"provider template {where}: the request must have exactly one {{audio_base64}} or {{audio_data_url}}")
+    response = spec.get("response") or {}
+    if not isinstance(response, dict) or set(response) - RESPONSE_FIELDS[stage] or not all(isinstance(p, str) for p in response.values()):
+        raise ValueError(f"provider template {where}: response must map some of {sorted(RESPONSE_FIELDS[stage])} to paths")
+    if stage == "asr" and response and "text" not in response:
+        raise ValueError(f"provider template {where}: an asr response needs a text path")
+    for path in response.values():
+        _steps(path)
+    return ProviderTemplate(
+        stage=stage,
+        url=str(spec.get("url") or "").strip(),
+        path=spec.get("path"),
+        query=spec.get("query") or {},
+        request=spec.get("request"),
+        response=response,
+    )
+
+
+def load_templates(path: str = PROVIDER_TEMPLATES_FILE) -> Dict[str, List[ProviderTemplate]]:
+    """stage -> its templates in path (see the module docstring); {} when it is not set."""
+    if not path:
+        return {}
+    with open(path, encoding="utf-8") as f:
+        specs = json.load(f)
+    if not isinstance(specs, dict) or set(specs) - set(VARIABLES):
+        raise ValueError(f"{path} must be an object with \"asr\" and/or \"tts\"")
+    loaded: Dict[str, List[ProviderTemplate]] = {}
+    for stage, entries in specs.items():
+        if not isinstance(entries, list):
+            raise ValueError(f"{path}: {stage} must be a list of templates")
+        loaded[stage] = [template_from_spec(spec, stage, f"{stage}[{i}]") for i, spec in enumerate(entries)]
+    logger.info("Loaded %s provider templates from %s", sum(map(len, loaded.values())), path)
+    return loaded
+
+
+templates = load_templates()
+
+
+def template_for(stage: str, url: str) -> Optional[ProviderTemplate]:
+    """The first of stage's templates whose url prefixes url; None when none does."""
+    for template in templates.get(stage, ()):
+        if url.startswith(template.url):
+            return template
+    return None
+
+
+def with_query(url: str, query: Dict[str, Any]) -> str:
+    """url with the rendered query parameters appended."""
+    if not query:
+        return url
+    return f"{url}{'&' if '?' in url else '?'}{urlencode(query, doseq=True)}"
+
+
+def asr_body(template: ProviderTemplate, variables: Dict[str, Any], placeholder: str) -> Dict[str, Any]:
+    """The rendered ASR request body, with placeholder where the base64 audio goes."""
+    return render(template.request, {**variables, "audio_base64": placeholder})
+
+
+def chat_completion_body(template: ProviderTemplate, body: Any) -> Dict[str, Any]:
+    """A backend's ASR response in the chat completions shape the stage reads (choices[0].message.content)."""
+    read = {name: extract(body, path) for name, path in template.response.items()}
+    text = read.get("text")
+    return {
+        "choices": [{"message": {"content": text if isinstance(text, str) else ""}}],
+        **{name: read[name] for name in ("language", "confidence", "language_confidence") if read.get(name) is not None},
+    }
+
+
+def tts_request(template: ProviderTemplate, base_url: str, payload: Dict[str, Any], variables: Dict[str, Any]) -> Tuple[str, Any]:
+    """(URL, JSON body) of a TTS request through template; payload is the built-in body."""
+    url = with_query(f"{base_url}{template.path or '/v1/audio/speech'}", render(template.query, variables))
+    return url, payload if template.request is None else render(template.request, variables)
+
+
+def tts_audio(template: ProviderTemplate, body: bytes) -> Optional[bytes]:
+    """The audio in a TTS backend's JSON reply, base64 at the template's audio path; None when it has none."""
+    try:
+        value = extract(json.loads(body), template.response["audio"])
+        return base64.b64decode(value, validate=True) if isinstance(value, str) else None
+    except (ValueError, binascii.Error) as exc:
+        logger.error("TTS reply did not match its provider template: %s", exc)
+        return None
@@ -0,0 +1,48 @@
+"""HTTP Range requests for audio served by GET, so mobile players can seek and resume downloads.
+
+Audio a GET serves whole (a shared turn's reply, a stitched read-aloud) advertises
+Accept-Ranges: bytes, and a request with one "bytes=" range gets 206 with Content-Range.
+Several ranges, another unit, or an If-Range (there are no validators to compare) are answered
+with the whole body, as RFC 9110 allows; a range past the end is 416.
+"""
+from typing import Dict, Optional, Tuple
+
+from fastapi import HTTPException, Request, Response
+
+ACCEPT_RANGES = {"Accept-Ranges": "bytes"}
+
+
+def byte_range(header: Optional[str], size: int) -> Optional[Tuple[int, int]]:
+    """[start, end) of a single bytes range of a size-byte body; None to send all of it. 416 when it is past the end."""
+    unit, _, spec = (header or "").partition("=")
+    if unit.strip().lower() != "bytes" or "," in spec:
+        return None
+    first, dash, last = spec.strip().partition("-")
+    if not dash:
+        return None
+    try:
+        if first.strip():
+            start = int(first)
+            end = int(last) + 1 if last.strip() else size
+            if start < 0 or last.strip() and end <= start:
+                return None
+        else:
+            # "bytes=-500": the last 500 bytes.
+            start, end = max(0, size - int(last)), size
+    except ValueError:
+        return None
+    end = min(end, size)
+    if start >= end:
+        raise HTTPException(status_code=416, detail="Requested range not satisfiable", headers={"Content-Range": f"bytes */{size}"})
+    return start, end
+
+
+def ranged_response(request: Request, body: bytes, media_type: str, headers: Optional[Dict[str, str]] = None) -> Response:
+    """body whole, or the part the request's Range header asks for."""
+    headers = {**(headers or {}), **ACCEPT_RANGES}
+    span = None if request.headers.get("if-range") else byte_range(request.headers.get("range"), len(body))
+    if span is None:
+        return Response(body, media_type=media_type, headers=headers)
+    start, end = span
+    headers["Content-Range"] = f"bytes {start}-{end - 1}/{len(body)}"
+    return Response(body[start:end], status_code=206, media_type=media_type, headers=headers)
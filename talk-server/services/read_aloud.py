@@ -0,0 +1,385 @@
+"""Read-aloud of long documents: text extraction, chunking, parallel TTS, and stitching.
+
+Documents (plain text, including text extracted from PDFs, or HTML) are split into chapters
+at headings and into chunks of whole sentences of at most DWANI_READ_CHUNK_CHARS. Chunks are
+synthesized as MP3 up to DWANI_READ_PARALLELISM at a time and kept, in order, as segments of
+an artifact on disk: served as one stitched MP3 or as a VOD HLS playlist (one segment per
+chunk). Artifacts expire after DWANI_READ_TTL_SECONDS; their random IDs act as capability
+URLs so HLS players can fetch them without an API key.
+"""
+import asyncio
+import json
+import math
+import re
+import shutil
+import time
+import uuid
+from dataclasses import dataclass, field
+from html.parser import HTMLParser
+from pathlib import Path
+from typing import Any, AsyncIterator, Dict, List, Optional
+
+from fastapi import HTTPException
+
+from config import READ_CHUNK_CHARS, READ_DIR, READ_MAX_CHARS, READ_PARALLELISM, READ_TTL_SECONDS, logger
+from models import GenerationParams
+from services.audio_format import mp3_duration, strip_id3, transcode
+from services.chat_svc import call_llm
+from services.concurrency import pipeline_limiter
+from services.prompts import language_display_name
+from services.tts import stream_tts
+
+_SENTENCE_END = re.compile(r"(?<=[.?!।॥])\s+")
+_HEADING_LINE = re.compile(r"^(?:#{1,3}\s+(?P<md>.+)|(?P<chapter>(?:chapter|part)\s+\S+.*))$", re.IGNORECASE)
+# Fallback pace for EXTINF when a segment's duration cannot be read from its frames.
+_CHARS_PER_SECOND = 14.0
+# Paragraphs are translated in batches of about this many characters per LLM call.
+_TRANSLATE_BATCH_CHARS = 2000
+
+_TRANSLATE_PROMPT = (
+    "Translate the user's text into {language}. Keep names, numbers, and the paragraph breaks "
+    "(blank lines) as they are. Output only the translation, with no notes or commentary."
+)
+
+
+@dataclass
+class Chapter:
+    title: Optional[str]
+    paragraphs: List[str] = field(default_factory=list)
+
+
+class _HTMLText(HTMLParser):
+    _SKIP = {"script", "style", "noscript", "template", "head"}
+    _BLOCK = {"p", "div", "li", "br", "tr", "section", "article", "blockquote", "pre", "dd", "dt"}
+    _HEADINGS = {"h1", "h2", "h3"}
+
+    def __init__(self):
+        super().__init__(convert_charrefs=True)
+        self.chapters: List[Chapter] = [Chapter(None)]
+        self._buffer: List[str] = []
+        self._skip_depth = 0
+        self._heading: Optional[List[str]] = None
+
+    def _flush(self) -> None:
+        text = " ".join("".join(self._buffer).split())
+        self._buffer = []
+        if text:
+            self.chapters[-1].paragraphs.append(text)
+
+    def handle_starttag(self, tag, attrs):
+        if tag in self._SKIP:
+            self._skip_depth += 1
+        elif tag in self._HEADINGS:
+            self._flush()
+            self._heading = []
+        elif tag in self._BLOCK or tag in {"h4", "h5", "h6"}:
+            self._flush()
+
+    def handle_endtag(self, tag):
+        if tag in self._SKIP:
+            self._skip_depth = max(0, self._skip_depth - 1)
+        elif tag in self._HEADINGS and self._heading is not None:
+            title = " ".join("".join(self._heading).split())
+            self._heading = None
+            if title:
+                self.chapters.append(Chapter(title))
+        elif tag in self._BLOCK or tag in {"h4", "h5", "h6"}:
+            self._flush()
+
+    def handle_data(self, data):
+        if self._skip_depth:
+            return
+        if self._heading is not None:
+            self._heading.append(data)
+        else:
+            self._buffer.append(data)
+
+    def close(self):
+        super().close()
+        self._flush()
+
+
+def _plain_chapters(text: str) -> List[Chapter]:
+    chapters = [Chapter(None)]
+    for block in re.split(r"\n\s*\n", text.replace("\r\n", "\n")):
+        lines = [line.strip() for line in block.strip().splitlines() if line.strip()]
+        if not lines:
+            continue
+        heading = _HEADING_LINE.match(lines[0]) if len(lines[0]) <= 120 else None
+        if heading:
+            chapters.append(Chapter((heading.group("md") or heading.group("chapter")).strip()))
+            lines = lines[1:]
+        if lines:
+            chapters[-1].paragraphs.append(" ".join(" ".join(lines).split()))
+    return chapters
+
+
+def parse_document(data: str, content_type: Optional[str] = None) -> List[Chapter]:
+    """Chapters of a plain-text or HTML document; empty leading chapters are dropped."""
+    is_html = "html" in (content_type or "") or data.lstrip()[:1] == "<"
+    if is_html:
+        parser = _HTMLText()
+        parser.feed(data)
+        parser.close()
+        chapters = parser.chapters
+    else:
+        chapters = _plain_chapters(data)
+    return [c for c in chapters if c.paragraphs or c.title]
+
+
+def chunk_sentences(paragraph: str, max_chars: int = READ_CHUNK_CHARS) -> List[str]:
+    """Whole sentences packed into chunks of at most max_chars (a longer sentence is split at spaces)."""
+    chunks: List[str] = []
+    current = ""
+    for sentence in _SENTENCE_END.split(paragraph):
+        pieces = [sentence]
+        if len(sentence) > max_chars:
+            pieces, line = [], ""
+            for word in sentence.split():
+                if line and len(line) + 1 + len(word) > max_chars:
+                    pieces.append(line)
+                    line = word
+                else:
+                    line = f"{line} {word}".strip()
+            pieces.append(line)
+        for piece in pieces:
+            if current and len(current) + 1 + len(piece) > max_chars:
+                chunks.append(current)
+                current = piece
+            else:
+                current = f"{current} {piece}".strip()
+    if current:
+        chunks.append(current)
+    return chunks
+
+
+@dataclass
+class ReadChunk:
+    chapter: int
+    text: str
+
+
+def plan_chunks(chapters: List[Chapter], max_chars: int = READ_CHUNK_CHARS) -> List[ReadChunk]:
+    """Chapter titles are read as their own chunk, then the chapter's paragraphs."""
+    chunks = []
+    for index, chapter in enumerate(chapters):
+        if chapter.title:
+            chunks.append(ReadChunk(index, chapter.title))
+        for paragraph in chapter.paragraphs:
+            chunks.extend(ReadChunk(index, text) for text in chunk_sentences(paragraph, max_chars))
+    return chunks
+
+
+async def translate_chapters(
+    chapters: List[Chapter],
+    language: str,
+    request_id: Optional[str] = None,
+    parallelism: int = READ_PARALLELISM,
+) -> List[Chapter]:
+    """Chapters translated into language by the LLM, batching paragraphs to save round trips."""
+    texts = [c.title for c in chapters if c.title] + [p for c in chapters for p in c.paragraphs]
+    batches: List[List[str]] = [[]]
+    for text in texts:
+        if batches[-1] and sum(len(t) for t in batches[-1]) + len(text) > _TRANSLATE_BATCH_CHARS:
+            batches.append([])
+        batches[-1].append(text)
+    prompt = _TRANSLATE_PROMPT.format(language=language_display_name(language))
+    gate = asyncio.Semaphore(max(1, parallelism))
+
+    async def ask(batch: List[str]) -> List[str]:
+        async with gate, pipeline_limiter.slot():
+            out = await call_llm(
+                "\n\n".join(batch),
+                request_id=request_id,
+                language=language,
+                generation=GenerationParams(temperature=0, max_tokens=min(8192, max(512, 2 * sum(map(len, batch))))),
+                system_prompt=prompt,
+            )
+        return [p.strip() for p in out.split("\n\n") if p.strip()]
+
+    async def one(batch: List[str]) -> List[str]:
+        parts = await ask(batch)
+        if len(parts) == len(batch):
+            return parts
+        if len(batch) == 1:
+            return [" ".join(parts)]
+        # The model merged or split paragraphs: translate one by one so chapters stay aligned.
+        return [" ".join(await ask([text])) for text in batch]
+
+    results = iter([t for batch in await asyncio.gather(*(one(b) for b in batches if b)) for t in batch])
+    translated = [Chapter(next(results) if c.title else None) for c in chapters]
+    for chapter, source in zip(translated, chapters):
+        chapter.paragraphs = [next(results) for _ in source.paragraphs]
+    return translated
+
+
+async def _synthesize(text: str, language: Optional[str], request_id: Optional[str]) -> bytes:
+    async with pipeline_limiter.slot():
+        stream = await stream_tts(text, request_id=request_id, language=language, audio_format="mp3")
+        audio = await stream.read()
+    if stream.audio_format != "mp3":
+        audio = await transcode(audio, "mp3")  # HLS and concatenation need MPEG audio
+    return audio
+
+
+async def synthesize_chunks(
+    chunks: List[ReadChunk],
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+    parallelism: int = READ_PARALLELISM,
+) -> List[bytes]:
+    """MP3 for each chunk, in order; up to `parallelism` TTS requests run at once."""
+    gate = asyncio.Semaphore(max(1, parallelism))
+
+    async def one(chunk: ReadChunk) -> bytes:
+        async with gate:
+            return await _synthesize(chunk.text, language, request_id)
+
+    tasks = [asyncio.create_task(one(chunk)) for chunk in chunks]
+    try:
+        return list(await asyncio.gather(*tasks))
+    except BaseException:
+        for task in tasks:
+            task.cancel()
+        await asyncio.gather(*tasks, return_exceptions=True)
+        raise
+
+
+def stitch_mp3(parts: List[bytes]) -> bytes:
+    """One MP3 stream: MPEG frames concatenate cleanly once later ID3 tags are dropped."""
+    return b"".join(part if i == 0 else strip_id3(part) for i, part in enumerate(parts))
+
+
+def chapter_timeline(chunks: List[ReadChunk], durations: List[float], chapters: List[Chapter]) -> List[Dict[str, Any]]:
+    timeline: List[Dict[str, Any]] = []
+    position = 0.0
+    for chunk, duration in zip(chunks, durations):
+        if not timeline or timeline[-1]["index"] != chunk.chapter:
+            timeline.append({
+                "index": chunk.chapter,
+                "title": chapters[chunk.chapter].title,
+                "start_seconds": round(position, 3),
+                "duration_seconds": 0.0,
+            })
+        timeline[-1]["duration_seconds"] = round(timeline[-1]["duration_seconds"] + duration, 3)
+        position += duration
+    return timeline
+
+
+def segment_durations(chunks: List[ReadChunk], parts: List[bytes]) -> List[float]:
+    return [
+        mp3_duration(audio) or max(1.0, len(chunk.text) / _CHARS_PER_SECOND)
+        for chunk, audio in zip(chunks, parts)
+    ]
+
+
+def hls_playlist(manifest: Dict[str, Any]) -> str:
+    durations = manifest["segments"]
+    titles = {c["first_segment"]: c["title"] for c in manifest["chapters"] if c.get("title")}
+    lines = [
+        "#EXTM3U",
+        "#EXT-X-VERSION:3",
+        f"#EXT-X-TARGETDURATION:{max(1, math.ceil(max(durations, default=1)))}",
+        "#EXT-X-MEDIA-SEQUENCE:0",
+        "#EXT-X-PLAYLIST-TYPE:VOD",
+    ]
+    for index, duration in enumerate(durations):
+        lines.append(f"#EXTINF:{duration:.3f},{titles.get(index, '')}")
+        lines.append(f"segments/{index}.mp3")
+    lines.append("#EXT-X-ENDLIST")
+    return "\n".join(lines) + "\n"
+
+
+class ReadArtifacts:
+    """Synthesized documents on disk, one directory per artifact, removed after the TTL."""
+
+    def __init__(self, directory: str = READ_DIR, ttl_seconds: int = READ_TTL_SECONDS):
+        self.directory = Path(directory)
+        self.ttl_seconds = ttl_seconds
+
+    def _dir(self, artifact_id: str) -> Optional[Path]:
+        if not re.fullmatch(r"[0-9a-f]{32}", artifact_id or ""):
+            return None
+        return self.directory / artifact_id
+
+    def create(
+        self,
+        chunks: List[ReadChunk],
+        parts: List[bytes],
+        chapters: List[Chapter],
+        title: Optional[str] = None,
+        source_url: Optional[str] = None,
+    ) -> Dict[str, Any]:
+        self.sweep()
+        artifact_id = uuid.uuid4().hex
+        path = self.directory / artifact_id
+        (path / "segments").mkdir(parents=True)
+        for index, audio in enumerate(parts):
+            (path / "segments" / f"{index}.mp3").write_bytes(audio)
+        durations = [round(d, 3) for d in segment_durations(chunks, parts)]
+        timeline = chapter_timeline(chunks, durations, chapters)
+        first_segment = {}
+        for index, chunk in enumerate(chunks):
+            first_segment.setdefault(chunk.chapter, index)
+        for entry in timeline:
+            entry["first_segment"] = first_segment[entry["index"]]
+        manifest = {
+            "id": artifact_id,
+            "title": title,
+            "source_url": source_url,
+            "created_at": time.time(),
+            "expires_at": time.time() + self.ttl_seconds,
+            "segments": durations,
+            "duration_seconds": round(sum(durations), 3),
+            "characters": sum(len(chunk.text) for chunk in chunks),
+            "chapters": timeline,
+        }
+        (path / "manifest.json").write_text(json.dumps(manifest), encoding="utf-8")
+        return manifest
+
+    def manifest(self, artifact_id: str) -> Optional[Dict[str, Any]]:
+        path = self._dir(artifact_id)
+        if path is None:
+            return None
+        try:
+            manifest = json.loads((path / "manifest.json").read_text(encoding="utf-8"))
+        except (OSError, ValueError):
+            return None
+        if manifest.get("expires_at", 0) < time.time():
+            shutil.rmtree(path, ignore_errors=True)
+            return None
+        return manifest
+
+    def segment_path(self, artifact_id: str, index: int) -> Optional[Path]:
+        manifest = self.manifest(artifact_id)
+        if manifest is None or not 0 <= index < len(manifest["segments"]):
+            return None
+        return self.directory / artifact_id / "segments" / f"{index}.mp3"
+
+    async def iter_audio(self, artifact_id: str, manifest: Dict[str, Any]) -> AsyncIterator[bytes]:
+        for index in range(len(manifest["segments"])):
+            data = await asyncio.to_thread((self.directory / artifact_id / "segments" / f"{index}.mp3").read_bytes)
+            yield data if index == 0 else strip_id3(data)
+
+    def sweep(self) -> None:
+        if not self.directory.is_dir():
+            return
+        cutoff = time.time() - self.ttl_seconds
+        for path in self.directory.iterdir():
+            try:
+                if path.is_dir() and path.stat().st_mtime < cutoff:
+                    shutil.rmtree(path, ignore_errors=True)
+            except OSError as exc:
+                logger.warning("Failed to remove expired read-aloud artifact %s: %s", path.name, exc)
+
+
+read_artifacts = ReadArtifacts()
+
+
+def document_text(raw: bytes) -> str:
+    if len(raw) > READ_MAX_CHARS * 4:
+        raise HTTPException(status_code=413, detail=f"Document too large (max {READ_MAX_CHARS} characters)")
+    try:
+        return raw.decode("utf-8-sig")
+    except UnicodeDecodeError:
+        raise HTTPException(status_code=400, detail="Document must be UTF-8 text or HTML")
@@ -0,0 +1,298 @@
+"""Opt-in recording of what each request passed through the pipeline, so bad-answer reports
+can be reproduced.
+
+With DWANI_RECORD_ARTIFACTS=1, hooks at every pipeline point (services/hooks.py) write what
+went through them under DWANI_RECORD_DIR/<request id>/: the uploaded audio, the transcript,
+the text sent to the LLM and its reply, the text sent to TTS, and the TTS audio once it has
+been relayed in full. They are registered ahead of plugin hooks, so inputs are recorded as
+received and outputs as the backends returned them. manifest.json lists the files in order
+with the session, language, mode and agent. Recordings expire after DWANI_RECORD_TTL_SECONDS
+and only the newest DWANI_RECORD_MAX_REQUESTS are kept, swept by the retention sweeper
+(services/retention.py) as well as whenever a recording starts. The admin API
+(routers/artifacts.py) lists, serves and deletes them; DELETE /v1/sessions/{id} erases a
+session's (services/erasure.py).
+
+With DWANI_RECORD_ENCRYPTION_KEY (32 bytes, base64; or _FILE) every artifact is encrypted at
+rest with AES-256-GCM, bound to its request and name, and decrypted when the admin API serves it.
+Manifests, which hold no audio or text, stay readable. Artifacts recorded before the key was set
+are served as they are.
+
+Recordings hold what users said; turn this on for debugging, not as an archive.
+"""
+import asyncio
+import base64
+import binascii
+import hashlib
+import json
+import os
+import re
+import shutil
+import threading
+import time
+from pathlib import Path
+from typing import Any, Dict, List, Optional
+
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from fastapi import UploadFile
+
+from config import RECORD_ARTIFACTS, RECORD_DIR, RECORD_MAX_REQUESTS, RECORD_TTL_SECONDS, logger
+from services.audio_format import detect_format
+from services.credentials import secret
+from services.hooks import HookContext, PipelineHooks, hooks
+from services.language_id import language_tag
+from services.tenants import current_tenant_name
+from services.tts import TTSStream
+
+_SAFE_ID = re.compile(r"[A-Za-z0-9][A-Za-z0-9_.-]{0,127}")
+_EXTENSIONS = {"mp3": ".mp3", "wav": ".wav", "ogg": ".ogg"}
+_MEDIA_TYPES = {".mp3": "audio/mpeg", ".wav": "audio/wav", ".ogg": "audio/ogg", ".txt": "text/plain; charset=utf-8"}
+_SEALED = b"DWE1"  # marks an encrypted artifact: then a 12-byte nonce and the AES-GCM ciphertext
+
+
+def _audio_extension(content_type: Optional[str], head: bytes) -> str:
+    return _EXTENSIONS.get(detect_format(content_type, head[:12]) or "", ".bin")
+
+
+def encryption_key(value: Optional[str] = None) -> Optional[bytes]:
+    """DWANI_RECORD_ENCRYPTION_KEY decoded, or None when unset; ValueError unless it is 32 bytes of base64."""
+    value = secret("DWANI_RECORD_ENCRYPTION_KEY") if value is None else value
+    if not value:
+        return None
+    try:
+        key = base64.b64decode(value, validate=True)
+    except (binascii.Error, ValueError):
+        key = b""
+    if len(key) != 32:
+        raise ValueError("DWANI_RECORD_ENCRYPTION_KEY must be 32 bytes, base64-encoded (e.g. openssl rand -base64 32)")
+    return key
+
+
+class ArtifactRecorder:
+    """One directory of numbered artifact files and a manifest per request ID."""
+
+    def __init__(
+        self,
+        directory: str = RECORD_DIR,
+        ttl_seconds: int = RECORD_TTL_SECONDS,
+        max_requests: int = RECORD_MAX_REQUESTS,
+        key: Optional[bytes] = None,
+    ):
+        """key encrypts artifacts at rest; None reads DWANI_RECORD_ENCRYPTION_KEY on first use."""
+        self.directory = Path(directory)
+        self.ttl_seconds = ttl_seconds
+        self.max_requests = max_requests
+        self._key = key
+        self._cipher: Optional[AESGCM] = None
+        self._lock = threading.Lock()
+
+    @property
+    def cipher(self) -> Optional[AESGCM]:
+        if self._cipher is None:
+            key = self._key if self._key is not None else encryption_key()
+            self._cipher = AESGCM(key) if key else None
+        return self._cipher
+
+    @property
+    def encrypted(self) -> bool:
+        return self.cipher is not None
+
+    def _seal(self, path: Path, name: str, data: bytes) -> bytes:
+        if self.cipher is None:
+            return data
+        nonce = os.urandom(12)
+        return _SEALED + nonce + self.cipher.encrypt(nonce, data, f"{path.name}/{name}".encode("utf-8"))
+
+    def _open(self, path: Path, name: str, data: bytes) -> bytes:
+        if not data.startswith(_SEALED):
+            return data
+        if self.cipher is None:
+            raise ValueError(f"Artifact {name} is encrypted and DWANI_RECORD_ENCRYPTION_KEY is not set")
+        nonce, sealed = data[len(_SEALED):len(_SEALED) + 12], data[len(_SEALED) + 12:]
+        return self.cipher.decrypt(nonce, sealed, f"{path.name}/{name}".encode("utf-8"))
+
+    @staticmethod
+    def dirname(request_id: str) -> str:
+        """request_id itself when it is safe as a file name; client-supplied IDs may not be."""
+        if _SAFE_ID.fullmatch(request_id) and request_id not in (".", ".."):
+            return request_id
+        return hashlib.sha256(request_id.encode("utf-8")).hexdigest()[:32]
+
+    def _path(self, request_id: str) -> Path:
+        return self.directory / self.dirname(request_id)
+
+    def record(self, ctx: HookContext, stage: str, data: bytes, extension: str) -> Optional[str]:
+        """Write one artifact; its file name, or None when it could not be stored."""
+        if not ctx.request_id:
+            return None
+        path = self._path(ctx.request_id)
+        try:
+            with self._lock:
+                created = not path.is_dir()
+                if created:
+                    path.mkdir(parents=True)
+                manifest = self._read_manifest(path) or {
+                    "request_id": ctx.request_id,
+                    "created_at": time.time(),
+                    "artifacts": [],
+                }
+                name = f"{len(manifest['artifacts']):02d}-{stage}{extension}"
+                (path / name).write_bytes(self._seal(path, name, data))
+                manifest.update({
+                    "session_id": ctx.session_id,
+                    "tenant": current_tenant_name(),
+                    "language": language_tag(ctx.language),
+                    "mode": ctx.mode,
+                    "agent_name": ctx.agent_name,
+                    "input_type": ctx.input_type,
+                })
+                manifest["artifacts"].append({
+                    "name": name, "stage": stage, "bytes": len(data), "at": time.time(), "encrypted": self.encrypted,
+                })
+                (path / "manifest.json").write_text(json.dumps(manifest, ensure_ascii=False), encoding="utf-8")
+        except OSError as exc:
+            logger.warning("Could not record %s artifact for request %s: %s", stage, ctx.request_id, exc)
+            return None
+        if created:
+            self.sweep()
+        return name
+
+    @staticmethod
+    def _read_manifest(path: Path) -> Optional[Dict[str, Any]]:
+        try:
+            return json.loads((path / "manifest.json").read_text(encoding="utf-8"))
+        except (OSError, ValueError):
+            return None
+
+    def manifest(self, request_id: str) -> Optional[Dict[str, Any]]:
+        path = self._path(request_id)
+        manifest = self._read_manifest(path)
+        if manifest is None:
+            return None
+        if manifest.get("created_at", 0) < time.time() - self.ttl_seconds:
+            shutil.rmtree(path, ignore_errors=True)
+            return None
+        return manifest
+
+    def artifact(self, request_id: str, name: str) -> Optional[Path]:
+        """Path of one listed artifact (names come from the manifest, never from the caller alone)."""
+        manifest = self.manifest(request_id)
+        if manifest is None or name not in {a["name"] for a in manifest["artifacts"]}:
+            return None
+        return self._path(request_id) / name
+
+    def read_artifact(self, request_id: str, name: str) -> Optional[bytes]:
+        """One listed artifact's content, decrypted; None when there is no such artifact."""
+        path = self.artifact(request_id, name)
+        if path is None or not path.is_file():
+            return None
+        return self._open(path.parent, name, path.read_bytes())
+
+    def recent(self, limit: int = 100) -> List[Dict[str, Any]]:
+        """Newest first: request ID, creation time, and the stages recorded."""
+        if not self.directory.is_dir():
+            return []
+        summaries = []
+        for path in self.directory.iterdir():
+            manifest = self._read_manifest(path) if path.is_dir() else None
+            if manifest is not None:
+                summaries.append({
+                    "request_id": manifest["request_id"],
+                    "created_at": manifest["created_at"],
+                    "session_id": manifest.get("session_id"),
+                    "stages": [a["stage"] for a in manifest["artifacts"]],
+                })
+        summaries.sort(key=lambda s: s["created_at"], reverse=True)
+        return summaries[:limit]
+
+    def delete(self, request_id: str) -> bool:
+        path = self._path(request_id)
+        if not path.is_dir():
+            return False
+        shutil.rmtree(path, ignore_errors=True)
+        return True
+
+    def delete_session(self, session_id: str, tenant: Optional[str] = None) -> int:
+        """Remove every recording of the tenant's session (None: the deployment's own); how many there were."""
+        if not self.directory.is_dir():
+            return 0
+        deleted = 0
+        for path in self.directory.iterdir():
+            manifest = self._read_manifest(path) if path.is_dir() else None
+            if manifest is not None and manifest.get("session_id") == session_id and manifest.get("tenant") == tenant:
+                shutil.rmtree(path, ignore_errors=True)
+                deleted += 1
+        return deleted
+
+    def sweep(self) -> None:
+        """Remove recordings past the TTL, then the oldest beyond DWANI_RECORD_MAX_REQUESTS."""
+        if not self.directory.is_dir():
+            return
+        cutoff = time.time() - self.ttl_seconds
+        kept = []
+        for path in self.directory.iterdir():
+            try:
+                if not path.is_dir():
+                    continue
+                mtime = path.stat().st_mtime
+                if mtime < cutoff:
+                    shutil.rmtree(path, ignore_errors=True)
+                else:
+                    kept.append((mtime, path))
+            except OSError as exc:
+                logger.warning("Failed to remove expired recording %s: %s", path.name, exc)
+        kept.sort()
+        for _, path in kept[:max(0, len(kept) - self.max_requests)]:
+            shutil.rmtree(path, ignore_errors=True)
+
+    def media_type(self, name: str) -> str:
+        return _MEDIA_TYPES.get(Path(name).suffix, "application/octet-stream")
+
+
+recorder = ArtifactRecorder()
+
+
+def _text_hook(stage: str):
+    async def hook(ctx: HookContext, value: Any) -> None:
+        if isinstance(value, str):
+            await asyncio.to_thread(recorder.record, ctx, stage, value.encode("utf-8"), ".txt")
+    hook.__name__ = f"record_{stage}"
+    return hook
+
+
+async def _record_upload(ctx: HookContext, file: UploadFile) -> None:
+    await file.seek(0)
+    data = await file.read()
+    await file.seek(0)
+    await asyncio.to_thread(recorder.record, ctx, "asr_input", data, _audio_extension(file.content_type, data))
+
+
+async def _record_tts_audio(ctx: HookContext, stream: TTSStream) -> None:
+    loop = asyncio.get_running_loop()
+
+    def store(content_type: Optional[str], audio: bytes) -> None:
+        loop.run_in_executor(None, recorder.record, ctx, "tts_audio", audio, _audio_extension(content_type, audio))
+
+    stream.observe(store)
+
+
+_HOOKS = {
+    "before_asr": _record_upload,
+    "after_asr": _text_hook("transcript"),
+    "before_llm": _text_hook("llm_input"),
+    "after_llm": _text_hook("llm_reply"),
+    "before_tts": _text_hook("tts_input"),
+    "after_tts": _record_tts_audio,
+}
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the recording hooks when DWANI_RECORD_ARTIFACTS is on."""
+    if not RECORD_ARTIFACTS or _record_upload in registry.registered("before_asr"):
+        return False
+    for point, hook in _HOOKS.items():
+        registry.register(point, hook)
+    logger.warning(
+        "Recording request artifacts (audio and text) under %s%s",
+        recorder.directory, ", encrypted" if recorder.encrypted else "",
+    )
+    return True
@@ -0,0 +1,92 @@
+"""Replay protection for expensive upload endpoints (/v1/speech_to_speech, /v1/audio/transcriptions).
+
+Buggy clients stuck in a retry loop send the same recording over and over, and each copy costs
+a full ASR (and LLM and TTS) run. With DWANI_REPLAY_WINDOW_SECONDS set, an upload identical to
+one from the same caller (API key, else client IP) within the window, byte for byte and with the
+same path, query, form fields and session, is caught by its hash. DWANI_REPLAY_ACTION=cache
+answers it with the first request's response (Idempotent-Replayed: true; a copy arriving while
+the first still runs waits for it); reject refuses it with 409 and a Retry-After until the
+window ends. Failed requests are not kept, so they can be sent again. Typed text is never
+caught, since users repeat short answers ("yes") on purpose.
+
+Requests with an Idempotency-Key are left to services/idempotency.py. Like it, this works per
+worker and buffers cached responses in full.
+"""
+import math
+import time
+from collections import OrderedDict
+from typing import Awaitable, Callable, Optional
+
+from fastapi import HTTPException, Request
+from fastapi.responses import Response
+from prometheus_client import Counter
+
+from config import IDEMPOTENCY_MAX_ENTRIES, REPLAY_ACTION, REPLAY_WINDOW_SECONDS
+from services.idempotency import IdempotencyCache, fingerprint
+
+_REPLAYS = Counter("dwani_upload_replays_total", "Identical uploads caught by replay protection", ["action"])
+
+
+def replay_key(request: Request, upload: str, content_type: Optional[str] = None, *fields: Optional[str]) -> Optional[str]:
+    """Hash of the caller, path, query, upload (its upload_digest) and other form fields; None for an empty upload."""
+    if not upload:
+        return None
+    caller = request.headers.get("X-API-Key") or request.headers.get("Authorization") or getattr(request.client, "host", "")
+    return fingerprint("replay", caller, request.url.path, str(request.url.query), content_type, upload, *fields)
+
+
+class ReplayGuard:
+    """Upload hashes seen within the window, with their responses when the action is cache."""
+
+    def __init__(self, window_seconds: int = REPLAY_WINDOW_SECONDS, action: str = REPLAY_ACTION, max_entries: int = IDEMPOTENCY_MAX_ENTRIES):
+        self.window_seconds = window_seconds
+        self.action = action
+        self.max_entries = max_entries
+        self._seen: "OrderedDict[str, float]" = OrderedDict()
+        self._responses = IdempotencyCache(ttl_seconds=window_seconds, max_entries=max_entries)
+
+    @property
+    def enabled(self) -> bool:
+        return self.window_seconds > 0
+
+    def clear(self) -> None:
+        self._seen.clear()
+        self._responses.clear()
+
+    def __len__(self) -> int:
+        return len(self._seen)
+
+    def _check_reject(self, key: str) -> None:
+        now = time.monotonic()
+        for seen_key in [k for k, until in self._seen.items() if until <= now]:
+            del self._seen[seen_key]
+        until = self._seen.get(key)
+        if until is not None:
+            _REPLAYS.labels(action="rejected").inc()
+            raise HTTPException(
+                status_code=409,
+                detail="The same upload was just sent; not processing it again",
+                headers={"Retry-After": str(max(1, math.ceil(until - now)))},
+            )
+        self._seen[key] = now + self.window_seconds
+        while len(self._seen) > self.max_entries:
+            self._seen.popitem(last=False)
+
+    async def run(self, key: Optional[str], respond: Callable[[], Awaitable[Response]]) -> Response:
+        """respond() unless key repeats one seen within the window."""
+        if not self.enabled or key is None:
+            return await respond()
+        if self.action == "reject":
+            self._check_reject(key)
+            try:
+                return await respond()
+            except Exception:
+                # A failed request does not count, so the client may send it again.
+                self._seen.pop(key, None)
+                raise
+        if self._responses.holds(key):
+            _REPLAYS.labels(action="cached").inc()
+        return await self._responses.run(key, key, respond)
+
+
+replay_guard = ReplayGuard()
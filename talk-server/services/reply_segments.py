@@ -0,0 +1,100 @@
+"""Long replies as a manifest of audio segment URLs, so playback starts at once and an
+interrupted reply is never synthesized in full.
+
+format=segments on /v1/speech_to_speech answers with the turn as JSON and, instead of the reply
+audio, the reply split into segments of whole sentences of at most DWANI_REPLY_SEGMENT_CHARS,
+each with a URL. Nothing is synthesized until a segment is fetched: a client plays the first,
+fetches the next while it plays, and stops fetching when the user interrupts.
+
+Each URL is a token holding its segment's text, language and expiry
+(DWANI_REPLY_SEGMENT_TTL_SECONDS), sealed with AES-256-GCM under a key derived from
+DWANI_REPLY_SEGMENT_SECRET (or _FILE), so no server state is kept, every replica sharing the secret
+serves it, and the reply text stays out of access logs. Like read-aloud artifact IDs the tokens
+are capability URLs, fetched without an API key. Without a secret the key is random per process.
+"""
+import base64
+import binascii
+import hashlib
+import json
+import os
+import re
+import time
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional
+
+from cryptography.exceptions import InvalidTag
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from fastapi import HTTPException
+
+from config import REPLY_SEGMENT_CHARS, REPLY_SEGMENT_TTL_SECONDS
+from services.credentials import signing_secret
+from services.read_aloud import chunk_sentences
+
+_AAD = b"dwani-reply-segment"
+_PARAGRAPH_BREAK = re.compile(r"\n\s*\n")
+
+
+@dataclass(frozen=True)
+class Segment:
+    text: str
+    language: Optional[str]
+    index: int
+    count: int
+    request_id: Optional[str]
+    expires_at: int  # Unix seconds
+
+
+def split_reply(reply: str, max_chars: int = REPLY_SEGMENT_CHARS) -> List[str]:
+    """reply as segments of whole sentences, paragraph by paragraph (a longer sentence is split at spaces)."""
+    segments: List[str] = []
+    for paragraph in _PARAGRAPH_BREAK.split(reply):
+        if paragraph.strip():
+            segments.extend(chunk_sentences(" ".join(paragraph.split()), max(1, max_chars)))
+    return segments
+
+
+def _cipher() -> AESGCM:
+    return AESGCM(hashlib.sha256(signing_secret("DWANI_REPLY_SEGMENT_SECRET", "reply segment URLs")).digest())
+
+
+def seal(segment: Segment) -> str:
+    payload = json.dumps({
+        "t": segment.text, "l": segment.language, "i": segment.index, "n": segment.count,
+        "r": segment.request_id, "exp": segment.expires_at,
+    }, ensure_ascii=False, separators=(",", ":")).encode("utf-8")
+    nonce = os.urandom(12)
+    return base64.urlsafe_b64encode(nonce + _cipher().encrypt(nonce, payload, _AAD)).rstrip(b"=").decode("ascii")
+
+
+def open_token(token: str) -> Segment:
+    """The segment a token holds; 404 when it is malformed, forged or expired."""
+    try:
+        sealed = base64.urlsafe_b64decode(token + "=" * (-len(token) % 4))
+        if len(sealed) <= 12 + 16:  # the nonce and the GCM tag
+            raise ValueError("too short")
+        payload = json.loads(_cipher().decrypt(sealed[:12], sealed[12:], _AAD))
+        segment = Segment(
+            str(payload["t"]), payload.get("l"), int(payload["i"]), int(payload["n"]), payload.get("r"), int(payload["exp"])
+        )
+    except (binascii.Error, InvalidTag, ValueError, KeyError, TypeError):
+        raise HTTPException(status_code=404, detail="Reply segment not found or expired")
+    if segment.expires_at <= time.time():
+        raise HTTPException(status_code=404, detail="Reply segment not found or expired")
+    return segment
+
+
+def segment_manifest(
+    reply: str, language: Optional[str], request_id: Optional[str], ttl_seconds: int = REPLY_SEGMENT_TTL_SECONDS
+) -> Dict[str, Any]:
+    """The format=segments fields: each segment's text and URL, and when the URLs expire."""
+    texts = split_reply(reply, REPLY_SEGMENT_CHARS)
+    expires_at = int(time.time()) + max(1, ttl_seconds)
+    segments = [
+        {
+            "index": index,
+            "text": text,
+            "url": f"/v1/replies/segments/{seal(Segment(text, language, index, len(texts), request_id, expires_at))}",
+        }
+        for index, text in enumerate(texts)
+    ]
+    return {"segments": segments, "segments_expire_at": expires_at}
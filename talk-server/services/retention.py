@@ -0,0 +1,108 @@
+"""Retention for stored conversations, recordings and sessions: scheduled purge, soft delete and legal hold.
+
+With DWANI_CONVERSATION_RETENTION_DAYS set, stored turns (DWANI_STORE_CONVERSATIONS=1) older
+than that are erased. An admin can soft-delete a conversation: it disappears from GraphQL and
+share links at once and is erased DWANI_CONVERSATION_DELETE_GRACE_DAYS later, unless restored
+in the meantime. A conversation under legal hold is exempt from both and cannot be deleted until
+the hold is released. Holds and deletions are kept per conversation in conversation_flags
+(migration 0008) and managed through /admin/conversations (routers/conversations.py).
+
+The purge runs every DWANI_CONVERSATION_PURGE_INTERVAL_SECONDS inside the server, or from cron:
+
+    python -m services.retention
+
+Each run also removes request recordings past DWANI_RECORD_TTL_SECONDS (services/recorder.py)
+and, with the SQLite session store, sessions past DWANI_SESSION_TTL_SECONDS, so neither waits for
+the next recording or restart. Erasing one session at once is services/erasure.py's job.
+
+Turns already exported to Parquet (services/exporter.py) are recalled by none of these.
+"""
+import asyncio
+from typing import Dict, Optional
+
+from prometheus_client import Counter
+
+import conversation_store
+from config import (
+    CONVERSATION_DELETE_GRACE_DAYS,
+    CONVERSATION_PURGE_INTERVAL_SECONDS,
+    CONVERSATION_RETENTION_DAYS,
+    RECORD_ARTIFACTS,
+    STORE_CONVERSATIONS,
+    logger,
+)
+from services.recorder import recorder
+from services.session import SQLiteSessionStore, session_store
+
+_PURGED = Counter("dwani_conversation_turns_purged_total", "Stored conversation turns erased, by reason", ["reason"])
+
+
+def purge_once(
+    retention_days: int = CONVERSATION_RETENTION_DAYS, grace_days: int = CONVERSATION_DELETE_GRACE_DAYS
+) -> Dict[str, int]:
+    """Erase what retention and expired soft deletes allow; the turns erased per reason."""
+    purged = conversation_store.purge_conversations(retention_days, grace_days)
+    for reason, count in purged.items():
+        if count:
+            _PURGED.labels(reason=reason).inc(count)
+    if any(purged.values()):
+        logger.info("Purged stored conversation turns", extra={"purged": purged})
+    return purged
+
+
+def sweep_stores(recordings: bool = RECORD_ARTIFACTS) -> None:
+    """Remove expired recordings and SQLite sessions."""
+    if recordings:
+        recorder.sweep()
+    store = session_store()
+    if isinstance(store, SQLiteSessionStore):
+        store.prune()
+
+
+class RetentionSweeper:
+    """Periodic purge inside the server process; off unless something is stored and the interval is set."""
+
+    def __init__(
+        self,
+        interval_seconds: int = CONVERSATION_PURGE_INTERVAL_SECONDS,
+        enabled: bool = STORE_CONVERSATIONS,
+        recordings: bool = RECORD_ARTIFACTS,
+    ):
+        self._interval = interval_seconds
+        self._enabled = enabled
+        self._recordings = recordings
+        self._task: Optional[asyncio.Task] = None
+
+    async def start(self) -> None:
+        if self._task is not None or self._interval <= 0:
+            return
+        self._task = asyncio.create_task(self._run())
+
+    async def stop(self) -> None:
+        if self._task is None:
+            return
+        self._task.cancel()
+        await asyncio.gather(self._task, return_exceptions=True)
+        self._task = None
+
+    async def _run(self) -> None:
+        while True:
+            await asyncio.sleep(self._interval)
+            if self._enabled:
+                try:
+                    await asyncio.to_thread(purge_once)
+                except Exception as exc:
+                    logger.warning("Conversation purge failed: %s", exc)
+            try:
+                await asyncio.to_thread(sweep_stores, self._recordings)
+            except Exception as exc:
+                logger.warning("Recording and session sweep failed: %s", exc)
+
+
+retention_sweeper = RetentionSweeper()
+
+
+if __name__ == "__main__":
+    purged = purge_once()
+    sweep_stores()
+    print(f"Purged {purged['expired']} expired and {purged['deleted']} deleted turns")
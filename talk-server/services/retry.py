@@ -5,6 +5,7 @@ import httpx
 from fastapi import HTTPException
 
 from config import MAX_RETRIES, logger
+from services.deadline import remaining
 
 T = TypeVar("T")
 
@@ -21,6 +22,9 @@ async def retry_async(coro_fn: Callable[..., T], max_retries: int = MAX_RETRIES)
             last_err = e
             if attempt < max_retries:
                 delay = 2**attempt
+                left = remaining()
+                if left is not None and left <= delay:
+                    break
                 logger.warning(f"Retry {attempt + 1}/{max_retries} after {delay}s: {e}")
                 await asyncio.sleep(delay)
     raise last_err
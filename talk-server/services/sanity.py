@@ -0,0 +1,68 @@
+"""Sanity checks on backend output, so junk is retried or reported instead of delivered.
+
+A transcript without a letter or digit (only punctuation or symbols) means ASR made nothing
+of the audio; a conversational reply that only repeats the user's words or the system prompt
+is an LLM glitch; TTS audio shorter than DWANI_MIN_TTS_AUDIO_MS is a failed synthesis. The
+LLM and TTS are asked again up to DWANI_GARBAGE_RETRIES times, since another try usually
+differs; a transcript without words fails at once, as the same audio would give the same
+result. An empty LLM reply is asked for again DWANI_LLM_EMPTY_RETRIES times instead, with a
+nudge to answer (services/chat_svc.py). Every failure is a stage error whose details.reason says which check failed, and
+is counted in dwani_upstream_garbage_total.
+"""
+import unicodedata
+from typing import Optional
+
+from prometheus_client import Counter
+
+from config import MIN_TTS_AUDIO_MS
+from services.audio_format import detect_format, mp3_duration
+from services.errors import StageError
+
+# Short input is often echoed legitimately ("Hello" -> "Hello!").
+_ECHO_MIN_WORDS = 4
+_SYSTEM_ECHO_CHARS = 80
+# TTS audio is held back this long at most to see whether the whole body is that short.
+SNIFF_BYTES = 4096
+
+_GARBAGE = Counter("dwani_upstream_garbage_total", "Backend outputs rejected by sanity checks", ["stage", "reason"])
+
+
+def garbage(stage: str, reason: str, detail: str, status_code: int = 502) -> StageError:
+    _GARBAGE.labels(stage=stage, reason=reason).inc()
+    return StageError(stage, status_code=status_code, detail=detail, details={"reason": reason})
+
+
+def has_words(text: str) -> bool:
+    return any(unicodedata.category(ch)[0] in "LN" for ch in text)
+
+
+def _normalized(text: str) -> str:
+    kept = "".join(ch if unicodedata.category(ch)[0] not in "PS" else " " for ch in text.casefold())
+    return " ".join(kept.split())
+
+
+def echoes_prompt(reply: str, user_text: str, system_prompt: Optional[str] = None) -> bool:
+    """True when the reply is the user's text again, or recites the start of the system prompt."""
+    reply_norm = _normalized(reply)
+    user_norm = _normalized(user_text)
+    if reply_norm and reply_norm == user_norm and len(user_norm.split()) >= _ECHO_MIN_WORDS:
+        return True
+    system_norm = _normalized(system_prompt or "")[:_SYSTEM_ECHO_CHARS]
+    return len(system_norm) == _SYSTEM_ECHO_CHARS and system_norm in reply_norm
+
+
+def short_audio_seconds(audio: bytes) -> Optional[float]:
+    """Length of a complete, small TTS body (MP3 frames, or WAV with a 44-byte header); None if it cannot be told."""
+    audio_format = detect_format(None, audio[:12])
+    if audio_format == "mp3":
+        return mp3_duration(audio)
+    if audio_format == "wav" and len(audio) >= 44:
+        # The header's data size is often 0 or a placeholder in streamed WAV, so count the bytes.
+        byte_rate = int.from_bytes(audio[28:32], "little")
+        return (len(audio) - 44) / byte_rate if byte_rate else None
+    return None
+
+
+def audio_too_short(audio: bytes) -> bool:
+    seconds = short_audio_seconds(audio)
+    return seconds is not None and seconds * 1000 < MIN_TTS_AUDIO_MS
@@ -1,24 +1,51 @@
+"""Conversation history and per-session settings behind a pluggable store.
+
+DWANI_SESSION_STORE selects the backend:
+
+- redis (the default): DWANI_REDIS_URL, or the DWANI_REDIS_SHARD_URLS ring, shared by every
+  gateway replica; falls back to process memory when Redis is not configured or unavailable.
+- sqlite: DWANI_SESSION_SQLITE_PATH, so sessions survive restarts of a single-host deployment.
+- memory: process memory only.
+- "module:Class" (or module:factory): any SessionStore implementation.
+
+Entries expire DWANI_SESSION_TTL_SECONDS after their last write in every backend but memory;
+delete_session() erases one at once (DELETE /v1/sessions/{id}, services/erasure.py).
+
+Session IDs are the client's, so two tenants (services/tenants.py) may pick the same one. The
+functions below hand stores tenant_session_id(), the ID within the caller's tenant, and
+session_digest() covers it too, so a tenant's conversations and erasures never reach another's.
+"""
 import hashlib
+import importlib
 import json
 import os
+import sqlite3
+import threading
+import time
 from typing import Dict, List, Optional
 
-from config import SESSION_CONTEXT_LIMIT, SESSION_MAX_HISTORY
+from config import SESSION_CONTEXT_LIMIT, SESSION_MAX_HISTORY, SESSION_SQLITE_PATH, SESSION_STORE
 from config import logger
+from services.sharding import REDIS_SHARD_RING
+from services.tenants import current_tenant_name
 
 try:
     import redis
 except Exception:  # pragma: no cover - optional dependency at runtime
     redis = None
 
-_session_store: Dict[str, List[Dict[str, str]]] = {}
+History = List[Dict[str, str]]
+
+_session_store: Dict[str, History] = {}
 _session_order: List[str] = []
+_session_settings: Dict[str, Dict[str, str]] = {}
 _MAX_SESSIONS = 5000
-_REDIS_TTL_SECONDS = int(os.getenv("DWANI_SESSION_TTL_SECONDS", "86400"))
+_SESSION_TTL_SECONDS = int(os.getenv("DWANI_SESSION_TTL_SECONDS", "86400"))
 _REDIS_CLIENT: Optional["redis.Redis"] = None
+_REDIS_SHARD_CLIENTS: Dict[str, "redis.Redis"] = {}
 
 
-def _redis_client() -> Optional["redis.Redis"]:
+def redis_client() -> Optional["redis.Redis"]:
     global _REDIS_CLIENT
     if _REDIS_CLIENT is not None:
         return _REDIS_CLIENT
@@ -35,70 +62,372 @@ def _redis_client() -> Optional["redis.Redis"]:
         return None
 
 
+def _session_redis(session_id: str) -> Optional["redis.Redis"]:
+    """Shard chosen by consistent hashing when DWANI_REDIS_SHARD_URLS is set, else the shared client."""
+    url = REDIS_SHARD_RING.owner(session_id)
+    if url is None or redis is None:
+        return redis_client()
+    client = _REDIS_SHARD_CLIENTS.get(url)
+    if client is None:
+        try:
+            client = redis.Redis.from_url(url, decode_responses=True)
+        except Exception as exc:
+            logger.warning("Failed to initialize Redis shard client: %s", exc)
+            return None
+        _REDIS_SHARD_CLIENTS[url] = client
+    return client
+
+
+def tenant_session_id(session_id: str) -> str:
+    """session_id within the current tenant; the deployment's own sessions keep their IDs as they are."""
+    # NUL cannot occur in an X-Session-ID header; dropping it everywhere keeps "<tenant>\0<id>" unambiguous.
+    session_id = session_id.replace("\0", "")
+    tenant = current_tenant_name()
+    return f"{tenant}\0{session_id}" if tenant else session_id
+
+
+def _digest(store_id: str) -> str:
+    # Avoid raw session IDs in Redis keys, logs and stored conversations.
+    return hashlib.sha256(store_id.encode("utf-8")).hexdigest()[:24]
+
+
+def session_digest(session_id: str) -> str:
+    """The session's key in the current tenant, as Redis, SQLite and stored conversations use it."""
+    return _digest(tenant_session_id(session_id))
+
+
 def _session_key(session_id: str) -> str:
-    # Avoid raw session IDs in Redis keys/logs.
-    digest = hashlib.sha256(session_id.encode("utf-8")).hexdigest()[:24]
-    return f"dwani:session:{digest}"
+    return f"dwani:session:{_digest(session_id)}"
 
 
-def _load_redis_history(session_id: str) -> Optional[List[Dict[str, str]]]:
-    client = _redis_client()
-    if client is None:
+def _settings_key(session_id: str) -> str:
+    return f"dwani:session-settings:{_digest(session_id)}"
+
+
+class SessionStore:
+    """Where history and settings live; subclasses implement these four methods (and replace_last_turn, optionally).
+
+    The session_id they get is tenant_session_id()'s, so stores need not know about tenants.
+    """
+
+    name = "custom"
+
+    def load_history(self, session_id: str) -> History:
+        raise NotImplementedError
+
+    def append_turn(self, session_id: str, user: str, assistant: str) -> None:
+        raise NotImplementedError
+
+    def replace_last_turn(self, session_id: str, user: str, assistant: str) -> None:
+        """Replace the latest exchange (a corrected transcript's); stores without it append instead."""
+        raise NotImplementedError
+
+    def get_settings(self, session_id: str) -> Dict[str, str]:
+        raise NotImplementedError
+
+    def update_settings(self, session_id: str, values: Dict[str, str]) -> None:
+        raise NotImplementedError
+
+    def delete_session(self, session_id: str) -> bool:
+        """Erase the session's history and settings; whether there was any."""
+        raise NotImplementedError
+
+
+def without_last_turn(history: History) -> History:
+    """history without its latest user/assistant exchange, if it ends with one."""
+    if len(history) >= 2 and [m.get("role") for m in history[-2:]] == ["user", "assistant"]:
+        return history[:-2]
+    return history
+
+
+def _with_turn(history: History, user: str, assistant: str, replace_last: bool = False) -> History:
+    if replace_last:
+        history = without_last_turn(history)
+    # When the exchange happened, for the transcript (GET /v1/sessions/{id}/transcript); not sent to the LLM.
+    at = time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime())
+    history = history + [{"role": "user", "content": user, "at": at}, {"role": "assistant", "content": assistant, "at": at}]
+    return history[-SESSION_MAX_HISTORY:]
+
+
+class MemorySessionStore(SessionStore):
+    """Process memory, at most _MAX_SESSIONS sessions; lost on restart and not shared."""
+
+    name = "memory"
+
+    def load_history(self, session_id: str) -> History:
+        return list(_session_store.get(session_id, []))
+
+    def append_turn(self, session_id: str, user: str, assistant: str, replace_last: bool = False) -> None:
+        if session_id not in _session_store:
+            _session_order.append(session_id)
+            while len(_session_store) >= _MAX_SESSIONS and _session_order:
+                _session_store.pop(_session_order.pop(0), None)
+        _session_store[session_id] = _with_turn(_session_store.get(session_id, []), user, assistant, replace_last)
+
+    def replace_last_turn(self, session_id: str, user: str, assistant: str) -> None:
+        self.append_turn(session_id, user, assistant, replace_last=True)
+
+    def get_settings(self, session_id: str) -> Dict[str, str]:
+        return dict(_session_settings.get(session_id, {}))
+
+    def update_settings(self, session_id: str, values: Dict[str, str]) -> None:
+        if session_id not in _session_settings and len(_session_settings) >= _MAX_SESSIONS:
+            _session_settings.pop(next(iter(_session_settings)))
+        _session_settings.setdefault(session_id, {}).update(values)
+
+    def delete_session(self, session_id: str) -> bool:
+        if session_id in _session_order:
+            _session_order.remove(session_id)
+        had_history = _session_store.pop(session_id, None) is not None
+        return _session_settings.pop(session_id, None) is not None or had_history
+
+
+class RedisSessionStore(SessionStore):
+    """Redis (sharded per session when configured), falling back to memory if it is unreachable."""
+
+    name = "redis"
+
+    def __init__(self, fallback: Optional[SessionStore] = None):
+        self.fallback = fallback or MemorySessionStore()
+
+    def _history(self, client: "redis.Redis", session_id: str) -> Optional[History]:
+        try:
+            payload = client.get(_session_key(session_id))
+            if not payload:
+                return []
+            parsed = json.loads(payload)
+            if isinstance(parsed, list):
+                return parsed
+        except Exception as exc:
+            logger.warning("Redis session read failed; falling back to memory: %s", exc)
         return None
-    try:
-        payload = client.get(_session_key(session_id))
-        if not payload:
-            return []
-        parsed = json.loads(payload)
-        if isinstance(parsed, list):
-            return parsed
-    except Exception as exc:
-        logger.warning("Redis session read failed; falling back to memory: %s", exc)
-    return None
 
+    def load_history(self, session_id: str) -> History:
+        client = _session_redis(session_id)
+        history = self._history(client, session_id) if client is not None else None
+        return self.fallback.load_history(session_id) if history is None else history
 
-def _save_redis_history(session_id: str, history: List[Dict[str, str]]) -> bool:
-    client = _redis_client()
-    if client is None:
-        return False
-    try:
-        client.setex(_session_key(session_id), _REDIS_TTL_SECONDS, json.dumps(history))
-        return True
-    except Exception as exc:
-        logger.warning("Redis session write failed; falling back to memory: %s", exc)
-        return False
+    def append_turn(self, session_id: str, user: str, assistant: str, replace_last: bool = False) -> None:
+        client = _session_redis(session_id)
+        history = self._history(client, session_id) if client is not None else None
+        if history is not None:
+            try:
+                payload = json.dumps(_with_turn(history, user, assistant, replace_last))
+                client.setex(_session_key(session_id), _SESSION_TTL_SECONDS, payload)
+                return
+            except Exception as exc:
+                logger.warning("Redis session write failed; falling back to memory: %s", exc)
+        if replace_last:
+            self.fallback.replace_last_turn(session_id, user, assistant)
+        else:
+            self.fallback.append_turn(session_id, user, assistant)
+
+    def replace_last_turn(self, session_id: str, user: str, assistant: str) -> None:
+        self.append_turn(session_id, user, assistant, replace_last=True)
+
+    def get_settings(self, session_id: str) -> Dict[str, str]:
+        client = _session_redis(session_id)
+        if client is not None:
+            try:
+                return dict(client.hgetall(_settings_key(session_id)))
+            except Exception as exc:
+                logger.warning("Redis session settings read failed; falling back to memory: %s", exc)
+        return self.fallback.get_settings(session_id)
+
+    def update_settings(self, session_id: str, values: Dict[str, str]) -> None:
+        client = _session_redis(session_id)
+        if client is not None:
+            try:
+                key = _settings_key(session_id)
+                pipe = client.pipeline()
+                pipe.hset(key, mapping=values)
+                pipe.expire(key, _SESSION_TTL_SECONDS)
+                pipe.execute()
+                return
+            except Exception as exc:
+                logger.warning("Redis session settings write failed; falling back to memory: %s", exc)
+        self.fallback.update_settings(session_id, values)
+
+    def delete_session(self, session_id: str) -> bool:
+        # Memory may hold what was written while Redis was unreachable.
+        deleted = self.fallback.delete_session(session_id)
+        client = _session_redis(session_id)
+        if client is not None:
+            deleted = client.delete(_session_key(session_id), _settings_key(session_id)) > 0 or deleted
+        return deleted
+
+
+class SQLiteSessionStore(SessionStore):
+    """A local SQLite file: survives restarts, but only replicas sharing the file see it."""
+
+    name = "sqlite"
+
+    def __init__(self, path: str = SESSION_SQLITE_PATH, ttl_seconds: int = _SESSION_TTL_SECONDS):
+        self.path = path
+        self.ttl_seconds = ttl_seconds
+        self._lock = threading.Lock()
+        self._db = sqlite3.connect(path, check_same_thread=False, isolation_level=None)
+        self._db.execute("PRAGMA journal_mode=WAL")
+        self._db.execute(
+            "CREATE TABLE IF NOT EXISTS session_history (key TEXT PRIMARY KEY, history TEXT NOT NULL, expires_at REAL NOT NULL)"
+        )
+        self._db.execute(
+            "CREATE TABLE IF NOT EXISTS session_settings (key TEXT PRIMARY KEY, settings TEXT NOT NULL, expires_at REAL NOT NULL)"
+        )
+        self.prune()
+
+    def prune(self) -> None:
+        """Delete expired sessions (run at startup; reads skip them as well)."""
+        now = time.time()
+        with self._lock:
+            self._db.execute("DELETE FROM session_history WHERE expires_at <= ?", (now,))
+            self._db.execute("DELETE FROM session_settings WHERE expires_at <= ?", (now,))
+
+    def _read(self, table: str, column: str, session_id: str) -> Optional[str]:
+        row = self._db.execute(
+            f"SELECT {column} FROM {table} WHERE key = ? AND expires_at > ?", (_digest(session_id), time.time())
+        ).fetchone()
+        return row[0] if row else None
+
+    def _write(self, table: str, column: str, session_id: str, payload: str) -> None:
+        self._db.execute(
+            f"INSERT INTO {table} (key, {column}, expires_at) VALUES (?, ?, ?) "
+            f"ON CONFLICT(key) DO UPDATE SET {column} = excluded.{column}, expires_at = excluded.expires_at",
+            (_digest(session_id), payload, time.time() + self.ttl_seconds),
+        )
+
+    def load_history(self, session_id: str) -> History:
+        with self._lock:
+            payload = self._read("session_history", "history", session_id)
+        return json.loads(payload) if payload else []
+
+    def append_turn(self, session_id: str, user: str, assistant: str, replace_last: bool = False) -> None:
+        with self._lock:
+            self._db.execute("BEGIN IMMEDIATE")
+            try:
+                payload = self._read("session_history", "history", session_id)
+                history = _with_turn(json.loads(payload) if payload else [], user, assistant, replace_last)
+                self._write("session_history", "history", session_id, json.dumps(history))
+                self._db.execute("COMMIT")
+            except Exception:
+                self._db.execute("ROLLBACK")
+                raise
+
+    def replace_last_turn(self, session_id: str, user: str, assistant: str) -> None:
+        self.append_turn(session_id, user, assistant, replace_last=True)
+
+    def get_settings(self, session_id: str) -> Dict[str, str]:
+        with self._lock:
+            payload = self._read("session_settings", "settings", session_id)
+        return json.loads(payload) if payload else {}
 
+    def update_settings(self, session_id: str, values: Dict[str, str]) -> None:
+        with self._lock:
+            self._db.execute("BEGIN IMMEDIATE")
+            try:
+                payload = self._read("session_settings", "settings", session_id)
+                settings = {**(json.loads(payload) if payload else {}), **values}
+                self._write("session_settings", "settings", session_id, json.dumps(settings))
+                self._db.execute("COMMIT")
+            except Exception:
+                self._db.execute("ROLLBACK")
+                raise
 
-def get_session_context(session_id: str) -> List[Dict[str, str]]:
+    def delete_session(self, session_id: str) -> bool:
+        key = _digest(session_id)
+        with self._lock:
+            deleted = self._db.execute("DELETE FROM session_history WHERE key = ?", (key,)).rowcount
+            deleted += self._db.execute("DELETE FROM session_settings WHERE key = ?", (key,)).rowcount
+        return deleted > 0
+
+    def close(self) -> None:
+        with self._lock:
+            self._db.close()
+
+
+def create_session_store(spec: str = SESSION_STORE) -> SessionStore:
+    """The store named by spec (see the module docstring); ValueError when it is unknown."""
+    spec = (spec or "redis").strip()
+    if spec.lower() == "redis":
+        return RedisSessionStore()
+    if spec.lower() == "sqlite":
+        return SQLiteSessionStore()
+    if spec.lower() == "memory":
+        return MemorySessionStore()
+    module_name, _, attr = spec.partition(":")
+    if not module_name or not attr:
+        raise ValueError(f"DWANI_SESSION_STORE must be redis, sqlite, memory, or module:Class, not {spec!r}")
+    store = getattr(importlib.import_module(module_name), attr)()
+    if not isinstance(store, SessionStore):
+        raise ValueError(f"{spec} did not produce a SessionStore")
+    return store
+
+
+_STORE: Optional[SessionStore] = None
+
+
+def session_store() -> SessionStore:
+    global _STORE
+    if _STORE is None:
+        _STORE = create_session_store()
+        logger.info("Session store: %s", _STORE.name)
+    return _STORE
+
+
+def set_session_store(store: Optional[SessionStore]) -> None:
+    """Use store from now on (None: recreate from DWANI_SESSION_STORE on next use)."""
+    global _STORE
+    _STORE = store
+
+
+def get_session_context(session_id: str) -> History:
+    """The session's latest messages, as the LLM gets them (role and content only)."""
     if not session_id:
         return []
-    redis_history = _load_redis_history(session_id)
-    if redis_history is not None:
-        return redis_history[-SESSION_CONTEXT_LIMIT:]
-    history = _session_store.get(session_id, [])
-    return history[-SESSION_CONTEXT_LIMIT:]
+    history = session_store().load_history(tenant_session_id(session_id))[-SESSION_CONTEXT_LIMIT:]
+    return [{"role": m.get("role", ""), "content": m.get("content", "")} for m in history]
+
+
+def session_transcript(session_id: str) -> List[Dict[str, Optional[str]]]:
+    """The session's exchanges in order: user, assistant and when it happened (null before timestamps were kept)."""
+    turns: List[Dict[str, Optional[str]]] = []
+    for message in session_store().load_history(tenant_session_id(session_id)) if session_id else []:
+        role = message.get("role")
+        if role not in ("user", "assistant"):
+            continue
+        if role == "user" or not turns or turns[-1]["assistant"] is not None:
+            turns.append({"user": None, "assistant": None, "at": message.get("at")})
+        turns[-1][role] = message.get("content", "")
+    return turns
 
 
-def append_to_session(session_id: str, user: str, assistant: str) -> None:
+def append_to_session(session_id: str, user: str, assistant: str, replace_last: bool = False) -> None:
+    """Add an exchange to the history; with replace_last, in place of the latest one (a corrected transcript's)."""
     if not session_id:
         return
-    redis_history = _load_redis_history(session_id)
-    if redis_history is not None:
-        redis_history.append({"role": "user", "content": user})
-        redis_history.append({"role": "assistant", "content": assistant})
-        if len(redis_history) > SESSION_MAX_HISTORY:
-            redis_history = redis_history[-SESSION_MAX_HISTORY:]
-        if _save_redis_history(session_id, redis_history):
+    store, session_id = session_store(), tenant_session_id(session_id)
+    if replace_last:
+        try:
+            store.replace_last_turn(session_id, user, assistant)
             return
+        except NotImplementedError:
+            logger.warning("Session store %s cannot replace turns; appending the corrected one", store.name)
+    store.append_turn(session_id, user, assistant)
+
+
+def get_session_settings(session_id: str) -> Dict[str, str]:
+    """Per-session options (e.g. turn-detection preset); same store and TTL as the history."""
+    if not session_id:
+        return {}
+    return session_store().get_settings(tenant_session_id(session_id))
+
+
+def update_session_settings(session_id: str, values: Dict[str, str]) -> None:
+    if not session_id or not values:
+        return
+    session_store().update_settings(tenant_session_id(session_id), values)
+
 
-    if session_id not in _session_store:
-        _session_store[session_id] = []
-        _session_order.append(session_id)
-        while len(_session_store) > _MAX_SESSIONS and _session_order:
-            old = _session_order.pop(0)
-            _session_store.pop(old, None)
-    history = _session_store[session_id]
-    history.append({"role": "user", "content": user})
-    history.append({"role": "assistant", "content": assistant})
-    if len(history) > SESSION_MAX_HISTORY:
-        _session_store[session_id] = history[-SESSION_MAX_HISTORY:]
+def delete_session(session_id: str) -> bool:
+    """Erase the session's history, slots and settings; NotImplementedError when the store cannot."""
+    return bool(session_id) and session_store().delete_session(tenant_session_id(session_id))
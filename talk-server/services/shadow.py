@@ -0,0 +1,96 @@
+"""Shadow traffic: mirror a share of ASR and TTS calls to a candidate backend, to evaluate it before migrating.
+
+With DWANI_SHADOW_ASR_URL (or DWANI_SHADOW_TTS_URL), DWANI_SHADOW_PERCENT of the
+transcriptions (syntheses) that succeed on the real backend are sent again, in the background,
+to the candidate, with DWANI_SHADOW_ASR_API_KEY (DWANI_SHADOW_TTS_API_KEY) or else the real
+backend's key. Users never wait for it or see its result: the call runs after the real one,
+outside the request's deadline, and is dropped when DWANI_SHADOW_MAX_CONCURRENT are already
+running in this worker. Each one is counted in dwani_shadow_requests_total{stage,outcome} (ok,
+error, skipped), timed in dwani_shadow_seconds{stage} and logged with the request's ID, next to
+what the real backend took; a shadow transcript's similarity to the real one (1 = the same)
+goes to dwani_shadow_asr_agreement.
+"""
+import asyncio
+import random
+import time
+from difflib import SequenceMatcher
+from typing import Any, Awaitable, Callable, Dict, Optional, Set
+
+from prometheus_client import Counter, Histogram
+
+from config import SHADOW_ASR_URL, SHADOW_MAX_CONCURRENT, SHADOW_PERCENT, SHADOW_TTS_URL, logger
+from services import backends
+from services.deadline import set_deadline
+
+REQUESTS = Counter("dwani_shadow_requests_total", "Requests mirrored to a candidate backend, by outcome", ["stage", "outcome"])
+SECONDS = Histogram(
+    "dwani_shadow_seconds",
+    "Time the candidate backend took per mirrored request",
+    ["stage"],
+    buckets=(0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60),
+)
+AGREEMENT = Histogram(
+    "dwani_shadow_asr_agreement",
+    "Similarity of the candidate's transcript to the real one",
+    buckets=(0.5, 0.7, 0.8, 0.9, 0.95, 0.99, 1),
+)
+
+_running: Set["asyncio.Task[None]"] = set()
+
+
+def sampled(stage: str) -> bool:
+    """Whether to mirror this stage's call: a candidate is configured and the call is in the sampled share."""
+    url = SHADOW_ASR_URL if stage == "asr" else SHADOW_TTS_URL
+    return bool(url) and random.random() * 100 < SHADOW_PERCENT
+
+
+def agreement(expected: str, actual: str) -> float:
+    return round(SequenceMatcher(None, " ".join(expected.lower().split()), " ".join(actual.lower().split())).ratio(), 3)
+
+
+def mirror(
+    stage: str, call: Callable[[], Awaitable[Dict[str, Any]]], primary_seconds: Optional[float] = None
+) -> bool:
+    """Run call against the stage's candidate in the background; False when too many are running.
+
+    call makes the stage's request with the backends of the moment (so the candidate's) and
+    returns fields to log about the result. It must not use anything the request closes.
+    """
+    if len(_running) >= SHADOW_MAX_CONCURRENT:
+        REQUESTS.labels(stage, "skipped").inc()
+        return False
+    task = asyncio.get_running_loop().create_task(_run(stage, call, primary_seconds))
+    _running.add(task)
+    task.add_done_callback(_running.discard)
+    return True
+
+
+async def _run(stage: str, call: Callable[[], Awaitable[Dict[str, Any]]], primary_seconds: Optional[float]) -> None:
+    # The task has a copy of the request's context: free it from the request's deadline.
+    set_deadline(None)
+    started = time.monotonic()
+    fields: Dict[str, Any] = {}
+    with backends.use_shadow_backend(stage):
+        try:
+            fields = await call()
+            outcome = "ok"
+        except Exception as exc:
+            outcome = "error"
+            fields = {"shadow_error": str(getattr(exc, "detail", "") or exc) or type(exc).__name__}
+    seconds = time.monotonic() - started
+    REQUESTS.labels(stage, outcome).inc()
+    SECONDS.labels(stage).observe(seconds)
+    if "shadow_agreement" in fields:
+        AGREEMENT.observe(fields["shadow_agreement"])
+    logger.info(
+        "Shadow %s request %s",
+        stage.upper(),
+        outcome,
+        extra={
+            "shadow_stage": stage,
+            "shadow_outcome": outcome,
+            "shadow_seconds": round(seconds, 3),
+            **({"primary_seconds": round(primary_seconds, 3)} if primary_seconds is not None else {}),
+            **fields,
+        },
+    )
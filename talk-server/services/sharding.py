@@ -0,0 +1,160 @@
+"""Consistent-hash ownership of sessions across Redis shards and gateway peers.
+
+DWANI_REDIS_SHARD_URLS spreads session keys over several Redis instances.
+DWANI_PEERS (base URLs of every replica, including this one) with DWANI_SELF_URL makes
+each session owned by one replica; requests for a session owned elsewhere are
+forwarded there, so in-memory session state stays consistent without Redis.
+
+A forwarded request carries X-Dwani-Forwarded-By with an HMAC of its method, path, sender and
+time under DWANI_PEER_SECRET, shared by all replicas. Only a hop whose signature checks out (and
+is under a minute old) is served as forwarded: not forwarded again, and not limited a second time.
+Without the secret, forwarding still works but every hop is treated as a client request. The
+request body is streamed to the owner as it arrives.
+"""
+import bisect
+import hashlib
+import hmac
+import os
+import time
+from typing import Dict, Iterable, List, Optional, Tuple
+
+from fastapi import Request
+from fastapi.responses import StreamingResponse
+from starlette.background import BackgroundTask
+
+from config import LLM_TIMEOUT, logger
+from services.credentials import secret
+from services.deadline import HEADER as DEADLINE_HEADER, remaining
+from services.http_client import http_client
+
+FORWARDED_HEADER = "X-Dwani-Forwarded-By"
+FORWARDED_AT_HEADER = "X-Dwani-Forwarded-At"
+SIGNATURE_HEADER = "X-Dwani-Forwarded-Signature"
+_HOP_HEADERS = {FORWARDED_HEADER.lower(), FORWARDED_AT_HEADER.lower(), SIGNATURE_HEADER.lower()}
+_MAX_HOP_AGE_SECONDS = 60
+_VIRTUAL_NODES = 128
+_HOP_BY_HOP = {
+    "connection",
+    "keep-alive",
+    "proxy-authenticate",
+    "proxy-authorization",
+    "te",
+    "trailers",
+    "transfer-encoding",
+    "upgrade",
+    "content-length",
+    "host",
+}
+
+
+def _hash(value: str) -> int:
+    return int.from_bytes(hashlib.sha256(value.encode("utf-8")).digest()[:8], "big")
+
+
+class HashRing:
+    def __init__(self, nodes: Iterable[str], virtual_nodes: int = _VIRTUAL_NODES):
+        self.nodes = sorted(set(n for n in nodes if n))
+        ring: List[Tuple[int, str]] = []
+        for node in self.nodes:
+            for i in range(virtual_nodes):
+                ring.append((_hash(f"{node}#{i}"), node))
+        ring.sort()
+        self._keys = [k for k, _ in ring]
+        self._owners = [n for _, n in ring]
+
+    def owner(self, key: str) -> Optional[str]:
+        if not self._keys:
+            return None
+        idx = bisect.bisect(self._keys, _hash(key)) % len(self._keys)
+        return self._owners[idx]
+
+
+def _split_urls(value: str) -> List[str]:
+    return [u.strip().rstrip("/") for u in value.split(",") if u.strip()]
+
+
+SELF_URL = os.getenv("DWANI_SELF_URL", "").strip().rstrip("/")
+PEER_RING = HashRing(_split_urls(os.getenv("DWANI_PEERS", "")))
+REDIS_SHARD_RING = HashRing(_split_urls(os.getenv("DWANI_REDIS_SHARD_URLS", "")))
+if len(PEER_RING.nodes) > 1 and not secret("DWANI_PEER_SECRET"):
+    logger.warning("DWANI_PEERS is set without DWANI_PEER_SECRET: forwarded requests are rate limited again by their owner")
+
+
+def _hop_signature(key: str, method: str, path: str, sender: str, at: str) -> str:
+    message = "\n".join((method.upper(), path, sender, at)).encode("utf-8")
+    return hmac.new(key.encode("utf-8"), message, hashlib.sha256).hexdigest()
+
+
+def forwarded_by_peer(request: Request) -> bool:
+    """Whether a configured peer signed this request's hop with DWANI_PEER_SECRET, recently."""
+    key = secret("DWANI_PEER_SECRET")
+    sender = request.headers.get(FORWARDED_HEADER, "")
+    at = request.headers.get(FORWARDED_AT_HEADER, "")
+    if not key or sender not in PEER_RING.nodes or not at.isdigit():
+        return False
+    if abs(time.time() - int(at)) > _MAX_HOP_AGE_SECONDS:
+        return False
+    expected = _hop_signature(key, request.method, request.url.path, sender, at)
+    return hmac.compare_digest(expected, request.headers.get(SIGNATURE_HEADER, ""))
+
+
+class ForwardError(Exception):
+    """Forwarding failed; replayable when the owner got none of the body, so the request can be served here."""
+
+    def __init__(self, detail: str, replayable: bool):
+        super().__init__(detail)
+        self.replayable = replayable
+
+
+def session_owner(session_id: str) -> Optional[str]:
+    """Peer base URL that owns the session, or None when it is this replica (or peering is off)."""
+    if not SELF_URL or len(PEER_RING.nodes) < 2:
+        return None
+    owner = PEER_RING.owner(session_id)
+    return None if owner == SELF_URL else owner
+
+
+async def forward_request(request: Request, peer: str) -> StreamingResponse:
+    """Replay the request against the owning peer and stream its response back."""
+    url = f"{peer}{request.url.path}"
+    if request.url.query:
+        url = f"{url}?{request.url.query}"
+    excluded = _HOP_BY_HOP | _HOP_HEADERS
+    headers: Dict[str, str] = {k: v for k, v in request.headers.items() if k.lower() not in excluded}
+    headers[FORWARDED_HEADER] = SELF_URL
+    key = secret("DWANI_PEER_SECRET")
+    if key:
+        at = str(int(time.time()))
+        headers[FORWARDED_AT_HEADER] = at
+        headers[SIGNATURE_HEADER] = _hop_signature(key, request.method, request.url.path, SELF_URL, at)
+    left = remaining()
+    if left is not None:
+        headers.pop(DEADLINE_HEADER.lower(), None)
+        headers[DEADLINE_HEADER] = str(max(1, round(left * 1000)))
+    request_id = getattr(request.state, "request_id", None)
+    if request_id:
+        headers.pop("x-request-id", None)
+        headers["X-Request-ID"] = request_id
+    started = []  # once the body is being read, it cannot be read again to serve the request here
+
+    async def body():
+        started.append(True)
+        async for chunk in request.stream():
+            yield chunk
+
+    client = http_client()
+    try:
+        upstream = await client.send(
+            client.build_request(request.method, url, headers=headers, content=body(), timeout=LLM_TIMEOUT),
+            stream=True,
+        )
+    except Exception as exc:
+        raise ForwardError(f"{type(exc).__name__}: {exc}", replayable=not started)
+
+    logger.debug("Forwarded session request to owner", extra={"peer": peer, "path": request.url.path})
+    return StreamingResponse(
+        upstream.aiter_raw(),
+        status_code=upstream.status_code,
+        headers={k: v for k, v in upstream.headers.items() if k.lower() not in _HOP_BY_HOP},
+        background=BackgroundTask(upstream.aclose),
+    )
@@ -0,0 +1,92 @@
+"""Signed, expiring share links to a stored conversation (DWANI_STORE_CONVERSATIONS=1).
+
+A link carries its own claims (the conversation's session key, expiry, whether it needs a
+passcode) signed with HMAC-SHA256 under DWANI_SHARE_LINK_SECRET, so no server state is
+kept and replicas sharing the secret accept each other's links. A passcode is mixed into
+the signature rather than stored, so the link alone cannot be brute-forced offline. Once
+the passcode is entered the page uses a short-lived unlocked token for its audio requests.
+"""
+import base64
+import hashlib
+import hmac
+import json
+import time
+from dataclasses import dataclass
+from typing import Optional, Tuple
+
+from fastapi import HTTPException
+
+from config import SHARE_LINK_MAX_TTL_SECONDS, SHARE_LINK_UNLOCK_SECONDS
+from services.credentials import signing_secret
+
+
+@dataclass(frozen=True)
+class ShareClaims:
+    session_key: str
+    expires_at: int  # Unix seconds
+    passcode: bool = False  # the signature also covers a passcode the viewer must enter
+
+    @property
+    def expired(self) -> bool:
+        return self.expires_at <= time.time()
+
+
+def _b64(data: bytes) -> str:
+    return base64.urlsafe_b64encode(data).rstrip(b"=").decode("ascii")
+
+
+def _unb64(text: str) -> bytes:
+    return base64.urlsafe_b64decode(text + "=" * (-len(text) % 4))
+
+
+def _signature(body: str, passcode: Optional[str]) -> str:
+    message = body.encode("ascii") + b"." + (passcode or "").encode("utf-8")
+    return _b64(hmac.new(signing_secret("DWANI_SHARE_LINK_SECRET", "share links"), message, hashlib.sha256).digest())
+
+
+def sign(claims: ShareClaims, passcode: Optional[str] = None) -> str:
+    payload = {"c": claims.session_key, "exp": claims.expires_at}
+    if claims.passcode:
+        payload["pw"] = 1
+    body = _b64(json.dumps(payload, separators=(",", ":")).encode("utf-8"))
+    return f"{body}.{_signature(body, passcode if claims.passcode else None)}"
+
+
+def create_link(session_key: str, ttl_seconds: int, passcode: Optional[str] = None) -> Tuple[str, ShareClaims]:
+    """A token for session_key valid for ttl_seconds (capped at DWANI_SHARE_LINK_MAX_TTL_SECONDS)."""
+    ttl_seconds = max(1, min(ttl_seconds, SHARE_LINK_MAX_TTL_SECONDS))
+    claims = ShareClaims(session_key, int(time.time()) + ttl_seconds, passcode=bool(passcode))
+    return sign(claims, passcode), claims
+
+
+def read_claims(token: str) -> ShareClaims:
+    """Claims of token without checking its signature; 404 when it is malformed."""
+    try:
+        body = token.split(".", 1)[0]
+        payload = json.loads(_unb64(body))
+        return ShareClaims(str(payload["c"]), int(payload["exp"]), passcode=bool(payload.get("pw")))
+    except (ValueError, KeyError, TypeError):
+        raise HTTPException(status_code=404, detail="Share link not found")
+
+
+def verify(token: str, passcode: Optional[str] = None) -> ShareClaims:
+    """Claims of a valid token; 404 if forged or malformed, 410 if expired, 401 for a wrong passcode."""
+    claims = read_claims(token)
+    body, _, signature = token.partition(".")
+    if claims.passcode and not passcode:
+        raise HTTPException(status_code=401, detail="This share link needs a passcode")
+    expected = _signature(body, passcode if claims.passcode else None)
+    if not hmac.compare_digest(signature.encode("utf-8"), expected.encode("ascii")):
+        if claims.passcode:
+            raise HTTPException(status_code=401, detail="Wrong passcode")
+        raise HTTPException(status_code=404, detail="Share link not found")
+    if claims.expired:
+        raise HTTPException(status_code=410, detail="Share link has expired")
+    return claims
+
+
+def unlock(token: str, passcode: str) -> Tuple[str, ShareClaims]:
+    """A passcode-free token for the same conversation, valid for DWANI_SHARE_LINK_UNLOCK_SECONDS at most."""
+    claims = verify(token, passcode)
+    unlocked = ShareClaims(claims.session_key, min(claims.expires_at, int(time.time()) + SHARE_LINK_UNLOCK_SECONDS))
+    return sign(unlocked), unlocked
@@ -0,0 +1,85 @@
+"""Per-session variables ("slots") that multi-turn tasks fill in across turns.
+
+A booking flow collects party_size on one turn and time on the next; both stay with the
+session until they are overwritten or cleared. Slots are set through
+PATCH /v1/session/slots, by agents (a "slots" object in their reply), by grammars with a
+"slot" name (the matched value), and by pipeline hooks (ctx.slots). The LLM sees them in its
+system prompt, where templates can reference {slots} or {slots.<name>} (services/prompts.py).
+Values are short strings, numbers, or booleans; setting one to null removes it.
+"""
+import json
+import re
+from typing import Any, Dict, Mapping, Optional
+
+from fastapi import HTTPException
+
+from config import SESSION_SLOT_VALUE_MAX_CHARS, SESSION_SLOTS_MAX, logger
+from services.session import get_session_settings, update_session_settings
+
+_SETTING = "slots"
+SLOT_NAME = re.compile(r"^[A-Za-z_][A-Za-z0-9_]{0,63}$")
+SlotValue = Any  # str, int, float, or bool
+
+
+def _check_value(name: str, value: Any) -> SlotValue:
+    if not SLOT_NAME.match(name):
+        raise ValueError(f"slot name {name!r} must be a letter or underscore followed by letters, digits, or underscores")
+    if isinstance(value, str):
+        value = value.strip()
+        if len(value) > SESSION_SLOT_VALUE_MAX_CHARS:
+            raise ValueError(f"slot {name!r} must be at most {SESSION_SLOT_VALUE_MAX_CHARS} characters")
+    elif not isinstance(value, (bool, int, float)):
+        raise ValueError(f"slot {name!r} must be a string, number, or boolean")
+    return value
+
+
+def merge(slots: Dict[str, SlotValue], updates: Mapping[str, Any]) -> Dict[str, SlotValue]:
+    """slots with updates applied (None removes a slot); ValueError for bad names, values, or too many."""
+    merged = dict(slots)
+    for name, value in updates.items():
+        if value is None:
+            merged.pop(name, None)
+        else:
+            merged[name] = _check_value(str(name), value)
+    if len(merged) > SESSION_SLOTS_MAX:
+        raise ValueError(f"a session holds at most {SESSION_SLOTS_MAX} slots")
+    return merged
+
+
+def get_slots(session_id: Optional[str]) -> Dict[str, SlotValue]:
+    if not session_id:
+        return {}
+    raw = get_session_settings(session_id).get(_SETTING)
+    if not raw:
+        return {}
+    try:
+        slots = json.loads(raw)
+    except json.JSONDecodeError:
+        return {}
+    return slots if isinstance(slots, dict) else {}
+
+
+def save_slots(session_id: Optional[str], slots: Dict[str, SlotValue]) -> None:
+    if session_id:
+        update_session_settings(session_id, {_SETTING: json.dumps(slots, ensure_ascii=False) if slots else ""})
+
+
+def update_slots(session_id: str, updates: Mapping[str, Any]) -> Dict[str, SlotValue]:
+    """Apply updates to the session's slots; 422 when they are invalid."""
+    try:
+        slots = merge(get_slots(session_id), updates)
+    except ValueError as exc:
+        raise HTTPException(status_code=422, detail=str(exc))
+    save_slots(session_id, slots)
+    return slots
+
+
+def apply_turn_updates(slots: Dict[str, SlotValue], updates: Any, source: str) -> Dict[str, SlotValue]:
+    """slots with updates from an agent or grammar; invalid ones are logged and dropped."""
+    if not isinstance(updates, dict) or not updates:
+        return slots
+    try:
+        return merge(slots, updates)
+    except ValueError as exc:
+        logger.warning("Ignoring slot updates from %s: %s", source, exc)
+        return slots
@@ -0,0 +1,109 @@
+"""Why ASR heard no speech in an upload, so client apps can coach the user instead of showing a generic 400.
+
+The no_speech_detected error's details say what was wrong with the recording, when something was:
+
+    too_short   shorter than DWANI_NO_SPEECH_MIN_SECONDS (the button was let go too soon)
+    silent      digital silence throughout (a muted or disconnected microphone)
+    too_quiet   no 20 ms louder than DWANI_NO_SPEECH_QUIET_DBFS (the user was far from the microphone)
+    clipped     at least DWANI_NO_SPEECH_CLIPPED_PERCENT of samples at full scale (too close or too loud)
+
+details.hints lists them, worst first, next to duration_seconds, level_dbfs (the loudest 20 ms)
+and clipped_percent; an empty list means the audio looked usable. Each no-speech upload is
+counted in dwani_no_speech_total{hint} by its first hint ("none" without one). WAV is read as it
+is; other formats are converted with ffmpeg first, and when that fails nothing is reported.
+"""
+import array
+import asyncio
+import io
+import sys
+import wave
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Optional
+
+from fastapi import UploadFile
+from prometheus_client import Counter
+
+from config import NO_SPEECH_CLIPPED_PERCENT, NO_SPEECH_MIN_SECONDS, NO_SPEECH_QUIET_DBFS
+from services.audio_format import detect_format, transcode
+from services.errors import RequestError, StageError
+from services.turn_detection import frame_dbfs
+
+HINTS = ("silent", "too_short", "too_quiet", "clipped")
+_FRAME_MS = 20
+
+_NO_SPEECH = Counter("dwani_no_speech_total", "Uploads ASR heard no speech in, by the first hint about the audio", ["hint"])
+
+
+@dataclass
+class AudioCheck:
+    duration_seconds: Optional[float] = None
+    level_dbfs: Optional[float] = None
+    clipped_percent: Optional[float] = None
+    hints: List[str] = field(default_factory=list)
+
+    def as_details(self) -> Dict[str, Any]:
+        return {
+            "hints": list(self.hints),
+            "duration_seconds": self.duration_seconds,
+            "level_dbfs": self.level_dbfs,
+            "clipped_percent": self.clipped_percent,
+        }
+
+
+def check_wav(data: bytes) -> Optional[AudioCheck]:
+    """Length, level and clipping of 16-bit PCM WAV, with the hints they give; None for other audio."""
+    try:
+        with wave.open(io.BytesIO(data)) as reader:
+            channels, width, rate = reader.getnchannels(), reader.getsampwidth(), reader.getframerate()
+            frames = reader.readframes(reader.getnframes())
+    except (wave.Error, EOFError):
+        return None
+    if width != 2 or not rate or not channels:
+        return None
+    samples = array.array("h", frames[: len(frames) // 2 * 2])
+    if sys.byteorder == "big":
+        samples.byteswap()
+    duration = len(samples) / channels / rate
+    window = max(1, rate * _FRAME_MS // 1000) * channels * width
+    loudest = max((frame_dbfs(frames[start:start + window]) for start in range(0, len(frames), window)), default=float("-inf"))
+    clipped = 100 * sum(1 for s in samples if s >= 32767 or s <= -32768) / len(samples) if samples else 0.0
+    check = AudioCheck(
+        duration_seconds=round(duration, 3),
+        level_dbfs=round(loudest, 1) if loudest > float("-inf") else None,
+        clipped_percent=round(clipped, 2),
+    )
+    found = {
+        "silent": check.level_dbfs is None,
+        "too_short": duration < NO_SPEECH_MIN_SECONDS,
+        "too_quiet": check.level_dbfs is not None and check.level_dbfs < NO_SPEECH_QUIET_DBFS,
+        "clipped": bool(samples) and clipped >= NO_SPEECH_CLIPPED_PERCENT,
+    }
+    check.hints = [hint for hint in HINTS if found[hint]]
+    return check
+
+
+async def diagnose(file: UploadFile) -> AudioCheck:
+    """An AudioCheck of the upload; an empty one when its audio cannot be read."""
+    try:
+        file.file.seek(0)
+        data = file.file.read()
+        file.file.seek(0)
+    except (OSError, ValueError):
+        return AudioCheck()
+    if not data:
+        return AudioCheck(duration_seconds=0.0, hints=["too_short"])
+    check = await asyncio.to_thread(check_wav, data) if detect_format(file.content_type, data[:12]) == "wav" else None
+    if check is None:
+        try:
+            converted = await transcode(data, "pcm16")
+        except StageError:
+            return AudioCheck()
+        check = await asyncio.to_thread(check_wav, converted)
+    return check or AudioCheck()
+
+
+async def no_speech_error(file: UploadFile) -> RequestError:
+    """The no_speech_detected error for an upload, with its hints; counted by the first of them."""
+    check = await diagnose(file)
+    _NO_SPEECH.labels(hint=check.hints[0] if check.hints else "none").inc()
+    return RequestError(400, "no_speech_detected", "No speech detected in the audio", details=check.as_details())
@@ -0,0 +1,211 @@
+"""Application-level encryption of the streaming WebSocket (/v1/transcribe/stream).
+
+For deployments that terminate TLS at an edge they do not trust with the audio. The client opens
+the socket with ?encryption=x25519-aes256gcm&public_key=<base64url of a fresh X25519 public key>.
+The server answers, in plain JSON, {"type": "key_exchange", "encryption": ..., "public_key": ...,
+"signature": ..., "signing_key": ...} with its own ephemeral key, and both sides derive two
+AES-256-GCM keys from the X25519 shared secret with HKDF-SHA256 (info "dwani-talk stream v2" +
+client key + server key): the first 32 bytes for client-to-server frames, the next 32 for
+server-to-client.
+
+The edge could answer with a key of its own, so the server signs "dwani-talk stream v2
+key_exchange" + client key + server key with DWANI_STREAM_SIGNING_KEY, a long-term Ed25519 key
+(base64 of its 32-byte seed; or _FILE). Clients pin its public key, which
+`python -m services.stream_crypto` prints along with a new key, and check the signature with
+verify_key_exchange before sending audio. signing_key names the key used, for rotation; it must
+be one the client pinned, never taken from the message alone. Without a signing key encrypted
+sessions are refused.
+
+After that every binary message is an encrypted frame: an 8-byte big-endian sequence number
+followed by the AES-GCM ciphertext and tag. The nonce is 4 bytes of direction (0 client to
+server, 1 server to client) and the sequence number, which each side starts at 0 and must
+increase, so a replayed or reordered frame is refused. A client frame's plaintext is a kind
+byte, 0 for audio or 1 for a JSON control (flush, end), then the content; every server message
+after key_exchange is JSON in a frame. Plain text controls are refused once a session is
+encrypted, so the edge cannot end or cut an utterance. DWANI_STREAM_ENCRYPTION: "optional"
+(default) lets the client choose, "required" refuses unencrypted sessions, "off" refuses
+encrypted ones.
+
+SessionCipher and verify_key_exchange are the client's half too (see tests/test_stream_crypto.py).
+"""
+import base64
+import binascii
+import json
+import struct
+from typing import Any, Dict, Optional, Tuple
+
+from cryptography.exceptions import InvalidSignature, InvalidTag
+from cryptography.hazmat.primitives import hashes
+from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PrivateKey, Ed25519PublicKey
+from cryptography.hazmat.primitives.asymmetric.x25519 import X25519PrivateKey, X25519PublicKey
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from cryptography.hazmat.primitives.kdf.hkdf import HKDF
+from cryptography.hazmat.primitives.serialization import Encoding, NoEncryption, PrivateFormat, PublicFormat
+
+from config import STREAM_ENCRYPTION
+from services.credentials import secret
+
+SCHEME = "x25519-aes256gcm"
+_INFO = b"dwani-talk stream v2"
+_SIGNED = _INFO + b" key_exchange"
+_AUDIO, _CONTROL = 0, 1
+_SEQUENCE = struct.Struct(">Q")
+_DIRECTIONS = {"client": (0, 1), "server": (1, 0)}  # role -> (direction it sends, direction it receives)
+
+
+class FrameError(ValueError):
+    """A frame that does not decrypt, or repeats or goes back in sequence."""
+
+
+def public_bytes(private_key: X25519PrivateKey) -> bytes:
+    return private_key.public_key().public_bytes(Encoding.Raw, PublicFormat.Raw)
+
+
+def encode_key(key: bytes) -> str:
+    return base64.urlsafe_b64encode(key).decode("ascii").rstrip("=")
+
+
+def _decode(value: str, name: str) -> bytes:
+    value = value.strip().rstrip("=").replace("+", "-").replace("/", "_")
+    try:
+        return base64.urlsafe_b64decode(value + "=" * (-len(value) % 4))
+    except (ValueError, binascii.Error) as exc:
+        raise ValueError(f"{name} must be base64url") from exc
+
+
+def decode_key(value: str) -> bytes:
+    """A base64url (or standard base64) X25519 public key; ValueError unless it is 32 bytes."""
+    key = _decode(value, "public_key")
+    if len(key) != 32:
+        raise ValueError("public_key must be a 32-byte X25519 public key")
+    return key
+
+
+def signing_key() -> Optional[Ed25519PrivateKey]:
+    """DWANI_STREAM_SIGNING_KEY, or None when it is not set; ValueError when it is not a 32-byte seed."""
+    value = secret("DWANI_STREAM_SIGNING_KEY")
+    if not value:
+        return None
+    seed = _decode(value, "DWANI_STREAM_SIGNING_KEY")
+    if len(seed) != 32:
+        raise ValueError("DWANI_STREAM_SIGNING_KEY must be the base64 of a 32-byte Ed25519 seed")
+    return Ed25519PrivateKey.from_private_bytes(seed)
+
+
+def signing_public_bytes(key: Ed25519PrivateKey) -> bytes:
+    return key.public_key().public_bytes(Encoding.Raw, PublicFormat.Raw)
+
+
+def verify_key_exchange(message: Dict[str, Any], client_public: bytes, pinned: bytes) -> bytes:
+    """The server's ephemeral key from key_exchange, once its signature checks out with the pinned key.
+
+    ValueError when the message is malformed, signed with another key, or not signed at all.
+    """
+    if message.get("type") != "key_exchange" or message.get("encryption") != SCHEME:
+        raise ValueError(f"expected a {SCHEME} key_exchange")
+    server_public = decode_key(str(message.get("public_key") or ""))
+    try:
+        Ed25519PublicKey.from_public_bytes(pinned).verify(
+            _decode(str(message.get("signature") or ""), "signature"), _SIGNED + client_public + server_public
+        )
+    except InvalidSignature as exc:
+        raise ValueError("key_exchange is not signed with the pinned key") from exc
+    return server_public
+
+
+class SessionCipher:
+    """One side's keys and sequence numbers for a session; role is "client" or "server"."""
+
+    def __init__(self, private_key: X25519PrivateKey, peer_public: bytes, role: str):
+        own = public_bytes(private_key)
+        client_key, server_key = (own, peer_public) if role == "client" else (peer_public, own)
+        shared = private_key.exchange(X25519PublicKey.from_public_bytes(peer_public))
+        keys = HKDF(algorithm=hashes.SHA256(), length=64, salt=None, info=_INFO + client_key + server_key).derive(shared)
+        to_server, to_client = AESGCM(keys[:32]), AESGCM(keys[32:])
+        self._send_direction, self._receive_direction = _DIRECTIONS[role]
+        self._sealer, self._opener = (to_server, to_client) if role == "client" else (to_client, to_server)
+        self._sent = 0
+        self._received = -1
+
+    @staticmethod
+    def _nonce(direction: int, sequence: int) -> bytes:
+        return struct.pack(">I", direction) + _SEQUENCE.pack(sequence)
+
+    def seal(self, plaintext: bytes) -> bytes:
+        sequence, self._sent = self._sent, self._sent + 1
+        return _SEQUENCE.pack(sequence) + self._sealer.encrypt(self._nonce(self._send_direction, sequence), plaintext, None)
+
+    def open(self, frame: bytes) -> bytes:
+        if len(frame) < _SEQUENCE.size + 16:
+            raise FrameError("frame too short")
+        (sequence,) = _SEQUENCE.unpack_from(frame)
+        if sequence <= self._received:
+            raise FrameError(f"frame {sequence} repeats or goes back (last was {self._received})")
+        try:
+            plaintext = self._opener.decrypt(self._nonce(self._receive_direction, sequence), frame[_SEQUENCE.size:], None)
+        except InvalidTag as exc:
+            raise FrameError("frame does not decrypt") from exc
+        self._received = sequence
+        return plaintext
+
+    def seal_json(self, message: Any) -> bytes:
+        return self.seal(json.dumps(message).encode("utf-8"))
+
+    def seal_audio(self, pcm: bytes) -> bytes:
+        """A client frame of audio."""
+        return self.seal(bytes([_AUDIO]) + pcm)
+
+    def seal_control(self, message: Dict[str, Any]) -> bytes:
+        """A client frame of a control message, such as {"type": "end"}."""
+        return self.seal(bytes([_CONTROL]) + json.dumps(message).encode("utf-8"))
+
+    def open_client_frame(self, frame: bytes) -> Tuple[Optional[bytes], Optional[Any]]:
+        """(audio, None) or (None, control) from a client frame; FrameError when it is neither."""
+        plaintext = self.open(frame)
+        if plaintext[:1] == bytes([_AUDIO]):
+            return plaintext[1:], None
+        if plaintext[:1] == bytes([_CONTROL]):
+            try:
+                return None, json.loads(plaintext[1:])
+            except ValueError as exc:
+                raise FrameError("control frame is not JSON") from exc
+        raise FrameError("frame is neither audio nor a control")
+
+
+def server_session(encryption: Optional[str], public_key: Optional[str]) -> Tuple[Optional[SessionCipher], Optional[dict]]:
+    """The server's cipher and key_exchange message for the client's query parameters.
+
+    (None, None) for an unencrypted session; ValueError when the request does not fit
+    DWANI_STREAM_ENCRYPTION or names another scheme or a malformed key, or there is no signing key.
+    """
+    if not encryption:
+        if STREAM_ENCRYPTION == "required":
+            raise ValueError(f"this server requires encryption={SCHEME} with a public_key")
+        return None, None
+    if STREAM_ENCRYPTION == "off":
+        raise ValueError("encryption is not enabled on this server")
+    if encryption != SCHEME:
+        raise ValueError(f"encryption must be {SCHEME}")
+    if not public_key:
+        raise ValueError(f"encryption={SCHEME} needs the client's public_key")
+    peer = decode_key(public_key)
+    signer = signing_key()
+    if signer is None:
+        raise ValueError("encryption is not enabled on this server (no DWANI_STREAM_SIGNING_KEY)")
+    private_key = X25519PrivateKey.generate()
+    cipher = SessionCipher(private_key, peer, "server")
+    own = public_bytes(private_key)
+    return cipher, {
+        "type": "key_exchange",
+        "encryption": SCHEME,
+        "public_key": encode_key(own),
+        "signature": encode_key(signer.sign(_SIGNED + peer + own)),
+        "signing_key": encode_key(signing_public_bytes(signer)),
+    }
+
+
+if __name__ == "__main__":
+    new_key = Ed25519PrivateKey.generate()
+    seed = new_key.private_bytes(Encoding.Raw, PrivateFormat.Raw, NoEncryption())
+    print(f"DWANI_STREAM_SIGNING_KEY={base64.b64encode(seed).decode('ascii')}")
+    print(f"public key for clients to pin: {encode_key(signing_public_bytes(new_key))}")
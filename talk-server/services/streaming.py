@@ -0,0 +1,174 @@
+"""Streaming transcription: the client streams raw PCM and the server decides when each
+utterance is complete.
+
+StreamingTranscriber runs the session's turn-detection preset (services/turn_detection.py)
+over the audio as it arrives, reports speech_started and speech_stopped as they happen, and
+sends each finished utterance to ASR. Transcriptions come back in utterance order while
+the client keeps streaming, so it never has to chop the audio itself. With wake words
+(services/wake_word.py) only the command after one is transcribed for the client.
+"""
+import asyncio
+import time
+from typing import Any, Awaitable, Callable, Dict, List, Optional
+
+from fastapi import HTTPException
+
+from config import logger
+from services.concurrency import pipeline_limiter
+from services.error_codes import code_for, error_fields
+from services.language_id import language_tag
+from services.latency import observe_stage
+from services.pipeline import audio_upload
+from services.telephony import wav_bytes
+from services.transcribe import transcribe_audio
+from services.turn_detection import Endpointer, SpeechEvent, TurnDetectionPreset
+from services.wake_word import WakeWordGate, find_wake_word
+
+Send = Callable[[Dict[str, Any]], Awaitable[None]]
+
+
+class StreamingTranscriber:
+    """One client stream of 16-bit little-endian mono PCM at sample_rate."""
+
+    def __init__(
+        self,
+        send: Send,
+        preset: TurnDetectionPreset,
+        sample_rate: int = 16000,
+        language: Optional[str] = None,
+        request_id: Optional[str] = None,
+        wake: Optional[WakeWordGate] = None,
+    ):
+        self._send = send
+        self.preset = preset
+        self.sample_rate = sample_rate
+        self.language = language
+        self.request_id = request_id
+        self._endpointer = Endpointer(preset, sample_rate=sample_rate)
+        self._queue: "asyncio.Queue[Optional[SpeechEvent]]" = asyncio.Queue()
+        self._worker: Optional[asyncio.Task] = None
+        self._utterances = 0
+        self._wake = wake
+        self._fed_seconds = 0.0
+        self._unanswered = 0  # utterances queued whose results have not been sent
+
+    async def start(self) -> None:
+        self._worker = asyncio.create_task(self._transcribe_queued())
+        ready = {"type": "ready", "sample_rate": self.sample_rate, "turn_detection": self.preset.as_dict()}
+        if self._wake is not None:
+            ready["wake_words"] = self._wake.wake_words
+        await self._send(ready)
+
+    async def feed(self, pcm: bytes) -> None:
+        for event in self._endpointer.feed_events(pcm):
+            await self._on_event(event)
+        self._fed_seconds += len(pcm) / (2 * self.sample_rate)
+        wake = self._wake
+        if wake is not None and not self._endpointer.speaking and not self._unanswered and wake.expired(self._fed_seconds):
+            wake.sleep()
+            await self._send({"type": "sleep", "reason": "timeout", "at_seconds": round(self._fed_seconds, 3)})
+
+    async def flush(self) -> None:
+        event = self._endpointer.flush()
+        if event is not None:
+            await self._on_event(event)
+
+    async def finish(self) -> None:
+        """End of stream: transcribe what is left and wait for every result to be sent."""
+        await self.flush()
+        await self._queue.put(None)
+        if self._worker is not None:
+            await self._worker
+
+    async def close(self) -> None:
+        """The client went away: drop pending utterances."""
+        if self._worker is not None and not self._worker.done():
+            self._worker.cancel()
+            try:
+                await self._worker
+            except (asyncio.CancelledError, Exception):
+                pass
+
+    async def _on_event(self, event: SpeechEvent) -> None:
+        if event.type == "speech_started":
+            await self._send({"type": "speech_started", "utterance": self._utterances, "at_seconds": event.at_seconds})
+            return
+        duration = len(event.audio) / (2 * self.sample_rate)
+        await self._send({
+            "type": "speech_stopped",
+            "utterance": self._utterances,
+            "at_seconds": event.at_seconds,
+            "duration_seconds": round(duration, 3),
+        })
+        self._utterances += 1
+        self._unanswered += 1
+        await self._queue.put(event)
+
+    async def _transcribe_queued(self) -> None:
+        index = 0
+        while True:
+            event = await self._queue.get()
+            if event is None:
+                return
+            result = await self._transcribe(index, event)
+            for message in [result] if self._wake is None else self._gated(index, event, result):
+                await self._send(message)
+            self._unanswered -= 1
+            index += 1
+
+    def _gated(self, index: int, event: SpeechEvent, result: Dict[str, Any]) -> List[Dict[str, Any]]:
+        """What the client hears of an utterance's result under wake-word gating."""
+        wake = self._wake
+        if result["type"] != "transcription":
+            return [result]
+        messages: List[Dict[str, Any]] = []
+        end = result["end_seconds"]
+        if wake.awake and not wake.expired(event.start_seconds):
+            if not result["text"].strip():
+                wake.wake(end)  # noise, not the command: keep listening
+                return [result]
+            wake.sleep()
+            return [result, {"type": "sleep", "reason": "answered", "at_seconds": end}]
+        if wake.awake:
+            wake.sleep()
+            messages.append({"type": "sleep", "reason": "timeout", "at_seconds": event.start_seconds})
+        found = find_wake_word(result["text"], wake.wake_words)
+        if found is None:
+            return messages + [{"type": "ignored", "utterance": index}]
+        wake_word, command = found
+        messages.append({"type": "wake", "utterance": index, "wake_word": wake_word, "at_seconds": end})
+        if not command:
+            wake.wake(end)
+            return messages
+        return messages + [{**result, "text": command}, {"type": "sleep", "reason": "answered", "at_seconds": end}]
+
+    async def _transcribe(self, index: int, event: SpeechEvent) -> Dict[str, Any]:
+        start = event.start_seconds
+        end = round(start + len(event.audio) / (2 * self.sample_rate), 3)
+        started = time.perf_counter()
+        try:
+            async with pipeline_limiter.slot():
+                with observe_stage("asr"):
+                    result = await transcribe_audio(
+                        audio_upload(wav_bytes(event.audio, self.sample_rate), "audio/wav", f"utterance-{index}.wav"),
+                        language=self.language,
+                        request_id=self.request_id,
+                    )
+        except HTTPException as exc:
+            logger.warning("Streaming transcription of utterance %s failed: %s", index, exc.detail)
+            return {
+                "type": "error",
+                "utterance": index,
+                "code": str(exc.status_code),
+                "message": str(exc.detail),
+                **error_fields(code_for(exc), self.language),
+            }
+        return {
+            "type": "transcription",
+            "utterance": index,
+            "start_seconds": start,
+            "end_seconds": end,
+            "text": result.text,
+            "language": language_tag(result.language),
+            "asr_ms": round((time.perf_counter() - started) * 1000),
+        }
@@ -0,0 +1,243 @@
+"""A Telegram bot: voice notes in, a spoken reply and its text out, with no frontend to build.
+
+Telegram POSTs each update to /v1/telegram/webhook (registered at startup when
+DWANI_TELEGRAM_WEBHOOK_URL is set), or with DWANI_TELEGRAM_POLLING=1 the server long-polls
+getUpdates instead, for development without a public URL (one worker only: Telegram answers a
+second poller with a conflict). A voice note (or audio file) is downloaded with getFile,
+run through converse(), and the reply is synthesized as Ogg/Opus and sent back with sendVoice,
+its text as the caption (or as a message of its own when it is too long for one). A text
+message skips ASR. Each chat is a session ("telegram:<chat id>"), so history and slots carry
+across messages; language, mode and agent come from DWANI_TELEGRAM_*.
+"""
+import asyncio
+from collections import OrderedDict
+from typing import Any, Dict, Optional, Set
+
+import httpx
+from fastapi import HTTPException
+from prometheus_client import Counter
+
+from config import (
+    MAX_UPLOAD_BYTES,
+    TELEGRAM_AGENT,
+    TELEGRAM_ALLOWED_CHATS,
+    TELEGRAM_API_URL,
+    TELEGRAM_LANGUAGE,
+    TELEGRAM_MODE,
+    TELEGRAM_POLLING,
+    TELEGRAM_WEBHOOK_URL,
+    logger,
+)
+from services.audio_format import transcode
+from services.concurrency import pipeline_limiter
+from services.credentials import secret
+from services.error_codes import code_for, error_fields
+from services.http_client import http_client
+from services.ids import new_id
+from services.messages import GREETING, message
+from services.pipeline import audio_upload, converse
+from services.tts import stream_tts
+
+CAPTION_LIMIT = 1024  # characters Telegram allows in a voice message's caption
+_SEEN_LIMIT = 1000
+_MESSAGES = Counter("dwani_telegram_messages_total", "Telegram messages handled by the bot", ["kind", "outcome"])
+
+
+class TelegramError(Exception):
+    """A Bot API call that failed or answered ok=false."""
+
+
+class TelegramBot:
+    """Bot API calls and the handling of one update at a time; one per process."""
+
+    def __init__(self, token: str, api_url: str = TELEGRAM_API_URL):
+        self.token = token
+        self.api_url = api_url.rstrip("/")
+        self._seen: "OrderedDict[int, None]" = OrderedDict()
+        self._running: Set[asyncio.Task] = set()
+
+    async def call(self, method: str, files: Optional[Dict[str, Any]] = None, **params: Any) -> Any:
+        """Call a Bot API method and return its result; TelegramError when it fails."""
+        url = f"{self.api_url}/bot{self.token}/{method}"
+        data = {key: value for key, value in params.items() if value is not None}
+        # getUpdates holds the request open for its own timeout.
+        timeout = data["timeout"] + 10 if method == "getUpdates" else httpx.USE_CLIENT_DEFAULT
+        try:
+            if files:
+                form = {key: str(value) for key, value in data.items()}
+                response = await http_client().post(url, data=form, files=files, timeout=timeout)
+            else:
+                response = await http_client().post(url, json=data, timeout=timeout)
+            body = response.json()
+        except (httpx.HTTPError, ValueError) as exc:
+            raise TelegramError(f"{method} failed: {exc}") from exc
+        if not body.get("ok"):
+            raise TelegramError(f"{method} failed: {body.get('description') or response.status_code}")
+        return body.get("result")
+
+    async def download(self, file_id: str) -> bytes:
+        info = await self.call("getFile", file_id=file_id)
+        if int(info.get("file_size") or 0) > MAX_UPLOAD_BYTES:
+            raise HTTPException(status_code=413, detail=f"Voice note exceeds {MAX_UPLOAD_BYTES} bytes")
+        try:
+            response = await http_client().get(f"{self.api_url}/file/bot{self.token}/{info['file_path']}")
+            response.raise_for_status()
+        except (httpx.HTTPError, KeyError) as exc:
+            raise TelegramError(f"Voice note download failed: {exc}") from exc
+        return response.content
+
+    def dispatch(self, update: Dict[str, Any]) -> None:
+        """Handle update in the background, so the webhook can answer Telegram at once."""
+        task = asyncio.create_task(self.handle_update(update))
+        self._running.add(task)
+        task.add_done_callback(self._running.discard)
+
+    async def handle_update(self, update: Dict[str, Any]) -> None:
+        """Answer one update; updates Telegram delivers again (after a slow answer) are skipped."""
+        update_id = update.get("update_id")
+        if update_id is not None:
+            if update_id in self._seen:
+                return
+            self._seen[update_id] = None
+            if len(self._seen) > _SEEN_LIMIT:
+                self._seen.popitem(last=False)
+        incoming = update.get("message") or {}
+        chat_id = (incoming.get("chat") or {}).get("id")
+        if chat_id is None:
+            return
+        if TELEGRAM_ALLOWED_CHATS and str(chat_id) not in TELEGRAM_ALLOWED_CHATS:
+            logger.info("Telegram message from chat %s ignored: not in DWANI_TELEGRAM_ALLOWED_CHATS", chat_id)
+            return
+        voice = incoming.get("voice") or incoming.get("audio")
+        text = (incoming.get("text") or "").strip()
+        try:
+            if voice:
+                await self._answer(chat_id, incoming.get("message_id"), voice=voice)
+            elif text.startswith("/start"):
+                await self.call("sendMessage", chat_id=chat_id, text=message(GREETING, TELEGRAM_LANGUAGE or None))
+            elif text:
+                await self._answer(chat_id, incoming.get("message_id"), text=text)
+        except TelegramError as exc:
+            logger.error("Telegram reply to chat %s failed: %s", chat_id, exc)
+
+    async def _answer(self, chat_id: int, message_id: Optional[int], voice: Optional[Dict[str, Any]] = None, text: Optional[str] = None) -> None:
+        kind = "voice" if voice else "text"
+        request_id = new_id("request")
+        language = TELEGRAM_LANGUAGE or None
+        try:
+            upload = None
+            if voice:
+                upload = audio_upload(await self.download(voice["file_id"]), voice.get("mime_type") or "audio/ogg", "voice.ogg")
+            async with pipeline_limiter.slot(block=True):
+                turn = await converse(
+                    upload,
+                    mode=TELEGRAM_MODE,
+                    agent_name=TELEGRAM_AGENT or None,
+                    session_id=f"telegram:{chat_id}",
+                    request_id=request_id,
+                    language=language,
+                    text=None if voice else text,
+                )
+            language = turn.language or language
+            audio = await self._voice(turn.reply, language, request_id) if turn.reply else None
+        except Exception as exc:
+            logger.warning("Telegram %s message from chat %s failed: %s", kind, chat_id, exc, extra={"request_id": request_id})
+            _MESSAGES.labels(kind=kind, outcome="error").inc()
+            await self.call(
+                "sendMessage",
+                chat_id=chat_id,
+                text=error_fields(code_for(exc), language)["localized_message"],
+                reply_to_message_id=message_id,
+            )
+            return
+        _MESSAGES.labels(kind=kind, outcome="ok").inc()
+        await self.reply(chat_id, message_id, turn.reply, audio)
+
+    async def _voice(self, text: str, language: Optional[str], request_id: str) -> bytes:
+        """The reply as Ogg/Opus, the only format sendVoice shows as a voice message."""
+        tts_stream = await stream_tts(text, request_id=request_id, language=language, audio_format="ogg")
+        audio = await tts_stream.read()
+        return audio if tts_stream.audio_format == "ogg" else await transcode(audio, "ogg")
+
+    async def reply(self, chat_id: int, message_id: Optional[int], text: str, audio: Optional[bytes]) -> None:
+        """Send the reply's voice message, captioned with its text when that fits."""
+        if not audio:
+            if text:
+                await self.call("sendMessage", chat_id=chat_id, text=text, reply_to_message_id=message_id)
+            return
+        caption = text if len(text) <= CAPTION_LIMIT else None
+        if caption is None:
+            await self.call("sendMessage", chat_id=chat_id, text=text, reply_to_message_id=message_id)
+        await self.call(
+            "sendVoice",
+            files={"voice": ("reply.ogg", audio, "audio/ogg")},
+            chat_id=chat_id,
+            caption=caption,
+            reply_to_message_id=message_id,
+        )
+
+    async def poll(self, timeout: int = 30) -> None:
+        """Long-poll getUpdates and handle each update, until cancelled."""
+        await self.call("deleteWebhook")
+        offset = None
+        while True:
+            try:
+                updates = await self.call("getUpdates", offset=offset, timeout=timeout)
+            except TelegramError as exc:
+                logger.warning("Telegram getUpdates failed, retrying: %s", exc)
+                await asyncio.sleep(5)
+                continue
+            for update in updates:
+                offset = update["update_id"] + 1
+                self.dispatch(update)
+
+
+_BOT: Optional[TelegramBot] = None
+_POLLER: Optional[asyncio.Task] = None
+
+
+def telegram_bot() -> Optional[TelegramBot]:
+    """The bot for DWANI_TELEGRAM_BOT_TOKEN, or None when it is unset."""
+    global _BOT
+    token = secret("DWANI_TELEGRAM_BOT_TOKEN")
+    if not token:
+        return None
+    if _BOT is None or _BOT.token != token:
+        _BOT = TelegramBot(token)
+    return _BOT
+
+
+async def start() -> None:
+    """Register the webhook (only with a secret), or start long polling; nothing without a bot token."""
+    global _POLLER
+    bot = telegram_bot()
+    if bot is None:
+        return
+    if TELEGRAM_POLLING:
+        _POLLER = asyncio.create_task(bot.poll())
+        logger.info("Telegram bot is long-polling for updates")
+        return
+    if not TELEGRAM_WEBHOOK_URL:
+        return
+    webhook_secret = secret("DWANI_TELEGRAM_WEBHOOK_SECRET")
+    if not webhook_secret:
+        logger.error("Telegram webhook not registered: DWANI_TELEGRAM_WEBHOOK_SECRET is not set, so updates would be refused")
+        return
+    try:
+        await bot.call(
+            "setWebhook",
+            url=TELEGRAM_WEBHOOK_URL,
+            secret_token=webhook_secret,
+            allowed_updates=["message"],
+        )
+        logger.info("Telegram webhook set to %s", TELEGRAM_WEBHOOK_URL)
+    except TelegramError as exc:
+        logger.error("Telegram webhook could not be registered: %s", exc)
+
+
+async def stop() -> None:
+    global _POLLER
+    if _POLLER is not None:
+        _POLLER.cancel()
+        await asyncio.gather(_POLLER, return_exceptions=True)
+        _POLLER = None
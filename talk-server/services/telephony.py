@@ -0,0 +1,290 @@
+"""Phone calls over Twilio Media Streams (<Connect><Stream>) as a voice bot.
+
+Twilio sends the caller's audio as 8 kHz G.711 mu-law frames in JSON "media" events over a
+WebSocket. TwilioCall decodes them, cuts utterances with the session's turn-detection preset
+(services/turn_detection.py), runs each through converse() and TTS, and streams the reply
+back as mu-law "media" messages followed by a "mark"; Twilio echoes the mark once playback
+has finished. If the caller talks over a reply that is being prepared or played
+(DWANI_BARGE_IN), the reply is cancelled, Twilio is told to "clear" the audio it has
+buffered, and what the caller is saying becomes the next turn.
+
+Each call is a session ("twilio:<CallSid>"), so history and slots carry across turns.
+Language, mode and agent come from the <Stream>'s <Parameter>s, else DWANI_TWILIO_*.
+"""
+import asyncio
+import base64
+import hashlib
+import hmac
+import struct
+import sys
+from array import array
+from typing import Any, Awaitable, Callable, Dict, Optional, Tuple
+
+from fastapi import HTTPException
+from prometheus_client import Counter
+
+from config import (
+    BARGE_IN,
+    BARGE_IN_MS,
+    TWILIO_AGENT,
+    TWILIO_ERROR_PROMPT,
+    TWILIO_GREETING,
+    TWILIO_LANGUAGE,
+    TWILIO_MODE,
+    logger,
+)
+from services.audio_format import transcode
+from services.concurrency import pipeline_limiter
+from services.ids import new_id
+from services.language_id import normalize_language
+from services.messages import GREETING, message
+from services.pipeline import audio_upload, converse
+from services.tts import stream_tts
+from services.turn_detection import Endpointer, get_preset, session_preset
+
+SAMPLE_RATE = 8000
+_FRAME_BYTES = 160  # 20 ms of mu-law, the frame size Twilio sends
+_BIAS = 0x84
+_CLIP = 32635
+_BARGE_INS = Counter("dwani_barge_in_total", "Replies cut short because the caller started speaking", ["phase"])
+
+
+def _ulaw_to_linear(value: int) -> int:
+    value = ~value & 0xFF
+    magnitude = (((value & 0x0F) << 3) + _BIAS) << ((value >> 4) & 0x07)
+    return _BIAS - magnitude if value & 0x80 else magnitude - _BIAS
+
+
+def _linear_to_ulaw(sample: int) -> int:
+    sign = 0x80 if sample < 0 else 0
+    sample = min(-sample if sign else sample, _CLIP) + _BIAS
+    exponent = max(0, sample.bit_length() - 8)
+    mantissa = (sample >> (exponent + 3)) & 0x0F
+    return ~(sign | (exponent << 4) | mantissa) & 0xFF
+
+
+# Lookup tables: 256 decoded samples, and the encoding of every 16-bit sample (indexed unsigned).
+_DECODE = [_ulaw_to_linear(value) for value in range(256)]
+_ENCODE = bytes(_linear_to_ulaw(value - 65536 if value > 32767 else value) for value in range(65536))
+
+
+def ulaw_decode(data: bytes) -> bytes:
+    """G.711 mu-law to 16-bit little-endian PCM."""
+    samples = array("h", (_DECODE[value] for value in data))
+    if sys.byteorder == "big":
+        samples.byteswap()
+    return samples.tobytes()
+
+
+def ulaw_encode(pcm: bytes) -> bytes:
+    """16-bit little-endian PCM to G.711 mu-law."""
+    samples = array("h", pcm[: len(pcm) // 2 * 2])
+    if sys.byteorder == "big":
+        samples.byteswap()
+    return bytes(_ENCODE[sample & 0xFFFF] for sample in samples)
+
+
+def wav_bytes(pcm: bytes, sample_rate: int = SAMPLE_RATE) -> bytes:
+    """A mono 16-bit WAV file around pcm (what the ASR upload expects)."""
+    header = struct.pack(
+        "<4sI4s4sIHHIIHH4sI",
+        b"RIFF", 36 + len(pcm), b"WAVE", b"fmt ", 16, 1, 1, sample_rate, sample_rate * 2, 2, 16, b"data", len(pcm),
+    )
+    return header + pcm
+
+
+def _wav_pcm(data: bytes) -> Optional[Tuple[bytes, int, int]]:
+    """(pcm, sample_rate, channels) of a 16-bit PCM WAV file, else None."""
+    if data[:4] != b"RIFF" or data[8:12] != b"WAVE":
+        return None
+    offset, fmt = 12, None
+    while offset + 8 <= len(data):
+        chunk, size = struct.unpack("<4sI", data[offset:offset + 8])
+        body = data[offset + 8:offset + 8 + size]
+        if chunk == b"fmt " and len(body) >= 16:
+            fmt = struct.unpack("<HHIIHH", body[:16])
+        elif chunk == b"data" and fmt is not None:
+            audio_format, channels, sample_rate, _, _, bits = fmt
+            if audio_format not in (1, 0xFFFE) or bits != 16:
+                return None
+            return body, sample_rate, channels
+        offset += 8 + size + (size & 1)
+    return None
+
+
+def downmix(pcm: bytes, sample_rate: int, channels: int, target_rate: int = SAMPLE_RATE) -> bytes:
+    """Mono at target_rate, resampled by averaging each output sample's window (a crude low-pass)."""
+    samples = array("h", pcm[: len(pcm) // (2 * channels) * 2 * channels])
+    if sys.byteorder == "big":
+        samples.byteswap()
+    if channels > 1:
+        samples = array("h", (sum(samples[i:i + channels]) // channels for i in range(0, len(samples), channels)))
+    if sample_rate != target_rate:
+        step = sample_rate / target_rate
+        out = array("h")
+        for index in range(int(len(samples) / step)):
+            start = int(index * step)
+            window = samples[start:max(start + 1, int((index + 1) * step))]
+            out.append(sum(window) // len(window))
+        samples = out
+    if sys.byteorder == "big":
+        samples.byteswap()
+    return samples.tobytes()
+
+
+async def to_mulaw(audio: bytes) -> bytes:
+    """TTS audio as 8 kHz mu-law: WAV is converted here, anything else with ffmpeg."""
+    wav = _wav_pcm(audio)
+    if wav is None:
+        return await transcode(audio, "mulaw")
+    return ulaw_encode(downmix(*wav))
+
+
+def twilio_signature(auth_token: str, url: str, params: Optional[Dict[str, str]] = None) -> str:
+    """X-Twilio-Signature for a request to url: HMAC-SHA1 over the URL and sorted form parameters."""
+    message = url + "".join(f"{key}{value}" for key, value in sorted((params or {}).items()))
+    return base64.b64encode(hmac.new(auth_token.encode("utf-8"), message.encode("utf-8"), hashlib.sha1).digest()).decode("ascii")
+
+
+def valid_twilio_signature(auth_token: str, url: str, params: Optional[Dict[str, str]], signature: Optional[str]) -> bool:
+    if not signature:
+        return False
+    expected = twilio_signature(auth_token, url, params)
+    return hmac.compare_digest(signature.encode("utf-8"), expected.encode("ascii"))
+
+
+Send = Callable[[Dict[str, Any]], Awaitable[None]]
+
+
+class TwilioCall:
+    """One Media Streams connection; feed it each JSON message Twilio sends."""
+
+    def __init__(self, send: Send):
+        self._send = send
+        self.stream_sid: Optional[str] = None
+        self.call_sid: Optional[str] = None
+        self.session_id: Optional[str] = None
+        self.language: Optional[str] = TWILIO_LANGUAGE or None
+        self.mode = TWILIO_MODE
+        self.agent_name: Optional[str] = TWILIO_AGENT or None
+        self._endpointer: Optional[Endpointer] = None
+        self._task: Optional[asyncio.Task] = None
+        self._awaiting_mark: Optional[str] = None
+        self._replies = 0
+
+    @property
+    def listening(self) -> bool:
+        """False while a reply is being prepared or played back."""
+        return self._awaiting_mark is None and (self._task is None or self._task.done())
+
+    async def handle(self, message: Dict[str, Any]) -> bool:
+        """Process one message; False once the stream has stopped."""
+        event = message.get("event")
+        if event == "start":
+            await self._on_start(message.get("start") or {}, message.get("streamSid"))
+        elif event == "media":
+            await self._on_media(message.get("media") or {})
+        elif event == "mark":
+            if (message.get("mark") or {}).get("name") == self._awaiting_mark:
+                self._awaiting_mark = None
+                if self._endpointer is not None and not self._endpointer.speaking:
+                    self._endpointer.reset()
+        elif event == "stop":
+            logger.info("Twilio call %s ended", self.call_sid)
+            return False
+        return True
+
+    async def close(self) -> None:
+        if self._task is not None and not self._task.done():
+            self._task.cancel()
+            try:
+                await self._task
+            except (asyncio.CancelledError, Exception):
+                pass
+
+    async def _on_start(self, start: Dict[str, Any], stream_sid: Optional[str]) -> None:
+        self.stream_sid = start.get("streamSid") or stream_sid
+        self.call_sid = start.get("callSid") or self.stream_sid
+        self.session_id = f"twilio:{self.call_sid}"
+        params = start.get("customParameters") or {}
+        self.language = normalize_language(params.get("language")) or self.language
+        self.mode = params.get("mode") or self.mode
+        self.agent_name = params.get("agent_name") or self.agent_name
+        preset = get_preset(params.get("turn_detection")) or session_preset(self.session_id)
+        self._endpointer = Endpointer(preset, sample_rate=SAMPLE_RATE)
+        logger.info("Twilio call %s started (stream %s, preset %s)", self.call_sid, self.stream_sid, preset.name)
+        greeting = message(GREETING, self.language, configured=TWILIO_GREETING)
+        if greeting:
+            self._task = asyncio.create_task(self._say(greeting, self.language, new_id("request")))
+
+    async def _on_media(self, media: Dict[str, Any]) -> None:
+        if self._endpointer is None or media.get("track", "inbound") != "inbound":
+            return
+        if not self.listening and not BARGE_IN:
+            return
+        try:
+            pcm = ulaw_decode(base64.b64decode(media.get("payload") or ""))
+        except ValueError:
+            return
+        utterances = self._endpointer.feed(pcm)
+        if not self.listening:
+            # Short sounds over the reply (a cough, line echo) are dropped; sustained speech interrupts it.
+            if not (self._endpointer.speaking and self._endpointer.voiced_ms >= BARGE_IN_MS):
+                return
+            await self._interrupt()
+            return
+        for utterance in utterances:
+            self._task = asyncio.create_task(self._respond(utterance))
+            break  # stop listening until this reply has been played
+
+    async def _interrupt(self) -> None:
+        """Barge-in: drop the reply in progress and the audio Twilio still has queued."""
+        phase = "speaking" if self._task is None or self._task.done() else "thinking"
+        logger.info("Twilio call %s: caller barged in while the reply was %s", self.call_sid, phase)
+        _BARGE_INS.labels(phase=phase).inc()
+        await self.close()
+        self._task = None
+        self._awaiting_mark = None
+        await self._send({"event": "clear", "streamSid": self.stream_sid})
+
+    async def _respond(self, pcm: bytes) -> None:
+        request_id = new_id("request")
+        try:
+            async with pipeline_limiter.slot():
+                turn = await converse(
+                    audio_upload(wav_bytes(pcm), "audio/wav"),
+                    mode=self.mode,
+                    agent_name=self.agent_name,
+                    session_id=self.session_id,
+                    request_id=request_id,
+                    language=self.language,
+                )
+        except HTTPException as exc:
+            if exc.status_code == 400:
+                # No speech in the utterance (noise, a cough): keep listening.
+                logger.info("Twilio call %s: utterance skipped: %s", self.call_sid, exc.detail)
+                return
+            logger.warning("Twilio call %s turn failed (%s): %s", self.call_sid, getattr(exc, "stage", None), exc.detail)
+            await self._say(message("call_error", self.language, configured=TWILIO_ERROR_PROMPT), self.language, request_id)
+            return
+        except Exception as exc:
+            logger.error("Twilio call %s turn failed: %s", self.call_sid, exc)
+            await self._say(message("call_error", self.language, configured=TWILIO_ERROR_PROMPT), self.language, request_id)
+            return
+        await self._say(turn.reply, turn.language, request_id)
+
+    async def _say(self, text: str, language: Optional[str], request_id: str) -> None:
+        if not text:
+            return
+        try:
+            tts_stream = await stream_tts(text, request_id=request_id, language=language, audio_format="wav")
+            audio = await to_mulaw(await tts_stream.read())
+        except Exception as exc:
+            logger.error("Twilio call %s: reply could not be synthesized: %s", self.call_sid, exc)
+            return
+        for offset in range(0, len(audio), _FRAME_BYTES):
+            payload = base64.b64encode(audio[offset:offset + _FRAME_BYTES]).decode("ascii")
+            await self._send({"event": "media", "streamSid": self.stream_sid, "media": {"payload": payload}})
+        self._replies += 1
+        self._awaiting_mark = f"reply-{self._replies}"
+        await self._send({"event": "mark", "streamSid": self.stream_sid, "mark": {"name": self._awaiting_mark}})
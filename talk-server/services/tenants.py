@@ -0,0 +1,171 @@
+"""Tenants: several apps served by one deployment, each with its own backends and limits.
+
+DWANI_TENANTS_FILE is a JSON object of tenant name -> settings, all of them optional:
+
+    {"acme": {"api_keys": ["acme-key-1"], "hosts": ["voice.acme.com"],
+              "backends": {"llm_base_url": "https://llm.acme.internal/v1", "llm_model": "gemma3",
+                           "tts_base_url": "https://tts.acme.internal"},
+              "languages": ["kannada", "english"], "personas": ["concierge"], "persona": "concierge",
+              "rate_limit": "300/minute", "daily_quota": 5000}}
+
+A request belongs to the tenant whose API key it carries (X-API-Key or Bearer), else to the
+tenant its Host names: one of its "hosts" in full, or its name as the first label
+(acme.talk.example.com). Any other request gets the deployment's own settings, as before.
+
+"backends" overrides fields of PipelineConfig (services/backends.py) for the tenant's turns; the
+rest come from the DWANI_* environment. "languages" and "personas" restrict what the tenant's
+requests may ask for, 400 otherwise, and "persona" is its default persona (DWANI_PERSONA). A
+tenant with api_keys only accepts those keys, even on its own host. "rate_limit" is shared by
+all of the tenant's requests like DWANI_RATE_LIMIT_PER_API_KEY, and "daily_quota" caps its
+pipeline (POST /v1/...) requests per day; both are per worker process (ratelimit.py). Branding
+for a tenant lives under the same name in DWANI_BRANDING_FILE (services/branding.py).
+"""
+import hmac
+import json
+from contextvars import ContextVar
+from dataclasses import dataclass, fields
+from typing import Any, Dict, FrozenSet, Optional, Tuple
+
+from config import TENANTS_FILE, logger
+from models import ALLOWED_LANGUAGES, language_alias
+from ratelimit import TokenBucketLimiter, parse_rate
+from services.backends import PipelineConfig, use_backends
+from services.branding import DEFAULT_TENANT, tenant_for_host, use_tenant
+
+_SETTINGS = {"api_keys", "hosts", "backends", "languages", "personas", "persona", "rate_limit", "daily_quota"}
+_BACKEND_FIELDS = {f.name: f.type for f in fields(PipelineConfig)}
+
+_active: ContextVar[Optional["Tenant"]] = ContextVar("dwani_tenant_settings", default=None)
+
+
+@dataclass(frozen=True)
+class Tenant:
+    name: str
+    api_keys: Tuple[str, ...] = ()
+    hosts: FrozenSet[str] = frozenset()
+    backends: Optional[PipelineConfig] = None
+    languages: FrozenSet[str] = frozenset()  # empty: every supported language
+    personas: FrozenSet[str] = frozenset()  # empty: every configured persona
+    persona: Optional[str] = None
+    rate: Optional[TokenBucketLimiter] = None
+    daily: Optional[TokenBucketLimiter] = None
+
+    def has_key(self, api_key: Optional[str]) -> bool:
+        provided = (api_key or "").encode("utf-8")
+        return bool(provided) and any(hmac.compare_digest(provided, key.encode("utf-8")) for key in self.api_keys)
+
+    def acquire(self, pipeline: bool) -> float:
+        """Take a request from the tenant's limits; 0 when allowed, else seconds until one is available."""
+        wait = self.rate.acquire(self.name) if self.rate is not None else 0.0
+        if not wait and pipeline and self.daily is not None:
+            wait = self.daily.acquire(self.name)
+        return wait
+
+
+def _strings(value: Any, where: str) -> Tuple[str, ...]:
+    if not isinstance(value, list) or not all(isinstance(item, str) and item.strip() for item in value):
+        raise ValueError(f"tenant {where} must be a list of non-empty strings")
+    return tuple(item.strip() for item in value)
+
+
+def _backends(spec: Any, name: str) -> PipelineConfig:
+    if not isinstance(spec, dict):
+        raise ValueError(f"tenant {name!r}: backends must be an object")
+    for key, value in spec.items():
+        if key not in _BACKEND_FIELDS:
+            raise ValueError(f"tenant {name!r}: unknown backend setting {key!r}, expected one of {sorted(_BACKEND_FIELDS)}")
+        expected = int if "int" in str(_BACKEND_FIELDS[key]) else str
+        if not isinstance(value, expected) or isinstance(value, bool):
+            raise ValueError(f"tenant {name!r}: backends.{key} must be {'an integer' if expected is int else 'a string'}")
+    return PipelineConfig(**spec)
+
+
+def tenant_from_spec(spec: Any, name: str) -> Tenant:
+    """A Tenant from its settings object; ValueError when malformed."""
+    if not isinstance(spec, dict):
+        raise ValueError(f"tenant {name!r} must be an object")
+    unknown = set(spec) - _SETTINGS
+    if unknown:
+        raise ValueError(f"tenant {name!r}: unknown settings {sorted(unknown)}, expected some of {sorted(_SETTINGS)}")
+    languages = set()
+    for value in _strings(spec.get("languages", []), f"{name!r} languages"):
+        language = language_alias(value).lower()
+        if language not in ALLOWED_LANGUAGES:
+            raise ValueError(f"tenant {name!r}: unsupported language {value!r}")
+        languages.add(language)
+    personas = frozenset(_strings(spec.get("personas", []), f"{name!r} personas"))
+    persona = spec.get("persona")
+    if persona is not None and (not isinstance(persona, str) or (personas and persona not in personas)):
+        raise ValueError(f"tenant {name!r}: persona must be one of its personas")
+    rate = parse_rate(str(spec.get("rate_limit") or ""))
+    daily_quota = spec.get("daily_quota") or 0
+    if not isinstance(daily_quota, int) or isinstance(daily_quota, bool) or daily_quota < 0:
+        raise ValueError(f"tenant {name!r}: daily_quota must be a non-negative integer")
+    return Tenant(
+        name=name,
+        api_keys=_strings(spec.get("api_keys", []), f"{name!r} api_keys"),
+        hosts=frozenset(host.lower() for host in _strings(spec.get("hosts", []), f"{name!r} hosts")),
+        backends=_backends(spec["backends"], name) if spec.get("backends") else None,
+        languages=frozenset(languages),
+        personas=personas,
+        persona=persona,
+        rate=TokenBucketLimiter(*rate) if rate else None,
+        daily=TokenBucketLimiter(daily_quota, daily_quota / 86400) if daily_quota else None,
+    )
+
+
+def load_tenants(path: str = TENANTS_FILE) -> Dict[str, Tenant]:
+    """The tenants defined in path (see the module docstring); {} when it is not set."""
+    if not path:
+        return {}
+    with open(path, encoding="utf-8") as f:
+        specs = json.load(f)
+    if not isinstance(specs, dict):
+        raise ValueError(f"{path} must be an object of tenant name -> settings")
+    loaded: Dict[str, Tenant] = {}
+    for name, spec in specs.items():
+        key = name.strip().lower()
+        if not key or key == DEFAULT_TENANT:
+            raise ValueError(f"{path}: {name!r} cannot be a tenant name")
+        loaded[key] = tenant_from_spec(spec, key)
+    owners: Dict[str, str] = {}
+    for tenant in loaded.values():
+        for api_key in tenant.api_keys:
+            if owners.setdefault(api_key, tenant.name) != tenant.name:
+                raise ValueError(f"{path}: tenants {owners[api_key]!r} and {tenant.name!r} share an API key")
+    logger.info("Loaded %s tenants from %s", len(loaded), path)
+    return loaded
+
+
+tenants = load_tenants()
+
+
+def tenant_for_request(api_key: Optional[str], host: Optional[str]) -> Optional[Tenant]:
+    """The tenant whose key the request carries, else the one its Host names; None for neither."""
+    if api_key:
+        for tenant in tenants.values():
+            if tenant.has_key(api_key):
+                return tenant
+    name = (host or "").strip().lower().split(":", 1)[0]
+    for tenant in tenants.values():
+        if name in tenant.hosts:
+            return tenant
+    return tenants.get(tenant_for_host(host, tenants) or "")
+
+
+def use_tenant_settings(tenant: Optional[Tenant]) -> None:
+    """Serve the rest of the current request, call or job with tenant's settings; None for the deployment's."""
+    _active.set(tenant)
+    use_backends(tenant.backends if tenant is not None else None)
+    if tenant is not None:
+        use_tenant(tenant.name)
+
+
+def current_tenant_settings() -> Optional[Tenant]:
+    return _active.get()
+
+
+def current_tenant_name() -> Optional[str]:
+    """Name of the tenant the current request, call or job belongs to; None for the deployment's own."""
+    tenant = _active.get()
+    return tenant.name if tenant is not None else None
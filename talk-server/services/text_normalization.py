@@ -0,0 +1,340 @@
+"""Text normalization before TTS: numbers, dates, currency and abbreviations in spoken form.
+
+LLM replies write "₹1,250", "15/08/2024", "3.5 km" and "ಡಾ." the way they are read, not the
+way they are said, and Indic TTS voices either skip such tokens or spell them out in English.
+For Kannada, Hindi and Tamil replies this stage writes them out in the reply's language:
+
+    numbers     12,500 / 1,00,000 (Indian or Western grouping) in lakh and crore; decimals
+                digit by digit after the point; numbers over nine digits or with a leading
+                zero (phone numbers, PINs) digit by digit
+    dates       DD/MM/YYYY, DD-MM-YYYY, DD.MM.YYYY and YYYY-MM-DD, with the month by name
+    currency    ₹, Rs., INR and the language's own abbreviation (रु., ರೂ., ரூ.), with paise; $
+    percent     50%
+    units       km, kg, cm, mm, ml and mg after a number
+    titles      Dr., etc. and the language's own abbreviations (डॉ., ಡಾ., கி.மீ.)
+
+Deployments add abbreviations, for any supported language, in DWANI_TTS_ABBREVIATIONS_FILE, a
+JSON object of language -> {abbreviation: expansion}. DWANI_TTS_NORMALIZE=0 sends the text as
+the LLM wrote it. Numbers in other languages are left to the TTS backend.
+
+With DWANI_TTS_SSML=1, for backends that accept SSML, the text is then sent as
+<speak xml:lang="kn-IN">, in a <prosody> with DWANI_TTS_SSML_RATE and DWANI_TTS_SSML_PITCH when
+set (e.g. "95%", "slow", "+2st"). Normalization runs as a before_tts hook (services/hooks.py),
+so it covers every synthesis and the TTS cache stores the spoken form.
+"""
+import json
+import re
+import unicodedata
+from dataclasses import dataclass
+from typing import Callable, Dict, Optional, Tuple
+from xml.sax.saxutils import escape, quoteattr
+
+from config import (
+    TTS_ABBREVIATIONS_FILE,
+    TTS_NORMALIZE,
+    TTS_SSML,
+    TTS_SSML_PITCH,
+    TTS_SSML_RATE,
+    logger,
+)
+from models import ALLOWED_LANGUAGES, LANGUAGE_TAGS, language_alias
+from services.hooks import HookContext, PipelineHooks, hooks
+
+# Longer than this (100 crore and up) a number without grouping commas is more likely an ID than an amount.
+_MAX_NUMBER_DIGITS = 9
+
+
+@dataclass(frozen=True)
+class Spoken:
+    """How one language says numbers and the tokens around them."""
+
+    below_100: Callable[[int], str]
+    hundreds: Tuple[str, ...]  # 100 .. 900
+    hundreds_joined: Tuple[str, ...]  # the same, followed by the rest of the number
+    # (value, word, word followed by the rest of the number, count word for exactly one), largest first
+    scales: Tuple[Tuple[int, str, str, str], ...]
+    point: str
+    months: Tuple[str, ...]
+    rupees: str
+    paise: str
+    dollars: str
+    percent: str
+    percent_first: bool  # "ಶೇಕಡಾ ಐವತ್ತು", not "ಐವತ್ತು ಶೇಕಡಾ"
+    rupee_symbols: Tuple[str, ...]
+    abbreviations: Dict[str, str]
+    units: Dict[str, str]
+
+
+_HINDI_BELOW_100 = (
+    "शून्य एक दो तीन चार पाँच छह सात आठ नौ "
+    "दस ग्यारह बारह तेरह चौदह पंद्रह सोलह सत्रह अठारह उन्नीस "
+    "बीस इक्कीस बाईस तेईस चौबीस पच्चीस छब्बीस सत्ताईस अट्ठाईस उनतीस "
+    "तीस इकतीस बत्तीस तैंतीस चौंतीस पैंतीस छत्तीस सैंतीस अड़तीस उनतालीस "
+    "चालीस इकतालीस बयालीस तैंतालीस चवालीस पैंतालीस छियालीस सैंतालीस अड़तालीस उनचास "
+    "पचास इक्यावन बावन तिरपन चौवन पचपन छप्पन सत्तावन अट्ठावन उनसठ "
+    "साठ इकसठ बासठ तिरसठ चौंसठ पैंसठ छियासठ सड़सठ अड़सठ उनहत्तर "
+    "सत्तर इकहत्तर बहत्तर तिहत्तर चौहत्तर पचहत्तर छिहत्तर सतहत्तर अठहत्तर उन्यासी "
+    "अस्सी इक्यासी बयासी तिरासी चौरासी पचासी छियासी सत्तासी अट्ठासी नवासी "
+    "नब्बे इक्यानबे बानबे तिरानबे चौरानबे पंचानबे छियानबे सत्तानबे अट्ठानबे निन्यानबे"
+).split()
+
+_KANNADA_BELOW_20 = (
+    "ಸೊನ್ನೆ ಒಂದು ಎರಡು ಮೂರು ನಾಲ್ಕು ಐದು ಆರು ಏಳು ಎಂಟು ಒಂಬತ್ತು "
+    "ಹತ್ತು ಹನ್ನೊಂದು ಹನ್ನೆರಡು ಹದಿಮೂರು ಹದಿನಾಲ್ಕು ಹದಿನೈದು ಹದಿನಾರು ಹದಿನೇಳು ಹದಿನೆಂಟು ಹತ್ತೊಂಬತ್ತು"
+).split()
+_KANNADA_TENS = "ಇಪ್ಪತ್ತು ಮೂವತ್ತು ನಲವತ್ತು ಐವತ್ತು ಅರವತ್ತು ಎಪ್ಪತ್ತು ಎಂಬತ್ತು ತೊಂಬತ್ತು".split()
+# The units as they join a tens word that has lost its final ು: ಇಪ್ಪತ್ತ + ೊಂದು = ಇಪ್ಪತ್ತೊಂದು, ಇಪ್ಪತ್ತ + ಮೂರು.
+_KANNADA_JOINED_UNITS = ("", "ೊಂದು", "ೆರಡು", "ಮೂರು", "ನಾಲ್ಕು", "ೈದು", "ಾರು", "ೇಳು", "ೆಂಟು", "ೊಂಬತ್ತು")
+_KANNADA_HUNDREDS = "ನೂರು ಇನ್ನೂರು ಮುನ್ನೂರು ನಾನೂರು ಐನೂರು ಆರುನೂರು ಏಳುನೂರು ಎಂಟುನೂರು ಒಂಬೈನೂರು".split()
+
+_TAMIL_BELOW_20 = (
+    "பூஜ்ஜியம் ஒன்று இரண்டு மூன்று நான்கு ஐந்து ஆறு ஏழு எட்டு ஒன்பது "
+    "பத்து பதினொன்று பன்னிரண்டு பதிமூன்று பதினான்கு பதினைந்து பதினாறு பதினேழு பதினெட்டு பத்தொன்பது"
+).split()
+_TAMIL_TENS = "இருபது முப்பது நாற்பது ஐம்பது அறுபது எழுபது எண்பது தொண்ணூறு".split()
+_TAMIL_TENS_JOINED = "இருபத்து முப்பத்து நாற்பத்து ஐம்பத்து அறுபத்து எழுபத்து எண்பத்து தொண்ணூற்று".split()
+
+
+def _hindi_below_100(n: int) -> str:
+    return _HINDI_BELOW_100[n]
+
+
+def _kannada_below_100(n: int) -> str:
+    if n < 20:
+        return _KANNADA_BELOW_20[n]
+    tens, units = divmod(n, 10)
+    word = _KANNADA_TENS[tens - 2]
+    return word if not units else word[:-1] + _KANNADA_JOINED_UNITS[units]
+
+
+def _tamil_below_100(n: int) -> str:
+    if n < 20:
+        return _TAMIL_BELOW_20[n]
+    tens, units = divmod(n, 10)
+    return _TAMIL_TENS[tens - 2] if not units else f"{_TAMIL_TENS_JOINED[tens - 2]} {_TAMIL_BELOW_20[units]}"
+
+
+_LANGUAGES: Dict[str, Spoken] = {
+    "hindi": Spoken(
+        below_100=_hindi_below_100,
+        hundreds=tuple(f"{_HINDI_BELOW_100[n]} सौ" for n in range(1, 10)),
+        hundreds_joined=tuple(f"{_HINDI_BELOW_100[n]} सौ" for n in range(1, 10)),
+        scales=((10 ** 7, "करोड़", "करोड़", "एक"), (10 ** 5, "लाख", "लाख", "एक"), (1000, "हज़ार", "हज़ार", "एक")),
+        point="दशमलव",
+        months=tuple("जनवरी फ़रवरी मार्च अप्रैल मई जून जुलाई अगस्त सितंबर अक्टूबर नवंबर दिसंबर".split()),
+        rupees="रुपये",
+        paise="पैसे",
+        dollars="डॉलर",
+        percent="प्रतिशत",
+        percent_first=False,
+        rupee_symbols=("रु.", "रु"),
+        abbreviations={"डॉ.": "डॉक्टर", "Dr.": "डॉक्टर", "कि.मी.": "किलोमीटर", "etc.": "वगैरह"},
+        units={"km": "किलोमीटर", "kg": "किलोग्राम", "cm": "सेंटीमीटर", "mm": "मिलीमीटर", "ml": "मिलीलीटर", "mg": "मिलीग्राम"},
+    ),
+    "kannada": Spoken(
+        below_100=_kannada_below_100,
+        hundreds=tuple(_KANNADA_HUNDREDS),
+        hundreds_joined=tuple(word[:-1] for word in _KANNADA_HUNDREDS),  # ನೂರ ಇಪ್ಪತ್ತು
+        scales=((10 ** 7, "ಕೋಟಿ", "ಕೋಟಿ", "ಒಂದು"), (10 ** 5, "ಲಕ್ಷ", "ಲಕ್ಷದ", "ಒಂದು"), (1000, "ಸಾವಿರ", "ಸಾವಿರದ", "ಒಂದು")),
+        point="ಬಿಂದು",
+        months=tuple(
+            "ಜನವರಿ ಫೆಬ್ರವರಿ ಮಾರ್ಚ್ ಏಪ್ರಿಲ್ ಮೇ ಜೂನ್ ಜುಲೈ ಆಗಸ್ಟ್ ಸೆಪ್ಟೆಂಬರ್ ಅಕ್ಟೋಬರ್ ನವೆಂಬರ್ ಡಿಸೆಂಬರ್".split()
+        ),
+        rupees="ರೂಪಾಯಿ",
+        paise="ಪೈಸೆ",
+        dollars="ಡಾಲರ್",
+        percent="ಶೇಕಡಾ",
+        percent_first=True,
+        rupee_symbols=("ರೂ.", "ರೂ"),
+        abbreviations={"ಡಾ.": "ಡಾಕ್ಟರ್", "Dr.": "ಡಾಕ್ಟರ್", "ಕಿ.ಮೀ.": "ಕಿಲೋಮೀಟರ್", "etc.": "ಇತ್ಯಾದಿ"},
+        units={"km": "ಕಿಲೋಮೀಟರ್", "kg": "ಕಿಲೋಗ್ರಾಂ", "cm": "ಸೆಂಟಿಮೀಟರ್", "mm": "ಮಿಲಿಮೀಟರ್", "ml": "ಮಿಲಿಲೀಟರ್", "mg": "ಮಿಲಿಗ್ರಾಂ"},
+    ),
+    "tamil": Spoken(
+        below_100=_tamil_below_100,
+        hundreds=tuple("நூறு இருநூறு முந்நூறு நானூறு ஐநூறு அறுநூறு எழுநூறு எண்ணூறு தொள்ளாயிரம்".split()),
+        hundreds_joined=tuple(
+            "நூற்று இருநூற்று முந்நூற்று நானூற்று ஐநூற்று அறுநூற்று எழுநூற்று எண்ணூற்று தொள்ளாயிரத்து".split()
+        ),
+        scales=((10 ** 7, "கோடி", "கோடியே", "ஒரு"), (10 ** 5, "லட்சம்", "லட்சத்து", "ஒரு"), (1000, "ஆயிரம்", "ஆயிரத்து", "")),
+        point="புள்ளி",
+        months=tuple(
+            "ஜனவரி பிப்ரவரி மார்ச் ஏப்ரல் மே ஜூன் ஜூலை ஆகஸ்ட் செப்டம்பர் அக்டோபர் நவம்பர் டிசம்பர்".split()
+        ),
+        rupees="ரூபாய்",
+        paise="பைசா",
+        dollars="டாலர்",
+        percent="சதவீதம்",
+        percent_first=False,
+        rupee_symbols=("ரூ.", "ரூ"),
+        abbreviations={"Dr.": "டாக்டர்", "கி.மீ.": "கிலோமீட்டர்", "etc.": "முதலியன"},
+        units={"km": "கிலோமீட்டர்", "kg": "கிலோகிராம்", "cm": "சென்டிமீட்டர்", "mm": "மில்லிமீட்டர்", "ml": "மில்லிலிட்டர்", "mg": "மில்லிகிராம்"},
+    ),
+}
+
+
+def number_words(n: int, language: str) -> str:
+    """n (>= 0) in words, in lakh and crore; ValueError for a language without number words."""
+    spoken = _LANGUAGES.get(language)
+    if spoken is None:
+        raise ValueError(f"no number words for {language!r}; expected one of {sorted(_LANGUAGES)}")
+    return _words(n, spoken)
+
+
+def _words(n: int, spoken: Spoken) -> str:
+    if n < 100:
+        return spoken.below_100(n)
+    if n < 1000:
+        count, rest = divmod(n, 100)
+        if not rest:
+            return spoken.hundreds[count - 1]
+        return f"{spoken.hundreds_joined[count - 1]} {spoken.below_100(rest)}"
+    for value, word, joined, one in spoken.scales:
+        if n >= value:
+            count, rest = divmod(n, value)
+            head = " ".join(part for part in (one if count == 1 else _words(count, spoken), joined if rest else word) if part)
+            return f"{head} {_words(rest, spoken)}" if rest else head
+    raise AssertionError("unreachable")
+
+
+def _digits(digits: str, spoken: Spoken) -> str:
+    return " ".join(spoken.below_100(int(digit)) for digit in digits)
+
+
+def _amount(whole: str, fraction: Optional[str], spoken: Spoken) -> str:
+    """A number as written (with grouping commas and a decimal part) in words."""
+    grouped, whole = "," in whole, whole.replace(",", "")
+    if (len(whole) > 1 and whole.startswith("0")) or (not grouped and len(whole) > _MAX_NUMBER_DIGITS):
+        words = _digits(whole, spoken)
+    else:
+        words = _words(int(whole), spoken)
+    return f"{words} {spoken.point} {_digits(fraction, spoken)}" if fraction else words
+
+
+# 1,250 / 12,50,000 / 1250.75; not glued to Latin letters or other digits (v2, 4G, 3rd).
+_NUMBER = r"(\d{1,3}(?:(?:,\d{3})+|(?:,\d{2})+,\d{3})|\d+)(?:\.(\d+))?"
+_NUMBER_RE = re.compile(rf"(?<![A-Za-z\d.]){_NUMBER}(?![A-Za-z\d])")
+_PERCENT_RE = re.compile(rf"(?<![A-Za-z\d.]){_NUMBER}\s?%")
+_DATE_RE = re.compile(r"(?<![\d/.-])(\d{1,2})([/.-])(\d{1,2})\2(\d{4})(?![\d/.-])")
+_ISO_DATE_RE = re.compile(r"(?<![\d/.-])(\d{4})-(\d{2})-(\d{2})(?![\d/.-])")
+_DOLLAR_RE = re.compile(rf"\$\s?{_NUMBER}(?![A-Za-z\d])")
+
+
+def _date(day: str, month: str, year: str, spoken: Spoken) -> Optional[str]:
+    if not (1 <= int(month) <= 12 and 1 <= int(day) <= 31):
+        return None
+    return f"{_words(int(day), spoken)} {spoken.months[int(month) - 1]} {_words(int(year), spoken)}"
+
+
+def _rupees(whole: str, fraction: Optional[str], spoken: Spoken) -> str:
+    words = f"{_amount(whole, None, spoken)} {spoken.rupees}"
+    paise = int((fraction + "0")[:2]) if fraction else 0
+    return f"{words} {_words(paise, spoken)} {spoken.paise}" if paise else words
+
+
+def _abbreviation_re(abbreviations: Dict[str, str]) -> Optional["re.Pattern[str]"]:
+    if not abbreviations:
+        return None
+    return re.compile("|".join(re.escape(key) for key in sorted(abbreviations, key=len, reverse=True)))
+
+
+def _letter(char: str) -> bool:
+    # Indic vowel signs are marks, not letters, but a word goes on through them.
+    return bool(char) and (char.isalpha() or unicodedata.category(char).startswith("M"))
+
+
+def _expand(m: "re.Match[str]", table: Dict[str, str]) -> str:
+    """The expansion of an abbreviation that is not part of a longer word ("Dr.Rao" and "5ಕಿ.ಮೀ." still count)."""
+    key = m.group(0)
+    before = m.string[m.start() - 1] if m.start() else ""
+    after = m.string[m.end():m.end() + 1]
+    if _letter(before) or (not key.endswith(".") and _letter(after)):
+        return key
+    return (" " if before.isdigit() else "") + table[key] + (" " if _letter(after) else "")
+
+
+def load_abbreviations(path: str = TTS_ABBREVIATIONS_FILE) -> Dict[str, Dict[str, str]]:
+    """Deployment abbreviations from path, a JSON object of language -> {abbreviation: expansion}."""
+    if not path:
+        return {}
+    with open(path, encoding="utf-8") as f:
+        specs = json.load(f)
+    if not isinstance(specs, dict):
+        raise ValueError(f"{path}: must be a JSON object of language -> {{abbreviation: expansion}}")
+    loaded: Dict[str, Dict[str, str]] = {}
+    for name, table in specs.items():
+        language = language_alias(name)
+        if language not in ALLOWED_LANGUAGES:
+            raise ValueError(f"{path}: unknown language {name!r}, expected one of {ALLOWED_LANGUAGES}")
+        if not isinstance(table, dict) or not all(
+            isinstance(key, str) and key.strip() and isinstance(value, str) for key, value in table.items()
+        ):
+            raise ValueError(f"{path}: {name!r} must map abbreviations to their expansions")
+        loaded.setdefault(language, {}).update({key.strip(): value for key, value in table.items()})
+    logger.info("Loaded TTS abbreviations for %s from %s", ", ".join(sorted(loaded)), path)
+    return loaded
+
+
+_extra_abbreviations = load_abbreviations()
+
+
+def _abbreviations(language: str) -> Dict[str, str]:
+    spoken = _LANGUAGES.get(language)
+    return {**(spoken.abbreviations if spoken else {}), **_extra_abbreviations.get(language, {})}
+
+
+_ABBREVIATION_RES = {language: _abbreviation_re(_abbreviations(language)) for language in ALLOWED_LANGUAGES}
+
+
+def normalize(text: str, language: Optional[str]) -> str:
+    """text with its numbers, dates, currency and abbreviations written out as language says them."""
+    language = language_alias(language)
+    pattern = _ABBREVIATION_RES.get(language)
+    if pattern is not None:
+        table = _abbreviations(language)
+        text = pattern.sub(lambda m: _expand(m, table), text)
+    spoken = _LANGUAGES.get(language)
+    if spoken is None:
+        return text
+    text = _ISO_DATE_RE.sub(lambda m: _date(m.group(3), m.group(2), m.group(1), spoken) or m.group(0), text)
+    text = _DATE_RE.sub(lambda m: _date(m.group(1), m.group(3), m.group(4), spoken) or m.group(0), text)
+    symbols = "|".join(re.escape(symbol) for symbol in ("₹", "Rs.", "Rs", "INR", *spoken.rupee_symbols))
+    text = re.sub(
+        rf"(?<![^\W\d_])(?:{symbols})\s?{_NUMBER}(?![A-Za-z\d])", lambda m: _rupees(m.group(1), m.group(2), spoken), text
+    )
+    text = _DOLLAR_RE.sub(lambda m: f"{_amount(m.group(1), m.group(2), spoken)} {spoken.dollars}", text)
+
+    def percent(m: "re.Match[str]") -> str:
+        words = _amount(m.group(1), m.group(2), spoken)
+        return f"{spoken.percent} {words}" if spoken.percent_first else f"{words} {spoken.percent}"
+
+    text = _PERCENT_RE.sub(percent, text)
+    units = "|".join(re.escape(unit) for unit in sorted(spoken.units, key=len, reverse=True))
+    text = re.sub(rf"(?<=\d)\s?({units})(?![A-Za-z])", lambda m: f" {spoken.units[m.group(1)]}", text)
+    return _NUMBER_RE.sub(lambda m: _amount(m.group(1), m.group(2), spoken), text)
+
+
+def ssml(text: str, language: Optional[str], rate: str = TTS_SSML_RATE, pitch: str = TTS_SSML_PITCH) -> str:
+    """text as an SSML document in language, with prosody when rate or pitch is set; SSML is left as it is."""
+    if text.lstrip().startswith("<speak"):
+        return text
+    body = escape(text)
+    prosody = "".join(f" {name}={quoteattr(value)}" for name, value in (("rate", rate), ("pitch", pitch)) if value)
+    if prosody:
+        body = f"<prosody{prosody}>{body}</prosody>"
+    tag = LANGUAGE_TAGS.get(language_alias(language) or "")
+    return f"<speak{f' xml:lang={quoteattr(tag)}' if tag else ''}>{body}</speak>"
+
+
+def _spoken_text(ctx: HookContext, text: str) -> Optional[str]:
+    spoken = normalize(text, ctx.language) if TTS_NORMALIZE else text
+    if TTS_SSML:
+        spoken = ssml(spoken, ctx.language)
+    return spoken if spoken != text else None
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the normalization before_tts hook; False when it is off or already registered."""
+    if not (TTS_NORMALIZE or TTS_SSML) or _spoken_text in registry.registered("before_tts"):
+        return False
+    registry.register("before_tts", _spoken_text)
+    return True
@@ -0,0 +1,224 @@
+"""Tools the LLM may call (OpenAI-style function calling) before it answers.
+
+DWANI_LLM_TOOLS="time,weather,knowledge" offers those tools with every conversational LLM call:
+the pipeline's replies, and call_llm without a system prompt; not tasks that bring their own
+prompt, such as punctuation or translation. When the model asks for one, the server runs it,
+adds the result to the conversation as a tool message and asks again, for up to
+DWANI_LLM_TOOL_ROUNDS rounds; the last request offers no tools, so the spoken reply is always
+text. A tool that fails or takes longer than DWANI_LLM_TOOL_TIMEOUT seconds returns an error the
+model can explain, rather than failing the turn.
+
+Built-in tools:
+
+    time       current date and time in an IANA time zone (default DWANI_TOOL_TIMEZONE)
+    weather    GET DWANI_TOOL_WEATHER_URL with ?city= (and ?language=); the reply is the result
+    knowledge  the entries of DWANI_TOOL_KNOWLEDGE_FILE ({"title": "text", ...}) that share the
+               most words with the query
+
+DWANI_TOOLS_FILE adds HTTP tools: {"name": {"description": ..., "parameters": <JSON schema>,
+"url": ...}}; the model's arguments are POSTed as JSON and the reply (text or JSON) is the
+result. Plugins (DWANI_PIPELINE_PLUGINS) may register_tool() their own. Results are cut to
+DWANI_LLM_TOOL_RESULT_CHARS characters.
+"""
+import asyncio
+import json
+import re
+from contextlib import contextmanager
+from contextvars import ContextVar
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import Any, Awaitable, Callable, Dict, Iterator, List, Optional
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+from prometheus_client import Counter
+
+from config import (
+    LLM_TOOL_RESULT_CHARS,
+    LLM_TOOL_TIMEOUT,
+    LLM_TOOLS,
+    TOOL_KNOWLEDGE_FILE,
+    TOOL_TIMEZONE,
+    TOOL_WEATHER_URL,
+    TOOLS_FILE,
+    logger,
+)
+from services.http_client import http_client
+
+_CALLS = Counter("dwani_llm_tool_calls_total", "Tool calls the LLM asked for", ["tool", "outcome"])
+_WORD = re.compile(r"\w+")
+_conversation: ContextVar[bool] = ContextVar("dwani_llm_conversation", default=False)
+
+ToolFunction = Callable[[Dict[str, Any]], Awaitable[str]]
+
+
+@dataclass(frozen=True)
+class Tool:
+    name: str
+    description: str
+    run: ToolFunction
+    parameters: Dict[str, Any] = field(default_factory=lambda: {"type": "object", "properties": {}})
+
+    def spec(self) -> Dict[str, Any]:
+        """The tool as offered in a chat completions request."""
+        return {"type": "function", "function": {"name": self.name, "description": self.description, "parameters": self.parameters}}
+
+
+tools: Dict[str, Tool] = {}
+
+
+def register_tool(tool: Tool) -> None:
+    """Make tool available to DWANI_LLM_TOOLS (plugins call this from their register function)."""
+    tools[tool.name] = tool
+
+
+async def _time(arguments: Dict[str, Any]) -> str:
+    name = str(arguments.get("timezone") or TOOL_TIMEZONE)
+    try:
+        now = datetime.now(ZoneInfo(name))
+    except (ZoneInfoNotFoundError, ValueError):
+        return f"error: unknown time zone {name!r}"
+    return json.dumps({"timezone": name, "datetime": now.isoformat(timespec="minutes"), "weekday": now.strftime("%A")})
+
+
+def _get_text(response) -> str:
+    response.raise_for_status()
+    return response.text
+
+
+async def _weather(arguments: Dict[str, Any]) -> str:
+    city = str(arguments.get("city") or "").strip()
+    if not city:
+        return "error: city is required"
+    params = {"city": city, **({"language": str(arguments["language"])} if arguments.get("language") else {})}
+    return _get_text(await http_client().get(TOOL_WEATHER_URL, params=params, timeout=LLM_TOOL_TIMEOUT))
+
+
+def _load_knowledge(path: str) -> Dict[str, str]:
+    if not path:
+        return {}
+    with open(path, encoding="utf-8") as f:
+        entries = json.load(f)
+    if not isinstance(entries, dict) or not all(isinstance(v, str) for v in entries.values()):
+        raise ValueError(f"{path} must be an object of title -> text")
+    return entries
+
+
+def _words(text: str) -> set:
+    return {w.lower() for w in _WORD.findall(text) if len(w) > 2}
+
+
+knowledge = _load_knowledge(TOOL_KNOWLEDGE_FILE)
+
+
+async def _knowledge(arguments: Dict[str, Any]) -> str:
+    query = _words(str(arguments.get("query") or ""))
+    scored = sorted(
+        ((len(query & _words(f"{title} {text}")), title) for title, text in knowledge.items()),
+        key=lambda item: -item[0],
+    )
+    found = [{"title": title, "text": knowledge[title]} for score, title in scored[:3] if score > 0]
+    return json.dumps(found, ensure_ascii=False) if found else "no matching entries"
+
+
+def _http_tool(url: str) -> ToolFunction:
+    async def run(arguments: Dict[str, Any]) -> str:
+        return _get_text(await http_client().post(url, json=arguments, timeout=LLM_TOOL_TIMEOUT))
+
+    return run
+
+
+def load_tools(path: str = TOOLS_FILE) -> Dict[str, Tool]:
+    """HTTP tools in path (see the module docstring); {} when it is not set."""
+    if not path:
+        return {}
+    with open(path, encoding="utf-8") as f:
+        specs = json.load(f)
+    if not isinstance(specs, dict):
+        raise ValueError(f"{path} must be an object of tool name -> spec")
+    loaded = {}
+    for name, spec in specs.items():
+        if not re.fullmatch(r"[A-Za-z0-9_-]{1,64}", name):
+            raise ValueError(f"{path}: tool name {name!r} must be 1-64 letters, digits, _ or -")
+        if not isinstance(spec, dict) or not isinstance(spec.get("url"), str) or not isinstance(spec.get("description"), str):
+            raise ValueError(f"{path}: tool {name!r} needs a url and a description")
+        parameters = spec.get("parameters") or {"type": "object", "properties": {}}
+        if not isinstance(parameters, dict) or parameters.get("type") != "object":
+            raise ValueError(f"{path}: parameters of tool {name!r} must be a JSON schema of type object")
+        loaded[name] = Tool(name, spec["description"], _http_tool(spec["url"]), parameters)
+    logger.info("Loaded %s tools from %s", len(loaded), path)
+    return loaded
+
+
+register_tool(Tool(
+    "time",
+    "Current date, time and weekday. Use for questions about the time or date.",
+    _time,
+    {"type": "object", "properties": {"timezone": {"type": "string", "description": f"IANA time zone, default {TOOL_TIMEZONE}"}}},
+))
+if TOOL_WEATHER_URL:
+    register_tool(Tool(
+        "weather",
+        "Current weather and forecast for a city.",
+        _weather,
+        {"type": "object", "properties": {"city": {"type": "string"}, "language": {"type": "string"}}, "required": ["city"]},
+    ))
+if knowledge:
+    register_tool(Tool(
+        "knowledge",
+        "Look up facts in the operator's knowledge base. Use before answering questions it may cover.",
+        _knowledge,
+        {"type": "object", "properties": {"query": {"type": "string", "description": "What to look up"}}, "required": ["query"]},
+    ))
+for _tool in load_tools().values():
+    register_tool(_tool)
+
+
+def check_tools(names: Optional[List[str]] = None) -> None:
+    """At startup, after plugins: ValueError when DWANI_LLM_TOOLS names a tool that is not available."""
+    unknown = [name for name in (LLM_TOOLS if names is None else names) if name not in tools]
+    if unknown:
+        raise ValueError(f"DWANI_LLM_TOOLS names unavailable tools {unknown}; available: {sorted(tools)}")
+
+
+@contextmanager
+def conversation() -> Iterator[None]:
+    """Offer tools to LLM calls for the duration, including ones with a persona's or the slots' system prompt."""
+    token = _conversation.set(True)
+    try:
+        yield
+    finally:
+        _conversation.reset(token)
+
+
+def in_conversation() -> bool:
+    return _conversation.get()
+
+
+def offered() -> List[Dict[str, Any]]:
+    """Specs of the enabled tools for a chat completions request; [] when tool calling is off."""
+    return [tools[name].spec() for name in LLM_TOOLS if name in tools]
+
+
+async def run_tool_call(call: Any) -> Dict[str, Any]:
+    """The tool message answering one of the model's tool calls."""
+    name = call.function.name
+    tool = tools.get(name)
+    try:
+        arguments = json.loads(call.function.arguments or "{}")
+    except json.JSONDecodeError:
+        arguments = None
+    if tool is None or name not in LLM_TOOLS or not isinstance(arguments, dict):
+        _CALLS.labels(tool=name if tool is not None else "unknown", outcome="invalid").inc()
+        result = f"error: no tool {name!r}" if tool is None or name not in LLM_TOOLS else "error: arguments must be a JSON object"
+    else:
+        try:
+            result = await asyncio.wait_for(tool.run(arguments), LLM_TOOL_TIMEOUT)
+            _CALLS.labels(tool=name, outcome="ok").inc()
+        except asyncio.TimeoutError:
+            _CALLS.labels(tool=name, outcome="timeout").inc()
+            result = "error: the tool took too long"
+        except Exception as exc:
+            logger.warning("Tool %s failed: %s", name, exc)
+            _CALLS.labels(tool=name, outcome="error").inc()
+            result = "error: the tool failed"
+    return {"role": "tool", "tool_call_id": call.id, "content": str(result)[:LLM_TOOL_RESULT_CHARS]}
@@ -1,15 +1,33 @@
 import os
 import base64
+import io
 import json
+import re
 import time
-from typing import Optional
+from dataclasses import dataclass, field
+from typing import IO, Any, AsyncIterator, Callable, Dict, List, Optional, Tuple
 
 import httpx
+from prometheus_client import Counter
 from fastapi import HTTPException, UploadFile
+from starlette.concurrency import run_in_threadpool
 
-from config import ASR_TIMEOUT, MAX_UPLOAD_BYTES, logger
-from models import TranscriptionResponse
+from config import ASR_DIARIZE_FIELD, ASR_NBEST, ASR_TIMEOUT, LANGUAGE_CONFIDENCE_MIN, MAX_UPLOAD_BYTES, NEIGHBOR_LANGUAGES, logger
+from models import LANGUAGE_TAGS, SpeakerSegmentResponse, TranscriptionResponse
+from services import backends, offline, shadow
+from services.balancer import server_error
+from services.buffers import BufferPool
+from services.deadline import StageTimeout, stage_timeout
+from services.errors import RequestError, StageError
+from services.fingerprint import audio_fingerprint, transcript_cache
+from services.hooks import current_context, run_hooks
+from services.http_client import http_client
+from services.language_id import normalize_language
+from services.prompts import language_display_name
+from services.provider_templates import ProviderTemplate, asr_body, chat_completion_body, render, template_for, with_query
 from services.retry import retry_async
+from services.sanity import garbage, has_words
+from services.uploads import check_audio_upload, upload_digest
 
 
 _TRANSCRIBE_TASK_PROMPT = (
@@ -17,6 +35,125 @@ _TRANSCRIBE_TASK_PROMPT = (
     "Output only the transcribed text. "
     "Do not translate, explain, answer questions, or add labels or commentary."
 )
+_DIARIZE_TASK_PROMPT = (
+    "Transcribe the audio verbatim in its native script. Several people may be speaking: start "
+    "each speaker's turn on a new line with 'Speaker 1:', 'Speaker 2:' and so on, numbering "
+    "speakers in the order they first speak. "
+    "Output only the transcript. "
+    "Do not translate, explain, answer questions, or add other labels or commentary."
+)
+_SPEAKER_LABEL = re.compile(r"^[\s*_\[(]*(?:speaker|spk)\s*(\d+)[\s*_\])]*[:\-–][\s*_]*(.*)$", re.IGNORECASE)
+_FALLBACKS = Counter(
+    "dwani_asr_language_fallback_total", "Low-confidence transcripts retried in neighbor languages, by outcome", ["outcome"]
+)
+
+
+@dataclass
+class _Recognized:
+    """One ASR call's result: the raw transcript and what the backend reported about it."""
+
+    text: str
+    language: Optional[str]
+    language_confidence: Optional[float]
+    confidence: Optional[float]
+    segments: Optional[List[SpeakerSegmentResponse]] = None  # from a backend that diarizes natively
+    alternatives: List[str] = field(default_factory=list)  # the backend's other hypotheses, raw
+
+
+@dataclass
+class SpeakerTurn:
+    speaker: Optional[str]
+    text: str
+
+
+def speaker_turns(text: str) -> List[SpeakerTurn]:
+    """Split a diarized transcript at its "Speaker N:" labels; unlabelled lines continue the turn before."""
+    turns: List[SpeakerTurn] = []
+    for line in text.splitlines():
+        line = line.strip()
+        if not line:
+            continue
+        match = _SPEAKER_LABEL.match(line)
+        if match:
+            turns.append(SpeakerTurn(f"Speaker {int(match.group(1))}", match.group(2).strip()))
+        elif turns:
+            turns[-1].text = f"{turns[-1].text} {line}".strip()
+        else:
+            turns.append(SpeakerTurn(None, line))
+    return [turn for turn in turns if turn.text]
+
+
+def prompted_segments(text: str) -> List[SpeakerSegmentResponse]:
+    """Untimed segments from a transcript's "Speaker N:" labels (see speaker_turns)."""
+    return [SpeakerSegmentResponse(speaker=turn.speaker, text=turn.text) for turn in speaker_turns(text)]
+
+
+def _seconds(value: Any) -> Optional[float]:
+    try:
+        return round(float(value), 3) if value is not None else None
+    except (TypeError, ValueError):
+        return None
+
+
+def _segments(body: Dict[str, Any], choice: Dict[str, Any]) -> Optional[List[SpeakerSegmentResponse]]:
+    """Segments of a diarizing backend ({"speaker", "text", "start", "end"} in the body, choice or message), if any.
+
+    Backends name speakers their own way (SPEAKER_00, 0, "A"), so they are renumbered
+    "Speaker 1", "Speaker 2" and so on in the order they first speak, like prompted labels.
+    """
+    for obj in (body, choice, choice.get("message") or {}):
+        raw = obj.get("segments")
+        if isinstance(raw, list) and raw:
+            break
+    else:
+        return None
+    labels: Dict[str, str] = {}
+    segments = []
+    for item in raw:
+        if not isinstance(item, dict) or not str(item.get("text") or "").strip():
+            continue
+        speaker = str(item.get("speaker") if item.get("speaker") is not None else "").strip()
+        if speaker and speaker not in labels:
+            labels[speaker] = f"Speaker {len(labels) + 1}"
+        segments.append(SpeakerSegmentResponse(
+            speaker=labels.get(speaker),
+            text=" ".join(str(item["text"]).split()),
+            start_seconds=_seconds(item.get("start")),
+            end_seconds=_seconds(item.get("end")),
+        ))
+    return segments or None
+
+
+def _labelled(segments: List[SpeakerSegmentResponse]) -> str:
+    return "\n".join(f"{segment.speaker}: {segment.text}" if segment.speaker else segment.text for segment in segments)
+
+
+def _transcribe_prompt(language: Optional[str], diarize: bool = False) -> str:
+    task = _DIARIZE_TASK_PROMPT if diarize else _TRANSCRIBE_TASK_PROMPT
+    if not language:
+        return task
+    return f"The speech is in {language_display_name(language)}. {task}"
+
+
+def _score(body: Dict[str, Any], choice: Dict[str, Any], *names: str) -> Optional[float]:
+    """The first of names reported in the ASR response body or its first choice, as a float."""
+    for obj in (body, choice):
+        for name in names:
+            if obj.get(name) is not None:
+                try:
+                    return float(obj[name])
+                except (TypeError, ValueError):
+                    return None
+    return None
+
+
+def _alternatives(body: Dict[str, Any], choices: List[Dict[str, Any]]) -> List[str]:
+    """Other hypotheses: the choices after the first (DWANI_ASR_NBEST), or an "alternatives" list in the body or first choice."""
+    texts = [(choice.get("message") or {}).get("content") for choice in choices[1:]]
+    for obj in (body, choices[0] if choices else {}):
+        for item in obj.get("alternatives") or []:
+            texts.append(item.get("text") or item.get("transcript") if isinstance(item, dict) else item)
+    return [text.strip() for text in texts if isinstance(text, str) and text.strip()]
 
 
 def _transcription_only_text(raw: str) -> str:
@@ -37,20 +174,58 @@ def _transcription_only_text(raw: str) -> str:
     return "\n".join(out_lines).strip() or raw.strip()
 
 
-async def transcribe_audio(file: UploadFile, request_id: Optional[str] = None) -> TranscriptionResponse:
-    start_time = time.time()
-    file_content = await file.read()
-    if len(file_content) > MAX_UPLOAD_BYTES:
-        raise HTTPException(status_code=413, detail=f"File too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+# Multiple of 3 so each chunk base64-encodes without padding mid-stream.
+_UPLOAD_CHUNK_BYTES = 3 * 64 * 1024
+_AUDIO_PLACEHOLDER = "__DWANI_AUDIO_BASE64__"
+_UPLOAD_BUFFERS = BufferPool("asr_upload", _UPLOAD_CHUNK_BYTES)
+_JSON_ENCODER = json.JSONEncoder()
+
 
-    if not file_content:
-        raise HTTPException(status_code=400, detail="Empty audio file")
+async def _upload_size(file: UploadFile) -> int:
+    if file.size is not None:
+        return file.size
+    file.file.seek(0, os.SEEK_END)
+    size = file.file.tell()
+    await file.seek(0)
+    return size
 
+
+def _readinto(fileobj: IO[bytes], view: memoryview) -> int:
+    readinto = getattr(fileobj, "readinto", None)
+    if readinto is not None:
+        return readinto(view) or 0
+    # SpooledTemporaryFile only grew readinto() in Python 3.11.
+    data = fileobj.read(len(view))
+    view[: len(data)] = data
+    return len(data)
+
+
+def _streamed_json_body(file: UploadFile, prefix: bytes, suffix: bytes) -> Callable[[], AsyncIterator[bytes]]:
+    """Build a body factory that base64-encodes the upload chunk by chunk between prefix and suffix."""
+
+    async def _body() -> AsyncIterator[bytes]:
+        await file.seek(0)
+        yield prefix
+        # Pooled read buffer; the only per-chunk allocation is the encoded output.
+        with _UPLOAD_BUFFERS.buffer() as view:
+            while True:
+                n = await run_in_threadpool(_readinto, file.file, view)
+                if not n:
+                    break
+                yield base64.standard_b64encode(view[:n])
+        yield suffix
+
+    return _body
+
+
+async def _recognize(
+    file: UploadFile, size: int, language: Optional[str], request_id: Optional[str], diarize: bool
+) -> _Recognized:
+    """One ASR call."""
     mime = file.content_type or "audio/wav"
-    b64 = base64.standard_b64encode(file_content).decode("ascii")
-    audio_data_url = f"data:{mime};base64,{b64}"
+    audio_data_url = f"data:{mime};base64,{_AUDIO_PLACEHOLDER}"
 
-    chat_url = os.getenv("DWANI_CHAT_COMPLETIONS_URL", "http://localhost:8000/v1/chat/completions")
+    asr = backends.asr_pool()
     payload = {
         "model": "gemma4",
         "messages": [
@@ -58,27 +233,56 @@ async def transcribe_audio(file: UploadFile, request_id: Optional[str] = None) -
                 "role": "user",
                 "content": [
                     {"type": "audio_url", "audio_url": {"url": audio_data_url}},
-                    {"type": "text", "text": _TRANSCRIBE_TASK_PROMPT},
+                    {"type": "text", "text": _transcribe_prompt(language, diarize)},
                 ],
             }
         ],
         "temperature": 0.2,
         "max_tokens": 512,
     }
+    if diarize and ASR_DIARIZE_FIELD:
+        payload[ASR_DIARIZE_FIELD] = True
+    if ASR_NBEST > 1:
+        payload["n"] = ASR_NBEST
+    tag = LANGUAGE_TAGS.get(language or "")
+    variables = {
+        "audio_data_url": audio_data_url,
+        "mime_type": mime,
+        "language": language,
+        "language_code": tag.split("-")[0] if tag else None,
+        "language_tag": tag,
+        "prompt": _transcribe_prompt(language, diarize),
+    }
+    headers = {"Content-Type": "application/json", **backends.auth_headers("asr")}
+    if request_id:
+        headers["X-Request-ID"] = request_id
+
+    sent_with: Dict[str, Optional[ProviderTemplate]] = {"template": None}
+
+    async def _post(chat_url: str) -> httpx.Response:
+        # A backend with a provider template (services/provider_templates.py) gets its own body shape.
+        template = sent_with["template"] = template_for("asr", chat_url)
+        request = payload if template is None or template.request is None else asr_body(template, variables, _AUDIO_PLACEHOLDER)
+        # The audio is never held in memory as a whole: the JSON envelope is split around
+        # a placeholder and the upload is base64-encoded into the gap while sending.
+        prefix, suffix = (part.encode("utf-8") for part in _JSON_ENCODER.encode(request).split(_AUDIO_PLACEHOLDER, 1))
+        content_length = len(prefix) + 4 * ((size + 2) // 3) + len(suffix)
+        return await http_client().post(
+            with_query(chat_url, render(template.query, variables)) if template is not None else chat_url,
+            headers={**headers, "Content-Length": str(content_length)},
+            content=_streamed_json_body(file, prefix, suffix)(),
+            timeout=stage_timeout("asr", ASR_TIMEOUT),
+        )
 
     async def _do():
         try:
-            async with httpx.AsyncClient(timeout=ASR_TIMEOUT) as client:
-                headers = {"Content-Type": "application/json"}
-                if request_id:
-                    headers["X-Request-ID"] = request_id
-                return await client.post(chat_url, headers=headers, json=payload)
+            return await asr.call(_post, failed_result=server_error)
         except httpx.TimeoutException:
             logger.error("Chat completions transcription timed out")
-            raise HTTPException(status_code=504, detail="Transcription service timeout")
+            raise StageTimeout("asr")
         except httpx.RequestError as e:
             logger.error(f"Chat completions request failed: {e}")
-            raise HTTPException(status_code=500, detail=f"Transcription failed: {e}")
+            raise StageError("asr", detail="ASR service unavailable", error_code="asr_unavailable")
 
     try:
         response = await retry_async(_do)
@@ -86,33 +290,151 @@ async def transcribe_audio(file: UploadFile, request_id: Optional[str] = None) -
         raise
     except Exception as e:
         logger.error(f"Transcription request failed: {e}")
-        raise HTTPException(status_code=500, detail=f"Transcription failed: {str(e)}")
+        raise StageError("asr")
 
     if response.status_code != 200:
-        logger.debug(f"Transcription error: {response.status_code} - {response.text}")
-        raise HTTPException(
-            status_code=502,
-            detail=f"Chat completions error: {response.status_code} {response.text}",
-        )
+        logger.error(f"Transcription error: {response.status_code} - {response.text}")
+        raise StageError("asr", detail=f"ASR service returned HTTP {response.status_code}")
 
     try:
         body = response.json()
+        template = sent_with["template"]
+        if template is not None and template.response:
+            body = chat_completion_body(template, body)
         choices = body.get("choices") or []
         text = ""
+        reported = body.get("language")
+        choice = choices[0] if choices else {}
+        language_confidence = _score(body, choice, "language_confidence", "language_probability")
+        confidence = _score(body, choice, "confidence")
+        segments = _segments(body, choice) if diarize else None
+        alternatives = _alternatives(body, choices)
         if choices:
             msg = choices[0].get("message") or {}
             text = (msg.get("content") or "").strip()
+            reported = reported or choices[0].get("language")
     except (json.JSONDecodeError, TypeError, KeyError) as e:
         logger.error(f"Invalid chat completions response: {e}")
-        raise HTTPException(status_code=502, detail="Invalid response from transcription service")
+        raise StageError("asr", detail="Invalid response from transcription service")
 
+    if segments:
+        text = _labelled(segments)
     if not text:
         logger.debug("Transcription empty from chat completions")
-        raise HTTPException(status_code=500, detail="Transcription failed: empty response")
+        raise StageError("asr", status_code=500, detail="Transcription failed: empty response")
+    return _Recognized(text, reported, language_confidence, confidence, segments, alternatives)
 
-    text = _transcription_only_text(text)
+
+async def _neighbor_fallback(
+    file: UploadFile, size: int, language: str, request_id: Optional[str], diarize: bool, confidence: float
+) -> Optional[Tuple[str, _Recognized]]:
+    """Transcribe again in language's neighbors; the most confident (neighbor, result), if it beats confidence."""
+    best = None
+    for neighbor in NEIGHBOR_LANGUAGES[language]:
+        try:
+            result = await _recognize(file, size, neighbor, request_id, diarize)
+        except StageError as e:
+            logger.warning("Neighbor-language transcription in %s failed: %s", neighbor, e.detail)
+            continue
+        if result.language_confidence is not None and result.language_confidence > confidence:
+            best, confidence = (neighbor, result), result.language_confidence
+    _FALLBACKS.labels(outcome="switched" if best else "kept").inc()
+    if best is not None:
+        logger.info("Low %s language confidence; transcribed as %s (confidence %.2f)", language, best[0], confidence)
+    return best
+
+
+async def _mirror(
+    file: UploadFile, language: Optional[str], request_id: Optional[str], diarize: bool, text: str, seconds: float
+) -> None:
+    """Send a copy of the upload to the shadow ASR backend (services/shadow.py), comparing its transcript with text."""
+    await file.seek(0)
+    audio = await file.read()
+    await file.seek(0)
+
+    async def _call() -> Dict[str, Any]:
+        copy = UploadFile(io.BytesIO(audio), size=len(audio), filename=file.filename, headers=file.headers)
+        result = await _recognize(copy, len(audio), language, request_id, diarize)
+        return {"shadow_agreement": shadow.agreement(text, result.text)}
+
+    shadow.mirror("asr", _call, seconds)
+
+
+async def transcribe_audio(
+    file: UploadFile,
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+    diarize: bool = False,
+) -> TranscriptionResponse:
+    """Transcribe an upload.
+
+    With diarize, each speaker's turn starts a line labelled "Speaker N:" and segments lists
+    the turns: as the backend timed them when it diarizes natively (DWANI_ASR_DIARIZE_FIELD),
+    else split from the prompted labels (see speaker_turns), without times.
+    alternatives lists the backend's other hypotheses (see _alternatives), when it returns any.
+    """
+    start_time = time.time()
+    # Before any hook, so neither plugins nor ffmpeg preprocessing see what is plainly not audio.
+    await check_audio_upload(file)
+    hook_ctx = current_context(request_id, language)
+    file = await run_hooks("before_asr", hook_ctx, file)
+    size = await _upload_size(file)
+    if size > MAX_UPLOAD_BYTES:
+        raise HTTPException(status_code=413, detail=f"File too large (max {MAX_UPLOAD_BYTES // (1024*1024)}MB)")
+
+    if size == 0:
+        raise RequestError(400, "empty_input", "Empty audio file")
+
+    key = audio_fingerprint(await upload_digest(file), language, diarize) if transcript_cache.enabled else None
+    cached = transcript_cache.get(key)
+    if cached is not None:
+        logger.debug("Transcript reused for a duplicate upload")
+        cached.text = await run_hooks("after_asr", hook_ctx, cached.text)
+        return cached
+
+    recognized_at = time.time()
+    try:
+        result = await _recognize(file, size, language, request_id, diarize)
+    except StageError as exc:
+        # A kiosk that lost its connection transcribes locally when it can (services/offline.py).
+        local = await offline.recognize(file, language, exc)
+        if local is None:
+            raise
+        result = _Recognized(local, language, None, None)
+    if shadow.sampled("asr"):
+        await _mirror(file, language, request_id, diarize, result.text, time.time() - recognized_at)
+    requested = None
+    confidence = result.language_confidence
+    if language and confidence is not None and confidence < LANGUAGE_CONFIDENCE_MIN and NEIGHBOR_LANGUAGES.get(language):
+        best = await _neighbor_fallback(file, size, language, request_id, diarize, confidence)
+        if best is not None:
+            requested, (language, result) = language, best
+    text = _transcription_only_text(result.text)
     if not text:
-        raise HTTPException(status_code=500, detail="Transcription failed: empty response")
+        raise StageError("asr", status_code=500, detail="Transcription failed: empty response")
+    if not has_words(text):
+        logger.warning("Transcript has no words: %r", text[:80])
+        raise garbage("asr", "no_words", "No words were recognized in the audio", status_code=422)
+
+    segments = None
+    if diarize:
+        segments = result.segments or prompted_segments(text)
+    alternatives = []
+    for alternative in map(_transcription_only_text, result.alternatives):
+        if alternative and alternative != text and alternative not in alternatives:
+            alternatives.append(alternative)
 
+    transcription = TranscriptionResponse(
+        text=text,
+        language=normalize_language(result.language) or (language if requested else None),
+        language_confidence=result.language_confidence,
+        requested_language=requested,
+        confidence=result.confidence,
+        segments=segments,
+        alternatives=alternatives or None,
+    )
+    # Kept before the after_asr hooks, which run again on a reused transcript.
+    transcript_cache.put(key, transcription)
+    transcription.text = await run_hooks("after_asr", hook_ctx, text)
     logger.debug(f"Transcription completed in {time.time() - start_time:.2f}s")
-    return TranscriptionResponse(text=text)
+    return transcription
@@ -0,0 +1,45 @@
+"""Translation stage for cross-lingual turns.
+
+With input_language (and output_language) on /v1/speech_to_speech, the user speaks one
+language while the LLM works in another: the transcript is translated into the turn's
+language before the LLM, and the reply is translated into output_language before TTS. The
+LLM's language is `language`, or DWANI_TRANSLATION_LANGUAGE (English) when only the user's
+is given. Translation uses an OpenAI-compatible chat model: DWANI_TRANSLATION_BASE_URL (with
+DWANI_TRANSLATION_MODEL and DWANI_TRANSLATION_API_KEY) when set, e.g. a model tuned for
+Indic languages, otherwise the conversation LLM. Failures are `translation` stage errors.
+"""
+from typing import Optional
+
+from config import TRANSLATION_MAX_TOKENS, logger
+from models import GenerationParams
+from services import backends
+from services.chat_svc import call_llm
+from services.deadline import StageTimeout
+from services.errors import StageError
+from services.latency import observe_stage
+from services.prompts import language_display_name
+
+_PROMPT = (
+    "Translate the user's text from {source} into {target}. Keep names, numbers, and the "
+    "meaning as they are. Output only the translation, with no notes or commentary."
+)
+
+
+async def translate(text: str, source: str, target: str, request_id: Optional[str] = None) -> str:
+    """text in target; unchanged when the languages are the same or there is nothing to translate."""
+    if source == target or not text.strip():
+        return text
+    prompt = _PROMPT.format(source=language_display_name(source), target=language_display_name(target))
+    try:
+        with observe_stage("translation"), backends.use_translation_backend():
+            return await call_llm(
+                text,
+                request_id=request_id,
+                generation=GenerationParams(temperature=0, max_tokens=TRANSLATION_MAX_TOKENS),
+                system_prompt=prompt,
+            )
+    except StageTimeout:
+        raise StageTimeout("translation")
+    except StageError as exc:
+        logger.error("Translation from %s to %s failed: %s", source, target, exc.detail)
+        raise StageError("translation", status_code=exc.status_code)
@@ -0,0 +1,165 @@
+"""Code-mixed input: romanized words written in the language's own script before the LLM and TTS.
+
+ASR often writes Kanglish or Hinglish speech in Latin script ("naanu office ge hogtini",
+"mujhe ticket chahiye"), which the LLM answers less well and Indic TTS voices spell out or
+skip. DWANI_TRANSLITERATE sets what happens per language ("kannada=mixed,hindi=native"):
+
+    keep     Latin-script words are left as they are (the default)
+    native   Latin-script words are written in the language's script, except
+             DWANI_TRANSLITERATE_KEEP_WORDS (brand names, say), which stay as they are
+    mixed    as native, and common English words ("office", "ticket") stay as they are too
+
+It runs on the transcript (after_asr, so the client gets it in the language's script too) and
+on the reply before TTS (before_tts), for Hindi, Marathi, Kannada, Tamil, Telugu and Malayalam.
+The romanization is informal, as ASR writes it: doubled or capital vowels are long ("aa", "ee",
+"oo"), "e" and "o" are long as in "beku", capital T, D, N, L and Sh are retroflex, "th" and
+"dh" are dental (aspirated in Hindi and Marathi), and consonant clusters are joined with a
+virama. Words run together with digits or another script are left alone.
+"""
+import re
+import unicodedata
+from typing import Dict, List, Optional, Tuple
+
+from config import TRANSLITERATE, TRANSLITERATE_KEEP_WORDS
+from services.hooks import HookContext, PipelineHooks, hooks
+
+MODES = ("keep", "native", "mixed")
+# Offset of each script's Unicode block from Devanagari's; the blocks share one layout.
+_SCRIPT_OFFSETS = {"hindi": 0, "marathi": 0, "tamil": 0x280, "telugu": 0x300, "kannada": 0x380, "malayalam": 0x400}
+# Hindi and Marathi drop a word-final consonant's vowel in speech, so it needs no virama.
+_SCHWA_DELETION = {"hindi", "marathi"}
+
+_VIRAMA = 0x94D
+# Romanization -> (independent vowel, vowel sign); "a" is the consonant's own vowel.
+_VOWELS = {
+    "aa": (0x906, 0x93E), "ai": (0x910, 0x948), "au": (0x914, 0x94C),
+    "ee": (0x908, 0x940), "ii": (0x908, 0x940), "oo": (0x90A, 0x942), "uu": (0x90A, 0x942),
+    "a": (0x905, None), "A": (0x906, 0x93E), "i": (0x907, 0x93F), "I": (0x908, 0x940),
+    "u": (0x909, 0x941), "U": (0x90A, 0x942), "e": (0x90F, 0x947), "o": (0x913, 0x94B),
+}
+_CONSONANTS = {
+    "chh": 0x91B, "shh": 0x937,
+    "kh": 0x916, "gh": 0x918, "ch": 0x91A, "jh": 0x91D, "Th": 0x920, "Dh": 0x922,
+    "th": 0x925, "dh": 0x927, "ph": 0x92B, "bh": 0x92D, "sh": 0x936, "Sh": 0x937,
+    "k": 0x915, "g": 0x917, "c": 0x915, "j": 0x91C, "T": 0x91F, "D": 0x921, "N": 0x923,
+    "t": 0x924, "d": 0x926, "n": 0x928, "p": 0x92A, "f": 0x92B, "b": 0x92C, "m": 0x92E,
+    "y": 0x92F, "r": 0x930, "l": 0x932, "L": 0x933, "v": 0x935, "w": 0x935, "s": 0x938,
+    "h": 0x939, "z": 0x91C, "q": 0x915,
+}
+# Dravidian romanization writes the dental stops t and d as "th" and "dh" ("thumba", "idhu").
+_DENTAL = {"th": 0x924, "dh": 0x926}
+_STOP_ROWS = (0x915, 0x91A, 0x91F, 0x924, 0x92A)
+_TOKENS = sorted([*_VOWELS, *_CONSONANTS], key=len, reverse=True)
+# Common English words "mixed" keeps in Latin script.
+_ENGLISH = {
+    "a", "an", "and", "are", "bank", "bill", "book", "bus", "but", "call", "can", "car", "card", "cash", "class",
+    "college", "computer", "doctor", "for", "from", "good", "hello", "help", "hi", "hospital", "hotel", "i", "in",
+    "is", "it", "job", "mobile", "money", "morning", "no", "of", "office", "ok", "okay", "on", "or", "order",
+    "phone", "please", "road", "room", "school", "shop", "sir", "sorry", "station", "thank", "thanks", "the",
+    "ticket", "time", "to", "train", "water", "we", "what", "yes", "you",
+}
+_WORD_RE = re.compile(r"(?<![\w])[A-Za-z]+(?![\w])")
+
+
+def modes(setting: Optional[str] = None) -> Dict[str, str]:
+    """DWANI_TRANSLITERATE (or setting) as language -> mode; ValueError for an unknown language or mode."""
+    parsed = {}
+    for item in (TRANSLITERATE if setting is None else setting).split(","):
+        language, _, mode = (part.strip().lower() for part in item.partition("="))
+        if not language:
+            continue
+        if language not in _SCRIPT_OFFSETS:
+            raise ValueError(f"DWANI_TRANSLITERATE names {language!r}; expected some of {sorted(_SCRIPT_OFFSETS)}")
+        if mode not in MODES:
+            raise ValueError(f"DWANI_TRANSLITERATE mode {mode!r} for {language}; expected one of {list(MODES)}")
+        parsed[language] = mode
+    return parsed
+
+
+def _char(code: int, offset: int) -> str:
+    """The letter at Devanagari code in the script at offset, or its row's plain consonant (Tamil has no gh, dh, b, ...)."""
+    letter = chr(code + offset)
+    if unicodedata.name(letter, None):
+        return letter
+    # Stops come in rows (k kh g gh ng, ch chh j jh ny, ...); Tamil keeps only the first of most.
+    return chr(max(row for row in _STOP_ROWS if row <= code) + offset) if _STOP_ROWS[0] <= code < 0x92F else letter
+
+
+def _tokens(word: str) -> List[Tuple[str, str]]:
+    """(kind, romanization) pieces of word, longest match first; lowercase matches when case does not."""
+    pieces, at = [], 0
+    while at < len(word):
+        for token in _TOKENS:
+            if word.startswith(token, at) or (token.islower() and word[at:at + len(token)].lower() == token):
+                kind = "vowel" if token in _VOWELS else "consonant"
+                pieces.append((kind, token if word.startswith(token, at) else token.lower()))
+                at += len(token)
+                break
+        else:
+            pieces.append(("other", word[at]))
+            at += 1
+    return pieces
+
+
+def transliterate_word(word: str, language: str) -> str:
+    """word, in Latin script, in language's script."""
+    offset = _SCRIPT_OFFSETS[language]
+    # A capitalized first letter is the start of a sentence or a name, not a long vowel or retroflex.
+    word = word[:1].lower() + word[1:] if not word.isupper() else word.lower()
+    out: List[str] = []
+    after_consonant = False
+    for kind, token in _tokens(word):
+        if kind == "consonant":
+            if after_consonant:
+                out.append(chr(_VIRAMA + offset))
+            code = _DENTAL.get(token) if language not in _SCHWA_DELETION else None
+            out.append(_char(code or _CONSONANTS[token], offset))
+            after_consonant = True
+        elif kind == "vowel":
+            independent, sign = _VOWELS[token]
+            if not after_consonant:
+                out.append(_char(independent, offset))
+            elif sign is not None:
+                out.append(_char(sign, offset))
+            after_consonant = False
+        else:
+            if after_consonant:
+                out.append(chr(_VIRAMA + offset))
+            out.append(token)
+            after_consonant = False
+    if after_consonant and language not in _SCHWA_DELETION:
+        out.append(chr(_VIRAMA + offset))
+    return "".join(out)
+
+
+def transliterate(text: str, language: Optional[str], mode: Optional[str] = None) -> str:
+    """text with its Latin-script words in language's script, as language's mode (or mode) says."""
+    if language is None:
+        return text
+    mode = mode or modes().get(language, "keep")
+    if mode == "keep" or language not in _SCRIPT_OFFSETS:
+        return text
+    keep = (_ENGLISH | TRANSLITERATE_KEEP_WORDS) if mode == "mixed" else TRANSLITERATE_KEEP_WORDS
+
+    def word(match: "re.Match[str]") -> str:
+        latin = match.group(0)
+        return latin if latin.lower() in keep else transliterate_word(latin, language)
+
+    return _WORD_RE.sub(word, text)
+
+
+def _native(ctx: HookContext, text: str) -> Optional[str]:
+    if not isinstance(text, str):
+        return None
+    written = transliterate(text, ctx.language)
+    return written if written != text else None
+
+
+def install(registry: PipelineHooks = hooks) -> bool:
+    """Register the after_asr and before_tts hooks; False when no language is configured or they already are."""
+    configured = modes()
+    if not any(mode != "keep" for mode in configured.values()) or _native in registry.registered("after_asr"):
+        return False
+    registry.register("after_asr", _native)
+    registry.register("before_tts", _native)
+    return True
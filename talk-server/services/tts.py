@@ -0,0 +1,328 @@
+import asyncio
+import time
+from typing import Any, AsyncIterator, Awaitable, Callable, Dict, List, Optional, Tuple
+
+import httpx
+
+from config import GARBAGE_RETRIES, MIN_TTS_AUDIO_MS, TTS_TIMEOUT, TTS_VOICES, logger
+from models import LANGUAGE_TAGS
+from services import backends, offline, shadow
+from services.audio_format import content_type_for, detect_format, non_audio_reason, override_content_type, tag_mp3
+from services.audio_metadata import metadata_frames
+from services.balancer import server_error
+from services.deadline import StageTimeout, stage_timeout
+from services.errors import StageError
+from services.hooks import current_context, run_hooks
+from services.http_client import http_client
+from services.latency import observe_stage
+from services.messages import recording
+from services.provider_templates import template_for, tts_audio, tts_request
+from services.sanity import SNIFF_BYTES, audio_too_short, garbage
+from services.tts_cache import cache_key, tts_cache
+from services.usage import record_usage
+
+
+class TTSStream:
+    """TTS audio relayed to the client chunk by chunk, from an open response or the cache."""
+
+    def __init__(
+        self,
+        response: Optional[httpx.Response],
+        first_chunk: bytes,
+        chunks: Optional[AsyncIterator[bytes]] = None,
+        content_type: Optional[str] = None,
+        on_complete: Optional[Callable[[Optional[str], bytes], None]] = None,
+    ):
+        self._response = response
+        self._first_chunk = first_chunk
+        self._source_head = first_chunk
+        self._chunks = chunks
+        self._content_type = content_type
+        self._on_complete = on_complete
+        self._on_finish: List[Callable[[bytes, bool], None]] = []
+        self._delivered: List[bytes] = []
+        self._complete = False
+        self._finished = False
+
+    @classmethod
+    def cached(cls, content_type: str, audio: bytes) -> "TTSStream":
+        return cls(None, audio, content_type=content_type)
+
+    @property
+    def content_type(self) -> Optional[str]:
+        if self._response is not None and self._content_type is None:
+            return self._response.headers.get("Content-Type")
+        return self._content_type
+
+    def header(self, name: str) -> Optional[str]:
+        """A header of the backend's response; None for cached audio, which keeps only the body."""
+        if self._response is None or not name:
+            return None
+        return self._response.headers.get(name)
+
+    def observe(self, callback: Callable[[Optional[str], bytes], None]) -> "TTSStream":
+        """Also hand the body to callback once it has been relayed in full (after the cache's on_complete)."""
+        previous = self._on_complete
+
+        def both(content_type: Optional[str], audio: bytes) -> None:
+            if previous is not None:
+                previous(content_type, audio)
+            callback(content_type, audio)
+
+        self._on_complete = both
+        return self
+
+    def on_finish(self, callback: Callable[[bytes, bool], None]) -> "TTSStream":
+        """Call callback(audio relayed, complete) once, when relaying ends or the stream is closed before the end."""
+        self._on_finish.append(callback)
+        return self
+
+    def rewrite_head(self, rewrite: Callable[[bytes], bytes]) -> "TTSStream":
+        """Replace the first bytes (all of cached audio) with rewrite(them), e.g. to put a tag in front.
+
+        Only the client gets the rewritten bytes: on_complete (the cache) still gets the audio as it came.
+        """
+        self._first_chunk = rewrite(self._first_chunk)
+        return self
+
+    @property
+    def size(self) -> Optional[int]:
+        """Length of the whole audio when it is already known (cached audio); None while it is relayed."""
+        return len(self._first_chunk) if self._chunks is None else None
+
+    @property
+    def audio_format(self) -> Optional[str]:
+        """mp3, wav, or ogg as actually returned, whatever the backend's Content-Type says."""
+        return detect_format(self.content_type, self._first_chunk[:12])
+
+    @property
+    def media_type(self) -> str:
+        return content_type_for(self.audio_format, self.content_type or "audio/mpeg")
+
+    async def iter_bytes(self) -> AsyncIterator[bytes]:
+        # Only a body relayed to the end is handed to on_complete (the cache), never a truncated one.
+        parts = [self._source_head] if self._on_complete else None
+        delivered = self._delivered if self._on_finish else None
+        try:
+            yield self._first_chunk
+            if delivered is not None:
+                delivered.append(self._first_chunk)
+            if self._chunks is not None:
+                async for chunk in self._chunks:
+                    if chunk:
+                        if parts is not None:
+                            parts.append(chunk)
+                        yield chunk
+                        if delivered is not None:
+                            delivered.append(chunk)
+            self._complete = True
+            if parts is not None:
+                self._on_complete(self.content_type, b"".join(parts))
+        finally:
+            await self.aclose()
+
+    async def read(self) -> bytes:
+        parts = []
+        async for chunk in self.iter_bytes():
+            parts.append(chunk)
+        return b"".join(parts)
+
+    async def aclose(self) -> None:
+        if self._response is not None:
+            await self._response.aclose()
+        if not self._finished:
+            self._finished = True
+            for callback in self._on_finish:
+                callback(b"".join(self._delivered), self._complete)
+
+
+def voice_for(language: Optional[str]) -> Optional[str]:
+    """The DWANI_TTS_VOICES voice of language; None for the backend's default."""
+    return TTS_VOICES.get(language) if language else None
+
+
+async def _no_chunks() -> AsyncIterator[bytes]:
+    return
+    yield
+
+
+def _store(key: str) -> Callable[[Optional[str], bytes], None]:
+    def store(content_type: Optional[str], audio: bytes) -> None:
+        if tts_cache.directory is None:
+            tts_cache.put(key, content_type, audio)
+        else:
+            asyncio.get_running_loop().run_in_executor(None, tts_cache.put, key, content_type, audio)
+
+    return store
+
+
+def _shadow_synthesis(payload: Dict[str, Any], variables: Dict[str, Any], request_id: Optional[str]) -> Callable[[], Awaitable[Dict[str, Any]]]:
+    """The same synthesis, read whole from the shadow TTS backend (services/shadow.py)."""
+
+    async def _call() -> Dict[str, Any]:
+        base_url = backends.tts_base_url()
+        template = template_for("tts", base_url)
+        url, body = tts_request(template, base_url, payload, variables) if template is not None else (f"{base_url}/v1/audio/speech", payload)
+        headers = {
+            "accept": "*/*",
+            "Content-Type": "application/json",
+            **backends.auth_headers("tts"),
+            **({"X-Request-ID": request_id} if request_id else {}),
+        }
+        response = await http_client().post(url, json=body, headers=headers, timeout=TTS_TIMEOUT)
+        response.raise_for_status()
+        audio = response.content
+        if template is not None and "audio" in template.response:
+            audio = tts_audio(template, audio) or b""
+        reason = non_audio_reason(response.headers.get("Content-Type"), audio[:SNIFF_BYTES]) if audio else "empty body"
+        if reason:
+            raise StageError("tts", detail=f"TTS service returned a non-audio response ({reason})")
+        return {"shadow_audio_bytes": len(audio), "shadow_content_type": response.headers.get("Content-Type")}
+
+    return _call
+
+
+async def stream_tts(
+    text: str,
+    request_id: Optional[str] = None,
+    language: Optional[str] = None,
+    audio_format: Optional[str] = None,
+    cache: bool = True,
+) -> TTSStream:
+    """Start TTS synthesis and return once the first audio bytes have arrived (or from the cache).
+
+    audio_format (mp3, wav, ogg) is passed to the backend as response_format; check
+    audio_format on the result, since backends may ignore it. cache=False always asks the
+    backend and keeps nothing (warm-up probes, services/warmup.py).
+    """
+    hook_ctx = current_context(request_id, language)
+
+    async def _deliver(stream: TTSStream) -> TTSStream:
+        # The after_tts hooks, then the ID3 tag (services/audio_metadata.py) on the audio as it is sent.
+        stream = await run_hooks("after_tts", hook_ctx, stream)
+        frames = metadata_frames(text, language, hook_ctx.request_id or request_id)
+        if frames and stream.audio_format == "mp3":
+            stream.rewrite_head(lambda head: tag_mp3(head, frames))
+        return stream
+
+    recorded = recording(text, language)
+    if recorded is not None:
+        # A branded phrase with its own recording (services/branding.py): nothing to synthesize.
+        return await _deliver(TTSStream.cached(*recorded))
+    text = await run_hooks("before_tts", hook_ctx, text)
+    key = None
+    if cache and tts_cache.cacheable(text):
+        key = cache_key(text, language, f"{backends.tts_base_url()}#{audio_format or ''}")
+        if tts_cache.directory is None:
+            entry = tts_cache.get(key)
+        else:
+            entry = await asyncio.to_thread(tts_cache.get, key)
+        if entry is not None:
+            return await _deliver(TTSStream.cached(*entry))
+    headers = {
+        "accept": "*/*",
+        "Content-Type": "application/json",
+        **backends.auth_headers("tts"),
+        **({"X-Request-ID": request_id} if request_id else {}),
+    }
+    payload = {"text": text, **({"response_format": audio_format} if audio_format else {})}
+    voice = voice_for(language)
+    if voice:
+        payload["voice"] = voice
+    tag = LANGUAGE_TAGS.get(language or "")
+    variables = {
+        "text": text,
+        "voice": voice,
+        "language": language,
+        "language_code": tag.split("-")[0] if tag else None,
+        "language_tag": tag,
+        "response_format": audio_format,
+    }
+    client = http_client()
+    sent_to = {"base_url": "", "template": None}
+
+    async def _send(base_url: str) -> httpx.Response:
+        # A backend with a provider template (services/provider_templates.py) gets its own request shape.
+        template = template_for("tts", base_url)
+        sent_to.update(base_url=base_url, template=template)
+        url, body = tts_request(template, base_url, payload, variables) if template is not None else (f"{base_url}/v1/audio/speech", payload)
+        request = client.build_request("POST", url, json=body, headers=headers, timeout=stage_timeout("tts", TTS_TIMEOUT))
+        return await client.send(request, stream=True)
+
+    async def _close(response: httpx.Response) -> None:
+        await response.aclose()
+
+    async def _open() -> Tuple[httpx.Response, bytes, AsyncIterator[bytes], bool]:
+        """The response, its first bytes (up to SNIFF_BYTES when they come in small chunks), and whether that was all."""
+        # Measured to the first audio bytes: the rest is relayed at the client's pace.
+        with observe_stage("tts"):
+            try:
+                response = await backends.tts_pool().call(_send, failed_result=server_error, discard=_close)
+            except httpx.TimeoutException:
+                logger.error("TTS request timed out")
+                raise StageTimeout("tts")
+            except httpx.RequestError as exc:
+                logger.error("TTS request failed: %s", exc)
+                raise StageError("tts", detail="TTS service unavailable", error_code="tts_unavailable")
+            try:
+                response.raise_for_status()
+                record_usage(tts_chars=len(text))
+                head, complete = b"", True
+                template = sent_to["template"]
+                if template is not None and "audio" in template.response:
+                    # Audio wrapped in JSON: decoded whole; an unreadable reply counts as empty audio.
+                    head = tts_audio(template, await response.aread()) or b""
+                    chunks = _no_chunks()
+                else:
+                    chunks = response.aiter_bytes()
+                    async for chunk in chunks:
+                        if chunk:
+                            head += chunk
+                            if len(head) >= SNIFF_BYTES:
+                                complete = False
+                                break
+            except httpx.HTTPStatusError:
+                await response.aclose()
+                logger.error("TTS returned HTTP %s", response.status_code, extra={"base_url": str(response.url)})
+                raise StageError("tts", detail=f"TTS service returned HTTP {response.status_code}")
+            except httpx.TimeoutException:
+                await response.aclose()
+                logger.error("TTS response timed out")
+                raise StageTimeout("tts")
+            except Exception:
+                await response.aclose()
+                raise
+        return response, head, chunks, complete
+
+    opened_at = time.monotonic()
+    for attempt in range(GARBAGE_RETRIES + 1):
+        try:
+            response, first_chunk, chunks, complete = await _open()
+        except StageError as exc:
+            # A kiosk that lost its connection speaks with a local voice when it has one (services/offline.py).
+            local = await offline.speak(text, language, exc)
+            if local is None:
+                raise
+            return await _deliver(TTSStream.cached("audio/wav", local))
+        if not first_chunk:
+            await response.aclose()
+            logger.error("TTS returned empty audio", extra={"base_url": str(response.url), "status_code": response.status_code})
+            raise StageError("tts", detail="TTS service returned empty audio")
+        content_type = override_content_type(sent_to["base_url"], response.headers.get("Content-Type"))
+        if sent_to["template"] is not None and "audio" in sent_to["template"].response:
+            content_type = content_type_for(detect_format(None, first_chunk[:12]), "audio/mpeg")
+        reason = non_audio_reason(content_type, first_chunk)
+        if reason:
+            await response.aclose()
+            logger.error("TTS returned a non-audio body (%s)", reason, extra={"base_url": sent_to["base_url"]})
+            raise StageError("tts", detail="TTS service returned a non-audio response")
+        if not (complete and audio_too_short(first_chunk)):
+            break
+        await response.aclose()
+        logger.warning("TTS returned under %sms of audio (attempt %s)", MIN_TTS_AUDIO_MS, attempt + 1)
+    else:
+        raise garbage("tts", "short_audio", "TTS service returned too little audio")
+    if shadow.sampled("tts"):
+        shadow.mirror("tts", _shadow_synthesis(payload, variables, request_id), time.monotonic() - opened_at)
+    stream = TTSStream(response, first_chunk, chunks, content_type=content_type, on_complete=_store(key) if key else None)
+    return await _deliver(stream)
@@ -0,0 +1,166 @@
+"""Synthesized-audio cache in front of the TTS backend.
+
+Replies repeat a lot ("I didn't understand that", greetings), so finished TTS audio is kept
+in an in-memory LRU and, with DWANI_TTS_CACHE_DIR, on disk so it survives restarts and is
+shared by workers. Entries are keyed by normalized text, language, and voice; a TTS
+deployment serves a single voice, so its base URL identifies the voice.
+"""
+import hashlib
+import os
+import threading
+import unicodedata
+from collections import OrderedDict
+from pathlib import Path
+from typing import Optional, Tuple
+
+from prometheus_client import Counter
+
+from config import logger
+
+TTS_CACHE_MAX_ENTRIES = int(os.getenv("DWANI_TTS_CACHE_MAX_ENTRIES", "512") or 0)
+TTS_CACHE_MAX_BYTES = int(os.getenv("DWANI_TTS_CACHE_MAX_BYTES", str(64 * 1024 * 1024)) or 0)
+# Long, one-off replies would only churn the cache.
+TTS_CACHE_MAX_TEXT_CHARS = int(os.getenv("DWANI_TTS_CACHE_MAX_TEXT_CHARS", "200") or 0)
+TTS_CACHE_DIR = os.getenv("DWANI_TTS_CACHE_DIR", "").strip() or None
+TTS_CACHE_DISK_MAX_BYTES = int(os.getenv("DWANI_TTS_CACHE_DISK_MAX_BYTES", str(512 * 1024 * 1024)) or 0)
+
+_LOOKUPS = Counter("dwani_tts_cache_lookups_total", "TTS cache lookups by result", ["result"])
+
+Entry = Tuple[str, bytes]  # (content type, audio)
+
+
+def normalize_text(text: str) -> str:
+    """Unicode NFC with whitespace collapsed; case and punctuation are kept since they change prosody."""
+    return " ".join(unicodedata.normalize("NFC", text).split())
+
+
+def cache_key(text: str, language: Optional[str], voice: str) -> str:
+    raw = "\x00".join([normalize_text(text), (language or "").lower(), voice])
+    return hashlib.sha256(raw.encode("utf-8")).hexdigest()
+
+
+class TTSCache:
+    """Thread-safe LRU bounded by entry count and total bytes, with an optional disk tier."""
+
+    def __init__(
+        self,
+        max_entries: int = TTS_CACHE_MAX_ENTRIES,
+        max_bytes: int = TTS_CACHE_MAX_BYTES,
+        max_text_chars: int = TTS_CACHE_MAX_TEXT_CHARS,
+        directory: Optional[str] = TTS_CACHE_DIR,
+        disk_max_bytes: int = TTS_CACHE_DISK_MAX_BYTES,
+    ):
+        self.max_entries = max_entries
+        self.max_bytes = max_bytes
+        self.max_text_chars = max_text_chars
+        self.directory = Path(directory) if directory else None
+        self.disk_max_bytes = disk_max_bytes
+        self._entries: "OrderedDict[str, Entry]" = OrderedDict()
+        self._size = 0
+        self._lock = threading.Lock()
+
+    @property
+    def enabled(self) -> bool:
+        return self.max_text_chars > 0 and (self.max_entries > 0 or self.directory is not None)
+
+    def cacheable(self, text: str) -> bool:
+        return self.enabled and 0 < len(normalize_text(text)) <= self.max_text_chars
+
+    def get(self, key: str) -> Optional[Entry]:
+        with self._lock:
+            entry = self._entries.get(key)
+            if entry is not None:
+                self._entries.move_to_end(key)
+        if entry is not None:
+            _LOOKUPS.labels(result="hit_memory").inc()
+            return entry
+        entry = self._read_disk(key)
+        if entry is not None:
+            _LOOKUPS.labels(result="hit_disk").inc()
+            self._remember(key, entry)
+            return entry
+        _LOOKUPS.labels(result="miss").inc()
+        return None
+
+    def put(self, key: str, content_type: Optional[str], audio: bytes) -> None:
+        if not audio:
+            return
+        entry = (content_type or "audio/mpeg", audio)
+        self._remember(key, entry)
+        self._write_disk(key, entry)
+
+    def clear(self) -> None:
+        with self._lock:
+            self._entries.clear()
+            self._size = 0
+
+    def purge_disk(self) -> int:
+        """Delete the disk tier (shared by every worker); the number of entries removed."""
+        if self.directory is None:
+            return 0
+        removed = 0
+        for path in self.directory.glob("*/*.bin"):
+            path.unlink(missing_ok=True)
+            removed += 1
+        return removed
+
+    def __len__(self) -> int:
+        return len(self._entries)
+
+    def _remember(self, key: str, entry: Entry) -> None:
+        size = len(entry[1])
+        if self.max_entries <= 0 or size > self.max_bytes:
+            return
+        with self._lock:
+            previous = self._entries.pop(key, None)
+            if previous is not None:
+                self._size -= len(previous[1])
+            self._entries[key] = entry
+            self._size += size
+            while len(self._entries) > self.max_entries or self._size > self.max_bytes:
+                _, evicted = self._entries.popitem(last=False)
+                self._size -= len(evicted[1])
+
+    def _path(self, key: str) -> Path:
+        return self.directory / key[:2] / f"{key}.bin"
+
+    def _read_disk(self, key: str) -> Optional[Entry]:
+        if self.directory is None:
+            return None
+        try:
+            data = self._path(key).read_bytes()
+        except OSError:
+            return None
+        content_type, sep, audio = data.partition(b"\n")
+        if not sep or not audio:
+            return None
+        return content_type.decode("ascii", "replace"), audio
+
+    def _write_disk(self, key: str, entry: Entry) -> None:
+        if self.directory is None:
+            return
+        path = self._path(key)
+        try:
+            path.parent.mkdir(parents=True, exist_ok=True)
+            # Write-then-rename so concurrent workers never read a partial file.
+            tmp = path.with_suffix(f".{os.getpid()}.tmp")
+            tmp.write_bytes(entry[0].encode("ascii", "replace") + b"\n" + entry[1])
+            os.replace(tmp, path)
+            self._trim_disk()
+        except OSError as exc:
+            logger.warning("Failed to write TTS cache entry: %s", exc)
+
+    def _trim_disk(self) -> None:
+        if self.disk_max_bytes <= 0:
+            return
+        files = [(p.stat(), p) for p in self.directory.glob("*/*.bin")]
+        total = sum(st.st_size for st, _ in files)
+        # Oldest first; a hit does not refresh mtime, so this is FIFO on disk and LRU in memory.
+        for st, path in sorted(files, key=lambda f: f[0].st_mtime):
+            if total <= self.disk_max_bytes:
+                break
+            path.unlink(missing_ok=True)
+            total -= st.st_size
+
+
+tts_cache = TTSCache()
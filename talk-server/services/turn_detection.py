@@ -0,0 +1,194 @@
+"""Named end-of-turn (end-pointing) presets, selectable per session.
+
+Quick commands want the turn to end soon after the user stops; dictation wants long pauses
+tolerated. Each preset maps to the voice-activity thresholds a streaming client or the
+server-side detector applies: frames louder than speech_threshold_dbfs count as speech,
+speech shorter than min_speech_ms is ignored, and silence_ms of quiet ends the turn.
+Endpointer applies a preset to 16-bit PCM on the server (phone calls, /v1/transcribe/stream),
+reporting where speech starts and stops so the server decides when an utterance is complete.
+"""
+import math
+import sys
+from array import array
+from collections import deque
+from dataclasses import asdict, dataclass
+from typing import Deque, Dict, List, Optional
+
+from config import TURN_DETECTION_PRESET, logger
+from services.session import get_session_settings, update_session_settings
+
+
+@dataclass(frozen=True)
+class TurnDetectionPreset:
+    name: str
+    description: str
+    speech_threshold_dbfs: float
+    min_speech_ms: int
+    silence_ms: int
+    prefix_padding_ms: int
+    max_utterance_seconds: int
+
+    def as_dict(self) -> Dict[str, object]:
+        return asdict(self)
+
+
+PRESETS: Dict[str, TurnDetectionPreset] = {
+    p.name: p
+    for p in (
+        TurnDetectionPreset("fast", "Short commands; ends the turn quickly", -35.0, 120, 350, 150, 15),
+        TurnDetectionPreset("balanced", "Conversation; tolerates brief pauses", -40.0, 200, 800, 300, 30),
+        TurnDetectionPreset("patient", "Dictation and thinking aloud; waits out long pauses", -45.0, 250, 2000, 500, 120),
+    )
+}
+_SETTING = "turn_detection"
+
+
+def _default_name() -> str:
+    if TURN_DETECTION_PRESET in PRESETS:
+        return TURN_DETECTION_PRESET
+    logger.warning("Unknown DWANI_TURN_DETECTION_PRESET %r; using balanced", TURN_DETECTION_PRESET)
+    return "balanced"
+
+
+def list_presets() -> List[TurnDetectionPreset]:
+    return list(PRESETS.values())
+
+
+def get_preset(name: Optional[str]) -> Optional[TurnDetectionPreset]:
+    return PRESETS.get((name or "").strip().lower())
+
+
+def session_preset(session_id: Optional[str]) -> TurnDetectionPreset:
+    """The session's chosen preset, else DWANI_TURN_DETECTION_PRESET."""
+    chosen = get_preset(get_session_settings(session_id).get(_SETTING)) if session_id else None
+    return chosen or PRESETS[_default_name()]
+
+
+def set_session_preset(session_id: str, name: str) -> TurnDetectionPreset:
+    preset = get_preset(name)
+    if preset is None:
+        raise ValueError(f"turn-detection preset must be one of {list(PRESETS)}")
+    update_session_settings(session_id, {_SETTING: preset.name})
+    return preset
+
+
+@dataclass
+class SpeechEvent:
+    """speech_started once speech is confirmed; speech_stopped when the turn ends, with its PCM.
+
+    at_seconds is stream time: when the speech began or stopped. An utterance's audio starts
+    at start_seconds, up to prefix_padding_ms before the speech.
+    """
+
+    type: str
+    at_seconds: float
+    audio: bytes = b""
+    start_seconds: float = 0.0
+
+
+def frame_dbfs(pcm: bytes) -> float:
+    """Level of 16-bit little-endian mono PCM in dBFS (-inf for digital silence)."""
+    samples = array("h", pcm[: len(pcm) // 2 * 2])
+    if sys.byteorder == "big":
+        samples.byteswap()
+    if not samples:
+        return float("-inf")
+    rms = math.sqrt(sum(s * s for s in samples) / len(samples))
+    return 20 * math.log10(rms / 32768) if rms else float("-inf")
+
+
+class Endpointer:
+    """Cuts a stream of 16-bit mono PCM into utterances using a preset's thresholds."""
+
+    def __init__(self, preset: TurnDetectionPreset, sample_rate: int = 8000, frame_ms: int = 20):
+        self.preset = preset
+        self.frame_ms = frame_ms
+        self._frame_bytes = sample_rate * frame_ms // 1000 * 2
+        self._max_bytes = preset.max_utterance_seconds * sample_rate * 2
+        # Enough leading audio for the prefix padding plus the frames that confirmed speech.
+        self._lead: Deque[bytes] = deque(maxlen=(preset.prefix_padding_ms + preset.min_speech_ms) // frame_ms + 1)
+        self._pending = b""
+        self._utterance = bytearray()
+        self._speaking = False
+        self._voiced_ms = 0
+        self._silence_ms = 0
+        self._position_ms = 0
+        self._utterance_start_ms = 0
+
+    @property
+    def speaking(self) -> bool:
+        """Inside an utterance (speech confirmed, end not yet reached)."""
+        return self._speaking
+
+    @property
+    def utterance_audio(self) -> bytes:
+        """The current utterance's PCM so far (empty outside one), e.g. for interim transcripts."""
+        return bytes(self._utterance) if self._speaking else b""
+
+    @property
+    def voiced_ms(self) -> int:
+        """Speech heard so far in the current (or candidate) utterance."""
+        return self._voiced_ms
+
+    def reset(self) -> None:
+        self._lead.clear()
+        self._pending = b""
+        self._utterance = bytearray()
+        self._speaking = False
+        self._voiced_ms = self._silence_ms = 0
+
+    def feed(self, pcm: bytes) -> List[bytes]:
+        """Utterances (PCM) that ended within pcm; usually none."""
+        return [event.audio for event in self.feed_events(pcm) if event.type == "speech_stopped"]
+
+    def feed_events(self, pcm: bytes) -> List[SpeechEvent]:
+        """Speech starts and ends within pcm, in order."""
+        data = self._pending + pcm
+        events = []
+        offset = 0
+        while offset + self._frame_bytes <= len(data):
+            event = self._frame(data[offset:offset + self._frame_bytes])
+            if event:
+                events.append(event)
+            offset += self._frame_bytes
+        self._pending = data[offset:]
+        return events
+
+    def flush(self) -> Optional[SpeechEvent]:
+        """End the utterance in progress now (end of stream, push-to-talk release); None if there is none."""
+        self._lead.clear()
+        self._voiced_ms = 0
+        if not self._speaking:
+            return None
+        return self._stop(self._position_ms - self._silence_ms)
+
+    def _stop(self, at_ms: int) -> SpeechEvent:
+        event = SpeechEvent("speech_stopped", at_ms / 1000, bytes(self._utterance), self._utterance_start_ms / 1000)
+        self._utterance = bytearray()
+        self._speaking = False
+        self._voiced_ms = 0
+        return event
+
+    def _frame(self, frame: bytes) -> Optional[SpeechEvent]:
+        self._position_ms += self.frame_ms
+        loud = frame_dbfs(frame) >= self.preset.speech_threshold_dbfs
+        if not self._speaking:
+            self._lead.append(frame)
+            self._voiced_ms = self._voiced_ms + self.frame_ms if loud else 0
+            if self._voiced_ms >= self.preset.min_speech_ms:
+                self._speaking = True
+                self._utterance = bytearray(b"".join(self._lead))
+                self._utterance_start_ms = self._position_ms - len(self._lead) * self.frame_ms
+                self._lead.clear()
+                self._silence_ms = 0
+                return SpeechEvent(
+                    "speech_started", (self._position_ms - self._voiced_ms) / 1000, start_seconds=self._utterance_start_ms / 1000
+                )
+            return None
+        self._utterance.extend(frame)
+        if loud:
+            self._voiced_ms += self.frame_ms
+        self._silence_ms = 0 if loud else self._silence_ms + self.frame_ms
+        if self._silence_ms >= self.preset.silence_ms or len(self._utterance) >= self._max_bytes:
+            return self._stop(self._position_ms - self._silence_ms)
+        return None
@@ -0,0 +1,149 @@
+"""Bounded memory for multipart uploads.
+
+Starlette spools each uploaded file in memory up to 1MB and then moves it to a temporary file.
+DWANI_UPLOAD_SPOOL_BYTES sets that threshold, and DWANI_UPLOAD_SPOOL_DIR sets where the
+temporary files go, so a long recording costs a request at most that much memory. The form
+parser's limits are configurable too: DWANI_FORM_MAX_FILES, DWANI_FORM_MAX_FIELDS, and
+DWANI_FORM_MAX_PART_BYTES for a non-file field. A form beyond them is a 400.
+
+Code that needs a whole upload's identity, such as the replay guard and idempotency
+fingerprints, hashes it with upload_digest() chunk by chunk instead of reading it into memory.
+
+Audio is checked before ASR sees it (check_audio_upload, from transcribe_audio): a Content-Type
+that is not audio, or bytes that are plainly something else (an executable, an archive, a
+document, HTML), are a 415 "unsupported_audio", and so is a recognized format that is not in
+DWANI_UPLOAD_AUDIO_FORMATS. Bytes of no recognized format pass, for backends that take raw
+or rarer formats, unless DWANI_UPLOAD_STRICT=1. With DWANI_FORM_STRICT_FIELDS=1, a form may
+only carry the fields its endpoint takes, plus DWANI_FORM_EXTRA_FIELDS (check_form_fields, an
+app-wide dependency); endpoints that read the form themselves, like the Twilio webhook, are exempt.
+"""
+import functools
+import hashlib
+import re
+import tempfile
+
+from fastapi import Request, UploadFile
+from fastapi.dependencies.utils import get_flat_dependant
+from starlette import formparsers
+from starlette.concurrency import run_in_threadpool
+from starlette.requests import HTTPConnection
+
+from config import (
+    FORM_EXTRA_FIELDS,
+    FORM_MAX_FIELDS,
+    FORM_MAX_FILES,
+    FORM_MAX_PART_BYTES,
+    FORM_STRICT_FIELDS,
+    UPLOAD_AUDIO_FORMATS,
+    UPLOAD_SPOOL_BYTES,
+    UPLOAD_SPOOL_DIR,
+    UPLOAD_STRICT,
+    logger,
+)
+from services.audio_format import not_audio_file, sniff_input_format
+from services.errors import RequestError
+
+_CHUNK = 64 * 1024
+_SNIFF_BYTES = 4096
+_MEDIA_TYPE = re.compile(r"[a-z0-9][a-z0-9!#$&^_.+-]*/[a-z0-9][a-z0-9!#$&^_.+-]*")
+# Besides audio/*: what browsers' MediaRecorder and object stores label audio with.
+_AUDIO_CONTAINER_TYPES = {
+    "application/octet-stream", "binary/octet-stream", "application/ogg", "video/webm", "video/mp4", "video/ogg", "video/3gpp",
+}
+_FORM_TYPES = ("multipart/form-data", "application/x-www-form-urlencoded")
+_applied = False
+
+
+def spooled_file() -> "tempfile.SpooledTemporaryFile[bytes]":
+    """An empty spool that moves to disk beyond DWANI_UPLOAD_SPOOL_BYTES, like the form parser's."""
+    return tempfile.SpooledTemporaryFile(max_size=UPLOAD_SPOOL_BYTES, dir=UPLOAD_SPOOL_DIR)
+
+
+def apply_upload_limits() -> None:
+    """Make Starlette's form parser spool and bound uploads as configured; idempotent."""
+    global _applied
+    if _applied:
+        return
+    _applied = True
+    formparsers.MultiPartParser.spool_max_size = UPLOAD_SPOOL_BYTES
+    if UPLOAD_SPOOL_DIR:
+        # The parser creates its spools with the default directory.
+        formparsers.SpooledTemporaryFile = functools.partial(tempfile.SpooledTemporaryFile, dir=UPLOAD_SPOOL_DIR)
+    form = Request.form
+
+    @functools.wraps(form)
+    def limited_form(request: Request, *, max_files=FORM_MAX_FILES, max_fields=FORM_MAX_FIELDS, max_part_size=FORM_MAX_PART_BYTES):
+        return form(request, max_files=max_files, max_fields=max_fields, max_part_size=max_part_size)
+
+    Request.form = limited_form
+    logger.debug(
+        "Uploads spool to disk beyond %s bytes; forms allow %s files, %s fields, %s-byte parts",
+        UPLOAD_SPOOL_BYTES, FORM_MAX_FILES, FORM_MAX_FIELDS, FORM_MAX_PART_BYTES,
+    )
+
+
+def _digest(file: UploadFile) -> str:
+    file.file.seek(0)
+    digest = hashlib.sha256()
+    size = 0
+    for chunk in iter(functools.partial(file.file.read, _CHUNK), b""):
+        digest.update(chunk)
+        size += len(chunk)
+    file.file.seek(0)
+    return digest.hexdigest() if size else ""
+
+
+async def upload_digest(file: UploadFile) -> str:
+    """SHA-256 of the upload, read chunk by chunk; "" for an empty one. The file is left at its start."""
+    return await run_in_threadpool(_digest, file)
+
+
+def _audio_type_problem(content_type: str) -> str:
+    media = content_type.split(";")[0].strip().lower()
+    if not media:
+        return ""
+    if not _MEDIA_TYPE.fullmatch(media):
+        return f"Content-Type {content_type[:64]!r} is not a media type"
+    if media.startswith("audio/") or media in _AUDIO_CONTAINER_TYPES:
+        return ""
+    return f"Content-Type {media} is not audio"
+
+
+async def check_audio_upload(file: UploadFile) -> None:
+    """415 "unsupported_audio" unless the upload may be audio in an accepted format (see the module docstring)."""
+    problem = _audio_type_problem(file.content_type or "")
+    if not problem:
+        await file.seek(0)
+        head = await file.read(_SNIFF_BYTES)
+        await file.seek(0)
+        if not head:
+            return  # an empty upload is its own error
+        audio_format = sniff_input_format(head)
+        kind = not_audio_file(head)
+        if kind:
+            problem = f"The file is {kind}, not audio"
+        elif audio_format is not None and audio_format not in UPLOAD_AUDIO_FORMATS:
+            problem = f"{audio_format} audio is not accepted; send one of {UPLOAD_AUDIO_FORMATS}"
+        elif audio_format is None and UPLOAD_STRICT:
+            problem = f"Unrecognized audio format; send one of {UPLOAD_AUDIO_FORMATS}"
+    if problem:
+        logger.warning("Upload refused: %s", problem)
+        raise RequestError(415, "unsupported_audio", problem)
+
+
+async def check_form_fields(request: HTTPConnection) -> None:
+    """With DWANI_FORM_STRICT_FIELDS=1, 400 for a form field the endpoint does not take (app-wide dependency)."""
+    if not FORM_STRICT_FIELDS or not isinstance(request, Request):
+        return  # WebSockets have no form
+    if not request.headers.get("content-type", "").lower().startswith(_FORM_TYPES):
+        return
+    route = request.scope.get("route")
+    dependant = getattr(route, "dependant", None)
+    if dependant is None:
+        return
+    declared = {param.alias for param in get_flat_dependant(dependant).body_params}
+    if not declared:
+        return  # the endpoint reads the form itself
+    unknown = sorted(set((await request.form()).keys()) - declared - FORM_EXTRA_FIELDS)
+    if unknown:
+        raise RequestError(400, "invalid_request", f"Unexpected form fields {unknown}; this endpoint takes {sorted(declared)}")
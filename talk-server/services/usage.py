@@ -0,0 +1,196 @@
+"""Per-API-key usage accounting for chargeback: audio seconds, LLM tokens and TTS characters.
+
+Each request is attributed to the API key it carried, as a SHA-256 prefix like the per-key rate
+limit's buckets (never the key itself), or to "anonymous". Stages count what they use with
+record_usage(); when the request ends, a request that used anything is added to its key's row
+for the day (UTC) in the store DWANI_USAGE_STORE selects:
+
+- memory (the default): process memory only, per worker.
+- sqlite: DWANI_USAGE_SQLITE_PATH, so usage survives restarts and is shared by the workers of a host.
+- off: nothing is counted.
+- "module:Class" (or module:factory): any UsageStore implementation, e.g. a billing database.
+
+Callers read their own usage at GET /v1/usage; GET /admin/usage.csv exports every key's. JSON
+turn responses carry the request's own usage (request_usage).
+Only work done while serving an HTTP request is counted, not background jobs or WebSocket streams.
+"""
+import importlib
+import sqlite3
+import threading
+from contextvars import ContextVar
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Optional, Tuple
+
+from config import USAGE_SQLITE_PATH, USAGE_STORE, logger
+from ratelimit import api_key_bucket
+
+METRICS = ("requests", "audio_seconds", "llm_prompt_tokens", "llm_completion_tokens", "tts_chars")
+ANONYMOUS = "anonymous"
+
+_usage: ContextVar[Optional[Dict[str, float]]] = ContextVar("dwani_usage", default=None)
+
+
+def usage_account(api_key: Optional[str]) -> str:
+    return api_key_bucket(api_key) if api_key else ANONYMOUS
+
+
+def today() -> str:
+    return datetime.now(timezone.utc).date().isoformat()
+
+
+def track_usage() -> Dict[str, float]:
+    """Start counting this request's usage; the returned dict fills in as stages run."""
+    counts: Dict[str, float] = {}
+    _usage.set(counts)
+    return counts
+
+
+def record_usage(**amounts: float) -> None:
+    """Add to the current request's usage, e.g. record_usage(tts_chars=120); a no-op outside one."""
+    counts = _usage.get()
+    if counts is None:
+        return
+    for metric, amount in amounts.items():
+        if amount:
+            counts[metric] = counts.get(metric, 0) + amount
+
+
+def request_usage() -> Dict[str, float]:
+    """What the current request has used so far, in every metric but requests, rounded like a store row."""
+    row = _row(ANONYMOUS, today(), _usage.get() or {})
+    return {metric: row[metric] for metric in METRICS if metric != "requests"}
+
+
+def _row(account: str, day: str, counts: Dict[str, float]) -> Dict[str, Any]:
+    """A store row; audio_seconds to the millisecond, the other metrics as whole numbers."""
+    values = {metric: counts.get(metric, 0) for metric in METRICS}
+    return {
+        "day": day,
+        "account": account,
+        **{metric: round(value, 3) if metric == "audio_seconds" else int(value) for metric, value in values.items()},
+    }
+
+
+class UsageStore:
+    """Where usage totals live; subclasses implement add and rows."""
+
+    name = "custom"
+
+    def add(self, account: str, day: str, counts: Dict[str, float]) -> None:
+        raise NotImplementedError
+
+    def rows(self, account: Optional[str] = None, since: Optional[str] = None, until: Optional[str] = None) -> List[Dict[str, Any]]:
+        """Daily rows (day, account and METRICS) ordered by day then account; since and until are inclusive."""
+        raise NotImplementedError
+
+
+class NullUsageStore(UsageStore):
+    name = "off"
+
+    def add(self, account: str, day: str, counts: Dict[str, float]) -> None:
+        pass
+
+    def rows(self, account: Optional[str] = None, since: Optional[str] = None, until: Optional[str] = None) -> List[Dict[str, Any]]:
+        return []
+
+
+class MemoryUsageStore(UsageStore):
+    """Process memory; lost on restart and counted separately by each worker."""
+
+    name = "memory"
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._totals: Dict[Tuple[str, str], Dict[str, float]] = {}
+
+    def add(self, account: str, day: str, counts: Dict[str, float]) -> None:
+        with self._lock:
+            totals = self._totals.setdefault((account, day), {})
+            for metric in METRICS:
+                totals[metric] = totals.get(metric, 0) + counts.get(metric, 0)
+
+    def rows(self, account: Optional[str] = None, since: Optional[str] = None, until: Optional[str] = None) -> List[Dict[str, Any]]:
+        with self._lock:
+            items = [(key, dict(totals)) for key, totals in self._totals.items()]
+        return [
+            _row(key_account, day, totals)
+            for (key_account, day), totals in sorted(items, key=lambda item: (item[0][1], item[0][0]))
+            if (account is None or key_account == account) and (since is None or day >= since) and (until is None or day <= until)
+        ]
+
+
+class SQLiteUsageStore(UsageStore):
+    """A local SQLite file: survives restarts, shared by the processes that use the same file."""
+
+    name = "sqlite"
+
+    def __init__(self, path: str = USAGE_SQLITE_PATH):
+        self.path = path
+        self._lock = threading.Lock()
+        self._db = sqlite3.connect(path, check_same_thread=False, isolation_level=None)
+        self._db.execute("PRAGMA journal_mode=WAL")
+        columns = ", ".join(
+            f"{metric} {'REAL' if metric == 'audio_seconds' else 'INTEGER'} NOT NULL DEFAULT 0" for metric in METRICS
+        )
+        self._db.execute(f"CREATE TABLE IF NOT EXISTS usage (account TEXT NOT NULL, day TEXT NOT NULL, {columns}, PRIMARY KEY (account, day))")
+
+    def add(self, account: str, day: str, counts: Dict[str, float]) -> None:
+        updates = ", ".join(f"{metric} = {metric} + excluded.{metric}" for metric in METRICS)
+        with self._lock:
+            self._db.execute(
+                f"INSERT INTO usage (account, day, {', '.join(METRICS)}) VALUES (?, ?, {', '.join('?' for _ in METRICS)}) "
+                f"ON CONFLICT(account, day) DO UPDATE SET {updates}",
+                (account, day, *(counts.get(metric, 0) for metric in METRICS)),
+            )
+
+    def rows(self, account: Optional[str] = None, since: Optional[str] = None, until: Optional[str] = None) -> List[Dict[str, Any]]:
+        where, params = [], []
+        for clause, value in (("account = ?", account), ("day >= ?", since), ("day <= ?", until)):
+            if value is not None:
+                where.append(clause)
+                params.append(value)
+        query = f"SELECT account, day, {', '.join(METRICS)} FROM usage"
+        if where:
+            query += " WHERE " + " AND ".join(where)
+        with self._lock:
+            found = self._db.execute(query + " ORDER BY day, account", params).fetchall()
+        return [_row(row[0], row[1], dict(zip(METRICS, row[2:]))) for row in found]
+
+    def close(self) -> None:
+        with self._lock:
+            self._db.close()
+
+
+def create_usage_store(spec: str = USAGE_STORE) -> UsageStore:
+    """The store named by spec (see the module docstring); ValueError when it is unknown."""
+    spec = (spec or "memory").strip()
+    if spec.lower() == "memory":
+        return MemoryUsageStore()
+    if spec.lower() == "sqlite":
+        return SQLiteUsageStore()
+    if spec.lower() == "off":
+        return NullUsageStore()
+    module_name, _, attr = spec.partition(":")
+    if not module_name or not attr:
+        raise ValueError(f"DWANI_USAGE_STORE must be memory, sqlite, off, or module:Class, not {spec!r}")
+    store = getattr(importlib.import_module(module_name), attr)()
+    if not isinstance(store, UsageStore):
+        raise ValueError(f"{spec} did not produce a UsageStore")
+    return store
+
+
+_STORE: Optional[UsageStore] = None
+
+
+def usage_store() -> UsageStore:
+    global _STORE
+    if _STORE is None:
+        _STORE = create_usage_store()
+        logger.info("Usage store: %s", _STORE.name)
+    return _STORE
+
+
+def save_usage(account: str, counts: Dict[str, float]) -> None:
+    """Add one finished request's counts to account's row for today, when it used anything."""
+    if counts:
+        usage_store().add(account, today(), {**counts, "requests": 1})
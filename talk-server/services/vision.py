@@ -0,0 +1,121 @@
+"""Image reading: OCR or a plain-language summary of a photographed document.
+
+The image goes to an OpenAI-compatible vision chat-completions endpoint of the dwani stack
+(DWANI_VISION_URL, falling back to the ASR endpoint's multimodal model) as a data URL.
+"ocr" returns the text as written; "summary" explains what the document says, in the
+requested language and in simple words, for users who cannot easily read it.
+"""
+import base64
+import json
+from typing import Optional
+
+import httpx
+from fastapi import HTTPException, UploadFile
+
+from config import IMAGE_MAX_BYTES, VISION_MAX_TOKENS, VISION_MODEL, VISION_TIMEOUT, logger
+from services import backends
+from services.balancer import server_error
+from services.deadline import StageTimeout, stage_timeout
+from services.errors import StageError
+from services.http_client import http_client
+from services.latency import observe_stage
+from services.prompts import language_display_name
+from services.retry import retry_async
+
+IMAGE_MODES = ("ocr", "summary")
+
+_OCR_PROMPT = (
+    "Extract all readable text from this image exactly as written, in its original script, "
+    "in reading order. Output only the text, with no labels or commentary."
+)
+_SUMMARY_PROMPT = (
+    "This is a photo of a document. Explain what it says in {language}, in short, simple "
+    "sentences for someone who cannot read it: what kind of document it is, the key facts "
+    "(names, amounts, dates, deadlines), and anything the reader must do. Do not use "
+    "markdown or lists; the answer will be read aloud."
+)
+_IMAGE_MAGIC = (
+    (b"\xff\xd8\xff", "image/jpeg"),
+    (b"\x89PNG\r\n\x1a\n", "image/png"),
+    (b"GIF87a", "image/gif"),
+    (b"GIF89a", "image/gif"),
+)
+
+
+def image_mime(data: bytes) -> Optional[str]:
+    """Media type from the magic bytes; None when the data is not a supported image."""
+    for magic, mime in _IMAGE_MAGIC:
+        if data.startswith(magic):
+            return mime
+    if data[:4] == b"RIFF" and data[8:12] == b"WEBP":
+        return "image/webp"
+    return None
+
+
+def _prompt(mode: str, language: Optional[str]) -> str:
+    if mode == "summary":
+        return _SUMMARY_PROMPT.format(language=language_display_name(language) if language else "the document's language")
+    if language:
+        return f"The text is in {language_display_name(language)}. {_OCR_PROMPT}"
+    return _OCR_PROMPT
+
+
+async def read_image(
+    file: UploadFile,
+    mode: str = "ocr",
+    language: Optional[str] = None,
+    request_id: Optional[str] = None,
+) -> str:
+    """Text read from (mode="ocr") or explained from (mode="summary") an uploaded image."""
+    data = await file.read(IMAGE_MAX_BYTES + 1)
+    if len(data) > IMAGE_MAX_BYTES:
+        raise HTTPException(status_code=413, detail=f"Image too large (max {IMAGE_MAX_BYTES // (1024*1024)}MB)")
+    if not data:
+        raise HTTPException(status_code=400, detail="Empty image file")
+    mime = image_mime(data)
+    if mime is None:
+        raise HTTPException(status_code=400, detail="Image must be JPEG, PNG, WebP, or GIF")
+
+    payload = {
+        "model": VISION_MODEL,
+        "messages": [
+            {
+                "role": "user",
+                "content": [
+                    {"type": "image_url", "image_url": {"url": f"data:{mime};base64,{base64.b64encode(data).decode('ascii')}"}},
+                    {"type": "text", "text": _prompt(mode, language)},
+                ],
+            }
+        ],
+        "temperature": 0.1 if mode == "ocr" else 0.3,
+        "max_tokens": VISION_MAX_TOKENS,
+    }
+    headers = {**backends.auth_headers("vision"), **({"X-Request-ID": request_id} if request_id else {})}
+
+    async def _post(url: str) -> httpx.Response:
+        return await http_client().post(url, json=payload, headers=headers, timeout=stage_timeout("vision", VISION_TIMEOUT))
+
+    async def _do():
+        try:
+            return await backends.vision_pool().call(_post, failed_result=server_error)
+        except httpx.TimeoutException:
+            logger.error("Vision request timed out")
+            raise StageTimeout("vision")
+        except httpx.RequestError as exc:
+            logger.error("Vision request failed: %s", exc)
+            raise StageError("vision", detail="Vision service unavailable", error_code="vision_unavailable")
+
+    with observe_stage("vision"):
+        response = await retry_async(_do)
+    if response.status_code != 200:
+        logger.error("Vision service returned HTTP %s: %s", response.status_code, response.text[:500])
+        raise StageError("vision", detail=f"Vision service returned HTTP {response.status_code}")
+    try:
+        choices = response.json().get("choices") or []
+        text = ((choices[0].get("message") or {}).get("content") or "").strip() if choices else ""
+    except (json.JSONDecodeError, AttributeError, TypeError) as exc:
+        logger.error("Invalid vision response: %s", exc)
+        raise StageError("vision", detail="Invalid response from vision service")
+    if not text:
+        raise StageError("vision", status_code=422, detail="No readable text found in the image")
+    return text
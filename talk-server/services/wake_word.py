@@ -0,0 +1,75 @@
+"""Wake-word gating for always-on streaming clients such as kiosks.
+
+With wake words (DWANI_STREAM_WAKE_WORDS, or ?wake_word= on /v1/transcribe/stream, comma
+separated) a stream starts asleep. Each utterance is still transcribed, but only to look for a
+wake word: without one the utterance's result is {"type": "ignored"} and its text is not sent.
+The ASR transcript is compared token by token, ignoring case and punctuation, and a wake word
+matches when it is at least DWANI_STREAM_WAKE_SIMILARITY alike (1 = exact) anywhere in the
+utterance, so ASR spellings such as "hey dhwani" still wake it.
+
+On a match the server sends {"type": "wake", "wake_word": ...}. Whatever followed the wake word
+in the same utterance ("hey dwani, what time is it?") is the command and is sent as that
+utterance's transcription; otherwise the next utterance that starts within
+DWANI_STREAM_WAKE_WINDOW seconds is. After the command the stream sends {"type": "sleep",
+"reason": "answered"}, and {"type": "sleep", "reason": "timeout"} if nobody spoke in time.
+"""
+import re
+import string
+from difflib import SequenceMatcher
+from typing import List, Optional, Tuple
+
+from config import STREAM_WAKE_SIMILARITY, STREAM_WAKE_WINDOW
+
+_TOKEN = re.compile(r"\S+")
+_PUNCTUATION = string.punctuation + "।॥“”‘’…¿¡"
+
+
+def parse_wake_words(value: str) -> List[str]:
+    """Wake words from a comma-separated list, normalized; [] for none."""
+    words = [" ".join(_normalized(word)) for word in value.split(",")]
+    return [word for word in words if word]
+
+
+def _normalized(text: str) -> List[str]:
+    return [token for token in (t.strip(_PUNCTUATION).lower() for t in text.split()) if token]
+
+
+def find_wake_word(transcript: str, wake_words: List[str], similarity: float = STREAM_WAKE_SIMILARITY) -> Optional[Tuple[str, str]]:
+    """(wake word, what followed it) for the best match in transcript, or None."""
+    tokens = [(m.group().strip(_PUNCTUATION).lower(), m.end()) for m in _TOKEN.finditer(transcript)]
+    tokens = [(token, end) for token, end in tokens if token]
+    best: Optional[Tuple[float, str, int]] = None
+    for word in wake_words:
+        size = len(word.split())
+        for start in range(len(tokens) - size + 1):
+            window = " ".join(token for token, _ in tokens[start:start + size])
+            score = SequenceMatcher(None, window, word).ratio()
+            if score >= similarity and (best is None or score > best[0]):
+                best = (score, word, tokens[start + size - 1][1])
+    if best is None:
+        return None
+    _, word, end = best
+    return word, transcript[end:].lstrip(_PUNCTUATION + " ").strip()
+
+
+class WakeWordGate:
+    """A stream's asleep/awake state; times are seconds of stream audio."""
+
+    def __init__(self, wake_words: List[str], window_seconds: float = STREAM_WAKE_WINDOW):
+        self.wake_words = wake_words
+        self.window_seconds = window_seconds
+        self._awake_until: Optional[float] = None
+
+    @property
+    def awake(self) -> bool:
+        return self._awake_until is not None
+
+    def wake(self, at_seconds: float) -> None:
+        self._awake_until = at_seconds + self.window_seconds
+
+    def sleep(self) -> None:
+        self._awake_until = None
+
+    def expired(self, at_seconds: float) -> bool:
+        """Awake, but nobody has started speaking since the window closed at at_seconds."""
+        return self._awake_until is not None and at_seconds > self._awake_until
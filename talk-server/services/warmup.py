@@ -0,0 +1,147 @@
+"""Warm-up and keepalive probes, so the first user request does not pay for a cold backend.
+
+With DWANI_WARMUP=1 each worker sends one small real request to every configured stage at
+startup: half a second of quiet noise to ASR, a one-word prompt to the LLM and a one-word phrase
+to TTS. That opens the pooled connections (TLS included) and makes backends that load their
+models lazily load them now. /ready and /startup answer 503 ("warming_up") until every stage has
+answered once; stages that could not be reached are tried again every DWANI_WARMUP_RETRY_SECONDS.
+A backend that answers with an error (no words in the noise, say) counts as warm: only failing to
+reach it, or a timeout, does not.
+
+DWANI_BACKEND_KEEPALIVE_SECONDS then repeats the probes at that interval, so idle connections
+are not dropped and scale-to-zero backends stay up. A failed keepalive is logged and counted but
+leaves readiness to the /ready checks. The noise differs each time and the TTS cache is bypassed, so
+no cache answers a probe. Probes are counted in dwani_warmup_probes_total{stage,phase,outcome}.
+"""
+import asyncio
+import random
+import sys
+import time
+from array import array
+from typing import Awaitable, Callable, Dict, List, Optional, Set, Tuple
+
+from fastapi import HTTPException
+from prometheus_client import Counter
+
+from config import BACKEND_KEEPALIVE_SECONDS, WARMUP, WARMUP_RETRY_SECONDS, logger
+from models import GenerationParams
+from services import backends
+from services.chat_svc import call_llm
+from services.error_codes import code_for
+from services.pipeline import audio_upload
+from services.telephony import wav_bytes
+from services.transcribe import transcribe_audio
+from services.tts import stream_tts
+
+_PROBES = Counter("dwani_warmup_probes_total", "Warm-up and keepalive requests to the backends", ["stage", "phase", "outcome"])
+
+_SAMPLE_RATE = 16000
+_PROBE_TEXT = "OK"
+
+
+def _noise(seconds: float = 0.5) -> bytes:
+    """A WAV of faint random noise: never silence a backend might reject outright, never the same twice."""
+    samples = array("h", (random.randint(-64, 64) for _ in range(int(seconds * _SAMPLE_RATE))))
+    if sys.byteorder == "big":
+        samples.byteswap()
+    return wav_bytes(samples.tobytes(), _SAMPLE_RATE)
+
+
+async def _asr() -> None:
+    await transcribe_audio(audio_upload(_noise(), "audio/wav", "warmup.wav"))
+
+
+async def _llm() -> None:
+    await call_llm(
+        _PROBE_TEXT,
+        generation=GenerationParams(temperature=0, max_tokens=8),
+        system_prompt="Reply with the single word OK.",
+    )
+
+
+async def _tts() -> None:
+    tts_stream = await stream_tts(_PROBE_TEXT, cache=False)
+    await tts_stream.read()
+
+
+# stage: (its configured URL, the probe)
+PROBES: Dict[str, Tuple[Callable[[], str], Callable[[], Awaitable[None]]]] = {
+    "asr": (backends.asr_url, _asr),
+    "llm": (backends.llm_base_url, _llm),
+    "tts": (backends.tts_base_url, _tts),
+}
+
+
+def reached(exc: Exception) -> bool:
+    """Whether a failed probe still got an answer from its backend, which warms it just as well."""
+    return isinstance(exc, HTTPException) and not code_for(exc).endswith(("_unavailable", "_timeout"))
+
+
+class Warmer:
+    """Startup warm-up of every configured stage, then keepalives; readiness waits for the warm-up."""
+
+    def __init__(
+        self,
+        enabled: bool = WARMUP,
+        retry_seconds: float = WARMUP_RETRY_SECONDS,
+        keepalive_seconds: float = BACKEND_KEEPALIVE_SECONDS,
+    ):
+        self.enabled = enabled
+        self.retry_seconds = retry_seconds
+        self.keepalive_seconds = keepalive_seconds
+        self.pending: Set[str] = set()
+        self._warm = not enabled
+        self._task: Optional[asyncio.Task] = None
+
+    @property
+    def warm(self) -> bool:
+        return self._warm
+
+    async def start(self) -> None:
+        if not self.enabled or self._task is not None:
+            return
+        self._warm = False
+        self.pending = {stage for stage, (url, _) in PROBES.items() if url()}
+        self._task = asyncio.create_task(self._run())
+
+    async def stop(self) -> None:
+        if self._task is None:
+            return
+        self._task.cancel()
+        await asyncio.gather(self._task, return_exceptions=True)
+        self._task = None
+
+    async def probe(self, stage: str, phase: str) -> bool:
+        """Send stage its probe; whether the backend answered."""
+        try:
+            await PROBES[stage][1]()
+            ok = True
+        except Exception as exc:
+            ok = reached(exc)
+            if not ok:
+                logger.warning("%s %s probe failed: %s", stage.upper(), phase, exc)
+        _PROBES.labels(stage=stage, phase=phase, outcome="ok" if ok else "failed").inc()
+        return ok
+
+    async def warm_up(self) -> bool:
+        """Probe the stages not yet warm, side by side; whether all of them are now."""
+        stages: List[str] = sorted(self.pending)
+        results = await asyncio.gather(*(self.probe(stage, "warmup") for stage in stages))
+        self.pending -= {stage for stage, ok in zip(stages, results) if ok}
+        return not self.pending
+
+    async def _run(self) -> None:
+        started = time.monotonic()
+        while not await self.warm_up():
+            logger.warning("Still warming up %s; retrying in %ss", sorted(self.pending), self.retry_seconds)
+            await asyncio.sleep(self.retry_seconds)
+        self._warm = True
+        logger.info("Backends warmed up in %.1fs", time.monotonic() - started)
+        if self.keepalive_seconds <= 0:
+            return
+        while True:
+            await asyncio.sleep(self.keepalive_seconds)
+            await asyncio.gather(*(self.probe(stage, "keepalive") for stage, (url, _) in PROBES.items() if url()))
+
+
+warmer = Warmer()
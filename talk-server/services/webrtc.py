@@ -0,0 +1,317 @@
+"""WebRTC calls: a browser sends its microphone as an audio track and hears the replies on a return track.
+
+POST /v1/webrtc/offer (routers/webrtc.py) answers the browser's SDP offer, with the ICE candidates
+already gathered, so no further signaling is needed. The inbound track is resampled to 16 kHz mono
+and fed to a ConversationStream, the session manager behind /v1/conversation/stream, so the
+session's turn-detection preset ends turns on the server and nothing is uploaded or downloaded per
+turn. Each reply is synthesized as WAV and played on the return track as TTS produces it. Speech
+over a reply (DWANI_BARGE_IN) cuts it off and cancels its turn.
+
+A data channel opened by the browser gets the stream's JSON events (all but audio_chunk, which the
+track carries) and takes the text, flush and cancel controls of the WebSocket; the call ends when
+the browser closes the connection. Media goes over UDP straight to the worker that answered;
+DWANI_WEBRTC_ICE_SERVERS names STUN or TURN servers for clients behind NAT, and each worker takes
+at most DWANI_WEBRTC_MAX_CALLS calls. Needs the optional aiortc package (requirements-webrtc.txt).
+"""
+import asyncio
+import base64
+import fractions
+import json
+import math
+import struct
+import time
+from typing import Any, Dict, Optional, Set, Tuple
+
+from fastapi import HTTPException
+from prometheus_client import Counter, Gauge
+
+from config import BARGE_IN, WEBRTC_ICE_SERVERS, WEBRTC_MAX_CALLS, logger
+from services.audio_format import transcode
+from services.conversation_stream import ConversationStream
+from services.error_codes import error_fields
+from services.ids import new_id
+from services.telephony import downmix
+from services.turn_detection import TurnDetectionPreset
+
+try:
+    import aiortc
+    import av
+    from aiortc.mediastreams import MediaStreamError
+except ImportError:  # requirements-webrtc.txt is optional
+    aiortc = av = MediaStreamError = None
+
+SAMPLE_RATE = 16000
+FRAME_SAMPLES = SAMPLE_RATE // 50  # 20 ms, an Opus frame
+FRAME_BYTES = FRAME_SAMPLES * 2
+
+_CALLS = Gauge("dwani_webrtc_calls", "WebRTC calls in progress in this worker")
+_TURNS = Counter("dwani_webrtc_turns_total", "Turns heard on WebRTC calls, by how they ended", ["status"])
+
+
+def _wav_data(head: bytes) -> Optional[Tuple[int, int, int]]:
+    """(offset of the samples, sample_rate, channels) once a streamed 16-bit PCM WAV's header has arrived."""
+    offset, fmt = 12, None
+    while offset + 8 <= len(head):
+        chunk, size = struct.unpack("<4sI", head[offset:offset + 8])
+        if chunk == b"data":
+            # A streamed WAV's data size is often a placeholder; the samples run to the end.
+            if fmt is None or fmt[0] not in (1, 0xFFFE) or fmt[5] != 16 or fmt[1] < 1:
+                return None
+            return offset + 8, fmt[2], fmt[1]
+        if offset + 8 + size > len(head):
+            return None
+        if chunk == b"fmt " and size >= 16:
+            fmt = struct.unpack("<HHIIHH", head[offset + 8:offset + 24])
+        offset += 8 + size + (size & 1)
+    return None
+
+
+class ReplyPCM:
+    """A reply's audio as it streams in, converted to 16 kHz mono PCM piece by piece.
+
+    Replies that turn out not to be 16-bit PCM WAV are kept whole and converted with ffmpeg at the end.
+    """
+
+    def __init__(self) -> None:
+        self._head = bytearray()
+        self._format: Optional[Tuple[int, int]] = None  # (sample_rate, channels)
+        self._block = 2  # bytes of input that resample to a whole number of output samples
+        self._rest = b""
+
+    def feed(self, chunk: bytes) -> bytes:
+        if self._format is None:
+            self._head.extend(chunk)
+            found = _wav_data(bytes(self._head)) if self._head[:4] == b"RIFF" and self._head[8:12] == b"WAVE" else None
+            if found is None:
+                return b""
+            offset, self._format = found[0], found[1:]
+            sample_rate, channels = self._format
+            self._block = 2 * channels * (sample_rate // math.gcd(sample_rate, SAMPLE_RATE))
+            chunk = bytes(self._head[offset:])
+            self._head.clear()
+        sample_rate, channels = self._format
+        data = self._rest + chunk
+        usable = len(data) // self._block * self._block
+        self._rest = data[usable:]
+        return downmix(data[:usable], sample_rate, channels, SAMPLE_RATE)
+
+    async def finish(self) -> bytes:
+        """The PCM of a reply that was not 16-bit PCM WAV (empty otherwise)."""
+        if self._format is not None or not self._head:
+            return b""
+        audio = await transcode(bytes(self._head), "wav", sample_rate=SAMPLE_RATE)
+        self._head.clear()
+        return self.feed(audio)
+
+
+class ReplyAudio:
+    """Reply PCM waiting to be played, handed to the return track 20 ms at a time (silence when there is none)."""
+
+    def __init__(self) -> None:
+        self._pending = bytearray()
+
+    @property
+    def playing(self) -> bool:
+        return bool(self._pending)
+
+    def play(self, pcm: bytes) -> None:
+        self._pending.extend(pcm)
+
+    def clear(self) -> None:
+        self._pending.clear()
+
+    def next_frame(self) -> bytes:
+        frame = bytes(self._pending[:FRAME_BYTES])
+        del self._pending[:FRAME_BYTES]
+        return frame + b"\0" * (FRAME_BYTES - len(frame))
+
+
+class WebRTCCall:
+    """One browser's call: its microphone feeds a ConversationStream, the replies go to ReplyAudio."""
+
+    def __init__(
+        self,
+        preset: TurnDetectionPreset,
+        session_id: str,
+        language: Optional[str] = None,
+        mode: str = "llm",
+        agent_name: Optional[str] = None,
+        request_id: Optional[str] = None,
+    ):
+        self.id = new_id("call")
+        self.session_id = session_id
+        self.audio = ReplyAudio()
+        self.channel: Optional[Any] = None  # the browser's data channel, once it opens one
+        self.pc: Optional[Any] = None
+        self._replies: Dict[int, ReplyPCM] = {}
+        self._open_turns: Set[int] = set()
+        self._listener: Optional[asyncio.Task] = None
+        self.stream = ConversationStream(
+            self._on_event,
+            preset,
+            session_id,
+            sample_rate=SAMPLE_RATE,
+            language=language,
+            mode=mode,
+            agent_name=agent_name,
+            audio_format="wav",
+            request_id=request_id,
+        )
+
+    async def control(self, message: str) -> None:
+        """A control message from the data channel, as on /v1/conversation/stream."""
+        try:
+            control = json.loads(message)
+        except json.JSONDecodeError:
+            control = None
+        kind = control.get("type") if isinstance(control, dict) else None
+        if kind == "text" and isinstance(control.get("text"), str) and control["text"].strip():
+            await self.stream.text(control["text"])
+        elif kind == "flush":
+            await self.stream.flush()
+        elif kind == "cancel":
+            self.audio.clear()
+            self.stream.cancel()
+        else:
+            detail = 'expected {"type": "text" | "flush" | "cancel"}'
+            self._send({"type": "error", "code": "400", "message": detail, **error_fields("invalid_request")})
+
+    @property
+    def listening(self) -> bool:
+        return self._listener is not None
+
+    def attach(self, track: Any) -> None:
+        """Start feeding the browser's audio track to the conversation."""
+        self._listener = asyncio.create_task(self.listen(track))
+
+    async def close(self) -> None:
+        if self._listener is not None and not self._listener.done():
+            self._listener.cancel()
+            await asyncio.gather(self._listener, return_exceptions=True)
+        await self.stream.close()
+        if self.pc is not None:
+            await self.pc.close()
+
+    def _send(self, event: Dict[str, Any]) -> None:
+        if self.channel is not None and self.channel.readyState == "open":
+            self.channel.send(json.dumps(event, ensure_ascii=False))
+
+    async def _on_event(self, event: Dict[str, Any]) -> None:
+        turn = event.get("turn")
+        if event["type"] == "audio_chunk":
+            self.audio.play(self._replies.setdefault(turn, ReplyPCM()).feed(base64.b64decode(event["audio_base64"])))
+            return
+        if event["type"] == "turn_start":
+            if BARGE_IN and event.get("input") == "audio" and (self.audio.playing or self._open_turns):
+                logger.info("WebRTC call %s: caller spoke over the reply", self.id)
+                self.audio.clear()
+                self.stream.cancel()
+            self._open_turns.add(turn)
+        elif event["type"] == "turn_end":
+            self._open_turns.discard(turn)
+            reply = self._replies.pop(turn, None)
+            if reply is not None and event["status"] == "completed":
+                try:
+                    self.audio.play(await reply.finish())
+                except HTTPException as exc:
+                    logger.error("WebRTC call %s: reply could not be played: %s", self.id, exc.detail)
+            _TURNS.labels(status=event["status"]).inc()
+        self._send(event)
+
+    async def listen(self, track: Any) -> None:
+        """Feed the browser's audio track to the conversation until it ends."""
+        resampler = av.AudioResampler(format="s16", layout="mono", rate=SAMPLE_RATE)
+        while True:
+            try:
+                frame = await track.recv()
+            except MediaStreamError:
+                return
+            for resampled in resampler.resample(frame):
+                await self.stream.feed(bytes(resampled.planes[0])[: resampled.samples * 2])
+
+
+if aiortc is not None:
+    class _ReplyTrack(aiortc.MediaStreamTrack):
+        """The return track: 20 ms frames of the call's ReplyAudio, in real time."""
+
+        kind = "audio"
+
+        def __init__(self, audio: ReplyAudio):
+            super().__init__()
+            self.audio = audio
+            self._started: Optional[float] = None
+            self._pts = 0
+
+        async def recv(self) -> Any:
+            if self.readyState != "live":
+                raise MediaStreamError
+            if self._started is None:
+                self._started = time.monotonic()
+            else:
+                await asyncio.sleep(max(0.0, self._started + self._pts / SAMPLE_RATE - time.monotonic()))
+            frame = av.AudioFrame(format="s16", layout="mono", samples=FRAME_SAMPLES)
+            frame.planes[0].update(self.audio.next_frame())
+            frame.sample_rate = SAMPLE_RATE
+            frame.pts = self._pts
+            frame.time_base = fractions.Fraction(1, SAMPLE_RATE)
+            self._pts += FRAME_SAMPLES
+            return frame
+
+
+calls: Dict[str, WebRTCCall] = {}
+
+
+async def answer(call: WebRTCCall, sdp: str, sdp_type: str) -> str:
+    """Accept the browser's offer for call; the SDP answer. 501 without aiortc, 503 when the worker is full."""
+    if aiortc is None:
+        raise HTTPException(status_code=501, detail="WebRTC needs aiortc: pip install -r requirements-webrtc.txt")
+    if len(calls) >= WEBRTC_MAX_CALLS:
+        raise HTTPException(status_code=503, detail="Too many WebRTC calls; retry shortly", headers={"Retry-After": "5"})
+    servers = [aiortc.RTCIceServer(urls=url) for url in WEBRTC_ICE_SERVERS]
+    pc = call.pc = aiortc.RTCPeerConnection(aiortc.RTCConfiguration(iceServers=servers))
+
+    @pc.on("track")
+    def on_track(track: Any) -> None:
+        if track.kind == "audio" and not call.listening:
+            pc.addTrack(_ReplyTrack(call.audio))
+            call.attach(track)
+
+    @pc.on("datachannel")
+    def on_datachannel(channel: Any) -> None:
+        call.channel = channel
+
+        @channel.on("message")
+        async def on_message(message: Any) -> None:
+            if isinstance(message, str):
+                await call.control(message)
+
+    @pc.on("connectionstatechange")
+    async def on_state() -> None:
+        if pc.connectionState in ("failed", "closed") and calls.pop(call.id, None) is not None:
+            _CALLS.dec()
+            logger.info("WebRTC call %s ended (%s)", call.id, pc.connectionState)
+            await call.close()
+
+    try:
+        await call.stream.start()
+        await pc.setRemoteDescription(aiortc.RTCSessionDescription(sdp=sdp, type=sdp_type))
+        if not call.listening:
+            raise HTTPException(status_code=400, detail="The offer must send an audio track")
+        await pc.setLocalDescription(await pc.createAnswer())
+    except (ValueError, HTTPException) as exc:
+        await call.close()
+        if isinstance(exc, HTTPException):
+            raise
+        raise HTTPException(status_code=400, detail=f"Invalid SDP offer: {exc}")
+    calls[call.id] = call
+    _CALLS.inc()
+    logger.info("WebRTC call %s started (session %s)", call.id, call.session_id)
+    return pc.localDescription.sdp
+
+
+async def close_all() -> None:
+    """Hang up every call (at shutdown)."""
+    for call in list(calls.values()):
+        await call.close()
+    calls.clear()
+    _CALLS.set(0)
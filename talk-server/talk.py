@@ -0,0 +1,444 @@
+"""Container entry points, so the image needs no shell wrappers or separate init, and a CLI for the pipeline.
+
+    python talk.py serve                  # Gunicorn (gunicorn.conf.py), or Hypercorn for HTTP/2; as PID 1 it also forwards signals and reaps children
+    python talk.py healthcheck            # exit 0 when the local /ready reports ok, else 1 (Docker HEALTHCHECK)
+    python talk.py drain                  # fail /ready and wait for in-flight requests (Kubernetes preStop hook)
+    python talk.py transcribe file.wav    # print the transcript
+    python talk.py speak "text" -o a.mp3  # synthesize speech
+    python talk.py converse file.wav      # one full turn: print the transcript and reply, -o saves the reply audio
+    python talk.py replay [request_id]    # re-run recorded requests (DWANI_RECORD_DIR) and diff transcripts and replies
+
+transcribe, speak, converse and replay run the pipeline library (services/pipeline.py) in this process against
+the DWANI_* backends, or the mock backends with --mock, so scripts and smoke tests need no running server.
+healthcheck and drain only use the standard library, so they stay cheap under a short HEALTHCHECK interval.
+"""
+import argparse
+import asyncio
+import difflib
+import http.client
+import json
+import mimetypes
+import os
+import signal
+import socket
+import ssl
+import subprocess
+import sys
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Awaitable, Callable, Dict, List, Optional, Union
+
+_FORWARDED_SIGNALS = (signal.SIGTERM, signal.SIGINT, signal.SIGHUP, signal.SIGQUIT, signal.SIGUSR1, signal.SIGUSR2, signal.SIGTTIN, signal.SIGTTOU)
+
+
+_UNIX_SCHEME = "http+unix://"
+
+
+def local_url(path: str = "/ready") -> str:
+    """path on this server, from DWANI_BIND and whether TLS is configured.
+
+    The first TCP listener is used; without one, the first Unix socket, as
+    http+unix://<percent-encoded socket path><path>. fd:// and systemd sockets have no address
+    to find, so those servers are assumed on port 8000 (pass --url otherwise).
+    """
+    binds = [address.strip() for address in os.getenv("DWANI_BIND", "0.0.0.0:8000").split(",") if address.strip()]
+    tcp = [address for address in binds if not address.startswith(("unix:", "fd://"))]
+    unix = [address[len("unix:"):] for address in binds if address.startswith("unix:")]
+    if not tcp and unix:
+        return f"{_UNIX_SCHEME}{urllib.parse.quote(unix[0], safe='')}{path}"
+    port = (tcp[0] if tcp else "").rpartition(":")[2] or "8000"
+    scheme = "https" if os.getenv("DWANI_TLS_CERT_FILE") else "http"
+    return f"{scheme}://127.0.0.1:{port}{path}"
+
+
+class _UnixConnection(http.client.HTTPConnection):
+    """HTTP over the Unix socket at socket_path."""
+
+    def __init__(self, socket_path: str, timeout: float):
+        super().__init__("localhost", timeout=timeout)
+        self.socket_path = socket_path
+
+    def connect(self) -> None:
+        self.sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self.sock.settimeout(self.timeout)
+        self.sock.connect(self.socket_path)
+
+
+def _urlopen(target: Union[str, urllib.request.Request], timeout: float, context: Optional[ssl.SSLContext]):
+    """urllib.request.urlopen, also for http+unix:// URLs from local_url()."""
+    url = target if isinstance(target, str) else target.full_url
+    if not url.startswith(_UNIX_SCHEME):
+        return urllib.request.urlopen(target, timeout=timeout, context=context)
+    socket_path, _, path = url[len(_UNIX_SCHEME):].partition("/")
+    connection = _UnixConnection(urllib.parse.unquote(socket_path), timeout)
+    try:
+        if isinstance(target, str):
+            connection.request("GET", f"/{path}")
+        else:
+            connection.request(target.get_method(), f"/{path}", headers=dict(target.header_items()))
+        response = connection.getresponse()
+    except http.client.HTTPException as exc:
+        raise urllib.error.URLError(exc) from exc
+    if response.status >= 400:
+        raise urllib.error.HTTPError(url, response.status, response.reason, response.headers, response)
+    return response
+
+
+def _local_context(url: str) -> Optional[ssl.SSLContext]:
+    if not url.startswith("https://"):
+        return None
+    # The certificate names the public host, not 127.0.0.1.
+    context = ssl.create_default_context()
+    context.check_hostname = False
+    context.verify_mode = ssl.CERT_NONE
+    return context
+
+
+def healthcheck(url: str, timeout: float = 5.0) -> int:
+    """0 when url answers 200 with status "ok" (or no JSON status at all), otherwise 1."""
+    context = _local_context(url)
+    try:
+        with _urlopen(url, timeout=timeout, context=context) as resp:
+            body = resp.read()
+    except (urllib.error.URLError, OSError) as exc:
+        print(f"unhealthy: {exc}", file=sys.stderr)
+        return 1
+    try:
+        status = json.loads(body).get("status", "ok")
+    except (ValueError, AttributeError):
+        status = "ok"
+    if status != "ok":
+        print(f"unhealthy: {body.decode('utf-8', 'replace')}", file=sys.stderr)
+        return 1
+    return 0
+
+
+def drain(url: str, timeout: float) -> int:
+    """POST url (/admin/drain) with DWANI_ADMIN_TOKEN; 0 once the server's in-flight requests finished, else 1."""
+    request = urllib.request.Request(
+        f"{url}?timeout={timeout:g}",
+        method="POST",
+        headers={"X-Admin-Token": os.getenv("DWANI_ADMIN_TOKEN", "").strip()},
+    )
+    context = _local_context(url)
+    try:
+        with _urlopen(request, timeout=timeout + 5, context=context) as resp:
+            body = json.loads(resp.read())
+    except (urllib.error.URLError, OSError, ValueError) as exc:
+        print(f"drain failed: {exc}", file=sys.stderr)
+        return 1
+    if not body.get("idle"):
+        print(f"drain timed out with {body.get('in_flight')} requests in flight", file=sys.stderr)
+        return 1
+    return 0
+
+
+def server_command(extra_args: List[str]) -> List[str]:
+    """Gunicorn, or Hypercorn for HTTP/2 with DWANI_HTTP2=1; SystemExit for HTTP/2 in cleartext without DWANI_H2C=1."""
+    if os.getenv("DWANI_HTTP2", "0") != "1":
+        return ["gunicorn", "-c", "gunicorn.conf.py", *extra_args, "main:app"]
+    if not os.getenv("DWANI_TLS_CERT_FILE") and os.getenv("DWANI_H2C", "0") != "1":
+        raise SystemExit("DWANI_HTTP2=1 needs DWANI_TLS_CERT_FILE, or DWANI_H2C=1 to serve HTTP/2 in cleartext")
+    return ["hypercorn", "--config", "file:hypercorn.conf.py", *extra_args, "main:app"]
+
+
+def serve(extra_args: List[str]) -> int:
+    command = server_command(extra_args)
+    if os.getpid() != 1:
+        os.execvp(command[0], command)
+    # As PID 1 the kernel delivers no signal the process has not handled, and orphaned
+    # processes are re-parented here; forward signals to the server and reap everything.
+    child = subprocess.Popen(command)
+    for signum in _FORWARDED_SIGNALS:
+        signal.signal(signum, lambda received, _frame: child.send_signal(received))
+    while True:
+        try:
+            pid, status = os.wait()
+        except ChildProcessError:
+            return child.returncode or 0
+        except InterruptedError:
+            continue
+        if pid == child.pid:
+            code = os.waitstatus_to_exitcode(status)
+            return code if code >= 0 else 128 - code
+
+
+def _read_input(path: str) -> bytes:
+    if path == "-":
+        return sys.stdin.buffer.read()
+    with open(path, "rb") as f:
+        return f.read()
+
+
+def _write_output(path: str, audio: bytes) -> None:
+    if path == "-":
+        sys.stdout.buffer.write(audio)
+        sys.stdout.buffer.flush()
+        return
+    with open(path, "wb") as f:
+        f.write(audio)
+    print(f"wrote {len(audio)} bytes to {path}", file=sys.stderr)
+
+
+def run_pipeline(mock: bool, call: Callable[[Any], Awaitable[Dict[str, Any]]]) -> Optional[Dict[str, Any]]:
+    """call(pipeline) on an in-process Pipeline; None, after printing why, when a stage fails."""
+    # Imported here so healthcheck and drain do not load the application.
+    from fastapi import HTTPException
+
+    from services import Pipeline, mock as mock_backends
+    from services.http_client import close_http_client
+
+    if mock:
+        mock_backends.enable()
+
+    async def run() -> Dict[str, Any]:
+        await close_http_client()  # a client of this event loop, with the mock transport when asked for
+        try:
+            async with Pipeline() as pipeline:
+                return await call(pipeline)
+        finally:
+            await close_http_client()
+
+    try:
+        return asyncio.run(run())
+    except HTTPException as exc:
+        print(f"error: {exc.detail}", file=sys.stderr)
+        return None
+
+
+def transcribe(path: str, language: Optional[str], mock: bool, as_json: bool) -> int:
+    audio = _read_input(path)
+    mime_type = (mimetypes.guess_type(path)[0] if path != "-" else None) or "audio/wav"
+
+    async def call(pipeline) -> Dict[str, Any]:
+        return {"text": await pipeline.transcribe(audio, mime_type=mime_type, language=language)}
+
+    result = run_pipeline(mock, call)
+    if result is None:
+        return 1
+    print(json.dumps(result, ensure_ascii=False) if as_json else result["text"])
+    return 0
+
+
+def speak(text: str, language: Optional[str], mock: bool, output: Optional[str]) -> int:
+    async def call(pipeline) -> Dict[str, Any]:
+        from services.audio_format import detect_format
+
+        audio = await pipeline.synthesize(text, language=language)
+        return {"audio": audio, "format": detect_format(None, audio[:16]) or "mp3"}
+
+    result = run_pipeline(mock, call)
+    if result is None:
+        return 1
+    _write_output(output or f"speech.{result['format']}", result["audio"])
+    return 0
+
+
+def converse(
+    path: Optional[str], text: Optional[str], language: Optional[str], mock: bool, output: Optional[str], as_json: bool
+) -> int:
+    audio = _read_input(path) if path else None
+    mime_type = (mimetypes.guess_type(path)[0] if path and path != "-" else None) or "audio/wav"
+
+    async def call(pipeline) -> Dict[str, Any]:
+        return {"turn": await pipeline.speech_to_speech(audio=audio, mime_type=mime_type, text=text, language=language)}
+
+    outcome = run_pipeline(mock, call)
+    if outcome is None:
+        return 1
+    result = outcome["turn"]
+    if output:
+        _write_output(output, result.audio)
+    if as_json:
+        print(json.dumps(
+            {"transcription": result.transcription, "reply": result.reply, "language": result.language},
+            ensure_ascii=False,
+        ))
+    else:
+        print(f"you: {result.transcription}\nreply: {result.reply}")
+    return 0
+
+
+def _recorded_inputs(recorder, request_id: str) -> Optional[Dict[str, Any]]:
+    """What a recording's turn was given and answered, from its manifest; None when it is gone."""
+    manifest = recorder.manifest(request_id)
+    if manifest is None:
+        return None
+    by_stage: Dict[str, List[str]] = {}
+    for artifact in manifest["artifacts"]:
+        by_stage.setdefault(artifact["stage"], []).append(artifact["name"])
+
+    def text(stage: str, last: bool = False) -> Optional[str]:
+        names = by_stage.get(stage)
+        data = recorder.read_artifact(request_id, names[-1 if last else 0]) if names else None
+        return data.decode("utf-8") if data is not None else None
+
+    audio_name = (by_stage.get("asr_input") or [None])[0]
+    reply = text("tts_input", last=True)
+    return {
+        "manifest": manifest,
+        "audio": recorder.read_artifact(request_id, audio_name) if audio_name else None,
+        "mime_type": recorder.media_type(audio_name) if audio_name else None,
+        "text": None if audio_name else text("llm_input"),
+        "transcript": text("transcript"),
+        "reply": reply if reply is not None else text("llm_reply", last=True),
+    }
+
+
+def _diff(request_id: str, field: str, recorded: Optional[str], replayed: Optional[str]) -> List[str]:
+    if recorded is None or recorded == replayed:
+        return []
+    return list(difflib.unified_diff(
+        recorded.splitlines(), (replayed or "").splitlines(),
+        f"{request_id}/{field} (recorded)", f"{request_id}/{field} (replayed)", lineterm="",
+    ))
+
+
+def replay(request_ids: List[str], directory: Optional[str], limit: int, mock: bool, as_json: bool) -> int:
+    """Re-run recorded requests through the pipeline; 0 when every transcript and reply came out the same."""
+    from services.language_id import normalize_language
+    from services.recorder import ArtifactRecorder
+
+    recorder = ArtifactRecorder(directory) if directory else ArtifactRecorder()
+    request_ids = request_ids or [summary["request_id"] for summary in recorder.recent(limit)]
+    if not request_ids:
+        print(f"no recordings under {recorder.directory}", file=sys.stderr)
+        return 1
+
+    async def call(pipeline) -> Dict[str, Any]:
+        from fastapi import HTTPException
+
+        outcomes = []
+        for request_id in request_ids:
+            outcome: Dict[str, Any] = {"request_id": request_id}
+            outcomes.append(outcome)
+            try:
+                recorded = _recorded_inputs(recorder, request_id)
+            except (OSError, ValueError) as exc:
+                outcome.update(status="failed", error=str(exc))
+                continue
+            if recorded is None:
+                outcome.update(status="failed", error="no such recording")
+                continue
+            if recorded["audio"] is None and recorded["text"] is None:
+                outcome.update(status="failed", error="no recorded audio or text to replay")
+                continue
+            manifest = recorded["manifest"]
+            try:
+                result = await pipeline.speech_to_speech(
+                    audio=recorded["audio"],
+                    mime_type=recorded["mime_type"] or "audio/wav",
+                    text=recorded["text"],
+                    mode=manifest.get("mode") or "llm",
+                    agent_name=manifest.get("agent_name"),
+                    language=normalize_language(manifest.get("language")),
+                )
+            except HTTPException as exc:
+                outcome.update(status="failed", error=str(exc.detail))
+                continue
+            diff = (
+                _diff(request_id, "transcript", recorded["transcript"], result.transcription)
+                + _diff(request_id, "reply", recorded["reply"], result.reply)
+            )
+            outcome.update(
+                status="changed" if diff else "same",
+                transcript={"recorded": recorded["transcript"], "replayed": result.transcription},
+                reply={"recorded": recorded["reply"], "replayed": result.reply},
+                diff=diff,
+            )
+        return {"outcomes": outcomes}
+
+    result = run_pipeline(mock, call)
+    if result is None:
+        return 1
+    outcomes = result["outcomes"]
+    for outcome in outcomes:
+        if as_json:
+            print(json.dumps(outcome, ensure_ascii=False))
+        elif outcome["status"] == "failed":
+            print(f"failed {outcome['request_id']}: {outcome['error']}")
+        else:
+            print(f"{outcome['status']} {outcome['request_id']}")
+            for line in outcome["diff"]:
+                print(f"  {line}")
+    counts = {status: sum(o["status"] == status for o in outcomes) for status in ("same", "changed", "failed")}
+    print(
+        f"replayed {len(outcomes)}: {counts['same']} same, {counts['changed']} changed, {counts['failed']} failed",
+        file=sys.stderr,
+    )
+    return 0 if counts["same"] == len(outcomes) else 1
+
+
+def main(argv: Optional[List[str]] = None) -> int:
+    parser = argparse.ArgumentParser(description="dwani talk-server")
+    commands = parser.add_subparsers(dest="command", required=True)
+    serve_parser = commands.add_parser("serve", help="run the server with Gunicorn (Hypercorn with DWANI_HTTP2=1)")
+    serve_parser.add_argument("--mock", action="store_true", help="canned ASR, LLM and TTS responses (services/mock.py)")
+    serve_parser.add_argument("gunicorn_args", nargs=argparse.REMAINDER, help="extra Gunicorn (or Hypercorn) arguments")
+    check = commands.add_parser("healthcheck", help="probe the local readiness endpoint")
+    check.add_argument("--url", help="URL to probe (default: /ready on DWANI_BIND's port)")
+    check.add_argument("--timeout", type=float, default=5.0)
+    drain_parser = commands.add_parser("drain", help="fail readiness and wait for in-flight requests")
+    drain_parser.add_argument("--url", help="drain endpoint (default: /admin/drain on DWANI_BIND's port)")
+    drain_parser.add_argument("--timeout", type=float, default=float(os.getenv("DWANI_DRAIN_TIMEOUT") or 30))
+    pipeline_options = argparse.ArgumentParser(add_help=False)
+    pipeline_options.add_argument("--language", help="language name or BCP-47 tag, such as kannada or kn-IN")
+    pipeline_options.add_argument("--mock", action="store_true", help="canned ASR, LLM and TTS responses (services/mock.py)")
+    transcribe_parser = commands.add_parser("transcribe", parents=[pipeline_options], help="transcribe an audio file")
+    transcribe_parser.add_argument("file", help="audio file, or - for standard input")
+    transcribe_parser.add_argument("--json", action="store_true", help="print a JSON object instead of the text")
+    speak_parser = commands.add_parser("speak", parents=[pipeline_options], help="synthesize speech from text")
+    speak_parser.add_argument("text")
+    speak_parser.add_argument("-o", "--output", help="audio file to write, or - for standard output (default: speech.<format>)")
+    converse_parser = commands.add_parser("converse", parents=[pipeline_options], help="run one full turn: ASR, LLM and TTS")
+    converse_parser.add_argument("file", nargs="?", help="audio file, or - for standard input")
+    converse_parser.add_argument("--text", help="say this instead of an audio file (skips ASR)")
+    converse_parser.add_argument("-o", "--output", help="write the reply audio to this file, or - for standard output")
+    converse_parser.add_argument("--json", action="store_true", help="print a JSON object instead of the transcript and reply")
+    replay_parser = commands.add_parser(
+        "replay", help="re-run recorded requests and diff their transcripts and replies against the recording",
+    )
+    replay_parser.add_argument("--mock", action="store_true", help="canned ASR, LLM and TTS responses (services/mock.py)")
+    replay_parser.add_argument("request_ids", nargs="*", metavar="request_id", help="recordings to replay (default: the newest)")
+    replay_parser.add_argument("--dir", help="recordings directory (default: DWANI_RECORD_DIR)")
+    replay_parser.add_argument("--limit", type=int, default=20, help="how many of the newest recordings to replay (default: 20)")
+    replay_parser.add_argument("--json", action="store_true", help="print one JSON object per recording")
+    args = parser.parse_args(argv)
+
+    if args.command == "replay":
+        if args.mock:
+            os.environ["DWANI_MOCK_BACKENDS"] = "1"
+        return replay(args.request_ids, args.dir, args.limit, args.mock, args.json)
+    if args.command in ("transcribe", "speak", "converse"):
+        if args.command == "converse" and bool(args.file) == bool(args.text):
+            parser.error("converse needs an audio file or --text")
+        if args.mock:
+            os.environ["DWANI_MOCK_BACKENDS"] = "1"
+        language = None
+        if args.language:
+            from services.language_id import normalize_language
+
+            language = normalize_language(args.language)
+            if language is None:
+                parser.error(f"unknown language {args.language!r}")
+        try:
+            if args.command == "transcribe":
+                return transcribe(args.file, language, args.mock, args.json)
+            if args.command == "speak":
+                return speak(args.text, language, args.mock, args.output)
+            return converse(args.file, args.text, language, args.mock, args.output, args.json)
+        except OSError as exc:
+            print(f"error: {exc}", file=sys.stderr)
+            return 1
+    if args.command == "serve":
+        if args.mock:
+            os.environ["DWANI_MOCK_BACKENDS"] = "1"
+        return serve(args.gunicorn_args)
+    if args.command == "drain":
+        return drain(args.url or local_url("/admin/drain"), args.timeout)
+    return healthcheck(args.url or local_url(), args.timeout)
+
+
+if __name__ == "__main__":
+    sys.exit(main())
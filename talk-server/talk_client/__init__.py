@@ -0,0 +1,27 @@
+"""Typed async client for the talk-server HTTP and WebSocket API.
+
+For Python services that call a talk-server over the network; they need only httpx (and
+websockets for stream()), not the server's own dependencies.
+
+    from talk_client import TalkClient
+
+    async with TalkClient("https://talk.example.com", api_key="...") as talk:
+        turn = await talk.speech_to_speech(wav_bytes, language="kannada", session_id="s-1")
+        text = (await talk.transcribe(wav_bytes)).text
+        mp3 = await talk.synthesize("ನಮಸ್ಕಾರ", language="kannada")
+        async with talk.stream(language="kannada") as stream:
+            await stream.send_audio(pcm)
+            await stream.end()
+            async for event in stream:
+                ...
+
+Failed requests raise TalkError with the server's error envelope. Requests that fail with
+429, 502, 503 or 504, or cannot connect, are retried with backoff (honouring Retry-After).
+speech_to_speech sends an Idempotency-Key, so a retried turn is not run or recorded twice.
+deadline= on a call bounds it, retries included, and is sent as X-Deadline-Ms so the
+server gives up at the same time; cancelling the awaiting task cancels the request.
+"""
+from talk_client.client import SpeechResult, TalkClient, TalkError, Transcription
+from talk_client.stream import StreamSession
+
+__all__ = ["SpeechResult", "StreamSession", "TalkClient", "TalkError", "Transcription"]
@@ -0,0 +1,250 @@
+"""TalkClient: the HTTP calls, retries and error handling (see talk_client/__init__.py)."""
+import asyncio
+import base64
+import time
+import uuid
+from dataclasses import dataclass, field
+from email.utils import parsedate_to_datetime
+from typing import Any, Dict, List, Optional
+from urllib.parse import urlencode
+
+import httpx
+
+from talk_client.stream import StreamSession
+
+_RETRY_STATUSES = {429, 502, 503, 504}
+_MAX_RETRY_AFTER = 30.0
+
+
+class TalkError(Exception):
+    """A request the server refused or could not complete; status_code is None when no response arrived."""
+
+    def __init__(
+        self,
+        status_code: Optional[int],
+        message: str,
+        error_code: Optional[str] = None,
+        localized_message: Optional[str] = None,
+        stage: Optional[str] = None,
+        request_id: Optional[str] = None,
+    ):
+        super().__init__(f"{status_code or 'no response'}: {message}")
+        self.status_code = status_code
+        self.message = message
+        self.error_code = error_code
+        self.localized_message = localized_message
+        self.stage = stage
+        self.request_id = request_id
+
+    @classmethod
+    def from_response(cls, response: httpx.Response) -> "TalkError":
+        try:
+            body = response.json()
+        except ValueError:
+            body = None
+        error = body.get("error") if isinstance(body, dict) and isinstance(body.get("error"), dict) else {}
+        detail = body.get("detail") if isinstance(body, dict) else None
+        return cls(
+            response.status_code,
+            error.get("message") or (detail if isinstance(detail, str) else None) or response.reason_phrase,
+            error_code=error.get("error_code"),
+            localized_message=error.get("localized_message"),
+            stage=error.get("stage"),
+            request_id=error.get("request_id") or response.headers.get("X-Request-ID"),
+        )
+
+
+@dataclass
+class SpeechResult:
+    transcription: str
+    reply: str
+    audio: bytes
+    content_type: str
+    detected_language: Optional[str] = None
+    request_id: Optional[str] = None
+    raw: Dict[str, Any] = field(default_factory=dict, repr=False)  # the whole JSON response
+
+
+@dataclass
+class Transcription:
+    text: str
+    language: Optional[str] = None
+    duration: Optional[float] = None
+    segments: List[Dict[str, Any]] = field(default_factory=list)
+    alternatives: List[str] = field(default_factory=list)
+
+
+def _retry_after(response: httpx.Response) -> Optional[float]:
+    value = response.headers.get("Retry-After")
+    if not value:
+        return None
+    try:
+        return min(max(float(value), 0.0), _MAX_RETRY_AFTER)
+    except ValueError:
+        pass
+    try:
+        return min(max(parsedate_to_datetime(value).timestamp() - time.time(), 0.0), _MAX_RETRY_AFTER)
+    except (TypeError, ValueError):
+        return None
+
+
+class TalkClient:
+    """Client for one talk-server; use it as an async context manager, or call aclose().
+
+    http_client replaces the client's own httpx.AsyncClient (which it then does not close),
+    e.g. to share a connection pool or to test against httpx.MockTransport.
+    """
+
+    def __init__(
+        self,
+        base_url: str = "http://localhost:8000",
+        api_key: Optional[str] = None,
+        timeout: float = 60.0,
+        retries: int = 2,
+        backoff: float = 0.5,
+        http_client: Optional[httpx.AsyncClient] = None,
+    ):
+        self.base_url = base_url.rstrip("/")
+        self.api_key = api_key
+        self.timeout = timeout
+        self.retries = retries
+        self.backoff = backoff
+        self._http = http_client or httpx.AsyncClient()
+        self._owns_http = http_client is None
+
+    async def __aenter__(self) -> "TalkClient":
+        return self
+
+    async def __aexit__(self, *exc_info) -> None:
+        await self.aclose()
+
+    async def aclose(self) -> None:
+        if self._owns_http:
+            await self._http.aclose()
+
+    def _headers(self) -> Dict[str, str]:
+        return {"X-API-Key": self.api_key} if self.api_key else {}
+
+    async def _request(
+        self, method: str, path: str, deadline: Optional[float] = None, headers: Optional[Dict[str, str]] = None, **kwargs: Any
+    ) -> httpx.Response:
+        """The response to a request, retried on connection failures and 429/502/503/504; TalkError otherwise."""
+        started = time.monotonic()
+        for attempt in range(self.retries + 1):
+            timeout = self.timeout
+            sent = {**self._headers(), **(headers or {})}
+            if deadline is not None:
+                left = deadline - (time.monotonic() - started)
+                if left <= 0:
+                    raise TalkError(None, f"deadline of {deadline:g}s exceeded", error_code="timeout")
+                timeout = min(timeout, left)
+                sent["X-Deadline-Ms"] = str(max(1, int(left * 1000)))
+            try:
+                response = await self._http.request(method, f"{self.base_url}{path}", headers=sent, timeout=timeout, **kwargs)
+            except (httpx.ConnectError, httpx.ConnectTimeout) as exc:
+                if attempt == self.retries:
+                    raise TalkError(None, f"could not connect: {exc}", error_code="unavailable") from exc
+                await asyncio.sleep(self.backoff * 2 ** attempt)
+                continue
+            except httpx.TimeoutException as exc:
+                raise TalkError(None, "the server did not answer in time", error_code="timeout") from exc
+            except httpx.HTTPError as exc:
+                raise TalkError(None, str(exc) or type(exc).__name__, error_code="unavailable") from exc
+            if response.status_code in _RETRY_STATUSES and attempt < self.retries:
+                delay = _retry_after(response)
+                await asyncio.sleep(self.backoff * 2 ** attempt if delay is None else delay)
+                continue
+            if response.is_error:
+                raise TalkError.from_response(response)
+            return response
+        raise AssertionError("unreachable")
+
+    async def speech_to_speech(
+        self,
+        audio: Optional[bytes] = None,
+        *,
+        text: Optional[str] = None,
+        filename: str = "audio.wav",
+        content_type: str = "audio/wav",
+        language: Optional[str] = None,
+        session_id: Optional[str] = None,
+        mode: str = "llm",
+        agent_name: Optional[str] = None,
+        persona: Optional[str] = None,
+        deadline: Optional[float] = None,
+    ) -> SpeechResult:
+        """One turn: audio (or text, skipping ASR) in, the transcript, reply and reply audio out."""
+        if (audio is None) == (text is None):
+            raise ValueError("pass audio or text")
+        params = {"format": "json", "mode": mode, "language": language, "agent_name": agent_name, "persona": persona}
+        headers = {"Idempotency-Key": uuid.uuid4().hex, **({"X-Session-ID": session_id} if session_id else {})}
+        response = await self._request(
+            "POST",
+            "/v1/speech_to_speech",
+            deadline=deadline,
+            headers=headers,
+            params={k: v for k, v in params.items() if v is not None},
+            files={"file": (filename, audio, content_type)} if audio is not None else None,
+            data={"text": text} if text is not None else None,
+        )
+        body = response.json()
+        return SpeechResult(
+            transcription=body["transcription"],
+            reply=body["llm_response"],
+            audio=base64.b64decode(body["audio_base64"]),
+            content_type=body.get("audio_content_type") or "audio/mpeg",
+            detected_language=body.get("detected_language"),
+            request_id=response.headers.get("X-Request-ID"),
+            raw=body,
+        )
+
+    async def transcribe(
+        self,
+        audio: bytes,
+        *,
+        filename: str = "audio.wav",
+        content_type: str = "audio/wav",
+        language: Optional[str] = None,
+        deadline: Optional[float] = None,
+    ) -> Transcription:
+        data = {"response_format": "verbose_json", **({"language": language} if language else {})}
+        response = await self._request(
+            "POST", "/v1/audio/transcriptions", deadline=deadline, files={"file": (filename, audio, content_type)}, data=data
+        )
+        body = response.json()
+        return Transcription(
+            text=body["text"],
+            language=body.get("language"),
+            duration=body.get("duration"),
+            segments=body.get("segments") or [],
+            alternatives=body.get("alternatives") or [],
+        )
+
+    async def synthesize(
+        self, text: str, *, language: Optional[str] = None, audio_format: str = "mp3", deadline: Optional[float] = None
+    ) -> bytes:
+        """Speech for text; audio_format is mp3, opus or wav."""
+        payload = {"input": text, "response_format": audio_format, **({"language": language} if language else {})}
+        response = await self._request("POST", "/v1/audio/speech", deadline=deadline, json=payload)
+        return response.content
+
+    def stream(
+        self,
+        *,
+        language: Optional[str] = None,
+        sample_rate: int = 16000,
+        turn_detection: Optional[str] = None,
+        session_id: Optional[str] = None,
+        wake_word: Optional[str] = None,
+    ) -> StreamSession:
+        """A streaming transcription session on /v1/transcribe/stream; use it with async with."""
+        query = {
+            "sample_rate": sample_rate,
+            "language": language,
+            "turn_detection": turn_detection,
+            "session_id": session_id,
+            "wake_word": wake_word,
+        }
+        base = "ws" + self.base_url[len("http"):] if self.base_url.startswith("http") else self.base_url
+        url = f"{base}/v1/transcribe/stream?{urlencode({k: v for k, v in query.items() if v is not None})}"
+        return StreamSession(url, self._headers())
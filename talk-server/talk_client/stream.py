@@ -0,0 +1,92 @@
+"""StreamSession: one /v1/transcribe/stream WebSocket (see routers/stream.py for the protocol)."""
+import json
+from typing import Any, Awaitable, Callable, Dict, Optional, Union
+
+Message = Union[str, bytes]
+
+
+class _WebSocket:
+    """The websockets connection, with recv() returning None once the server closes."""
+
+    def __init__(self, connection: Any):
+        self._connection = connection
+
+    async def send(self, message: Message) -> None:
+        await self._connection.send(message)
+
+    async def recv(self) -> Optional[Message]:
+        from websockets.exceptions import ConnectionClosed
+
+        try:
+            return await self._connection.recv()
+        except ConnectionClosed:
+            return None
+
+    async def close(self) -> None:
+        await self._connection.close()
+
+
+async def _connect(url: str, headers: Dict[str, str]) -> _WebSocket:
+    import websockets
+
+    return _WebSocket(await websockets.connect(url, additional_headers=headers))
+
+
+class StreamSession:
+    """Send PCM with send_audio(), end it with end(), and iterate for the server's events.
+
+    Entering the session connects and waits for the ready event (kept as .ready); a stream
+    the server rejects raises TalkError. connect replaces the WebSocket connection, for tests.
+    """
+
+    def __init__(
+        self, url: str, headers: Dict[str, str], connect: Optional[Callable[[str, Dict[str, str]], Awaitable[Any]]] = None
+    ):
+        self.url = url
+        self.headers = headers
+        self.ready: Optional[Dict[str, Any]] = None
+        self._connect = connect or _connect
+        self._ws: Any = None
+
+    async def __aenter__(self) -> "StreamSession":
+        from talk_client.client import TalkError
+
+        self._ws = await self._connect(self.url, self.headers)
+        event = await self.receive()
+        if event is None or event.get("type") != "ready":
+            await self._ws.close()
+            message = event.get("message") if event else "the server closed the stream"
+            raise TalkError(400 if event else None, message or "stream rejected", error_code=(event or {}).get("error_code"))
+        self.ready = event
+        return self
+
+    async def __aexit__(self, *exc_info) -> None:
+        await self._ws.close()
+
+    async def send_audio(self, pcm: bytes) -> None:
+        """16-bit little-endian mono PCM at the session's sample rate, in chunks of any size."""
+        await self._ws.send(pcm)
+
+    async def flush(self) -> None:
+        """End the current utterance now, e.g. on push-to-talk release."""
+        await self._ws.send(json.dumps({"type": "flush"}))
+
+    async def end(self) -> None:
+        """Transcribe what is left; the server sends the remaining events and closes."""
+        await self._ws.send(json.dumps({"type": "end"}))
+
+    async def receive(self) -> Optional[Dict[str, Any]]:
+        """The next event, or None once the stream is closed."""
+        message = await self._ws.recv()
+        if message is None:
+            return None
+        return json.loads(message)
+
+    def __aiter__(self) -> "StreamSession":
+        return self
+
+    async def __anext__(self) -> Dict[str, Any]:
+        event = await self.receive()
+        if event is None:
+            raise StopAsyncIteration
+        return event
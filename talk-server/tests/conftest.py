@@ -1,11 +1,37 @@
 """Pytest fixtures for talk-server tests."""
+import os
+
 import pytest
 from fastapi.testclient import TestClient
 
 import main
 
 
+def pytest_addoption(parser):
+    parser.addoption("--e2e", action="store_true", help="also run the end-to-end tests against live backends")
+
+
+def pytest_collection_modifyitems(config, items):
+    """e2e tests (tests/test_e2e.py) only run with --e2e or DWANI_E2E=1."""
+    if config.getoption("--e2e") or os.getenv("DWANI_E2E") == "1":
+        return
+    skip = pytest.mark.skip(reason="end-to-end test against live backends; run with --e2e")
+    for item in items:
+        if "e2e" in item.keywords:
+            item.add_marker(skip)
+
+
 @pytest.fixture
 def client():
     """FastAPI test client."""
     return TestClient(main.app)
+
+
+@pytest.fixture(autouse=True)
+def _reset_rate_limits():
+    """All tests share the "testclient" address; start each one with full buckets."""
+    for limiter in (main.ip_limiter, main.key_limiter):
+        if limiter is not None:
+            limiter.reset()
+    main.limiter.reset()
+    yield
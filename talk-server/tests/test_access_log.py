@@ -0,0 +1,40 @@
+import logging
+
+from routers import chat as chat_router
+from services import pipeline
+from services.latency import observe_stage, server_timing, track_request
+from services.tts import TTSStream
+
+
+def test_stage_times_add_up_per_request():
+    stages = track_request()
+    with observe_stage("llm"):
+        pass
+    with observe_stage("llm"):
+        pass
+    assert list(stages) == ["llm"]
+    assert server_timing({"asr": 812.34, "llm": 250.0}, 1100.0) == "asr;dur=812.3, llm;dur=250.0, total;dur=1100.0"
+
+
+def test_server_timing_and_access_log(client, monkeypatch, caplog):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "Sure."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        with observe_stage("tts"):
+            return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    with caplog.at_level(logging.INFO):
+        res = client.post("/v1/speech_to_speech", data={"text": "hello"}, headers={"X-Request-ID": "timing-1"})
+        client.get("/health")
+    assert res.status_code == 200
+    timing = res.headers["Server-Timing"]
+    assert "llm;dur=" in timing and "tts;dur=" in timing and timing.split(", ")[-1].startswith("total;dur=")
+
+    access = [r for r in caplog.records if r.name.endswith(".access")]
+    assert len(access) == 1  # /health is excluded
+    assert access[0].status_code == 200 and access[0].path == "/v1/speech_to_speech"
+    assert access[0].request_id == "timing-1" and set(access[0].stage_ms) >= {"llm", "tts"}
@@ -0,0 +1,80 @@
+"""Tests for the admin backend, cache-flush and breaker-reset endpoints."""
+import pytest
+
+from routers import admin as admin_router
+from services.balancer import pool, reset_pools
+from services.idempotency import idempotency_cache
+from services.llm_health import llm_health, reset_llm_health
+from services.tts_cache import TTSCache
+
+ADMIN = {"X-Admin-Token": "admin-token"}
+
+
+@pytest.fixture(autouse=True)
+def _admin(monkeypatch):
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+    reset_pools()
+    reset_llm_health()
+    yield
+    reset_pools()
+    reset_llm_health()
+
+
+def test_endpoints_need_the_admin_token(client):
+    assert client.post("/admin/caches/flush").status_code == 401
+    assert client.post("/admin/breakers/reset", headers={"X-Admin-Token": "wrong"}).status_code == 401
+
+
+def test_flush_empties_the_chosen_caches(client, monkeypatch, tmp_path):
+    cache = TTSCache(directory=str(tmp_path))
+    cache.put("a" * 64, "audio/mpeg", b"mp3")
+    cache.put("b" * 64, "audio/mpeg", b"mp3")
+    monkeypatch.setattr(admin_router, "tts_cache", cache)
+    idempotency_cache.clear()
+
+    res = client.post("/admin/caches/flush", params={"cache": "tts"}, headers=ADMIN)
+    assert res.status_code == 200
+    assert res.json() == {"flushed": {"tts": 2, "tts_disk": 2}}
+    assert len(cache) == 0 and cache.get("a" * 64) is None
+
+    res = client.post("/admin/caches/flush", headers=ADMIN)
+    assert set(res.json()["flushed"]) == {"tts", "tts_disk", "idempotency", "replay", "fingerprint"}
+    assert client.post("/admin/caches/flush", params={"cache": "prompts"}, headers=ADMIN).status_code == 400
+
+
+def test_reset_puts_ejected_backends_back_per_stage(client):
+    llm, tts = pool("llm", "http://llm-a,http://llm-b"), pool("tts", "http://tts")
+    for backends in (llm, tts):
+        backends.failure_threshold = 1
+        backends.record_failure(backends.instances[0])
+    llm_health("http://llm-a").observe(600)
+
+    res = client.post("/admin/breakers/reset", params={"stage": "tts"}, headers=ADMIN)
+    assert res.json() == {"reset": {"tts": 1}}
+    assert tts.candidates()[0].failures == 0
+    assert llm.instances[0].failures == 1 and llm_health("http://llm-a").degraded()
+
+    res = client.post("/admin/breakers/reset", params={"stage": ["llm"]}, headers=ADMIN)
+    assert res.json() == {"reset": {"llm": 1}}
+    assert not llm_health("http://llm-a").degraded()
+    assert client.post("/admin/breakers/reset", params={"stage": "gpu"}, headers=ADMIN).status_code == 400
+
+
+def test_backends_are_listed_disabled_and_weighted(client):
+    llm = pool("llm", "http://llm-a,http://llm-b")
+    listed = client.get("/admin/backends", headers=ADMIN).json()
+    assert [(p["stage"], [i["url"] for i in p["instances"]]) for p in listed["pools"]] == [("llm", ["http://llm-a", "http://llm-b"])]
+
+    res = client.post("/admin/backends/disable", params={"stage": "llm", "url": "http://llm-a/", "seconds": 60}, headers=ADMIN)
+    assert res.status_code == 200 and res.json()["disabled"] and 0 < res.json()["disabled_for_s"] <= 60
+    assert [i.url for i in llm.candidates()] == ["http://llm-b"]
+    res = client.delete("/admin/backends/disable", params={"stage": "llm", "url": "http://llm-a"}, headers=ADMIN)
+    assert not res.json()["disabled"] and len(llm.candidates()) == 2
+
+    res = client.put("/admin/backends/weight", params={"stage": "llm", "url": "http://llm-b", "weight": 3}, headers=ADMIN)
+    assert res.json()["weight"] == 3
+    firsts = [llm.candidates()[0].url for _ in range(8)]
+    assert firsts.count("http://llm-b") == 6
+
+    assert client.put("/admin/backends/weight", params={"stage": "llm", "url": "http://llm-c", "weight": 1}, headers=ADMIN).status_code == 404
+    assert client.post("/admin/backends/disable", params={"stage": "gpu", "url": "http://llm-a"}, headers=ADMIN).status_code == 400
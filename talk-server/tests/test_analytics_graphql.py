@@ -0,0 +1,66 @@
+import uuid
+
+from fastapi.testclient import TestClient
+
+import main
+from auth_store import init_auth_db
+from conversation_store import record_turn
+
+
+client = TestClient(main.app)
+
+
+def _query(query, variables=None):
+    res = client.post("/v1/graphql", json={"query": query, "variables": variables or {}})
+    assert res.status_code == 200
+    body = res.json()
+    assert "errors" not in body, body
+    return body["data"]
+
+
+def test_conversation_and_usage_queries():
+    init_auth_db()
+    key = uuid.uuid4().hex[:24]
+    record_turn(key, "llm", None, "kannada", "audio", "namaskara", "hegiddiri", 420)
+    record_turn(key, "llm", None, "kannada", "text", "chennagiddini", "olleyadu", 380)
+
+    data = _query(
+        """query($key: String!) {
+            conversation(sessionKey: $key) { turnCount turns { inputType transcript reply latencyMs } }
+        }""",
+        {"key": key},
+    )
+    conversation = data["conversation"]
+    assert conversation["turnCount"] == 2
+    assert [t["transcript"] for t in conversation["turns"]] == ["namaskara", "chennagiddini"]
+
+    usage = _query("{ usage { turns conversations byLanguage { key turns } } }")["usage"]
+    assert usage["turns"] >= 2
+    assert any(c["key"] == "kannada" for c in usage["byLanguage"])
+
+
+def test_unknown_conversation_is_null():
+    init_auth_db()
+    data = _query('{ conversation(sessionKey: "does-not-exist") { turnCount } }')
+    assert data["conversation"] is None
+
+
+def test_other_tenants_turns_are_not_visible():
+    init_auth_db()
+    key = uuid.uuid4().hex[:24]
+    record_turn(key, "llm", None, "kannada", "text", key, "hegiddiri", 420, tenant="acme")
+    data = _query("""query($key: String!) { conversation(sessionKey: $key) { turnCount } }""", {"key": key})
+    assert data["conversation"] is None
+    assert key not in [turn["transcript"] for turn in _query("{ turns(limit: 100) { transcript } }")["turns"]]
+
+
+def test_graphql_is_read_only():
+    res = client.post("/v1/graphql", json={"query": "mutation { deleteEverything }"})
+    body = res.json()
+    assert body.get("errors")
+
+
+def test_graphql_requires_api_key_when_configured(monkeypatch):
+    monkeypatch.setenv("DWANI_API_KEY", "secret")
+    res = client.post("/v1/graphql", json={"query": "{ usage { turns } }"})
+    assert res.status_code == 401
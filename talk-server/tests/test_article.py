@@ -0,0 +1,101 @@
+import asyncio
+
+import httpx
+import pytest
+from fastapi import HTTPException
+
+from routers import read as read_router
+from services import article as article_svc
+from services import egress
+from services import read_aloud as read_svc
+from services.read_aloud import Chapter
+from services.tts import TTSStream
+
+_PAGE = """<html><head><title>Big News - Example Times</title></head><body>
+<header><nav><ul><li><a href="/">Home</a></li><li><a href="/world">World news and much more here</a></li></ul></nav></header>
+<div class="sidebar"><p>Subscribe to our newsletter for more great content, every single week.</p></div>
+<article class="story"><h1>Big News</h1>
+<p>The first paragraph of the story is here, with commas, names, and details.</p>
+<h2>Background</h2><p>Some background that explains what happened before, in some detail.
+<p>An unclosed paragraph that still belongs to the article body.
+<p><a href="/more">Read more stories like this one on our website today</a></p></article>
+<div id="comments"><p>Great article, thanks for writing this, I really enjoyed it a lot.</p></div>
+<footer><p>Copyright Example Times, all rights reserved by the company.</p></footer></body></html>"""
+
+
+def test_article_extraction_drops_boilerplate():
+    article = article_svc.extract_article(_PAGE)
+    assert article.title == "Big News"
+    chapters = article_svc.readable_chapters(article)
+    assert [c.title for c in chapters] == ["Big News", "Background"]
+    assert chapters[1].paragraphs == [
+        "Some background that explains what happened before, in some detail.",
+        "An unclosed paragraph that still belongs to the article body.",
+    ]
+    text = " ".join(p for c in chapters for p in c.paragraphs)
+    assert "newsletter" not in text and "Great article" not in text and "Read more" not in text
+
+
+@pytest.mark.parametrize("url", ["ftp://example.com/a", "http://127.0.0.1/admin", "http://[::1]/"])
+def test_non_public_urls_are_refused(url):
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(article_svc.fetch_article(url))
+    assert exc.value.status_code == 400
+
+
+def test_pages_and_redirects_are_fetched_from_the_checked_address(monkeypatch):
+    answers = {"news.example.com": "93.184.216.34", "rebind.example.com": "169.254.169.254"}
+    connected = []
+
+    def handler(request):
+        connected.append((request.url.host, request.headers["host"]))
+        if request.url.path == "/moved":
+            return httpx.Response(301, headers={"location": "http://rebind.example.com/latest/meta-data"})
+        return httpx.Response(200, headers={"content-type": "text/html"}, content=_PAGE.encode())
+
+    monkeypatch.setattr(egress.socket, "getaddrinfo", lambda host, port: [(None, None, None, "", (answers[host], 80))])
+    monkeypatch.setattr(
+        article_svc, "PinnedTransport", lambda allow_private: egress.PinnedTransport(transport=httpx.MockTransport(handler))
+    )
+    assert asyncio.run(article_svc.fetch_article("https://news.example.com/story")).title == "Big News"
+    assert connected == [("93.184.216.34", "news.example.com")]
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(article_svc.fetch_article("https://news.example.com/moved"))
+    assert exc.value.status_code == 400 and len(connected) == 2
+
+
+def test_translation_keeps_chapters_aligned(monkeypatch):
+    async def fake_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        assert "Hindi" in system_prompt or language == "hindi"
+        return "\n\n".join(f"[{p}]" for p in user_text.split("\n\n"))
+
+    monkeypatch.setattr(read_svc, "call_llm", fake_llm)
+    chapters = [Chapter("Intro", ["One.", "Two."]), Chapter(None, ["Three."])]
+    out = asyncio.run(read_svc.translate_chapters(chapters, "hindi"))
+    assert [(c.title, c.paragraphs) for c in out] == [("[Intro]", ["[One.]", "[Two.]"]), (None, ["[Three.]"])]
+
+
+def test_read_url_reads_the_article(client, monkeypatch, tmp_path):
+    async def fake_fetch(url):
+        assert url == "https://news.example.com/story"
+        return article_svc.extract_article(_PAGE, url + "?amp=0")
+
+    spoken = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", b"\xff\xfb\x90\x00" + b"\x00" * 413)
+
+    monkeypatch.setattr(read_router, "fetch_article", fake_fetch)
+    monkeypatch.setattr(read_svc, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(read_svc.read_artifacts, "directory", tmp_path)
+    res = client.post("/v1/read", data={"url": "https://news.example.com/story"})
+    assert res.status_code == 200
+    body = res.json()
+    assert body["title"] == "Big News" and body["source_url"] == "https://news.example.com/story?amp=0"
+    assert spoken[0] == "Big News" and "Background" in spoken
+
+
+def test_translate_requires_language(client):
+    res = client.post("/v1/read?translate=true", data={"text": "Hello there."})
+    assert res.status_code == 400
@@ -0,0 +1,79 @@
+"""Tests for asking the user to repeat when the transcript is too uncertain."""
+import asyncio
+import io
+
+from fastapi import UploadFile
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import pipeline
+from services import transcribe as transcribe_svc
+from services.messages import message
+from services.tts import TTSStream
+
+
+def _transcribe_with(monkeypatch, body):
+    class FakeResponse:
+        status_code = 200
+
+        def json(self):
+            return body
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            _ = [chunk async for chunk in content]
+            return FakeResponse()
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+    return asyncio.run(transcribe_svc.transcribe_audio(upload))
+
+
+def test_transcript_confidence_is_read_from_the_body_or_the_choice(monkeypatch):
+    choice = {"message": {"content": "hello"}}
+    assert _transcribe_with(monkeypatch, {"choices": [choice], "confidence": 0.42}).confidence == 0.42
+    assert _transcribe_with(monkeypatch, {"choices": [{**choice, "confidence": "0.9"}]}).confidence == 0.9
+    assert _transcribe_with(monkeypatch, {"choices": [choice]}).confidence is None
+
+
+def _fake_turn(monkeypatch, confidence, spoken):
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="ನಮಸ್ಕಾರ", confidence=confidence)
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "LLM reply"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "ASR_CONFIDENCE_MIN", 0.6)
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+
+def test_unclear_audio_is_answered_with_a_request_to_repeat(client, monkeypatch):
+    spoken = []
+    _fake_turn(monkeypatch, 0.3, spoken)
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada", "format": "json"}, files=files)
+    assert res.status_code == 200
+    data = res.json()
+    assert data["llm_response"] == message("clarify", "kannada")
+    assert (data["low_confidence"], data["asr_confidence"]) == (True, 0.3)
+
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada"}, files=files)
+    assert res.headers["X-ASR-Low-Confidence"] == "true"
+    assert spoken == [message("clarify", "kannada")] * 2
+
+
+def test_confident_audio_reaches_the_llm(client, monkeypatch):
+    spoken = []
+    _fake_turn(monkeypatch, 0.8, spoken)
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada"}, files=files)
+    assert res.status_code == 200
+    assert "X-ASR-Low-Confidence" not in res.headers
+    assert spoken == ["LLM reply"]
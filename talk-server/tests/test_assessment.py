@@ -0,0 +1,67 @@
+import json
+import uuid
+
+import pytest
+
+from services import assessment as assessment_svc
+from services.assessment import parse_assessment
+from services.session import MemorySessionStore, append_to_session, set_session_store
+
+_FEEDBACK = {
+    "turns": [
+        {"index": 0, "corrected": "Nanu shalege hoguttene.", "grammar": ["Verb ending should match the subject."],
+         "vocabulary": [{"instead_of": "shale", "suggestion": "shalege", "explanation": "Dative for a destination."}]},
+        {"index": 1, "corrected": "Ondu kaapi beku.", "grammar": []},
+    ],
+    "grammar_score": 72.4,
+    "vocabulary_score": "180",
+    "summary": "Good effort; watch verb endings.",
+}
+
+
+@pytest.fixture(autouse=True)
+def _memory_sessions():
+    set_session_store(MemorySessionStore())
+    yield
+    set_session_store(None)
+
+
+def test_llm_feedback_is_parsed_defensively():
+    raw = "Here you go:\n```json\n" + json.dumps(_FEEDBACK) + "\n```"
+    turns = ["nanu shale hogutene", "Ondu kaapi beku."]
+    result = parse_assessment(raw, "s1", turns, "english")
+    assert result.grammar_score == 72 and result.vocabulary_score == 100
+    assert result.turns[0].corrected == "Nanu shalege hoguttene." and result.turns[0].vocabulary[0].suggestion == "shalege"
+    # A "correction" identical to what was said is no correction.
+    assert result.turns[1].corrected is None and result.turns[1].text == "Ondu kaapi beku."
+
+
+def test_assessment_endpoint_reviews_learner_turns_and_caches(client, monkeypatch):
+    session_id = f"learner-{uuid.uuid4().hex}"
+    append_to_session(session_id, "nanu shale hogutene", "Oh, which school?")
+    append_to_session(session_id, "Ondu kaapi beku.", "Sure.")
+    prompts = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts.append((user_text, system_prompt))
+        return json.dumps(_FEEDBACK)
+
+    monkeypatch.setattr(assessment_svc, "call_llm", fake_call_llm)
+    res = client.get(f"/v1/sessions/{session_id}/assessment", params={"feedback_language": "hindi", "language": "kannada"})
+    assert res.status_code == 200
+    body = res.json()
+    assert body["feedback_language"] == "hi-IN" and not body["cached"]
+    assert [t["text"] for t in body["turns"]] == ["nanu shale hogutene", "Ondu kaapi beku."]
+    transcript, system_prompt = prompts[0]
+    assert "Learner [0]: nanu shale hogutene" in transcript and "Tutor: Oh, which school?" in transcript
+    assert "Hindi" in system_prompt and "practising Kannada" in system_prompt
+
+    again = client.get(f"/v1/sessions/{session_id}/assessment", params={"feedback_language": "hindi", "language": "kannada"})
+    assert again.json()["cached"] and again.json()["turns"] == body["turns"] and len(prompts) == 1
+    append_to_session(session_id, "Dhanyavada", "You're welcome.")
+    assert not client.get(f"/v1/sessions/{session_id}/assessment", params={"feedback_language": "hindi"}).json()["cached"]
+
+
+def test_assessment_needs_learner_turns(client):
+    assert client.get(f"/v1/sessions/empty-{uuid.uuid4().hex}/assessment").status_code == 404
+    assert client.get("/v1/sessions/x/assessment", params={"feedback_language": "klingon"}).status_code == 400
@@ -0,0 +1,180 @@
+import asyncio
+import io
+
+import pytest
+from fastapi import HTTPException
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import audio_format, pipeline
+from services import tts as tts_svc
+from services.telephony import wav_bytes
+from services.tts import TTSStream
+from services.tts_cache import TTSCache
+
+_WAV_HEAD = b"RIFF\x24\x00\x00\x00WAVEfmt "
+
+
+def test_format_is_detected_from_bytes_before_content_type():
+    assert audio_format.detect_format("audio/mpeg", _WAV_HEAD) == "wav"
+    assert audio_format.detect_format("application/octet-stream", b"OggS\x00\x02") == "ogg"
+    assert audio_format.detect_format(None, b"ID3\x04") == "mp3"
+    assert audio_format.detect_format("audio/x-wav", b"") == "wav"
+    assert audio_format.detect_format("application/octet-stream", b"????") is None
+
+
+def test_input_formats_are_sniffed_from_their_magic_bytes():
+    assert audio_format.sniff_input_format(_WAV_HEAD) == "wav"
+    assert audio_format.sniff_input_format(b"\x00\x00\x00\x20ftypM4A ") == "mp4"
+    assert audio_format.sniff_input_format(b"\xff\xf1\x50\x80") == "aac"
+    assert audio_format.sniff_input_format(b"\xff\xfb\x90\x64") == "mp3"
+    assert audio_format.sniff_input_format(b"\xff\xd8\xff\xe0") is None
+    assert audio_format.not_audio_file(b"\xff\xd8\xff\xe0") == "an image"
+    assert audio_format.not_audio_file(b"MZ" + bytes(70)) is None  # 16-bit PCM may start with "MZ"
+
+
+def test_sample_rate_is_read_from_the_audio():
+    assert audio_format.sample_rate(wav_bytes(b"\x00\x00" * 10, 22050)) == 22050
+    assert audio_format.sample_rate(b"ID3\x04\x00\x00\x00\x00\x00\x00" + b"\xff\xf3\x64\xc4" + b"\x00" * 100) == 24000
+    assert audio_format.sample_rate(b"OggS\x00\x02" + b"\x00" * 22 + b"OpusHead") == 48000
+    assert audio_format.sample_rate(b"????") is None
+
+
+def test_requested_format_is_validated():
+    assert audio_format.normalize_format("OPUS") == "ogg"
+    assert audio_format.normalize_format(None) is None
+    with pytest.raises(HTTPException) as exc:
+        audio_format.normalize_format("flac")
+    assert exc.value.status_code == 400
+
+
+def test_stream_reports_actual_media_type():
+    assert TTSStream.cached("audio/mpeg", _WAV_HEAD).media_type == "audio/wav"
+    assert TTSStream.cached("audio/mpeg", b"\xff\xfb\x90\x00").media_type == "audio/mpeg"
+
+
+def test_requested_format_is_forwarded_to_tts(monkeypatch):
+    sent = {}
+
+    class FakeResponse:
+        status_code = 200
+        headers = {"Content-Type": "audio/wav"}
+
+        def raise_for_status(self):
+            pass
+
+        async def aiter_bytes(self):
+            yield _WAV_HEAD
+
+        async def aclose(self):
+            pass
+
+    class FakeClient:
+        def build_request(self, method, url, json=None, **kwargs):
+            sent.update(json)
+
+        async def send(self, request, stream=False):
+            return FakeResponse()
+
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: FakeClient())
+    stream = asyncio.run(tts_svc.stream_tts("hello", audio_format="wav"))
+    assert sent == {"text": "hello", "response_format": "wav"}
+    assert stream.audio_format == "wav"
+
+
+def test_content_type_overrides_prefer_the_backend_and_exact_type(monkeypatch):
+    overrides = audio_format.parse_content_type_overrides(
+        "*=audio/ogg, application/octet-stream=audio/mpeg, http://tts-b:9000/ *=audio/wav, bogus"
+    )
+    monkeypatch.setattr(audio_format, "_OVERRIDES", overrides)
+    assert audio_format.override_content_type("http://tts-a", "application/octet-stream") == "audio/mpeg"
+    assert audio_format.override_content_type("http://tts-a", "audio/x-raw") == "audio/ogg"
+    assert audio_format.override_content_type("http://tts-b:9000", "application/octet-stream") == "audio/wav"
+    monkeypatch.setattr(audio_format, "_OVERRIDES", [])
+    assert audio_format.override_content_type("http://tts-a", "audio/mpeg") == "audio/mpeg"
+
+
+def test_error_pages_are_not_audio():
+    assert audio_format.non_audio_reason("text/html; charset=utf-8", b"<!DOCTYPE html><html>")
+    assert audio_format.non_audio_reason("audio/mpeg", b'{"error": "model not loaded"}')
+    assert audio_format.non_audio_reason("application/octet-stream", b"\n  <html><body>502 Bad Gateway")
+    assert audio_format.non_audio_reason("text/plain", _WAV_HEAD) is None
+    assert audio_format.non_audio_reason("application/octet-stream", b"<\x00\x91\xfe\x03\x00") is None
+
+
+def test_html_body_from_tts_is_a_stage_error(monkeypatch):
+    class FakeResponse:
+        status_code = 200
+        headers = {"Content-Type": "application/octet-stream"}
+
+        def raise_for_status(self):
+            pass
+
+        async def aiter_bytes(self):
+            yield b"<html><head><title>Service Unavailable</title></head></html>"
+
+        async def aclose(self):
+            pass
+
+    class FakeClient:
+        def build_request(self, method, url, **kwargs):
+            return None
+
+        async def send(self, request, stream=False):
+            return FakeResponse()
+
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: FakeClient())
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(tts_svc.stream_tts("hello"))
+    assert exc.value.status_code == 502
+
+
+def _mock_turn(monkeypatch, tts_bytes):
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="hello")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None):
+        return "hi there"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", tts_bytes)
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+
+def _speak(client, fmt):
+    return client.post(
+        "/v1/speech_to_speech",
+        params={"format": fmt},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+
+
+def test_backend_audio_in_requested_format_is_relayed(client, monkeypatch):
+    _mock_turn(monkeypatch, _WAV_HEAD + b"data")
+    res = _speak(client, "wav")
+    assert res.status_code == 200
+    assert res.headers["content-type"] == "audio/wav"
+    assert res.content == _WAV_HEAD + b"data"
+
+
+def test_other_backend_format_is_transcoded(client, monkeypatch):
+    _mock_turn(monkeypatch, b"ID3mp3-bytes")
+
+    async def fake_transcode(audio, target):
+        assert (audio, target) == (b"ID3mp3-bytes", "ogg")
+        return b"OggS-opus"
+
+    monkeypatch.setattr(chat_router, "transcode", fake_transcode)
+    res = _speak(client, "opus")
+    assert res.status_code == 200
+    assert res.headers["content-type"] == "audio/ogg"
+    assert res.content == b"OggS-opus"
+
+
+def test_unknown_format_is_rejected(client):
+    assert _speak(client, "flac").status_code == 400
@@ -0,0 +1,81 @@
+"""Tests for ID3 tags on reply audio (services/audio_metadata.py)."""
+import asyncio
+from datetime import datetime, timezone
+
+from services import audio_metadata
+from services import tts as tts_svc
+from services.audio_format import id3_frame, mp3_duration, strip_id3, tag_mp3
+from services.tts_cache import TTSCache
+
+_FRAME = b"\xff\xfb\x90\x00" + b"\x00" * 413  # one 128 kbps MPEG-1 Layer III frame at 44.1 kHz
+
+
+def test_frames_join_the_leading_tag_and_replace_their_kind():
+    tagged = tag_mp3(_FRAME, [id3_frame("TIT2", "first"), id3_frame("TXXX", "a", "request_id")])
+    assert tagged.startswith(b"ID3\x04") and strip_id3(tagged) == _FRAME
+    again = tag_mp3(tagged, [id3_frame("TIT2", "second"), id3_frame("TXXX", "mark", "watermark")])
+    assert strip_id3(again) == _FRAME and again.count(b"ID3") == 1
+    assert b"second" in again and b"first" not in again
+    assert b"request_id\x00a" in again and b"watermark\x00mark" in again
+    assert mp3_duration(again) == mp3_duration(_FRAME)
+    v23 = b"ID3\x03\x00\x00\x00\x00\x00\x00" + _FRAME
+    assert strip_id3(tag_mp3(v23, [id3_frame("TIT2", "x")])) == _FRAME
+
+
+def test_frames_describe_the_clip(monkeypatch):
+    monkeypatch.setattr(audio_metadata, "AUDIO_METADATA", True)
+    monkeypatch.setattr(audio_metadata, "AUDIO_METADATA_EXCERPT_CHARS", 12)
+    frames = audio_metadata.metadata_frames(
+        "ನಮಸ್ಕಾರ, how are you today?", "kannada", "req-1", now=datetime(2026, 3, 1, 9, 30, tzinfo=timezone.utc)
+    )
+    assert frames == [
+        id3_frame("TDRC", "2026-03-01T09:30:00"),
+        id3_frame("TIT2", "ನಮಸ್ಕಾರ, how…"),
+        id3_frame("TLAN", "kan"),
+        id3_frame("TXXX", "kn-IN", "language"),
+        id3_frame("TXXX", "req-1", "request_id"),
+    ]
+    assert audio_metadata.excerpt("ನಮಸ್ಕಾರ, how are you", 10) == "ನಮಸ್ಕಾರ…"
+    monkeypatch.setattr(audio_metadata, "AUDIO_METADATA", False)
+    assert audio_metadata.metadata_frames("Hello", "english", "req-1") == []
+
+
+class _FakeResponse:
+    status_code = 200
+    headers = {"Content-Type": "application/octet-stream"}
+
+    def raise_for_status(self):
+        pass
+
+    async def aiter_bytes(self):
+        yield _FRAME * 8  # long enough not to be taken for a truncated reply
+
+    async def aclose(self):
+        pass
+
+
+class _FakeClient:
+    def build_request(self, method, url, **kwargs):
+        return (method, url, kwargs)
+
+    async def send(self, request, stream=False):
+        return _FakeResponse()
+
+
+def test_sent_mp3_is_tagged_but_cached_as_returned(monkeypatch):
+    cache = TTSCache(directory=None)
+    monkeypatch.setattr(tts_svc, "tts_cache", cache)
+    monkeypatch.setattr(tts_svc, "http_client", lambda: _FakeClient())
+    monkeypatch.setattr(audio_metadata, "AUDIO_METADATA", True)
+
+    async def speak(request_id):
+        stream = await tts_svc.stream_tts("Hello there", request_id=request_id, language="english")
+        return stream.media_type, await stream.read()
+
+    media_type, first = asyncio.run(speak("req-1"))
+    assert media_type == "audio/mpeg"
+    assert first.startswith(b"ID3\x04") and b"request_id\x00req-1" in first and b"Hello there" in first
+    assert strip_id3(first) == _FRAME * 8
+    assert [audio for _, audio in cache._entries.values()] == [_FRAME * 8]
+    _, second = asyncio.run(speak("req-2"))
+    assert b"req-2" in second and b"req-1" not in second
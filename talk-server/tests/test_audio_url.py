@@ -0,0 +1,128 @@
+"""Tests for fetching speech-to-speech input audio from audio_url."""
+import asyncio
+
+import httpx
+import pytest
+from fastapi import HTTPException
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import audio_url as audio_url_svc
+from services import egress, pipeline
+from services.egress import check_host
+from services.tts import TTSStream
+
+_WAV = b"RIFF\x24\x00\x00\x00WAVEfmt " + b"\x00" * 32
+
+
+@pytest.mark.parametrize("url, detail", [
+    ("http://127.0.0.1:8000/a.wav", "audio_url must point to a public host"),
+    ("http://169.254.169.254/latest/meta-data", "audio_url must point to a public host"),
+    ("file:///etc/passwd", "audio_url must be an http(s) URL"),
+])
+def test_private_and_non_http_urls_are_refused(url, detail):
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(check_host(url, name="audio_url"))
+    assert exc.value.status_code == 400
+    assert exc.value.detail == detail
+
+
+def _serve(monkeypatch, handler, answers=None):
+    """Serve requests with handler; lookups of any host answer the next of answers (a public address by default)."""
+    checked = []
+    answers = iter(answers or [])
+
+    def fake_getaddrinfo(host, port):
+        checked.append(host)
+        return [(None, None, None, "", (next(answers, "93.184.216.34"), 443))]
+
+    monkeypatch.setattr(egress.socket, "getaddrinfo", fake_getaddrinfo)
+    monkeypatch.setattr(
+        audio_url_svc,
+        "PinnedTransport",
+        lambda allow_private, name: egress.PinnedTransport(allow_private, name, httpx.MockTransport(handler)),
+    )
+    return checked
+
+
+def test_redirects_are_checked_and_audio_is_returned(monkeypatch):
+    def handler(request):
+        if request.url.path == "/signed":
+            return httpx.Response(302, headers={"location": "https://bucket.example.com/clip.wav?sig=secret"})
+        return httpx.Response(200, headers={"content-type": "binary/octet-stream"}, content=_WAV)
+
+    checked = _serve(monkeypatch, handler)
+    upload = asyncio.run(audio_url_svc.fetch_audio("https://api.example.com/signed"))
+    assert checked == ["api.example.com", "bucket.example.com"]
+    assert upload.filename == "clip.wav"
+    assert upload.content_type == "audio/wav"
+    assert audio_url_svc.redacted("https://bucket.example.com/clip.wav?sig=secret") == "https://bucket.example.com/clip.wav"
+
+
+def test_the_checked_address_is_the_one_connected_to(monkeypatch):
+    connected = []
+
+    def handler(request):
+        connected.append((request.url.host, request.headers["host"], request.extensions["sni_hostname"]))
+        return httpx.Response(200, headers={"content-type": "audio/wav"}, content=_WAV)
+
+    # A rebinding host: public for the first lookup, loopback for any later one.
+    checked = _serve(monkeypatch, handler, answers=["93.184.216.34", "127.0.0.1"])
+    asyncio.run(audio_url_svc.fetch_audio("https://rebind.example.com/a.wav"))
+    assert checked == ["rebind.example.com"]
+    assert connected == [("93.184.216.34", "rebind.example.com", "rebind.example.com")]
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(audio_url_svc.fetch_audio("https://rebind.example.com/a.wav"))
+    assert exc.value.detail == "audio_url must point to a public host"
+    assert len(connected) == 1
+
+
+def test_non_audio_and_oversized_responses_are_rejected(monkeypatch):
+    bodies = {
+        "/page": ("text/html", b"<html>Access denied</html>"),
+        "/error": ("application/octet-stream", b'{"error": "SignatureDoesNotMatch"}'),
+        "/big": ("audio/wav", _WAV * 10),
+    }
+
+    def handler(request):
+        content_type, body = bodies[request.url.path]
+        return httpx.Response(200, headers={"content-type": content_type}, content=body)
+
+    _serve(monkeypatch, handler)
+    monkeypatch.setattr(audio_url_svc, "MAX_UPLOAD_BYTES", len(_WAV) * 2)
+    for path, status in (("/page", 400), ("/error", 400), ("/big", 413)):
+        with pytest.raises(HTTPException) as exc:
+            asyncio.run(audio_url_svc.fetch_audio(f"https://cdn.example.com{path}"))
+        assert exc.value.status_code == status
+
+
+def test_speech_to_speech_accepts_a_json_audio_url(client, monkeypatch):
+    fetched = []
+
+    async def fake_fetch_audio(url):
+        fetched.append(url)
+        return pipeline.audio_upload(_WAV)
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        assert file.file.read() == _WAV
+        return TranscriptionResponse(text="hello")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "hi there"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"ID3")
+
+    monkeypatch.setattr(chat_router, "fetch_audio", fake_fetch_audio)
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post(
+        "/v1/speech_to_speech", params={"format": "json"}, json={"audio_url": "https://bucket.example.com/a.wav?sig=x"}
+    )
+    assert res.status_code == 200
+    assert res.json()["transcription"] == "hello"
+    assert fetched == ["https://bucket.example.com/a.wav?sig=x"]
+
+    assert client.post("/v1/speech_to_speech", json={"url": "https://example.com/a.wav"}).status_code == 422
@@ -0,0 +1,134 @@
+import asyncio
+
+import httpx
+import pytest
+
+from services import balancer
+from services.balancer import BackendPool
+
+
+def _response(status: int) -> httpx.Response:
+    return httpx.Response(status, request=httpx.Request("GET", "http://backend"))
+
+
+def test_round_robin_spreads_requests():
+    pool = BackendPool("tts", ("http://a", "http://b", "http://c"), strategy="round_robin")
+    firsts = [pool.candidates()[0].url for _ in range(6)]
+    assert firsts == ["http://a", "http://b", "http://c"] * 2
+
+
+def test_least_latency_prefers_the_fastest_instance():
+    pool = BackendPool("llm", ("http://slow", "http://fast"), strategy="least_latency")
+    slow, fast = pool.instances
+    pool.record_success(slow, 2.0)
+    pool.record_success(fast, 0.2)
+    assert pool.candidates()[0] is fast
+
+
+def test_connection_failure_fails_over_and_ejects():
+    pool = BackendPool("asr", ("http://down", "http://up"), strategy="round_robin", failure_threshold=2)
+    calls = []
+
+    async def fn(url):
+        calls.append(url)
+        if url == "http://down":
+            raise httpx.ConnectError("refused")
+        return "ok"
+
+    for _ in range(2):
+        assert asyncio.run(pool.call(fn)) == "ok"
+    assert calls == ["http://down", "http://up", "http://up"]
+    down = pool.instances[0]
+    assert down.failures == 1
+    # Once ejected, the instance is tried last whatever the rotation says.
+    pool.record_failure(down)
+    assert [i.url for i in pool.candidates()] == ["http://up", "http://down"]
+
+
+def test_server_error_fails_over_but_last_response_is_returned():
+    pool = BackendPool("tts", ("http://a", "http://b"), strategy="round_robin")
+    discarded = []
+
+    async def always_500(url):
+        return _response(503)
+
+    async def discard(resp):
+        discarded.append(resp)
+
+    result = asyncio.run(pool.call(always_500, failed_result=balancer.server_error, discard=discard))
+    assert result.status_code == 503
+    assert len(discarded) == 1
+
+
+def test_other_errors_are_not_retried_elsewhere():
+    pool = BackendPool("llm", ("http://a", "http://b"))
+    calls = []
+
+    async def bad_request(url):
+        calls.append(url)
+        raise ValueError("bad payload")
+
+    with pytest.raises(ValueError):
+        asyncio.run(pool.call(bad_request))
+    assert len(calls) == 1
+
+
+def test_pools_are_shared_per_url_list():
+    balancer.reset_pools()
+    assert balancer.pool("tts", "http://a, http://b/") is balancer.pool("tts", "http://a,http://b")
+    assert [i.url for i in balancer.pool("tts", "http://a,http://b").instances] == ["http://a", "http://b"]
+
+
+def test_hedged_request_races_a_slow_instance():
+    pool = BackendPool("llm", ("http://slow", "http://fast"), strategy="round_robin")
+    cancelled = []
+
+    async def fn(url):
+        try:
+            await asyncio.sleep(1 if url == "http://slow" else 0)
+        except asyncio.CancelledError:
+            cancelled.append(url)
+            raise
+        return url
+
+    assert asyncio.run(pool.hedged(fn, 0.01)) == "http://fast"
+    assert cancelled == ["http://slow"]
+    assert all(i.in_flight == 0 for i in pool.instances)
+
+
+def test_hedged_request_answered_in_time_sends_no_hedge():
+    pool = BackendPool("llm", ("http://a",), strategy="round_robin")
+    calls = []
+
+    async def fn(url):
+        calls.append(url)
+        return "ok"
+
+    assert asyncio.run(pool.hedged(fn, 1)) == "ok"
+    assert calls == ["http://a"]
+
+
+def test_hedged_request_fails_over_at_once_and_fails_when_both_do():
+    pool = BackendPool("llm", ("http://down", "http://up"), strategy="round_robin")
+
+    async def fn(url):
+        if url == "http://down":
+            raise httpx.ConnectError("refused")
+        return url
+
+    assert asyncio.run(pool.hedged(fn, 60)) == "http://up"
+    assert pool.instances[0].failures == 1
+
+    async def down(url):
+        raise httpx.ConnectError("refused")
+
+    with pytest.raises(httpx.ConnectError):
+        asyncio.run(pool.hedged(down, 60))
+
+
+def test_weight_zero_instances_only_serve_when_the_others_fail():
+    pool = BackendPool("tts", ("http://main", "http://spare"), strategy="round_robin")
+    pool.instances[1].weight = 0
+    assert [[i.url for i in pool.candidates()] for _ in range(2)] == [["http://main", "http://spare"]] * 2
+    pool.instances[0].disabled_until = float("inf")
+    assert [i.url for i in pool.candidates()] == ["http://spare"]
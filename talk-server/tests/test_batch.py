@@ -0,0 +1,71 @@
+"""Tests for running many files through /v1/batch/speech_to_speech."""
+import io
+import zipfile
+
+from services import batch as batch_svc
+from services.errors import StageError
+from services.pipeline import TurnResult
+from services.tts import TTSStream
+
+
+def _fake_pipeline(monkeypatch):
+    async def fake_converse(file, mode="llm", agent_name=None, request_id=None, language=None, persona=None):
+        audio = await file.read()
+        if audio == b"broken":
+            raise StageError("asr", detail="ASR service returned HTTP 500")
+        return TurnResult(transcription=audio.decode(), reply=f"reply to {audio.decode()}")
+
+    async def fake_stream_tts(text, request_id=None, language=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(batch_svc, "converse", fake_converse)
+    monkeypatch.setattr(batch_svc, "stream_tts", fake_stream_tts)
+
+
+def _zip(entries):
+    buffer = io.BytesIO()
+    with zipfile.ZipFile(buffer, "w") as bundle:
+        for name, data in entries.items():
+            bundle.writestr(name, data)
+    return buffer.getvalue()
+
+
+def test_batch_reports_each_file_in_upload_order(client, monkeypatch):
+    _fake_pipeline(monkeypatch)
+    files = [
+        ("files", ("one.wav", io.BytesIO(b"one"), "audio/wav")),
+        ("files", ("bad.wav", io.BytesIO(b"broken"), "audio/wav")),
+        ("files", ("two.wav", io.BytesIO(b"two"), "audio/wav")),
+    ]
+    res = client.post("/v1/batch/speech_to_speech", params={"language": "kannada"}, files=files)
+    assert res.status_code == 200
+    manifest = res.json()
+    assert (manifest["files"], manifest["succeeded"], manifest["failed"]) == (3, 2, 1)
+    one, bad, two = manifest["results"]
+    assert (one["file"], one["status"], one["llm_response"], one["audio_bytes"]) == ("one.wav", "succeeded", "reply to one", 3)
+    assert "audio_base64" not in one
+    assert (bad["status"], bad["error"]["stage"], bad["error"]["code"]) == ("failed", "asr", "502")
+    assert two["transcription"] == "two"
+
+
+def test_zip_archives_are_unpacked(client, monkeypatch):
+    _fake_pipeline(monkeypatch)
+    archive = _zip({"corpus/a.wav": b"alpha", "corpus/.DS_Store": b"x", "__MACOSX/corpus/._a.wav": b"x", "corpus/b.wav": b"beta"})
+    res = client.post(
+        "/v1/batch/speech_to_speech",
+        params={"include_audio": "true"},
+        files=[("files", ("corpus.zip", io.BytesIO(archive), "application/zip"))],
+    )
+    results = res.json()["results"]
+    assert [r["file"] for r in results] == ["corpus/a.wav", "corpus/b.wav"]
+    assert results[0]["audio_base64"] == "bXAz"
+
+
+def test_batch_limits(client, monkeypatch):
+    _fake_pipeline(monkeypatch)
+    monkeypatch.setattr(batch_svc, "BATCH_MAX_FILES", 2)
+    archive = _zip({"a.wav": b"a", "b.wav": b"b", "c.wav": b"c"})
+    res = client.post("/v1/batch/speech_to_speech", files=[("files", ("c.zip", io.BytesIO(archive), "application/zip"))])
+    assert res.status_code == 400
+    res = client.post("/v1/batch/speech_to_speech", files=[("files", ("c.zip", io.BytesIO(b"not a zip"), "application/zip"))])
+    assert res.status_code == 400
@@ -0,0 +1,124 @@
+"""Tests for delivering reply audio through object storage with signed URLs."""
+import io
+import sys
+import time
+import types
+
+import pytest
+from fastapi.testclient import TestClient
+
+import main
+from routers import chat as chat_router
+from services import blob_store, pipeline
+from services import jobs as jobs_svc
+from services.blob_store import BlobStore, create_blob_store, set_blob_store
+from services.pipeline import TurnResult
+from services.tts import TTSStream
+
+_MP3 = b"ID3\x04\x00\x00\x00\x00\x00\x00mp3"
+
+
+class MemoryBlobStore(BlobStore):
+    name = "memory"
+
+    def __init__(self, fail=False):
+        self.objects = {}
+        self.metadata = {}
+        self.fail = fail
+
+    def put(self, key, data, content_type, metadata=None):
+        if self.fail:
+            raise OSError("bucket is gone")
+        self.objects[key] = (data, content_type)
+        self.metadata[key] = metadata
+
+    def signed_url(self, key, expires_in):
+        return f"https://blobs.example/{key}?expires={expires_in}&sig=abc"
+
+
+@pytest.fixture
+def store():
+    store = MemoryBlobStore()
+    set_blob_store(store)
+    yield store
+    set_blob_store(None)
+
+
+def _fake_turn(monkeypatch):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "namaskara"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", _MP3)
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+
+def test_format_url_stores_the_audio_and_returns_a_signed_url(client, monkeypatch, store):
+    _fake_turn(monkeypatch)
+
+    res = client.post("/v1/speech_to_speech?format=url", data={"text": "hello"}, headers={"X-Request-ID": "req-1"})
+    assert res.status_code == 200
+    body = res.json()
+    assert body["transcription"] == "hello" and body["llm_response"] == "namaskara"
+    assert "audio_base64" not in body and body["audio_content_type"] == "audio/mpeg"
+    [(key, (data, content_type))] = store.objects.items()
+    assert "req-1" not in key and key.endswith(".mp3") and data == _MP3 and content_type == "audio/mpeg"
+    assert store.metadata[key] == {"request-id": "req-1"}
+    assert body["audio_url"] == f"https://blobs.example/{key}?expires=3600&sig=abc"
+    assert body["audio_url_expires_at"] >= int(time.time()) + 3590
+
+
+def test_format_url_needs_a_store_and_reports_upload_failures(client, monkeypatch):
+    set_blob_store(None)
+    monkeypatch.setattr(blob_store, "BLOB_STORE_URI", "")
+    assert client.post("/v1/speech_to_speech?format=url", data={"text": "hello"}).status_code == 400
+
+    _fake_turn(monkeypatch)
+    set_blob_store(MemoryBlobStore(fail=True))
+    try:
+        res = client.post("/v1/speech_to_speech?format=url", data={"text": "hello"})
+    finally:
+        set_blob_store(None)
+    assert res.status_code == 502
+    assert res.json()["error"]["stage"] == "storage" and "bucket" not in res.text
+
+
+def test_jobs_can_deliver_a_signed_url(monkeypatch, store):
+    async def fake_converse(file, mode="llm", agent_name=None, session_id=None, request_id=None, language=None, persona=None):
+        return TurnResult(transcription="hello", reply="hi there")
+
+    async def fake_stream_tts(text, request_id=None, language=None):
+        return TTSStream.cached("audio/wav", b"RIFF\x24\x00\x00\x00WAVEfmt ")
+
+    monkeypatch.setattr(jobs_svc, "converse", fake_converse)
+    monkeypatch.setattr(jobs_svc, "stream_tts", fake_stream_tts)
+
+    with TestClient(main.app) as client:
+        res = client.post("/v1/jobs/speech_to_speech?format=url", files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")})
+        assert res.status_code == 202
+        body = {}
+        for _ in range(50):
+            body = client.get(f"/v1/jobs/{res.json()['job_id']}").json()
+            if body["status"] in {"succeeded", "failed"}:
+                break
+            time.sleep(0.05)
+    assert body["status"] == "succeeded"
+    assert "audio_base64" not in body["result"] and body["result"]["audio_url"].startswith("https://blobs.example/")
+    assert [key.rsplit(".", 1)[1] for key in store.objects] == ["wav"]
+
+
+def test_store_is_chosen_by_uri(monkeypatch):
+    calls = []
+    fake_boto3 = types.SimpleNamespace(client=lambda service, **kwargs: calls.append((service, kwargs)) or object())
+    monkeypatch.setitem(sys.modules, "boto3", fake_boto3)
+    monkeypatch.setattr(blob_store, "BLOB_ENDPOINT_URL", "http://minio:9000")
+
+    s3 = create_blob_store("s3://replies/talk/audio/")
+    assert (s3.bucket, s3.prefix) == ("replies", "talk/audio")
+    assert calls == [("s3", {"endpoint_url": "http://minio:9000", "region_name": None})]
+    assert isinstance(create_blob_store("test_blob_store:MemoryBlobStore"), MemoryBlobStore)
+    assert create_blob_store("") is None
+    with pytest.raises(ValueError):
+        create_blob_store("ftp://replies")
@@ -0,0 +1,83 @@
+"""Tests for per-tenant branding of the phrases the server speaks."""
+import asyncio
+import io
+import json
+
+import pytest
+
+from models import TranscriptionResponse
+from services import loops, messages, pipeline
+from services.branding import load_branding, tenant_for_host, use_tenant
+from services.messages import CATALOG, GREETING, message
+from services.mock import silent_mp3
+from services.tts import stream_tts
+
+_KNOWN = [*CATALOG, GREETING]
+
+
+@pytest.fixture
+def branded(tmp_path, monkeypatch):
+    (tmp_path / "acme").mkdir()
+    (tmp_path / "acme" / "pardon.mp3").write_bytes(silent_mp3(0.5))
+    (tmp_path / "branding.json").write_text(json.dumps({
+        "default": {"call_error": "Our service is busy, please call back."},
+        "tenants": {
+            "acme": {
+                "clarify": {"hindi": "फिर से बोलिए", "*": {"text": "Acme didn't catch that.", "audio": "acme/pardon.mp3"}},
+                "greeting": "Welcome to Acme.",
+            },
+        },
+    }))
+    branding = load_branding(str(tmp_path / "branding.json"), _KNOWN)
+    monkeypatch.setattr(messages, "BRANDING", branding)
+    return branding
+
+
+def test_tenants_are_named_by_the_host_or_its_first_label():
+    tenants = ["default", "acme", "voice.globex.com"]
+    assert tenant_for_host("acme.talk.example.com:8443", tenants) == "acme"
+    assert tenant_for_host("Voice.Globex.com", tenants) == "voice.globex.com"
+    assert tenant_for_host("default.example.com", tenants) is None
+    assert tenant_for_host("other.example.com", tenants) is None
+    assert tenant_for_host(None, tenants) is None
+
+
+def test_malformed_branding_is_rejected(tmp_path):
+    path = tmp_path / "branding.json"
+    for spec in ({"default": {"goodbye": "Bye"}}, {"default": {"clarify": {"english": 3}}}, {"brands": {}}):
+        path.write_text(json.dumps(spec))
+        with pytest.raises(ValueError):
+            load_branding(str(path), _KNOWN)
+
+
+def test_tenant_phrases_beat_the_defaults_the_environment_and_the_catalog(branded, monkeypatch):
+    async def say():
+        use_tenant("acme")
+        return message("clarify", "hindi"), message("clarify", "tamil"), message(GREETING), message("call_error", "tamil")
+
+    assert asyncio.run(say()) == ("फिर से बोलिए", "Acme didn't catch that.", "Welcome to Acme.", "Our service is busy, please call back.")
+    assert message("clarify", "tamil") == CATALOG["clarify"]["tamil"]
+    assert message(GREETING, configured="Hello!") == "Hello!"
+    monkeypatch.setattr(loops, "LOOP_FALLBACK_REPLY", "Say again?")
+    assert loops.fallback("", "repeat", "english") == "Say again?"
+
+
+def test_recorded_phrases_are_played_instead_of_synthesized(branded):
+    async def say():
+        use_tenant("acme")
+        tts_stream = await stream_tts(message("clarify", "english"), language="english")
+        return tts_stream.audio_format, await tts_stream.read()
+
+    assert asyncio.run(say()) == ("mp3", silent_mp3(0.5))
+
+
+def test_a_tenants_unclear_turn_plays_its_recording(client, branded, monkeypatch):
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="hmm", confidence=0.1)
+
+    monkeypatch.setattr(pipeline, "ASR_CONFIDENCE_MIN", 0.6)
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", headers={"Host": "acme.talk.example.com"}, files=files)
+    assert res.status_code == 200
+    assert res.content == silent_mp3(0.5)
@@ -0,0 +1,142 @@
+import asyncio
+
+import pytest
+
+from models import GenerationParams
+from routers import chat as chat_router
+from services import backends, budget, chat_svc, pipeline, retrieval
+from services.backends import PipelineConfig, use_config
+from services.budget import complete_sentences, fit_tts_budget, llm_generation, truncate_at_sentence
+from services.deadline import set_deadline
+from services.errors import StageError
+from services.tts import TTSStream
+
+
+@pytest.mark.parametrize("text, limit, expected", [
+    ("Sure. The train leaves at 7.30 from platform two.", 30, "Sure."),
+    ("ಸರಿ। ರೈಲು ಏಳು ಗಂಟೆಗೆ ಹೊರಡುತ್ತದೆ", 10, "ಸರಿ।"),
+    ("one two three four five six", 12, "one two…"),
+    ("short", 30, "short"),
+])
+def test_truncate_at_sentence(text, limit, expected):
+    assert truncate_at_sentence(text, limit) == expected
+
+
+def test_complete_sentences_drops_the_cut_off_tail():
+    assert complete_sentences("It is sunny. Tomorrow it will") == "It is sunny."
+    assert complete_sentences("no sentence end") == "no sentence end"
+
+
+def test_token_budget_caps_the_request(monkeypatch):
+    monkeypatch.setattr(backends, "TURN_MAX_LLM_TOKENS", 64)
+    generation, capped = llm_generation(GenerationParams(max_tokens=500, temperature=0.3))
+    assert capped and generation.max_tokens == 64 and generation.temperature == 0.3
+    assert llm_generation(GenerationParams(max_tokens=32)) == (GenerationParams(max_tokens=32), False)
+    with use_config(PipelineConfig(max_llm_tokens=0)):
+        assert llm_generation(None) == (None, False)
+
+
+def test_reply_cut_by_the_token_budget_ends_on_a_sentence(monkeypatch):
+    seen = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append(generation.max_tokens)
+        chat_svc._finish_reason.set("length")
+        return "It is sunny. Tomorrow it"
+
+    monkeypatch.setattr(backends, "TURN_MAX_LLM_TOKENS", 16)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    turn = asyncio.run(pipeline.converse(text="weather?"))
+    assert seen == [16]
+    assert turn.reply == "It is sunny."
+    assert turn.budget_hits == ("llm_tokens",)
+
+
+def test_over_budget_reply_is_shortened_then_truncated(monkeypatch):
+    monkeypatch.setattr(backends, "TURN_MAX_TTS_CHARS", 20)
+    shortened = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        shortened.append(system_prompt)
+        return "Train at 7.30."
+
+    monkeypatch.setattr(budget, "call_llm", fake_call_llm)
+    long_reply = "The next train to Mysuru leaves at 7.30 from platform two."
+    assert asyncio.run(fit_tts_budget(long_reply)) == ("Train at 7.30.", True)
+    assert "at most 20 characters" in shortened[0]
+
+    async def failing_call_llm(*args, **kwargs):
+        raise StageError("llm")
+
+    monkeypatch.setattr(budget, "call_llm", failing_call_llm)
+    assert asyncio.run(fit_tts_budget("Yes. The next train leaves soon.")) == ("Yes.", True)
+    assert asyncio.run(fit_tts_budget("Okay.")) == ("Okay.", False)
+
+
+def test_text_spoken_as_written_is_only_cut(monkeypatch):
+    monkeypatch.setattr(backends, "TURN_MAX_TTS_CHARS", 20)
+    assert budget.cut_to_tts_budget("Amount due 1,250. Pay by 5 March.") == ("Amount due 1,250.", True)
+    assert budget.cut_to_tts_budget("Amount due 1,250.") == ("Amount due 1,250.", False)
+
+
+def test_endpoint_reports_budget_hits(client, monkeypatch):
+    spoken = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "First sentence here. Second sentence that goes on and on."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(backends, "TURN_MAX_TTS_CHARS", 25)
+    monkeypatch.setattr(budget, "BUDGET_SHORTEN", False)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post("/v1/speech_to_speech", data={"text": "tell me"})
+    assert res.headers["X-Budget-Hit"] == "tts_chars"
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "tell me"})
+    assert res.json()["budget_hits"] == ["tts_chars"]
+    assert spoken == ["First sentence here."] * 2
+
+
+def test_latency_budget_lowers_max_tokens_when_the_deadline_is_near(monkeypatch):
+    monkeypatch.setattr(budget, "LATENCY_TTS_RESERVE_MS", 1000)
+    monkeypatch.setattr(budget, "LATENCY_LLM_TOKENS_PER_SECOND", 20)
+    set_deadline(None)
+    assert budget.latency_generation(GenerationParams(max_tokens=256)) == (GenerationParams(max_tokens=256), False)
+    set_deadline(30)
+    assert budget.latency_generation(None) == (None, False) and not budget.hurried()
+    set_deadline(4)
+    generation, lowered = budget.latency_generation(GenerationParams(max_tokens=256, temperature=0.2))
+    assert lowered and 32 <= generation.max_tokens <= 60 and generation.temperature == 0.2
+    set_deadline(0.5)
+    assert budget.latency_generation(None)[0].max_tokens == budget.LATENCY_MIN_TOKENS
+    monkeypatch.setattr(budget, "LATENCY_DEGRADE", False)
+    assert budget.latency_generation(None) == (None, False)
+    set_deadline(None)
+
+
+def test_hurried_turn_skips_optional_stages_and_reports_latency(monkeypatch):
+    seen = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append((generation.max_tokens, system_prompt))
+        chat_svc._finish_reason.set("length")
+        return "It is sunny. Tomorrow it"
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(budget, "call_llm", fake_call_llm)
+    monkeypatch.setattr(retrieval, "RETRIEVAL_URL", "http://retrieval.invalid/search")
+    monkeypatch.setattr(backends, "TURN_MAX_TTS_CHARS", 8)
+
+    async def run():
+        set_deadline(3)
+        return await pipeline.converse(text="weather?")
+
+    turn = asyncio.run(run())
+    # Retrieval was skipped (nothing was sent to its URL), and the reply was cut rather than shortened by the LLM.
+    assert len(seen) == 1 and seen[0][0] == budget.LATENCY_MIN_TOKENS
+    assert turn.reply == "It is…"
+    assert turn.budget_hits == ("latency", "tts_chars")
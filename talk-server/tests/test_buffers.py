@@ -0,0 +1,18 @@
+from services.buffers import BufferPool
+
+
+def test_buffer_pool_reuses_released_buffers():
+    pool = BufferPool("test_reuse", size=8, max_idle=1)
+    first = pool.get()
+    pool.put(first)
+    assert pool.get() is first
+
+
+def test_buffer_pool_drops_excess_and_foreign_buffers():
+    pool = BufferPool("test_bounds", size=8, max_idle=1)
+    a, b = pool.get(), pool.get()
+    pool.put(a)
+    pool.put(b)
+    pool.put(bytearray(4))
+    assert pool.get() is a
+    assert pool.get() is not b
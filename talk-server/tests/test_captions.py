@@ -0,0 +1,122 @@
+import json
+import struct
+
+import httpx
+
+from routers import chat as chat_router
+from services import captions, pipeline
+from services.captions import WordTiming, caption_cues, parse_word_timings, subtitles
+from services.tts import TTSStream
+
+_REPLY = "ನಮಸ್ಕಾರ. ನಾಳೆ ಬೆಳಿಗ್ಗೆ ಮಳೆ ಬರುತ್ತದೆ. Take an umbrella."
+
+
+def _wav(seconds, rate=8000):
+    pcm = b"\x00\x00" * int(rate * seconds)
+    return struct.pack(
+        "<4sI4s4sIHHIIHH4sI", b"RIFF", 36 + len(pcm), b"WAVE", b"fmt ", 16, 1, 1, rate, rate * 2, 2, 16, b"data", len(pcm)
+    ) + pcm
+
+
+def test_cues_split_the_reply_and_share_the_duration(monkeypatch):
+    monkeypatch.setattr(captions, "CAPTION_MAX_CHARS", 30)
+    cues = caption_cues(_REPLY, 6.0)
+    assert [c.text for c in cues] == ["ನಮಸ್ಕಾರ.", "ನಾಳೆ ಬೆಳಿಗ್ಗೆ ಮಳೆ ಬರುತ್ತದೆ.", "Take an umbrella."]
+    assert cues[0].start_seconds == 0 and cues[-1].end_seconds == 6.0
+    assert all(a.end_seconds == b.start_seconds for a, b in zip(cues, cues[1:]))
+    # Longer cues get more time; without a duration the speaking rate sets it.
+    assert cues[1].end_seconds - cues[1].start_seconds > cues[0].end_seconds - cues[0].start_seconds
+    assert caption_cues(_REPLY)[-1].end_seconds == round(len(_REPLY) / captions.CAPTION_CHARS_PER_SECOND, 3)
+    assert caption_cues("  ") == []
+
+
+def test_words_are_timed_within_their_cue():
+    cues = caption_cues("Take an umbrella.", 2.0)
+    words = cues[0].words
+    assert [w.word for w in words] == ["Take", "an", "umbrella."]
+    assert words[0].start_seconds == 0 and words[-1].end_seconds == 2.0
+    assert words[1].end_seconds - words[1].start_seconds < words[2].end_seconds - words[2].start_seconds
+
+
+def test_tts_marks_time_the_words_as_given():
+    marks = parse_word_timings('[{"word": "Take", "start": 0.1, "end": 0.4}, {"word": "an", "start": 0.4, "end": 0.5},'
+                               ' {"word": "umbrella.", "start": 0.55, "end": 1.2}]')
+    cue = caption_cues("Take an umbrella.", 9.0, marks)[0]
+    assert (cue.start_seconds, cue.end_seconds) == (0.1, 1.2) and cue.words[2] == WordTiming("umbrella.", 0.55, 1.2)
+    # Marks for other words than the reply's are not used.
+    assert caption_cues("Take it.", 2.0, marks)[0].end_seconds == 2.0
+    assert parse_word_timings("not json") is None and parse_word_timings(None) is None
+
+
+def _fake_turn(monkeypatch, audio, headers=None):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return _REPLY
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        if headers:
+            return TTSStream(httpx.Response(200, headers={"Content-Type": "audio/wav", **headers}), audio)
+        return TTSStream.cached("audio/wav", audio)
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+
+def test_json_reply_has_cues_timed_to_the_audio(client, monkeypatch):
+    _fake_turn(monkeypatch, _wav(4.0))
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "weather?"})
+    assert res.status_code == 200
+    cues = res.json()["captions"]
+    assert cues[-1]["end_seconds"] == 4.0 and " ".join(c["text"] for c in cues) == _REPLY
+    assert res.json()["word_timing"] == "estimated"
+    assert [w["word"] for c in cues for w in c["words"]] == _REPLY.split()
+
+
+def test_ndjson_stream_sends_cues_before_the_audio(client, monkeypatch):
+    audio = _wav(2.5)
+    _fake_turn(monkeypatch, audio)
+    res = client.post("/v1/speech_to_speech", params={"format": "ndjson"}, data={"text": "weather?"})
+    assert res.status_code == 200 and res.headers["content-type"].startswith("application/x-ndjson")
+    events = [json.loads(line) for line in res.text.splitlines()]
+    types = [e["type"] for e in events]
+    assert types[0] == "turn" and types[-1] == "done"
+    assert types.index("audio") > max(i for i, t in enumerate(types) if t == "caption")
+    assert events[0]["llm_response"] == _REPLY and events[0]["audio_content_type"] == "audio/wav"
+    assert events[-1]["duration_seconds"] == 2.5
+    assert events[-1]["captions"][-1]["end_seconds"] == 2.5
+
+
+def test_backend_word_marks_are_passed_through(client, monkeypatch):
+    marks = [{"word": word, "start": i * 0.5, "end": i * 0.5 + 0.4} for i, word in enumerate(_REPLY.split())]
+    _fake_turn(monkeypatch, _wav(1.0), {"X-Word-Timestamps": json.dumps(marks)})
+    res = client.post("/v1/speech_to_speech", params={"format": "ndjson"}, data={"text": "weather?"})
+    events = [json.loads(line) for line in res.text.splitlines()]
+    assert events[0]["word_timing"] == "tts"
+    words = [w for e in events if e["type"] == "caption" for w in e["words"]]
+    assert [(w["start_seconds"], w["end_seconds"]) for w in words] == [(m["start"], m["end"]) for m in marks]
+    assert "captions" not in events[-1]  # nothing to re-time
+
+
+def test_cues_as_srt_and_webvtt():
+    cues = caption_cues("Hello <there>. Bye now.", 3725.5)
+    srt = subtitles(cues, "srt")
+    assert srt.startswith("1\n00:00:00,000 --> ") and "\n\n2\n" in srt and srt.endswith("01:02:05,500\nBye now.\n")
+    vtt = subtitles(cues, "vtt")
+    assert vtt.startswith("WEBVTT\n\n00:00:00.000 --> ") and "Hello &lt;there&gt;." in vtt
+
+
+def test_subtitles_come_with_the_json_and_ndjson_replies(client, monkeypatch):
+    _fake_turn(monkeypatch, _wav(4.0))
+    res = client.post("/v1/speech_to_speech", params={"format": "json", "subtitles": "vtt"}, data={"text": "weather?"})
+    assert res.status_code == 200
+    assert res.json()["subtitles"].startswith("WEBVTT") and "00:00:04.000" in res.json()["subtitles"]
+
+    res = client.post("/v1/speech_to_speech", params={"format": "ndjson", "subtitles": "srt"}, data={"text": "weather?"})
+    done = json.loads(res.text.splitlines()[-1])
+    assert done["subtitles"].startswith("1\n") and "00:00:04,000" in done["subtitles"]
+
+
+def test_subtitles_need_a_body_to_travel_in(client, monkeypatch):
+    _fake_turn(monkeypatch, _wav(1.0))
+    assert client.post("/v1/speech_to_speech", params={"subtitles": "srt"}, data={"text": "weather?"}).status_code == 400
+    res = client.post("/v1/speech_to_speech", params={"format": "json", "subtitles": "ass"}, data={"text": "weather?"})
+    assert res.status_code == 400
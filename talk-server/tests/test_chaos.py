@@ -0,0 +1,70 @@
+import asyncio
+
+import pytest
+
+from routers import chat as chat_router
+from services import chaos
+from services import pipeline
+from services.chaos import ChaosMonkey, install
+from services.errors import StageError
+from services.hooks import hooks
+from services.tts import TTSStream
+
+
+@pytest.fixture(autouse=True)
+def _clear_hooks():
+    hooks.clear()
+    yield
+    hooks.clear()
+
+
+def test_faults_follow_per_stage_probabilities():
+    monkey = ChaosMonkey(latency_rate={}, error_rate={"llm": 1.0}, latency_ms=0, seed="1")
+    asyncio.run(monkey.before("asr"))
+    with pytest.raises(StageError) as exc:
+        asyncio.run(monkey.before("llm"))
+    assert exc.value.stage == "llm"
+    assert exc.value.status_code == 503
+    assert exc.value.details == {"injected": True}
+    assert exc.value.headers == {"Retry-After": "1"}
+
+
+def test_truncation_cuts_audio_part_way():
+    audio = b"ID3" + bytes(997)
+
+    async def truncated(rate):
+        stream = await ChaosMonkey(latency_rate={}, error_rate={}, truncate_rate=rate, seed="1").truncate(
+            TTSStream.cached("audio/mpeg", audio)
+        )
+        return None if stream is None else await stream.read()
+
+    cut = asyncio.run(truncated(1.0))
+    assert 100 <= len(cut) <= 900
+    assert audio.startswith(cut)
+    assert asyncio.run(truncated(0)) is None
+
+
+def test_install_only_in_chaos_mode(monkeypatch):
+    assert not install()
+    monkeypatch.setattr(chaos, "CHAOS_MODE", True)
+    assert install()
+    assert not install()
+    assert [h.__name__ for h in hooks.registered("before_tts")] == ["chaos_tts"]
+
+
+def test_injected_failure_uses_error_envelope(client, monkeypatch):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "unreachable"
+
+    monkeypatch.setattr(chaos, "CHAOS_MODE", True)
+    monkeypatch.setattr(chaos, "monkey", ChaosMonkey(latency_rate={}, error_rate={"llm": 1.0}))
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "call_llm", fake_call_llm)
+    install()
+
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "hello"})
+    assert res.status_code == 503
+    assert res.headers["Retry-After"] == "1"
+    error = res.json()["error"]
+    assert error["stage"] == "llm"
+    assert error["details"]["injected"] is True
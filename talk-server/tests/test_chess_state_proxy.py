@@ -17,16 +17,7 @@ class _FakeResponse:
 
 
 class _FakeAsyncClient:
-    def __init__(self, *args, **kwargs):
-        pass
-
-    async def __aenter__(self):
-        return self
-
-    async def __aexit__(self, exc_type, exc, tb):
-        return None
-
-    async def get(self, url):
+    async def get(self, url, timeout=None):
         assert url.endswith("/v1/chess/state")
         return _FakeResponse(
             200,
@@ -41,9 +32,9 @@ class _FakeAsyncClient:
 
 
 def test_chess_state_proxy(monkeypatch):
-    import httpx
+    from routers import chess as chess_router
     monkeypatch.setenv("DWANI_AGENT_BASE_URL", "http://agents:8081")
-    monkeypatch.setattr(httpx, "AsyncClient", _FakeAsyncClient)
+    monkeypatch.setattr(chess_router, "http_client", _FakeAsyncClient)
     res = client.get("/v1/chess/state")
     assert res.status_code == 200
     body = res.json()
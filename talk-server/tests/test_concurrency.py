@@ -0,0 +1,185 @@
+import asyncio
+import io
+
+import pytest
+from fastapi.testclient import TestClient
+
+import main
+from routers import chat as chat_router
+from services import concurrency, dictation, meetings, pronunciation
+from services.balancer import BackendPool
+from services.concurrency import ConcurrencyLimiter, PipelineBusy
+
+
+def test_disabled_limiter_never_blocks():
+    async def run():
+        limiter = ConcurrencyLimiter(max_concurrent=0, max_queue=0)
+        async with limiter.slot(), limiter.slot():
+            assert limiter.active == 0
+
+    asyncio.run(run())
+
+
+def test_waiters_are_served_in_order_then_rejected_when_queue_is_full():
+    async def run():
+        limiter = ConcurrencyLimiter(max_concurrent=1, max_queue=1, queue_timeout=5)
+        order = []
+        await limiter.acquire()
+
+        async def waiter():
+            async with limiter.slot():
+                order.append("waiter")
+
+        task = asyncio.create_task(waiter())
+        await asyncio.sleep(0)
+        assert limiter.queued == 1
+        with pytest.raises(PipelineBusy) as exc:
+            await limiter.acquire()
+        assert exc.value.status_code == 503
+        assert exc.value.headers["Retry-After"]
+        limiter.release()
+        await task
+        assert order == ["waiter"]
+        assert limiter.active == 0 and limiter.queued == 0
+
+    asyncio.run(run())
+
+
+def test_queue_timeout_returns_busy_and_frees_the_queue():
+    async def run():
+        limiter = ConcurrencyLimiter(max_concurrent=1, max_queue=4, queue_timeout=0.01)
+        await limiter.acquire()
+        with pytest.raises(PipelineBusy):
+            await limiter.acquire()
+        assert limiter.queued == 0
+        limiter.release()
+        assert limiter.active == 0
+
+    asyncio.run(run())
+
+
+def test_blocking_acquire_ignores_queue_bound():
+    async def run():
+        limiter = ConcurrencyLimiter(max_concurrent=1, max_queue=0, queue_timeout=0.01)
+        await limiter.acquire()
+        task = asyncio.create_task(limiter.acquire(block=True))
+        await asyncio.sleep(0.05)
+        assert not task.done()
+        limiter.release()
+        await task
+        assert limiter.active == 1
+
+    asyncio.run(run())
+
+
+def test_speech_to_speech_returns_503_when_at_capacity(monkeypatch):
+    full = ConcurrencyLimiter(max_concurrent=1, max_queue=0)
+    full._active = 1
+    monkeypatch.setattr(chat_router, "pipeline_limiter", full)
+
+    res = TestClient(main.app).post(
+        "/v1/speech_to_speech",
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 503
+    assert res.headers["Retry-After"] == "5"
+    assert res.json()["error"]["code"] == "503"
+
+
+def test_dictation_pronunciation_and_meetings_return_503_when_at_capacity(client, monkeypatch):
+    full = ConcurrencyLimiter(max_concurrent=1, max_queue=0)
+    full._active = 1
+    for module in (dictation, pronunciation, meetings):
+        monkeypatch.setattr(module, "pipeline_limiter", full)
+
+    def upload():
+        return {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+
+    assert client.post("/v1/dictation", files=upload()).status_code == 503
+    assert client.post("/v1/pronunciation", data={"reference_text": "namaskara"}, files=upload()).status_code == 503
+    assert client.post("/v1/meetings/summarize", files=upload()).status_code == 503
+
+
+def test_stage_limit_bounds_upstream_calls(monkeypatch):
+    monkeypatch.setitem(concurrency.stage_limiters, "llm", ConcurrencyLimiter(1, 0, 0.01, stage="llm"))
+
+    async def run():
+        pool = BackendPool("llm", ("http://llm",))
+        in_flight = []
+        release = asyncio.Event()
+
+        async def call(url):
+            in_flight.append(url)
+            await release.wait()
+            return "ok"
+
+        first = asyncio.create_task(pool.call(call))
+        await asyncio.sleep(0)
+        with pytest.raises(PipelineBusy):
+            await pool.call(call)
+        # Background jobs wait for the slot instead of being turned away.
+        async with ConcurrencyLimiter(0, 0).slot(block=True):
+            second = asyncio.create_task(pool.call(call))
+            await asyncio.sleep(0.05)
+            assert len(in_flight) == 1
+            release.set()
+            assert await first == "ok" and await second == "ok"
+        assert len(in_flight) == 2
+        # Other stages are not limited.
+        assert await BackendPool("asr", ("http://asr",)).call(call) == "ok"
+
+    asyncio.run(run())
+
+
+def test_interactive_waiters_are_served_before_batch_ones():
+    async def run():
+        limiter = ConcurrencyLimiter(max_concurrent=1, max_queue=4, queue_timeout=5, interactive_reserved=0)
+        order = []
+        await limiter.acquire()
+
+        async def waiter(name, priority):
+            async with limiter.slot(priority=priority):
+                order.append(name)
+
+        batch = asyncio.create_task(waiter("batch", "batch"))
+        await asyncio.sleep(0)
+        live = asyncio.create_task(waiter("interactive", "interactive"))
+        await asyncio.sleep(0)
+        assert limiter.queued == 2
+        limiter.release()
+        await asyncio.gather(batch, live)
+        assert order == ["interactive", "batch"]
+        assert limiter.active == 0 and limiter.queued == 0
+
+    asyncio.run(run())
+
+
+def test_batch_work_leaves_the_reserved_slots_to_interactive_requests():
+    async def run():
+        limiter = ConcurrencyLimiter(max_concurrent=3, max_queue=4, queue_timeout=5, interactive_reserved=1)
+        assert limiter.batch_limit == 2
+        await limiter.acquire(priority="batch")
+        await limiter.acquire(priority="batch")
+        third = asyncio.create_task(limiter.acquire(block=True, priority="batch"))
+        await asyncio.sleep(0)
+        assert not third.done() and limiter.active == 2
+        # The reserved slot is free for a live request.
+        await limiter.acquire(priority="interactive")
+        assert limiter.active == 3
+        limiter.release()  # the live request is done; batch is still at its limit
+        await asyncio.sleep(0)
+        assert not third.done()
+        limiter.release()  # a batch slot frees up
+        await third
+        assert limiter.active == 2
+
+    asyncio.run(run())
+
+
+def test_priority_comes_from_the_api_key_and_may_only_be_lowered(monkeypatch):
+    monkeypatch.setattr(concurrency, "PRIORITY_API_KEYS", {"bulk": "batch", "live": "interactive"})
+    assert concurrency.use_priority("bulk") == "batch"
+    assert concurrency.use_priority("bulk", "interactive") == "batch"
+    assert concurrency.use_priority("live", "Batch") == "batch"
+    assert concurrency.use_priority(None) == "interactive"
+    assert concurrency.current_priority() == "interactive"
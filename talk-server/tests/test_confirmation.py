@@ -0,0 +1,135 @@
+import asyncio
+import uuid
+
+import pytest
+from fastapi import HTTPException
+
+from routers import chat as chat_router
+from services import confirmation as confirmation_svc
+from services import pipeline
+from services.tts import TTSStream
+
+_PAYMENT = {"name": "payment", "slots": {"amount": 500, "to": "Ravi"}}
+
+
+@pytest.fixture(autouse=True)
+def _sensitive(monkeypatch):
+    monkeypatch.setattr(confirmation_svc, "CONFIRM_INTENTS", {"payment"})
+    monkeypatch.setattr(confirmation_svc, "CONFIRM_MAX_ATTEMPTS", 1)
+
+
+@pytest.fixture
+def agent(monkeypatch):
+    calls = []
+
+    async def fake_call_agent(agent_name, text, session_id=None, request_id=None):
+        calls.append(text)
+        if "pay" in text:
+            return {"reply": "Paying Ravi now.", "intent": _PAYMENT}
+        if "weather" in text:
+            return {"reply": "It is sunny.", "intent": {"name": "weather_lookup"}}
+        return {"reply": "Okay."}
+
+    monkeypatch.setattr(pipeline, "call_agent", fake_call_agent)
+    return calls
+
+
+def _turn(text, session_id):
+    return asyncio.run(pipeline.converse(mode="agent", session_id=session_id, text=text))
+
+
+def test_sensitive_intent_waits_for_a_yes(agent, monkeypatch):
+    delivered = []
+
+    async def fake_deliver(url, event, request_id=None):
+        delivered.append((url, event))
+        return True
+
+    monkeypatch.setattr(confirmation_svc, "INTENT_WEBHOOK_URL", "http://hooks/intents")
+    monkeypatch.setattr(confirmation_svc, "deliver_webhook", fake_deliver)
+    session = f"s-{uuid.uuid4()}"
+
+    asked = _turn("pay Ravi 500", session)
+    assert asked.reply == "Please confirm: payment (amount: 500, to: Ravi). Should I go ahead? Say yes or no."
+    assert asked.confirmation.status == "pending" and asked.confirmation.intent is None
+    assert delivered == []
+
+    done = _turn("haan", session)
+    assert done.confirmation.status == "confirmed"
+    assert done.confirmation.intent.slots == {"amount": 500, "to": "Ravi"}
+    assert [event["type"] for _, event in delivered] == ["ai.dwani.talk.intent.confirmed"]
+    assert agent == ["pay Ravi 500"]  # the answer never reached the agent
+
+    assert _turn("thanks", session).confirmation is None
+
+
+def test_no_or_repeated_unclear_answers_drop_the_intent(agent):
+    session = f"s-{uuid.uuid4()}"
+    _turn("pay Ravi 500", session)
+    assert _turn("no", session).confirmation.status == "cancelled"
+
+    _turn("pay Ravi 500", session)
+    again = _turn("what did you say?", session)
+    assert again.confirmation.status == "pending"
+    assert again.reply.startswith("Please confirm: payment")
+    dropped = _turn("hmm", session)
+    assert dropped.confirmation.status == "cancelled"
+    assert dropped.confirmation.intent is None
+
+
+def test_other_intents_are_emitted_at_once(agent):
+    turn = _turn("weather in Mysuru", f"s-{uuid.uuid4()}")
+    assert turn.reply == "It is sunny."
+    assert turn.confirmation.status == "emitted"
+    assert turn.confirmation.intent.name == "weather_lookup"
+
+
+def test_failed_delivery_is_reported(agent, monkeypatch):
+    async def failing_deliver(url, event, request_id=None):
+        return False
+
+    monkeypatch.setattr(confirmation_svc, "INTENT_WEBHOOK_URL", "http://hooks/intents")
+    monkeypatch.setattr(confirmation_svc, "deliver_webhook", failing_deliver)
+    session = f"s-{uuid.uuid4()}"
+    _turn("pay Ravi 500", session)
+    turn = _turn("yes", session)
+    assert turn.confirmation.status == "failed"
+    assert turn.confirmation.intent is None
+
+
+def test_confirmation_needs_a_session():
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(confirmation_svc.handle_intent(confirmation_svc.Intent("payment"), None))
+    assert exc.value.status_code == 400
+
+
+def test_question_is_phrased_in_the_session_language(monkeypatch):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        assert language == "kannada" and "payment" in user_text
+        return "ರವಿಗೆ 500 ರೂಪಾಯಿ ಪಾವತಿಸಬೇಕೇ? ಹೌದು ಅಥವಾ ಇಲ್ಲ ಎನ್ನಿ."
+
+    monkeypatch.setattr(confirmation_svc, "call_llm", fake_call_llm)
+    outcome = asyncio.run(confirmation_svc.handle_intent(
+        confirmation_svc.Intent.from_payload(_PAYMENT), f"s-{uuid.uuid4()}", language="kannada"
+    ))
+    assert outcome.reply.startswith("ರವಿಗೆ")
+
+
+def test_endpoint_reports_confirmation_state(client, agent, monkeypatch):
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    headers = {"X-Session-ID": f"s-{uuid.uuid4()}"}
+
+    res = client.post("/v1/speech_to_speech", params={"mode": "agent"}, data={"text": "pay Ravi 500"}, headers=headers)
+    assert res.status_code == 200
+    assert res.headers["X-Confirmation"] == "pending"
+    assert "X-Intent" not in res.headers
+
+    res = client.post(
+        "/v1/speech_to_speech", params={"mode": "agent", "format": "json"}, data={"text": "yes"}, headers=headers
+    )
+    body = res.json()
+    assert body["confirmation"] == "confirmed"
+    assert body["intent"]["name"] == "payment"
@@ -0,0 +1,123 @@
+import asyncio
+import math
+import struct
+
+from starlette.websockets import WebSocketDisconnect
+
+from models import TranscriptionResponse
+from services import conversation_stream
+from services.errors import StageError
+from services.pipeline import TurnResult
+from services.tts import TTSStream
+from services.turn_detection import PRESETS
+
+
+def _tone(ms, rate=16000):
+    samples = [int(8000 * math.sin(2 * math.pi * 440 * i / rate)) for i in range(rate * ms // 1000)]
+    return struct.pack(f"<{len(samples)}h", *samples)
+
+
+def _silence(ms, rate=16000):
+    return b"\x00\x00" * (rate * ms // 1000)
+
+
+def _fake_pipeline(monkeypatch, fail_on=None):
+    calls = []
+
+    async def fake_converse(file, session_id=None, text=None, on_transcript=None, **kwargs):
+        heard = text if text is not None else "namaskara"
+        calls.append((session_id, heard, file is not None))
+        if heard == fail_on:
+            raise StageError("llm", detail="LLM service unavailable")
+        await on_transcript(heard, "kannada")
+        return TurnResult(transcription=heard, reply=f"reply to {heard}", language="kannada")
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"ID3" + text.encode())
+
+    monkeypatch.setattr(conversation_stream, "converse", fake_converse)
+    monkeypatch.setattr(conversation_stream, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(conversation_stream, "CONVERSATION_PARTIAL_MS", 0)
+    return calls
+
+
+def _events(ws):
+    events = []
+    while True:
+        try:
+            events.append(ws.receive_json())
+        except WebSocketDisconnect:
+            return events
+
+
+def test_turns_are_answered_with_typed_events(client, monkeypatch):
+    calls = _fake_pipeline(monkeypatch)
+    with client.websocket_connect("/v1/conversation/stream?language=kannada&turn_detection=fast&session_id=s1") as ws:
+        ready = ws.receive_json()
+        assert ready["type"] == "ready" and ready["protocol"] == "dwani.conversation.v1" and ready["session_id"] == "s1"
+        ws.send_text('{"type": "text", "text": "hello"}')
+        ws.send_bytes(_silence(200) + _tone(400) + _silence(PRESETS["fast"].silence_ms + 100))
+        ws.send_text('{"type": "end"}')
+        events = _events(ws)
+
+    # Turn 1 may start while turn 0 is still being answered; each turn's own events are in order.
+    for turn in (0, 1):
+        assert [e["type"] for e in events if e["turn"] == turn] == [
+            "turn_start", "final_transcript", "assistant_text", "audio_chunk", "turn_end"
+        ]
+    by_type = {(e["type"], e["turn"]): e for e in events}
+    assert by_type[("turn_start", 0)]["input"] == "text" and by_type[("turn_start", 1)]["input"] == "audio"
+    assert by_type[("final_transcript", 1)] == {"type": "final_transcript", "turn": 1, "text": "namaskara", "language": "kn-IN"}
+    assert by_type[("assistant_text", 0)]["text"] == "reply to hello"
+    chunk = by_type[("audio_chunk", 0)]
+    assert chunk["sequence"] == 0 and chunk["content_type"] == "audio/mpeg" and chunk["audio_base64"]
+    assert by_type[("turn_end", 1)]["status"] == "completed"
+    assert calls == [("s1", "hello", False), ("s1", "namaskara", True)]
+
+
+def test_a_failed_turn_ends_with_an_error(client, monkeypatch):
+    _fake_pipeline(monkeypatch, fail_on="hello")
+    with client.websocket_connect("/v1/conversation/stream") as ws:
+        assert ws.receive_json()["session_id"].startswith("session")
+        ws.send_text('{"type": "text", "text": "hello"}')
+        ws.send_text('{"type": "end"}')
+        events = _events(ws)
+    assert [e["type"] for e in events] == ["turn_start", "error", "turn_end"]
+    assert events[1]["code"] == "502" and events[1]["error_code"] and events[2]["status"] == "failed"
+
+
+def test_settings_are_checked(client):
+    with client.websocket_connect("/v1/conversation/stream?mode=chat") as ws:
+        assert "mode" in ws.receive_json()["message"]
+    with client.websocket_connect("/v1/conversation/stream?format=flac") as ws:
+        assert "format" in ws.receive_json()["message"]
+
+
+def test_partial_transcripts_come_while_the_user_speaks(monkeypatch):
+    _fake_pipeline(monkeypatch)
+    heard = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        heard.append(len(await file.read()))
+        return TranscriptionResponse(text=f"partial {len(heard)}", language=language)
+
+    monkeypatch.setattr(conversation_stream, "transcribe_audio", fake_transcribe)
+    sent = []
+
+    async def send(event):
+        sent.append(event)
+
+    async def talk():
+        stream = conversation_stream.ConversationStream(send, PRESETS["fast"], "s1", partial_ms=200)
+        await stream.start()
+        for _ in range(10):
+            await stream.feed(_tone(100))
+            await asyncio.sleep(0.02)
+        await stream.finish()
+
+    asyncio.run(talk())
+    kinds = [event["type"] for event in sent]
+    partials = [event for event in sent if event["type"] == "partial_transcript"]
+    assert partials and all(event["turn"] == 0 for event in partials)
+    assert kinds.index("partial_transcript") < kinds.index("final_transcript")
+    assert "partial_transcript" not in kinds[kinds.index("final_transcript"):]
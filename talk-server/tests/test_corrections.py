@@ -0,0 +1,96 @@
+"""Tests for the transcript correction dictionary (services/corrections.py) and its admin endpoints."""
+import json
+import os
+
+import pytest
+
+from routers import admin as admin_router
+from services import corrections
+from services.hooks import HookContext, PipelineHooks
+
+ADMIN = {"X-Admin-Token": "admin-token"}
+
+
+def _dictionary(*specs):
+    dictionary = corrections.CorrectionDictionary("")
+    for spec in specs:
+        dictionary.add(corrections.correction_from_spec(spec))
+    return dictionary
+
+
+def test_phrases_match_whole_words_in_any_case():
+    dictionary = _dictionary(
+        {"match": "the money app", "replace": "Dwani app"},
+        {"match": "ಬೆಂಗಳೂರ್", "replace": "ಬೆಂಗಳೂರು"},
+    )
+    assert dictionary.apply("Open The Money App please") == "Open Dwani app please"
+    assert dictionary.apply("the money apps") == "the money apps"
+    assert dictionary.apply("ಬೆಂಗಳೂರ್ ಗೆ ಹೋಗು") == "ಬೆಂಗಳೂರು ಗೆ ಹೋಗು"
+    assert dictionary.apply("ಬೆಂಗಳೂರ್ನಲ್ಲಿ") == "ಬೆಂಗಳೂರ್ನಲ್ಲಿ"
+
+
+def test_regexes_use_groups_and_languages_limit_entries():
+    dictionary = _dictionary(
+        {"match": r"pin code (\d) (\d)", "replace": r"PIN code \1\2", "regex": True},
+        {"match": "myso", "replace": "ಮೈಸೂರು", "language": "kn"},
+    )
+    assert dictionary.apply("my pin code 5 6") == "my PIN code 56"
+    assert dictionary.apply("myso", "kannada") == "ಮೈಸೂರು"
+    assert dictionary.apply("myso", "hindi") == "myso"
+
+
+def test_adding_the_same_match_again_updates_it():
+    dictionary = _dictionary({"match": "dwany", "replace": "Dwani"})
+    entry = dictionary.add(corrections.correction_from_spec({"match": "dwany", "replace": "dwani"}))
+    assert [e.replace for e in dictionary.entries()] == ["dwani"]
+    assert dictionary.remove(entry.id) and not dictionary.remove(entry.id)
+
+
+def test_malformed_entries_are_refused():
+    for bad in (
+        "dwany",
+        {"match": "", "replace": "x"},
+        {"match": "a", "replace": "b", "regex": "yes"},
+        {"match": "a", "replace": "b", "language": "klingon"},
+        {"match": "a", "replace": "b", "case": True},
+        {"match": "(", "replace": "b", "regex": True},
+        {"match": "a", "replace": r"\2", "regex": True},
+    ):
+        with pytest.raises(ValueError):
+            corrections.correction_from_spec(bad)
+
+
+def test_changes_are_saved_and_reloaded_from_the_file(tmp_path):
+    path = tmp_path / "corrections.json"
+    dictionary = corrections.CorrectionDictionary(str(path))
+    assert dictionary.entries() == []
+    dictionary.add(corrections.correction_from_spec({"match": "dwany", "replace": "Dwani"}))
+    assert json.loads(path.read_text()) == [{"match": "dwany", "replace": "Dwani", "regex": False, "language": None}]
+
+    other = corrections.CorrectionDictionary(str(path))
+    path.write_text(json.dumps([{"match": "tolk", "replace": "talk"}]))
+    os.utime(path, (1, 1))
+    assert other.apply("dwany tolk") == "dwany talk"
+    path.write_text("[{")
+    os.utime(path, (2, 2))
+    assert other.apply("dwany tolk") == "dwany talk"
+
+
+def test_the_hook_corrects_in_the_turn_language(monkeypatch):
+    monkeypatch.setattr(corrections, "corrections", _dictionary({"match": "tolk", "replace": "talk", "language": "english"}))
+    registry = PipelineHooks()
+    assert corrections.install(registry) and not corrections.install(registry)
+    assert registry.registered("after_asr")[0](HookContext(language="english"), "tolk to me") == "talk to me"
+
+
+def test_admins_manage_entries(client, monkeypatch):
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+    monkeypatch.setattr(corrections, "corrections", corrections.CorrectionDictionary(""))
+    monkeypatch.setattr(admin_router, "corrections", corrections.corrections)
+    res = client.post("/admin/corrections", json={"match": "dwany", "replace": "Dwani"}, headers=ADMIN)
+    assert res.status_code == 200
+    entry_id = res.json()["id"]
+    assert client.get("/admin/corrections", headers=ADMIN).json()["corrections"][0]["id"] == entry_id
+    assert client.post("/admin/corrections", json={"match": "("}, headers=ADMIN).status_code == 400
+    assert client.delete(f"/admin/corrections/{entry_id}", headers=ADMIN).status_code == 200
+    assert client.delete(f"/admin/corrections/{entry_id}", headers=ADMIN).status_code == 404
@@ -0,0 +1,65 @@
+import pytest
+
+import cors
+import main
+from ratelimit import TokenBucketLimiter
+
+_PREFLIGHT = {"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type"}
+
+
+def test_wildcard_origins_stay_within_the_host():
+    policy = cors.load_policy(origins="https://talk.dwani.ai, https://*.dwani.ai")
+    assert policy.allows("https://talk.dwani.ai")
+    assert policy.allows("https://app.dwani.ai/")
+    assert not policy.allows("https://app.dwani.ai.evil.com")
+    assert not policy.allows("https://evil.com/.dwani.ai")
+    assert not policy.allows(None)
+
+
+def test_any_origin_never_advertises_credentials():
+    policy = cors.load_policy(origins="*", allow_credentials=True)
+    headers = policy.preflight_headers("https://example.com")
+    assert headers["Access-Control-Allow-Origin"] == "*"
+    assert "Access-Control-Allow-Credentials" not in headers
+
+
+@pytest.fixture
+def locked_down(monkeypatch):
+    policy = cors.load_policy(
+        origins="https://app.example.com", headers="Content-Type,X-API-Key", max_age=600, allow_credentials=False
+    )
+    monkeypatch.setattr(main, "cors_policy", policy)
+    return policy
+
+
+def test_preflight_from_allowed_origin(client, locked_down):
+    res = client.options("/v1/chat", headers={"Origin": "https://app.example.com", **_PREFLIGHT})
+    assert res.status_code == 204
+    assert res.headers["access-control-allow-origin"] == "https://app.example.com"
+    assert res.headers["access-control-allow-headers"] == "Content-Type, X-API-Key"
+    assert res.headers["access-control-max-age"] == "600"
+    assert "access-control-allow-credentials" not in res.headers
+
+
+def test_preflight_from_other_origin_is_rejected(client, locked_down):
+    res = client.options("/v1/chat", headers={"Origin": "https://talk.dwani.ai", **_PREFLIGHT})
+    assert res.status_code == 403
+    assert "access-control-allow-origin" not in res.headers
+
+
+def test_simple_request_gets_origin_and_exposed_headers(client, locked_down):
+    res = client.get("/health", headers={"Origin": "https://app.example.com"})
+    assert res.headers["access-control-allow-origin"] == "https://app.example.com"
+    assert "Origin" in res.headers["vary"]
+    assert "X-Request-ID" in res.headers["access-control-expose-headers"]
+    other = client.get("/health", headers={"Origin": "https://evil.example.com"})
+    assert "access-control-allow-origin" not in other.headers
+
+
+def test_rate_limited_responses_carry_cors_headers(client, locked_down, monkeypatch):
+    monkeypatch.setattr(main, "key_limiter", TokenBucketLimiter(capacity=1, refill_per_second=0.1))
+    headers = {"X-API-Key": "noisy", "Origin": "https://app.example.com"}
+    client.get("/v1/jobs/missing", headers=headers)
+    res = client.get("/v1/jobs/missing", headers=headers)
+    assert res.status_code == 429
+    assert res.headers["access-control-allow-origin"] == "https://app.example.com"
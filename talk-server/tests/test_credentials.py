@@ -0,0 +1,77 @@
+import logging
+import os
+
+from services import backends, credentials
+from services.credentials import FileSecrets, RedactingFilter, Secrets
+
+
+class _Provider:
+    def __init__(self, values):
+        self.values = values
+
+    def get(self, name):
+        return self.values.get(name)
+
+
+def test_provider_then_env_then_file(monkeypatch, tmp_path):
+    key_file = tmp_path / "tts_key"
+    key_file.write_text("from-file-key\n", encoding="utf-8")
+    monkeypatch.setenv("DWANI_TTS_API_KEY_FILE", str(key_file))
+    monkeypatch.delenv("DWANI_TTS_API_KEY", raising=False)
+    assert Secrets().get("DWANI_TTS_API_KEY") == "from-file-key"
+    monkeypatch.setenv("DWANI_TTS_API_KEY", "from-env-key")
+    assert Secrets().get("DWANI_TTS_API_KEY") == "from-env-key"
+    assert Secrets(_Provider({"DWANI_TTS_API_KEY": "from-vault"})).get("DWANI_TTS_API_KEY") == "from-vault"
+    assert Secrets(_Provider({})).get("DWANI_TTS_API_KEY") == "from-env-key"
+
+
+def test_rotated_file_is_read_again(monkeypatch, tmp_path):
+    key_file = tmp_path / "asr_key"
+    key_file.write_text("first-key", encoding="utf-8")
+    monkeypatch.setenv("DWANI_ASR_API_KEY_FILE", str(key_file))
+    files = FileSecrets()
+    assert files.get("DWANI_ASR_API_KEY") == "first-key"
+    key_file.write_text("second-key", encoding="utf-8")
+    stat = key_file.stat()
+    os.utime(key_file, (stat.st_atime, stat.st_mtime + 10))
+    assert files.get("DWANI_ASR_API_KEY") == "second-key"
+
+
+def test_missing_file_gives_no_key(monkeypatch, tmp_path):
+    monkeypatch.setenv("DWANI_ASR_API_KEY_FILE", str(tmp_path / "absent"))
+    monkeypatch.delenv("DWANI_ASR_API_KEY", raising=False)
+    assert Secrets().get("DWANI_ASR_API_KEY") == ""
+
+
+def test_handed_out_keys_are_redacted_in_logs(monkeypatch):
+    store = Secrets(_Provider({"DWANI_LLM_API_KEY": "sk-live-123456"}))
+    monkeypatch.setattr(credentials, "secrets", store)
+    store.get("DWANI_LLM_API_KEY")
+    record = logging.LogRecord("dwani", logging.INFO, __file__, 1, "calling with %s", ("Bearer sk-live-123456",), None)
+    assert RedactingFilter().filter(record)
+    assert record.getMessage() == "calling with Bearer ***"
+
+
+def test_signing_secrets_fall_back_to_one_random_key_per_process(monkeypatch):
+    monkeypatch.setattr(credentials, "_random_keys", {})
+    monkeypatch.delenv("DWANI_SHARE_LINK_SECRET", raising=False)
+    monkeypatch.delenv("DWANI_SHARE_LINK_SECRET_FILE", raising=False)
+    key = credentials.signing_secret("DWANI_SHARE_LINK_SECRET", "share links")
+    assert len(key) == 32 and credentials.signing_secret("DWANI_SHARE_LINK_SECRET", "share links") == key
+    monkeypatch.setenv("DWANI_SHARE_LINK_SECRET", "share-secret")
+    assert credentials.signing_secret("DWANI_SHARE_LINK_SECRET", "share links") == b"share-secret"
+
+
+def test_auth_headers(monkeypatch):
+    monkeypatch.setattr(backends, "_API_KEYS", {**backends._API_KEYS, "tts": lambda: "tts-key"})
+    assert backends.auth_headers("tts") == {"Authorization": "Bearer tts-key"}
+    monkeypatch.setattr(backends, "BACKEND_AUTH_HEADERS", {"tts": "X-API-Key"})
+    assert backends.auth_headers("tts") == {"X-API-Key": "tts-key"}
+    monkeypatch.setattr(backends, "_API_KEYS", {**backends._API_KEYS, "tts": lambda: ""})
+    assert backends.auth_headers("tts") == {}
+
+
+def test_llm_key_has_no_dummy_default(monkeypatch):
+    monkeypatch.delenv("DWANI_LLM_API_KEY", raising=False)
+    monkeypatch.delenv("DWANI_LLM_API_KEY_FILE", raising=False)
+    assert backends.llm_api_key() == ""
@@ -0,0 +1,70 @@
+import io
+import time
+
+import pytest
+from fastapi.testclient import TestClient
+
+import main
+from services import deadline, pipeline
+
+
+def test_stage_timeout_is_capped_by_request_deadline():
+    deadline.set_deadline(2)
+    try:
+        assert deadline.stage_timeout("llm", 60) <= 2
+        assert deadline.stage_timeout("llm", 1) == 1
+    finally:
+        deadline.set_deadline(None)
+
+
+def test_expired_deadline_raises_stage_timeout():
+    deadline.set_deadline(0.001)
+    time.sleep(0.01)
+    try:
+        with pytest.raises(deadline.StageTimeout) as exc:
+            deadline.stage_timeout("tts", 30)
+        assert exc.value.status_code == 504
+        assert exc.value.stage == "tts"
+    finally:
+        deadline.set_deadline(None)
+
+
+def test_speech_to_speech_reports_timed_out_stage(monkeypatch):
+    async def slow_transcribe(file, language=None, request_id=None):
+        raise deadline.StageTimeout("asr")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", slow_transcribe)
+    client = TestClient(main.app)
+    res = client.post(
+        "/v1/speech_to_speech",
+        headers={"X-Request-Timeout": "5"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 504
+    assert res.headers["X-Timeout-Stage"] == "asr"
+    assert res.json()["error"]["details"]["stage"] == "asr"
+
+
+def test_deadline_ms_header_sets_the_budget_and_reports_what_is_left(monkeypatch):
+    budgets = []
+
+    async def timed_transcribe(file, language=None, request_id=None):
+        budgets.append(deadline.remaining())
+        raise deadline.StageTimeout("asr")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", timed_transcribe)
+    client = TestClient(main.app)
+    res = client.post(
+        "/v1/speech_to_speech",
+        headers={"X-Request-Timeout": "5", "X-Deadline-Ms": "250"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 504
+    assert 0 < budgets[0] <= 0.25
+    consumed, left = int(res.headers["X-Deadline-Consumed-Ms"]), int(res.headers["X-Deadline-Remaining-Ms"])
+    assert consumed >= 0 and 0 <= left <= 250 - consumed + 1
+
+    res = client.get("/v1/turn_detection/presets")
+    assert "X-Deadline-Remaining-Ms" not in res.headers
+    res = client.get("/v1/turn_detection/presets", headers={"X-Deadline-Ms": "soon"})
+    assert "X-Deadline-Remaining-Ms" not in res.headers
@@ -0,0 +1,76 @@
+import pytest
+
+import deps
+import main
+from ratelimit import TokenBucketLimiter
+from routers import auth as auth_router
+from routers import demo
+from services import demo as demo_svc
+from services.audio_format import detect_format
+from services.telephony import _wav_pcm, wav_bytes
+
+
+def test_demo_page_is_served_at_the_root(client):
+    res = client.get("/")
+    assert res.status_code == 200 and res.headers["content-type"].startswith("text/html")
+    assert "/v1/speech_to_speech" in res.text
+    assert '<option value="kannada">Kannada</option>' in res.text and "{{" not in res.text
+    assert "connect-src 'self'" in res.headers["Content-Security-Policy"]
+
+
+def test_demo_page_can_be_turned_off(client, monkeypatch):
+    monkeypatch.setattr(demo, "DEMO_PAGE", False)
+    assert client.get("/").status_code == 404
+
+
+@pytest.fixture
+def demo_mode(monkeypatch):
+    for module in (demo, deps, demo_svc):
+        monkeypatch.setattr(module, "DEMO_MODE", True)
+    monkeypatch.delenv("DWANI_API_KEY", raising=False)
+
+
+def test_demo_keys_rotate_and_stay_valid_one_window_longer(demo_mode):
+    issued = demo_svc.issue_key(now=7200.0)
+    assert issued["rotates_at"] == 10800
+    assert demo_svc.valid_demo_key(issued["api_key"], now=10799.0)
+    assert demo_svc.valid_demo_key(issued["api_key"], now=14000.0)
+    assert not demo_svc.valid_demo_key(issued["api_key"], now=14400.0)
+    assert not demo_svc.valid_demo_key("demo_" + "0" * 32, now=7200.0)
+
+
+def test_demo_mode_requires_the_issued_key(client, demo_mode):
+    assert client.get("/v1/jobs/unknown").status_code == 401
+    res = client.get("/demo/key")
+    assert res.status_code == 200 and res.headers["cache-control"] == "no-store"
+    assert client.get("/v1/jobs/unknown", headers={"X-API-Key": res.json()["api_key"]}).status_code == 404
+
+
+def test_demo_key_is_not_served_outside_demo_mode(client):
+    assert client.get("/demo/key").status_code == 404
+
+
+def test_daily_quota_counts_pipeline_requests(client, monkeypatch):
+    monkeypatch.setattr(main, "demo_quota", TokenBucketLimiter(1, 1 / 86400))
+    assert client.post("/v1/chat", json={"text": "hello", "mode": "agent", "agent_name": "unknown_agent"}).status_code == 422
+    res = client.post("/v1/chat", json={"text": "hello", "mode": "agent", "agent_name": "unknown_agent"})
+    assert res.status_code == 429 and int(res.headers["Retry-After"]) > 3600
+
+
+def test_signup_is_closed_in_demo_mode(client, monkeypatch):
+    monkeypatch.setattr(auth_router, "DEMO_MODE", True)
+    res = client.post("/v1/auth/signup", json={"email": "demo@example.com", "password": "correct horse battery"})
+    assert res.status_code == 403
+
+
+def test_watermark_marks_mp3_and_wav_once():
+    mp3 = b"\xff\xfb\x90\x00" + b"\x00" * 64
+    marked = demo_svc.watermark(mp3, "demo audio")
+    assert detect_format(None, marked[:12]) == "mp3" and marked.endswith(mp3)
+    assert b"demo audio" in marked and demo_svc.watermark(marked, "demo audio") == marked
+
+    wav = wav_bytes(b"\x01\x00" * 100)
+    marked = demo_svc.watermark(wav, "demo audio")
+    assert b"LIST" in marked and int.from_bytes(marked[4:8], "little") == len(marked) - 8
+    assert _wav_pcm(marked) == _wav_pcm(wav)
+    assert demo_svc.watermark(b"OggS" + b"\x00" * 40, "demo audio").startswith(b"OggS\x00")
@@ -0,0 +1,83 @@
+"""Tests for passing diarized ASR output through to speaker-labelled segments."""
+import asyncio
+import io
+import json
+
+from fastapi import UploadFile
+
+from models import SpeakerSegmentResponse, TranscriptionResponse
+from routers import audio as audio_router
+from services import transcribe as transcribe_svc
+
+_SEGMENTS = [
+    {"speaker": "SPEAKER_07", "start": 0.0, "end": 1.52, "text": " Shall we  start? "},
+    {"speaker": "SPEAKER_02", "start": 1.6, "end": 3.0, "text": "Yes."},
+    {"speaker": "SPEAKER_07", "start": "3.1", "end": 4, "text": "Good."},
+    {"speaker": "SPEAKER_02", "text": ""},
+]
+
+
+class FakeResponse:
+    status_code = 200
+
+    def __init__(self, body):
+        self.body = body
+
+    def json(self):
+        return self.body
+
+
+def _transcribe(monkeypatch, body, diarize=True, field=""):
+    payloads = []
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            payloads.append(json.loads(b"".join([chunk async for chunk in content])))
+            return FakeResponse(body)
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    monkeypatch.setattr(transcribe_svc, "ASR_DIARIZE_FIELD", field)
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+    return asyncio.run(transcribe_svc.transcribe_audio(upload, diarize=diarize)), payloads[0]
+
+
+def test_native_segments_are_renumbered_and_timed(monkeypatch):
+    result, payload = _transcribe(monkeypatch, {"choices": [{"message": {"content": ""}}], "segments": _SEGMENTS}, field="diarize")
+    assert payload["diarize"] is True
+    assert result.text == "Speaker 1: Shall we start?\nSpeaker 2: Yes.\nSpeaker 1: Good."
+    assert result.segments == [
+        SpeakerSegmentResponse(speaker="Speaker 1", text="Shall we start?", start_seconds=0.0, end_seconds=1.52),
+        SpeakerSegmentResponse(speaker="Speaker 2", text="Yes.", start_seconds=1.6, end_seconds=3.0),
+        SpeakerSegmentResponse(speaker="Speaker 1", text="Good.", start_seconds=3.1, end_seconds=4.0),
+    ]
+
+
+def test_prompted_labels_are_split_when_the_backend_does_not_diarize(monkeypatch):
+    body = {"choices": [{"message": {"content": "Speaker 1: Hello.\nSpeaker 2: Hi there."}}]}
+    result, payload = _transcribe(monkeypatch, body)
+    assert "diarize" not in payload
+    assert [(s.speaker, s.text, s.start_seconds) for s in result.segments] == [("Speaker 1", "Hello.", None), ("Speaker 2", "Hi there.", None)]
+
+    result, _ = _transcribe(monkeypatch, {"choices": [{"message": {"content": "Hello."}}], "segments": _SEGMENTS}, diarize=False)
+    assert (result.text, result.segments) == ("Hello.", None)
+
+
+def test_transcriptions_endpoint_returns_speaker_segments(client, monkeypatch):
+    async def fake_transcribe(file, language=None, request_id=None, diarize=False):
+        assert diarize
+        return TranscriptionResponse(text="Speaker 1: Shall we start?\nSpeaker 2:  Yes.", segments=[
+            SpeakerSegmentResponse(speaker="Speaker 1", text="Shall we start?", start_seconds=0.0, end_seconds=1.5),
+            SpeakerSegmentResponse(speaker="Speaker 2", text="Yes."),
+        ])
+
+    monkeypatch.setattr(audio_router, "transcribe_audio", fake_transcribe)
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/audio/transcriptions", data={"diarize": "true"}, files=files)
+    assert res.status_code == 200
+    assert res.json() == {
+        "text": "Speaker 1: Shall we start?\nSpeaker 2: Yes.",
+        "segments": [
+            {"id": 0, "start": 0.0, "end": 1.5, "speaker": "Speaker 1", "text": "Shall we start?"},
+            {"id": 1, "start": None, "end": None, "speaker": "Speaker 2", "text": "Yes."},
+        ],
+    }
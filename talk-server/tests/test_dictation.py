@@ -0,0 +1,119 @@
+import asyncio
+import io
+import json
+import math
+import struct
+import wave
+
+from models import TranscriptionResponse
+from services import dictation as dictation_svc
+
+
+def _wav(seconds: float, rate: int = 8000, quiet=()) -> bytes:
+    samples = []
+    for i in range(int(seconds * rate)):
+        t = i / rate
+        silent = any(lo <= t < hi for lo, hi in quiet)
+        samples.append(0 if silent else int(8000 * math.sin(2 * math.pi * 440 * t)))
+    buf = io.BytesIO()
+    with wave.open(buf, "wb") as w:
+        w.setnchannels(1)
+        w.setsampwidth(2)
+        w.setframerate(rate)
+        w.writeframes(struct.pack(f"<{len(samples)}h", *samples))
+    return buf.getvalue()
+
+
+def test_long_wav_is_split_at_pauses():
+    parts = dictation_svc.split_wav(_wav(70, quiet=[(28.5, 29.0), (57.0, 57.5)]), 30)
+    assert len(parts) == 3
+    assert 28.5 <= parts[0][1] <= 29.0
+    assert 57.0 <= parts[1][1] <= 57.5
+    assert parts[-1][1] == 70.0
+
+
+def test_short_or_non_wav_audio_is_not_split():
+    assert len(dictation_svc.split_wav(_wav(5), 30)) == 1
+    assert dictation_svc.split_wav(b"\xff\xfb\x90\x00" * 100, 30) is None
+
+
+def test_long_silences_are_shortened_and_times_map_back():
+    audio, stats = dictation_svc.compress_silence(_wav(20, quiet=[(5, 10), (12, 12.5), (15, 18)]), 2000, 500)
+    assert stats.as_dict() == {
+        "original_seconds": 20.0,
+        "compressed_seconds": 13.0,
+        "removed_seconds": 7.0,
+        "silences": 2,
+        "ratio": 0.65,
+    }
+    with wave.open(io.BytesIO(audio)) as reader:
+        assert reader.getnframes() == 13 * 8000
+    assert stats.original_time(5.2) == 5.2
+    assert stats.original_time(8.0) == 12.5
+    assert dictation_svc.compress_silence(b"\xff\xfb\x90\x00" * 100, 2000, 500) is None
+
+
+def test_only_raw_asr_output_needs_restoration():
+    assert dictation_svc.needs_restoration("so the meeting is at three tomorrow")
+    assert not dictation_svc.needs_restoration("So the meeting is at three, tomorrow.")
+    assert not dictation_svc.needs_restoration("ok thanks")
+
+
+def test_restoration_that_rewrites_the_text_is_discarded(monkeypatch):
+    async def chatty_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "Sure! Here is your text with punctuation added, I hope this helps you with your notes."
+
+    monkeypatch.setattr(dictation_svc, "call_llm", chatty_llm)
+    assert asyncio.run(dictation_svc.restore_punctuation("call me at five")) is None
+
+
+def _fake_pipeline(monkeypatch, transcripts):
+    calls = iter(transcripts)
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text=next(calls))
+
+    async def fake_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        assert system_prompt and generation.temperature == 0
+        return user_text.capitalize() + "."
+
+    monkeypatch.setattr(dictation_svc, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(dictation_svc, "call_llm", fake_llm)
+
+
+def test_dictation_streams_one_paragraph_per_segment(client, monkeypatch):
+    _fake_pipeline(monkeypatch, ["first part of the note here", "Already punctuated, second part."])
+    audio = _wav(40, quiet=[(28.5, 29.0)])
+    res = client.post("/v1/dictation", files={"file": ("note.wav", io.BytesIO(audio), "audio/wav")})
+    assert res.status_code == 200
+    assert res.headers["content-type"].startswith("application/x-ndjson")
+    lines = [json.loads(line) for line in res.text.splitlines()]
+    assert [line["type"] for line in lines] == ["segment", "segment", "done"]
+    assert lines[0]["text"] == "First part of the note here." and lines[0]["restored"] is True
+    assert lines[1]["text"] == "Already punctuated, second part." and lines[1]["restored"] is False
+
+
+def test_dictation_json_format_joins_paragraphs(client, monkeypatch):
+    _fake_pipeline(monkeypatch, ["one two three four", "five six seven eight"])
+    audio = _wav(40, quiet=[(28.5, 29.0)])
+    res = client.post("/v1/dictation?format=json", files={"file": ("note.wav", io.BytesIO(audio), "audio/wav")})
+    assert res.status_code == 200
+    assert res.json()["text"] == "One two three four.\n\nFive six seven eight."
+    assert len(res.json()["segments"]) == 2
+
+
+def test_dictation_reports_silence_removed_before_asr(client, monkeypatch):
+    sent = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        with wave.open(io.BytesIO(await file.read())) as reader:
+            sent.append(reader.getnframes() / reader.getframerate())
+        return TranscriptionResponse(text="Before the break. After the break.")
+
+    monkeypatch.setattr(dictation_svc, "transcribe_audio", fake_transcribe)
+    audio = _wav(40, quiet=[(10, 25)])
+    res = client.post("/v1/dictation?format=json", files={"file": ("lecture.wav", io.BytesIO(audio), "audio/wav")})
+    assert res.status_code == 200
+    assert sent == [25.5]
+    assert res.json()["segments"][0]["end_seconds"] == 40.0
+    assert res.json()["silence"]["removed_seconds"] == 14.5
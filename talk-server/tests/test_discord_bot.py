@@ -0,0 +1,75 @@
+import asyncio
+import base64
+import struct
+
+from services import discord_bot
+
+
+class FakeStream:
+    """Stands in for ConversationStream: records what it is fed, events are sent by the test."""
+
+    opened = []
+
+    def __init__(self, send, preset, session_id, **kwargs):
+        self.send = send
+        self.session_id = session_id
+        self.kwargs = kwargs
+        self.fed = []
+        FakeStream.opened.append(self)
+
+    async def start(self):
+        pass
+
+    async def feed(self, pcm):
+        self.fed.append(pcm)
+
+    async def close(self):
+        pass
+
+
+def _bridge(monkeypatch, allowed=()):
+    FakeStream.opened = []
+    played = []
+
+    async def play(audio):
+        played.append(audio)
+
+    monkeypatch.setattr(discord_bot, "ConversationStream", FakeStream)
+    monkeypatch.setattr(discord_bot, "DISCORD_ALLOWED_USERS", set(allowed))
+    monkeypatch.setattr(discord_bot, "DISCORD_LANGUAGE", "kn")
+    return discord_bot.VoiceBridge(99, play), played
+
+
+def test_discord_audio_becomes_16k_mono():
+    stereo = struct.pack("<12h", *([1000, 3000] * 6))  # 6 frames at 48 kHz
+    assert discord_bot.to_pipeline_pcm(stereo) == struct.pack("<2h", 2000, 2000)
+
+
+def test_each_member_is_a_session_and_replies_are_played(monkeypatch):
+    bridge, played = _bridge(monkeypatch)
+
+    async def scenario():
+        await bridge.feed(7, b"\0" * 12)
+        await bridge.feed(8, b"\0" * 12)
+        await bridge.feed(7, b"\0" * 12)
+        first, second = FakeStream.opened
+        for chunk in (b"Ogg", b"S"):
+            await first.send({"type": "audio_chunk", "turn": 0, "audio_base64": base64.b64encode(chunk).decode()})
+        await first.send({"type": "turn_end", "turn": 0, "status": "completed"})
+        await second.send({"type": "audio_chunk", "turn": 0, "audio_base64": "eA=="})
+        await second.send({"type": "error", "turn": 0, "message": "LLM down"})
+        await second.send({"type": "turn_end", "turn": 0, "status": "failed"})
+        await bridge.close()
+
+    asyncio.run(scenario())
+    first, second = FakeStream.opened
+    assert (first.session_id, second.session_id) == ("discord:99:7", "discord:99:8")
+    assert first.kwargs["sample_rate"] == 16000 and first.kwargs["language"] == "kannada"
+    assert len(first.fed) == 2 and len(second.fed) == 1
+    assert played == [b"OggS"]
+
+
+def test_only_allowed_members_are_answered(monkeypatch):
+    bridge, _ = _bridge(monkeypatch, allowed={"7"})
+    asyncio.run(bridge.feed(8, b"\0" * 12))
+    assert FakeStream.opened == []
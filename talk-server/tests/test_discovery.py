@@ -0,0 +1,95 @@
+import asyncio
+import sys
+import types
+
+import pytest
+from fastapi import HTTPException
+
+from services import balancer, discovery
+
+
+class FakeConsul:
+    def __init__(self, services):
+        self.services = services
+        self.requests = []
+
+    async def get(self, url, params=None, headers=None):
+        self.requests.append((url, params, headers))
+        body = self.services[url.rsplit("/", 1)[1]]
+        if isinstance(body, Exception):
+            raise body
+
+        class Response:
+            def raise_for_status(self):
+                pass
+
+            def json(self):
+                return body
+
+        return Response()
+
+
+@pytest.fixture
+def fresh(monkeypatch):
+    monkeypatch.setattr(discovery, "_found", {})
+    monkeypatch.setattr(discovery, "_watched", set())
+    balancer.reset_pools()
+    yield
+    balancer.reset_pools()
+
+
+def _entry(address, port, node="10.0.0.9"):
+    return {"Node": {"Address": node}, "Service": {"Address": address, "Port": port}}
+
+
+def test_consul_instances_join_the_pool_and_keep_their_health(fresh, monkeypatch):
+    consul = FakeConsul({"llm": [_entry("10.0.0.1", 8000), _entry("", 8001)]})
+    monkeypatch.setattr(discovery, "http_client", lambda: consul)
+    monkeypatch.setenv("DWANI_CONSUL_TOKEN", "consul-token")
+
+    pool = balancer.pool("llm", "consul+http://llm/v1")
+    assert pool.instances == []  # not looked up yet
+    with pytest.raises(HTTPException) as excinfo:
+        asyncio.run(pool.call(lambda url: asyncio.sleep(0)))
+    assert excinfo.value.status_code == 503
+
+    asyncio.run(discovery.refresh())
+    assert [i.url for i in pool.instances] == ["http://10.0.0.1:8000/v1", "http://10.0.0.9:8001/v1"]
+    url, params, headers = consul.requests[0]
+    assert url.endswith("/v1/health/service/llm") and params == {"passing": "true"}
+    assert headers == {"X-Consul-Token": "consul-token"}
+
+    pool.record_failure(pool.instances[0])
+    consul.services["llm"] = [_entry("10.0.0.1", 8000), _entry("10.0.0.3", 8000)]
+    asyncio.run(discovery.refresh())
+    assert [i.url for i in pool.instances] == ["http://10.0.0.1:8000/v1", "http://10.0.0.3:8000/v1"]
+    assert pool.instances[0].failures == 1
+
+    consul.services["llm"] = ConnectionError("agent down")
+    asyncio.run(discovery.refresh())
+    assert len(pool.instances) == 2  # the last instances found are kept
+
+
+def test_srv_records_of_the_lowest_priority_are_used(fresh, monkeypatch):
+    def record(priority, weight, port, target):
+        return types.SimpleNamespace(priority=priority, weight=weight, port=port, target=target)
+
+    async def resolve(name, kind):
+        assert (name, kind) == ("_tts._tcp.example.internal", "SRV")
+        return [record(10, 1, 9000, "b.example.internal."), record(10, 5, 9000, "a.example.internal."), record(20, 1, 9000, "backup.example.internal.")]
+
+    dns = types.ModuleType("dns")
+    dns.asyncresolver = types.SimpleNamespace(resolve=resolve)
+    monkeypatch.setitem(sys.modules, "dns", dns)
+    monkeypatch.setitem(sys.modules, "dns.asyncresolver", dns.asyncresolver)
+
+    found = asyncio.run(discovery.resolve("srv+https://_tts._tcp.example.internal"))
+    assert found == ("https://a.example.internal:9000", "https://b.example.internal:9000")
+
+
+def test_static_urls_mix_with_discovered_ones(fresh):
+    discovery._found["consul+http://asr/v1/chat/completions"] = ("http://10.0.0.5:8000/v1/chat/completions",)
+    urls = discovery.expand(("http://asr.static/v1/chat/completions", "consul+http://asr/v1/chat/completions"))
+    assert urls == ("http://asr.static/v1/chat/completions", "http://10.0.0.5:8000/v1/chat/completions")
+    with pytest.raises(ValueError):
+        discovery._parse("consul+ftp://asr")
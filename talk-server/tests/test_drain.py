@@ -0,0 +1,87 @@
+"""Tests for the startup probe and draining before shutdown."""
+import asyncio
+
+import httpx
+import pytest
+
+from routers import health as health_router
+from services import drain
+from services.balancer import reset_pools
+
+ADMIN = {"X-Admin-Token": "admin-token"}
+
+
+@pytest.fixture(autouse=True)
+def _drain_file(tmp_path, monkeypatch):
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+    monkeypatch.setattr(drain, "DRAIN_FILE", str(tmp_path / "draining"))
+    yield
+    drain.stop_drain()
+    reset_pools()
+
+
+def test_drain_fails_readiness_until_cancelled(client):
+    assert client.get("/ready").status_code == 200
+    res = client.post("/admin/drain", headers=ADMIN)
+    assert res.status_code == 200
+    assert res.json() == {"draining": True, "idle": True, "in_flight": 0}
+    assert client.get("/ready").status_code == 503
+    assert client.get("/ready").json() == {"status": "draining"}
+    assert client.get("/health").status_code == 200
+
+    assert client.delete("/admin/drain", headers=ADMIN).json() == {"draining": False}
+    assert client.get("/ready").status_code == 200
+
+
+def test_other_workers_see_the_flag_but_not_one_left_by_an_earlier_run(monkeypatch):
+    drain.start_drain()
+    monkeypatch.setattr(drain, "_draining", False)
+    assert drain.draining()
+    with open(drain.DRAIN_FILE, "w", encoding="utf-8") as f:
+        f.write("1")
+    assert not drain.draining()
+
+
+def test_drain_waits_for_requests_in_flight_until_their_reply_is_sent():
+    async def run():
+        release = asyncio.Event()
+
+        async def body():
+            yield b"first"
+            await release.wait()
+            yield b"last"
+
+        async def send():
+            return [chunk async for chunk in drain.tracked_body(body())]
+
+        sending = asyncio.create_task(send())
+        await asyncio.sleep(0)
+        assert drain.in_flight() == 1
+        assert not await drain.wait_idle(0.05, poll=0.01)
+        release.set()
+        assert await drain.wait_idle(1, poll=0.01)
+        return await sending
+
+    assert asyncio.run(run()) == [b"first", b"last"]
+
+
+def test_startup_probe_waits_until_the_backends_answer(client, monkeypatch):
+    reachable = []
+
+    class FakeClient:
+        async def get(self, url, timeout=None):
+            if not reachable:
+                raise httpx.ConnectError("refused")
+            return httpx.Response(200)
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_TTS", "http://tts")
+    monkeypatch.setattr(health_router, "http_client", FakeClient)
+    monkeypatch.setattr(health_router, "_started", False)
+
+    res = client.get("/startup")
+    assert res.status_code == 503
+    assert res.json()["status"] == "starting" and res.json()["checks"]["tts"] == "unreachable: ConnectError"
+    reachable.append(True)
+    assert client.get("/startup").json() == {"status": "ok"}
+    reachable.clear()
+    assert client.get("/startup").status_code == 200
@@ -0,0 +1,84 @@
+"""End-to-end tests with the Indic fixtures in tests/testdata against live backends, for release validation.
+
+Skipped unless run with --e2e (or DWANI_E2E=1). The gateway runs in-process with the DWANI_*
+backend settings of the environment, or DWANI_E2E_BASE_URL names a deployed one to test
+instead (with DWANI_API_KEY, when it needs one):
+
+    DWANI_CHAT_COMPLETIONS_URL=... DWANI_API_BASE_URL_LLM=... DWANI_API_BASE_URL_TTS=... pytest --e2e tests/test_e2e.py
+"""
+import base64
+import io
+import os
+import wave
+
+import httpx
+import pytest
+from fastapi.testclient import TestClient
+
+import main
+from services.audio_format import detect_format
+from testdata import RELEASE_LANGUAGES, character_error_rate, load_samples, script_share
+
+SAMPLES = load_samples()
+
+
+@pytest.fixture(scope="module")
+def gateway():
+    base_url = os.getenv("DWANI_E2E_BASE_URL", "").strip()
+    if not base_url:
+        yield TestClient(main.app)
+        return
+    api_key = os.getenv("DWANI_API_KEY", "")
+    with httpx.Client(base_url=base_url, headers={"X-API-Key": api_key} if api_key else {}, timeout=120) as client:
+        yield client
+
+
+def _upload(sample):
+    return {"file": (sample.name, io.BytesIO(sample.read()), "audio/wav")}
+
+
+def test_fixtures_are_short_16k_mono_wav_with_a_license():
+    assert SAMPLES
+    for sample in SAMPLES:
+        with wave.open(sample.path) as w:
+            assert (w.getnchannels(), w.getframerate(), w.getsampwidth()) == (1, 16000, 2), sample.file
+            assert w.getnframes() / w.getframerate() <= 15, sample.file
+        assert sample.license and sample.source, sample.file
+
+
+@pytest.mark.e2e
+def test_every_release_language_has_a_checked_recording():
+    checked = {sample.language for sample in SAMPLES if sample.transcript}
+    missing = [language for language in RELEASE_LANGUAGES if language not in checked]
+    assert not missing, f"tests/testdata has no recording with a checked transcript in {missing} (see its README)"
+
+
+def test_character_error_rate():
+    assert character_error_rate("ನಮಸ್ಕಾರ", "ನಮಸ್ಕಾರ") == 0
+    assert character_error_rate("नमस्ते जी", "नमस्ते") == pytest.approx(2 / 8)
+    assert script_share("வணக்கம் hello", "tamil") == 0.5
+
+
+@pytest.mark.e2e
+@pytest.mark.parametrize("sample", SAMPLES, ids=[sample.name for sample in SAMPLES])
+def test_transcript_matches_the_golden_one(gateway, sample):
+    res = gateway.post("/v1/audio/transcriptions", data={"language": sample.language}, files=_upload(sample))
+    assert res.status_code == 200, res.text
+    text = res.json()["text"]
+    if sample.transcript is None:
+        assert script_share(text, sample.language) >= 0.8, text
+    else:
+        assert character_error_rate(sample.transcript, text) <= sample.max_cer, text
+
+
+@pytest.mark.e2e
+@pytest.mark.parametrize("sample", SAMPLES, ids=[sample.name for sample in SAMPLES])
+def test_spoken_turn_gets_a_spoken_reply(gateway, sample):
+    res = gateway.post(
+        "/v1/speech_to_speech", params={"language": sample.language, "format": "json"}, files=_upload(sample)
+    )
+    assert res.status_code == 200, res.text
+    data = res.json()
+    assert data["transcription"] and data["llm_response"]
+    audio = base64.b64decode(data["audio_base64"])
+    assert detect_format(data.get("audio_content_type"), audio[:12]) in {"mp3", "wav", "ogg"}
@@ -0,0 +1,48 @@
+"""Tests for machine-readable error codes and localized error messages."""
+from fastapi import HTTPException
+
+from services import pipeline
+from services.deadline import StageTimeout
+from services.error_codes import CATALOG, code_for, localized_message
+from services.errors import RequestError, StageError
+
+
+def test_codes_follow_the_stage_and_status():
+    assert code_for(StageTimeout("asr")) == "asr_timeout"
+    assert code_for(StageError("tts", status_code=503)) == "tts_unavailable"
+    assert code_for(StageError("vision")) == "vision_failed"
+    assert code_for(StageError("asr", detail="ASR service unavailable", error_code="asr_unavailable")) == "asr_unavailable"
+    assert code_for(RequestError(400, "no_speech_detected", "No speech detected in the audio")) == "no_speech_detected"
+    assert code_for(HTTPException(status_code=404)) == "not_found"
+    assert code_for(HTTPException(status_code=422)) == "invalid_request"
+    assert code_for(ValueError("boom")) == "internal_error"
+
+
+def test_every_message_is_in_all_four_languages():
+    for code, messages in CATALOG.items():
+        assert set(messages) == {"english", "kannada", "hindi", "tamil"}, code
+    assert localized_message("vision_timeout", "hindi") == CATALOG["timeout"]["hindi"]
+    assert localized_message("llm_failed", "marathi") == CATALOG["llm_failed"]["english"]
+
+
+def test_pipeline_errors_are_localized_to_the_request_language(client, monkeypatch):
+    async def slow_llm(*args, **kwargs):
+        raise StageTimeout("llm")
+
+    monkeypatch.setattr(pipeline, "call_llm", slow_llm)
+    res = client.post("/v1/speech_to_speech", params={"language": "kn-IN"}, data={"text": "namaskara"})
+    assert res.status_code == 504
+    error = res.json()["error"]
+    assert error["code"] == "504" and error["error_code"] == "llm_timeout"
+    assert error["language"] == "kannada" and error["localized_message"] == CATALOG["llm_timeout"]["kannada"]
+
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada"}, data={"text": "   "})
+    assert res.json()["error"]["error_code"] == "empty_input"
+
+
+def test_accept_language_picks_the_language_until_the_request_names_one(client):
+    error = client.get("/v1/no-such-endpoint", headers={"Accept-Language": "ta-IN,en;q=0.8"}).json()["error"]
+    assert error["error_code"] == "not_found" and error["language"] == "tamil"
+    assert error["localized_message"] == CATALOG["not_found"]["tamil"]
+    error = client.get("/v1/no-such-endpoint", headers={"Accept-Language": "fr-FR"}).json()["error"]
+    assert error["language"] == "english"
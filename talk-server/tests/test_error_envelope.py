@@ -0,0 +1,57 @@
+import io
+
+from fastapi.testclient import TestClient
+
+import main
+from routers import chat as chat_router
+from services import transcribe as transcribe_svc
+
+# Unhandled exceptions must come back as a response, not be re-raised into the test.
+client = TestClient(main.app, raise_server_exceptions=False)
+
+
+def test_unhandled_exception_becomes_500_envelope(monkeypatch):
+    async def broken_llm(*args, **kwargs):
+        raise RuntimeError("connection to 10.0.0.7:8000 refused")
+
+    monkeypatch.setattr(chat_router, "call_llm", broken_llm)
+    res = client.post("/v1/chat", json={"text": "hello"}, headers={"X-Request-ID": "req-1"})
+    assert res.status_code == 500
+    body = res.json()
+    assert body["error"]["message"] == "Internal server error"
+    assert body["error"]["request_id"] == "req-1"
+    assert res.headers["X-Request-ID"] == "req-1"
+    assert "10.0.0.7" not in res.text
+
+
+def test_upstream_error_body_is_not_forwarded(monkeypatch):
+    class UpstreamFailure:
+        status_code = 500
+        text = "Traceback (most recent call last): /opt/models/gemma4/serve.py"
+
+    class FakeClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            return UpstreamFailure()
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeClient)
+    res = client.post("/v1/speech_to_speech", files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")})
+    assert res.status_code == 502
+    error = res.json()["error"]
+    assert error["stage"] == "asr"
+    assert error["code"] == "502"
+    assert "Traceback" not in res.text and "/opt/models" not in res.text
+
+
+def test_validation_errors_use_the_envelope():
+    res = client.post("/v1/chat", json={"mode": "llm"})
+    assert res.status_code == 422
+    body = res.json()
+    assert body["detail"] == "Request validation failed"
+    assert body["error"]["details"]["errors"][0]["loc"] == ["body", "text"]
+
+
+def test_unknown_path_uses_the_envelope():
+    res = client.get("/v1/does-not-exist")
+    assert res.status_code == 404
+    assert res.json()["error"]["code"] == "404"
+    assert res.json()["error"]["stage"] is None
@@ -0,0 +1,51 @@
+import asyncio
+
+from services import events
+
+
+def test_cloud_event_has_required_attributes():
+    event = events.cloud_event("job.succeeded", "jobs/123", {"id": "123"})
+    assert event["specversion"] == "1.0"
+    assert event["type"] == "ai.dwani.talk.job.succeeded"
+    assert event["subject"] == "jobs/123"
+    assert event["source"] == events.EVENT_SOURCE
+    assert event["time"].endswith("Z")
+    assert event["data"] == {"id": "123"}
+
+
+def _capture_post(monkeypatch):
+    sent = {}
+
+    class FakeResponse:
+        status_code = 202
+
+    class FakeAsyncClient:
+        def __init__(self, pinned=False):
+            pass
+
+        async def post(self, url, json=None, headers=None, timeout=None):
+            sent.update(url=url, json=json, headers=headers)
+            return FakeResponse()
+
+    monkeypatch.setattr(events, "webhook_client", FakeAsyncClient)
+    return sent
+
+
+def test_structured_mode_posts_envelope(monkeypatch):
+    sent = _capture_post(monkeypatch)
+    monkeypatch.setattr(events, "CLOUDEVENTS_MODE", "structured")
+    event = events.cloud_event("job.failed", "jobs/1", {"id": "1"})
+    assert asyncio.run(events.deliver_webhook("http://hook", event))
+    assert sent["headers"]["Content-Type"] == "application/cloudevents+json"
+    assert sent["json"]["type"] == "ai.dwani.talk.job.failed"
+
+
+def test_binary_mode_uses_ce_headers(monkeypatch):
+    sent = _capture_post(monkeypatch)
+    monkeypatch.setattr(events, "CLOUDEVENTS_MODE", "binary")
+    event = events.cloud_event("job.succeeded", "jobs/1", {"id": "1"})
+    assert asyncio.run(events.deliver_webhook("http://hook", event, request_id="r-1"))
+    assert sent["headers"]["ce-type"] == "ai.dwani.talk.job.succeeded"
+    assert sent["headers"]["ce-specversion"] == "1.0"
+    assert sent["headers"]["X-Request-ID"] == "r-1"
+    assert sent["json"] == {"id": "1"}
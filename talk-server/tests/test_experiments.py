@@ -0,0 +1,71 @@
+"""Tests for tagging turns, exports and webhook events with their experiment and backends."""
+import contextvars
+from types import SimpleNamespace
+
+from routers import chat as chat_router
+from services import chat_svc, events, experiments, pipeline
+from services.experiments import current_tags, start_tags, tag_llm, tag_turn
+from services.tts import TTSStream
+
+
+def _isolated(fn):
+    return contextvars.copy_context().run(fn)
+
+
+def test_tags_default_to_the_deployment_and_drop_odd_values(monkeypatch):
+    monkeypatch.setattr(experiments, "EXPERIMENT", "canary")
+
+    def run():
+        start_tags(variant="b")
+        tag_llm("http://llm.internal:8000/v1", "meta-llama/Llama-3.1-8B")
+        tag_turn(voice="bad voice name")
+        return current_tags()
+
+    assert _isolated(run) == {
+        "experiment": "canary", "variant": "b", "provider": "llm.internal", "model": "meta-llama/Llama-3.1-8B", "voice": None,
+    }
+    assert _isolated(current_tags) == dict.fromkeys(experiments.TAGS)
+
+
+def test_webhook_events_carry_the_tags_as_extensions():
+    def run():
+        start_tags("onboarding", "short-prompt")
+        tag_turn(voice="kn_female")
+        return events.cloud_event("job.succeeded", "jobs/1", {"id": "1"})
+
+    event = _isolated(run)
+    assert (event["experiment"], event["variant"], event["voice"]) == ("onboarding", "short-prompt", "kn_female")
+    assert "model" not in event and "model" not in _isolated(lambda: events.cloud_event("x", "y", {}))
+
+
+def test_stored_turn_is_tagged_with_the_request_experiment_and_backends(client, monkeypatch):
+    stored = []
+
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            return SimpleNamespace(choices=[SimpleNamespace(message=SimpleNamespace(content="namaskara"))])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, base_url=None, **kwargs):
+            self.chat = SimpleNamespace(completions=FakeCompletions())
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm.internal")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", FakeAsyncOpenAI)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(pipeline, "STORE_CONVERSATIONS", True)
+    monkeypatch.setattr(pipeline, "record_turn", lambda **turn: stored.append(turn))
+    monkeypatch.setattr("services.tts.TTS_VOICES", {"kannada": "kn_female"})
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"language": "kannada"},
+        data={"text": "hello"},
+        headers={"X-Experiment": "greeting-test", "X-Experiment-Variant": "b"},
+    )
+    assert res.status_code == 200
+    turn = stored[0]
+    assert (turn["experiment"], turn["variant"], turn["provider"], turn["voice"]) == ("greeting-test", "b", "llm.internal", "kn_female")
+    assert turn["model"]
@@ -0,0 +1,78 @@
+"""Tests for reusing what was made of a duplicate upload (services/fingerprint.py)."""
+import asyncio
+import io
+
+from fastapi import UploadFile
+
+from routers import chat as chat_router
+from services import fingerprint, pipeline
+from services import transcribe as transcribe_svc
+from services.tts import TTSStream
+
+
+def _cache(monkeypatch, ttl=60):
+    cache = fingerprint.TranscriptCache(ttl_seconds=ttl, max_entries=8)
+    monkeypatch.setattr(transcribe_svc, "transcript_cache", cache)
+    return cache
+
+
+def _asr(monkeypatch):
+    calls = []
+
+    async def recognize(file, size, language, request_id, diarize):
+        calls.append(language)
+        return transcribe_svc._Recognized(f"heard {file.file.read().decode()}", language, None, 0.9)
+
+    monkeypatch.setattr(transcribe_svc, "_recognize", recognize)
+    return calls
+
+
+def _transcribe(audio, language="kannada"):
+    upload = UploadFile(file=io.BytesIO(audio), size=len(audio), filename="a.wav")
+    return asyncio.run(transcribe_svc.transcribe_audio(upload, language=language))
+
+
+def test_a_duplicate_clip_reuses_its_transcript(monkeypatch):
+    calls = _asr(monkeypatch)
+    cache = _cache(monkeypatch)
+    assert _transcribe(b"clip").text == "heard clip"
+    again = _transcribe(b"clip")
+    assert (again.text, again.confidence) == ("heard clip", 0.9)
+    assert calls == ["kannada"]
+    _transcribe(b"clip", language="hindi")
+    _transcribe(b"other clip")
+    assert calls == ["kannada", "hindi", "kannada"] and len(cache) == 3
+
+
+def test_without_a_ttl_every_upload_is_transcribed(monkeypatch):
+    calls = _asr(monkeypatch)
+    _cache(monkeypatch, ttl=0)
+    _transcribe(b"clip")
+    _transcribe(b"clip")
+    assert len(calls) == 2
+
+
+def test_full_results_are_reused_when_enabled(client, monkeypatch):
+    replies = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        replies.append(user_text)
+        return f"reply {len(replies)}"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", text.encode())
+
+    _asr(monkeypatch)
+    _cache(monkeypatch)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(chat_router, "results_enabled", lambda: True)
+    monkeypatch.setattr(fingerprint, "result_cache", fingerprint.IdempotencyCache(ttl_seconds=60))
+
+    def post():
+        return client.post("/v1/speech_to_speech", files={"file": ("a.wav", io.BytesIO(b"clip"), "audio/wav")})
+
+    first, second = post(), post()
+    assert first.content == second.content == b"reply 1"
+    assert second.headers["Idempotent-Replayed"] == "true"
+    assert replies == ["heard clip"]
@@ -0,0 +1,55 @@
+"""Tests for feature flags and their per-key and per-tenant overrides (services/flags.py)."""
+import json
+
+import pytest
+
+from services import flags
+
+
+def test_rollouts_parse_on_off_and_percentages():
+    assert flags.parse_rollout("reply_segments=off, translation=25%,moderation=on") == {
+        "reply_segments": 0.0, "translation": 25.0, "moderation": 100.0,
+    }
+    assert flags.parse_rollout("") == {}
+    for spec in ("telepathy=on", "translation=sometimes", "translation=150%"):
+        with pytest.raises(ValueError):
+            flags.parse_rollout(spec)
+
+
+def test_a_key_beats_its_tenant_which_beats_the_rollout():
+    ff = flags.FeatureFlags(
+        {"translation": 0.0},
+        api_keys={"beta-key": {"translation": True}},
+        tenants={"Acme": {"translation": True, "moderation": False}},
+    )
+    assert not ff.enabled("translation", "some-key")
+    assert ff.enabled("translation", "some-key", tenant="acme")
+    assert ff.enabled("translation", "beta-key")
+    assert not ff.enabled("moderation", "beta-key", tenant="acme")
+    assert ff.enabled("moderation", "beta-key") and ff.enabled("streaming_replies", None)
+
+
+def test_a_percentage_picks_the_same_keys_every_time():
+    ff = flags.FeatureFlags({"reply_segments": 30.0})
+    keys = [f"key-{n}" for n in range(1000)]
+    on = [key for key in keys if ff.enabled("reply_segments", key)]
+    assert 230 < len(on) < 370
+    assert on == [key for key in keys if ff.enabled("reply_segments", key)]
+    assert not ff.enabled("reply_segments", None)
+
+
+def test_override_files_are_validated(tmp_path):
+    path = tmp_path / "flags.json"
+    path.write_text(json.dumps({"api_keys": {"k": {"reply_segments": True}}}))
+    assert flags.FeatureFlags.load("", str(path)).enabled("reply_segments", "k")
+    for bad in ({"keys": {}}, {"api_keys": {"k": {"telepathy": True}}}, {"tenants": {"acme": {"translation": "yes"}}}):
+        path.write_text(json.dumps(bad))
+        with pytest.raises(ValueError):
+            flags.FeatureFlags.load("", str(path))
+
+
+def test_a_disabled_feature_is_refused_for_the_request(client, monkeypatch):
+    monkeypatch.setattr(flags, "flags", flags.FeatureFlags({"reply_segments": 0.0}))
+    res = client.post("/v1/speech_to_speech?format=segments", data={"text": "hello"})
+    assert res.status_code == 403
+    assert res.json()["error"]["error_code"] == "feature_disabled"
@@ -0,0 +1,132 @@
+import io
+import json
+from urllib.parse import unquote
+
+import pytest
+from fastapi import HTTPException
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import grammar as grammar_svc
+from services import pipeline
+from services.grammar import grammar_from_spec, grammars, load_grammars, resolve_grammar
+from services.tts import TTSStream
+
+_CITY = {"name": "city", "choices": {"Bengaluru": ["bangalore", "ಬೆಂಗಳೂರು"], "Mysuru": ["mysore"]}}
+
+
+@pytest.mark.parametrize("said, value", [
+    ("Yes.", "yes"),
+    ("yes please", "yes"),
+    ("ಹೌದು", "yes"),
+    ("हाँ जी", "yes"),
+    ("Nope!", "no"),
+    ("yes no", None),
+    ("maybe later", None),
+])
+def test_yes_no(said, value):
+    assert grammars["yes_no"].match(said) == value
+
+
+@pytest.mark.parametrize("said, value", [
+    ("one two three four", "1234"),
+    ("my pin is 98 76", "9876"),
+    ("double five six", "556"),
+    ("ಒಂದು ಎರಡು ಮೂರು", "123"),
+    ("एक दो", "12"),
+    ("१२३", "123"),
+    ("I don't remember", None),
+])
+def test_digits(said, value):
+    assert grammars["digits"].match(said) == value
+
+
+def test_choice_synonyms_and_close_spellings_normalize_to_the_value():
+    city = grammar_from_spec(_CITY)
+    assert city.match("Bangalore") == "Bengaluru"
+    assert city.match("I want to go to mysore") == "Mysuru"
+    assert city.match("Bengaluroo") == "Bengaluru"
+    match, reply = city.respond("Delhi")
+    assert not match.matched
+    assert reply == "Sorry, I didn't catch that. Please say Bengaluru or Mysuru."
+
+
+def test_digit_length_and_localized_reprompt():
+    pin = grammar_from_spec({"type": "digits", "min_digits": 4, "max_digits": 4,
+                             "reprompt": {"english": "Four digits please.", "hindi": "चार अंक बताइए।"}})
+    assert pin.match("one two") is None
+    assert pin.respond("one two", "hindi")[1] == "चार अंक बताइए।"
+    assert pin.respond("one two", "kannada")[1] == "Four digits please."
+
+
+def test_resolve_rejects_unknown_and_malformed_grammars():
+    assert resolve_grammar(None) is None
+    assert resolve_grammar("yes_no").name == "yes_no"
+    with pytest.raises(HTTPException) as exc:
+        resolve_grammar("colours")
+    assert exc.value.status_code == 400
+    with pytest.raises(HTTPException):
+        resolve_grammar('{"choices": []}')
+    with pytest.raises(HTTPException):
+        resolve_grammar('{"type": "digits", "min_digits": 5, "max_digits": 2}')
+
+
+def test_grammars_file_adds_named_grammars(tmp_path):
+    path = tmp_path / "grammars.json"
+    path.write_text(json.dumps({"city": _CITY}), encoding="utf-8")
+    loaded = load_grammars(str(path))
+    assert set(loaded) == {"yes_no", "digits", "city"}
+    assert loaded["city"].match("mysore") == "Mysuru"
+
+
+def _no_llm(*args, **kwargs):
+    raise AssertionError("the LLM must not run for a constrained prompt")
+
+
+def test_constrained_turn_confirms_without_the_llm(client, monkeypatch):
+    spoken = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="It's Bangalore")
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", _no_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    monkeypatch.setitem(grammar_svc.grammars, "city", grammar_from_spec({**_CITY, "confirm": "{value}, got it."}))
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"grammar": "city"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 200
+    assert res.headers["X-Grammar-Match"] == "true"
+    assert unquote(res.headers["X-Grammar-Value"]) == "Bengaluru"
+    assert spoken == ["Bengaluru, got it."]
+
+
+def test_mismatch_reprompts_and_reports_in_json(client, monkeypatch):
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "call_llm", _no_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"grammar": "yes_no", "format": "json"},
+        data={"text": "what time is it"},
+    )
+    assert res.status_code == 200
+    body = res.json()
+    assert body["grammar"] == {"name": "yes_no", "matched": False, "value": None}
+    assert body["llm_response"] == "Sorry, I didn't catch that. Please say yes or no."
+
+
+def test_unknown_grammar_is_a_400(client):
+    res = client.post("/v1/speech_to_speech", params={"grammar": "colours"}, data={"text": "red"})
+    assert res.status_code == 400
@@ -1,6 +1,7 @@
 from fastapi.testclient import TestClient
 
 from main import app
+from services import build_info
 
 
 client = TestClient(app)
@@ -11,3 +12,11 @@ def test_health():
     assert response.status_code == 200
     assert response.json().get("status") == "ok"
 
+
+def test_version_reports_the_build(monkeypatch):
+    monkeypatch.setenv("DWANI_BUILD_DATE", "2026-10-14T09:00:00Z")
+    monkeypatch.setattr(build_info, "commit", lambda: "abc123")
+    monkeypatch.setattr(build_info, "MOCK_BACKENDS", True)
+    body = client.get("/version").json()
+    assert (body["version"], body["commit"], body["build_date"]) == (build_info.VERSION, "abc123", "2026-10-14T09:00:00Z")
+    assert "mock_backends" in body["features"] and body["python"]
@@ -0,0 +1,156 @@
+import asyncio
+import sys
+import types
+
+import pytest
+from fastapi import HTTPException
+
+from services import pipeline as pipeline_svc
+from services import tts as tts_svc
+from services.hooks import HookContext, PipelineHooks, hooks, load_plugins
+from services.pipeline import Pipeline, PipelineConfig
+from services.tts_cache import TTSCache
+
+
+@pytest.fixture(autouse=True)
+def _clear_hooks():
+    hooks.clear()
+    yield
+    hooks.clear()
+
+
+def _fake_llm(seen):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append(user_text)
+        return f"reply to {user_text}"
+
+    return fake_call_llm
+
+
+def test_llm_hooks_rewrite_prompt_and_reply(monkeypatch):
+    seen = []
+    monkeypatch.setattr(pipeline_svc, "call_llm", _fake_llm(seen))
+    hooks.register("before_llm", lambda ctx, text: text.lower())
+
+    @hooks.on("after_llm")
+    async def shout(ctx, reply):
+        ctx.metadata["language"] = ctx.language
+        return reply.upper()
+
+    turn = asyncio.run(pipeline_svc.converse(text="  NAMASKARA ", language="kannada"))
+    assert seen == ["namaskara"]
+    assert turn.transcription == "namaskara"
+    assert turn.reply == "REPLY TO NAMASKARA"
+
+
+def test_before_llm_can_answer_or_route_the_turn(monkeypatch):
+    seen = []
+    monkeypatch.setattr(pipeline_svc, "call_llm", _fake_llm(seen))
+    agent_calls = []
+
+    async def fake_call_agent(agent_name, text, session_id=None, request_id=None):
+        agent_calls.append(agent_name)
+        return {"reply": "the agent answered"}
+
+    monkeypatch.setattr(pipeline_svc, "call_agent", fake_call_agent)
+
+    def route(ctx, text):
+        if text == "hours?":
+            ctx.reply = "We are open nine to five."
+        elif "chess" in text:
+            ctx.mode, ctx.agent_name = "agent", "chess_orchestrator"
+
+    hooks.register("before_llm", route)
+    assert asyncio.run(pipeline_svc.converse(text="hours?")).reply == "We are open nine to five."
+    assert asyncio.run(pipeline_svc.converse(text="play chess")).reply == "the agent answered"
+    assert seen == [] and agent_calls == ["chess_orchestrator"]
+
+
+def test_hook_http_exception_stops_the_turn(monkeypatch):
+    seen = []
+    monkeypatch.setattr(pipeline_svc, "call_llm", _fake_llm(seen))
+
+    def moderate(ctx, text):
+        if "forbidden" in text:
+            raise HTTPException(status_code=400, detail="Input not allowed")
+
+    hooks.register("before_llm", moderate)
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(pipeline_svc.converse(text="something forbidden"))
+    assert exc.value.status_code == 400
+    assert seen == []
+
+
+class _FakeResponse:
+    status_code = 200
+    headers = {"Content-Type": "audio/mpeg"}
+
+    def raise_for_status(self):
+        pass
+
+    async def aiter_bytes(self):
+        yield b"ID3audio"
+
+    async def aclose(self):
+        pass
+
+
+def test_tts_hooks_see_the_synthesized_text(monkeypatch):
+    sent = []
+
+    class _FakeClient:
+        def build_request(self, method, url, **kwargs):
+            sent.append(kwargs["json"]["text"])
+
+        async def send(self, request, stream=False):
+            return _FakeResponse()
+
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: _FakeClient())
+    hooks.register("before_tts", lambda ctx, text: text.replace("Rs.", "rupees"))
+    streams = []
+    hooks.register("after_tts", lambda ctx, stream: streams.append((ctx.request_id, stream)))
+
+    async def speak():
+        stream = await tts_svc.stream_tts("It costs Rs. 20", request_id="req-1", language="en")
+        return await stream.read()
+
+    assert asyncio.run(speak()) == b"ID3audio"
+    assert sent == ["It costs rupees 20"]
+    assert [request_id for request_id, _ in streams] == ["req-1"]
+
+
+def test_embedded_pipeline_uses_its_own_hooks(monkeypatch):
+    seen = []
+    monkeypatch.setattr(pipeline_svc, "call_llm", _fake_llm(seen))
+    hooks.register("after_llm", lambda ctx, reply: "server-wide")
+    own = PipelineHooks()
+    own.register("after_llm", lambda ctx, reply: "embedded")
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return tts_svc.TTSStream.cached("audio/mpeg", text.encode())
+
+    monkeypatch.setattr(pipeline_svc, "stream_tts", fake_stream_tts)
+    result = asyncio.run(Pipeline(PipelineConfig(), hooks=own).speech_to_speech(text="hi"))
+    assert result.reply == "embedded"
+    assert result.audio == b"embedded"
+
+
+def test_unknown_hook_point_is_rejected():
+    with pytest.raises(ValueError):
+        hooks.register("before_everything", lambda ctx, value: value)
+
+
+def test_plugins_register_from_module_spec(monkeypatch):
+    plugin = types.ModuleType("talk_test_plugin")
+
+    def setup(registry):
+        registry.register("after_asr", lambda ctx, text: text.strip("."))
+
+    plugin.setup = setup
+    monkeypatch.setitem(sys.modules, "talk_test_plugin", plugin)
+    registry = PipelineHooks()
+    assert load_plugins("talk_test_plugin:setup, ", registry) == 1
+    assert asyncio.run(registry.run("after_asr", HookContext(), "hello.")) == "hello"
+    with pytest.raises(ImportError):
+        load_plugins("talk_missing_plugin:setup", PipelineHooks())
@@ -0,0 +1,28 @@
+import asyncio
+
+from services import http_client as http_client_svc
+
+
+def test_http_client_is_shared_until_closed():
+    first = http_client_svc.http_client()
+    assert http_client_svc.http_client() is first
+    asyncio.run(http_client_svc.close_http_client())
+    assert first.is_closed
+    second = http_client_svc.http_client()
+    assert second is not first
+    asyncio.run(http_client_svc.close_http_client())
+
+
+def test_default_tls_verification_without_backend_certs():
+    assert http_client_svc._tls_verify() is True
+
+
+def test_backend_ca_builds_ssl_context(monkeypatch):
+    import ssl
+
+    import certifi
+
+    monkeypatch.setattr(http_client_svc, "BACKEND_CA_FILE", certifi.where())
+    context = http_client_svc._tls_verify()
+    assert isinstance(context, ssl.SSLContext)
+    assert context.verify_mode == ssl.CERT_REQUIRED
@@ -0,0 +1,98 @@
+import asyncio
+import io
+
+import pytest
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import pipeline
+from services.idempotency import IdempotencyCache, idempotency_cache
+from services.tts import TTSStream
+
+
+@pytest.fixture
+def pipeline_calls(monkeypatch):
+    calls = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        calls.append("asr")
+        return TranscriptionResponse(text="hello")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        calls.append("llm")
+        return "hi there"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        calls.append("tts")
+        return TTSStream.cached("audio/mpeg", b"ID3" + bytes(len(calls)))
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    idempotency_cache.clear()
+    yield calls
+    idempotency_cache.clear()
+
+
+def _post(client, key=None, audio=b"audio", params=None):
+    return client.post(
+        "/v1/speech_to_speech",
+        params={"language": "kannada", **(params or {})},
+        files={"file": ("a.wav", io.BytesIO(audio), "audio/wav")},
+        headers={"Idempotency-Key": key} if key else {},
+    )
+
+
+def test_retry_with_same_key_replays_the_response(client, pipeline_calls):
+    first = _post(client, "turn-1")
+    retry = _post(client, "turn-1")
+    assert first.status_code == retry.status_code == 200
+    assert first.headers["Idempotent-Replayed"] == "false"
+    assert retry.headers["Idempotent-Replayed"] == "true"
+    assert retry.content == first.content
+    assert retry.headers["content-type"] == first.headers["content-type"]
+    assert pipeline_calls == ["asr", "llm", "tts"]
+
+
+def test_requests_without_a_key_run_every_time(client, pipeline_calls):
+    _post(client)
+    res = _post(client)
+    assert "Idempotent-Replayed" not in res.headers
+    assert pipeline_calls.count("asr") == 2
+
+
+def test_key_reused_for_a_different_request_is_rejected(client, pipeline_calls):
+    assert _post(client, "turn-2").status_code == 200
+    assert _post(client, "turn-2", audio=b"other audio").status_code == 422
+    assert _post(client, "turn-2", params={"format": "json"}).status_code == 422
+    assert pipeline_calls.count("asr") == 1
+
+
+def test_failed_requests_are_not_kept(client, pipeline_calls, monkeypatch):
+    async def failing_tts(text, request_id=None, language=None, audio_format=None):
+        from services.errors import StageError
+        raise StageError("tts", detail="TTS service unavailable")
+
+    monkeypatch.setattr(chat_router, "stream_tts", failing_tts)
+    assert _post(client, "turn-3").status_code == 502
+    assert len(idempotency_cache) == 0
+
+
+def test_concurrent_retry_waits_for_the_first_request():
+    from fastapi.responses import Response
+
+    cache = IdempotencyCache(ttl_seconds=60, max_entries=10)
+    runs = []
+
+    async def respond():
+        runs.append(1)
+        await asyncio.sleep(0.05)
+        return Response(b"reply", media_type="audio/mpeg")
+
+    async def main():
+        return await asyncio.gather(cache.run("k", "f", respond), cache.run("k", "f", respond))
+
+    first, second = asyncio.run(main())
+    assert runs == [1]
+    assert first.body == second.body == b"reply"
+    assert second.headers["Idempotent-Replayed"] == "true"
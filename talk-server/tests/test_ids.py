@@ -0,0 +1,44 @@
+import logging
+import time
+import uuid
+
+from services import events, ids
+
+
+def test_id_formats(monkeypatch):
+    assert uuid.UUID(ids.new_id(id_format="uuid4")).version == 4
+    first = ids.new_id(id_format="uuid7")
+    assert uuid.UUID(first).version == 7
+    time.sleep(0.002)
+    assert ids.new_id(id_format="uuid7") > first
+    ulid = ids.new_id(id_format="ulid")
+    assert len(ulid) == 26 and set(ulid) <= set(ids._CROCKFORD)
+    monkeypatch.setitem(ids.ID_PREFIXES, "job", "job_")
+    assert ids.new_id("job").startswith("job_") and not ids.new_id("request").startswith("job_")
+
+
+def test_client_correlation_ids():
+    assert ids.client_request_id({"X-Correlation-ID": "trace-42"}) == "trace-42"
+    assert ids.client_request_id({"X-Request-ID": "a", "X-Correlation-ID": "b"}) == "a"
+    assert ids.client_request_id({"X-Request-ID": "bad id\nforged log line"}) is None
+    assert ids.client_request_id({"X-Request-ID": "x" * 129}) is None
+
+
+def test_request_id_reaches_logs_and_events():
+    record = logging.LogRecord("test", logging.INFO, __file__, 1, "hello", None, None)
+    token = ids.bind_request_id("req-7")
+    try:
+        ids.RequestIDFilter().filter(record)
+        event = events.cloud_event("intent.emitted", "s", {})
+    finally:
+        ids.reset_request_id(token)
+    assert record.request_id == "req-7"
+    assert event["requestid"] == "req-7"
+    assert "requestid" not in events.cloud_event("intent.emitted", "s", {})
+
+
+def test_correlation_id_is_echoed_or_replaced(client):
+    res = client.get("/health", headers={"X-Correlation-ID": "trace-42"})
+    assert res.headers["X-Request-ID"] == "trace-42"
+    res = client.get("/health", headers={"X-Request-ID": "not a valid id"})
+    assert res.headers["X-Request-ID"] != "not a valid id" and uuid.UUID(res.headers["X-Request-ID"])
@@ -0,0 +1,94 @@
+import asyncio
+import base64
+import io
+
+import httpx
+from fastapi import UploadFile
+
+from routers import chat as chat_router
+from services import backends, vision as vision_svc
+from services.tts import TTSStream
+
+_PNG = b"\x89PNG\r\n\x1a\n" + b"\x00" * 64
+
+
+class _FakeClient:
+    def __init__(self, status=200, content="Electricity bill. Amount due 1,250 rupees by 5 March."):
+        self.status = status
+        self.content = content
+        self.payloads = []
+
+    async def post(self, url, json=None, headers=None, timeout=None):
+        self.payloads.append(json)
+        body = {"choices": [{"message": {"content": self.content}}]}
+        return httpx.Response(self.status, json=body, request=httpx.Request("POST", url))
+
+
+def _upload(data: bytes) -> UploadFile:
+    return UploadFile(io.BytesIO(data), filename="photo.png")
+
+
+def test_image_type_is_detected_from_magic_bytes():
+    assert vision_svc.image_mime(_PNG) == "image/png"
+    assert vision_svc.image_mime(b"\xff\xd8\xff\xe0rest") == "image/jpeg"
+    assert vision_svc.image_mime(b"RIFF\x00\x00\x00\x00WEBPVP8 ") == "image/webp"
+    assert vision_svc.image_mime(b"%PDF-1.7") is None
+
+
+def test_summary_mode_asks_for_the_requested_language(monkeypatch):
+    fake = _FakeClient()
+    monkeypatch.setattr(vision_svc, "http_client", lambda: fake)
+    monkeypatch.setenv("DWANI_VISION_URL", "http://vision/v1/chat/completions")
+    text = asyncio.run(vision_svc.read_image(_upload(_PNG), mode="summary", language="kannada"))
+    assert text.startswith("Electricity bill")
+    content = fake.payloads[0]["messages"][0]["content"]
+    assert content[0]["image_url"]["url"].startswith("data:image/png;base64,")
+    assert "Kannada" in content[1]["text"]
+
+
+def test_image_to_speech_returns_text_and_audio(client, monkeypatch):
+    async def fake_read_image(file, mode="ocr", language=None, request_id=None):
+        assert mode == "summary" and language == "hindi"
+        return "Bijli ka bill."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        assert text == "Bijli ka bill."
+        return TTSStream.cached("audio/mpeg", b"ID3speech")
+
+    monkeypatch.setattr(chat_router, "read_image", fake_read_image)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    res = client.post(
+        "/v1/image_to_speech",
+        params={"mode": "summary", "language": "hindi", "format": "json"},
+        files={"file": ("bill.png", io.BytesIO(_PNG), "image/png")},
+    )
+    assert res.status_code == 200
+    body = res.json()
+    assert body["text"] == "Bijli ka bill."
+    assert base64.b64decode(body["audio_base64"]) == b"ID3speech"
+
+
+def test_long_text_is_cut_to_the_tts_budget(client, monkeypatch):
+    async def fake_read_image(file, mode="ocr", language=None, request_id=None):
+        return "Electricity bill. Amount due 1,250 rupees by 5 March."
+
+    spoken = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", b"ID3speech")
+
+    monkeypatch.setattr(backends, "TURN_MAX_TTS_CHARS", 30)
+    monkeypatch.setattr(chat_router, "read_image", fake_read_image)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    res = client.post("/v1/image_to_speech", files={"file": ("bill.png", io.BytesIO(_PNG), "image/png")})
+    assert res.headers["X-Budget-Hit"] == "tts_chars"
+    res = client.post("/v1/image_to_speech?format=json", files={"file": ("bill.png", io.BytesIO(_PNG), "image/png")})
+    assert res.json()["budget_hits"] == ["tts_chars"] and res.json()["text"].endswith("5 March.")
+    assert spoken == ["Electricity bill."] * 2
+
+
+def test_non_image_upload_is_rejected(client):
+    res = client.post("/v1/image_to_speech", files={"file": ("doc.pdf", io.BytesIO(b"%PDF-1.7"), "application/pdf")})
+    assert res.status_code == 400
+    assert "JPEG" in res.json()["detail"]
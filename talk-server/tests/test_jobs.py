@@ -0,0 +1,86 @@
+"""Tests for the asynchronous /v1/jobs speech-to-speech API."""
+import asyncio
+import io
+import time
+
+import httpx
+from fastapi.testclient import TestClient
+
+import main
+from services import http_client
+from services import jobs as jobs_svc
+from services import tenants
+from services.pipeline import TurnResult
+
+
+def test_job_runs_in_background_and_can_be_polled(monkeypatch):
+    async def fake_converse(file, mode="llm", agent_name=None, session_id=None, request_id=None, language=None, persona=None):
+        assert (await file.read()) == b"audio"
+        return TurnResult(transcription="hello", reply="hi there")
+
+    class FakeTtsStream:
+        async def read(self):
+            return b"mp3"
+
+    async def fake_stream_tts(text, request_id=None, language=None):
+        return FakeTtsStream()
+
+    monkeypatch.setattr(jobs_svc, "converse", fake_converse)
+    monkeypatch.setattr(jobs_svc, "stream_tts", fake_stream_tts)
+
+    with TestClient(main.app) as client:
+        res = client.post(
+            "/v1/jobs/speech_to_speech",
+            files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+        )
+        assert res.status_code == 202
+        job_id = res.json()["job_id"]
+
+        body = {}
+        for _ in range(50):
+            body = client.get(f"/v1/jobs/{job_id}").json()
+            if body["status"] in {"succeeded", "failed"}:
+                break
+            time.sleep(0.05)
+        assert body["status"] == "succeeded"
+        assert body["result"]["llm_response"] == "hi there"
+        assert body["result"]["audio_base64"] == "bXAz"
+
+
+def test_unknown_job_returns_404(client: TestClient):
+    res = client.get("/v1/jobs/does-not-exist")
+    assert res.status_code == 404
+
+
+def test_webhooks_to_private_hosts_are_refused(client: TestClient, monkeypatch):
+    res = client.post(
+        "/v1/jobs/speech_to_speech",
+        params={"webhook_url": "http://127.0.0.1:9000/hook"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 400
+
+    # Deliveries go through the pinned client, which checks the host as it connects.
+    delivered = []
+    network = httpx.MockTransport(lambda request: delivered.append(str(request.url)) or httpx.Response(202))
+    monkeypatch.setattr(http_client.httpx, "AsyncHTTPTransport", lambda **kwargs: network)
+    monkeypatch.setattr(http_client, "_webhook_clients", {})
+    job = jobs_svc.Job(id="job-1", status="succeeded", webhook_url="http://169.254.169.254/latest/meta-data")
+    asyncio.run(jobs_svc.job_manager._notify(job))
+    assert delivered == []
+    monkeypatch.setattr(http_client, "WEBHOOK_ALLOW_PRIVATE", True)
+    monkeypatch.setattr(http_client, "_webhook_clients", {})
+    asyncio.run(jobs_svc.job_manager._notify(job))
+    assert delivered == [job.webhook_url]
+
+
+def test_jobs_are_only_visible_to_the_tenant_that_submitted_them(client: TestClient, monkeypatch):
+    monkeypatch.setitem(tenants.tenants, "acme", tenants.Tenant("acme", api_keys=("acme-key",)))
+    for job_id, tenant in (("job-acme", "acme"), ("job-own", None), ("job-branded", "branded-host")):
+        jobs_svc.job_manager._save(jobs_svc.Job(id=job_id, status="succeeded", tenant=tenant))
+    acme = {"X-API-Key": "acme-key"}
+    assert client.get("/v1/jobs/job-acme", headers=acme).status_code == 200
+    assert client.get("/v1/jobs/job-acme").status_code == 404
+    assert client.get("/v1/jobs/job-own", headers=acme).status_code == 404
+    assert client.get("/v1/jobs/job-own").status_code == 200
+    assert client.get("/v1/jobs/job-branded").status_code == 200
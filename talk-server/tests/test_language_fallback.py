@@ -0,0 +1,90 @@
+"""Tests for retrying low-confidence transcripts in neighbor languages."""
+import asyncio
+import io
+import json
+
+from fastapi import UploadFile
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import pipeline
+from services import transcribe as transcribe_svc
+from services.tts import TTSStream
+
+_TRANSCRIPTS = {"Kannada": ("ನಮಸ್ಕಾರ", 0.2), "Telugu": ("నమస్కారం", 0.9), "Tamil": ("வணக்கம்", 0.4)}
+
+
+class FakeResponse:
+    status_code = 200
+
+    def __init__(self, body):
+        self.body = body
+
+    def json(self):
+        return self.body
+
+
+class FakeAsyncClient:
+    prompts = []
+
+    async def post(self, url, headers=None, content=None, timeout=None):
+        payload = json.loads(b"".join([chunk async for chunk in content]))
+        prompt = payload["messages"][0]["content"][1]["text"]
+        self.prompts.append(prompt)
+        name = next(name for name in _TRANSCRIPTS if name in prompt)
+        text, confidence = _TRANSCRIPTS[name]
+        return FakeResponse({"choices": [{"message": {"content": text}}], "language_confidence": confidence})
+
+
+def _transcribe(monkeypatch, neighbors, language="kannada"):
+    FakeAsyncClient.prompts = []
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    monkeypatch.setattr(transcribe_svc, "NEIGHBOR_LANGUAGES", neighbors)
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+    return asyncio.run(transcribe_svc.transcribe_audio(upload, language=language))
+
+
+def test_low_confidence_switches_to_the_most_confident_neighbor(monkeypatch):
+    result = _transcribe(monkeypatch, {"kannada": ["tamil", "telugu"]})
+    assert (result.text, result.language, result.language_confidence) == ("నమస్కారం", "telugu", 0.9)
+    assert result.requested_language == "kannada"
+    assert len(FakeAsyncClient.prompts) == 3
+
+
+def test_confident_or_unconfigured_languages_are_kept(monkeypatch):
+    result = _transcribe(monkeypatch, {"telugu": ["kannada"]}, language="telugu")
+    assert result.requested_language is None and len(FakeAsyncClient.prompts) == 1
+
+    result = _transcribe(monkeypatch, {})
+    assert (result.text, result.requested_language) == ("ನಮಸ್ಕಾರ", None)
+
+    result = _transcribe(monkeypatch, {"kannada": ["kannada"]})
+    assert result.requested_language is None and len(FakeAsyncClient.prompts) == 2
+
+
+def test_turn_continues_in_the_neighbor_language(client, monkeypatch):
+    spoken = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="నమస్కారం", language="telugu", language_confidence=0.9, requested_language="kannada")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "నమస్కారం!"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(language)
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada", "format": "json"}, files=files)
+    assert res.status_code == 200
+    assert res.json()["language_fallback"] == {"requested": "kn-IN", "used": "te-IN"}
+    assert spoken == ["telugu"]
+
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada"}, files=files)
+    assert res.headers["X-Language-Fallback"] == "te-IN"
@@ -0,0 +1,154 @@
+import asyncio
+import io
+
+import pytest
+from fastapi import HTTPException
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import pipeline
+from services import tts as tts_svc
+from services.language_id import detect_language, language_tag, normalize_language, parse_language
+from services.tts import TTSStream
+from services.tts_cache import TTSCache
+
+
+@pytest.mark.parametrize("text, language", [
+    ("ನಮಸ್ಕಾರ, ಹೇಗಿದ್ದೀರಿ?", "kannada"),
+    ("मुझे क्या करना है", "hindi"),
+    ("मला काय हवे आहे", "marathi"),
+    ("வணக்கம்", "tamil"),
+    ("నమస్కారం", "telugu"),
+    ("നമസ്കാരം", "malayalam"),
+    ("What is the time?", "english"),
+    ("Wie spät ist es bitte", "german"),
+    ("kya haal hai", "hindi"),
+    ("nanage coffee beku", "kannada"),
+    ("123 ?", None),
+])
+def test_detect_language(text, language):
+    assert detect_language(text) == language
+
+
+def test_normalize_backend_language_codes():
+    assert normalize_language("kn-IN") == "kannada"
+    assert normalize_language("Hindi") == "hindi"
+    assert normalize_language("fr") is None
+    assert normalize_language(None) is None
+
+
+def test_language_tags():
+    assert language_tag("kannada") == "kn-IN"
+    assert language_tag("unknown") == "unknown"
+    assert language_tag(None) is None
+    assert parse_language("kn-IN") == "kannada"
+    assert parse_language("hi") == "hindi"
+    assert parse_language("auto", allow_auto=True) == "auto"
+    with pytest.raises(HTTPException) as exc:
+        parse_language("fr-FR")
+    assert exc.value.status_code == 400 and "kn-IN" in exc.value.detail
+
+
+def test_speech_to_speech_accepts_language_tags(client, monkeypatch):
+    seen = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "call_llm", _capture_llm(seen))
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post("/v1/speech_to_speech", params={"language": "ta-IN"}, data={"text": "vanakkam"})
+    assert res.status_code == 200 and seen == ["tamil"]
+    assert client.post("/v1/speech_to_speech", params={"language": "xx-YY"}, data={"text": "hi"}).status_code == 400
+
+
+def _capture_llm(seen):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append(language)
+        return "ಸರಿ"
+
+    return fake_call_llm
+
+
+def test_auto_prefers_the_asr_backends_detection(monkeypatch):
+    seen = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        assert language is None  # no language hint to the ASR
+        return TranscriptionResponse(text="hello", language="kannada")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", _capture_llm(seen))
+    upload = pipeline.audio_upload(b"RIFF")
+    turn = asyncio.run(pipeline.converse(upload, language="auto"))
+    assert turn.language == "kannada"
+    assert seen == ["kannada"]
+
+
+def test_auto_falls_back_to_the_transcript_then_the_default(monkeypatch):
+    seen = []
+    monkeypatch.setattr(pipeline, "call_llm", _capture_llm(seen))
+    assert asyncio.run(pipeline.converse(text="मला काय हवे आहे", language="auto")).language == "marathi"
+
+    monkeypatch.setattr(pipeline, "AUTO_LANGUAGE_FALLBACK", "english")
+    assert asyncio.run(pipeline.converse(text="42", language="auto")).language == "english"
+    assert seen == ["marathi", "english"]
+
+
+def test_endpoint_routes_tts_and_reports_detection(client, monkeypatch):
+    spoken = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="ನನಗೆ ಕಾಫಿ ಬೇಕು")
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(language)
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", _capture_llm([]))
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"language": "auto"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 200
+    assert res.headers["X-Detected-Language"] == "kn-IN"
+    assert spoken == ["kannada"]
+
+    res = client.post("/v1/speech_to_speech", params={"language": "auto", "format": "json"}, data={"text": "9"})
+    assert res.json()["detected_language"] is None
+
+
+def test_tts_voice_follows_the_language(monkeypatch):
+    sent = []
+
+    class _Response:
+        status_code = 200
+        headers = {"Content-Type": "audio/mpeg"}
+
+        def raise_for_status(self):
+            pass
+
+        async def aiter_bytes(self):
+            yield b"ID3"
+
+        async def aclose(self):
+            pass
+
+    class _Client:
+        def build_request(self, method, url, **kwargs):
+            sent.append(kwargs["json"])
+
+        async def send(self, request, stream=False):
+            return _Response()
+
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: _Client())
+    monkeypatch.setattr(tts_svc, "TTS_VOICES", {"kannada": "kn_female"})
+    asyncio.run(tts_svc.stream_tts("ನಮಸ್ಕಾರ", language="kannada"))
+    asyncio.run(tts_svc.stream_tts("hello", language="english"))
+    assert sent == [{"text": "ನಮಸ್ಕಾರ", "voice": "kn_female"}, {"text": "hello"}]
@@ -0,0 +1,58 @@
+import io
+import struct
+import wave
+
+from prometheus_client import REGISTRY
+
+from services import latency
+
+
+def _wav(seconds: float, rate: int = 16000) -> bytes:
+    buf = io.BytesIO()
+    with wave.open(buf, "wb") as w:
+        w.setnchannels(1)
+        w.setsampwidth(2)
+        w.setframerate(rate)
+        w.writeframes(b"\x00\x00" * int(rate * seconds))
+    return buf.getvalue()
+
+
+def test_wav_duration_from_header():
+    data = _wav(3.0)
+    assert abs(latency.probe_duration(io.BytesIO(data)) - 3.0) < 1e-6
+
+
+def test_non_wav_has_unknown_duration():
+    assert latency.wav_duration(b"\xff\xfb\x90\x00" + b"\x00" * 100) is None
+    assert latency.wav_duration(b"RIFF" + struct.pack("<I", 4) + b"WAVE") is None
+
+
+def test_duration_labels_are_coarse():
+    assert latency.duration_label(1.5) == "0-2s"
+    assert latency.duration_label(12) == "10-30s"
+    assert latency.duration_label(600) == "60s+"
+    assert latency.duration_label(None) == "unknown"
+    assert latency.duration_label(None, audio_input=False) == "text"
+
+
+def test_observe_stage_labels_by_input_duration():
+    def count():
+        return REGISTRY.get_sample_value(
+            "dwani_stage_processing_seconds_count", {"stage": "asr", "audio_duration": "2-5s"}
+        ) or 0
+
+    before = count()
+    latency.set_input_audio(3.0)
+    with latency.observe_stage("asr"):
+        pass
+    assert count() == before + 1
+
+
+def test_request_timings_name_each_stage_and_the_total():
+    stages = latency.track_request()
+    with latency.observe_stage("asr"):
+        pass
+    stages["llm"] = 12.34
+    timings = latency.request_timings()
+    assert set(timings) == {"asr_ms", "llm_ms", "total_ms"}
+    assert timings["llm_ms"] == 12.3 and timings["total_ms"] >= timings["asr_ms"]
@@ -0,0 +1,78 @@
+import asyncio
+
+import pytest
+
+from services.lifecycle import Lifecycle, LifecycleContext
+from services.pipeline import Pipeline, PipelineConfig
+
+
+def test_start_in_order_and_shut_down_in_reverse():
+    calls = []
+    lifecycle = Lifecycle()
+    lifecycle.on_start(lambda: calls.append("start queue"))
+    lifecycle.on_shutdown(lambda ctx: calls.append(f"stop queue ({ctx.reason})"))
+
+    @lifecycle.on_start
+    async def start_cache(ctx):
+        ctx.metadata["cache"] = "warm"
+        calls.append("start cache")
+
+    @lifecycle.on_shutdown
+    async def stop_cache(ctx):
+        calls.append(f"stop cache ({ctx.metadata['cache']})")
+
+    async def run():
+        await lifecycle.start(LifecycleContext("test"))
+        await lifecycle.start()  # already running
+        assert lifecycle.running
+        await lifecycle.shutdown()
+        await lifecycle.shutdown()  # runs once
+
+    asyncio.run(run())
+    assert calls == ["start queue", "start cache", "stop cache (warm)", "stop queue (shutdown)"]
+    assert not lifecycle.running
+
+
+def test_failed_start_shuts_down_and_raises():
+    reasons = []
+    lifecycle = Lifecycle()
+    lifecycle.on_shutdown(lambda ctx: reasons.append(ctx.reason))
+
+    @lifecycle.on_start
+    def broken():
+        raise RuntimeError("no scheduler")
+
+    with pytest.raises(RuntimeError):
+        asyncio.run(lifecycle.start())
+    assert reasons == ["start_failed"]
+
+
+def test_slow_or_failing_shutdown_hooks_do_not_block_the_rest():
+    calls = []
+    lifecycle = Lifecycle(shutdown_timeout=0.05)
+    lifecycle.on_shutdown(lambda: calls.append("last"))
+
+    @lifecycle.on_shutdown
+    async def hangs():
+        await asyncio.sleep(10)
+
+    @lifecycle.on_shutdown
+    def fails():
+        raise RuntimeError("already closed")
+
+    asyncio.run(lifecycle.shutdown())
+    assert calls == ["last"]
+
+
+def test_embedded_pipeline_runs_its_lifecycle():
+    seen = []
+    pipe = Pipeline(PipelineConfig(llm_base_url="http://llm"))
+    pipe.lifecycle.on_start(lambda ctx: seen.append((ctx.name, ctx.config.llm_base_url)))
+    pipe.lifecycle.on_shutdown(lambda ctx: seen.append(ctx.reason))
+
+    async def run():
+        async with pipe as running:
+            assert running is pipe
+
+    asyncio.run(run())
+    assert seen == [("pipeline", "http://llm"), "shutdown"]
@@ -0,0 +1,78 @@
+"""Tests for switching to the fallback LLM when time to first token degrades."""
+import asyncio
+from types import SimpleNamespace
+
+import httpx
+import pytest
+from openai import APITimeoutError
+
+from services import backends, chat_svc
+from services.llm_health import LLMHealth, llm_health, reset_llm_health
+
+
+class Clock:
+    now = 100.0
+
+    def __call__(self):
+        return self.now
+
+
+def test_timeout_follows_recent_health_within_bounds():
+    health = LLMHealth("http://llm", window=3, slow_ms=2000, fallback_seconds=30)
+    assert health.timeout(60) == 4
+    for seconds in (1.5, 1.8, 1.9):
+        health.observe(seconds)
+    assert health.timeout(60) == pytest.approx(7.2)
+    assert health.timeout(5) == 5
+    assert not health.degraded()
+
+
+def test_slow_median_sends_new_requests_to_the_fallback_for_a_while():
+    clock = Clock()
+    health = LLMHealth("http://llm", window=3, slow_ms=2000, fallback_seconds=30, clock=clock)
+    for seconds in (1, 1.5, 9):
+        health.observe(seconds)
+    assert not health.degraded()
+    health.observe(8)
+    assert health.degraded() and health.median() is None
+    clock.now += 31
+    assert not health.degraded()
+
+
+@pytest.fixture
+def llms(monkeypatch):
+    calls = []
+
+    class FakeCompletions:
+        def __init__(self, base_url):
+            self.base_url = base_url
+
+        async def create(self, **kwargs):
+            calls.append((self.base_url, kwargs["model"], kwargs["timeout"]))
+            if self.base_url.startswith("http://llm/"):
+                raise APITimeoutError(request=httpx.Request("POST", self.base_url))
+            return SimpleNamespace(choices=[SimpleNamespace(message=SimpleNamespace(content="namaskara"))])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, base_url=None, **kwargs):
+            self.chat = SimpleNamespace(completions=FakeCompletions(base_url))
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", FakeAsyncOpenAI)
+    monkeypatch.setattr(backends, "LLM_FALLBACK_BASE_URL", "http://fallback")
+    monkeypatch.setattr(backends, "LLM_FALLBACK_MODEL", "small")
+    reset_llm_health()
+    yield calls
+    reset_llm_health()
+
+
+def test_timed_out_call_is_retried_on_the_fallback(llms):
+    assert asyncio.run(chat_svc.call_llm("hello")) == "namaskara"
+    assert [(url, model) for url, model, _ in llms] == [("http://llm/v1", backends.llm_model()), ("http://fallback/v1", "small")]
+    assert llms[0][2] == llm_health("http://llm").slow * 2
+
+
+def test_degraded_llm_is_skipped(llms):
+    llm_health("http://llm").observe(600)
+    assert asyncio.run(chat_svc.call_llm("hello")) == "namaskara"
+    assert [url for url, _, _ in llms] == ["http://fallback/v1"]
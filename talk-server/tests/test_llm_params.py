@@ -0,0 +1,47 @@
+import asyncio
+from types import SimpleNamespace
+
+from services import chat_svc
+from models import GenerationParams
+
+
+def _fake_openai(captured):
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            captured.update(kwargs)
+            message = SimpleNamespace(content="namaskara")
+            return SimpleNamespace(choices=[SimpleNamespace(message=message)])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, **kwargs):
+            self.chat = SimpleNamespace(completions=FakeCompletions())
+
+    return FakeAsyncOpenAI
+
+
+def test_defaults_come_from_config(monkeypatch):
+    captured = {}
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(captured))
+    monkeypatch.setattr(chat_svc, "LLM_MAX_TOKENS", 300)
+    monkeypatch.setattr(chat_svc, "LLM_TEMPERATURE", None)
+    asyncio.run(chat_svc.call_llm("hello"))
+    assert captured["max_tokens"] == 300
+    assert "temperature" not in captured
+
+
+def test_request_overrides_model_and_sampling(monkeypatch):
+    captured = {}
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(captured))
+    params = GenerationParams(model="qwen3", temperature=0.2, top_p=0.9, max_tokens=64)
+    assert asyncio.run(chat_svc.call_llm("hello", generation=params)) == "namaskara"
+    assert captured["model"] == "qwen3"
+    assert captured["temperature"] == 0.2
+    assert captured["top_p"] == 0.9
+    assert captured["max_tokens"] == 64
+
+
+def test_chat_rejects_out_of_range_temperature(client):
+    res = client.post("/v1/chat", json={"text": "hi", "temperature": 5})
+    assert res.status_code == 422
@@ -0,0 +1,115 @@
+"""Tests for weighted A/B testing of LLM models."""
+import asyncio
+from collections import Counter
+from types import SimpleNamespace
+
+import pytest
+
+from models import GenerationParams
+from routers import chat as chat_router
+from services import backends, chat_svc, llm_variants
+from services.llm_variants import LLMVariant, choose, parse_variants
+from services.tts import TTSStream
+
+
+def test_parse_variants():
+    assert parse_variants("gemma3=90, qwen3@http://qwen:8000/v1/=10,off=0,llama") == [
+        LLMVariant("gemma3", 90),
+        LLMVariant("qwen3", 10, "http://qwen:8000/v1"),
+        LLMVariant("llama", 1),
+    ]
+    assert parse_variants("") == []
+    with pytest.raises(ValueError):
+        parse_variants("gemma3=lots")
+
+
+def test_choice_is_sticky_per_session_and_follows_the_weights():
+    variants = parse_variants("a=3,b=1")
+    assert choose("session-1", variants) == choose("session-1", variants)
+    picked = Counter(choose(f"session-{n}", variants).model for n in range(4000))
+    assert 0.7 < picked["a"] / 4000 < 0.8
+    assert choose("session-1", []) is None
+
+
+def _fake_openai(models):
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            models.append((kwargs["model"], self.base_url))
+            return SimpleNamespace(choices=[SimpleNamespace(message=SimpleNamespace(content="namaskara"), finish_reason="stop")])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, base_url=None, **kwargs):
+            completions = FakeCompletions()
+            completions.base_url = base_url
+            self.chat = SimpleNamespace(completions=completions)
+
+    return FakeAsyncOpenAI
+
+
+def test_call_llm_uses_the_chosen_variant_unless_a_model_is_requested(monkeypatch):
+    models = []
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm.internal")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(models))
+    monkeypatch.setattr(llm_variants, "VARIANTS", parse_variants("qwen3@http://qwen.internal=1"))
+
+    asyncio.run(chat_svc.call_llm("hello", request_id="r-1"))
+    asyncio.run(chat_svc.call_llm("hello", request_id="r-2", generation=GenerationParams(model="gemma3")))
+    with backends.use_config(backends.PipelineConfig(llm_model="tenant-model")):
+        asyncio.run(chat_svc.call_llm("hello", request_id="r-3"))
+    assert models == [
+        ("qwen3", "http://qwen.internal/v1"),
+        ("gemma3", "http://llm.internal/v1"),
+        ("tenant-model", "http://llm.internal/v1"),
+    ]
+    replies = llm_variants.VARIANT_REPLIES.labels("qwen3", "ok")._value.get()
+    assert replies >= 1
+
+
+def test_response_names_the_model(client, monkeypatch):
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm.internal")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai([]))
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(llm_variants, "VARIANTS", parse_variants("gemma3=1,qwen3=1"))
+
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada"}, data={"text": "hello"}, headers={"X-Session-ID": "s-1"})
+    assert res.status_code == 200
+    assert res.headers["X-LLM-Model"] == choose("s-1", llm_variants.VARIANTS).model
+
+
+def test_requests_pick_a_model_from_the_allowlist(client, monkeypatch):
+    models = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm.internal")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(models))
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(llm_variants, "LLM_ALLOWED_MODELS", ["qwen3", "llama3"])
+
+    res = client.post("/v1/speech_to_speech", params={"model": "qwen3"}, data={"text": "hello"})
+    assert res.status_code == 200 and res.headers["X-LLM-Model"] == "qwen3"
+    res = client.post("/v1/speech_to_speech", data={"text": "hello", "model": "llama3"})
+    assert res.status_code == 200 and res.headers["X-LLM-Model"] == "llama3"
+    assert [model for model, _ in models] == ["qwen3", "llama3"]
+
+    res = client.post("/v1/speech_to_speech", params={"model": "gpt-4o"}, data={"text": "hello"})
+    assert res.status_code == 400
+    assert res.json()["error"]["error_code"] == "invalid_request" and "qwen3" in res.json()["error"]["message"]
+    assert client.post("/v1/chat", json={"text": "hello", "model": "gpt-4o"}).status_code == 400
+    assert len(models) == 2
+    assert client.post("/v1/chat", json={"text": "hello", "model": " qwen3 "}).status_code == 200
+    assert models[-1][0] == "qwen3"
+
+
+def test_without_an_allowlist_any_model_is_accepted(monkeypatch):
+    monkeypatch.setattr(llm_variants, "LLM_ALLOWED_MODELS", [])
+    assert llm_variants.resolve_model(" gpt-4o ") == "gpt-4o"
+    assert llm_variants.resolve_model("") is None
+    monkeypatch.setattr(llm_variants, "LLM_ALLOWED_MODELS", ["qwen3"])
+    monkeypatch.setattr(llm_variants, "VARIANTS", parse_variants("llama3=1"))
+    assert llm_variants.resolve_model(backends.llm_model()) == backends.llm_model()
+    assert llm_variants.resolve_model("llama3") == "llama3"
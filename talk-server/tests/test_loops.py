@@ -0,0 +1,44 @@
+"""Tests for breaking LLM replies that loop or repeat earlier ones."""
+import asyncio
+
+from services import loops, pipeline
+from services.messages import message
+
+_PREVIOUS = "The weather in Bengaluru is sunny today."
+_CONTEXT = [{"role": "user", "content": "Weather?"}, {"role": "assistant", "content": _PREVIOUS}]
+
+
+def test_loops_are_detected():
+    assert loops.loop_kind("I can help. I can help. I can help.") == "self"
+    assert loops.loop_kind("Let me check the price and the price and the price and the price and the price") == "self"
+    assert loops.loop_kind("The weather in Bengaluru is sunny today!", [_PREVIOUS]) == "repeat"
+    assert loops.loop_kind("Tomorrow looks rainy in the evening.", [_PREVIOUS]) is None
+    assert loops.loop_kind("Yes. Yes. Yes.") is None
+    assert loops.deduplicated("I can help. I can help. I can help.") == "I can help."
+
+
+def _session(monkeypatch, replies, prompts):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts.append(system_prompt)
+        return replies.pop(0)
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(pipeline, "get_session_context", lambda session_id: list(_CONTEXT))
+    monkeypatch.setattr(pipeline, "append_to_session", lambda *args: None)
+
+
+def test_repeated_reply_is_asked_again_with_a_fresh_prompt(monkeypatch):
+    prompts = []
+    _session(monkeypatch, [_PREVIOUS, "Tomorrow looks rainy in the evening."], prompts)
+    turn = asyncio.run(pipeline.converse(text="And tomorrow?", session_id="loops-1"))
+    assert turn.reply == "Tomorrow looks rainy in the evening."
+    assert prompts[0] is None
+    assert prompts[1].endswith(loops.FRESH_INSTRUCTION)
+
+
+def test_fallback_when_the_fresh_prompt_loops_too(monkeypatch):
+    _session(monkeypatch, [_PREVIOUS, _PREVIOUS], [])
+    assert asyncio.run(pipeline.converse(text="And tomorrow?", session_id="loops-2")).reply == message("loop_fallback")
+
+    _session(monkeypatch, ["I can help. I can help. I can help.", "Sure. I can help. I can help. I can help."], [])
+    assert asyncio.run(pipeline.converse(text="Help?", session_id="loops-3")).reply == "Sure. I can help."
@@ -0,0 +1,61 @@
+"""Tests for reply audio loudness normalization and lead silence."""
+import asyncio
+
+import pytest
+
+from services import loudness
+from services.errors import StageError
+from services.hooks import HookContext, PipelineHooks
+from services.telephony import wav_bytes
+from services.tts import TTSStream
+
+
+def test_filters_follow_the_settings():
+    assert loudness.filter_graph(lufs=0, lead_silence_ms=0) is None
+    assert loudness.filter_graph(lufs=-16, true_peak=-1.5, lead_silence_ms=0) == "loudnorm=I=-16:TP=-1.5:LRA=11"
+    assert loudness.filter_graph(lufs=-23, true_peak=-2, lead_silence_ms=300) == "adelay=300:all=1,loudnorm=I=-23:TP=-2:LRA=11"
+    assert loudness.filter_graph(lufs=0, lead_silence_ms=250) == "adelay=250:all=1"
+
+
+@pytest.fixture
+def ffmpeg(monkeypatch):
+    calls = []
+
+    async def fake_transcode(audio, target, filters=None, sample_rate=None):
+        calls.append((target, filters, sample_rate))
+        return b"RIFF\x24\x00\x00\x00WAVEfmt processed"
+
+    monkeypatch.setattr(loudness, "transcode", fake_transcode)
+    monkeypatch.setattr(loudness, "FILTERS", "loudnorm=I=-16:TP=-1.5:LRA=11")
+    return calls
+
+
+def _postprocess(stream):
+    registry = PipelineHooks()
+    assert loudness.install(registry)
+    assert not loudness.install(registry)
+    return asyncio.run(registry.run("after_tts", HookContext(), stream))
+
+
+def test_reply_audio_is_normalized_in_its_own_format_and_rate(ffmpeg):
+    stream = _postprocess(TTSStream.cached("application/octet-stream", wav_bytes(b"\x00\x01" * 100, 22050)))
+    assert ffmpeg == [("wav", "loudnorm=I=-16:TP=-1.5:LRA=11", 22050)]
+    assert stream.media_type == "audio/wav"
+    assert asyncio.run(stream.read()).endswith(b"processed")
+
+
+def test_audio_is_sent_as_returned_when_ffmpeg_fails_or_the_format_is_unknown(ffmpeg, monkeypatch):
+    async def failing_transcode(audio, target, filters=None, sample_rate=None):
+        raise StageError("tts", detail="TTS audio could not be converted to mp3")
+
+    monkeypatch.setattr(loudness, "transcode", failing_transcode)
+    stream = _postprocess(TTSStream.cached("audio/mpeg", b"ID3mp3-bytes"))
+    assert asyncio.run(stream.read()) == b"ID3mp3-bytes"
+
+    raw = TTSStream.cached("application/octet-stream", b"\x01\x02raw-pcm")
+    assert _postprocess(raw) is raw
+
+
+def test_nothing_is_installed_without_settings(monkeypatch):
+    monkeypatch.setattr(loudness, "FILTERS", None)
+    assert not loudness.install(PipelineHooks())
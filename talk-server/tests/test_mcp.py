@@ -0,0 +1,89 @@
+import base64
+
+from fastapi.testclient import TestClient
+
+import main
+from services import mcp as mcp_svc
+from services.pipeline import TurnResult
+from services.tts import TTSStream
+
+
+client = TestClient(main.app)
+
+
+def _rpc(method, params=None, msg_id=1):
+    return client.post("/mcp", json={"jsonrpc": "2.0", "id": msg_id, "method": method, "params": params or {}})
+
+
+def test_initialize_and_list_tools():
+    res = _rpc("initialize", {"protocolVersion": mcp_svc.PROTOCOL_VERSION, "capabilities": {}})
+    assert res.status_code == 200
+    assert res.json()["result"]["capabilities"]["tools"] is not None
+
+    names = {tool["name"] for tool in _rpc("tools/list").json()["result"]["tools"]}
+    assert names == {"transcribe_audio", "synthesize_speech", "voice_chat"}
+
+
+def test_notification_gets_no_reply():
+    res = client.post("/mcp", json={"jsonrpc": "2.0", "method": "notifications/initialized"})
+    assert res.status_code == 202
+
+
+def test_voice_chat_returns_text_and_audio(monkeypatch):
+    async def fake_converse(**kwargs):
+        assert kwargs["text"] == "hello"
+        return TurnResult(transcription="hello", reply="namaskara")
+
+    async def fake_stream_tts(text, request_id=None, language=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(mcp_svc, "converse", fake_converse)
+    monkeypatch.setattr(mcp_svc, "stream_tts", fake_stream_tts)
+
+    result = _rpc("tools/call", {"name": "voice_chat", "arguments": {"text": "hello"}}).json()["result"]
+    text, audio = result["content"]
+    assert text == {"type": "text", "text": "namaskara"}
+    assert audio["mimeType"] == "audio/mpeg"
+    assert base64.b64decode(audio["data"]) == b"mp3"
+    assert result["structuredContent"]["transcription"] == "hello"
+
+
+def test_tools_answer_to_the_endpoint_names(monkeypatch):
+    calls = []
+
+    async def fake_stream_tts(text, request_id=None, language=None):
+        calls.append((text, language))
+        return TTSStream.cached("audio/wav", b"wav")
+
+    monkeypatch.setattr(mcp_svc, "stream_tts", fake_stream_tts)
+
+    result = _rpc("tools/call", {"name": "synthesize", "arguments": {"text": "ನಮಸ್ಕಾರ", "language": "kn-IN"}}).json()["result"]
+    assert result["content"][0]["mimeType"] == "audio/wav"
+    assert calls == [("ನಮಸ್ಕಾರ", "kannada")]
+
+
+def test_invalid_arguments_are_jsonrpc_errors():
+    body = _rpc("tools/call", {"name": "transcribe_audio", "arguments": {"audio_base64": "not base64!"}}).json()
+    assert body["error"]["code"] == -32602
+
+    body = _rpc("tools/call", {"name": "nope", "arguments": {}}).json()
+    assert body["error"]["code"] == -32602
+
+    assert _rpc("resources/list").json()["error"]["code"] == -32601
+
+
+def test_pipeline_failure_is_tool_error(monkeypatch):
+    from fastapi import HTTPException
+
+    async def failing_stream_tts(text, request_id=None, language=None):
+        raise HTTPException(status_code=502, detail="TTS down")
+
+    monkeypatch.setattr(mcp_svc, "stream_tts", failing_stream_tts)
+    result = _rpc("tools/call", {"name": "synthesize_speech", "arguments": {"text": "hi"}}).json()["result"]
+    assert result["isError"] is True
+    assert "TTS down" in result["content"][0]["text"]
+
+
+def test_sse_messages_require_known_session():
+    res = client.post("/mcp/messages?session_id=missing", json={"jsonrpc": "2.0", "id": 1, "method": "ping"})
+    assert res.status_code == 404
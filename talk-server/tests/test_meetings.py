@@ -0,0 +1,128 @@
+import io
+import json
+import math
+import struct
+import wave
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import meetings as meetings_svc
+from services.concurrency import ConcurrencyLimiter
+from services.transcribe import SpeakerTurn, speaker_turns
+from services.tts import TTSStream
+
+_MINUTES = json.dumps({
+    "summary": "The team agreed to ship the release on Friday.",
+    "key_points": ["Testing is done"],
+    "decisions": ["Ship on Friday"],
+    "action_items": [
+        {"task": "Write the release notes", "owner": "Asha", "due": "Thursday"},
+        {"task": "Book the demo room", "owner": None, "due": "null"},
+        {"owner": "Ravi"},
+    ],
+})
+
+
+def _wav(seconds: float, rate: int = 8000) -> bytes:
+    samples = [int(8000 * math.sin(2 * math.pi * 440 * i / rate)) for i in range(int(seconds * rate))]
+    buf = io.BytesIO()
+    with wave.open(buf, "wb") as w:
+        w.setnchannels(1)
+        w.setsampwidth(2)
+        w.setframerate(rate)
+        w.writeframes(struct.pack(f"<{len(samples)}h", *samples))
+    return buf.getvalue()
+
+
+def test_diarized_transcript_is_split_at_speaker_labels():
+    text = "Speaker 1: Shall we start?\nYes, let's.\n**Speaker 2:** Testing is done.\nspk1 - Good."
+    assert speaker_turns(text) == [
+        SpeakerTurn("Speaker 1", "Shall we start? Yes, let's."),
+        SpeakerTurn("Speaker 2", "Testing is done."),
+        SpeakerTurn("Speaker 1", "Good."),
+    ]
+    assert speaker_turns("no labels here") == [SpeakerTurn(None, "no labels here")]
+
+
+def test_summary_parsing_drops_items_without_a_task():
+    minutes = meetings_svc.parse_summary(f"```json\n{_MINUTES}\n```")
+    assert minutes.decisions == ["Ship on Friday"]
+    assert [(a.task, a.owner, a.due) for a in minutes.action_items] == [
+        ("Write the release notes", "Asha", "Thursday"),
+        ("Book the demo room", None, None),
+    ]
+    assert minutes.spoken_text() == (
+        "The team agreed to ship the release on Friday. "
+        "Write the release notes (Asha, Thursday). Book the demo room."
+    )
+
+
+def _fake_pipeline(monkeypatch, llm_reply=_MINUTES):
+    prompts = {}
+
+    async def fake_transcribe(file, language=None, request_id=None, diarize=False):
+        assert diarize
+        return TranscriptionResponse(text="Speaker 1: Is testing done?\nSpeaker 2: Yes, we can ship Friday.")
+
+    async def fake_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts["user"], prompts["system"] = user_text, system_prompt
+        return llm_reply
+
+    monkeypatch.setattr(meetings_svc, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(meetings_svc, "call_llm", fake_llm)
+    return prompts
+
+
+def test_meeting_is_transcribed_by_speaker_and_summarized(client, monkeypatch):
+    prompts = _fake_pipeline(monkeypatch)
+    res = client.post(
+        "/v1/meetings/summarize?summary_language=hindi",
+        files={"file": ("standup.wav", io.BytesIO(_wav(3)), "audio/wav")},
+    )
+    assert res.status_code == 200
+    body = res.json()
+    assert [(t["speaker"], t["text"]) for t in body["transcript"]] == [
+        ("Speaker 1", "Is testing done?"),
+        ("Speaker 2", "Yes, we can ship Friday."),
+    ]
+    assert body["action_items"][0] == {"task": "Write the release notes", "owner": "Asha", "due": "Thursday"}
+    assert body["audio_base64"] is None
+    assert "Speaker 2: Yes, we can ship Friday." in prompts["user"]
+    assert "Hindi" in prompts["system"]
+
+
+def test_speak_returns_the_summary_as_audio(client, monkeypatch):
+    _fake_pipeline(monkeypatch)
+    spoken = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", b"ID3summary")
+
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    res = client.post("/v1/meetings/summarize?speak=true", files={"file": ("a.wav", io.BytesIO(_wav(1)), "audio/wav")})
+    assert res.status_code == 200
+    assert res.json()["audio_content_type"] == "audio/mpeg"
+    assert spoken[0].startswith("The team agreed")
+
+
+def test_unusable_summary_is_a_stage_error(client, monkeypatch):
+    _fake_pipeline(monkeypatch, llm_reply="Sorry, I cannot help with that.")
+    res = client.post("/v1/meetings/summarize", files={"file": ("a.wav", io.BytesIO(_wav(1)), "audio/wav")})
+    assert res.status_code == 502
+
+
+def test_the_minutes_are_written_inside_a_pipeline_slot(client, monkeypatch):
+    _fake_pipeline(monkeypatch)
+    limiter = ConcurrencyLimiter(max_concurrent=1, max_queue=0)
+    held = []
+    real_llm = meetings_svc.call_llm
+
+    async def counting_llm(*args, **kwargs):
+        held.append(limiter.active)
+        return await real_llm(*args, **kwargs)
+
+    monkeypatch.setattr(meetings_svc, "pipeline_limiter", limiter)
+    monkeypatch.setattr(meetings_svc, "call_llm", counting_llm)
+    res = client.post("/v1/meetings/summarize", files={"file": ("a.wav", io.BytesIO(_wav(1)), "audio/wav")})
+    assert res.status_code == 200 and held == [1] and limiter.active == 0
@@ -0,0 +1,47 @@
+"""Tests for the built-in catalog of server-spoken sentences."""
+import asyncio
+import uuid
+
+from services import confirmation as confirmation_svc
+from services import loops
+from services.grammar import grammar_from_spec, grammars
+from services.messages import CATALOG, localized, message
+
+
+def test_every_message_is_translated_and_takes_the_same_parameters():
+    for key, texts in CATALOG.items():
+        assert set(texts) == {"english", "kannada", "hindi", "tamil"}, key
+        fields = {name for name in ("first", "second", "options") if "{" + name + "}" in texts["english"]}
+        for text in texts.values():
+            assert {name for name in ("first", "second", "options") if "{" + name + "}" in text} == fields, key
+
+
+def test_language_names_and_tags_pick_the_translation():
+    assert message("call_error", "hindi") == CATALOG["call_error"]["hindi"]
+    assert message("call_error", "ta-IN") == CATALOG["call_error"]["tamil"]
+    assert message("call_error", "Kannada") == CATALOG["call_error"]["kannada"]
+    assert message("call_error", "telugu") == CATALOG["call_error"]["english"]
+    assert message("call_error") == CATALOG["call_error"]["english"]
+    assert localized("call_error", "telugu") is None
+
+
+def test_grammar_reprompts_follow_the_turn_language():
+    city = grammar_from_spec({"name": "city", "choices": ["Bengaluru", "Mysuru"]})
+    assert city.reprompt_text("kannada") == "ಕ್ಷಮಿಸಿ, ನನಗೆ ಅರ್ಥವಾಗಲಿಲ್ಲ. ದಯವಿಟ್ಟು Bengaluru ಅಥವಾ Mysuru ಎಂದು ಹೇಳಿ."
+    assert grammars["digits"].respond("hello", "hindi")[1] == CATALOG["reprompt_digits"]["hindi"]
+
+
+def test_loop_fallback_is_localized_unless_configured(monkeypatch):
+    assert loops.fallback("", "repeat", "tamil") == CATALOG["loop_fallback"]["tamil"]
+    monkeypatch.setattr(loops, "LOOP_FALLBACK_REPLY", "One more time, please?")
+    assert loops.fallback("", "repeat", "tamil") == "One more time, please?"
+
+
+def test_confirmation_apology_needs_no_llm_for_catalog_languages(monkeypatch):
+    async def fail_call_llm(*args, **kwargs):
+        raise AssertionError("the LLM should not be asked")
+
+    monkeypatch.setattr(confirmation_svc, "call_llm", fail_call_llm)
+    outcome = asyncio.run(confirmation_svc.resolve_pending(f"s-{uuid.uuid4()}", "yes", language="hindi"))
+    assert outcome.status == "cancelled"
+    assert outcome.reply == CATALOG["confirm_lost"]["hindi"]
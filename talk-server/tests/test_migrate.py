@@ -0,0 +1,48 @@
+from sqlalchemy import create_engine, inspect, text
+
+import conversation_store
+import migrate
+
+
+def _engine(tmp_path):
+    return create_engine(f"sqlite:///{tmp_path / 'talk.db'}")
+
+
+def test_migrations_apply_once_and_record_versions(tmp_path):
+    engine = _engine(tmp_path)
+    assert migrate.apply_migrations(engine)
+    assert migrate.apply_migrations(engine)  # idempotent
+    assert migrate.pending(engine) == []
+    columns = {c["name"] for c in inspect(engine).get_columns("conversation_turns")}
+    expected = {"transcript_chars", "reply_chars", "cost_usd", "request_id", "playback_ms", "experiment", "voice", "tenant"}
+    assert expected <= columns
+    assert {"users", "auth_sessions", "schema_migrations", "conversation_flags"} <= set(inspect(engine).get_table_names())
+
+
+def test_baseline_adopts_tables_created_before_migrations(tmp_path):
+    engine = _engine(tmp_path)
+    with engine.begin() as conn:
+        conn.execute(text("CREATE TABLE users (id INTEGER PRIMARY KEY, email VARCHAR(255) NOT NULL, "
+                          "password_hash VARCHAR(255) NOT NULL, created_at DATETIME NOT NULL)"))
+        conn.execute(text("INSERT INTO users (email, password_hash, created_at) VALUES ('a@b.c', 'x', '2026-01-01')"))
+    assert migrate.apply_migrations(engine)
+    with engine.begin() as conn:
+        assert conn.execute(text("SELECT count(*) FROM users")).scalar() == 1
+
+
+def test_backfill_fills_derived_fields(tmp_path, monkeypatch):
+    monkeypatch.setattr(conversation_store, "COST_PER_1K_INPUT_CHARS", 1.0)
+    monkeypatch.setattr(conversation_store, "COST_PER_1K_OUTPUT_CHARS", 2.0)
+    engine = _engine(tmp_path)
+    migrate.apply_migrations(engine)
+    with engine.begin() as conn:
+        conn.execute(text(
+            "INSERT INTO conversation_turns (mode, input_type, transcript, reply) "
+            "VALUES ('llm', 'text', 'abcd', 'efghijkl')"
+        ))
+
+    assert migrate.backfill(engine, batch_size=1) == 1
+    assert migrate.backfill(engine) == 0
+    with engine.begin() as conn:
+        row = conn.execute(text("SELECT transcript_chars, reply_chars, cost_usd FROM conversation_turns")).one()
+    assert row == (4, 8, 0.02)
@@ -0,0 +1,80 @@
+import asyncio
+import base64
+import io
+import json
+import struct
+
+import pytest
+
+import talk
+from services import backends, mock
+from services import http_client as http_client_svc
+from services.audio_format import mp3_duration
+
+
+@pytest.fixture
+def mocked(monkeypatch):
+    for name in ("DWANI_CHAT_COMPLETIONS_URL", "DWANI_API_BASE_URL_LLM", "DWANI_API_BASE_URL_TTS"):
+        monkeypatch.delenv(name, raising=False)
+    monkeypatch.setattr(mock, "_enabled", True)
+    asyncio.run(http_client_svc.close_http_client())
+    yield
+    asyncio.run(http_client_svc.close_http_client())
+
+
+def _wav(seconds=0.5, rate=16000):
+    data = bytes(int(seconds * rate) * 2)
+    fmt = struct.pack("<HHIIHH", 1, 1, rate, rate * 2, 2, 16)
+    return b"RIFF" + struct.pack("<I", 36 + len(data)) + b"WAVEfmt " + struct.pack("<I", 16) + fmt + b"data" + struct.pack("<I", len(data)) + data
+
+
+def test_sample_mp3_lasts_as_long_as_asked():
+    assert mp3_duration(mock.silent_mp3(3.0)) == pytest.approx(3.0, abs=0.03)
+    assert mp3_duration(mock.silent_mp3(0)) > 0
+
+
+def test_unset_backend_urls_point_at_the_mocks(mocked):
+    assert backends.llm_base_url() == "http://mock-llm"
+    assert backends.tts_base_url() == "http://mock-tts"
+
+
+def test_speech_to_speech_runs_offline(client, mocked):
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"format": "json"},
+        files={"file": ("a.wav", io.BytesIO(_wav()), "audio/wav")},
+    )
+    assert res.status_code == 200
+    data = res.json()
+    assert data["transcription"]
+    assert data["llm_response"] == f"You said: {data['transcription']}"
+    assert mp3_duration(base64.b64decode(data["audio_base64"])) > 0
+
+
+def test_serve_mock_flag_sets_the_environment(monkeypatch):
+    served = []
+    monkeypatch.setenv("DWANI_MOCK_BACKENDS", "0")
+    monkeypatch.setattr(talk, "serve", lambda args: served.append((args, talk.os.environ["DWANI_MOCK_BACKENDS"])) or 0)
+    assert talk.main(["serve", "--mock", "--workers", "1"]) == 0
+    assert served == [(["--workers", "1"], "1")]
+
+
+def test_cli_pipeline_commands_run_in_process(mocked, monkeypatch, tmp_path, capsys):
+    monkeypatch.setenv("DWANI_MOCK_BACKENDS", "0")
+    monkeypatch.setattr(mock, "_transcripts", iter(["Where is counter five?"] * 2))
+    sample = tmp_path / "question.wav"
+    sample.write_bytes(_wav())
+
+    assert talk.main(["transcribe", "--mock", "--json", str(sample)]) == 0
+    assert json.loads(capsys.readouterr().out) == {"text": "Where is counter five?"}
+
+    reply = tmp_path / "reply.mp3"
+    assert talk.main(["converse", "--mock", "--language", "en-IN", str(sample), "-o", str(reply)]) == 0
+    assert capsys.readouterr().out.startswith("you: Where is counter five?\nreply: You said: Where is counter five?")
+    assert mp3_duration(reply.read_bytes()) > 0
+
+    assert talk.main(["speak", "--mock", "Namaskara", "-o", str(tmp_path / "speech.mp3")]) == 0
+    assert mp3_duration((tmp_path / "speech.mp3").read_bytes()) > 0
+
+    assert talk.main(["transcribe", "--mock", str(tmp_path / "missing.wav")]) == 1
+    assert "missing.wav" in capsys.readouterr().err
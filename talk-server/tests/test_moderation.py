@@ -0,0 +1,102 @@
+import asyncio
+
+import httpx
+import pytest
+
+from routers import chat as chat_router
+from services import moderation
+from services import pipeline
+from services.errors import StageError
+from services.hooks import hooks
+from services.moderation import ContentBlocked, Moderator, install
+
+
+@pytest.fixture(autouse=True)
+def _clear_hooks():
+    hooks.clear()
+    yield
+    hooks.clear()
+
+
+def _echo_llm(seen):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append(user_text)
+        return f"you said {user_text}"
+
+    return fake_call_llm
+
+
+def test_blocklist_masks_whole_words_in_any_script():
+    mod = Moderator(blocklist=["darn", "ಕೆಟ್ಟ ಪದ"], url="")
+    assert mod.apply_blocklist("Darn it, darning is fine", "input") == "*** it, darning is fine"
+    assert mod.apply_blocklist("ಇದು ಕೆಟ್ಟ ಪದ ಅಲ್ಲ", "input") == "ಇದು *** ಅಲ್ಲ"
+    assert mod.apply_blocklist("ಕೆಟ್ಟ ಪದಗಳು", "input") == "ಕೆಟ್ಟ ಪದಗಳು"
+
+
+def test_block_action_raises_with_details():
+    mod = Moderator(blocklist=["darn"], action="block", url="")
+    with pytest.raises(ContentBlocked) as exc:
+        mod.apply_blocklist("oh darn", "output")
+    assert exc.value.status_code == 422
+    assert exc.value.stage == "moderation"
+    assert exc.value.details == {"direction": "output", "categories": ["blocklist"]}
+
+
+def test_moderation_api_flags_and_fails_closed(monkeypatch):
+    class _Client:
+        def __init__(self, response=None):
+            self.response = response
+
+        async def post(self, url, json=None, headers=None, timeout=None):
+            if self.response is None:
+                raise httpx.ConnectError("down")
+            return httpx.Response(200, json=self.response, request=httpx.Request("POST", url))
+
+    flagged = {"results": [{"flagged": True, "categories": {"harassment": True, "violence": False}}]}
+    monkeypatch.setattr(moderation, "http_client", lambda: _Client(flagged))
+    mod = Moderator(blocklist=[], url="http://mod/v1/moderations")
+    with pytest.raises(ContentBlocked) as exc:
+        asyncio.run(mod.check("you are useless", "input"))
+    assert exc.value.categories == ["harassment"]
+
+    monkeypatch.setattr(moderation, "http_client", lambda: _Client())
+    with pytest.raises(StageError) as exc:
+        asyncio.run(mod.check("hello", "input"))
+    assert exc.value.status_code == 503
+    fail_open = Moderator(blocklist=[], url="http://mod/v1/moderations", fail_open=True)
+    assert asyncio.run(fail_open.check("hello", "input")) == "hello"
+
+
+def test_hooks_moderate_both_sides_of_the_llm(monkeypatch):
+    seen = []
+    monkeypatch.setattr(moderation, "moderator", Moderator(blocklist=["darn", "said"], url=""))
+    monkeypatch.setattr(pipeline, "call_llm", _echo_llm(seen))
+    assert install()
+    assert not install()  # idempotent across restarts of the app
+
+    turn = asyncio.run(pipeline.converse(text="darn traffic"))
+    assert seen == ["*** traffic"]
+    assert turn.reply == "you *** *** traffic"
+
+
+def test_stages_limit_which_side_is_checked(monkeypatch):
+    monkeypatch.setattr(moderation, "moderator", Moderator(blocklist=["darn"], url=""))
+    monkeypatch.setattr(moderation, "MODERATION_STAGES", {"output"})
+    assert asyncio.run(moderation.moderate("darn", "input")) == "darn"
+    assert asyncio.run(moderation.moderate("darn", "output")) == "***"
+
+
+def test_blocked_turn_returns_structured_error(client, monkeypatch):
+    monkeypatch.setattr(moderation, "moderator", Moderator(blocklist=["darn"], action="block", url=""))
+    monkeypatch.setattr(chat_router, "call_llm", _echo_llm([]))
+    install()
+
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "darn it"})
+    assert res.status_code == 422
+    error = res.json()["error"]
+    assert error["stage"] == "moderation"
+    assert error["details"]["direction"] == "input"
+
+    res = client.post("/v1/chat", json={"text": "darn it"})
+    assert res.status_code == 422
+    assert res.json()["error"]["details"]["categories"] == ["blocklist"]
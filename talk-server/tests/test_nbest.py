@@ -0,0 +1,97 @@
+"""Tests for N-best transcripts and rerunning a turn on a corrected transcript."""
+import asyncio
+import io
+import json
+
+import pytest
+from fastapi import UploadFile
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import pipeline
+from services import session as session_svc
+from services import transcribe as transcribe_svc
+from services.session import MemorySessionStore, set_session_store
+from services.tts import TTSStream
+
+
+def _transcribe(monkeypatch, body, nbest=0):
+    payloads = []
+
+    class FakeResponse:
+        status_code = 200
+
+        def json(self):
+            return body
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            payloads.append(json.loads(b"".join([chunk async for chunk in content])))
+            return FakeResponse()
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    monkeypatch.setattr(transcribe_svc, "ASR_NBEST", nbest)
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+    return asyncio.run(transcribe_svc.transcribe_audio(upload)), payloads[0]
+
+
+def test_alternatives_come_from_extra_choices_or_an_alternatives_list(monkeypatch):
+    body = {"choices": [{"message": {"content": c}} for c in ("book a table", "look a table", "book a table", "book a cable")]}
+    result, payload = _transcribe(monkeypatch, body, nbest=4)
+    assert payload["n"] == 4
+    assert result.alternatives == ["look a table", "book a cable"]
+
+    body = {"choices": [{"message": {"content": "hello"}, "alternatives": [{"text": "yellow"}, "hallo", {"confidence": 0.1}]}]}
+    result, payload = _transcribe(monkeypatch, body)
+    assert "n" not in payload
+    assert result.alternatives == ["yellow", "hallo"]
+    assert _transcribe(monkeypatch, {"choices": [{"message": {"content": "hello"}}]})[0].alternatives is None
+
+
+@pytest.fixture
+def turns(monkeypatch):
+    prompts = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="book a cable", alternatives=["book a table"])
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts.append((user_text, [m["content"] for m in context or []]))
+        return f"reply to {user_text}"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(session_svc, "_session_store", {})
+    set_session_store(MemorySessionStore())
+    yield prompts
+    set_session_store(None)
+
+
+def test_override_reruns_the_llm_and_replaces_the_misheard_turn(client, turns):
+    headers = {"X-Session-ID": "s1"}
+    client.post("/v1/speech_to_speech", data={"text": "hi"}, headers=headers)
+    files = {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, files=files, headers=headers)
+    assert res.json()["alternatives"] == ["book a table"]
+
+    res = client.post(
+        "/v1/speech_to_speech", params={"format": "json"}, data={"transcript_override": "book a table"}, headers=headers
+    )
+    assert res.status_code == 200
+    data = res.json()
+    assert (data["transcription"], data["llm_response"], data["corrected"]) == ("book a table", "reply to book a table", True)
+    assert "alternatives" not in data
+    assert turns[-1] == ("book a table", ["hi", "reply to hi"])
+    assert [m["content"] for m in session_svc.get_session_context("s1")] == [
+        "hi", "reply to hi", "book a table", "reply to book a table"
+    ]
+
+
+def test_override_is_sent_alone(client, turns):
+    res = client.post("/v1/speech_to_speech", data={"text": "hi", "transcript_override": "hello"})
+    assert res.status_code == 400
+    assert turns == []
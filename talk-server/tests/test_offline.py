@@ -0,0 +1,77 @@
+"""Tests for the local ASR and TTS fallback when the backends are unreachable."""
+import asyncio
+import io
+
+import httpx
+import pytest
+from fastapi import UploadFile
+
+from services import offline
+from services import transcribe as transcribe_svc
+from services import tts as tts_svc
+from services.deadline import StageTimeout
+from services.errors import StageError
+from services.tts_cache import TTSCache
+
+
+def test_only_unreachable_backends_are_bypassed():
+    assert offline.unreachable(StageError("asr", detail="ASR service unavailable", error_code="asr_unavailable"))
+    assert offline.unreachable(StageTimeout("tts"))
+    assert offline.unreachable(StageError("tts", 503, "No TTS backend instances are available"))
+    assert not offline.unreachable(StageError("asr", detail="ASR service returned HTTP 500"))
+    assert not offline.unreachable(StageError("tts", detail="TTS service returned a non-audio response"))
+
+
+def test_voices_by_language_with_a_default(monkeypatch):
+    monkeypatch.setattr(offline, "OFFLINE_TTS_VOICES", {"kannada": "/m/kn.onnx", "default": "/m/en.onnx"})
+    assert offline.voice_path("Kannada") == "/m/kn.onnx"
+    assert offline.voice_path("hindi") == offline.voice_path(None) == "/m/en.onnx"
+    monkeypatch.setattr(offline, "OFFLINE_TTS_VOICES", {})
+    assert offline.voice_path("kannada") is None
+
+
+def _transcribe(monkeypatch, error, model="/models/ggml-small.bin"):
+    async def down(*args):
+        raise error
+
+    monkeypatch.setattr(transcribe_svc, "_recognize", down)
+    monkeypatch.setattr(offline, "OFFLINE_ASR_MODEL", model)
+    monkeypatch.setattr(offline, "_transcribe", lambda file, language: f"{file.getvalue().decode()} in {language}")
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+    return asyncio.run(transcribe_svc.transcribe_audio(upload, language="kannada"))
+
+
+def test_unreachable_asr_transcribes_locally(monkeypatch):
+    result = _transcribe(monkeypatch, StageError("asr", detail="ASR service unavailable", error_code="asr_unavailable"))
+    assert (result.text, result.language, result.confidence, result.alternatives) == ("RIFFdata in kannada", "kannada", None, None)
+
+
+def test_failing_asr_or_no_model_keeps_the_backend_error(monkeypatch):
+    with pytest.raises(StageError) as raised:
+        _transcribe(monkeypatch, StageError("asr", detail="ASR service returned HTTP 500"))
+    assert raised.value.detail == "ASR service returned HTTP 500"
+    with pytest.raises(StageTimeout):
+        _transcribe(monkeypatch, StageTimeout("asr"), model="")
+
+
+class DownClient:
+    def build_request(self, *args, **kwargs):
+        return None
+
+    async def send(self, request, stream=False):
+        raise httpx.ConnectError("refused")
+
+
+def test_unreachable_tts_speaks_locally(monkeypatch):
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", DownClient)
+    monkeypatch.setattr(offline, "OFFLINE_TTS_VOICES", {"kannada": "/m/kn.onnx"})
+    monkeypatch.setattr(offline, "_synthesize", lambda text, path: b"RIFF" + f"{text}@{path}".encode())
+    stream = asyncio.run(tts_svc.stream_tts("ನಮಸ್ಕಾರ", language="kannada"))
+    assert stream.content_type == "audio/wav"
+    assert asyncio.run(stream.read()) == b"RIFF" + "ನಮಸ್ಕಾರ@/m/kn.onnx".encode()
+
+    monkeypatch.setattr(offline, "_synthesize", lambda text, path: 1 / 0)
+    with pytest.raises(StageError) as raised:
+        asyncio.run(tts_svc.stream_tts("ನಮಸ್ಕಾರ", language="kannada"))
+    assert raised.value.error_code == "tts_unavailable"
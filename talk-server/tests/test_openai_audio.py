@@ -0,0 +1,74 @@
+import io
+
+from models import TranscriptionResponse
+from routers import audio as audio_router
+from services.tts import TTSStream
+
+_WAV_HEAD = b"RIFF\x24\x00\x00\x00WAVEfmt "
+
+
+def _fake_transcribe(monkeypatch, seen):
+    async def fake_transcribe(file, language=None, request_id=None, diarize=False):
+        seen["language"] = language
+        return TranscriptionResponse(text="  namaskara   hegiddira ", language="kannada")
+
+    monkeypatch.setattr(audio_router, "transcribe_audio", fake_transcribe)
+
+
+def test_transcription_returns_openai_json(client, monkeypatch):
+    seen = {}
+    _fake_transcribe(monkeypatch, seen)
+    res = client.post(
+        "/v1/audio/transcriptions",
+        data={"model": "whisper-1", "language": "kn"},
+        files={"file": ("a.mp3", io.BytesIO(b"\xff\xfb\x90\x00audio"), "audio/mpeg")},
+    )
+    assert res.status_code == 200
+    assert res.json() == {"text": "namaskara hegiddira"}
+    assert seen["language"] == "kannada"
+
+
+def test_transcription_text_and_verbose_formats(client, monkeypatch):
+    _fake_transcribe(monkeypatch, {})
+    files = {"file": ("a.mp3", io.BytesIO(b"audio"), "audio/mpeg")}
+    res = client.post("/v1/audio/transcriptions", data={"response_format": "text"}, files=files)
+    assert res.headers["content-type"].startswith("text/plain")
+    assert res.text == "namaskara hegiddira"
+    verbose = client.post("/v1/audio/transcriptions", data={"response_format": "verbose_json"}, files=files).json()
+    assert verbose["task"] == "transcribe" and verbose["language"] == "kannada"
+
+
+def test_transcription_rejects_unknown_language_and_format(client):
+    files = {"file": ("a.mp3", io.BytesIO(b"audio"), "audio/mpeg")}
+    assert client.post("/v1/audio/transcriptions", data={"language": "xx"}, files=files).status_code == 400
+    assert client.post("/v1/audio/transcriptions", data={"response_format": "srt"}, files=files).status_code == 400
+
+
+def test_speech_streams_tts_audio_in_the_requested_format(client, monkeypatch):
+    sent = {}
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        sent.update(text=text, language=language, audio_format=audio_format)
+        return TTSStream.cached("audio/wav", _WAV_HEAD)
+
+    monkeypatch.setattr(audio_router, "stream_tts", fake_stream_tts)
+    res = client.post(
+        "/v1/audio/speech", json={"model": "tts-1", "input": "Hello there", "voice": "hi", "response_format": "wav"}
+    )
+    assert res.status_code == 200
+    assert res.headers["content-type"] == "audio/wav"
+    assert res.content == _WAV_HEAD
+    assert sent == {"text": "Hello there", "language": "hindi", "audio_format": "wav"}
+
+
+def test_speech_ignores_openai_voice_names_and_rejects_unsupported_formats(client, monkeypatch):
+    sent = {}
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        sent["language"] = language
+        return TTSStream.cached("audio/mpeg", b"ID3audio")
+
+    monkeypatch.setattr(audio_router, "stream_tts", fake_stream_tts)
+    assert client.post("/v1/audio/speech", json={"input": "Hi", "voice": "alloy"}).status_code == 200
+    assert sent["language"] is None
+    assert client.post("/v1/audio/speech", json={"input": "Hi", "response_format": "flac"}).status_code == 400
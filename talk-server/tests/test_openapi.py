@@ -0,0 +1,22 @@
+from fastapi.testclient import TestClient
+
+import main
+
+
+client = TestClient(main.app)
+
+
+def test_openapi_documents_speech_endpoint_and_error_envelope():
+    spec = client.get("/openapi.json").json()
+    assert spec["openapi"].startswith("3.")
+    op = spec["paths"]["/v1/speech_to_speech"]["post"]
+    assert {"audio/mpeg", "audio/wav", "audio/ogg"} <= set(op["responses"]["200"]["content"])
+    assert op["responses"]["504"]["content"]["application/json"]["schema"]["$ref"].endswith("/ErrorResponse")
+    assert "ErrorResponse" in spec["components"]["schemas"]
+    assert "/v1/jobs/{job_id}" in spec["paths"]
+
+
+def test_swagger_ui_is_served():
+    res = client.get("/docs")
+    assert res.status_code == 200
+    assert "swagger" in res.text.lower()
@@ -0,0 +1,88 @@
+"""Tests for pacing calls toward each backend instance."""
+import asyncio
+import time
+
+import pytest
+
+from services import pacing
+from services.balancer import BackendPool
+from services.concurrency import ConcurrencyLimiter, PipelineBusy
+from services.pacing import Pacer
+
+
+def test_a_burst_is_spread_out_to_the_instances_pace():
+    async def run():
+        pacer = Pacer("llm", rps=20, burst=2, max_wait=1)
+        started = time.monotonic()
+        due = []
+
+        async def call():
+            async with pacer.slot():
+                due.append(time.monotonic() - started)
+
+        await asyncio.gather(*(call() for _ in range(4)))
+        return due
+
+    due = asyncio.run(run())
+    assert due[0] < 0.02 and due[1] < 0.02
+    assert 0.04 < due[2] < 0.09
+    assert 0.09 < due[3] < 0.14
+
+
+def test_a_call_that_would_wait_too_long_is_turned_away_unless_it_is_a_job():
+    async def run():
+        pacer = Pacer("tts", rps=10, max_wait=0.01)
+        async with pacer.slot():
+            pass
+        with pytest.raises(PipelineBusy) as exc:
+            async with pacer.slot():
+                pass
+        assert exc.value.status_code == 503 and exc.value.headers["Retry-After"]
+        started = time.monotonic()
+        async with ConcurrencyLimiter(0, 0).slot(block=True):
+            async with pacer.slot():
+                pass
+        return time.monotonic() - started
+
+    assert 0.05 < asyncio.run(run()) < 0.2
+
+
+def test_in_flight_calls_are_capped_per_instance(monkeypatch):
+    monkeypatch.setattr(pacing, "_pacers", {
+        ("llm", "http://llm-a"): Pacer("llm", max_concurrent=1, max_wait=0.01),
+        ("llm", "http://llm-b"): Pacer("llm", max_concurrent=1, max_wait=0.01),
+    })
+
+    async def run():
+        pool = BackendPool("llm", ("http://llm-a", "http://llm-b"), strategy="round_robin")
+        release = asyncio.Event()
+        served = []
+
+        async def call(url):
+            served.append(url)
+            await release.wait()
+            return url
+
+        first = asyncio.create_task(pool.call(call))
+        await asyncio.sleep(0)
+        second = asyncio.create_task(pool.call(call))
+        await asyncio.sleep(0)
+        # Both instances are busy, so a third call has to wait and gives up.
+        with pytest.raises(PipelineBusy):
+            await pool.call(call)
+        release.set()
+        return sorted([await first, await second]), served
+
+    results, served = asyncio.run(run())
+    assert results == ["http://llm-a", "http://llm-b"]
+    assert sorted(served) == ["http://llm-a", "http://llm-b"]
+
+
+def test_unpaced_stages_have_no_pacer(monkeypatch):
+    monkeypatch.setattr(pacing, "_pacers", {})
+    monkeypatch.setattr(pacing, "UPSTREAM_MAX_RPS", {"llm": 5})
+    monkeypatch.setattr(pacing, "UPSTREAM_MAX_CONCURRENT", {})
+    assert pacing.pacer("asr", "http://asr") is None
+    assert pacing.pacer("llm", "http://llm").rps == 5
+    assert pacing.pacer("llm", "http://llm") is pacing.pacer("llm", "http://llm")
+    assert pacing.pacer("llm", "http://other-llm") is not pacing.pacer("llm", "http://llm")
@@ -0,0 +1,31 @@
+import uuid
+
+import pyarrow.parquet as pq
+
+import conversation_store
+from auth_store import init_auth_db
+from conversation_store import record_turn
+from services import exporter
+
+
+def test_export_writes_partitioned_parquet_and_advances_watermark(tmp_path, monkeypatch):
+    init_auth_db()
+    monkeypatch.setattr(exporter, "_WATERMARK", f"test-{uuid.uuid4().hex}")
+    # Start from the newest existing turn so only this test's rows are exported.
+    latest = conversation_store.turns_after(0, 10**6)
+    conversation_store.export_watermark(exporter._WATERMARK)
+    if latest:
+        conversation_store.advance_export_watermark(exporter._WATERMARK, 0, latest[-1].id)
+
+    record_turn("k1", "llm", None, "hindi", "text", "namaste", "kaise ho", 100)
+    record_turn("k1", "llm", None, "hindi", "audio", "theek hoon", "accha", 200)
+
+    assert exporter.export_once(str(tmp_path), batch_size=1) == 2
+    assert exporter.export_once(str(tmp_path)) == 0
+
+    files = sorted(tmp_path.glob("date=*/tenant=default/*.parquet"))
+    assert len(files) == 2
+    table = pq.read_table(files[0])
+    assert "transcript" in table.column_names
+    assert "audio" not in " ".join(table.column_names)
+    assert table.column("reply").to_pylist() == ["kaise ho"]
@@ -0,0 +1,49 @@
+import pytest
+
+from routers import chat as chat_router
+from services import budget, personas, pipeline, prompts
+from services.personas import Persona, persona_from_spec
+from services.tts import TTSStream
+
+
+def test_persona_specs():
+    assert persona_from_spec("Be brief.", "short") == Persona("short", "Be brief.")
+    tutor = persona_from_spec({"instructions": " Teach. ", "max_reply_chars": 40}, "tutor")
+    assert tutor.instructions == "Teach." and tutor.max_reply_chars == 40
+    for spec in ({"instructions": ""}, {"instructions": "x", "max_reply_chars": -1}, ["x"]):
+        with pytest.raises(ValueError):
+            persona_from_spec(spec, "bad")
+
+
+def test_server_persona_sets_the_prompt(monkeypatch):
+    monkeypatch.setitem(personas.personas, "concierge", Persona("concierge", "You are a hotel concierge."))
+    monkeypatch.setattr(personas, "PERSONA", "concierge")
+    assert prompts.build_system_prompt("english").startswith("You are a hotel concierge. Always reply in English")
+    monkeypatch.setattr(personas, "PERSONA", "default")
+    monkeypatch.delenv("DWANI_SYSTEM_PROMPT", raising=False)
+    assert prompts.build_system_prompt() == prompts.DEFAULT_SYSTEM_PROMPT
+
+
+def test_request_persona_and_reply_length(client, monkeypatch):
+    prompts_seen = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts_seen.append(system_prompt)
+        return "First sentence here. Second sentence is much longer than the limit allows."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setitem(personas.personas, "tutor", Persona("tutor", "You are a patient tutor.", max_reply_chars=30))
+    monkeypatch.setattr(budget, "BUDGET_SHORTEN", False)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post("/v1/speech_to_speech", params={"persona": "tutor", "format": "json"}, data={"text": "hello"})
+    assert res.status_code == 200
+    assert prompts_seen == ["You are a patient tutor."]
+    assert res.json()["llm_response"] == "First sentence here."
+    assert res.json()["budget_hits"] == ["tts_chars"]
+
+    res = client.post("/v1/speech_to_speech", params={"persona": "pirate"}, data={"text": "hello"})
+    assert res.status_code == 400 and "persona must be one of" in res.json()["detail"]
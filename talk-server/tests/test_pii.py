@@ -0,0 +1,58 @@
+import asyncio
+import json
+
+import pytest
+
+from services import pii
+from services.hooks import HookContext, PipelineHooks
+
+
+@pytest.mark.parametrize(
+    "text, expected",
+    [
+        ("call me on 98450 12345 please", "call me on [PHONE] please"),
+        ("my number is +91-9845012345", "my number is [PHONE]"),
+        ("ನನ್ನ ಸಂಖ್ಯೆ ೯೮೪೫೦೧೨೩೪೫", "ನನ್ನ ಸಂಖ್ಯೆ [PHONE]"),
+        ("office +44 20 7946 0958", "office [PHONE]"),
+        ("aadhaar 2345 6789 0123 hai", "aadhaar [AADHAAR] hai"),
+        ("mail ravi.k+talk@example.co.in now", "mail [EMAIL] now"),
+        ("PAN ABCDE1234F", "PAN [PAN]"),
+        ("the order has 12 items for 450 rupees", "the order has 12 items for 450 rupees"),
+    ],
+)
+def test_pii_is_masked_by_type(text, expected):
+    redactor = pii.PIIRedactor(pii.load_patterns("", tuple(pii.PATTERNS)), audit_file="")
+    assert redactor.redact(text)[0] == expected
+
+
+def test_custom_types_and_unknown_ones(tmp_path):
+    patterns = tmp_path / "pii.txt"
+    patterns.write_text("# employee IDs\nemployee EMP-\\d{6}\n", encoding="utf-8")
+    redactor = pii.PIIRedactor(pii.load_patterns(str(patterns), ("email",)), mask="<{type}>", audit_file="")
+    assert redactor.redact("EMP-123456 and 98450 12345") == ("<EMPLOYEE> and 98450 12345", {"employee": 1})
+    with pytest.raises(ValueError):
+        pii.load_patterns("", ("passport",))
+    patterns.write_text("broken (\n", encoding="utf-8")
+    with pytest.raises(ValueError):
+        pii.load_patterns(str(patterns), ())
+
+
+def test_redactions_are_audited_without_the_values(tmp_path, monkeypatch):
+    audit = tmp_path / "audit.jsonl"
+    monkeypatch.setattr(pii, "PII_REDACT", True)
+    monkeypatch.setattr(pii, "redactor", pii.PIIRedactor(pii.load_patterns("", ("phone", "email")), audit_file=str(audit)))
+    registry = PipelineHooks()
+    assert pii.install(registry)
+    ctx = HookContext(request_id="req-1", session_id="s1")
+    text = asyncio.run(registry.run("after_asr", ctx, "call 9845012345 or 9845012346, or mail a@b.in"))
+    assert text == "call [PHONE] or [PHONE], or mail [EMAIL]"
+    assert asyncio.run(registry.run("before_llm", ctx, text)) == text
+    (event,) = [json.loads(line) for line in audit.read_text(encoding="utf-8").splitlines()]
+    assert event["types"] == {"phone": 2, "email": 1}
+    assert event["request_id"] == "req-1" and event["stage"] == "transcript" and event["session"] != "s1"
+    assert "9845012345" not in audit.read_text(encoding="utf-8")
+
+
+def test_nothing_is_installed_unless_enabled(monkeypatch):
+    monkeypatch.setattr(pii, "PII_REDACT", False)
+    assert not pii.install(PipelineHooks())
@@ -0,0 +1,38 @@
+import asyncio
+
+from services import backends
+from services import pipeline as pipeline_svc
+from services.pipeline import Pipeline, PipelineConfig, TurnResult
+from services.tts import TTSStream
+
+
+def test_backends_fall_back_to_env(monkeypatch):
+    monkeypatch.setenv("DWANI_API_BASE_URL_TTS", "http://env-tts/")
+    assert backends.tts_base_url() == "http://env-tts"
+    with backends.use_config(PipelineConfig(tts_base_url="http://embedded-tts")):
+        assert backends.tts_base_url() == "http://embedded-tts"
+    assert backends.tts_base_url() == "http://env-tts"
+
+
+def test_pipeline_runs_stages_against_its_own_backends(monkeypatch):
+    seen = {}
+
+    async def fake_converse(file=None, **kwargs):
+        seen["llm"] = backends.llm_base_url()
+        seen["audio"] = file.file.read()
+        return TurnResult(transcription="namaskara", reply="hegiddiri")
+
+    async def fake_stream_tts(text, request_id=None, language=None):
+        seen["tts"] = backends.tts_base_url()
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline_svc, "converse", fake_converse)
+    monkeypatch.setattr(pipeline_svc, "stream_tts", fake_stream_tts)
+
+    pipe = Pipeline(PipelineConfig(llm_base_url="http://llm", tts_base_url="http://tts"))
+    result = asyncio.run(pipe.speech_to_speech(audio=b"RIFF", language="kannada"))
+
+    assert seen == {"llm": "http://llm", "audio": b"RIFF", "tts": "http://tts"}
+    assert result.reply == "hegiddiri"
+    assert result.audio == b"mp3"
+    assert result.content_type == "audio/mpeg"
@@ -0,0 +1,120 @@
+import asyncio
+import struct
+
+import pytest
+from starlette.requests import ClientDisconnect
+
+from services import playback
+from services.playback import PlaybackResponse, delivered_ms, track_playback
+from services.tts import TTSStream
+
+
+class _BackendResponse:
+    headers = {"Content-Type": "audio/wav"}
+
+    def __init__(self):
+        self.closed = False
+
+    async def aclose(self):
+        self.closed = True
+
+
+def _wav_header(data_size, byte_rate=32000):
+    fmt = struct.pack("<HHIIHH", 1, 1, 16000, byte_rate, 2, 16)
+    return b"RIFF" + struct.pack("<I", min(36 + data_size, 0xFFFFFFFF)) + b"WAVEfmt " + struct.pack("<I", 16) + fmt + b"data" + struct.pack("<I", data_size)
+
+
+def _stream(chunk_count=10, chunk_size=3200):
+    backend = _BackendResponse()
+    produced = []
+
+    async def chunks():
+        for _ in range(chunk_count):
+            produced.append(chunk_size)
+            yield bytes(chunk_size)
+
+    stream = TTSStream(backend, _wav_header(chunk_count * chunk_size), chunks(), content_type="audio/wav")
+    return stream, backend, produced
+
+
+def test_delivered_ms_counts_the_samples_received():
+    assert delivered_ms(_wav_header(32000) + bytes(16000)) == 500
+    assert delivered_ms(_wav_header(0xFFFFFFFF) + bytes(3200)) == 100
+    assert delivered_ms(b"OggS" + bytes(100)) is None
+
+
+def test_on_finish_reports_partial_and_complete_relays():
+    seen = []
+
+    async def run():
+        stream, backend, _ = _stream()
+        stream.on_finish(lambda audio, complete: seen.append((len(audio), complete)))
+        chunks = stream.iter_bytes()
+        await chunks.__anext__()
+        await chunks.__anext__()
+        await chunks.__anext__()
+        await chunks.aclose()
+        assert backend.closed
+
+        stream, _, _ = _stream(chunk_count=2)
+        stream.on_finish(lambda audio, complete: seen.append((len(audio), complete)))
+        await stream.read()
+
+    asyncio.run(run())
+    assert seen == [(44 + 3200, False), (44 + 2 * 3200, True)]
+
+
+def test_disconnect_closes_the_backend_and_records_delivery(monkeypatch):
+    stored = []
+    monkeypatch.setattr(playback, "STORE_CONVERSATIONS", True)
+    monkeypatch.setattr(playback, "record_playback", lambda *args: stored.append(args))
+
+    async def run():
+        stream, backend, produced = _stream()
+        track_playback(stream, "request-1", store=True)
+        sent = []
+
+        async def send(message):
+            if message["type"] == "http.response.body" and len(sent) == 3:
+                raise OSError("client went away")
+            sent.append(message)
+
+        async def receive():
+            await asyncio.sleep(10)
+
+        scope = {"type": "http", "asgi": {"spec_version": "2.4"}, "method": "GET", "path": "/", "headers": []}
+        with pytest.raises(ClientDisconnect):
+            await PlaybackResponse(stream)(scope, receive, send)
+        assert backend.closed
+        assert len(produced) < 10  # the rest was never pulled from the backend
+        for _ in range(100):
+            if stored:
+                break
+            await asyncio.sleep(0.01)
+
+    asyncio.run(run())
+    request_id, delivered_bytes, ms, interrupted = stored[0]
+    assert (request_id, interrupted) == ("request-1", True)
+    assert delivered_bytes == 44 + 3200
+    assert ms == 100
+
+
+def test_chunks_are_sent_as_they_arrive():
+    async def run():
+        stream, _, _ = _stream(chunk_count=3)
+        sent = []
+
+        async def send(message):
+            sent.append(message)
+
+        async def receive():
+            await asyncio.sleep(10)
+
+        scope = {"type": "http", "asgi": {"spec_version": "2.4"}, "method": "GET", "path": "/", "headers": []}
+        await PlaybackResponse(stream)(scope, receive, send)
+        return sent
+
+    sent = asyncio.run(run())
+    assert (b"x-accel-buffering", b"no") in sent[0]["headers"]
+    bodies = [message for message in sent if message["type"] == "http.response.body"]
+    assert [len(message["body"]) for message in bodies] == [44, 3200, 3200, 3200, 0]
@@ -0,0 +1,138 @@
+"""Tests for silence trimming and loudness normalization before ASR."""
+import array
+import base64
+import io
+import json
+import math
+import struct
+import wave
+
+import pytest
+
+from services import preprocess
+from services import transcribe as transcribe_svc
+from services.hooks import hooks
+
+
+@pytest.fixture(autouse=True)
+def _clear_hooks():
+    hooks.clear()
+    yield
+    hooks.clear()
+
+
+def _wav(seconds: float, rate: int = 8000, amplitude: int = 300, quiet=()) -> bytes:
+    samples = []
+    for i in range(int(seconds * rate)):
+        t = i / rate
+        silent = any(lo <= t < hi for lo, hi in quiet)
+        samples.append(0 if silent else int(amplitude * math.sin(2 * math.pi * 440 * t)))
+    buf = io.BytesIO()
+    with wave.open(buf, "wb") as w:
+        w.setnchannels(1)
+        w.setsampwidth(2)
+        w.setframerate(rate)
+        w.writeframes(struct.pack(f"<{len(samples)}h", *samples))
+    return buf.getvalue()
+
+
+def _read(data: bytes):
+    with wave.open(io.BytesIO(data)) as reader:
+        return reader.getnframes(), array.array("h", reader.readframes(reader.getnframes()))
+
+
+def test_quiet_recording_is_trimmed_and_brought_up():
+    audio, stats = preprocess.preprocess_wav(
+        _wav(6.5, quiet=[(0, 2), (5, 6.5)]), trim_db=40, pad_ms=200, target_dbfs=-20, max_gain_db=30
+    )
+    assert stats.original_seconds == 6.5
+    assert stats.trimmed_seconds == 3.1
+    assert 23 < stats.gain_db < 24.5
+    frames, samples = _read(audio)
+    assert frames == int(3.4 * 8000)
+    assert 4400 < max(samples) < 4900
+
+
+def test_gain_is_capped_and_never_clips():
+    _, stats = preprocess.preprocess_wav(_wav(1, amplitude=30), trim=False, target_dbfs=-20, max_gain_db=12)
+    assert stats.gain_db == 12
+    audio, stats = preprocess.preprocess_wav(_wav(1, amplitude=20000), trim=False, target_dbfs=-3, max_gain_db=30)
+    assert max(_read(audio)[1]) <= 29205  # -1 dBFS
+    assert 3 < stats.gain_db < 3.5
+
+
+def test_steps_are_separate_and_unchanged_audio_is_returned_as_is():
+    data = _wav(3, quiet=[(0, 1)])
+    _, stats = preprocess.preprocess_wav(data, normalize=False)
+    assert stats.trimmed_seconds > 0 and stats.gain_db == 0
+    _, stats = preprocess.preprocess_wav(data, trim=False)
+    assert stats.trimmed_seconds == 0 and stats.gain_db > 0
+    loud = _wav(1, amplitude=4634)
+    assert preprocess.preprocess_wav(loud, target_dbfs=-20)[0] == loud
+    silence = _wav(1, amplitude=0)
+    assert preprocess.preprocess_wav(silence)[0] == silence
+    assert preprocess.preprocess_wav(b"\xff\xfb\x90\x00" * 100) is None
+
+
+def test_header_values_pick_steps():
+    assert preprocess.parse_steps("on") == {"trim", "normalize"}
+    assert preprocess.parse_steps(" Trim ") == {"trim"}
+    assert preprocess.parse_steps("off") == set()
+    with pytest.raises(ValueError):
+        preprocess.parse_steps("normalise")
+    assert preprocess.steps_name(frozenset({"normalize", "trim"})) == "trim,normalize"
+    preprocess.use_preprocessing("normalise")
+    assert preprocess.current_steps() == preprocess.DEFAULT_STEPS
+
+
+def _fake_asr(monkeypatch):
+    uploads = []
+
+    class FakeResponse:
+        status_code = 200
+
+        def json(self):
+            return {"choices": [{"message": {"content": "namaskara"}}]}
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            uploads.append(json.loads(b"".join([chunk async for chunk in content])))
+            return FakeResponse()
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    return uploads
+
+
+def _sent_audio(payload) -> bytes:
+    url = payload["messages"][0]["content"][0]["audio_url"]["url"]
+    return base64.b64decode(url.split(",", 1)[1])
+
+
+def test_uploads_are_preprocessed_when_the_request_asks(client, monkeypatch):
+    uploads = _fake_asr(monkeypatch)
+    monkeypatch.setattr(preprocess, "DEFAULT_STEPS", frozenset())
+    preprocess.install()
+    data = _wav(4, quiet=[(0, 2)])
+    files = {"file": ("call.wav", data, "audio/wav")}
+
+    assert client.post("/v1/audio/transcriptions", files=files).status_code == 200
+    assert _sent_audio(uploads[-1]) == data
+
+    res = client.post("/v1/audio/transcriptions", files=files, headers={"X-Audio-Preprocess": "trim,normalize"})
+    assert res.status_code == 200
+    frames, samples = _read(_sent_audio(uploads[-1]))
+    assert frames == int(2.2 * 8000)
+    assert max(samples) > 1000
+
+
+def test_deployment_steps_can_be_turned_off_per_request(client, monkeypatch):
+    uploads = _fake_asr(monkeypatch)
+    monkeypatch.setattr(preprocess, "DEFAULT_STEPS", frozenset(preprocess.STEPS))
+    preprocess.install()
+    data = _wav(4, quiet=[(0, 2)])
+    files = {"file": ("call.wav", data, "audio/wav")}
+
+    client.post("/v1/audio/transcriptions", files=files, headers={"X-Audio-Preprocess": "off"})
+    assert _sent_audio(uploads[-1]) == data
+    client.post("/v1/audio/transcriptions", files=files)
+    assert _sent_audio(uploads[-1]) != data
@@ -0,0 +1,52 @@
+import json
+
+from models import GenerationParams
+from routers import chat as chat_router
+from services import prompts
+
+
+def test_system_prompt_without_language_is_base_prompt(monkeypatch):
+    monkeypatch.delenv("DWANI_SYSTEM_PROMPT", raising=False)
+    assert prompts.build_system_prompt() == prompts.DEFAULT_SYSTEM_PROMPT
+
+
+def test_system_prompt_enforces_reply_language():
+    prompt = prompts.build_system_prompt("kannada")
+    assert "Always reply in Kannada" in prompt
+
+
+def test_language_templates_from_file(tmp_path, monkeypatch):
+    path = tmp_path / "prompts.json"
+    path.write_text(json.dumps({"hindi": "जवाब हमेशा {language} में दें।"}), encoding="utf-8")
+    monkeypatch.setenv("DWANI_PROMPTS_FILE", str(path))
+    monkeypatch.setattr(prompts, "_TEMPLATES", prompts._load_templates())
+    assert prompts.language_instruction("hindi") == "जवाब हमेशा Hindi (हिन्दी) में दें।"
+    assert prompts.language_instruction("tamil").startswith("Always reply in Tamil")
+
+
+def test_verbosity_modes_set_the_length_instruction_and_max_tokens(monkeypatch):
+    monkeypatch.delenv("DWANI_SYSTEM_PROMPT", raising=False)
+    assert prompts.build_system_prompt(verbosity="short") == prompts.DEFAULT_SYSTEM_PROMPT
+    assert prompts.build_system_prompt(verbosity="normal").startswith("Keep your reply to two or three short sentences.")
+    assert prompts.verbosity_generation(None, "short") is None
+    assert prompts.verbosity_generation(None, "detailed").max_tokens == 1024
+    assert prompts.verbosity_generation(GenerationParams(max_tokens=64), "detailed").max_tokens == 64
+    monkeypatch.setenv("DWANI_SYSTEM_PROMPT", "You are a museum guide.")
+    assert prompts.build_system_prompt() == "You are a museum guide."
+    assert prompts.build_system_prompt(verbosity="detailed").startswith("You are a museum guide. Give a complete answer")
+
+
+def test_request_verbosity_reaches_the_llm(client, monkeypatch):
+    seen = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append((system_prompt, generation.max_tokens if generation else None))
+        return "Here is a fuller answer."
+
+    monkeypatch.delenv("DWANI_SYSTEM_PROMPT", raising=False)
+    monkeypatch.setattr(chat_router, "call_llm", fake_call_llm)
+    res = client.post("/v1/chat", json={"text": "Tell me about Hampi", "verbosity": "normal"})
+    assert res.status_code == 200
+    assert seen[0][0].startswith("Keep your reply to two or three short sentences.") and seen[0][1] == 512
+    res = client.post("/v1/speech_to_speech", params={"verbosity": "chatty"}, data={"text": "hello"})
+    assert res.status_code == 400 and "verbosity must be one of" in res.json()["detail"]
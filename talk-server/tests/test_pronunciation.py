@@ -0,0 +1,71 @@
+import io
+import math
+import struct
+import wave
+
+from models import TranscriptionResponse
+from services import pronunciation as pronunciation_svc
+from services.pronunciation import align, score_reading
+
+
+def _wav(parts, rate=16000):
+    samples = []
+    for kind, seconds in parts:
+        for i in range(int(seconds * rate)):
+            samples.append(int(8000 * math.sin(2 * math.pi * 440 * i / rate)) if kind == "speech" else 0)
+    buf = io.BytesIO()
+    with wave.open(buf, "wb") as w:
+        w.setnchannels(1)
+        w.setsampwidth(2)
+        w.setframerate(rate)
+        w.writeframes(struct.pack(f"<{len(samples)}h", *samples))
+    return buf.getvalue()
+
+
+def test_words_are_scored_against_the_reference():
+    assessment = score_reading("ನಾನು ಶಾಲೆಗೆ ಹೋಗುತ್ತೇನೆ.", "ನಾನು ಅಂ ಶಾಲೆಗೆ ಹೋಗುತೇನೆ")
+    assert [(w.status, w.accuracy) for w in assessment.words] == [("correct", 100), ("correct", 100), ("mispronounced", 80)]
+    assert assessment.words[1].extra_before == ["ಅಂ"] and assessment.extra_words == ["ಅಂ"]
+    assert assessment.words[2].heard == "ಹೋಗುತೇನೆ"
+    assert assessment.completeness == 100 and assessment.fluency == 75
+
+
+def test_a_skipped_word_does_not_shift_the_rest():
+    assessment = score_reading("The cat sat on the mat.", "the cat on the the mat")
+    assert [w.status for w in assessment.words] == ["correct", "correct", "omitted", "correct", "correct", "correct"]
+    assert assessment.words[4].extra_before == ["the"]
+    assert assessment.completeness == 83
+    assert align(["a", "b"], []) == [(0, None), (1, None)]
+
+
+def test_pace_and_pauses_come_from_wav_audio():
+    steady = score_reading("one two three four five six", "one two three four five six", _wav([("silence", 1), ("speech", 4), ("silence", 1)]))
+    assert steady.words_per_minute == 90.0 and steady.long_pauses == 0 and steady.overall == 100
+    halting = score_reading(
+        "one two three four five six",
+        "one two three four five six",
+        _wav([("speech", 2), ("silence", 1), ("speech", 2), ("silence", 1), ("speech", 2)]),
+    )
+    assert halting.long_pauses == 2 and halting.fluency < steady.fluency
+    assert score_reading("one", "one").words_per_minute is None
+
+
+def test_pronunciation_endpoint(client, monkeypatch):
+    async def fake_transcribe(file, language=None, request_id=None):
+        assert language == "kannada"
+        return TranscriptionResponse(text="ನಾನು ಶಾಲೆಗೆ", language=language)
+
+    monkeypatch.setattr(pronunciation_svc, "transcribe_audio", fake_transcribe)
+    res = client.post(
+        "/v1/pronunciation",
+        params={"language": "kannada"},
+        files={"file": ("speech.wav", _wav([("speech", 1)]), "audio/wav")},
+        data={"reference_text": "ನಾನು ಶಾಲೆಗೆ ಹೋಗುತ್ತೇನೆ"},
+    )
+    assert res.status_code == 200
+    body = res.json()
+    assert [w["status"] for w in body["words"]] == ["correct", "correct", "omitted"]
+    assert body["transcription"] == "ನಾನು ಶಾಲೆಗೆ" and body["completeness"] == 67
+
+    res = client.post("/v1/pronunciation", files={"file": ("speech.wav", _wav([("speech", 1)]), "audio/wav")}, data={"reference_text": "?!"})
+    assert res.status_code == 400
@@ -0,0 +1,25 @@
+"""Keep proto/dwani/talk/v1/talk.proto in step with the JSON the server emits."""
+import os
+import re
+from dataclasses import fields
+
+from services.jobs import Job
+
+_PROTO = os.path.join(os.path.dirname(__file__), "..", "..", "proto", "dwani", "talk", "v1", "talk.proto")
+
+
+def _message_fields(name: str):
+    with open(_PROTO, encoding="utf-8") as fh:
+        src = fh.read()
+    body = re.search(r"message %s \{(.*?)\n\}" % name, src, re.S).group(1)
+    return set(re.findall(r'json_name = "(\w+)"', body))
+
+
+def test_job_message_matches_public_job_payload():
+    public = set(Job(id="x").public())
+    assert _message_fields("Job") == public
+
+
+def test_result_message_matches_job_result_keys():
+    assert _message_fields("SpeechToSpeechResult") == {"transcription", "llm_response", "audio_base64"}
+    assert {f.name for f in fields(Job)} - {"session_id"} == _message_fields("Job")
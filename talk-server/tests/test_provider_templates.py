@@ -0,0 +1,125 @@
+"""Tests for ASR and TTS provider request/response templates."""
+import asyncio
+import base64
+import json
+
+import pytest
+
+from services import provider_templates
+from services import transcribe as transcribe_svc
+from services import tts as tts_svc
+from services.mock import silent_mp3
+from services.provider_templates import extract, load_templates, render, template_from_spec
+from services.tts_cache import TTSCache
+
+
+def test_placeholders_are_filled_and_missing_values_left_out():
+    template = {"input": {"text": "{text}"}, "voice": "{voice}", "rate": "{speed}", "note": "say {text} in {language}", "fixed": 1}
+    assert render(template, {"text": "Namaskara", "voice": None, "speed": 1.5, "language": "kannada"}) == {
+        "input": {"text": "Namaskara"},
+        "rate": 1.5,
+        "note": "say Namaskara in kannada",
+        "fixed": 1,
+    }
+
+
+def test_response_paths_reach_into_objects_and_lists():
+    body = {"results": [{"alternatives": [{"transcript": "namaskara", "confidence": 0.9}]}]}
+    assert extract(body, "results[0].alternatives[0].transcript") == "namaskara"
+    assert extract(body, "results[1].alternatives[0].transcript") is None
+    assert extract(body, "results.transcript") is None
+
+
+def test_malformed_templates_are_rejected(tmp_path):
+    for stage, spec in (
+        ("tts", {"request": {"input": "{txt}"}}),
+        ("tts", {"headers": {}}),
+        ("asr", {"path": "/recognize"}),
+        ("asr", {"request": {"lang": "{language}"}}),
+        ("asr", {"response": {"language": "lang"}}),
+        ("tts", {"response": {"audio": "a..b"}}),
+    ):
+        with pytest.raises(ValueError):
+            template_from_spec(spec, stage, "test")
+    path = tmp_path / "templates.json"
+    path.write_text(json.dumps({"llm": []}))
+    with pytest.raises(ValueError):
+        load_templates(str(path))
+
+
+def _use(monkeypatch, stage, spec):
+    monkeypatch.setitem(provider_templates.templates, stage, [template_from_spec(spec, stage, "test")])
+
+
+def test_asr_backend_gets_its_own_body_and_its_reply_is_read(client, monkeypatch):
+    sent = []
+
+    class FakeResponse:
+        status_code = 200
+
+        def json(self):
+            return {"results": [{"transcript": "namaskara", "confidence": 0.92}], "lang": "kn-IN"}
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            sent.append((url, json.loads(b"".join([chunk async for chunk in content]))))
+            return FakeResponse()
+
+    monkeypatch.setenv("DWANI_CHAT_COMPLETIONS_URL", "http://vendor-asr/recognize")
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    _use(monkeypatch, "asr", {
+        "url": "http://vendor-asr",
+        "query": {"lang": "{language_code}"},
+        "request": {"audio": {"content": "{audio_base64}", "type": "{mime_type}"}, "language": "{language_tag}"},
+        "response": {"text": "results[0].transcript", "confidence": "results[0].confidence", "language": "lang"},
+    })
+
+    files = {"file": ("call.wav", b"RIFF-audio", "audio/wav")}
+    res = client.post("/v1/audio/transcriptions", files=files, data={"language": "kannada", "response_format": "verbose_json"})
+    assert res.status_code == 200
+    assert res.json()["text"] == "namaskara"
+    url, body = sent[-1]
+    assert url == "http://vendor-asr/recognize?lang=kn"
+    assert body == {"audio": {"content": base64.b64encode(b"RIFF-audio").decode(), "type": "audio/wav"}, "language": "kn-IN"}
+
+
+def test_tts_backend_gets_its_own_path_and_body_and_json_audio_is_decoded(monkeypatch):
+    audio = silent_mp3(0.5)
+    sent = []
+
+    class FakeResponse:
+        status_code = 200
+        headers = {"Content-Type": "application/json"}
+
+        def raise_for_status(self):
+            pass
+
+        async def aread(self):
+            return json.dumps({"audioContent": base64.b64encode(audio).decode()}).encode()
+
+        async def aclose(self):
+            pass
+
+    class FakeClient:
+        def build_request(self, method, url, **kwargs):
+            sent.append((url, kwargs["json"]))
+            return None
+
+        async def send(self, request, stream=False):
+            return FakeResponse()
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_TTS", "http://vendor-tts")
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: FakeClient())
+    _use(monkeypatch, "tts", {
+        "path": "/synthesize",
+        "request": {"input": {"text": "{text}"}, "voice": "{voice}", "languageCode": "{language_tag}"},
+        "response": {"audio": "audioContent"},
+    })
+
+    async def speak():
+        stream = await tts_svc.stream_tts("Namaskara", language="kannada")
+        return stream.content_type, await stream.read()
+
+    assert asyncio.run(speak()) == ("audio/mpeg", audio)
+    assert sent == [("http://vendor-tts/synthesize", {"input": {"text": "Namaskara"}, "languageCode": "kn-IN"})]
@@ -0,0 +1,46 @@
+"""Tests for byte ranges and gzip of JSON responses."""
+import pytest
+from fastapi import HTTPException
+
+from services.compression import accepts_gzip
+from services.ranges import byte_range
+
+
+@pytest.mark.parametrize(
+    "header,span",
+    [
+        ("bytes=0-99", (0, 100)),
+        ("bytes=500-", (500, 1000)),
+        ("bytes=-200", (800, 1000)),
+        ("bytes=-5000", (0, 1000)),
+        ("bytes=900-5000", (900, 1000)),
+        ("bytes=5-3", None),
+        ("bytes=0-1,5-6", None),
+        ("items=0-1", None),
+        (None, None),
+    ],
+)
+def test_single_byte_ranges_are_honoured(header, span):
+    assert byte_range(header, 1000) == span
+
+
+@pytest.mark.parametrize("header", ["bytes=1000-", "bytes=-0"])
+def test_ranges_past_the_end_are_not_satisfiable(header):
+    with pytest.raises(HTTPException) as exc:
+        byte_range(header, 1000)
+    assert exc.value.status_code == 416 and exc.value.headers == {"Content-Range": "bytes */1000"}
+
+
+def test_gzip_follows_accept_encoding():
+    assert accepts_gzip("gzip, deflate, br") and accepts_gzip("*")
+    assert not accepts_gzip("gzip;q=0") and not accepts_gzip("br") and not accepts_gzip(None)
+
+
+def test_large_json_responses_are_gzipped(client):
+    res = client.get("/openapi.json", headers={"Accept-Encoding": "gzip"})
+    assert res.headers["content-encoding"] == "gzip" and "Accept-Encoding" in res.headers["vary"]
+    assert res.json()["info"]["title"] == "dwani.ai API"
+    raw = client.get("/openapi.json", headers={"Accept-Encoding": "identity"})
+    assert "content-encoding" not in raw.headers
+    assert int(res.headers["content-length"]) < len(raw.content)
+    assert "content-encoding" not in client.get("/health", headers={"Accept-Encoding": "gzip"}).headers
@@ -0,0 +1,28 @@
+from fastapi.testclient import TestClient
+
+import main
+from ratelimit import TokenBucketLimiter, parse_rate
+
+
+def test_parse_rate():
+    assert parse_rate("120/minute") == (120.0, 2.0)
+    assert parse_rate("5/second") == (5.0, 5.0)
+    assert parse_rate("") is None
+
+
+def test_token_bucket_reports_wait_when_empty():
+    limiter = TokenBucketLimiter(capacity=2, refill_per_second=1)
+    assert limiter.acquire("a") == 0
+    assert limiter.acquire("a") == 0
+    assert limiter.acquire("a") > 0
+    assert limiter.acquire("b") == 0
+
+
+def test_api_key_bucket_returns_429_with_retry_after(monkeypatch):
+    monkeypatch.setattr(main, "key_limiter", TokenBucketLimiter(capacity=1, refill_per_second=0.1))
+    client = TestClient(main.app)
+    headers = {"X-API-Key": "noisy"}
+    client.get("/v1/jobs/missing", headers=headers)
+    res = client.get("/v1/jobs/missing", headers=headers)
+    assert res.status_code == 429
+    assert res.headers["Retry-After"] == "10"
@@ -0,0 +1,85 @@
+import io
+
+from services import read_aloud as read_svc
+from services.audio_format import mp3_duration, strip_id3
+from services.tts import TTSStream
+
+# One MPEG-1 Layer III frame: 128 kbps, 44.1 kHz, 417 bytes, 1152 samples.
+_FRAME = b"\xff\xfb\x90\x00" + b"\x00" * 413
+_ID3 = b"ID3\x03\x00\x00\x00\x00\x00\x04tag!"
+
+
+def test_mp3_duration_walks_frames_after_id3_tag():
+    assert abs(mp3_duration(_ID3 + _FRAME * 100) - 100 * 1152 / 44100) < 1e-6
+    assert strip_id3(_ID3 + _FRAME) == _FRAME
+    assert mp3_duration(b"not audio") is None
+
+
+def test_plain_text_headings_become_chapters():
+    chapters = read_svc.parse_document("# Intro\nHello there. How are you?\n\nChapter 2\nIt was over.")
+    assert [c.title for c in chapters] == ["Intro", "Chapter 2"]
+    assert chapters[0].paragraphs == ["Hello there. How are you?"]
+
+
+def test_html_skips_scripts_and_splits_at_headings():
+    html = "<html><head><style>p{}</style></head><body><p>Preface.</p><h1>One</h1><p>A &amp; B.</p><script>x()</script></body></html>"
+    chapters = read_svc.parse_document(html, "text/html")
+    assert [(c.title, c.paragraphs) for c in chapters] == [(None, ["Preface."]), ("One", ["A & B."])]
+
+
+def test_chunks_keep_whole_sentences_within_the_limit():
+    chunks = read_svc.chunk_sentences("One two. Three four five. Six.", max_chars=16)
+    assert chunks == ["One two.", "Three four five.", "Six."]
+    assert all(len(c) <= 20 for c in read_svc.chunk_sentences("word " * 50, max_chars=20))
+
+
+def _fake_tts(monkeypatch, tmp_path):
+    spoken = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        assert audio_format == "mp3"
+        spoken.append(text)
+        return TTSStream.cached("audio/mpeg", _ID3 + _FRAME * 10)
+
+    monkeypatch.setattr(read_svc, "stream_tts", fake_stream_tts)
+    monkeypatch.setattr(read_svc.read_artifacts, "directory", tmp_path)
+    return spoken
+
+
+def test_read_returns_playlist_and_stitched_audio(client, monkeypatch, tmp_path):
+    spoken = _fake_tts(monkeypatch, tmp_path)
+    res = client.post("/v1/read", data={"text": "# Intro\nFirst sentence. Second one.\n\n# Next\nThe end."})
+    assert res.status_code == 200
+    body = res.json()
+    assert spoken == ["Intro", "First sentence. Second one.", "Next", "The end."]
+    assert body["segments"] == 4
+    assert [c["title"] for c in body["chapters"]] == ["Intro", "Next"]
+    assert body["chapters"][1]["first_segment"] == 2
+
+    playlist = client.get(body["playlist_url"])
+    assert playlist.headers["content-type"].startswith("application/vnd.apple.mpegurl")
+    assert playlist.text.count("#EXTINF:0.261") == 4 and playlist.text.rstrip().endswith("#EXT-X-ENDLIST")
+    assert client.get(f"/v1/read/{body['id']}/segments/3.mp3").content == _ID3 + _FRAME * 10
+
+    audio = client.get(body["audio_url"]).content
+    assert audio == _ID3 + _FRAME * 40  # later ID3 tags are dropped when stitching
+
+    part = client.get(body["audio_url"], headers={"Range": "bytes=10-19"})
+    assert part.status_code == 206 and part.content == audio[10:20]
+    assert part.headers["content-range"] == f"bytes 10-19/{len(audio)}"
+    assert client.get(body["audio_url"], headers={"Range": f"bytes={len(audio)}-"}).status_code == 416
+
+
+def test_read_accepts_document_upload_and_audio_output(client, monkeypatch, tmp_path):
+    _fake_tts(monkeypatch, tmp_path)
+    doc = io.BytesIO(b"<h1>Title</h1><p>Body text.</p>")
+    res = client.post("/v1/read?output=audio", files={"file": ("doc.html", doc, "text/html")})
+    assert res.status_code == 200
+    assert res.headers["content-type"] == "audio/mpeg"
+    assert client.get(f"/v1/read/{res.headers['X-Read-Id']}").json()["segments"] == 2
+
+
+def test_read_rejects_missing_input_and_unknown_artifacts(client):
+    assert client.post("/v1/read").status_code == 400
+    assert client.get("/v1/read/0123456789abcdef0123456789abcdef/playlist.m3u8").status_code == 404
+    assert client.get("/v1/read/..%2f..%2fetc/audio.mp3").status_code == 404
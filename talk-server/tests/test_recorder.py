@@ -0,0 +1,99 @@
+import asyncio
+import base64
+
+import pytest
+from cryptography.exceptions import InvalidTag
+
+from services import pipeline, recorder as recorder_svc
+from services.hooks import HookContext, hooks
+from services.pipeline import audio_upload
+from services.recorder import ArtifactRecorder
+from services.tts import TTSStream
+from routers import chat as chat_router
+
+_ADMIN = {"X-Admin-Token": "admin-secret"}
+
+
+@pytest.fixture
+def recording(tmp_path, monkeypatch):
+    store = ArtifactRecorder(str(tmp_path), ttl_seconds=3600, max_requests=2)
+    monkeypatch.setattr(recorder_svc, "recorder", store)
+    monkeypatch.setattr(recorder_svc, "RECORD_ARTIFACTS", True)
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-secret")
+    hooks.clear()
+    assert recorder_svc.install(hooks) and not recorder_svc.install(hooks)
+    yield store
+    hooks.clear()
+
+
+def test_artifacts_are_numbered_per_request_and_pruned(tmp_path):
+    store = ArtifactRecorder(str(tmp_path), ttl_seconds=3600, max_requests=2)
+    ctx = HookContext(request_id="../escape", session_id="s1")
+    assert store.record(ctx, "transcript", b"hello", ".txt") == "00-transcript.txt"
+    assert store.record(ctx, "llm_reply", b"hi", ".txt") == "01-llm_reply.txt"
+    # Client-supplied request IDs that are not safe file names are hashed.
+    assert (tmp_path / store.dirname("../escape") / "01-llm_reply.txt").read_bytes() == b"hi"
+    assert store.manifest("../escape")["session_id"] == "s1"
+    assert store.artifact("../escape", "../../etc/passwd") is None
+    for request_id in ("r2", "r3"):
+        store.record(HookContext(request_id=request_id), "transcript", b"x", ".txt")
+    assert store.manifest("../escape") is None and [r["request_id"] for r in store.recent()] == ["r3", "r2"]
+
+
+def test_upload_and_tts_audio_are_recorded(recording):
+    ctx = HookContext(request_id="req-audio")
+    upload = audio_upload(b"RIFF\x00\x00\x00\x00WAVEfmt ", "audio/wav")
+
+    async def run():
+        assert await hooks.run("before_asr", ctx, upload) is upload
+        stream = await hooks.run("after_tts", ctx, TTSStream.cached("audio/mpeg", b"ID3\x04audio"))
+        assert await stream.read() == b"ID3\x04audio"
+
+    asyncio.run(run())
+    assert asyncio.run(upload.read())[:4] == b"RIFF"  # still readable by ASR
+    names = [a["name"] for a in recording.manifest("req-audio")["artifacts"]]
+    assert names == ["00-asr_input.wav", "01-tts_audio.mp3"]
+
+
+def test_turn_is_recorded_and_served_to_admins(client, recording, monkeypatch):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "Carry an umbrella."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/wav", b"RIFF")
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "Will it rain?"}, headers={"X-Request-ID": "bad-answer-1"})
+    assert res.status_code == 200
+
+    assert client.get("/admin/artifacts/bad-answer-1").status_code == 401
+    manifest = client.get("/admin/artifacts/bad-answer-1", headers=_ADMIN).json()
+    assert [a["stage"] for a in manifest["artifacts"]] == ["llm_input", "llm_reply"]
+    reply = client.get("/admin/artifacts/bad-answer-1/01-llm_reply.txt", headers=_ADMIN)
+    assert reply.text == "Carry an umbrella." and reply.headers["content-type"].startswith("text/plain")
+    listing = client.get("/admin/artifacts", headers=_ADMIN).json()
+    assert listing["recording"] and listing["requests"][0]["request_id"] == "bad-answer-1"
+    assert client.delete("/admin/artifacts/bad-answer-1", headers=_ADMIN).status_code == 204
+    assert client.get("/admin/artifacts/bad-answer-1", headers=_ADMIN).status_code == 404
+
+
+def test_artifacts_are_encrypted_at_rest(tmp_path):
+    key = bytes(range(32))
+    store = ArtifactRecorder(str(tmp_path), ttl_seconds=3600, max_requests=10, key=key)
+    ctx = HookContext(request_id="r1", session_id="s1")
+    name = store.record(ctx, "transcript", b"my number is 98450", ".txt")
+    on_disk = (tmp_path / "r1" / name).read_bytes()
+    assert b"98450" not in on_disk
+    assert store.read_artifact("r1", name) == b"my number is 98450"
+    assert store.manifest("r1")["artifacts"][0]["encrypted"] is True
+    with pytest.raises(InvalidTag):
+        ArtifactRecorder(str(tmp_path), key=bytes(32)).read_artifact("r1", name)
+    assert store.delete_session("s1") == 1 and store.manifest("r1") is None
+
+
+def test_encryption_key_must_be_32_bytes_of_base64():
+    assert recorder_svc.encryption_key("") is None
+    assert len(recorder_svc.encryption_key(base64.b64encode(bytes(32)).decode())) == 32
+    with pytest.raises(ValueError):
+        recorder_svc.encryption_key("c2hvcnQ=")
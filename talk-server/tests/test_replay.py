@@ -0,0 +1,96 @@
+import io
+
+import pytest
+
+from models import TranscriptionResponse
+from routers import audio as audio_router
+from routers import chat as chat_router
+from services import pipeline
+from services.errors import StageError
+from services.replay import ReplayGuard
+from services.tts import TTSStream
+
+
+@pytest.fixture
+def pipeline_calls(monkeypatch):
+    calls = []
+
+    async def fake_transcribe(file, language=None, request_id=None, diarize=False):
+        calls.append("asr")
+        return TranscriptionResponse(text="hello")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        calls.append("llm")
+        return "hi there"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        calls.append("tts")
+        return TTSStream.cached("audio/mpeg", b"ID3" + bytes(len(calls)))
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(audio_router, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    return calls
+
+
+def _guard(monkeypatch, action):
+    guard = ReplayGuard(window_seconds=30, action=action)
+    monkeypatch.setattr(chat_router, "replay_guard", guard)
+    monkeypatch.setattr(audio_router, "replay_guard", guard)
+    return guard
+
+
+def _post(client, audio=b"audio", session="replay-s1"):
+    return client.post(
+        "/v1/speech_to_speech",
+        params={"language": "kannada"},
+        files={"file": ("a.wav", io.BytesIO(audio), "audio/wav")},
+        headers={"X-Session-ID": session},
+    )
+
+
+def test_identical_upload_is_answered_from_the_first_response(client, pipeline_calls, monkeypatch):
+    _guard(monkeypatch, "cache")
+    first, again = _post(client), _post(client)
+    assert first.status_code == again.status_code == 200
+    assert again.headers["Idempotent-Replayed"] == "true" and again.content == first.content
+    assert pipeline_calls == ["asr", "llm", "tts"]
+    assert _post(client, audio=b"other audio").status_code == 200
+    assert _post(client, session="replay-s2").status_code == 200
+    assert pipeline_calls.count("asr") == 3
+
+
+def test_reject_action_refuses_the_copy_with_409(client, pipeline_calls, monkeypatch):
+    _guard(monkeypatch, "reject")
+    assert _post(client).status_code == 200
+    res = _post(client)
+    assert res.status_code == 409 and 1 <= int(res.headers["Retry-After"]) <= 30
+    assert pipeline_calls.count("asr") == 1
+
+
+def test_failed_requests_can_be_sent_again(client, pipeline_calls, monkeypatch):
+    async def failing_tts(text, request_id=None, language=None, audio_format=None):
+        raise StageError("tts", detail="TTS service unavailable")
+
+    _guard(monkeypatch, "reject")
+    monkeypatch.setattr(chat_router, "stream_tts", failing_tts)
+    assert _post(client).status_code == 502
+    assert _post(client).status_code == 502
+    assert pipeline_calls.count("asr") == 2
+
+
+def test_transcriptions_are_protected_too(client, pipeline_calls, monkeypatch):
+    _guard(monkeypatch, "reject")
+    files = {"file": ("a.wav", b"audio", "audio/wav")}
+    assert client.post("/v1/audio/transcriptions", files=files, data={"language": "hi"}).status_code == 200
+    assert client.post("/v1/audio/transcriptions", files=files, data={"language": "hi"}).status_code == 409
+    assert client.post("/v1/audio/transcriptions", files=files, data={"language": "ta"}).status_code == 200
+
+
+def test_typed_text_and_disabled_protection_run_every_time(client, pipeline_calls, monkeypatch):
+    _guard(monkeypatch, "reject")
+    for _ in range(2):
+        assert client.post("/v1/speech_to_speech", data={"text": "yes"}).status_code == 200
+    monkeypatch.setattr(chat_router, "replay_guard", ReplayGuard(window_seconds=0))
+    assert _post(client).status_code == _post(client).status_code == 200
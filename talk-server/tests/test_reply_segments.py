@@ -0,0 +1,71 @@
+"""Tests for segmented replies (services/reply_segments.py)."""
+import time
+
+import pytest
+from fastapi import HTTPException
+
+from routers import chat as chat_router
+from services import pipeline, reply_segments
+from services.concurrency import ConcurrencyLimiter
+from services.tts import TTSStream
+
+
+def test_replies_are_split_into_whole_sentences():
+    reply = "First sentence here. Second one? Third!\n\nA new paragraph. ಇದು ಕನ್ನಡ ವಾಕ್ಯ."
+    assert reply_segments.split_reply(reply, max_chars=30) == [
+        "First sentence here.", "Second one? Third!", "A new paragraph.", "ಇದು ಕನ್ನಡ ವಾಕ್ಯ.",
+    ]
+    assert reply_segments.split_reply("   ") == []
+
+
+def test_tokens_are_sealed_and_expire(monkeypatch):
+    monkeypatch.setenv("DWANI_REPLY_SEGMENT_SECRET", "segment-secret")
+    segment = reply_segments.Segment("Hello there.", "kannada", 0, 2, "req-1", int(time.time()) + 60)
+    token = reply_segments.seal(segment)
+    assert "Hello" not in token
+    assert reply_segments.open_token(token) == segment
+    for bad in (token[:-2] + ("A" if token[-2] != "A" else "B") + token[-1], "not-a-token"):
+        with pytest.raises(HTTPException) as exc:
+            reply_segments.open_token(bad)
+        assert exc.value.status_code == 404
+    expired = reply_segments.seal(reply_segments.Segment("Old.", None, 0, 1, None, int(time.time()) - 1))
+    with pytest.raises(HTTPException):
+        reply_segments.open_token(expired)
+    monkeypatch.setenv("DWANI_REPLY_SEGMENT_SECRET", "another-secret")
+    with pytest.raises(HTTPException):
+        reply_segments.open_token(token)
+
+
+def test_segments_are_synthesized_only_when_fetched(client, monkeypatch):
+    synthesized = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "One. Two. Three."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        synthesized.append((text, language))
+        return TTSStream.cached("audio/mpeg", text.encode())
+
+    monkeypatch.setenv("DWANI_REPLY_SEGMENT_SECRET", "segment-secret")
+    monkeypatch.setattr(reply_segments, "REPLY_SEGMENT_CHARS", 5)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    res = client.post("/v1/speech_to_speech?format=segments&language=kannada", data={"text": "Count to three"})
+    assert res.status_code == 200
+    body = res.json()
+    assert body["llm_response"] == "One. Two. Three." and body["segments_expire_at"] > time.time()
+    assert [segment["text"] for segment in body["segments"]] == ["One.", "Two.", "Three."]
+    assert synthesized == []
+    first = client.get(body["segments"][0]["url"])
+    assert first.content == b"One." and first.headers["X-Reply-Segment"] == "1/3"
+    assert synthesized == [("One.", "kannada")]
+    assert client.get("/v1/replies/segments/forged").status_code == 404
+
+
+def test_segments_wait_for_a_pipeline_slot(client, monkeypatch):
+    monkeypatch.setenv("DWANI_REPLY_SEGMENT_SECRET", "segment-secret")
+    token = reply_segments.seal(reply_segments.Segment("Hello.", None, 0, 1, None, int(time.time()) + 60))
+    full = ConcurrencyLimiter(max_concurrent=1, max_queue=0)
+    full._active = 1
+    monkeypatch.setattr(chat_router, "pipeline_limiter", full)
+    assert client.get(f"/v1/replies/segments/{token}").status_code == 503
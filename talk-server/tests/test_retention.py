@@ -0,0 +1,126 @@
+"""Tests for conversation retention, soft delete, legal hold and session erasure."""
+from contextlib import contextmanager
+from datetime import datetime, timedelta, timezone
+
+import pytest
+from sqlalchemy import create_engine
+from sqlalchemy.orm import sessionmaker
+
+import conversation_store
+import migrate
+from routers import conversations as conversations_router
+from services import erasure
+from services.hooks import HookContext
+from services.recorder import ArtifactRecorder
+from services.session import MemorySessionStore, append_to_session, get_session_context, session_digest, set_session_store
+
+ADMIN = {"X-Admin-Token": "admin-token"}
+
+
+@pytest.fixture
+def store(tmp_path, monkeypatch):
+    """conversation_store on a database of its own, so purges never touch other tests' turns."""
+    engine = create_engine(f"sqlite:///{tmp_path / 'talk.db'}")
+    migrate.apply_migrations(engine)
+    sessions = sessionmaker(bind=engine, expire_on_commit=False)
+
+    @contextmanager
+    def db_session():
+        db = sessions()
+        try:
+            yield db
+            db.commit()
+        except Exception:
+            db.rollback()
+            raise
+        finally:
+            db.close()
+
+    monkeypatch.setattr(conversation_store, "db_session", db_session)
+    monkeypatch.setattr(conversations_router, "STORE_CONVERSATIONS", True)
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+    for key in ("held", "kept", "gone"):
+        conversation_store.record_turn(key, "llm", None, "kannada", "text", f"{key} question", "answer", 100)
+    conversation_store.record_turn(None, "llm", None, "kannada", "text", "no session", "answer", 100)
+    return conversation_store
+
+
+def _later(days):
+    return datetime.now(timezone.utc) + timedelta(days=days)
+
+
+def test_soft_deleted_conversations_are_hidden_and_can_be_restored(store):
+    store.soft_delete("gone")
+    assert store.get_conversation("gone") is None
+    assert store.get_conversation("gone", include_deleted=True).turn_count == 1
+    assert {c.session_key for c in store.list_conversations()} == {"held", "kept"}
+    assert [t.transcript for t in store.list_turns()] == ["no session", "kept question", "held question"]
+
+    assert store.restore("gone") is not None
+    assert store.restore("gone") is None
+    assert store.get_conversation("gone").turn_count == 1
+
+
+def test_purge_erases_expired_turns_and_lapsed_deletions_but_not_held_ones(store):
+    store.set_legal_hold("held", True, "matter 42")
+    with pytest.raises(store.LegalHold):
+        store.soft_delete("held")
+    store.soft_delete("gone", now=_later(-31))
+
+    assert store.purge_conversations(0, 30) == {"expired": 0, "deleted": 1}
+    assert store.get_conversation("gone", include_deleted=True) is None
+    assert store.purge_conversations(90, 30, now=_later(91)) == {"expired": 2, "deleted": 0}
+    assert [c.session_key for c in store.list_conversations()] == ["held"]
+
+    store.set_legal_hold("held", False)
+    assert store.conversation_flags("held").hold_reason is None
+    assert store.purge_conversations(90, 30, now=_later(91)) == {"expired": 1, "deleted": 0}
+    assert store.conversation_flags("held") is None
+
+
+def test_admin_api_holds_deletes_and_restores(client, store):
+    assert client.get("/admin/conversations/kept").status_code == 401
+    assert client.get("/admin/conversations/unknown", headers=ADMIN).status_code == 404
+
+    res = client.put("/admin/conversations/kept/hold", json={"reason": "matter 42"}, headers=ADMIN)
+    assert res.status_code == 200
+    assert res.json()["legal_hold"] is True and res.json()["hold_reason"] == "matter 42"
+    assert client.delete("/admin/conversations/kept", headers=ADMIN).status_code == 409
+
+    client.delete("/admin/conversations/kept/hold", headers=ADMIN)
+    res = client.delete("/admin/conversations/kept", headers=ADMIN)
+    assert res.status_code == 200
+    assert res.json()["deleted_at"] and res.json()["erase_after"]
+    assert store.get_conversation("kept") is None
+
+    assert client.post("/admin/conversations/kept/restore", headers=ADMIN).json()["deleted_at"] is None
+    assert client.post("/admin/conversations/kept/restore", headers=ADMIN).status_code == 409
+    assert client.post("/admin/conversations/purge", headers=ADMIN).json() == {"purged": {"expired": 0, "deleted": 0}}
+
+
+def test_session_erasure_removes_history_turns_and_recordings(client, store, tmp_path, monkeypatch):
+    key = session_digest("user-1")
+    store.record_turn(key, "llm", None, "kannada", "text", "my number", "noted", 100)
+    monkeypatch.setattr(erasure, "STORE_CONVERSATIONS", True)
+    recordings = ArtifactRecorder(str(tmp_path / "recordings"), ttl_seconds=3600, max_requests=10)
+    monkeypatch.setattr(erasure, "recorder", recordings)
+    recordings.record(HookContext(request_id="r1", session_id="user-1"), "transcript", b"my number", ".txt")
+    recordings.record(HookContext(request_id="r2", session_id="someone-else"), "transcript", b"hi", ".txt")
+    set_session_store(MemorySessionStore())
+    try:
+        append_to_session("user-1", "my number", "noted")
+        res = client.delete("/v1/sessions/user-1")
+        assert res.status_code == 200
+        assert res.json() == {"session_id": "user-1", "erased": {"session": True, "conversation_turns": 1, "recordings": 1}}
+        assert get_session_context("user-1") == []
+        assert store.get_conversation(key, include_deleted=True) is None
+        assert recordings.manifest("r1") is None and recordings.manifest("r2") is not None
+
+        # Under legal hold nothing is erased, not even the session history.
+        store.record_turn(key, "llm", None, "kannada", "text", "again", "noted", 100)
+        store.set_legal_hold(key, True, "matter 42")
+        append_to_session("user-1", "again", "noted")
+        assert client.delete("/v1/sessions/user-1").status_code == 409
+        assert get_session_context("user-1") and store.get_conversation(key).turn_count == 1
+    finally:
+        set_session_store(None)
@@ -0,0 +1,76 @@
+"""Tests for the retrieval stage that grounds replies in the deployment's documents."""
+import asyncio
+
+import httpx
+
+from routers import chat as chat_router
+from services import pipeline, retrieval
+from services.retrieval import Passage, grounded, parse_passages
+from services.tts import TTSStream
+
+_SCHEME = "PM-KISAN pays eligible farmers 6000 rupees a year in three instalments."
+
+
+def test_passages_are_read_from_common_reply_shapes():
+    assert parse_passages(["one", " ", "two"]) == [Passage("one"), Passage("two")]
+    assert parse_passages({"results": [{"text": _SCHEME, "score": 0.9, "source": "schemes.pdf"}]}) == [
+        Passage(_SCHEME, 0.9, "schemes.pdf")
+    ]
+    qdrant = {"result": None, "matches": [{"score": 0.7, "payload": {"page_content": "Opening  hours:\n9 to 5", "title": "FAQ"}}]}
+    assert parse_passages(qdrant) == [Passage("Opening hours: 9 to 5", 0.7, "FAQ")]
+    elastic = {"hits": {"total": 1, "hits": [{"_score": 3.2, "_source": {"content": "Refunds take a week."}}]}}
+    assert parse_passages(elastic) == [Passage("Refunds take a week.", 3.2)]
+    assert parse_passages({"error": "no index"}) == [] and parse_passages("nothing") == []
+
+
+def test_passages_are_filtered_and_capped(monkeypatch):
+    monkeypatch.setattr(retrieval, "RETRIEVAL_MIN_SCORE", 0.5)
+    monkeypatch.setattr(retrieval, "RETRIEVAL_MAX_CHARS", 30)
+    passages = [Passage("a" * 20, 0.9), Passage("low", 0.1), Passage("b" * 20, 0.8)]
+    assert retrieval._selected(passages) == [Passage("a" * 20, 0.9)]
+    prompt = grounded("Be brief.", [Passage(_SCHEME, source="schemes.pdf")])
+    assert prompt.startswith("Be brief.\n\nReference passages") and prompt.endswith(f"[1] (schemes.pdf) {_SCHEME}")
+    assert grounded("Be brief.", []) == "Be brief."
+
+
+def _search(monkeypatch, reply):
+    sent = []
+
+    class FakeAsyncClient:
+        async def post(self, url, json=None, headers=None, timeout=None):
+            sent.append(json)
+            if isinstance(reply, Exception):
+                raise reply
+            return httpx.Response(200, json=reply, request=httpx.Request("POST", url))
+
+    monkeypatch.setattr(retrieval, "RETRIEVAL_URL", "http://search")
+    monkeypatch.setattr(retrieval, "http_client", FakeAsyncClient)
+    return sent
+
+
+def test_turns_are_grounded_in_the_retrieved_passages(client, monkeypatch):
+    prompts_seen = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts_seen.append(system_prompt)
+        return "It pays 6000 rupees a year."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    sent = _search(monkeypatch, {"passages": [{"text": _SCHEME, "source": "schemes.pdf"}]})
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+    res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "How much does PM-KISAN pay?"})
+    assert res.status_code == 200 and res.json()["llm_response"] == "It pays 6000 rupees a year."
+    assert sent[0]["query"] == "How much does PM-KISAN pay?" and sent[0]["top_k"] == 3
+    assert prompts_seen[0].endswith(f"[1] (schemes.pdf) {_SCHEME}")
+
+
+def test_failed_retrieval_answers_without_grounding(monkeypatch):
+    _search(monkeypatch, httpx.ConnectError("refused"))
+    assert asyncio.run(retrieval.retrieve("How much does PM-KISAN pay?")) == []
+    _search(monkeypatch, httpx.ReadTimeout("slow"))
+    assert asyncio.run(retrieval.retrieve("How much does PM-KISAN pay?")) == []
+    monkeypatch.setattr(retrieval, "RETRIEVAL_URL", "")
+    assert asyncio.run(retrieval.retrieve("How much does PM-KISAN pay?")) == []
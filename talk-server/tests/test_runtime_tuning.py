@@ -0,0 +1,27 @@
+import runtime_tuning
+
+
+def _fake_files(files):
+    return lambda path: files.get(path)
+
+
+def test_cgroup_v2_quota(monkeypatch):
+    monkeypatch.setattr(runtime_tuning, "_read", _fake_files({runtime_tuning._CGROUP_V2_CPU_MAX: "150000 100000"}))
+    assert runtime_tuning.cgroup_cpu_limit() == 1.5
+
+
+def test_cgroup_v2_unlimited(monkeypatch):
+    monkeypatch.setattr(runtime_tuning, "_read", _fake_files({runtime_tuning._CGROUP_V2_CPU_MAX: "max 100000"}))
+    assert runtime_tuning.cgroup_cpu_limit() is None
+
+
+def test_worker_count_rounds_quota_up(monkeypatch):
+    monkeypatch.delenv("DWANI_WORKERS", raising=False)
+    monkeypatch.setattr(runtime_tuning, "cgroup_cpu_limit", lambda: 1.5)
+    monkeypatch.setattr(runtime_tuning.os, "sched_getaffinity", lambda pid: set(range(16)), raising=False)
+    assert runtime_tuning.worker_count() == 2
+
+
+def test_worker_count_env_override(monkeypatch):
+    monkeypatch.setenv("DWANI_WORKERS", "5")
+    assert runtime_tuning.worker_count() == 5
@@ -0,0 +1,157 @@
+"""Tests for rejecting junk transcripts, echoed LLM replies and truncated TTS audio."""
+import asyncio
+import io
+import struct
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException, UploadFile
+
+from services import chat_svc, pipeline, sanity
+from services import transcribe as transcribe_svc
+from services import tts as tts_svc
+from services.messages import message
+from services.tts_cache import TTSCache
+
+
+def _wav(ms: int) -> bytes:
+    data = b"\x00\x00" * (16 * ms)
+    fmt = struct.pack("<HHIIHH", 1, 1, 16000, 32000, 2, 16)
+    return b"RIFF" + struct.pack("<I", 36 + len(data)) + b"WAVEfmt " + struct.pack("<I", 16) + fmt + b"data" + struct.pack("<I", len(data)) + data
+
+
+def test_words_and_echoes_are_told_apart():
+    assert not sanity.has_words(" ... ?! ")
+    assert sanity.has_words("ನಮಸ್ಕಾರ")
+    assert sanity.echoes_prompt("What is the weather today?", "what is the weather today", None)
+    assert not sanity.echoes_prompt("Hello!", "hello", None)
+    prompt = "You are a helpful voice assistant. Keep answers short, friendly and easy to follow when spoken aloud."
+    assert sanity.echoes_prompt(f"Sure. {prompt}", "tell me a joke", prompt)
+    assert not sanity.echoes_prompt("Why did the chicken cross the road?", "tell me a joke", prompt)
+
+
+def test_short_audio_is_measured_only_when_it_can_be():
+    assert sanity.audio_too_short(_wav(10))
+    assert not sanity.audio_too_short(_wav(200))
+    assert not sanity.audio_too_short(b"ID3audio")
+
+
+def test_punctuation_only_transcript_is_a_422(monkeypatch):
+    class FakeResponse:
+        status_code = 200
+
+        def json(self):
+            return {"choices": [{"message": {"content": " ... "}}]}
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            _ = [chunk async for chunk in content]
+            return FakeResponse()
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(transcribe_svc.transcribe_audio(upload))
+    assert exc.value.status_code == 422
+    assert exc.value.details == {"reason": "no_words"}
+
+
+def test_echoed_reply_is_retried(monkeypatch):
+    replies = ["what is the weather today", "It is sunny in Bengaluru."]
+
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            message = SimpleNamespace(content=replies.pop(0))
+            return SimpleNamespace(choices=[SimpleNamespace(message=message)])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, **kwargs):
+            self.chat = SimpleNamespace(completions=FakeCompletions())
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", FakeAsyncOpenAI)
+    monkeypatch.setattr(chat_svc, "GARBAGE_RETRIES", 1)
+    assert asyncio.run(chat_svc.call_llm("What is the weather today?")) == "It is sunny in Bengaluru."
+    assert not replies
+
+
+def _fake_llm(monkeypatch, replies, prompts):
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            prompts.append(kwargs["messages"][0]["content"])
+            message = SimpleNamespace(content=replies.pop(0))
+            return SimpleNamespace(choices=[SimpleNamespace(message=message)])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, **kwargs):
+            self.chat = SimpleNamespace(completions=FakeCompletions())
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", FakeAsyncOpenAI)
+    monkeypatch.setattr(chat_svc, "LLM_EMPTY_RETRIES", 1)
+
+
+def test_empty_reply_is_asked_for_again(monkeypatch):
+    prompts = []
+    _fake_llm(monkeypatch, ["  ", "It is sunny in Bengaluru."], prompts)
+    assert asyncio.run(chat_svc.call_llm("What is the weather today?")) == "It is sunny in Bengaluru."
+    assert chat_svc.EMPTY_NUDGE not in prompts[0]
+    assert prompts[1].endswith(chat_svc.EMPTY_NUDGE)
+
+
+def test_reply_that_stays_empty_fails_or_gets_the_fallback(monkeypatch):
+    _fake_llm(monkeypatch, ["", ""], [])
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(chat_svc.call_llm("What is the weather today?"))
+    assert exc.value.details == {"reason": "empty"}
+
+    _fake_llm(monkeypatch, ["", ""], [])
+    monkeypatch.setattr(pipeline, "LLM_EMPTY_FALLBACK", True)
+    turn = asyncio.run(pipeline.converse(text="What is the weather today?", language="hindi"))
+    assert turn.reply == message("no_reply", "hindi")
+
+
+def _fake_tts(monkeypatch, bodies):
+    class FakeResponse:
+        status_code = 200
+        headers = {"Content-Type": "audio/wav"}
+
+        def __init__(self, body):
+            self.body = body
+
+        def raise_for_status(self):
+            pass
+
+        async def aiter_bytes(self):
+            for i in range(0, len(self.body), 1024):
+                yield self.body[i:i + 1024]
+
+        async def aclose(self):
+            pass
+
+    class FakeClient:
+        def build_request(self, method, url, **kwargs):
+            return None
+
+        async def send(self, request, stream=False):
+            return FakeResponse(bodies.pop(0))
+
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: FakeClient())
+    monkeypatch.setattr(tts_svc, "GARBAGE_RETRIES", 1)
+
+
+def test_too_short_tts_audio_is_retried(monkeypatch):
+    bodies = [_wav(10), _wav(500)]
+    _fake_tts(monkeypatch, bodies)
+    stream = asyncio.run(tts_svc.stream_tts("hello"))
+    assert asyncio.run(stream.read()) == _wav(500)
+    assert not bodies
+
+
+def test_too_short_tts_audio_fails_after_retries(monkeypatch):
+    _fake_tts(monkeypatch, [_wav(10), _wav(20)])
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(tts_svc.stream_tts("hello"))
+    assert exc.value.status_code == 502
+    assert exc.value.details == {"reason": "short_audio"}
@@ -0,0 +1,95 @@
+import sys
+import types
+
+import pytest
+
+from services import session as session_svc
+from services.session import (
+    MemorySessionStore,
+    RedisSessionStore,
+    SQLiteSessionStore,
+    create_session_store,
+    set_session_store,
+)
+
+
+@pytest.fixture
+def sqlite_store(tmp_path):
+    store = SQLiteSessionStore(str(tmp_path / "sessions.db"))
+    set_session_store(store)
+    yield store
+    set_session_store(None)
+    store.close()
+
+
+def test_sqlite_history_and_settings_survive_a_restart(sqlite_store, monkeypatch):
+    monkeypatch.setattr(session_svc, "SESSION_MAX_HISTORY", 4)
+    for i in range(3):
+        session_svc.append_to_session("s1", f"question {i}", f"answer {i}")
+    session_svc.update_session_settings("s1", {"turn_detection": "patient"})
+    session_svc.update_session_settings("s1", {"slots": "{}"})
+
+    reopened = SQLiteSessionStore(sqlite_store.path)
+    assert [m["content"] for m in reopened.load_history("s1")] == ["question 1", "answer 1", "question 2", "answer 2"]
+    assert reopened.get_settings("s1") == {"turn_detection": "patient", "slots": "{}"}
+    assert reopened.load_history("s2") == [] and reopened.get_settings("s2") == {}
+    reopened.close()
+
+
+def test_replacing_the_last_turn(sqlite_store):
+    sqlite_store.append_turn("s1", "hi", "hello")
+    sqlite_store.replace_last_turn("s1", "hey", "hello again")
+    sqlite_store.replace_last_turn("s2", "first", "turn")
+    assert [m["content"] for m in sqlite_store.load_history("s1")] == ["hey", "hello again"]
+    assert [m["content"] for m in sqlite_store.load_history("s2")] == ["first", "turn"]
+
+
+def test_sqlite_entries_expire(tmp_path):
+    store = SQLiteSessionStore(str(tmp_path / "sessions.db"), ttl_seconds=-1)
+    store.append_turn("s1", "hi", "hello")
+    store.update_settings("s1", {"a": "1"})
+    assert store.load_history("s1") == []
+    assert store.get_settings("s1") == {}
+    store.close()
+
+
+@pytest.mark.parametrize("kind", ["memory", "sqlite"])
+def test_sessions_can_be_deleted(kind, tmp_path, monkeypatch):
+    monkeypatch.setattr(session_svc, "_session_store", {})
+    monkeypatch.setattr(session_svc, "_session_settings", {})
+    store = SQLiteSessionStore(str(tmp_path / "sessions.db")) if kind == "sqlite" else MemorySessionStore()
+    store.append_turn("s1", "hi", "hello")
+    store.update_settings("s1", {"slots": "{}"})
+    store.append_turn("s2", "other", "session")
+    assert store.delete_session("s1") is True
+    assert store.load_history("s1") == [] and store.get_settings("s1") == {}
+    assert store.delete_session("s1") is False
+    assert len(store.load_history("s2")) == 2
+
+
+def test_redis_store_falls_back_to_memory(monkeypatch):
+    monkeypatch.setattr(session_svc, "_session_redis", lambda session_id: None)
+    monkeypatch.setattr(session_svc, "_session_store", {})
+    store = RedisSessionStore()
+    store.append_turn("s1", "hi", "hello")
+    assert MemorySessionStore().load_history("s1") == store.load_history("s1")
+    assert len(store.load_history("s1")) == 2
+
+
+def test_store_is_chosen_by_name_or_import_path(monkeypatch):
+    assert isinstance(create_session_store("memory"), MemorySessionStore)
+    assert isinstance(create_session_store("redis"), RedisSessionStore)
+
+    class CustomStore(MemorySessionStore):
+        name = "custom"
+
+    module = types.ModuleType("custom_session_store")
+    module.CustomStore = CustomStore
+    module.Broken = dict
+    monkeypatch.setitem(sys.modules, "custom_session_store", module)
+    assert isinstance(create_session_store("custom_session_store:CustomStore"), CustomStore)
+    with pytest.raises(ValueError):
+        create_session_store("custom_session_store:Broken")
+    with pytest.raises(ValueError):
+        create_session_store("postgres")
+
@@ -0,0 +1,46 @@
+"""Tests for GET /v1/sessions/{id}/transcript and the timestamps it relies on."""
+import re
+
+from services import session as session_svc
+from services.session import MemorySessionStore, set_session_store
+
+
+def test_llm_context_leaves_the_timestamps_out(monkeypatch):
+    monkeypatch.setattr(session_svc, "_session_store", {})
+    set_session_store(MemorySessionStore())
+    try:
+        session_svc.append_to_session("s1", "ನಮಸ್ಕಾರ", "ನಮಸ್ಕಾರ! ಹೇಗಿದ್ದೀರಿ?")
+        assert session_svc.get_session_context("s1") == [
+            {"role": "user", "content": "ನಮಸ್ಕಾರ"},
+            {"role": "assistant", "content": "ನಮಸ್ಕಾರ! ಹೇಗಿದ್ದೀರಿ?"},
+        ]
+        (turn,) = session_svc.session_transcript("s1")
+        assert (turn["user"], turn["assistant"]) == ("ನಮಸ್ಕಾರ", "ನಮಸ್ಕಾರ! ಹೇಗಿದ್ದೀರಿ?")
+        assert re.fullmatch(r"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ", turn["at"])
+    finally:
+        set_session_store(None)
+
+
+def test_transcript_as_json_or_text(client, monkeypatch):
+    monkeypatch.setattr(session_svc, "_session_store", {})
+    set_session_store(MemorySessionStore())
+    try:
+        # History from before timestamps were kept has none.
+        session_svc._session_store["s1"] = [{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]
+        session_svc.append_to_session("s1", "what time is it", "It is noon.")
+        res = client.get("/v1/sessions/s1/transcript")
+        assert res.status_code == 200
+        turns = res.json()["turns"]
+        assert [(t["user"], t["assistant"]) for t in turns] == [("hi", "hello"), ("what time is it", "It is noon.")]
+        assert turns[0]["at"] is None and turns[1]["at"]
+
+        res = client.get("/v1/sessions/s1/transcript", params={"format": "text"})
+        assert res.headers["content-type"].startswith("text/plain")
+        lines = res.text.splitlines()
+        assert lines[:3] == ["User: hi", "Assistant: hello", ""]
+        assert lines[3] == f"[{turns[1]['at']}] User: what time is it"
+
+        assert client.get("/v1/sessions/unknown/transcript").status_code == 404
+        assert client.get("/v1/sessions/s1/transcript", params={"format": "csv"}).status_code == 400
+    finally:
+        set_session_store(None)
@@ -0,0 +1,129 @@
+"""Tests for mirroring ASR and TTS calls to a candidate backend."""
+import asyncio
+import io
+import json
+
+from fastapi import UploadFile
+
+from services import backends, shadow
+from services import transcribe as transcribe_svc
+from services import tts as tts_svc
+from services.tts_cache import TTSCache
+
+_WAV_HEAD = b"RIFF\x24\x00\x00\x00WAVEfmt "
+
+
+class FakeLogger:
+    def __init__(self):
+        self.records = []
+
+    def info(self, message, *args, extra=None):
+        self.records.append(extra)
+
+
+def _shadowing(monkeypatch, stage, url):
+    monkeypatch.setattr(backends, f"SHADOW_{stage.upper()}_URL", url)
+    monkeypatch.setattr(shadow, f"SHADOW_{stage.upper()}_URL", url)
+    monkeypatch.setattr(shadow, "SHADOW_PERCENT", 100)
+    log = FakeLogger()
+    monkeypatch.setattr(shadow, "logger", log)
+    return log
+
+
+async def _settled(call):
+    result = await call
+    await asyncio.gather(*shadow._running)
+    return result
+
+
+def test_transcription_is_mirrored_and_compared(monkeypatch):
+    log = _shadowing(monkeypatch, "asr", "http://asr.candidate/v1/chat/completions")
+    sent = []
+
+    class FakeResponse:
+        status_code = 200
+
+        def __init__(self, text):
+            self.text = text
+
+        def json(self):
+            return {"choices": [{"message": {"content": self.text}}]}
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            body = json.loads(b"".join([chunk async for chunk in content]))
+            sent.append((url, body["messages"][0]["content"][0]["audio_url"]["url"]))
+            return FakeResponse("namaskara" if "candidate" not in url else "namaskaara")
+
+    monkeypatch.setenv("DWANI_CHAT_COMPLETIONS_URL", "http://asr.primary/v1/chat/completions")
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    upload = UploadFile(file=io.BytesIO(b"RIFFdata"), size=8, filename="a.wav")
+
+    result = asyncio.run(_settled(transcribe_svc.transcribe_audio(upload, language="kannada")))
+    assert result.text == "namaskara"
+    assert [url for url, _ in sent] == ["http://asr.primary/v1/chat/completions", "http://asr.candidate/v1/chat/completions"]
+    assert sent[0][1] == sent[1][1]
+    record = log.records[0]
+    assert record["shadow_stage"] == "asr" and record["shadow_outcome"] == "ok"
+    assert 0.9 < record["shadow_agreement"] < 1 and "primary_seconds" in record
+
+
+def test_synthesis_is_mirrored_and_failures_stay_in_the_background(monkeypatch):
+    log = _shadowing(monkeypatch, "tts", "http://tts.candidate")
+    posted = []
+
+    class FakeStream:
+        status_code = 200
+        headers = {"Content-Type": "audio/wav"}
+
+        def raise_for_status(self):
+            pass
+
+        async def aiter_bytes(self):
+            yield _WAV_HEAD
+
+        async def aclose(self):
+            pass
+
+    class FakeHTML:
+        status_code = 200
+        headers = {"Content-Type": "text/html"}
+        content = b"<html>maintenance</html>"
+
+        def raise_for_status(self):
+            pass
+
+    class FakeClient:
+        def build_request(self, method, url, **kwargs):
+            return url
+
+        async def send(self, request, stream=False):
+            return FakeStream()
+
+        async def post(self, url, json=None, headers=None, timeout=None):
+            posted.append((url, json))
+            return FakeHTML()
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_TTS", "http://tts.primary")
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: FakeClient())
+
+    stream = asyncio.run(_settled(tts_svc.stream_tts("hello", audio_format="wav")))
+    assert stream.audio_format == "wav"
+    assert posted == [("http://tts.candidate/v1/audio/speech", {"text": "hello", "response_format": "wav"})]
+    assert log.records[0]["shadow_outcome"] == "error" and "non-audio" in log.records[0]["shadow_error"]
+
+
+def test_shadow_requests_are_dropped_when_too_many_run(monkeypatch):
+    monkeypatch.setattr(shadow, "SHADOW_MAX_CONCURRENT", 0)
+
+    async def call():
+        raise AssertionError("not run")
+
+    assert shadow.mirror("tts", call) is False
+
+
+def test_nothing_is_sampled_without_a_candidate(monkeypatch):
+    monkeypatch.setattr(shadow, "SHADOW_PERCENT", 100)
+    monkeypatch.setattr(shadow, "SHADOW_ASR_URL", "")
+    assert not shadow.sampled("asr")
@@ -0,0 +1,54 @@
+import time
+
+from starlette.requests import Request
+
+from services import sharding
+
+
+def _request(headers, method="POST", path="/v1/speech_to_speech"):
+    raw = [(k.lower().encode(), v.encode()) for k, v in headers.items()]
+    return Request({"type": "http", "method": method, "path": path, "headers": raw, "query_string": b""})
+
+
+def test_hash_ring_is_stable_and_uses_all_nodes():
+    ring = sharding.HashRing(["http://a:8000", "http://b:8000", "http://c:8000"])
+    owners = {ring.owner(f"session-{i}") for i in range(300)}
+    assert owners == set(ring.nodes)
+    assert ring.owner("session-42") == sharding.HashRing(reversed(ring.nodes)).owner("session-42")
+
+
+def test_removing_a_node_only_moves_its_keys():
+    full = sharding.HashRing(["a", "b", "c"])
+    reduced = sharding.HashRing(["a", "b"])
+    for i in range(300):
+        key = f"s{i}"
+        if full.owner(key) != "c":
+            assert reduced.owner(key) == full.owner(key)
+
+
+def test_session_owner_is_none_for_local_sessions(monkeypatch):
+    ring = sharding.HashRing(["http://a", "http://b"])
+    monkeypatch.setattr(sharding, "PEER_RING", ring)
+    monkeypatch.setattr(sharding, "SELF_URL", "http://a")
+    for i in range(50):
+        owner = sharding.session_owner(f"s{i}")
+        assert owner in (None, "http://b")
+        assert (owner is None) == (ring.owner(f"s{i}") == "http://a")
+
+
+def test_only_signed_recent_hops_count_as_forwarded(monkeypatch):
+    monkeypatch.setenv("DWANI_PEER_SECRET", "peer-secret")
+    monkeypatch.setattr(sharding, "PEER_RING", sharding.HashRing(["http://a", "http://b"]))
+    at = str(int(time.time()))
+    signed = {
+        sharding.FORWARDED_HEADER: "http://a",
+        sharding.FORWARDED_AT_HEADER: at,
+        sharding.SIGNATURE_HEADER: sharding._hop_signature("peer-secret", "POST", "/v1/speech_to_speech", "http://a", at),
+    }
+    assert sharding.forwarded_by_peer(_request(signed))
+    assert not sharding.forwarded_by_peer(_request({sharding.FORWARDED_HEADER: "http://a"}))
+    assert not sharding.forwarded_by_peer(_request(signed, path="/v1/chat"))
+    stale = {**signed, sharding.FORWARDED_AT_HEADER: str(int(time.time()) - 3600)}
+    assert not sharding.forwarded_by_peer(_request(stale))
+    monkeypatch.delenv("DWANI_PEER_SECRET")
+    assert not sharding.forwarded_by_peer(_request(signed))
@@ -0,0 +1,90 @@
+import time
+import uuid
+
+import pytest
+
+from auth_store import init_auth_db
+from conversation_store import list_turns, record_turn
+from routers import share as share_router
+from services import share as share_svc
+from services.tts import TTSStream
+
+
+@pytest.fixture
+def conversation(monkeypatch):
+    init_auth_db()
+    monkeypatch.setattr(share_router, "STORE_CONVERSATIONS", True)
+    key = uuid.uuid4().hex[:24]
+    record_turn(key, "llm", None, "kannada", "audio", "ನಮಸ್ಕಾರ", "<b>ಹೇಗಿದ್ದೀರಿ?</b>", 420)
+    return key
+
+
+def _share(client, key, **body):
+    res = client.post(f"/v1/conversations/{key}/share", json=body)
+    assert res.status_code == 200, res.text
+    return res.json()
+
+
+def test_link_renders_transcript_and_audio(client, conversation, monkeypatch):
+    spoken = []
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append((text, language))
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(share_router, "stream_tts", fake_stream_tts)
+    link = _share(client, conversation)
+    assert link["url"].endswith(f"/share/{link['token']}") and not link["passcode_protected"]
+
+    page = client.get(f"/share/{link['token']}")
+    assert page.status_code == 200
+    assert page.headers["Referrer-Policy"] == "no-referrer"
+    assert "ನಮಸ್ಕಾರ" in page.text and "&lt;b&gt;" in page.text  # stored text is escaped
+
+    turn_id = list_turns(conversation)[0].id
+    audio = client.get(f"/share/{link['token']}/audio/{turn_id}")
+    assert audio.content == b"mp3"
+    assert spoken == [("<b>ಹೇಗಿದ್ದೀರಿ?</b>", "kannada")]
+    assert client.get(f"/share/{link['token']}/audio/{turn_id + 10_000}").status_code == 404
+
+
+def test_passcode_protects_page_and_audio(client, conversation):
+    link = _share(client, conversation, passcode="4321")
+    token = link["token"]
+    assert link["passcode_protected"]
+
+    form = client.get(f"/share/{token}")
+    assert 'name="passcode"' in form.text and "ನಮಸ್ಕಾರ" not in form.text
+    assert client.post(f"/share/{token}", data={"passcode": "0000"}).status_code == 401
+    assert client.get(f"/share/{token}/audio/1").status_code == 401
+
+    page = client.post(f"/share/{token}", data={"passcode": "4321"})
+    assert page.status_code == 200 and "ನಮಸ್ಕಾರ" in page.text
+    assert token not in page.text  # audio links use a short-lived unlocked token
+
+
+def test_expired_and_forged_links_are_refused(client, conversation):
+    token, _ = share_svc.create_link(conversation, 60)
+    assert client.get(f"/share/{token[:-4]}AAAA").status_code == 404
+    assert client.get("/share/not-a-token").status_code == 404
+
+    expired = share_svc.sign(share_svc.ShareClaims(conversation, int(time.time()) - 1))
+    assert client.get(f"/share/{expired}").status_code == 410
+    locked = share_svc.ShareClaims(conversation, int(time.time()) - 1, passcode=True)
+    assert client.get(f"/share/{share_svc.sign(locked, '4321')}").status_code == 410
+
+
+def test_share_needs_a_stored_conversation(client, monkeypatch):
+    monkeypatch.setattr(share_router, "STORE_CONVERSATIONS", True)
+    init_auth_db()
+    assert client.post("/v1/conversations/unknown/share", json={}).status_code == 404
+    monkeypatch.setattr(share_router, "STORE_CONVERSATIONS", False)
+    assert client.post("/v1/conversations/unknown/share", json={}).status_code == 404
+
+
+def test_another_tenants_conversation_cannot_be_shared(client, monkeypatch):
+    monkeypatch.setattr(share_router, "STORE_CONVERSATIONS", True)
+    init_auth_db()
+    key = uuid.uuid4().hex[:24]
+    record_turn(key, "llm", None, "kannada", "text", "ನಮಸ್ಕಾರ", "ಹೇಗಿದ್ದೀರಿ?", 420, tenant="acme")
+    assert client.post(f"/v1/conversations/{key}/share", json={}).status_code == 404
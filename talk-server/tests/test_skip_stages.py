@@ -0,0 +1,81 @@
+"""Tests for skip_llm (echo mode) and skip_tts (text-only replies) on /v1/speech_to_speech."""
+import io
+
+from models import TranscriptionResponse
+from routers import chat as chat_router
+from services import pipeline
+from services.tts import TTSStream
+
+
+def _fake_stages(monkeypatch, calls):
+    async def fake_transcribe(file, language=None, request_id=None):
+        calls.append("asr")
+        return TranscriptionResponse(text="ನಾನು ಮನೆಗೆ ಹೋಗುತ್ತೇನೆ")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        calls.append("llm")
+        return "LLM reply"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        calls.append(f"tts:{text}")
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+
+def _upload():
+    return {"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")}
+
+
+def test_skip_llm_speaks_the_transcript_back(client, monkeypatch):
+    calls = []
+    _fake_stages(monkeypatch, calls)
+    appended = []
+    monkeypatch.setattr(pipeline, "append_to_session", lambda *args, **kwargs: appended.append(args))
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"language": "kannada", "skip_llm": "true"},
+        files=_upload(),
+        headers={"X-Session-ID": "practice-1"},
+    )
+    assert res.status_code == 200
+    assert res.content == b"mp3"
+    assert calls == ["asr", "tts:ನಾನು ಮನೆಗೆ ಹೋಗುತ್ತೇನೆ"]
+    # Practice turns are not conversation: the session is left as it was.
+    assert appended == []
+
+
+def test_skip_tts_returns_the_text_only(client, monkeypatch):
+    calls = []
+    _fake_stages(monkeypatch, calls)
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada", "skip_tts": "true"}, files=_upload())
+    assert res.status_code == 200
+    data = res.json()
+    assert (data["transcription"], data["llm_response"]) == ("ನಾನು ಮನೆಗೆ ಹೋಗುತ್ತೇನೆ", "LLM reply")
+    assert "audio_base64" not in data and "captions" not in data
+    assert calls == ["asr", "llm"]
+
+
+def test_skipping_both_stages_only_transcribes(client, monkeypatch):
+    calls = []
+    _fake_stages(monkeypatch, calls)
+    res = client.post(
+        "/v1/speech_to_speech", params={"language": "kannada", "skip_llm": "true", "skip_tts": "true"}, files=_upload()
+    )
+    assert res.json()["llm_response"] == res.json()["transcription"]
+    assert calls == ["asr"]
+
+
+def test_skip_flags_reject_conflicting_parameters(client, monkeypatch):
+    _fake_stages(monkeypatch, [])
+    for params in (
+        {"skip_tts": "true", "format": "wav"},
+        {"skip_tts": "true", "format": "ndjson"},
+        {"skip_tts": "true", "format": "json", "subtitles": "srt"},
+        {"skip_llm": "true", "grammar": "yes_no"},
+        {"skip_llm": "true", "input_language": "kannada"},
+    ):
+        res = client.post("/v1/speech_to_speech", params={"language": "english", **params}, files=_upload())
+        assert res.status_code == 400, params
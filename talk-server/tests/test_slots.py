@@ -0,0 +1,96 @@
+import asyncio
+import uuid
+
+import pytest
+
+from services import pipeline
+from services.grammar import grammar_from_spec
+from services.hooks import hooks
+from services.prompts import build_system_prompt
+from services.slots import get_slots, merge
+
+
+@pytest.fixture(autouse=True)
+def _clear_hooks():
+    hooks.clear()
+    yield
+    hooks.clear()
+
+
+def test_merge_validates_and_removes():
+    slots = merge({"party_size": 2}, {"time": " 7pm ", "party_size": None})
+    assert slots == {"time": "7pm"}
+    with pytest.raises(ValueError):
+        merge({}, {"party size": 2})
+    with pytest.raises(ValueError):
+        merge({}, {"guests": ["Ravi"]})
+
+
+def test_prompt_templates_read_slots(monkeypatch):
+    assert build_system_prompt(slots={"party_size": 2, "time": "7pm"}).endswith(
+        "Details the user has given so far: party size: 2; time: 7pm."
+    )
+    monkeypatch.setenv("DWANI_SYSTEM_PROMPT", "Table for {slots.party_size} at {slots.time}.")
+    assert build_system_prompt(slots={"party_size": 2}) == "Table for 2 at unknown."
+
+
+def test_slots_accumulate_across_agent_turns(monkeypatch):
+    replies = [
+        {"reply": "For how many?", "slots": {"time": "7pm"}},
+        {"reply": "Booked for 2 at 7pm.", "slots": {"party_size": 2}},
+        {"reply": "Noted.", "slots": {"bad name": "x", "party_size": 4}},
+    ]
+
+    async def fake_call_agent(agent_name, text, session_id=None, request_id=None):
+        return replies.pop(0)
+
+    monkeypatch.setattr(pipeline, "call_agent", fake_call_agent)
+    session = f"s-{uuid.uuid4()}"
+    asyncio.run(pipeline.converse(mode="agent", session_id=session, text="book a table at 7pm"))
+    asyncio.run(pipeline.converse(mode="agent", session_id=session, text="two people"))
+    assert get_slots(session) == {"time": "7pm", "party_size": 2}
+
+    asyncio.run(pipeline.converse(mode="agent", session_id=session, text="make it four"))
+    assert get_slots(session) == {"time": "7pm", "party_size": 2}  # an invalid update is dropped as a whole
+
+
+def test_llm_prompt_carries_slots_and_hooks_write_them(monkeypatch):
+    prompts = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        prompts.append(system_prompt)
+        return "Okay."
+
+    @hooks.on("after_llm")
+    def remember(ctx, reply):
+        ctx.slots["last_reply"] = reply
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    session = f"s-{uuid.uuid4()}"
+    asyncio.run(pipeline.converse(session_id=session, text="hello"))
+    asyncio.run(pipeline.converse(session_id=session, text="again"))
+    assert prompts[0] is None  # no slots yet: the usual prompt
+    assert "last reply: Okay." in prompts[1]
+    assert get_slots(session) == {"last_reply": "Okay."}
+
+
+def test_grammar_fills_its_slot():
+    city = grammar_from_spec({"choices": {"Bengaluru": ["bangalore"]}, "slot": "city"})
+    session = f"s-{uuid.uuid4()}"
+    asyncio.run(pipeline.converse(session_id=session, text="bangalore", grammar=city))
+    assert get_slots(session) == {"city": "Bengaluru"}
+    with pytest.raises(ValueError):
+        grammar_from_spec({"choices": ["a"], "slot": "no spaces allowed"})
+
+
+def test_slots_endpoints(client):
+    headers = {"X-Session-ID": f"s-{uuid.uuid4()}"}
+    res = client.patch("/v1/session/slots", json={"slots": {"party_size": 2, "time": "7pm"}}, headers=headers)
+    assert res.status_code == 200
+    res = client.patch("/v1/session/slots", json={"slots": {"time": None}}, headers=headers)
+    assert res.json() == {"slots": {"party_size": 2}}
+    assert client.get("/v1/session/slots", headers=headers).json() == {"slots": {"party_size": 2}}
+
+    assert client.patch("/v1/session/slots", json={"slots": {"x": {"nested": 1}}}, headers=headers).status_code == 422
+    assert client.delete("/v1/session/slots", headers=headers).json() == {"slots": {}}
+    assert client.get("/v1/session/slots").status_code == 400
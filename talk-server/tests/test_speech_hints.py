@@ -0,0 +1,61 @@
+"""Tests for the hints that come with a no_speech_detected error."""
+import asyncio
+import io
+import math
+import struct
+import wave
+
+from fastapi import UploadFile
+
+from models import TranscriptionResponse
+from services import pipeline, speech_hints
+
+
+def _wav(seconds: float, amplitude: int = 0, rate: int = 16000) -> bytes:
+    count = int(seconds * rate)
+    # Beyond 32767 the sine is clipped, as an overdriven microphone would be.
+    samples = [max(-32768, min(32767, round(amplitude * math.sin(2 * math.pi * 440 * i / rate)))) for i in range(count)]
+    out = io.BytesIO()
+    with wave.open(out, "wb") as writer:
+        writer.setnchannels(1)
+        writer.setsampwidth(2)
+        writer.setframerate(rate)
+        writer.writeframes(struct.pack(f"<{count}h", *samples))
+    return out.getvalue()
+
+
+def test_recordings_get_the_hints_that_fit():
+    assert speech_hints.check_wav(_wav(2, 8000)).hints == []
+    silent = speech_hints.check_wav(_wav(2))
+    assert (silent.hints, silent.level_dbfs, silent.duration_seconds) == (["silent"], None, 2.0)
+    assert speech_hints.check_wav(_wav(0.2, 8000)).hints == ["too_short"]
+    quiet = speech_hints.check_wav(_wav(2, 100))
+    assert quiet.hints == ["too_quiet"] and quiet.level_dbfs < -40
+    loud = speech_hints.check_wav(_wav(2, 65000))
+    assert loud.hints == ["clipped"] and loud.clipped_percent >= 1
+    assert speech_hints.check_wav(b"ID3 not a wav") is None
+
+
+def test_unreadable_audio_gets_no_hints(monkeypatch):
+    async def no_ffmpeg(data, target):
+        raise speech_hints.StageError("tts", detail="TTS audio could not be converted to pcm16")
+
+    monkeypatch.setattr(speech_hints, "transcode", no_ffmpeg)
+    upload = UploadFile(file=io.BytesIO(b"ID3audio"), size=8, filename="a.mp3")
+    assert asyncio.run(speech_hints.diagnose(upload)).as_details() == {
+        "hints": [], "duration_seconds": None, "level_dbfs": None, "clipped_percent": None,
+    }
+
+
+def test_no_speech_error_says_what_was_wrong(client, monkeypatch):
+    async def hears_nothing(file, language=None, request_id=None):
+        return TranscriptionResponse(text="")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", hears_nothing)
+    files = {"file": ("a.wav", io.BytesIO(_wav(1, 50)), "audio/wav")}
+    res = client.post("/v1/speech_to_speech", params={"language": "kannada"}, files=files)
+    assert res.status_code == 400
+    error = res.json()["error"]
+    assert error["error_code"] == "no_speech_detected"
+    assert error["details"]["hints"] == ["too_quiet"]
+    assert error["details"]["duration_seconds"] == 1.0
@@ -6,6 +6,8 @@ from fastapi.testclient import TestClient
 
 import main
 from routers import chat as chat_router
+from services import pipeline
+from services.tts import TTSStream
 
 
 @pytest.fixture
@@ -44,33 +46,15 @@ def test_speech_to_speech_returns_json_when_mocked(client: TestClient, monkeypat
     async def fake_transcribe(file, language, request_id=None):
         return TranscriptionResponse(text="hello")
 
-    async def fake_call_llm(user_text, context=None, request_id=None):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None):
         return "hi there"
 
-    class FakeTtsResponse:
-        status_code = 200
-        content = b"fake_mp3_bytes"
-        headers = {}
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"fake_mp3_bytes")
 
-        def raise_for_status(self):
-            pass
-
-    class FakeHttpClient:
-        def __init__(self, *args, **kwargs):
-            pass
-
-        async def __aenter__(self):
-            return self
-
-        async def __aexit__(self, *args):
-            pass
-
-        async def post(self, *args, **kwargs):
-            return FakeTtsResponse()
-
-    monkeypatch.setattr(chat_router, "transcribe_audio", fake_transcribe)
-    monkeypatch.setattr(chat_router, "call_llm", fake_call_llm)
-    monkeypatch.setattr("routers.chat.httpx.AsyncClient", FakeHttpClient)
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
 
     res = client.post(
         "/v1/speech_to_speech",
@@ -83,3 +67,63 @@ def test_speech_to_speech_returns_json_when_mocked(client: TestClient, monkeypat
     assert data.get("transcription") == "hello"
     assert data.get("llm_response") == "hi there"
     assert "audio_base64" in data
+
+
+def test_speech_to_speech_streams_tts_audio(client: TestClient, monkeypatch):
+    """Without format=json the TTS body is relayed chunk by chunk."""
+    from models import TranscriptionResponse
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="hello")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None):
+        return "hi there"
+
+    async def rest():
+        yield b"chunk-2"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream(None, b"chunk-1", rest(), content_type="audio/mpeg")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", fake_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"mode": "llm"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 200
+    assert res.content == b"chunk-1chunk-2"
+
+
+def test_speech_to_speech_text_input_skips_asr(client: TestClient, monkeypatch):
+    """A text form field replaces the audio upload and ASR is never called."""
+
+    async def failing_transcribe(file, language=None, request_id=None):
+        raise AssertionError("ASR must not run for text input")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None):
+        assert user_text == "ನಮಸ್ಕಾರ"
+        return "ನಮಸ್ಕಾರ!"
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "transcribe_audio", failing_transcribe)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"language": "kannada", "format": "json"},
+        data={"text": "ನಮಸ್ಕಾರ"},
+    )
+    assert res.status_code == 200
+    assert res.json()["transcription"] == "ನಮಸ್ಕಾರ"
+
+
+def test_speech_to_speech_requires_file_or_text(client: TestClient):
+    res = client.post("/v1/speech_to_speech", data={})
+    assert res.status_code == 400
@@ -0,0 +1,156 @@
+import asyncio
+import math
+import struct
+
+import pytest
+from starlette.websockets import WebSocketDisconnect
+
+from models import TranscriptionResponse
+from services import streaming
+from services.errors import StageError
+from services.turn_detection import PRESETS, Endpointer
+from services.wake_word import WakeWordGate, find_wake_word, parse_wake_words
+
+
+def _tone(ms, rate=16000):
+    samples = [int(8000 * math.sin(2 * math.pi * 440 * i / rate)) for i in range(rate * ms // 1000)]
+    return struct.pack(f"<{len(samples)}h", *samples)
+
+
+def _silence(ms, rate=16000):
+    return b"\x00\x00" * (rate * ms // 1000)
+
+
+def test_endpointer_reports_speech_start_and_stop():
+    preset = PRESETS["fast"]
+    endpointer = Endpointer(preset, sample_rate=16000)
+    events = endpointer.feed_events(_silence(500) + _tone(400) + _silence(preset.silence_ms + 100))
+    assert [e.type for e in events] == ["speech_started", "speech_stopped"]
+    started, stopped = events
+    assert started.at_seconds == 0.5 and stopped.at_seconds == 0.9
+    # The utterance keeps prefix_padding_ms of audio before the speech.
+    assert 0.5 - stopped.start_seconds >= preset.prefix_padding_ms / 1000
+    assert endpointer.feed_events(_tone(300))[0].type == "speech_started"
+    flushed = endpointer.flush()
+    assert flushed.type == "speech_stopped" and len(flushed.audio) >= len(_tone(300))
+    assert endpointer.flush() is None
+
+
+@pytest.fixture
+def fake_asr(monkeypatch):
+    calls = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        audio = await file.read()
+        calls.append((audio, language))
+        if len(calls) == 2:
+            raise StageError("asr", detail="ASR service unavailable")
+        return TranscriptionResponse(text=f"utterance {len(calls)}", language=language)
+
+    monkeypatch.setattr(streaming, "transcribe_audio", fake_transcribe)
+    return calls
+
+
+def test_server_cuts_utterances_and_transcribes_them(client, fake_asr):
+    with client.websocket_connect("/v1/transcribe/stream?language=kannada&turn_detection=fast") as ws:
+        ready = ws.receive_json()
+        assert ready["type"] == "ready" and ready["sample_rate"] == 16000 and ready["turn_detection"]["name"] == "fast"
+        audio = _silence(300) + _tone(400) + _silence(500) + _tone(400) + _silence(500)
+        for offset in range(0, len(audio), 1000):  # chunk sizes need not match frames
+            ws.send_bytes(audio[offset:offset + 1000])
+        ws.send_bytes(_tone(300))
+        ws.send_text('{"type": "end"}')
+        events = []
+        while True:
+            try:
+                events.append(ws.receive_json())
+            except WebSocketDisconnect:
+                break
+
+    kinds = [e["type"] for e in events]
+    assert kinds.count("speech_started") == 3 and kinds.count("speech_stopped") == 3
+    results = [e for e in events if e["type"] in ("transcription", "error")]
+    assert [(r["type"], r["utterance"]) for r in results] == [("transcription", 0), ("error", 1), ("transcription", 2)]
+    assert results[0]["text"] == "utterance 1" and 0.1 < results[0]["start_seconds"] < 0.3
+    assert results[1]["code"] == "502"
+    wav, language = fake_asr[0]
+    assert wav[:4] == b"RIFF" and struct.unpack("<I", wav[24:28])[0] == 16000 and language == "kannada"
+
+
+def test_stream_settings_and_api_key_are_checked(client, monkeypatch):
+    with client.websocket_connect("/v1/transcribe/stream?sample_rate=1000") as ws:
+        assert "sample_rate" in ws.receive_json()["message"]
+
+    monkeypatch.setenv("DWANI_API_KEY", "secret")
+    with pytest.raises(WebSocketDisconnect):
+        with client.websocket_connect("/v1/transcribe/stream") as ws:
+            ws.receive_json()
+    with client.websocket_connect("/v1/transcribe/stream?api_key=secret") as ws:
+        assert ws.receive_json()["type"] == "ready"
+
+
+def test_wake_words_match_loosely_and_split_off_the_command():
+    words = parse_wake_words("Hey Dwani!, ನಮಸ್ಕಾರ ದ್ವಾನಿ")
+    assert words == ["hey dwani", "ನಮಸ್ಕಾರ ದ್ವಾನಿ"]
+    assert find_wake_word("Hey dhwani, what time is it?", words) == ("hey dwani", "what time is it?")
+    assert find_wake_word("ನಮಸ್ಕಾರ ದ್ವಾನಿ। ಸಮಯ ಎಷ್ಟು?", words) == ("ನಮಸ್ಕಾರ ದ್ವಾನಿ", "ಸಮಯ ಎಷ್ಟು?")
+    assert find_wake_word("Um, hey Dwani.", words) == ("hey dwani", "")
+    assert find_wake_word("hey there", words) is None
+
+
+def test_only_the_command_after_a_wake_word_is_transcribed(client, monkeypatch):
+    heard = ["Good morning.", "Hey Dwani.", "What time is it?", "Is it open?", "Hey dwani, where is counter five?"]
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text=heard.pop(0), language=language)
+
+    monkeypatch.setattr(streaming, "transcribe_audio", fake_transcribe)
+    with client.websocket_connect("/v1/transcribe/stream?turn_detection=fast&wake_word=hey dwani") as ws:
+        assert ws.receive_json()["wake_words"] == ["hey dwani"]
+        for _ in range(5):
+            ws.send_bytes(_tone(400))
+            ws.send_text('{"type": "flush"}')
+        ws.send_text('{"type": "end"}')
+        events = []
+        while True:
+            try:
+                events.append(ws.receive_json())
+            except WebSocketDisconnect:
+                break
+
+    results = [e for e in events if e["type"] not in ("speech_started", "speech_stopped")]
+    assert [(e["type"], e.get("utterance"), e.get("text") or e.get("reason")) for e in results] == [
+        ("ignored", 0, None),
+        ("wake", 1, None),
+        ("transcription", 2, "What time is it?"),
+        ("sleep", None, "answered"),
+        ("ignored", 3, None),
+        ("wake", 4, None),
+        ("transcription", 4, "where is counter five?"),
+        ("sleep", None, "answered"),
+    ]
+
+
+def test_the_stream_sleeps_again_when_no_command_follows(monkeypatch):
+    sent = []
+
+    async def send(message):
+        sent.append(message)
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        return TranscriptionResponse(text="Hey Dwani", language=language)
+
+    async def run():
+        stream = streaming.StreamingTranscriber(send, PRESETS["fast"], wake=WakeWordGate(["hey dwani"], window_seconds=1))
+        await stream.start()
+        await stream.feed(_tone(400))
+        await stream.flush()
+        while not any(m["type"] == "wake" for m in sent):
+            await asyncio.sleep(0.01)
+        await stream.feed(_silence(1500))
+        await stream.close()
+
+    monkeypatch.setattr(streaming, "transcribe_audio", fake_transcribe)
+    asyncio.run(run())
+    assert [m["type"] for m in sent][-2:] == ["wake", "sleep"]
+    assert sent[-1]["reason"] == "timeout"
@@ -0,0 +1,112 @@
+"""Tests for encrypted audio frames on the streaming WebSocket."""
+import base64
+import json
+import math
+import struct
+
+import pytest
+from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PrivateKey
+from cryptography.hazmat.primitives.asymmetric.x25519 import X25519PrivateKey
+
+from models import TranscriptionResponse
+from services import stream_crypto, streaming
+from services.stream_crypto import FrameError, SessionCipher, decode_key, encode_key, public_bytes, server_session
+from services.stream_crypto import signing_public_bytes, verify_key_exchange
+
+_SEED = bytes(range(32))
+_PINNED = signing_public_bytes(Ed25519PrivateKey.from_private_bytes(_SEED))
+
+
+@pytest.fixture(autouse=True)
+def signing_key(monkeypatch):
+    monkeypatch.setenv("DWANI_STREAM_SIGNING_KEY", base64.b64encode(_SEED).decode("ascii"))
+
+
+def _pair():
+    client_key, server_key = X25519PrivateKey.generate(), X25519PrivateKey.generate()
+    client = SessionCipher(client_key, public_bytes(server_key), "client")
+    server = SessionCipher(server_key, public_bytes(client_key), "server")
+    return client, server
+
+
+def test_frames_decrypt_only_once_and_in_order():
+    client, server = _pair()
+    first, second = client.seal(b"pcm-1"), client.seal(b"pcm-2")
+    assert server.open(first) == b"pcm-1"
+    assert server.open(second) == b"pcm-2"
+    with pytest.raises(FrameError):
+        server.open(first)
+    assert json.loads(client.open(server.seal_json({"type": "ready"}))) == {"type": "ready"}
+
+    tampered = bytearray(client.seal(b"pcm-3"))
+    tampered[-1] ^= 1
+    with pytest.raises(FrameError):
+        server.open(bytes(tampered))
+    # A frame sealed for the server is not accepted back by the client that sent it.
+    with pytest.raises(FrameError):
+        client.open(client.seal(b"pcm-4"))
+
+
+def test_the_session_follows_the_servers_policy(monkeypatch):
+    key = encode_key(public_bytes(X25519PrivateKey.generate()))
+    assert decode_key(key + "==") == decode_key(key)
+    assert server_session(None, None) == (None, None)
+    cipher, message = server_session("x25519-aes256gcm", key)
+    assert cipher is not None and message["type"] == "key_exchange" and len(decode_key(message["public_key"])) == 32
+    for encryption, public_key in (("aes128", key), ("x25519-aes256gcm", None), ("x25519-aes256gcm", "c2hvcnQ")):
+        with pytest.raises(ValueError):
+            server_session(encryption, public_key)
+
+    monkeypatch.delenv("DWANI_STREAM_SIGNING_KEY")
+    with pytest.raises(ValueError):
+        server_session("x25519-aes256gcm", key)
+
+    monkeypatch.setattr(stream_crypto, "STREAM_ENCRYPTION", "required")
+    with pytest.raises(ValueError):
+        server_session(None, None)
+    monkeypatch.setattr(stream_crypto, "STREAM_ENCRYPTION", "off")
+    with pytest.raises(ValueError):
+        server_session("x25519-aes256gcm", key)
+
+
+def test_the_key_exchange_is_signed_with_the_pinned_key():
+    client_key = X25519PrivateKey.generate()
+    client_public = public_bytes(client_key)
+    _, message = server_session("x25519-aes256gcm", encode_key(client_public))
+    assert verify_key_exchange(message, client_public, _PINNED) == decode_key(message["public_key"])
+
+    # An edge that swaps in its own key cannot sign it, nor re-sign with a key of its own.
+    edge_key = Ed25519PrivateKey.generate()
+    swapped = {**message, "public_key": encode_key(public_bytes(X25519PrivateKey.generate()))}
+    resigned = {**swapped, "signature": encode_key(edge_key.sign(b"dwani-talk stream v2 key_exchange" + client_public))}
+    for forged in (swapped, resigned, {**message, "signature": ""}):
+        with pytest.raises(ValueError):
+            verify_key_exchange(forged, client_public, _PINNED)
+
+
+def test_encrypted_stream_carries_audio_and_results(client, monkeypatch):
+    heard = []
+
+    async def fake_transcribe(file, language=None, request_id=None):
+        heard.append(await file.read())
+        return TranscriptionResponse(text="namaskara", language=language)
+
+    monkeypatch.setattr(streaming, "transcribe_audio", fake_transcribe)
+    private_key = X25519PrivateKey.generate()
+    url = f"/v1/transcribe/stream?encryption=x25519-aes256gcm&public_key={encode_key(public_bytes(private_key))}"
+    with client.websocket_connect(url) as ws:
+        server_key = verify_key_exchange(ws.receive_json(), public_bytes(private_key), _PINNED)
+        cipher = SessionCipher(private_key, server_key, "client")
+        assert json.loads(cipher.open(ws.receive_bytes()))["type"] == "ready"
+        ws.send_text('{"type": "end"}')
+        assert json.loads(cipher.open(ws.receive_bytes()))["message"] == "controls must be encrypted frames"
+        tone = [int(8000 * math.sin(2 * math.pi * 440 * i / 16000)) for i in range(6400)]
+        ws.send_bytes(cipher.seal_audio(struct.pack(f"<{len(tone)}h", *tone)))
+        ws.send_bytes(cipher.seal_control({"type": "flush"}))
+        events = [json.loads(cipher.open(ws.receive_bytes())) for _ in range(3)]
+        assert [e["type"] for e in events] == ["speech_started", "speech_stopped", "transcription"]
+        assert events[-1]["text"] == "namaskara"
+
+        ws.send_bytes(struct.pack(">Q", 99) + b"\x00" * 32)
+        assert json.loads(cipher.open(ws.receive_bytes()))["message"] == "frame does not decrypt"
+    assert heard and heard[0][:4] == b"RIFF"
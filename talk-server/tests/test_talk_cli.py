@@ -0,0 +1,161 @@
+import asyncio
+import io
+import urllib.error
+
+import pytest
+
+import talk
+
+
+class _Response(io.BytesIO):
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        return False
+
+
+def _urlopen(body=None, error=None):
+    def fake(url, timeout=None, context=None):
+        if error is not None:
+            raise error
+        return _Response(body)
+    return fake
+
+
+def test_healthcheck_passes_when_ready(monkeypatch):
+    monkeypatch.setattr(talk.urllib.request, "urlopen", _urlopen(b'{"status": "ok", "checks": {}}'))
+    assert talk.main(["healthcheck"]) == 0
+
+
+def test_healthcheck_fails_when_degraded_or_unreachable(monkeypatch):
+    monkeypatch.setattr(talk.urllib.request, "urlopen", _urlopen(b'{"status": "degraded"}'))
+    assert talk.main(["healthcheck"]) == 1
+    monkeypatch.setattr(talk.urllib.request, "urlopen", _urlopen(error=urllib.error.URLError("refused")))
+    assert talk.main(["healthcheck"]) == 1
+
+
+def test_healthcheck_accepts_non_json_liveness(monkeypatch):
+    monkeypatch.setattr(talk.urllib.request, "urlopen", _urlopen(b"ok"))
+    assert talk.main(["healthcheck", "--url", "http://127.0.0.1:8000/health"]) == 0
+
+
+def test_local_url_follows_bind_and_tls(monkeypatch):
+    monkeypatch.setenv("DWANI_BIND", "0.0.0.0:9001")
+    monkeypatch.delenv("DWANI_TLS_CERT_FILE", raising=False)
+    assert talk.local_url() == "http://127.0.0.1:9001/ready"
+    monkeypatch.setenv("DWANI_TLS_CERT_FILE", "/certs/server.pem")
+    assert talk.local_url("/health") == "https://127.0.0.1:9001/health"
+
+
+def test_drain_posts_the_admin_token_and_reports_leftover_requests(monkeypatch):
+    sent = []
+
+    def fake(request, timeout=None, context=None):
+        sent.append((request.full_url, request.get_method(), request.get_header("X-admin-token")))
+        return _Response(b'{"draining": true, "idle": false, "in_flight": 2}')
+
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+    monkeypatch.delenv("DWANI_BIND", raising=False)
+    monkeypatch.delenv("DWANI_TLS_CERT_FILE", raising=False)
+    monkeypatch.setattr(talk.urllib.request, "urlopen", fake)
+    assert talk.main(["drain", "--timeout", "20"]) == 1
+    assert sent == [("http://127.0.0.1:8000/admin/drain?timeout=20", "POST", "admin-token")]
+    monkeypatch.setattr(talk.urllib.request, "urlopen", _urlopen(b'{"draining": true, "idle": true, "in_flight": 0}'))
+    assert talk.main(["drain"]) == 0
+
+
+def test_local_url_uses_the_unix_socket_without_a_tcp_listener(monkeypatch):
+    monkeypatch.delenv("DWANI_TLS_CERT_FILE", raising=False)
+    monkeypatch.setenv("DWANI_BIND", "unix:/run/talk/talk.sock, 127.0.0.1:9002")
+    assert talk.local_url() == "http://127.0.0.1:9002/ready"
+    monkeypatch.setenv("DWANI_BIND", "unix:/run/talk/talk.sock")
+    assert talk.local_url() == "http+unix://%2Frun%2Ftalk%2Ftalk.sock/ready"
+    monkeypatch.setenv("DWANI_BIND", "fd://3")
+    assert talk.local_url() == "http://127.0.0.1:8000/ready"
+
+
+def test_healthcheck_and_drain_over_a_unix_socket(monkeypatch, tmp_path):
+    import http.server
+    import socketserver
+    import threading
+
+    seen = []
+
+    class Handler(http.server.BaseHTTPRequestHandler):
+        def do_GET(self):
+            seen.append((self.command, self.path))
+            status = 200 if self.path == "/ready" else 503
+            self.send_response(status)
+            self.end_headers()
+            self.wfile.write(b'{"status": "ok"}' if status == 200 else b'{"status": "draining"}')
+
+        def do_POST(self):
+            seen.append((self.command, self.path, self.headers.get("X-Admin-Token")))
+            self.send_response(200)
+            self.end_headers()
+            self.wfile.write(b'{"draining": true, "idle": true, "in_flight": 0}')
+
+        def log_message(self, *args):
+            pass
+
+    class Server(socketserver.ThreadingMixIn, socketserver.UnixStreamServer):
+        daemon_threads = True
+
+    path = str(tmp_path / "talk.sock")
+    server = Server(path, Handler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    try:
+        monkeypatch.setenv("DWANI_BIND", f"unix:{path}")
+        monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+        assert talk.main(["healthcheck"]) == 0
+        assert talk.main(["healthcheck", "--url", talk.local_url("/health")]) == 1
+        assert talk.main(["drain", "--timeout", "5"]) == 0
+    finally:
+        server.shutdown()
+        server.server_close()
+    assert seen == [("GET", "/ready"), ("GET", "/health"), ("POST", "/admin/drain?timeout=5", "admin-token")]
+
+
+def test_http2_runs_hypercorn_and_needs_tls_or_h2c(monkeypatch):
+    monkeypatch.delenv("DWANI_HTTP2", raising=False)
+    assert talk.server_command(["--timeout", "60"])[:3] == ["gunicorn", "-c", "gunicorn.conf.py"]
+    monkeypatch.setenv("DWANI_HTTP2", "1")
+    monkeypatch.delenv("DWANI_TLS_CERT_FILE", raising=False)
+    monkeypatch.delenv("DWANI_H2C", raising=False)
+    with pytest.raises(SystemExit):
+        talk.server_command([])
+    monkeypatch.setenv("DWANI_H2C", "1")
+    assert talk.server_command([]) == ["hypercorn", "--config", "file:hypercorn.conf.py", "main:app"]
+
+
+def test_replay_diffs_recorded_turns_against_the_current_pipeline(monkeypatch, tmp_path, capsys):
+    from services.hooks import HookContext
+    from services.pipeline import SpeechResult
+    from services.recorder import ArtifactRecorder
+
+    recorder = ArtifactRecorder(str(tmp_path), key=None)
+    spoken = HookContext(request_id="spoken", language="kannada", mode="llm", input_type="audio")
+    recorder.record(spoken, "asr_input", b"RIFF-clip", ".wav")
+    recorder.record(spoken, "transcript", "ನಮಸ್ಕಾರ".encode(), ".txt")
+    recorder.record(spoken, "llm_reply", b"Hello there", ".txt")
+    recorder.record(spoken, "tts_input", b"Hello there", ".txt")
+    typed = HookContext(request_id="typed", mode="llm", input_type="text")
+    recorder.record(typed, "llm_input", b"What time is it?", ".txt")
+    recorder.record(typed, "llm_reply", b"It is noon", ".txt")
+    seen = []
+
+    class FakePipeline:
+        async def speech_to_speech(self, audio=None, mime_type=None, text=None, mode=None, agent_name=None, language=None):
+            seen.append((audio, mime_type, text, language))
+            if audio is not None:
+                return SpeechResult("ನಮಸ್ಕಾರ", "Hello there", b"", "audio/mpeg", language)
+            return SpeechResult(text, "It is half past twelve", b"", "audio/mpeg", language)
+
+    monkeypatch.setattr(talk, "run_pipeline", lambda mock, call: asyncio.run(call(FakePipeline())))
+    assert talk.main(["replay", "--dir", str(tmp_path), "spoken", "typed", "gone"]) == 1
+    out = capsys.readouterr().out.splitlines()
+    assert seen == [(b"RIFF-clip", "audio/wav", None, "kannada"), (None, "audio/wav", "What time is it?", None)]
+    assert out[0] == "same spoken" and out[1] == "changed typed"
+    assert "  -It is noon" in out and "  +It is half past twelve" in out
+    assert out[-1].startswith("failed gone")
@@ -0,0 +1,143 @@
+import asyncio
+import base64
+import json
+
+import httpx
+import pytest
+
+from talk_client import StreamSession, TalkClient, TalkError
+
+
+def _client(handler, **kwargs):
+    return TalkClient("http://talk.test", api_key="k", backoff=0, http_client=httpx.AsyncClient(transport=httpx.MockTransport(handler)), **kwargs)
+
+
+def test_speech_to_speech_sends_multipart_and_decodes_the_reply():
+    seen = []
+
+    def handler(request):
+        seen.append(request)
+        body = {"transcription": "hello", "llm_response": "hi", "audio_base64": base64.b64encode(b"ID3").decode(), "audio_content_type": "audio/mpeg"}
+        return httpx.Response(200, json=body, headers={"X-Request-ID": "req-1"})
+
+    result = asyncio.run(_client(handler).speech_to_speech(b"RIFF", language="kannada", session_id="s-1"))
+    assert (result.transcription, result.reply, result.audio, result.request_id) == ("hello", "hi", b"ID3", "req-1")
+    request = seen[0]
+    assert request.url.path == "/v1/speech_to_speech"
+    assert request.url.params["format"] == "json" and request.url.params["language"] == "kannada"
+    assert request.headers["X-API-Key"] == "k" and request.headers["X-Session-ID"] == "s-1"
+    assert request.headers["Idempotency-Key"]
+    assert b'name="file"; filename="audio.wav"' in request.content
+
+
+def test_retries_honour_retry_after_and_keep_the_idempotency_key(monkeypatch):
+    sleeps, keys = [], []
+
+    async def sleep(seconds):
+        sleeps.append(seconds)
+
+    monkeypatch.setattr(asyncio, "sleep", sleep)
+
+    def handler(request):
+        keys.append(request.headers["Idempotency-Key"])
+        if len(keys) == 1:
+            return httpx.Response(503, headers={"Retry-After": "2"})
+        return httpx.Response(200, json={"transcription": "a", "llm_response": "b", "audio_base64": ""})
+
+    asyncio.run(_client(handler).speech_to_speech(text="hello"))
+    assert sleeps == [2.0]
+    assert len(keys) == 2 and keys[0] == keys[1]
+
+
+def test_errors_carry_the_envelope_and_are_not_retried():
+    calls = []
+
+    def handler(request):
+        calls.append(request)
+        error = {"code": "400", "message": "Unsupported audio", "stage": "audio", "request_id": "req-2", "error_code": "unsupported_audio"}
+        return httpx.Response(400, json={"error": error, "detail": "Unsupported audio"})
+
+    with pytest.raises(TalkError) as caught:
+        asyncio.run(_client(handler).transcribe(b"RIFF"))
+    assert len(calls) == 1
+    error = caught.value
+    assert (error.status_code, error.error_code, error.stage, error.request_id) == (400, "unsupported_audio", "audio", "req-2")
+
+
+def test_exhausted_retries_raise_the_last_error():
+    def handler(request):
+        return httpx.Response(429, json={"detail": "Too many requests"})
+
+    with pytest.raises(TalkError) as caught:
+        asyncio.run(_client(handler, retries=1).synthesize("hello"))
+    assert caught.value.status_code == 429 and caught.value.message == "Too many requests"
+
+
+def test_deadline_is_sent_as_x_deadline_ms():
+    seen = []
+
+    def handler(request):
+        seen.append(request)
+        return httpx.Response(200, content=b"ID3")
+
+    assert asyncio.run(_client(handler).synthesize("hello", language="hindi", deadline=5)) == b"ID3"
+    assert 0 < int(seen[0].headers["X-Deadline-Ms"]) <= 5000
+    assert json.loads(seen[0].content) == {"input": "hello", "response_format": "mp3", "language": "hindi"}
+
+
+def test_stream_url_and_events():
+    client = TalkClient("https://talk.test", api_key="k")
+    assert client.stream(language="kannada", wake_word="hey dwani").url == (
+        "wss://talk.test/v1/transcribe/stream?sample_rate=16000&language=kannada&wake_word=hey+dwani"
+    )
+
+    class FakeWebSocket:
+        def __init__(self):
+            self.sent = []
+            self.incoming = [json.dumps({"type": "ready"}), json.dumps({"type": "transcription", "text": "hello"}), None]
+
+        async def send(self, message):
+            self.sent.append(message)
+
+        async def recv(self):
+            return self.incoming.pop(0)
+
+        async def close(self):
+            pass
+
+    ws = FakeWebSocket()
+
+    async def connect(url, headers):
+        assert headers == {"X-API-Key": "k"}
+        return ws
+
+    async def run():
+        async with StreamSession("wss://talk.test/v1/transcribe/stream", {"X-API-Key": "k"}, connect=connect) as stream:
+            await stream.send_audio(b"\x00\x00")
+            await stream.end()
+            return stream.ready, [event async for event in stream]
+
+    ready, events = asyncio.run(run())
+    assert ready == {"type": "ready"}
+    assert events == [{"type": "transcription", "text": "hello"}]
+    assert ws.sent == [b"\x00\x00", '{"type": "end"}']
+
+
+def test_rejected_stream_raises():
+    class Rejecting:
+        async def recv(self):
+            return json.dumps({"type": "error", "code": "400", "message": "unknown turn_detection preset", "error_code": "invalid_request"})
+
+        async def close(self):
+            pass
+
+    async def connect(url, headers):
+        return Rejecting()
+
+    async def run():
+        async with StreamSession("ws://talk.test", {}, connect=connect):
+            pass
+
+    with pytest.raises(TalkError) as caught:
+        asyncio.run(run())
+    assert caught.value.error_code == "invalid_request"
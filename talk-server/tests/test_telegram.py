@@ -0,0 +1,138 @@
+import asyncio
+
+from services import telegram
+from services.pipeline import TurnResult
+from services.tts import TTSStream
+
+
+class FakeResponse:
+    def __init__(self, body=None, content=b""):
+        self._body = body
+        self.content = content
+        self.status_code = 200
+
+    def json(self):
+        return self._body
+
+    def raise_for_status(self):
+        pass
+
+
+class FakeBotAPI:
+    """Answers the Bot API methods the bot calls and records what it sent."""
+
+    def __init__(self):
+        self.calls = []
+
+    async def post(self, url, json=None, data=None, files=None, timeout=None):
+        method = url.rsplit("/", 1)[1]
+        self.calls.append((method, json if files is None else data, files))
+        if method == "getFile":
+            return FakeResponse({"ok": True, "result": {"file_path": "voice/file_1.oga", "file_size": 4}})
+        return FakeResponse({"ok": True, "result": {}})
+
+    async def get(self, url):
+        assert url == "https://api.telegram.test/file/bot123:abc/voice/file_1.oga"
+        return FakeResponse(content=b"OggS")
+
+
+def _bot(monkeypatch):
+    api = FakeBotAPI()
+    monkeypatch.setattr(telegram, "http_client", lambda: api)
+    monkeypatch.setattr(telegram, "TELEGRAM_LANGUAGE", "kannada")
+    monkeypatch.setattr(telegram, "TELEGRAM_ALLOWED_CHATS", set())
+    return telegram.TelegramBot("123:abc", "https://api.telegram.test"), api
+
+
+def _voice_update(update_id=1):
+    return {
+        "update_id": update_id,
+        "message": {"message_id": 7, "chat": {"id": 42}, "voice": {"file_id": "f1", "mime_type": "audio/ogg"}},
+    }
+
+
+def test_a_voice_note_is_answered_with_a_captioned_voice_message(monkeypatch):
+    bot, api = _bot(monkeypatch)
+    seen = {}
+
+    async def fake_converse(file, **kwargs):
+        seen["audio"] = await file.read()
+        seen.update(kwargs)
+        return TurnResult(transcription="namaskara", reply="Namaskara!", language="kannada")
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        seen["tts"] = (text, language, audio_format)
+        return TTSStream.cached("audio/ogg", b"OggSreply")
+
+    monkeypatch.setattr(telegram, "converse", fake_converse)
+    monkeypatch.setattr(telegram, "stream_tts", fake_stream_tts)
+
+    asyncio.run(bot.handle_update(_voice_update()))
+    asyncio.run(bot.handle_update(_voice_update()))  # Telegram's redelivery is skipped
+    assert seen["audio"] == b"OggS"
+    assert seen["session_id"] == "telegram:42" and seen["language"] == "kannada" and seen["text"] is None
+    assert seen["tts"] == ("Namaskara!", "kannada", "ogg")
+    assert [method for method, _, _ in api.calls] == ["getFile", "sendVoice"]
+    _, form, files = api.calls[1]
+    assert form == {"chat_id": "42", "caption": "Namaskara!", "reply_to_message_id": "7"}
+    assert files["voice"] == ("reply.ogg", b"OggSreply", "audio/ogg")
+
+
+def test_long_replies_are_sent_as_text_next_to_the_voice_message(monkeypatch):
+    bot, api = _bot(monkeypatch)
+    reply = "word " * 300
+    asyncio.run(bot.reply(42, 7, reply, b"OggS"))
+    assert [method for method, _, _ in api.calls] == ["sendMessage", "sendVoice"]
+    assert api.calls[0][1]["text"] == reply
+    assert "caption" not in api.calls[1][1]
+
+
+def test_a_failed_turn_is_answered_with_a_localized_error(monkeypatch):
+    bot, api = _bot(monkeypatch)
+
+    async def failing_converse(file, **kwargs):
+        raise RuntimeError("llm down")
+
+    monkeypatch.setattr(telegram, "converse", failing_converse)
+    update = {"update_id": 2, "message": {"message_id": 8, "chat": {"id": 42}, "text": "hello"}}
+    asyncio.run(bot.handle_update(update))
+    method, body, _ = api.calls[-1]
+    assert method == "sendMessage" and body["chat_id"] == 42 and body["text"]
+
+
+def test_chats_outside_the_allowlist_are_ignored(monkeypatch):
+    bot, api = _bot(monkeypatch)
+    monkeypatch.setattr(telegram, "TELEGRAM_ALLOWED_CHATS", {"7"})
+    asyncio.run(bot.handle_update(_voice_update()))
+    assert api.calls == []
+
+
+def test_the_webhook_needs_the_secret_token(client, monkeypatch):
+    monkeypatch.setenv("DWANI_TELEGRAM_BOT_TOKEN", "123:abc")
+    monkeypatch.setenv("DWANI_TELEGRAM_WEBHOOK_SECRET", "s3cret")
+    dispatched = []
+    monkeypatch.setattr(telegram.TelegramBot, "dispatch", lambda self, update: dispatched.append(update))
+    assert client.post("/v1/telegram/webhook", json=_voice_update()).status_code == 403
+    response = client.post("/v1/telegram/webhook", json=_voice_update(), headers={"X-Telegram-Bot-Api-Secret-Token": "s3cret"})
+    assert response.status_code == 200 and dispatched == [_voice_update()]
+
+
+def test_the_webhook_refuses_updates_without_a_secret(client, monkeypatch):
+    monkeypatch.setenv("DWANI_TELEGRAM_BOT_TOKEN", "123:abc")
+    monkeypatch.delenv("DWANI_TELEGRAM_WEBHOOK_SECRET", raising=False)
+    dispatched = []
+    monkeypatch.setattr(telegram.TelegramBot, "dispatch", lambda self, update: dispatched.append(update))
+    assert client.post("/v1/telegram/webhook", json=_voice_update()).status_code == 403
+    assert dispatched == []
+
+    api = FakeBotAPI()
+    monkeypatch.setattr(telegram, "http_client", lambda: api)
+    monkeypatch.setattr(telegram, "TELEGRAM_POLLING", False)
+    monkeypatch.setattr(telegram, "TELEGRAM_WEBHOOK_URL", "https://talk.example.com/v1/telegram/webhook")
+    asyncio.run(telegram.start())
+    assert api.calls == []
+
+
+def test_the_webhook_is_not_found_without_a_token(client, monkeypatch):
+    monkeypatch.delenv("DWANI_TELEGRAM_BOT_TOKEN", raising=False)
+    assert client.post("/v1/telegram/webhook", json={}).status_code == 404
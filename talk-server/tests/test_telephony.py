@@ -0,0 +1,197 @@
+import asyncio
+import base64
+import math
+import struct
+
+import pytest
+from starlette.websockets import WebSocketDisconnect
+
+from services import session as session_svc
+from services import telephony
+from services.pipeline import TurnResult
+from services.turn_detection import PRESETS, Endpointer
+from services.tts import TTSStream
+from routers import telephony as telephony_router
+
+
+def _tone(ms, amplitude=8000, rate=8000):
+    samples = [int(amplitude * math.sin(2 * math.pi * 440 * i / rate)) for i in range(rate * ms // 1000)]
+    return struct.pack(f"<{len(samples)}h", *samples)
+
+
+def _silence(ms, rate=8000):
+    return b"\x00\x00" * (rate * ms // 1000)
+
+
+def test_mulaw_round_trip_is_close():
+    pcm = _tone(20)
+    decoded = struct.unpack(f"<{len(pcm) // 2}h", telephony.ulaw_decode(telephony.ulaw_encode(pcm)))
+    for original, restored in zip(struct.unpack(f"<{len(pcm) // 2}h", pcm), decoded):
+        assert abs(original - restored) <= max(16, abs(original) // 16)
+    assert telephony.ulaw_encode(b"\x00\x00") == b"\xff"
+    assert telephony.ulaw_decode(b"\x80\x00") == struct.pack("<2h", 32124, -32124)
+
+
+def test_wav_replies_are_resampled_to_8k():
+    audio = telephony.wav_bytes(_tone(100, rate=24000), sample_rate=24000)
+    assert len(asyncio.run(telephony.to_mulaw(audio))) == 800
+
+
+def test_endpointer_ends_the_turn_after_silence():
+    preset = PRESETS["fast"]
+    endpointer = Endpointer(preset)
+    assert endpointer.feed(_silence(500)) == []
+    assert endpointer.feed(_tone(400)) == []
+    utterances = endpointer.feed(_silence(preset.silence_ms + 40))
+    assert len(utterances) == 1
+    # The speech, the silence that ended it, and up to prefix_padding_ms of lead-in.
+    duration_ms = len(utterances[0]) // 16
+    assert 400 + preset.silence_ms <= duration_ms <= 400 + preset.silence_ms + preset.prefix_padding_ms + 20
+    assert endpointer.feed(_silence(40) + _tone(60)) == []  # too short to count as speech
+
+
+def test_twilio_signature_matches_the_documented_example():
+    # https://www.twilio.com/docs/usage/security#validating-requests
+    params = {"CallSid": "CA1234567890ABCDE", "Caller": "+12349013030", "Digits": "1234", "From": "+12349013030", "To": "+18005551212"}
+    url = "https://mycompany.com/myapp.php?foo=1&bar=2"
+    signature = telephony.twilio_signature("12345", url, params)
+    assert signature == "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+    assert telephony.valid_twilio_signature("12345", url, params, signature)
+    assert not telephony.valid_twilio_signature("12345", url, {**params, "Digits": "0"}, signature)
+
+
+@pytest.fixture
+def fake_pipeline(monkeypatch):
+    monkeypatch.setattr(session_svc, "_session_redis", lambda session_id: None)
+    monkeypatch.setattr(session_svc, "_session_settings", {})
+    monkeypatch.setattr(telephony, "TWILIO_GREETING", "")
+    monkeypatch.setattr(telephony_router, "TWILIO_AUTH_TOKEN", "secret")
+    calls = {"converse": [], "tts": []}
+
+    async def fake_converse(file=None, mode="llm", agent_name=None, session_id=None, request_id=None, language=None, **_):
+        calls["converse"].append((await file.read(), session_id, language))
+        return TurnResult(transcription="ನಮಸ್ಕಾರ", reply="ಹೇಗಿದ್ದೀರಿ?", language=language)
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        calls["tts"].append((text, audio_format))
+        return TTSStream.cached("audio/wav", telephony.wav_bytes(_tone(50)))
+
+    monkeypatch.setattr(telephony, "converse", fake_converse)
+    monkeypatch.setattr(telephony, "stream_tts", fake_stream_tts)
+    return calls
+
+
+def _connect(client):
+    signature = telephony.twilio_signature("secret", "ws://testserver/v1/telephony/twilio")
+    return client.websocket_connect("/v1/telephony/twilio", headers={"X-Twilio-Signature": signature})
+
+
+def _media(pcm):
+    return {"event": "media", "media": {"track": "inbound", "payload": base64.b64encode(telephony.ulaw_encode(pcm)).decode()}}
+
+
+def test_call_runs_a_turn_and_streams_the_reply(client, fake_pipeline, monkeypatch):
+    monkeypatch.setattr(telephony, "BARGE_IN", False)
+    with _connect(client) as ws:
+        ws.send_json({"event": "connected", "protocol": "Call"})
+        ws.send_json({
+            "event": "start",
+            "start": {"streamSid": "MZ1", "callSid": "CA1", "customParameters": {"language": "kannada", "turn_detection": "fast"}},
+        })
+        speech = _silence(200) + _tone(400) + _silence(400)
+        for offset in range(0, len(speech), 320):
+            ws.send_json(_media(speech[offset:offset + 320]))
+
+        # 50 ms of reply audio: 400 mu-law bytes in 20 ms frames, then the mark.
+        frames = [ws.receive_json() for _ in range(4)]
+        assert [len(base64.b64decode(f["media"]["payload"])) for f in frames[:3]] == [160, 160, 80]
+        assert all(f["streamSid"] == "MZ1" for f in frames)
+        assert frames[3] == {"event": "mark", "streamSid": "MZ1", "mark": {"name": "reply-1"}}
+
+        wav, session_id, language = fake_pipeline["converse"][0]
+        assert wav[:4] == b"RIFF" and session_id == "twilio:CA1" and language == "kannada"
+        assert fake_pipeline["tts"] == [("ಹೇಗಿದ್ದೀರಿ?", "wav")]
+
+        # While the reply plays the caller is not listened to; the echoed mark resumes it.
+        ws.send_json(_media(_tone(400) + _silence(400)))
+        ws.send_json({"event": "mark", "streamSid": "MZ1", "mark": {"name": "reply-1"}})
+        ws.send_json({"event": "stop", "streamSid": "MZ1"})
+    assert len(fake_pipeline["converse"]) == 1
+
+
+def _start(ws):
+    ws.send_json({"event": "start", "start": {"streamSid": "MZ1", "callSid": "CA1", "customParameters": {"turn_detection": "fast"}}})
+
+
+def test_speaking_over_the_reply_clears_it_and_starts_a_turn(client, fake_pipeline):
+    with _connect(client) as ws:
+        _start(ws)
+        ws.send_json(_media(_tone(400) + _silence(400)))
+        assert [ws.receive_json()["event"] for _ in range(4)] == ["media", "media", "media", "mark"]
+
+        # A cough does not interrupt; sustained speech does, and becomes the next turn.
+        ws.send_json(_media(_tone(60) + _silence(400)))
+        ws.send_json(_media(_tone(400)))
+        assert ws.receive_json() == {"event": "clear", "streamSid": "MZ1"}
+        ws.send_json(_media(_silence(400)))
+        events = [ws.receive_json() for _ in range(4)]
+        assert events[3] == {"event": "mark", "streamSid": "MZ1", "mark": {"name": "reply-2"}}
+        ws.send_json({"event": "stop", "streamSid": "MZ1"})
+    assert len(fake_pipeline["converse"]) == 2
+
+
+def test_barge_in_cancels_a_reply_still_being_prepared(fake_pipeline, monkeypatch):
+    started, cancelled = [], []
+
+    async def slow_converse(file=None, **_):
+        started.append(True)
+        try:
+            await asyncio.sleep(60)
+        except asyncio.CancelledError:
+            cancelled.append(True)
+            raise
+
+    monkeypatch.setattr(telephony, "converse", slow_converse)
+
+    async def run():
+        sent = []
+
+        async def send(message):
+            sent.append(message)
+
+        call = telephony.TwilioCall(send)
+        await call.handle({"event": "start", "start": {"streamSid": "MZ1", "callSid": "CA1", "customParameters": {"turn_detection": "fast"}}})
+        await call.handle(_media(_tone(400) + _silence(400)))
+        await asyncio.sleep(0)
+        assert started and not call.listening
+        await call.handle(_media(_tone(400)))
+        assert call.listening
+        await call.close()
+        return sent
+
+    assert asyncio.run(run()) == [{"event": "clear", "streamSid": "MZ1"}]
+    assert cancelled and not fake_pipeline["tts"]
+
+
+def test_signature_is_required_when_an_auth_token_is_set(client, monkeypatch):
+    monkeypatch.setattr(telephony_router, "TWILIO_AUTH_TOKEN", "secret")
+    assert client.post("/v1/telephony/twilio/twiml", data={"CallSid": "CA1"}).status_code == 403
+
+    url = "http://testserver/v1/telephony/twilio/twiml?language=kannada"
+    signature = telephony.twilio_signature("secret", url, {"CallSid": "CA1"})
+    res = client.post(url, data={"CallSid": "CA1"}, headers={"X-Twilio-Signature": signature})
+    assert res.status_code == 200
+    assert '<Stream url="ws://testserver/v1/telephony/twilio">' in res.text
+    assert '<Parameter name="language" value="kannada"/>' in res.text
+
+    with pytest.raises(WebSocketDisconnect):
+        with client.websocket_connect("/v1/telephony/twilio") as ws:
+            ws.receive_json()
+
+
+def test_calls_are_refused_without_an_auth_token(client, monkeypatch):
+    monkeypatch.setattr(telephony_router, "TWILIO_AUTH_TOKEN", "")
+    assert client.post("/v1/telephony/twilio/twiml", data={"CallSid": "CA1"}).status_code == 404
+    with pytest.raises(WebSocketDisconnect):
+        with client.websocket_connect("/v1/telephony/twilio") as ws:
+            ws.receive_json()
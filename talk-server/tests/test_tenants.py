@@ -0,0 +1,135 @@
+"""Tests for per-tenant API keys, backends, languages, personas and quotas."""
+import json
+
+import pytest
+from fastapi import HTTPException
+
+from routers import chat as chat_router
+from services import backends, personas, pipeline, session, tenants
+from services.branding import use_tenant
+from services.language_id import parse_language
+from services.personas import Persona, default_persona, resolve_persona
+from services.tts import TTSStream
+
+_SPECS = {
+    "acme": {
+        "api_keys": ["acme-key"],
+        "hosts": ["voice.acme.com"],
+        "backends": {"llm_model": "acme-model", "max_tts_chars": 0},
+        "languages": ["kannada", "en-IN"],
+        "personas": ["concierge"],
+        "persona": "concierge",
+        "rate_limit": "3/minute",
+    },
+    "globex": {"daily_quota": 1},
+}
+
+
+@pytest.fixture
+def configured(tmp_path, monkeypatch):
+    path = tmp_path / "tenants.json"
+    path.write_text(json.dumps(_SPECS))
+    for name, tenant in tenants.load_tenants(str(path)).items():
+        monkeypatch.setitem(tenants.tenants, name, tenant)
+    monkeypatch.setitem(personas.personas, "concierge", Persona("concierge", "You are a hotel concierge."))
+    yield tenants.tenants
+    tenants.use_tenant_settings(None)
+    use_tenant(None)
+
+
+def test_tenants_file_is_parsed_and_validated(configured, tmp_path):
+    acme = configured["acme"]
+    assert acme.languages == {"kannada", "english"}
+    assert acme.backends.llm_model == "acme-model" and acme.backends.llm_base_url is None
+    assert acme.has_key("acme-key") and not acme.has_key("other") and not acme.has_key(None)
+
+    for spec in (
+        {"acme": {"quota": 5}},
+        {"acme": {"languages": ["klingon"]}},
+        {"acme": {"backends": {"llm_url": "http://llm"}}},
+        {"acme": {"backends": {"max_llm_tokens": "many"}}},
+        {"acme": {"personas": ["a"], "persona": "b"}},
+        {"acme": {"api_keys": ["k"]}, "globex": {"api_keys": ["k"]}},
+        {"default": {}},
+    ):
+        path = tmp_path / "bad.json"
+        path.write_text(json.dumps(spec))
+        with pytest.raises(ValueError):
+            tenants.load_tenants(str(path))
+
+
+def test_the_api_key_names_the_tenant_before_the_host(configured):
+    assert tenants.tenant_for_request("acme-key", "globex.talk.example.com").name == "acme"
+    assert tenants.tenant_for_request("other", "voice.acme.com:443").name == "acme"
+    assert tenants.tenant_for_request(None, "globex.talk.example.com").name == "globex"
+    assert tenants.tenant_for_request(None, "talk.example.com") is None
+
+
+def test_a_tenants_backends_languages_and_personas_apply(configured):
+    tenants.use_tenant_settings(configured["acme"])
+    assert backends.llm_model() == "acme-model"
+    assert backends.max_tts_chars() == 0
+    assert parse_language("kn-IN") == "kannada"
+    with pytest.raises(HTTPException) as err:
+        parse_language("hindi")
+    assert "['english', 'kannada']" in err.value.detail
+    assert default_persona().name == "concierge"
+    with pytest.raises(HTTPException):
+        resolve_persona("default")
+
+    tenants.use_tenant_settings(None)
+    assert backends.llm_model() != "acme-model"
+    assert parse_language("hindi") == "hindi"
+    assert resolve_persona("default").name == "default"
+
+
+def test_a_tenant_with_keys_only_accepts_its_own(client, configured):
+    path = "/v1/turn_detection/presets"
+    assert client.get(path).status_code == 200
+    assert client.get(path, headers={"Host": "voice.acme.com"}).status_code == 401
+    assert client.get(path, headers={"Host": "voice.acme.com", "X-API-Key": "other"}).status_code == 401
+    assert client.get(path, headers={"X-API-Key": "acme-key"}).status_code == 200
+    # The tenant's 3/minute is shared by all of its requests, rejected ones included.
+    assert client.get(path, headers={"X-API-Key": "acme-key"}).status_code == 429
+
+
+def test_a_tenants_turns_use_its_model_and_daily_quota(client, configured, monkeypatch):
+    models_seen = []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        models_seen.append(backends.llm_model())
+        return "Namaskara."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post("/v1/speech_to_speech", headers={"X-API-Key": "acme-key"}, data={"text": "hello"})
+    assert res.status_code == 200
+    assert models_seen == ["acme-model"]
+
+    globex = {"Host": "globex.talk.example.com"}
+    assert client.post("/v1/speech_to_speech", headers=globex, data={"text": "hello"}).status_code == 200
+    assert models_seen[-1] != "acme-model"
+    assert client.post("/v1/speech_to_speech", headers=globex, data={"text": "hello"}).status_code == 429
+
+
+def test_tenants_using_the_same_session_id_do_not_share_it(configured, monkeypatch):
+    monkeypatch.setattr(session, "_STORE", session.MemorySessionStore())
+    monkeypatch.setattr(session, "_session_store", {})
+    monkeypatch.setattr(session, "_session_order", [])
+    tenants.use_tenant_settings(configured["acme"])
+    session.append_to_session("s1", "hi", "hello")
+    acme_key = session.session_digest("s1")
+
+    tenants.use_tenant_settings(configured["globex"])
+    assert session.get_session_context("s1") == [] and session.session_digest("s1") != acme_key
+    assert not session.delete_session("s1")
+    tenants.use_tenant_settings(None)
+    assert session.session_transcript("s1") == []
+    assert session.session_digest("s1") == session._digest("s1")
+
+    tenants.use_tenant_settings(configured["acme"])
+    assert [m["content"] for m in session.get_session_context("s1")] == ["hi", "hello"]
@@ -0,0 +1,70 @@
+"""Tests for writing numbers, dates, currency and abbreviations out before TTS."""
+import asyncio
+import json
+
+import pytest
+
+from services import text_normalization
+from services.hooks import HookContext, PipelineHooks
+from services.text_normalization import normalize, number_words, ssml
+
+
+@pytest.mark.parametrize("n, hindi, kannada, tamil", [
+    (21, "इक्कीस", "ಇಪ್ಪತ್ತೊಂದು", "இருபத்து ஒன்று"),
+    (250, "दो सौ पचास", "ಇನ್ನೂರ ಐವತ್ತು", "இருநூற்று ஐம்பது"),
+    (2024, "दो हज़ार चौबीस", "ಎರಡು ಸಾವಿರದ ಇಪ್ಪತ್ತನಾಲ್ಕು", "இரண்டு ஆயிரத்து இருபத்து நான்கு"),
+    (150000, "एक लाख पचास हज़ार", "ಒಂದು ಲಕ್ಷದ ಐವತ್ತು ಸಾವಿರ", "ஒரு லட்சத்து ஐம்பது ஆயிரம்"),
+    (15000000, "एक करोड़ पचास लाख", "ಒಂದು ಕೋಟಿ ಐವತ್ತು ಲಕ್ಷ", "ஒரு கோடியே ஐம்பது லட்சம்"),
+])
+def test_numbers_are_said_in_lakh_and_crore(n, hindi, kannada, tamil):
+    assert number_words(n, "hindi") == hindi
+    assert number_words(n, "kannada") == kannada
+    assert number_words(n, "tamil") == tamil
+    with pytest.raises(ValueError):
+        number_words(n, "english")
+
+
+def test_amounts_dates_and_units_are_written_out():
+    assert normalize("कुल ₹1,250.50 देने हैं", "hindi") == "कुल एक हज़ार दो सौ पचास रुपये पचास पैसे देने हैं"
+    assert normalize("ರೂ.500 ಮತ್ತು 50% ರಿಯಾಯಿತಿ", "kannada") == "ಐನೂರು ರೂಪಾಯಿ ಮತ್ತು ಶೇಕಡಾ ಐವತ್ತು ರಿಯಾಯಿತಿ"
+    assert normalize("15/08/2024 அன்று 3.5 km", "tamil") == "பதினைந்து ஆகஸ்ட் இரண்டு ஆயிரத்து இருபத்து நான்கு அன்று மூன்று புள்ளி ஐந்து கிலோமீட்டர்"
+    assert normalize("1,00,000 लोग, $20", "hindi") == "एक लाख लोग, बीस डॉलर"
+
+
+def test_identifiers_and_other_languages_are_left_alone():
+    assert normalize("ಕರೆ 9876543210", "kannada") == "ಕರೆ ಒಂಬತ್ತು ಎಂಟು ಏಳು ಆರು ಐದು ನಾಲ್ಕು ಮೂರು ಎರಡು ಒಂದು ಸೊನ್ನೆ"
+    assert normalize("4G और v2 मॉडल", "hindi") == "4G और v2 मॉडल"
+    assert normalize("Flight 6E 204 on 15/08/2024", "english") == "Flight 6E 204 on 15/08/2024"
+    assert normalize("32/13/2024", "hindi") == "बत्तीस/तेरह/दो हज़ार चौबीस"
+
+
+def test_abbreviations_only_as_whole_words():
+    assert normalize("ಡಾ. ರಾವ್ ಮತ್ತು Dr.Rao", "kannada") == "ಡಾಕ್ಟರ್ ರಾವ್ ಮತ್ತು ಡಾಕ್ಟರ್ Rao"
+    assert normalize("ಮಾಡಾ.", "kannada") == "ಮಾಡಾ."
+
+
+def test_deployment_abbreviations_are_loaded(tmp_path):
+    path = tmp_path / "abbreviations.json"
+    path.write_text(json.dumps({"kn": {"ಬೆಂ.": "ಬೆಂಗಳೂರು"}, "english": {"approx.": "approximately"}}), encoding="utf-8")
+    assert text_normalization.load_abbreviations(str(path)) == {
+        "kannada": {"ಬೆಂ.": "ಬೆಂಗಳೂರು"}, "english": {"approx.": "approximately"}
+    }
+    path.write_text(json.dumps({"klingon": {"a": "b"}}), encoding="utf-8")
+    with pytest.raises(ValueError):
+        text_normalization.load_abbreviations(str(path))
+
+
+def test_ssml_wraps_escaped_text_with_prosody():
+    assert ssml("a < b", "kannada", rate="95%") == '<speak xml:lang="kn-IN"><prosody rate="95%">a &lt; b</prosody></speak>'
+    assert ssml("hello", None) == "<speak>hello</speak>"
+    assert ssml("<speak>as is</speak>", "hindi", pitch="+2st") == "<speak>as is</speak>"
+
+
+def test_hook_rewrites_text_before_tts(monkeypatch):
+    registry = PipelineHooks()
+    monkeypatch.setattr(text_normalization, "TTS_SSML", True)
+    assert text_normalization.install(registry)
+    assert not text_normalization.install(registry)
+
+    text = asyncio.run(registry.run("before_tts", HookContext(language="hindi"), "5 km"))
+    assert text == '<speak xml:lang="hi-IN">पाँच किलोमीटर</speak>'
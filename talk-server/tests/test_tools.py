@@ -0,0 +1,127 @@
+"""Tests for LLM tool calling (services/tools.py)."""
+import asyncio
+import json
+from types import SimpleNamespace
+
+import pytest
+
+from services import chat_svc, tools
+from services.tools import Tool, check_tools, load_tools, register_tool
+
+
+def _call(call_id, name, arguments):
+    return SimpleNamespace(id=call_id, function=SimpleNamespace(name=name, arguments=arguments))
+
+
+def _fake_openai(requests, replies):
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            requests.append({**kwargs, "messages": list(kwargs["messages"])})
+            return SimpleNamespace(choices=[SimpleNamespace(message=replies.pop(0))])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, **kwargs):
+            self.chat = SimpleNamespace(completions=FakeCompletions())
+
+    return FakeAsyncOpenAI
+
+
+def _use_tools(monkeypatch, names):
+    async def capital(arguments):
+        return {"karnataka": "Bengaluru"}.get(arguments.get("state", "").lower(), "unknown")
+
+    monkeypatch.setitem(tools.tools, "capital", Tool("capital", "Capital of an Indian state", capital))
+    monkeypatch.setattr(tools, "LLM_TOOLS", names)
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+
+
+def test_tool_results_go_back_to_the_model_before_it_answers(monkeypatch):
+    _use_tools(monkeypatch, ["capital", "time"])
+    requests = []
+    replies = [
+        SimpleNamespace(content=None, tool_calls=[_call("c1", "capital", '{"state": "Karnataka"}'), _call("c2", "time", "{}")]),
+        SimpleNamespace(content="Bengaluru is the capital.", tool_calls=None),
+    ]
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(requests, replies))
+    assert asyncio.run(chat_svc.call_llm("What is the capital of Karnataka?")) == "Bengaluru is the capital."
+
+    assert [t["function"]["name"] for t in requests[0]["tools"]] == ["capital", "time"]
+    assistant, capital, now = requests[1]["messages"][-3:]
+    assert [c["id"] for c in assistant["tool_calls"]] == ["c1", "c2"]
+    assert capital == {"role": "tool", "tool_call_id": "c1", "content": "Bengaluru"}
+    assert now["tool_call_id"] == "c2" and json.loads(now["content"])["timezone"] == "Asia/Kolkata"
+
+
+def test_the_last_round_offers_no_tools_and_tasks_get_none(monkeypatch):
+    _use_tools(monkeypatch, ["capital"])
+    monkeypatch.setattr(chat_svc, "LLM_TOOL_ROUNDS", 1)
+    requests = []
+    replies = [
+        SimpleNamespace(content=None, tool_calls=[_call("c1", "weather", '{"city": "Mysuru"}')]),
+        SimpleNamespace(content="I cannot check the weather.", tool_calls=None),
+    ]
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(requests, replies))
+    assert asyncio.run(chat_svc.call_llm("Weather in Mysuru?")) == "I cannot check the weather."
+    assert "tools" not in requests[1]
+    assert requests[1]["messages"][-1]["content"] == "error: no tool 'weather'"
+
+    requests.clear()
+    replies.append(SimpleNamespace(content="Hello.", tool_calls=None))
+    asyncio.run(chat_svc.call_llm("hello", system_prompt="Add punctuation."))
+    assert "tools" not in requests[0]
+
+
+def test_failing_and_slow_tools_answer_with_an_error(monkeypatch):
+    async def broken(arguments):
+        raise RuntimeError("down")
+
+    async def slow(arguments):
+        await asyncio.sleep(1)
+
+    monkeypatch.setitem(tools.tools, "broken", Tool("broken", "", broken))
+    monkeypatch.setitem(tools.tools, "slow", Tool("slow", "", slow))
+    monkeypatch.setattr(tools, "LLM_TOOLS", ["broken", "slow"])
+    monkeypatch.setattr(tools, "LLM_TOOL_TIMEOUT", 0.01)
+    assert asyncio.run(tools.run_tool_call(_call("a", "broken", "{}")))["content"] == "error: the tool failed"
+    assert asyncio.run(tools.run_tool_call(_call("b", "slow", "{}")))["content"] == "error: the tool took too long"
+    assert asyncio.run(tools.run_tool_call(_call("c", "broken", "[1]")))["content"] == "error: arguments must be a JSON object"
+
+
+def test_tools_file_and_startup_check(tmp_path, monkeypatch):
+    path = tmp_path / "tools.json"
+    path.write_text(json.dumps({"order_status": {
+        "description": "Status of an order",
+        "url": "http://orders.internal/status",
+        "parameters": {"type": "object", "properties": {"order_id": {"type": "string"}}},
+    }}))
+    loaded = load_tools(str(path))
+    assert loaded["order_status"].spec()["function"]["parameters"]["properties"] == {"order_id": {"type": "string"}}
+    path.write_text(json.dumps({"bad name": {"description": "x", "url": "http://x"}}))
+    with pytest.raises(ValueError):
+        load_tools(str(path))
+
+    with pytest.raises(ValueError, match="weather"):
+        check_tools(["time", "weather"])
+    monkeypatch.setattr(tools, "tools", dict(tools.tools))
+    register_tool(Tool("weather", "Weather", loaded["order_status"].run))
+    check_tools(["time", "weather"])
+
+
+def test_knowledge_returns_the_entries_sharing_most_words(monkeypatch):
+    monkeypatch.setattr(tools, "knowledge", {
+        "Opening hours": "The clinic opens at nine and closes at six.",
+        "Parking": "Parking is free behind the clinic.",
+    })
+    found = json.loads(asyncio.run(tools._knowledge({"query": "When does the clinic open? What are the opening hours?"})))
+    assert [entry["title"] for entry in found] == ["Opening hours", "Parking"]
+    assert asyncio.run(tools._knowledge({"query": "refunds"})) == "no matching entries"
+
+
+def test_persona_and_slot_prompts_are_still_offered_tools(monkeypatch):
+    _use_tools(monkeypatch, ["capital"])
+    requests = []
+    replies = [SimpleNamespace(content="Hello.", tool_calls=None)]
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", _fake_openai(requests, replies))
+    with tools.conversation():
+        asyncio.run(chat_svc.call_llm("hello", system_prompt="You are a clinic receptionist."))
+    assert [t["function"]["name"] for t in requests[0]["tools"]] == ["capital"]
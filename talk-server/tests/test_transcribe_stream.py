@@ -0,0 +1,37 @@
+"""Tests for the streamed chat-completions transcription request body."""
+import asyncio
+import base64
+import io
+import json
+
+from fastapi import UploadFile
+
+from services import transcribe as transcribe_svc
+
+
+def test_streamed_body_is_valid_json_with_full_audio(monkeypatch):
+    audio = bytes(range(256)) * 2000
+    upload = UploadFile(file=io.BytesIO(audio), size=len(audio), filename="a.wav")
+    captured = {}
+
+    class FakeResponse:
+        status_code = 200
+
+        def json(self):
+            return {"choices": [{"message": {"content": "ನಮಸ್ಕಾರ"}}]}
+
+    class FakeAsyncClient:
+        async def post(self, url, headers=None, content=None, timeout=None):
+            body = b"".join([chunk async for chunk in content])
+            captured["length"] = headers["Content-Length"]
+            captured["body"] = body
+            return FakeResponse()
+
+    monkeypatch.setattr(transcribe_svc, "http_client", FakeAsyncClient)
+    result = asyncio.run(transcribe_svc.transcribe_audio(upload))
+
+    assert result.text == "ನಮಸ್ಕಾರ"
+    assert captured["length"] == str(len(captured["body"]))
+    payload = json.loads(captured["body"])
+    url = payload["messages"][0]["content"][0]["audio_url"]["url"]
+    assert base64.standard_b64decode(url.split(",", 1)[1]) == audio
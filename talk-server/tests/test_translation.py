@@ -0,0 +1,114 @@
+"""Tests for cross-lingual turns: input_language/output_language and the translation stage."""
+import asyncio
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException
+
+from routers import chat as chat_router
+from services import chat_svc, pipeline, translation
+from services.errors import StageError
+from services.tts import TTSStream
+
+_ENGLISH = {"ನನಗೆ ಕಾಫಿ ಬೇಕು": "I want coffee", "Here is your coffee.": "ಇಗೋ ನಿಮ್ಮ ಕಾಫಿ."}
+
+
+def _fake_translate_llm(calls):
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        calls.append(system_prompt)
+        return _ENGLISH[user_text]
+
+    return fake_call_llm
+
+
+def test_turn_is_translated_both_ways(client, monkeypatch):
+    translated, seen, spoken, stored = [], [], [], []
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        seen.append((user_text, language))
+        return "Here is your coffee."
+
+    async def fake_stream_tts(text, request_id=None, language=None, audio_format=None):
+        spoken.append((text, language))
+        return TTSStream.cached("audio/mpeg", b"mp3")
+
+    monkeypatch.setattr(translation, "call_llm", _fake_translate_llm(translated))
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    monkeypatch.setattr(pipeline, "append_to_session", lambda session_id, user, reply: stored.append((user, reply)))
+    monkeypatch.setattr(chat_router, "stream_tts", fake_stream_tts)
+
+    res = client.post(
+        "/v1/speech_to_speech",
+        params={"input_language": "kannada", "format": "json"},
+        data={"text": "ನನಗೆ ಕಾಫಿ ಬೇಕು"},
+        headers={"X-Session-ID": "s1"},
+    )
+    assert res.status_code == 200
+    body = res.json()
+    assert body["transcription"] == "ನನಗೆ ಕಾಫಿ ಬೇಕು"
+    assert body["llm_response"] == "ಇಗೋ ನಿಮ್ಮ ಕಾಫಿ."
+    assert body["translation"] == {
+        "input_language": "kannada",
+        "output_language": "kannada",
+        "llm_language": "english",
+        "llm_input": "I want coffee",
+        "llm_reply": "Here is your coffee.",
+    }
+    assert seen == [("I want coffee", "english")]
+    assert spoken == [("ಇಗೋ ನಿಮ್ಮ ಕಾಫಿ.", "kannada")]
+    assert stored == [("I want coffee", "Here is your coffee.")]
+    assert "from Kannada into English" in translated[0]
+
+
+def test_same_language_turn_is_not_translated(monkeypatch):
+    async def fail(*args, **kwargs):
+        raise AssertionError("translation was not needed")
+
+    async def fake_call_llm(user_text, context=None, request_id=None, language=None, generation=None, system_prompt=None):
+        return "ok"
+
+    monkeypatch.setattr(translation, "call_llm", fail)
+    monkeypatch.setattr(pipeline, "call_llm", fake_call_llm)
+    turn = asyncio.run(pipeline.converse(text="hello", language="english", input_language="english"))
+    assert turn.reply == "ok"
+    assert turn.translation.llm_reply == "ok"
+
+
+def test_translation_backend_is_configurable(monkeypatch):
+    captured = {}
+
+    class FakeCompletions:
+        async def create(self, **kwargs):
+            captured["model"] = kwargs["model"]
+            message = SimpleNamespace(content="I want coffee")
+            return SimpleNamespace(choices=[SimpleNamespace(message=message)])
+
+    class FakeAsyncOpenAI:
+        def __init__(self, base_url=None, **kwargs):
+            captured["base_url"] = base_url
+            self.chat = SimpleNamespace(completions=FakeCompletions())
+
+    monkeypatch.setenv("DWANI_API_BASE_URL_LLM", "http://llm")
+    monkeypatch.setenv("DWANI_TRANSLATION_BASE_URL", "http://translate:10805")
+    monkeypatch.setenv("DWANI_TRANSLATION_MODEL", "indictrans")
+    monkeypatch.setattr(chat_svc, "AsyncOpenAI", FakeAsyncOpenAI)
+    assert asyncio.run(translation.translate("ನನಗೆ ಕಾಫಿ ಬೇಕು", "kannada", "english")) == "I want coffee"
+    assert captured == {"base_url": "http://translate:10805/v1", "model": "indictrans"}
+
+
+def test_translation_failure_is_a_stage_error(monkeypatch):
+    async def failing_call_llm(*args, **kwargs):
+        raise StageError("llm", detail="LLM returned empty response")
+
+    monkeypatch.setattr(translation, "call_llm", failing_call_llm)
+    with pytest.raises(HTTPException) as exc:
+        asyncio.run(translation.translate("ನಮಸ್ಕಾರ", "kannada", "english"))
+    assert exc.value.stage == "translation"
+    assert exc.value.status_code == 502
+
+
+def test_cross_lingual_parameters_are_validated(client):
+    res = client.post("/v1/speech_to_speech", params={"input_language": "klingon"}, data={"text": "hi"})
+    assert res.status_code == 400
+    res = client.post("/v1/speech_to_speech", params={"input_language": "kannada", "language": "auto"}, data={"text": "hi"})
+    assert res.status_code == 400
@@ -0,0 +1,49 @@
+"""Tests for writing romanized (code-mixed) words in the language's script."""
+import asyncio
+
+import pytest
+
+from services import transliteration
+from services.hooks import HookContext, PipelineHooks
+from services.transliteration import modes, transliterate, transliterate_word
+
+
+@pytest.mark.parametrize("word, language, written", [
+    ("naanu", "kannada", "ನಾನು"),
+    ("beku", "kannada", "ಬೇಕು"),
+    ("hogtini", "kannada", "ಹೋಗ್ತಿನಿ"),
+    ("Illa", "kannada", "ಇಲ್ಲ"),
+    ("mujhe", "hindi", "मुझे"),
+    ("kal", "hindi", "कल"),
+    ("naanu", "telugu", "నాను"),
+    ("bhaagya", "tamil", "பாக்ய"),
+])
+def test_romanized_words_are_written_in_the_script(word, language, written):
+    assert transliterate_word(word, language) == written
+
+
+def test_modes_keep_english_words_and_configured_ones(monkeypatch):
+    text = "naanu office ge hogtini, 10am ge"
+    assert transliterate(text, "kannada", "mixed") == "ನಾನು office ಗೇ ಹೋಗ್ತಿನಿ, 10am ಗೇ"
+    assert transliterate(text, "kannada", "keep") == text
+    assert transliterate("mujhe ticket chahiye", "hindi", "native") == "मुझे तिक्केत चहिये"
+    monkeypatch.setattr(transliteration, "TRANSLITERATE_KEEP_WORDS", {"dwani"})
+    assert transliterate("Dwani beku", "kannada", "native") == "Dwani ಬೇಕು"
+    assert transliterate("hello", None, "native") == transliterate("hello", "english", "native") == "hello"
+
+
+def test_modes_are_validated():
+    assert modes("kannada=mixed, hindi=native") == {"kannada": "mixed", "hindi": "native"}
+    with pytest.raises(ValueError):
+        modes("kannada=roman")
+    with pytest.raises(ValueError):
+        modes("english=native")
+
+
+def test_hooks_write_transcripts_and_replies_in_the_script(monkeypatch):
+    monkeypatch.setattr(transliteration, "TRANSLITERATE", "kannada=mixed")
+    registry = PipelineHooks()
+    assert transliteration.install(registry) and not transliteration.install(registry)
+    ctx = HookContext(language="kannada")
+    assert asyncio.run(registry.run("after_asr", ctx, "naanu bus ge")) == "ನಾನು bus ಗೇ"
+    assert asyncio.run(registry.run("before_tts", HookContext(language="hindi"), "kal")) == "kal"
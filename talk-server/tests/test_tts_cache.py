@@ -0,0 +1,103 @@
+import asyncio
+
+from prometheus_client import REGISTRY
+
+from services import tts as tts_svc
+from services.tts_cache import TTSCache, cache_key
+
+
+def _lookups(result):
+    return REGISTRY.get_sample_value("dwani_tts_cache_lookups_total", {"result": result}) or 0
+
+
+def test_key_normalizes_whitespace_but_not_language_or_voice():
+    base = cache_key("Hello  there\n", "kn", "http://tts")
+    assert cache_key(" Hello there", "KN", "http://tts") == base
+    assert cache_key("Hello there", "hi", "http://tts") != base
+    assert cache_key("Hello there", "kn", "http://other-tts") != base
+
+
+def test_lru_evicts_by_entries_and_bytes():
+    cache = TTSCache(max_entries=2, max_bytes=10, directory=None)
+    cache.put("a", "audio/mpeg", b"1111")
+    cache.put("b", "audio/mpeg", b"2222")
+    cache.get("a")
+    cache.put("c", "audio/mpeg", b"3333")
+    assert cache.get("b") is None
+    assert cache.get("a") == ("audio/mpeg", b"1111")
+    cache.put("big", "audio/mpeg", b"x" * 11)
+    assert cache.get("big") is None
+
+
+def test_disk_tier_survives_a_new_process(tmp_path):
+    TTSCache(directory=str(tmp_path)).put("k" * 64, "audio/wav", b"RIFF....")
+    fresh = TTSCache(directory=str(tmp_path))
+    before = _lookups("hit_disk")
+    assert fresh.get("k" * 64) == ("audio/wav", b"RIFF....")
+    assert _lookups("hit_disk") == before + 1
+    assert len(fresh) == 1
+
+
+def test_long_text_is_not_cacheable():
+    cache = TTSCache(max_text_chars=10, directory=None)
+    assert cache.cacheable("Namaskara")
+    assert not cache.cacheable("A much longer reply than ten characters")
+
+
+class _FakeResponse:
+    status_code = 200
+    headers = {"Content-Type": "audio/mpeg"}
+
+    def raise_for_status(self):
+        pass
+
+    async def aiter_bytes(self):
+        yield b"ID3"
+        yield b"audio"
+
+    async def aclose(self):
+        pass
+
+
+class _FakeClient:
+    sends = 0
+
+    def build_request(self, method, url, **kwargs):
+        return (method, url, kwargs)
+
+    async def send(self, request, stream=False):
+        _FakeClient.sends += 1
+        return _FakeResponse()
+
+
+def test_repeated_reply_is_served_from_cache(monkeypatch):
+    monkeypatch.setattr(tts_svc, "tts_cache", TTSCache(directory=None))
+    monkeypatch.setattr(tts_svc, "http_client", lambda: _FakeClient())
+    _FakeClient.sends = 0
+    misses, hits = _lookups("miss"), _lookups("hit_memory")
+
+    async def speak():
+        stream = await tts_svc.stream_tts("Sorry, I didn't understand that.", language="en")
+        return stream.content_type, await stream.read()
+
+    first = asyncio.run(speak())
+    second = asyncio.run(speak())
+    assert first == second == ("audio/mpeg", b"ID3audio")
+    assert _FakeClient.sends == 1
+    assert _lookups("miss") == misses + 1
+    assert _lookups("hit_memory") == hits + 1
+
+
+def test_partially_relayed_audio_is_not_cached(monkeypatch):
+    cache = TTSCache(directory=None)
+    monkeypatch.setattr(tts_svc, "tts_cache", cache)
+    monkeypatch.setattr(tts_svc, "http_client", lambda: _FakeClient())
+
+    async def abandon():
+        stream = await tts_svc.stream_tts("Hello", language="en")
+        chunks = stream.iter_bytes()
+        await chunks.__anext__()
+        await chunks.aclose()
+
+    asyncio.run(abandon())
+    assert len(cache) == 0
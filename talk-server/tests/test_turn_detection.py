@@ -0,0 +1,33 @@
+from services import session as session_svc
+from services import turn_detection
+
+
+def test_presets_trade_latency_for_patience():
+    fast, balanced, patient = (turn_detection.get_preset(n) for n in ("fast", "balanced", "patient"))
+    assert fast.silence_ms < balanced.silence_ms < patient.silence_ms
+    assert turn_detection.get_preset(" FAST ") is fast
+    assert turn_detection.get_preset("unknown") is None
+
+
+def test_list_presets_endpoint(client):
+    res = client.get("/v1/turn_detection/presets")
+    assert res.status_code == 200
+    assert [p["name"] for p in res.json()] == ["fast", "balanced", "patient"]
+
+
+def test_preset_is_selected_per_session(client, monkeypatch):
+    monkeypatch.setattr(session_svc, "_session_redis", lambda session_id: None)
+    monkeypatch.setattr(session_svc, "_session_settings", {})
+
+    assert client.get("/v1/session/turn_detection", headers={"X-Session-ID": "s1"}).json()["name"] == "balanced"
+    res = client.put("/v1/session/turn_detection", headers={"X-Session-ID": "s1"}, json={"preset": "patient"})
+    assert res.status_code == 200
+    assert res.json()["silence_ms"] == turn_detection.PRESETS["patient"].silence_ms
+    assert client.get("/v1/session/turn_detection", headers={"X-Session-ID": "s1"}).json()["name"] == "patient"
+    assert client.get("/v1/session/turn_detection", headers={"X-Session-ID": "s2"}).json()["name"] == "balanced"
+
+
+def test_session_header_and_preset_are_validated(client):
+    assert client.get("/v1/session/turn_detection").status_code == 400
+    res = client.put("/v1/session/turn_detection", headers={"X-Session-ID": "s1"}, json={"preset": "slow"})
+    assert res.status_code == 422
@@ -0,0 +1,99 @@
+import asyncio
+import hashlib
+import io
+
+from fastapi import Request, UploadFile
+from starlette import formparsers
+from starlette.datastructures import Headers
+
+from services import uploads
+from services.errors import RequestError
+from services.uploads import check_audio_upload, upload_digest
+
+
+def test_upload_digest_hashes_the_whole_upload_and_rewinds():
+    data = b"RIFF" + bytes(200_000)
+    upload = UploadFile(io.BytesIO(data), size=len(data), filename="a.wav")
+    assert asyncio.run(upload_digest(upload)) == hashlib.sha256(data).hexdigest()
+    assert upload.file.tell() == 0
+    assert asyncio.run(upload_digest(UploadFile(io.BytesIO(b""), size=0))) == ""
+
+
+def test_spooled_file_moves_to_disk_beyond_the_threshold(monkeypatch, tmp_path):
+    monkeypatch.setattr(uploads, "UPLOAD_SPOOL_BYTES", 16)
+    monkeypatch.setattr(uploads, "UPLOAD_SPOOL_DIR", str(tmp_path))
+    with uploads.spooled_file() as spool:
+        spool.write(b"x" * 8)
+        assert not spool._rolled
+        spool.write(b"x" * 16)
+        assert spool._rolled
+
+
+def test_form_limits_are_applied_to_every_form(client, monkeypatch):
+    monkeypatch.setattr(uploads, "_applied", False)
+    monkeypatch.setattr(uploads, "UPLOAD_SPOOL_BYTES", 4096)
+    monkeypatch.setattr(uploads, "FORM_MAX_FIELDS", 2)
+    monkeypatch.setattr(Request, "form", Request.form)
+    monkeypatch.setattr(formparsers.MultiPartParser, "spool_max_size", formparsers.MultiPartParser.spool_max_size)
+    uploads.apply_upload_limits()
+    assert formparsers.MultiPartParser.spool_max_size == 4096
+
+    res = client.post(
+        "/v1/audio/transcriptions",
+        data={"model": "whisper-1", "language": "kannada", "prompt": "x"},
+        files={"file": ("a.wav", io.BytesIO(b"audio"), "audio/wav")},
+    )
+    assert res.status_code == 400
+
+
+def _upload(data, content_type="audio/wav"):
+    return UploadFile(io.BytesIO(data), size=len(data), filename="a.wav", headers=Headers({"content-type": content_type}))
+
+
+def _refusal(data, content_type="audio/wav"):
+    try:
+        asyncio.run(check_audio_upload(_upload(data, content_type)))
+    except RequestError as exc:
+        assert exc.status_code == 415 and exc.error_code == "unsupported_audio"
+        return exc.detail
+    return None
+
+
+def test_uploads_that_are_plainly_not_audio_are_refused():
+    pe = b"MZ" + bytes(58) + (64).to_bytes(4, "little") + b"PE\0\0"
+    assert "Windows executable" in _refusal(pe)
+    assert "ELF" in _refusal(b"\x7fELF\x02\x01")
+    assert "zip" in _refusal(b"PK\x03\x04rest")
+    assert "HTML" in _refusal(b"<!DOCTYPE html><html>")
+    assert "not audio" in _refusal(b"RIFF\0\0\0\0WAVE", "application/x-msdownload")
+    assert "not a media type" in _refusal(b"RIFF\0\0\0\0WAVE", "audio")
+    assert _refusal(b"RIFF\0\0\0\0WAVEfmt ") is None
+    assert _refusal(b"\x1aE\xdf\xa3webm", "video/webm;codecs=opus") is None
+    assert _refusal(b"") is None
+
+
+def test_formats_are_allowlisted_and_strict_mode_wants_a_known_one(monkeypatch):
+    monkeypatch.setattr(uploads, "UPLOAD_AUDIO_FORMATS", ["wav", "mp3"])
+    assert "flac" in _refusal(b"fLaC\0\0\0\x22")
+    assert _refusal(b"\x01\x00\x02\x00raw pcm") is None
+    monkeypatch.setattr(uploads, "UPLOAD_STRICT", True)
+    assert "Unrecognized" in _refusal(b"\x01\x00\x02\x00raw pcm")
+    assert _refusal(b"ID3\x04\0\0") is None
+
+
+def test_executables_never_reach_asr(client):
+    res = client.post("/v1/audio/transcriptions", files={"file": ("a.wav", io.BytesIO(b"\x7fELF\x02\x01\x01"), "audio/wav")})
+    assert res.status_code == 415
+    assert res.json()["error"]["error_code"] == "unsupported_audio"
+
+
+def test_strict_forms_refuse_fields_the_endpoint_does_not_take(client, monkeypatch):
+    monkeypatch.setattr(uploads, "FORM_STRICT_FIELDS", True)
+    monkeypatch.setattr(uploads, "FORM_EXTRA_FIELDS", {"client_version"})
+    res = client.post(
+        "/v1/audio/transcriptions",
+        data={"model": "whisper-1", "client_version": "3", "payload": "x"},
+        files={"file": ("a.wav", io.BytesIO(b"\x7fELF"), "audio/wav")},
+    )
+    assert res.status_code == 400
+    assert "'payload'" in res.json()["error"]["message"] and "client_version" not in res.json()["error"]["message"]
@@ -0,0 +1,78 @@
+import asyncio
+import csv
+import io
+
+import pytest
+
+from ratelimit import api_key_bucket
+from services import http_client as http_client_svc
+from services import mock, usage
+from services.usage import MemoryUsageStore, SQLiteUsageStore, record_usage, track_usage
+
+
+@pytest.fixture
+def store(monkeypatch):
+    store = MemoryUsageStore()
+    monkeypatch.setattr(usage, "_STORE", store)
+    return store
+
+
+@pytest.mark.parametrize("make_store", [MemoryUsageStore, lambda: SQLiteUsageStore(":memory:")])
+def test_stores_add_up_daily_rows(make_store):
+    store = make_store()
+    store.add("team-a", "2026-03-02", {"requests": 1, "tts_chars": 10})
+    store.add("team-a", "2026-03-02", {"requests": 1, "tts_chars": 5, "audio_seconds": 1.25})
+    store.add("team-b", "2026-03-01", {"requests": 1, "llm_prompt_tokens": 30})
+    rows = store.rows()
+    assert [(row["day"], row["account"]) for row in rows] == [("2026-03-01", "team-b"), ("2026-03-02", "team-a")]
+    assert rows[1] == {
+        "day": "2026-03-02", "account": "team-a", "requests": 2, "audio_seconds": 1.25,
+        "llm_prompt_tokens": 0, "llm_completion_tokens": 0, "tts_chars": 15,
+    }
+    assert [row["account"] for row in store.rows("team-b")] == ["team-b"]
+    assert store.rows(since="2026-03-02", until="2026-03-02") == rows[1:]
+
+
+def test_usage_is_only_recorded_inside_a_tracked_request():
+    asyncio.run(asyncio.to_thread(record_usage, tts_chars=10))  # a context with nothing tracked: ignored
+    counts = track_usage()
+    record_usage(tts_chars=3, audio_seconds=0)
+    record_usage(tts_chars=2)
+    assert counts == {"tts_chars": 5}
+
+
+def test_turns_are_charged_to_the_api_key(client, monkeypatch, store):
+    monkeypatch.setenv("DWANI_API_KEY", "team-a-key")
+    monkeypatch.setenv("DWANI_ADMIN_TOKEN", "admin-token")
+    monkeypatch.setattr(mock, "_enabled", True)
+    for name in ("DWANI_API_BASE_URL_LLM", "DWANI_API_BASE_URL_TTS"):
+        monkeypatch.delenv(name, raising=False)
+    asyncio.run(http_client_svc.close_http_client())
+    headers = {"X-API-Key": "team-a-key"}
+    try:
+        res = client.post("/v1/speech_to_speech", params={"format": "json"}, data={"text": "hello there"}, headers=headers)
+        assert res.status_code == 200
+        reply, turn_usage = res.json()["llm_response"], res.json()["usage"]
+        assert (turn_usage["tts_chars"], turn_usage["llm_completion_tokens"], turn_usage["audio_seconds"]) == (
+            len(reply), len(reply.split()), 0
+        )
+        assert {"llm_ms", "total_ms"} <= set(res.json()["timings"])
+    finally:
+        asyncio.run(http_client_svc.close_http_client())
+
+    res = client.get("/v1/usage", headers=headers)
+    assert res.status_code == 200
+    body = res.json()
+    assert body["account"] == api_key_bucket("team-a-key")
+    assert body["totals"]["requests"] == 1
+    assert body["totals"]["tts_chars"] == len(reply)
+    assert body["totals"]["llm_completion_tokens"] == len(reply.split())
+
+    res = client.get("/v1/usage", params={"format": "csv"}, headers=headers)
+    assert res.headers["content-type"].startswith("text/csv")
+    assert list(csv.DictReader(io.StringIO(res.text)))[0]["tts_chars"] == str(len(reply))
+
+    assert client.get("/admin/usage.csv").status_code == 401
+    res = client.get("/admin/usage.csv", headers={"X-Admin-Token": "admin-token"})
+    assert [row["account"] for row in csv.DictReader(io.StringIO(res.text))] == [api_key_bucket("team-a-key")]
+    assert client.get("/v1/usage", params={"since": "yesterday"}, headers=headers).status_code == 400
@@ -0,0 +1,58 @@
+"""Tests for backend warm-up and keepalive probes (services/warmup.py)."""
+import asyncio
+
+from routers import health as health_router
+from services import warmup
+from services.errors import StageError
+
+
+def _probes(monkeypatch, failures):
+    sent = []
+
+    def probe(stage):
+        async def run():
+            sent.append(stage)
+            if failures.get(stage):
+                raise failures[stage]
+
+        return (lambda: f"http://{stage}", run)
+
+    monkeypatch.setattr(warmup, "PROBES", {stage: probe(stage) for stage in ("asr", "llm", "tts")})
+    return sent
+
+
+def test_stages_that_answer_are_warm_even_with_an_error(monkeypatch):
+    failures = {
+        "asr": StageError("asr", status_code=422, detail="No words were recognized in the audio"),
+        "tts": StageError("tts", detail="TTS service unavailable", error_code="tts_unavailable"),
+    }
+    sent = _probes(monkeypatch, failures)
+    warmer = warmup.Warmer(enabled=True)
+    warmer.pending = {"asr", "llm", "tts"}
+    assert asyncio.run(warmer.warm_up()) is False
+    assert warmer.pending == {"tts"}
+    failures.pop("tts")
+    assert asyncio.run(warmer.warm_up()) is True
+    assert sent == ["asr", "llm", "tts", "tts"]
+
+
+def test_readiness_waits_for_the_warm_up(client, monkeypatch):
+    _probes(monkeypatch, {})
+    warmer = warmup.Warmer(enabled=True, keepalive_seconds=0)
+    monkeypatch.setattr(health_router, "warmer", warmer)
+    warmer.pending = {"llm"}
+    res = client.get("/ready")
+    assert res.status_code == 503 and res.json() == {"status": "warming_up", "pending": ["llm"]}
+    assert client.get("/startup").status_code == 503
+
+    async def start_and_finish():
+        await warmer.start()
+        await asyncio.wait_for(warmer._task, 1)
+
+    asyncio.run(start_and_finish())
+    assert warmer.warm and warmer.pending == set()
+    assert client.get("/ready").json()["status"] != "warming_up"
+
+
+def test_warm_up_is_off_by_default():
+    assert warmup.Warmer(enabled=False).warm
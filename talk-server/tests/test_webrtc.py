@@ -0,0 +1,113 @@
+import asyncio
+import base64
+import struct
+
+from services import webrtc
+from services.telephony import wav_bytes
+
+
+class FakeStream:
+    """Stands in for ConversationStream: records what it is asked to do, events are sent by the test."""
+
+    def __init__(self, send, preset, session_id, **kwargs):
+        self.send = send
+        self.kwargs = kwargs
+        self.cancelled = 0
+        self.texts = []
+
+    def cancel(self):
+        self.cancelled += 1
+        return True
+
+    async def text(self, text):
+        self.texts.append(text)
+
+    async def close(self):
+        pass
+
+
+class FakeChannel:
+    readyState = "open"
+
+    def __init__(self):
+        self.sent = []
+
+    def send(self, message):
+        self.sent.append(message)
+
+
+def _call(monkeypatch):
+    monkeypatch.setattr(webrtc, "ConversationStream", FakeStream)
+    monkeypatch.setattr(webrtc, "BARGE_IN", True)
+    call = webrtc.WebRTCCall(None, "session-1", language="kannada")
+    call.channel = FakeChannel()
+    return call
+
+
+def _chunk(turn, audio):
+    return {"type": "audio_chunk", "turn": turn, "audio_base64": base64.b64encode(audio).decode()}
+
+
+def test_streamed_wav_is_played_as_it_arrives():
+    reply = webrtc.ReplyPCM()
+    stereo = wav_bytes(b"", 32000)[:22] + struct.pack("<H", 2) + wav_bytes(b"", 32000)[24:]
+    samples = struct.pack("<16h", *([100, 300] * 8))  # 8 stereo frames at 32 kHz
+    assert reply.feed(stereo[:20]) == b""
+    assert reply.feed(stereo[20:] + samples[:12]) == struct.pack("<h", 200)
+    assert reply.feed(samples[12:]) == struct.pack("<3h", 200, 200, 200)
+
+
+def test_replies_other_than_wav_are_converted_at_the_end(monkeypatch):
+    async def fake_transcode(audio, target, sample_rate=None):
+        assert (audio, target, sample_rate) == (b"OggS-reply", "wav", 16000)
+        return wav_bytes(b"\1\0\2\0", 16000)
+
+    monkeypatch.setattr(webrtc, "transcode", fake_transcode)
+    reply = webrtc.ReplyPCM()
+    assert reply.feed(b"OggS-") == reply.feed(b"reply") == b""
+    assert asyncio.run(reply.finish()) == b"\1\0\2\0"
+
+
+def test_the_return_track_gets_20ms_frames_padded_with_silence():
+    audio = webrtc.ReplyAudio()
+    audio.play(b"\1" * (webrtc.FRAME_BYTES + 2))
+    assert audio.next_frame() == b"\1" * webrtc.FRAME_BYTES
+    assert audio.next_frame() == b"\1\1" + b"\0" * (webrtc.FRAME_BYTES - 2)
+    assert not audio.playing and audio.next_frame() == b"\0" * webrtc.FRAME_BYTES
+
+
+def test_replies_play_on_the_track_and_events_go_to_the_data_channel(monkeypatch):
+    call = _call(monkeypatch)
+    pcm = b"\5\0" * 400
+
+    async def scenario():
+        await call.stream.send({"type": "turn_start", "turn": 0, "input": "audio"})
+        await call.stream.send(_chunk(0, wav_bytes(pcm, 16000)))
+        await call.stream.send({"type": "turn_end", "turn": 0, "status": "completed"})
+        await call.control('{"type": "text", "text": "hello"}')
+        await call.control("nonsense")
+
+    asyncio.run(scenario())
+    assert call.audio.next_frame() == pcm[:webrtc.FRAME_BYTES]
+    assert call.stream.kwargs["sample_rate"] == 16000 and call.stream.kwargs["audio_format"] == "wav"
+    assert call.stream.texts == ["hello"]
+    kinds = [message.split('"type": "')[1].split('"')[0] for message in call.channel.sent]
+    assert kinds == ["turn_start", "turn_end", "error"]
+
+
+def test_speaking_over_a_reply_cuts_it_off(monkeypatch):
+    call = _call(monkeypatch)
+
+    async def scenario():
+        await call.stream.send({"type": "turn_start", "turn": 0, "input": "audio"})
+        await call.stream.send(_chunk(0, wav_bytes(b"\5\0" * 4000, 16000)))
+        await call.stream.send({"type": "turn_start", "turn": 1, "input": "audio"})
+
+    asyncio.run(scenario())
+    assert call.stream.cancelled == 1 and not call.audio.playing
+
+
+def test_offers_need_aiortc(client, monkeypatch):
+    monkeypatch.setattr(webrtc, "aiortc", None)
+    res = client.post("/v1/webrtc/offer", json={"sdp": "v=0", "type": "offer"})
+    assert res.status_code == 501
@@ -0,0 +1,71 @@
+"""Indic speech fixtures for the end-to-end tests (tests/test_e2e.py), listed in manifest.json.
+
+Each sample is a short 16 kHz mono WAV recording with its language, and a golden
+transcript once one has been checked by a native speaker (null until then: the test
+only checks that the transcript is in the language's script). Only recordings whose
+license allows redistribution belong here; the manifest names each one's license and source.
+"""
+import json
+import os
+import unicodedata
+from dataclasses import dataclass
+from typing import List, Optional
+
+DIRECTORY = os.path.dirname(os.path.abspath(__file__))
+
+# The languages a release is validated in: each needs a recording with a checked transcript.
+RELEASE_LANGUAGES = ("kannada", "hindi", "tamil")
+# Unicode script name of each language's letters, as in unicodedata.name() ("KANNADA LETTER KA").
+SCRIPTS = {"kannada": "KANNADA", "hindi": "DEVANAGARI", "marathi": "DEVANAGARI", "tamil": "TAMIL", "telugu": "TELUGU"}
+
+
+@dataclass(frozen=True)
+class Sample:
+    file: str
+    language: str
+    transcript: Optional[str] = None
+    max_cer: float = 0.3  # highest character error rate against transcript that passes
+    license: str = ""
+    source: str = ""
+
+    @property
+    def path(self) -> str:
+        """file is relative to this directory, so a recording kept elsewhere in the repository is not copied."""
+        return os.path.normpath(os.path.join(DIRECTORY, self.file))
+
+    @property
+    def name(self) -> str:
+        return os.path.basename(self.file)
+
+    def read(self) -> bytes:
+        with open(self.path, "rb") as f:
+            return f.read()
+
+
+def load_samples(path: str = os.path.join(DIRECTORY, "manifest.json")) -> List[Sample]:
+    with open(path, encoding="utf-8") as f:
+        return [Sample(**spec) for spec in json.load(f)["samples"]]
+
+
+def character_error_rate(reference: str, hypothesis: str) -> float:
+    """Edit distance between the transcripts' characters (spaces and case ignored) over the reference length."""
+    ref = [c for c in unicodedata.normalize("NFC", reference.lower()) if not c.isspace()]
+    hyp = [c for c in unicodedata.normalize("NFC", hypothesis.lower()) if not c.isspace()]
+    if not ref:
+        return 0.0 if not hyp else 1.0
+    previous = list(range(len(hyp) + 1))
+    for i, r in enumerate(ref, 1):
+        current = [i]
+        for j, h in enumerate(hyp, 1):
+            current.append(min(previous[j] + 1, current[j - 1] + 1, previous[j - 1] + (r != h)))
+        previous = current
+    return previous[-1] / len(ref)
+
+
+def script_share(text: str, language: str) -> float:
+    """Share of text's letters written in language's script (1.0 when the language has none listed)."""
+    script = SCRIPTS.get(language)
+    letters = [c for c in text if c.isalpha()]
+    if script is None or not letters:
+        return 1.0 if script is None else 0.0
+    return sum(unicodedata.name(c, "").startswith(script) for c in letters) / len(letters)